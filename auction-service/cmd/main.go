@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ireuven89/auctions/auction-service/internal/repository"
 	"github.com/ireuven89/auctions/auction-service/internal/service"
+	"github.com/ireuven89/auctions/auction-service/internal/webhook"
 
 	"github.com/ireuven89/auctions/auction-service/db"
 	"github.com/ireuven89/auctions/auction-service/internal"
 	"github.com/ireuven89/auctions/shared/config"
+	"github.com/ireuven89/auctions/shared/events"
+	http2 "github.com/ireuven89/auctions/shared/http"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/ireuven89/auctions/shared/messaging/outbox"
+	"github.com/ireuven89/auctions/shared/sqltx"
 	"github.com/julienschmidt/httprouter"
 	"go.uber.org/zap"
 )
@@ -35,8 +42,48 @@ func main() {
 
 	repo := repository.NewAuctionRepo(dbConn, logger)
 	itemRepo := repository.NewItemRepo(dbConn, logger)
-	service := service.NewService(repo, itemRepo, logger)
+	bidRepo := repository.NewBidRepo(dbConn, logger)
+	outboxRepo := repository.NewOutboxRepo(dbConn, logger)
+	historyRepo := repository.NewHistoryRepo(dbConn, logger)
+	txManager := sqltx.NewManager(dbConn)
+	webhookRepo := repository.NewWebhookRepo(dbConn, logger)
+	webhookService := service.NewWebhookService(webhookRepo, repo, logger)
+	service := service.NewService(repo, itemRepo, bidRepo, txManager, outboxRepo, historyRepo, logger)
+
+	// deliveries already enqueued go out even while the broker is down
+	go webhook.NewDispatcher(webhookRepo, logger).Run(context.Background())
+
+	// without a broker the events wait in the outbox until one is configured
+	if cfg.Rabbit.URL != "" {
+		broker, err := messaging.DialAMQP(cfg.Rabbit)
+
+		if err != nil {
+			panic(fmt.Errorf("failed connecting broker %v", err))
+		}
+		defer broker.Close()
+
+		go outbox.NewRelay(outbox.NewSQLStore(dbConn), broker).Run(context.Background())
+
+		// a webhook gets an event once however many times it arrives, no processed store is needed
+		consumer := messaging.NewConsumer(broker, nil)
+		consumer.Handle(internal.WebhookEventsSubscription, messaging.DefaultRetryPolicy, events.Validating(internal.NewWebhookEventsHandler(webhookService)))
+
+		go func() {
+			err := consumer.Run(context.Background())
+			logger.Error("consumer stopped", zap.Error(err))
+		}()
+	} else {
+		logger.Warn("rabbit.url is not set, auction events are kept in the outbox and not delivered to webhooks")
+	}
 	transport := internal.NewTransport(service, router)
+	internal.RegisterWebhookRoutes(router, webhookService)
+
+	var apiKeys http2.APIKeyResolver
+
+	if cfg.Auth.URL != "" {
+		apiKeys = http2.NewCachedAPIKeyResolver(http2.NewRemoteAPIKeyResolver(cfg.Auth.URL, cfg.Auth.ClientID, cfg.Auth.ClientSecret), cfg.Auth.APIKeyCacheTTL)
+	}
 
-	transport.ListenAndServe(cfg.Server.Port, publicKey)
+	// impersonated requests reach the audit log of auth-service through the outbox
+	transport.ListenAndServe(cfg.Server.Port, publicKey, apiKeys, events.NewOutboxImpersonationRecorder(dbConn, "auction-service"))
 }
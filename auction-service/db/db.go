@@ -45,7 +45,8 @@ func MustNewDB(host, user, password string, port int) (*sql.DB, error) {
 			return nil, err
 		}
 
-		db, err = sql.Open("mysql", dsn+databaseName)
+		// timestamps are scanned into time.Time
+		db, err = sql.Open("mysql", dsn+databaseName+"?parseTime=true")
 		if err != nil {
 			fmt.Printf("failed connecting db %v with databse name attempt %d", err, attempt)
 			attempt++
@@ -78,7 +79,6 @@ func migrate(db *sql.DB) error {
 	}
 
 	backoff := retry.WithMaxRetries(3, retry.NewConstant(1*time.Second))
-	attemtps := 1
 	err := retry.Do(ctx, backoff, func(ctx context.Context) error {
 		err := gooseUp(db, migrationPath)
 
@@ -91,7 +91,6 @@ func migrate(db *sql.DB) error {
 	})
 
 	if err != nil {
-		fmt.Errorf("migrate failed %v retry number %d", err, attemtps)
 		return fmt.Errorf("migrate %w", err)
 	}
 
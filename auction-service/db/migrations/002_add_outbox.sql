@@ -0,0 +1,20 @@
+-- +goose Up
+
+-- events are written here in the transaction of the change they describe, the relay publishes them in seq order
+create table if not exists outbox
+(
+    seq          bigint unsigned auto_increment primary key,
+    id           varchar(64)      not null,
+    aggregate_id varchar(36)      not null,
+    topic        varchar(255)     not null,
+    type         varchar(255)     not null,
+    envelope     json             not null,
+    attempts     integer unsigned not null default 0,
+    last_error   text,
+    created_at   timestamp(6)     not null default current_timestamp(6),
+    sent_at      timestamp(6)     null,
+    unique key unique_outbox_id (id),
+    index idx_outbox_pending (sent_at, seq),
+    index idx_outbox_aggregate (aggregate_id, seq)
+);
+
@@ -0,0 +1,28 @@
+-- +goose Up
+
+-- every change of every auction, appended in the transaction of the change and never updated or deleted.
+-- The state of an auction at any time is replayed from it
+create table if not exists auction_history
+(
+    seq          bigint unsigned auto_increment primary key,
+    auction_id   varchar(36)      not null,
+    version      integer unsigned not null,
+    type         varchar(32)      not null,
+    changes      json             not null,
+    bid          json             null,
+    actor        varchar(255)     not null default '',
+    impersonator varchar(255)     not null default '',
+    occurred_at  timestamp(6)     not null,
+    unique key uq_auction_history_version (auction_id, version)
+);
+
+-- the auctions created before get a Created entry with the state they have now at the time they were created,
+-- what changed on them before is unknown
+insert into auction_history (auction_id, version, type, changes, occurred_at)
+select a.id, 1, 'Created',
+       json_object('description', a.description, 'sellerId', a.seller_id, 'regions', a.regions,
+                   'initialOffer', a.initial_offer, 'currentBid', a.current_bid, 'status', a.status,
+                   'winnerId', a.winner_id),
+       coalesce(a.created_at, current_timestamp(6))
+from auctions a
+where not exists (select 1 from auction_history h where h.auction_id = a.id);
@@ -0,0 +1,39 @@
+-- +goose Up
+
+create table if not exists webhooks
+(
+    id          varchar(36)      not null primary key,
+    seller_id   varchar(36)      not null,
+    url         varchar(2048)    not null,
+    secret      varchar(128)     not null,
+    event_types json             not null,
+    active      boolean          not null default true,
+    failures    integer unsigned not null default 0,
+    disabled_at timestamp(6)     null,
+    created_at  timestamp(6)     not null,
+    updated_at  timestamp(6)     not null,
+    index idx_webhooks_seller (seller_id)
+);
+
+-- the delivery log. An event is delivered once per webhook, dedupe is its id and null for replays
+create table if not exists webhook_deliveries
+(
+    id               varchar(36)      not null primary key,
+    webhook_id       varchar(36)      not null,
+    event_id         varchar(64)      not null,
+    event_type       varchar(255)     not null,
+    payload          json             not null,
+    status           varchar(16)      not null default 'pending',
+    attempts         integer unsigned not null default 0,
+    next_attempt_at  timestamp(6)     not null,
+    last_status_code integer          not null default 0,
+    last_error       text             null,
+    replay_of        varchar(36)      null,
+    dedupe           varchar(64)      null,
+    created_at       timestamp(6)     not null,
+    delivered_at     timestamp(6)     null,
+    unique key uq_webhook_deliveries_dedupe (webhook_id, dedupe),
+    index idx_webhook_deliveries_due (status, next_attempt_at),
+    index idx_webhook_deliveries_log (webhook_id, created_at),
+    constraint fk_webhook_deliveries_webhook foreign key (webhook_id) references webhooks (id) on delete cascade
+);
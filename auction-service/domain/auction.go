@@ -7,15 +7,19 @@ import (
 )
 
 type Auction struct {
-	ID           string
-	Description  string
+	ID          string
+	Description string
+	// SellerID is the organization selling, every authorized member of it manages the auction
+	SellerID     string
 	Regions      []byte
 	InitialOffer float64
 	CurrentBid   float64
 	MinIncrement float64
 	Status       AuctionStatus
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	// WinnerID is the bidder of the highest bid once the auction completed
+	WinnerID  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
 type AuctionRequest struct {
@@ -38,6 +42,7 @@ var (
 	ErrTooManyRequests = errors.New("too many requests")
 	ErrUnAuthorized    = errors.New("unauthorized")
 	ErrBadRequest      = errors.New("bad request")
+	ErrForbidden       = errors.New("forbidden")
 )
 
 type AuctionStatus int
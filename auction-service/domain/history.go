@@ -0,0 +1,131 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// the kinds of changes the history of an auction records
+const (
+	HistoryCreated       = "Created"
+	HistoryEdited        = "Edited"
+	HistoryStatusChanged = "StatusChanged"
+	HistoryBidPlaced     = "BidPlaced"
+	HistoryClosed        = "Closed"
+	HistoryCancelled     = "Cancelled"
+	HistoryDeleted       = "Deleted"
+)
+
+// AuctionChanges are the fields a change set, nil fields kept their value
+type AuctionChanges struct {
+	Description  *string         `json:"description,omitempty"`
+	SellerID     *string         `json:"sellerId,omitempty"`
+	Regions      json.RawMessage `json:"regions,omitempty"`
+	InitialOffer *float64        `json:"initialOffer,omitempty"`
+	MinIncrement *float64        `json:"minIncrement,omitempty"`
+	Status       *string         `json:"status,omitempty"`
+	CurrentBid   *float64        `json:"currentBid,omitempty"`
+	WinnerID     *string         `json:"winnerId,omitempty"`
+}
+
+// HistoryBid is the bid placed by a BidPlaced change
+type HistoryBid struct {
+	ID       string  `json:"id"`
+	BidderID string  `json:"bidderId"`
+	Amount   float64 `json:"amount"`
+}
+
+// HistoryEntry is a change of an auction. The history is only ever appended to, Version numbers the
+// changes of the auction from 1
+type HistoryEntry struct {
+	AuctionID string
+	Version   int
+	Type      string
+	Changes   AuctionChanges
+	Bid       *HistoryBid
+	// Actor is who made the change, empty for changes made by other services
+	Actor string
+	// Impersonator is the admin who made the change acting as the actor
+	Impersonator string
+	OccurredAt   time.Time
+}
+
+// AuctionState is an auction as its history tells it at a point in time
+type AuctionState struct {
+	ID           string
+	Description  string
+	SellerID     string
+	Regions      json.RawMessage
+	InitialOffer float64
+	MinIncrement float64
+	CurrentBid   float64
+	Status       string
+	WinnerID     string
+	// Deleted is set from the change that deleted the auction on
+	Deleted bool
+	// Version is the last change applied
+	Version   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// AuctionHistory is the history of an auction up to a point in time and its state then
+type AuctionHistory struct {
+	Entries []HistoryEntry
+	State   AuctionState
+}
+
+// ReplayHistory folds the entries that occurred up to asOf into the state of the auction then, in version
+// order. It tells false when the auction did not exist yet
+func ReplayHistory(entries []HistoryEntry, asOf time.Time) (AuctionState, bool) {
+	var state AuctionState
+
+	for _, entry := range entries {
+		if entry.OccurredAt.After(asOf) {
+			break
+		}
+
+		state.apply(entry)
+	}
+
+	return state, state.Version > 0
+}
+
+func (s *AuctionState) apply(entry HistoryEntry) {
+	if entry.Type == HistoryCreated {
+		s.ID = entry.AuctionID
+		s.CreatedAt = entry.OccurredAt
+	}
+	if entry.Type == HistoryDeleted {
+		s.Deleted = true
+	}
+
+	c := entry.Changes
+	if c.Description != nil {
+		s.Description = *c.Description
+	}
+	if c.SellerID != nil {
+		s.SellerID = *c.SellerID
+	}
+	if c.Regions != nil {
+		s.Regions = c.Regions
+	}
+	if c.InitialOffer != nil {
+		s.InitialOffer = *c.InitialOffer
+	}
+	if c.MinIncrement != nil {
+		s.MinIncrement = *c.MinIncrement
+	}
+	if c.Status != nil {
+		s.Status = *c.Status
+	}
+	if c.CurrentBid != nil {
+		s.CurrentBid = *c.CurrentBid
+	}
+	if c.WinnerID != nil {
+		s.WinnerID = *c.WinnerID
+	}
+
+	s.Version = entry.Version
+	s.UpdatedAt = entry.OccurredAt
+}
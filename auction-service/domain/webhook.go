@@ -0,0 +1,81 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// the status of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint of a seller the events of its auctions are posted to
+type Webhook struct {
+	ID       string
+	SellerID string
+	URL      string
+	// Secret signs the deliveries, it is only shown when the webhook is registered
+	Secret string
+	// EventTypes are the events delivered, all of them when empty
+	EventTypes []string
+	Active     bool
+	// Failures counts the deliveries in a row that failed every attempt, the webhook is disabled past a limit
+	Failures   int
+	DisabledAt *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Subscribed tells whether events of the type are delivered to the webhook
+func (w Webhook) Subscribed(eventType string) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+type WebhookRequest struct {
+	ID         string   `json:"-"`
+	SellerID   string   `json:"sellerId"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"eventTypes"`
+	// Active enables a webhook again after it was disabled, or disables it
+	Active *bool `json:"active"`
+}
+
+// WebhookDelivery is an event posted, or to be posted, to a webhook. The deliveries are the log sellers
+// query and replay events from
+type WebhookDelivery struct {
+	ID        string
+	WebhookID string
+	EventID   string
+	EventType string
+	// Payload is the body posted, the envelope of the event
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastStatusCode int
+	LastError      string
+	// ReplayOf is the delivery this one replays
+	ReplayOf    string
+	CreatedAt   time.Time
+	DeliveredAt *time.Time
+}
+
+type DeliveryFilter struct {
+	WebhookID string
+	// Status filters by status when set
+	Status string
+	Limit  int
+}
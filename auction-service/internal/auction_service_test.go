@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"testing"
 	"time"
 
@@ -10,7 +11,11 @@ import (
 	"github.com/ireuven89/auctions/auction-service/internal/mocks"
 	"github.com/ireuven89/auctions/auction-service/internal/service"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/ireuven89/auctions/shared/events"
+	http2 "github.com/ireuven89/auctions/shared/http"
+	"github.com/ireuven89/auctions/shared/messaging"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.uber.org/zap"
@@ -55,7 +60,7 @@ func TestCreateAuction(t *testing.T) {
 	mockRepo := new(MockRepository)
 	itemMockRepo := new(mocks.ItemRepositoryMock)
 	logger := zap.NewNop()
-	svc := service.NewService(mockRepo, itemMockRepo, logger)
+	svc := service.NewService(mockRepo, itemMockRepo, nil, mocks.MockTransactor{}, new(mocks.MockOutbox), new(mocks.MockHistory), logger)
 
 	req := domain.AuctionRequest{Description: "Test Auction", MinIncrement: 1.0, InitialOffer: 1.0}
 
@@ -75,7 +80,7 @@ func TestFetchAuction_NotFound(t *testing.T) {
 	itemMockRepo := new(mocks.ItemRepositoryMock)
 
 	logger := zap.NewNop()
-	svc := service.NewService(mockRepo, itemMockRepo, logger)
+	svc := service.NewService(mockRepo, itemMockRepo, nil, mocks.MockTransactor{}, new(mocks.MockOutbox), new(mocks.MockHistory), logger)
 
 	mockRepo.On("Find", mock.Anything, "not_found").Return(domain.Auction{}, sql.ErrNoRows)
 
@@ -91,7 +96,7 @@ func TestUpdateAuction(t *testing.T) {
 	itemMockRepo := new(mocks.ItemRepositoryMock)
 
 	logger := zap.NewNop()
-	svc := service.NewService(mockRepo, itemMockRepo, logger)
+	svc := service.NewService(mockRepo, itemMockRepo, nil, mocks.MockTransactor{}, new(mocks.MockOutbox), new(mocks.MockHistory), logger)
 
 	req := domain.AuctionRequest{ID: uuid.New().String(), Description: "Updated Auction", CreatedAt: time.Time{}, UpdatedAt: time.Time{}}
 	mockRepo.On("Update", context.Background(), mock.AnythingOfType("domain.AuctionRequest")).Return(nil)
@@ -105,15 +110,367 @@ func TestUpdateAuction(t *testing.T) {
 func TestDeleteAuction(t *testing.T) {
 	mockRepo := new(MockRepository)
 	itemMockRepo := new(mocks.ItemRepositoryMock)
+	outbox := new(mocks.MockOutbox)
+	history := new(mocks.MockHistory)
 
 	logger := zap.NewNop()
-	svc := service.NewService(mockRepo, itemMockRepo, logger)
+	svc := service.NewService(mockRepo, itemMockRepo, nil, mocks.MockTransactor{}, outbox, history, logger)
 
 	id := uuid.New().String()
+	mockRepo.On("Find", mock.Anything, id).Return(domain.Auction{ID: id, SellerID: "org-id"}, nil)
 	mockRepo.On("Delete", mock.Anything, id).Return(nil)
 
 	err := svc.Delete(context.Background(), id)
 
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
+
+	// the deletion is in the history and told to the consumers with the seller, the auction is gone
+	assert.Len(t, history.Entries, 1)
+	assert.Equal(t, domain.HistoryDeleted, history.Entries[0].Type)
+	assert.Len(t, outbox.Events, 1)
+	assert.Equal(t, events.TypeAuctionDeleted, outbox.Events[0].Envelope.Type)
+	deleted, err := messaging.Payload[events.AuctionDeleted](outbox.Events[0].Envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, "org-id", deleted.SellerID)
+}
+
+func TestDeleteAuction_NotFound(t *testing.T) {
+	mockRepo := new(MockRepository)
+	outbox := new(mocks.MockOutbox)
+	svc := service.NewService(mockRepo, new(mocks.ItemRepositoryMock), nil, mocks.MockTransactor{}, outbox, new(mocks.MockHistory), zap.NewNop())
+
+	mockRepo.On("Find", mock.Anything, "not_found").Return(domain.Auction{}, sql.ErrNoRows)
+
+	err := svc.Delete(context.Background(), "not_found")
+
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+	mockRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+	assert.Empty(t, outbox.Events)
+}
+
+func orgContext(orgID, role string) context.Context {
+	return http2.ContextWithClaims(context.Background(), jwt.MapClaims{"sub": "user-id", http2.OrgClaim: orgID, http2.OrgRoleClaim: role})
+}
+
+func TestCreateAuction_ActiveOrganization(t *testing.T) {
+	mockRepo := new(MockRepository)
+	svc := service.NewService(mockRepo, new(mocks.ItemRepositoryMock), nil, mocks.MockTransactor{}, new(mocks.MockOutbox), new(mocks.MockHistory), zap.NewNop())
+
+	mockRepo.On("Create", mock.Anything, mock.MatchedBy(func(req domain.AuctionRequest) bool {
+		return req.SellerId == "org-id"
+	})).Return(nil)
+
+	req := domain.AuctionRequest{Description: "Test Auction", MinIncrement: 1.0, InitialOffer: 1.0}
+	_, err := svc.Create(orgContext("org-id", http2.OrgRoleManager), req)
+	assert.NoError(t, err)
+
+	// viewers can't list, and nobody lists for another seller
+	_, err = svc.Create(orgContext("org-id", http2.OrgRoleViewer), req)
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+
+	req.SellerId = "other-org"
+	_, err = svc.Create(orgContext("org-id", http2.OrgRoleOwner), req)
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+
+	mockRepo.AssertNumberOfCalls(t, "Create", 1)
+}
+
+func TestUpdateAuction_OtherOrganization(t *testing.T) {
+	mockRepo := new(MockRepository)
+	svc := service.NewService(mockRepo, new(mocks.ItemRepositoryMock), nil, mocks.MockTransactor{}, new(mocks.MockOutbox), new(mocks.MockHistory), zap.NewNop())
+
+	mockRepo.On("Find", mock.Anything, "auction-id").Return(domain.Auction{ID: "auction-id", SellerID: "org-id"}, nil)
+	mockRepo.On("Update", mock.Anything, mock.AnythingOfType("domain.AuctionRequest")).Return(nil)
+	mockRepo.On("Delete", mock.Anything, "auction-id").Return(nil)
+	req := domain.AuctionRequest{ID: "auction-id", Description: "Updated Auction"}
+
+	err := svc.Update(orgContext("other-org", http2.OrgRoleOwner), req)
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+	err = svc.Delete(orgContext("other-org", http2.OrgRoleOwner), "auction-id")
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+	mockRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+
+	// any manager of the seller may change it
+	assert.NoError(t, svc.Update(orgContext("org-id", http2.OrgRoleManager), req))
+	assert.NoError(t, svc.Delete(orgContext("org-id", http2.OrgRoleOwner), "auction-id"))
+}
+
+type txKey struct{}
+
+// markingTransactor marks the context of the transaction so the calls made within it can be told apart
+type markingTransactor struct{}
+
+func (markingTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(context.WithValue(ctx, txKey{}, true))
+}
+
+func inTx(ctx context.Context) bool {
+	return ctx.Value(txKey{}) != nil
+}
+
+func TestUpdateAuction_AuthorizesWithinTransaction(t *testing.T) {
+	mockRepo := new(MockRepository)
+	svc := service.NewService(mockRepo, new(mocks.ItemRepositoryMock), nil, markingTransactor{}, new(mocks.MockOutbox), new(mocks.MockHistory), zap.NewNop())
+
+	// the seller is only looked up within the transaction, where the repository locks the auction
+	mockRepo.On("Find", mock.MatchedBy(inTx), "auction-id").Return(domain.Auction{ID: "auction-id", SellerID: "org-id"}, nil)
+	mockRepo.On("Update", mock.MatchedBy(inTx), mock.AnythingOfType("domain.AuctionRequest")).Return(nil)
+	mockRepo.On("Delete", mock.MatchedBy(inTx), "auction-id").Return(nil)
+	ctx := orgContext("org-id", http2.OrgRoleManager)
+
+	assert.NoError(t, svc.Update(ctx, domain.AuctionRequest{ID: "auction-id", Description: "Updated Auction"}))
+	assert.NoError(t, svc.Delete(ctx, "auction-id"))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestDeleteManyAuctions_OtherOrganization(t *testing.T) {
+	mockRepo := new(MockRepository)
+	svc := service.NewService(mockRepo, new(mocks.ItemRepositoryMock), nil, markingTransactor{}, new(mocks.MockOutbox), new(mocks.MockHistory), zap.NewNop())
+
+	mockRepo.On("Find", mock.MatchedBy(inTx), "own").Return(domain.Auction{ID: "own", SellerID: "org-id"}, nil)
+	mockRepo.On("Find", mock.MatchedBy(inTx), "other").Return(domain.Auction{ID: "other", SellerID: "other-org"}, nil)
+	mockRepo.On("DeleteMany", mock.MatchedBy(inTx), []interface{}{"own"}).Return(nil)
+	ctx := orgContext("org-id", http2.OrgRoleManager)
+
+	// one auction of another seller and nothing is deleted
+	err := svc.DeleteMany(ctx, []string{"own", "other"})
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+	mockRepo.AssertNotCalled(t, "DeleteMany", mock.Anything, mock.Anything)
+
+	assert.NoError(t, svc.DeleteMany(ctx, []string{"own"}))
+	mockRepo.AssertCalled(t, "DeleteMany", mock.Anything, []interface{}{"own"})
+}
+
+func TestCreateAuctionItems(t *testing.T) {
+	itemRepo := new(mocks.ItemRepositoryMock)
+	svc := service.NewService(new(MockRepository), itemRepo, nil, mocks.MockTransactor{}, new(mocks.MockOutbox), new(mocks.MockHistory), zap.NewNop())
+
+	itemRepo.On("CreateBulk", mock.Anything, mock.MatchedBy(func(items []domain.Item) bool {
+		for _, item := range items {
+			if item.ID == "" || item.AuctionID != "auction-id" || item.CreatedAt.IsZero() {
+				return false
+			}
+		}
+		return len(items) == 2 && items[0].ID != items[1].ID
+	})).Return(nil)
+
+	err := svc.CreateAuctionItems(context.Background(), "auction-id", []domain.Item{{Description: "lamp"}, {Description: "shade"}})
+	assert.NoError(t, err)
+	itemRepo.AssertExpectations(t)
+}
+
+func TestDeleteManyAuctions_History(t *testing.T) {
+	mockRepo := new(MockRepository)
+	outbox := new(mocks.MockOutbox)
+	history := new(mocks.MockHistory)
+	svc := service.NewService(mockRepo, new(mocks.ItemRepositoryMock), nil, mocks.MockTransactor{}, outbox, history, zap.NewNop())
+
+	mockRepo.On("Find", mock.Anything, "first").Return(domain.Auction{ID: "first", SellerID: "org-id"}, nil)
+	mockRepo.On("Find", mock.Anything, "second").Return(domain.Auction{ID: "second", SellerID: "org-id"}, nil)
+	mockRepo.On("DeleteMany", mock.Anything, []interface{}{"first", "second"}).Return(nil)
+
+	assert.NoError(t, svc.DeleteMany(context.Background(), []string{"first", "second"}))
+
+	var deleted []string
+	for _, entry := range history.Entries {
+		assert.Equal(t, domain.HistoryDeleted, entry.Type)
+		deleted = append(deleted, entry.AuctionID)
+	}
+	assert.Equal(t, []string{"first", "second"}, deleted)
+	assert.Len(t, outbox.Events, 2)
+}
+
+func TestCreateAuction_Outbox(t *testing.T) {
+	mockRepo := new(MockRepository)
+	outbox := new(mocks.MockOutbox)
+	svc := service.NewService(mockRepo, new(mocks.ItemRepositoryMock), nil, mocks.MockTransactor{}, outbox, new(mocks.MockHistory), zap.NewNop())
+	mockRepo.On("Create", mock.Anything, mock.AnythingOfType("domain.AuctionRequest")).Return(nil)
+
+	req := domain.AuctionRequest{Description: "Test Auction", MinIncrement: 1, InitialOffer: 5, SellerId: "org-id"}
+	id, err := svc.Create(context.Background(), req)
+	assert.NoError(t, err)
+
+	assert.Len(t, outbox.Events, 1)
+	event := outbox.Events[0]
+	assert.Equal(t, id, event.AggregateID)
+	assert.Equal(t, events.TopicAuctionCreated, event.Topic)
+	assert.Equal(t, events.TypeAuctionCreated, event.Envelope.Type)
+
+	payload, err := messaging.Payload[events.AuctionCreated](event.Envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, "org-id", payload.SellerID)
+	assert.Equal(t, domain.Pending.String(), payload.Status)
+
+	// the auction is not created without its event
+	outbox.AddFunc = func(ctx context.Context, aggregateID, topic string, envelope messaging.Envelope) error {
+		return errors.New("outbox down")
+	}
+	_, err = svc.Create(context.Background(), req)
+	assert.Error(t, err)
+}
+
+func TestUpdateAuction_StatusEvents(t *testing.T) {
+	mockRepo := new(MockRepository)
+	bidRepo := new(mocks.MockBidRepository)
+	outbox := new(mocks.MockOutbox)
+	svc := service.NewService(mockRepo, new(mocks.ItemRepositoryMock), bidRepo, mocks.MockTransactor{}, outbox, new(mocks.MockHistory), zap.NewNop())
+	mockRepo.On("Update", mock.Anything, mock.AnythingOfType("domain.AuctionRequest")).Return(nil)
+	mockRepo.On("Find", mock.Anything, "auction-id").Return(domain.Auction{ID: "auction-id", Status: domain.Completed, CurrentBid: 20, WinnerID: "bidder-id"}, nil)
+	bidRepo.On("Highest", mock.Anything, "auction-id").Return(domain.Bid{BidderID: "bidder-id", Price: 20}, nil)
+
+	assert.NoError(t, svc.Update(context.Background(), domain.AuctionRequest{ID: "auction-id", Status: domain.Active.String()}))
+	assert.NoError(t, svc.Update(context.Background(), domain.AuctionRequest{ID: "auction-id", Status: domain.Completed.String()}))
+	assert.NoError(t, svc.Update(context.Background(), domain.AuctionRequest{ID: "auction-id", Status: domain.Cancelled.String()}))
+
+	var types []string
+	for _, event := range outbox.Events {
+		types = append(types, event.Envelope.Type)
+	}
+	assert.Equal(t, []string{events.TypeAuctionUpdated, events.TypeAuctionClosed, events.TypeAuctionCancelled}, types)
+
+	closed, err := messaging.Payload[events.AuctionClosed](outbox.Events[1].Envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, events.AuctionClosed{AuctionID: "auction-id", WinnerID: "bidder-id", FinalBid: 20, ClosedAt: closed.ClosedAt}, closed)
+}
+
+func TestUpdateAuction_SellerCannotSetBidOrWinner(t *testing.T) {
+	mockRepo := new(MockRepository)
+	bidRepo := new(mocks.MockBidRepository)
+	svc := service.NewService(mockRepo, new(mocks.ItemRepositoryMock), bidRepo, mocks.MockTransactor{}, new(mocks.MockOutbox), new(mocks.MockHistory), zap.NewNop())
+	mockRepo.On("Update", mock.Anything, mock.AnythingOfType("domain.AuctionRequest")).Return(nil)
+	mockRepo.On("Find", mock.Anything, "auction-id").Return(domain.Auction{ID: "auction-id", Status: domain.Completed}, nil)
+	bidRepo.On("Highest", mock.Anything, "auction-id").Return(domain.Bid{}, sql.ErrNoRows)
+
+	err := svc.Update(context.Background(), domain.AuctionRequest{ID: "auction-id", Description: "bike", WinnerId: "friend-id", CurrentBid: 1000})
+	assert.NoError(t, err)
+	err = svc.Update(context.Background(), domain.AuctionRequest{ID: "auction-id", Status: domain.Completed.String(), WinnerId: "friend-id", CurrentBid: 1000})
+	assert.NoError(t, err)
+
+	for _, call := range mockRepo.Calls {
+		if call.Method == "Update" {
+			update := call.Arguments.Get(1).(domain.AuctionRequest)
+			assert.Empty(t, update.WinnerId)
+			assert.Zero(t, update.CurrentBid)
+		}
+	}
+}
+
+func TestPlaceBid(t *testing.T) {
+	mockRepo := new(MockRepository)
+	bidRepo := new(mocks.MockBidRepository)
+	outbox := new(mocks.MockOutbox)
+	svc := service.NewService(mockRepo, new(mocks.ItemRepositoryMock), bidRepo, mocks.MockTransactor{}, outbox, new(mocks.MockHistory), zap.NewNop())
+
+	mockRepo.On("Find", mock.Anything, "auction-id").Return(domain.Auction{ID: "auction-id", Status: domain.Active, CurrentBid: 10}, nil)
+	mockRepo.On("Find", mock.Anything, "closed-id").Return(domain.Auction{ID: "closed-id", Status: domain.Completed}, nil)
+	mockRepo.On("Update", mock.Anything, mock.AnythingOfType("domain.AuctionRequest")).Return(nil)
+	bidRepo.On("Create", mock.Anything, mock.AnythingOfType("domain.Bid")).Return(nil)
+
+	err := svc.PlaceBid(context.Background(), domain.PlaceBidRequest{AuctionID: "auction-id", BidderID: "bidder-id", Amount: 15})
+	assert.NoError(t, err)
+
+	assert.Len(t, outbox.Events, 1)
+	placed, err := messaging.Payload[events.BidPlaced](outbox.Events[0].Envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, "bidder-id", placed.BidderID)
+	assert.Equal(t, float64(15), placed.Amount)
+	assert.Equal(t, events.TopicBidPlaced, outbox.Events[0].Topic)
+
+	err = svc.PlaceBid(context.Background(), domain.PlaceBidRequest{AuctionID: "closed-id", BidderID: "bidder-id", Amount: 15})
+	assert.Error(t, err)
+	assert.Len(t, outbox.Events, 1)
+}
+
+func TestAuctionHistory(t *testing.T) {
+	mockRepo := new(MockRepository)
+	bidRepo := new(mocks.MockBidRepository)
+	history := new(mocks.MockHistory)
+	svc := service.NewService(mockRepo, new(mocks.ItemRepositoryMock), bidRepo, mocks.MockTransactor{}, new(mocks.MockOutbox), history, zap.NewNop())
+
+	mockRepo.On("Create", mock.Anything, mock.AnythingOfType("domain.AuctionRequest")).Return(nil)
+	mockRepo.On("Update", mock.Anything, mock.AnythingOfType("domain.AuctionRequest")).Return(nil)
+	bidRepo.On("Create", mock.Anything, mock.AnythingOfType("domain.Bid")).Return(nil)
+	bidRepo.On("Highest", mock.Anything, mock.Anything).Return(domain.Bid{BidderID: "bidder-id", Price: 7}, nil)
+
+	id, err := svc.Create(context.Background(), domain.AuctionRequest{Description: "old lamp", MinIncrement: 1, InitialOffer: 5, SellerId: "org-id"})
+	assert.NoError(t, err)
+	mockRepo.On("Find", mock.Anything, id).Return(domain.Auction{ID: id, SellerID: "org-id", Status: domain.Active, CurrentBid: 5, MinIncrement: 1}, nil)
+	assert.NoError(t, svc.Update(context.Background(), domain.AuctionRequest{ID: id, Status: domain.Active.String()}))
+
+	beforeBid := time.Now()
+	time.Sleep(time.Millisecond)
+	assert.NoError(t, svc.PlaceBid(context.Background(), domain.PlaceBidRequest{AuctionID: id, BidderID: "bidder-id", Amount: 7}))
+	assert.NoError(t, svc.Update(orgContext("org-id", http2.OrgRoleManager), domain.AuctionRequest{ID: id, Description: "antique lamp"}))
+	assert.NoError(t, svc.Update(context.Background(), domain.AuctionRequest{ID: id, Status: domain.Completed.String()}))
+
+	var types []string
+	for _, entry := range history.Entries {
+		types = append(types, entry.Type)
+	}
+	assert.Equal(t, []string{domain.HistoryCreated, domain.HistoryStatusChanged, domain.HistoryBidPlaced, domain.HistoryEdited, domain.HistoryClosed}, types)
+	assert.Equal(t, "user-id", history.Entries[3].Actor)
+
+	current, err := svc.History(context.Background(), id, time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, current.Entries, 5)
+	assert.Equal(t, "antique lamp", current.State.Description)
+	assert.Equal(t, domain.Completed.String(), current.State.Status)
+	assert.Equal(t, "bidder-id", current.State.WinnerID)
+	assert.Equal(t, 5, current.State.Version)
+
+	past, err := svc.History(context.Background(), id, beforeBid)
+	assert.NoError(t, err)
+	assert.Len(t, past.Entries, 2)
+	assert.Equal(t, "old lamp", past.State.Description)
+	assert.Equal(t, domain.Active.String(), past.State.Status)
+	assert.Equal(t, float64(5), past.State.InitialOffer)
+	assert.Zero(t, past.State.CurrentBid)
+
+	_, err = svc.History(context.Background(), id, beforeBid.Add(-time.Hour))
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
+
+func TestAuctionHistory_Readers(t *testing.T) {
+	mockRepo := new(MockRepository)
+	bidRepo := new(mocks.MockBidRepository)
+	history := new(mocks.MockHistory)
+	svc := service.NewService(mockRepo, new(mocks.ItemRepositoryMock), bidRepo, mocks.MockTransactor{}, new(mocks.MockOutbox), history, zap.NewNop())
+
+	mockRepo.On("Create", mock.Anything, mock.AnythingOfType("domain.AuctionRequest")).Return(nil)
+	id, err := svc.Create(context.Background(), domain.AuctionRequest{Description: "old lamp", MinIncrement: 1, InitialOffer: 5, SellerId: "org-id"})
+	assert.NoError(t, err)
+	mockRepo.On("Find", mock.Anything, id).Return(domain.Auction{ID: id, SellerID: "org-id"}, nil)
+	bidRepo.On("HasBid", mock.Anything, id, "bidder-id").Return(true, nil)
+	bidRepo.On("HasBid", mock.Anything, id, "user-id").Return(false, nil)
+	bidRepo.On("HasBid", mock.Anything, id, "admin-id").Return(false, nil)
+
+	claims := func(c jwt.MapClaims) context.Context { return http2.ContextWithClaims(context.Background(), c) }
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+	}{
+		{name: "seller viewer", ctx: orgContext("org-id", http2.OrgRoleViewer)},
+		{name: "other seller", ctx: orgContext("other-org", http2.OrgRoleOwner), err: domain.ErrForbidden},
+		{name: "service", ctx: claims(jwt.MapClaims{"sub": "projections", "typ": http2.TokenTypeService})},
+		{name: "bidder on the auction", ctx: claims(jwt.MapClaims{"sub": "bidder-id"})},
+		{name: "support", ctx: claims(jwt.MapClaims{"sub": "admin-id", "role": http2.RoleAdmin})},
+		{name: "support impersonating", ctx: claims(jwt.MapClaims{"sub": "user-id", http2.ActorClaim: map[string]interface{}{"sub": "admin-id"}})},
+		{name: "other user", ctx: claims(jwt.MapClaims{"sub": "user-id"}), err: domain.ErrForbidden},
+		{name: "admin api key", ctx: claims(jwt.MapClaims{"sub": "admin-id", "role": http2.RoleAdmin, "typ": http2.TokenTypeAPIKey}), err: domain.ErrForbidden},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := svc.History(tc.ctx, id, time.Time{})
+
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
 }
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"context"
+
+	"github.com/ireuven89/auctions/auction-service/internal/service"
+	"github.com/ireuven89/auctions/shared/events"
+	"github.com/ireuven89/auctions/shared/messaging"
+)
+
+// WebhookEventsSubscription is the queue the auction events delivered to the webhooks of sellers reach
+// auction-service on
+var WebhookEventsSubscription = messaging.Subscription{
+	Queue: "auction-service.webhooks",
+	Topics: []string{
+		events.TopicAuctionCreated,
+		events.TopicAuctionUpdated,
+		events.TopicBidPlaced,
+		events.TopicAuctionClosed,
+		events.TopicAuctionCancelled,
+		events.TopicAuctionDeleted,
+	},
+}
+
+// NewWebhookEventsHandler turns the auction events into deliveries to the webhooks subscribed to them
+func NewWebhookEventsHandler(s service.WebhookService) messaging.Handler {
+	return func(ctx context.Context, msg messaging.Message) error {
+
+		return s.EnqueueEvent(ctx, msg.Envelope)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"mime/multipart"
+	"time"
 
 	"github.com/ireuven89/auctions/auction-service/domain"
 	"github.com/ireuven89/auctions/auction-service/internal/service"
@@ -156,6 +157,31 @@ func MakeEndpointCreateAuctionItems(s service.Service) endpoint.Endpoint {
 	}
 }
 
+type GetAuctionItemsRequestModel struct {
+	auctionID string
+}
+
+type GetAuctionItemsResponseModel struct {
+	items []domain.Item
+}
+
+func MakeEndpointGetAuctionItems(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(GetAuctionItemsRequestModel)
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointGetAuctionItems.failed parsing request")
+		}
+
+		items, err := s.FindAuctionItems(ctx, req.auctionID)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointGetAuctionItems %w", err)
+		}
+
+		return GetAuctionItemsResponseModel{items: items}, nil
+	}
+}
+
 type AuctionPicturesRequestModel struct {
 	ItemID string
 	Files  []*multipart.FileHeader
@@ -181,3 +207,32 @@ func MakeEndpointCreateAuctionItemPictures(s service.Service) endpoint.Endpoint
 type CreateItemRequestModel struct {
 	req domain.ItemRequest
 }
+
+type GetAuctionHistoryRequestModel struct {
+	id   string
+	asOf time.Time
+}
+
+type GetAuctionHistoryResponseModel struct {
+	history domain.AuctionHistory
+}
+
+func MakeEndpointGetAuctionHistory(s service.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(GetAuctionHistoryRequestModel)
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointGetAuctionHistory.failed parsing request")
+		}
+
+		res, err := s.History(ctx, req.id, req.asOf)
+
+		if err != nil {
+
+			return nil, fmt.Errorf("MakeEndpointGetAuctionHistory %w", err)
+		}
+
+		return GetAuctionHistoryResponseModel{
+			history: res,
+		}, nil
+	}
+}
@@ -9,6 +9,7 @@ func formatAuction(auction *domain.Auction) map[string]interface{} {
 	return map[string]interface{}{
 		"id":           auction.ID,
 		"description":  auction.Description,
+		"sellerId":     auction.SellerID,
 		"regions":      auction.Regions,
 		"starting_bid": auction.InitialOffer,
 		"currentOffer": auction.CurrentBid,
@@ -28,3 +29,90 @@ func formatItem(auction *domain.Item) map[string]interface{} {
 		"auction_id":  auction.AuctionID,
 	}
 }
+
+func formatHistory(history domain.AuctionHistory) map[string]interface{} {
+	entries := make([]map[string]interface{}, 0, len(history.Entries))
+
+	for _, entry := range history.Entries {
+		formatted := map[string]interface{}{
+			"version":    entry.Version,
+			"type":       entry.Type,
+			"changes":    entry.Changes,
+			"actor":      entry.Actor,
+			"occurredAt": entry.OccurredAt,
+		}
+
+		if entry.Bid != nil {
+			formatted["bid"] = entry.Bid
+		}
+
+		if entry.Impersonator != "" {
+			formatted["impersonator"] = entry.Impersonator
+		}
+
+		entries = append(entries, formatted)
+	}
+
+	state := history.State
+
+	return map[string]interface{}{
+		"auctionId": state.ID,
+		"state": map[string]interface{}{
+			"description":  state.Description,
+			"sellerId":     state.SellerID,
+			"regions":      state.Regions,
+			"initialOffer": state.InitialOffer,
+			"minIncrement": state.MinIncrement,
+			"currentBid":   state.CurrentBid,
+			"status":       state.Status,
+			"winnerId":     state.WinnerID,
+			"deleted":      state.Deleted,
+			"version":      state.Version,
+			"created_at":   state.CreatedAt,
+			"updated_at":   state.UpdatedAt,
+		},
+		"history": entries,
+	}
+}
+
+// formatWebhook leaves the secret out, it is only shown when the webhook is registered
+func formatWebhook(hook domain.Webhook) map[string]interface{} {
+
+	return map[string]interface{}{
+		"id":         hook.ID,
+		"sellerId":   hook.SellerID,
+		"url":        hook.URL,
+		"eventTypes": hook.EventTypes,
+		"active":     hook.Active,
+		"failures":   hook.Failures,
+		"disabledAt": hook.DisabledAt,
+		"created_at": hook.CreatedAt,
+		"updated_at": hook.UpdatedAt,
+	}
+}
+
+func formatDelivery(delivery domain.WebhookDelivery) map[string]interface{} {
+	formatted := map[string]interface{}{
+		"id":             delivery.ID,
+		"webhookId":      delivery.WebhookID,
+		"eventId":        delivery.EventID,
+		"eventType":      delivery.EventType,
+		"payload":        delivery.Payload,
+		"status":         delivery.Status,
+		"attempts":       delivery.Attempts,
+		"lastStatusCode": delivery.LastStatusCode,
+		"lastError":      delivery.LastError,
+		"created_at":     delivery.CreatedAt,
+		"deliveredAt":    delivery.DeliveredAt,
+	}
+
+	if delivery.Status == domain.DeliveryPending {
+		formatted["nextAttemptAt"] = delivery.NextAttemptAt
+	}
+
+	if delivery.ReplayOf != "" {
+		formatted["replayOf"] = delivery.ReplayOf
+	}
+
+	return formatted
+}
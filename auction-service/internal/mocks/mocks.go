@@ -2,9 +2,13 @@ package mocks
 
 import (
 	"context"
+	"database/sql"
 	"mime/multipart"
+	"sync"
+	"time"
 
 	"github.com/ireuven89/auctions/auction-service/domain"
+	"github.com/ireuven89/auctions/shared/messaging"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -16,8 +20,10 @@ type MockAuctionService struct {
 	DeleteManyFunc            func(ctx context.Context, ids []string) error
 	SearchFunc                func(ctx context.Context, request domain.AuctionRequest) ([]domain.Auction, error)
 	CreateAuctionItemsFunc    func(ctx context.Context, itemId string, items []domain.Item) error
+	FindAuctionItemsFunc      func(ctx context.Context, auctionId string) ([]domain.Item, error)
 	CreateAuctionPicturesFunc func(ctx context.Context, id string, request []*multipart.FileHeader) error
 	PlaceBidFunc              func(ctx context.Context, bid domain.PlaceBidRequest) error
+	HistoryFunc               func(ctx context.Context, id string, asOf time.Time) (domain.AuctionHistory, error)
 }
 
 func (m *MockAuctionService) CreateAuctionPictures(ctx context.Context, id string, request []*multipart.FileHeader) error {
@@ -52,10 +58,18 @@ func (m *MockAuctionService) PlaceBid(ctx context.Context, bid domain.PlaceBidRe
 	return m.PlaceBidFunc(ctx, bid)
 }
 
+func (m *MockAuctionService) History(ctx context.Context, id string, asOf time.Time) (domain.AuctionHistory, error) {
+	return m.HistoryFunc(ctx, id, asOf)
+}
+
 func (m *MockAuctionService) CreateAuctionItems(ctx context.Context, itemId string, items []domain.Item) error {
 	return m.CreateAuctionItemsFunc(ctx, itemId, items)
 }
 
+func (m *MockAuctionService) FindAuctionItems(ctx context.Context, auctionId string) ([]domain.Item, error) {
+	return m.FindAuctionItemsFunc(ctx, auctionId)
+}
+
 type ItemRepositoryMock struct {
 	mock.Mock
 }
@@ -154,3 +168,228 @@ func (m *MockItemRepository) GeItemsWByAuctionWithPictures(ctx context.Context,
 
 	return m.GeItemsWByAuctionWithPicturesFunc(ctx, id)
 }
+
+// MockTransactor runs the function without a transaction
+type MockTransactor struct{}
+
+func (m MockTransactor) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+type OutboxEvent struct {
+	AggregateID string
+	Topic       string
+	Envelope    messaging.Envelope
+}
+
+// MockOutbox records the events added to it, AddFunc may fail them
+type MockOutbox struct {
+	mu      sync.Mutex
+	Events  []OutboxEvent
+	AddFunc func(ctx context.Context, aggregateID, topic string, envelope messaging.Envelope) error
+}
+
+func (m *MockOutbox) Add(ctx context.Context, aggregateID, topic string, envelope messaging.Envelope) error {
+	if m.AddFunc != nil {
+		if err := m.AddFunc(ctx, aggregateID, topic, envelope); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Events = append(m.Events, OutboxEvent{AggregateID: aggregateID, Topic: topic, Envelope: envelope})
+
+	return nil
+}
+
+// MockHistory keeps the entries appended to it in memory, numbering them per auction like the repository
+type MockHistory struct {
+	mu      sync.Mutex
+	Entries []domain.HistoryEntry
+}
+
+func (m *MockHistory) Append(ctx context.Context, entry domain.HistoryEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry.Version = 1
+	for _, e := range m.Entries {
+		if e.AuctionID == entry.AuctionID {
+			entry.Version++
+		}
+	}
+	m.Entries = append(m.Entries, entry)
+
+	return nil
+}
+
+func (m *MockHistory) List(ctx context.Context, auctionID string, until time.Time) ([]domain.HistoryEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var entries []domain.HistoryEntry
+	for _, e := range m.Entries {
+		if e.AuctionID == auctionID && !e.OccurredAt.After(until) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+// MockWebhooks keeps webhooks and their deliveries in memory, a delivery of an event a webhook already has
+// is skipped like the repository does
+type MockWebhooks struct {
+	mu    sync.Mutex
+	Hooks []domain.Webhook
+	Log   []domain.WebhookDelivery
+}
+
+func (m *MockWebhooks) Create(ctx context.Context, hook domain.Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Hooks = append(m.Hooks, hook)
+
+	return nil
+}
+
+func (m *MockWebhooks) Find(ctx context.Context, id string) (domain.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, hook := range m.Hooks {
+		if hook.ID == id {
+			return hook, nil
+		}
+	}
+
+	return domain.Webhook{}, sql.ErrNoRows
+}
+
+func (m *MockWebhooks) FindBySeller(ctx context.Context, sellerID string) ([]domain.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var hooks []domain.Webhook
+	for _, hook := range m.Hooks {
+		if hook.SellerID == sellerID {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	return hooks, nil
+}
+
+func (m *MockWebhooks) Update(ctx context.Context, hook domain.Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.Hooks {
+		if m.Hooks[i].ID == hook.ID {
+			m.Hooks[i] = hook
+		}
+	}
+
+	return nil
+}
+
+func (m *MockWebhooks) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.Hooks {
+		if m.Hooks[i].ID == id {
+			m.Hooks = append(m.Hooks[:i], m.Hooks[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (m *MockWebhooks) Enqueue(ctx context.Context, deliveries ...domain.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, delivery := range deliveries {
+		duplicate := false
+		for _, d := range m.Log {
+			duplicate = duplicate || (delivery.ReplayOf == "" && d.ReplayOf == "" && d.WebhookID == delivery.WebhookID && d.EventID == delivery.EventID)
+		}
+
+		if !duplicate {
+			m.Log = append(m.Log, delivery)
+		}
+	}
+
+	return nil
+}
+
+func (m *MockWebhooks) Deliveries(ctx context.Context, filter domain.DeliveryFilter) ([]domain.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deliveries []domain.WebhookDelivery
+	for _, d := range m.Log {
+		if d.WebhookID == filter.WebhookID && (filter.Status == "" || d.Status == filter.Status) && len(deliveries) < filter.Limit {
+			deliveries = append(deliveries, d)
+		}
+	}
+
+	return deliveries, nil
+}
+
+func (m *MockWebhooks) FindDelivery(ctx context.Context, webhookID, id string) (domain.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range m.Log {
+		if d.WebhookID == webhookID && d.ID == id {
+			return d, nil
+		}
+	}
+
+	return domain.WebhookDelivery{}, sql.ErrNoRows
+}
+
+func (m *MockWebhooks) ReplaySince(ctx context.Context, webhookID string, since time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	replayed := 0
+	for _, d := range m.Log {
+		if d.WebhookID == webhookID && d.ReplayOf == "" && !d.CreatedAt.Before(since) {
+			d.ReplayOf, d.ID, d.Status = d.ID, d.ID+"-replay", domain.DeliveryPending
+			m.Log = append(m.Log, d)
+			replayed++
+		}
+	}
+
+	return replayed, nil
+}
+
+type MockBidRepository struct {
+	mock.Mock
+}
+
+func (m *MockBidRepository) Find(ctx context.Context, id string) (domain.Bid, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(domain.Bid), args.Error(1)
+}
+
+func (m *MockBidRepository) Highest(ctx context.Context, auctionID string) (domain.Bid, error) {
+	args := m.Called(ctx, auctionID)
+	return args.Get(0).(domain.Bid), args.Error(1)
+}
+
+func (m *MockBidRepository) HasBid(ctx context.Context, auctionID, bidderID string) (bool, error) {
+	args := m.Called(ctx, auctionID, bidderID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockBidRepository) Create(ctx context.Context, bid domain.Bid) error {
+	args := m.Called(ctx, bid)
+	return args.Error(0)
+}
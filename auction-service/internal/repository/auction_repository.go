@@ -11,16 +11,19 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ireuven89/auctions/shared/sqltx"
 	"go.uber.org/zap"
 )
 
 type AuctionDB struct {
 	ID           string    `db:"id"`
 	Description  string    `db:"description"`
+	SellerID     string    `db:"seller_id"`
 	Regions      []byte    `db:"regions"`
 	InitialOffer float64   `db:"initial_offer"`
 	CurrentBid   float64   `db:"current_highest"`
 	Status       string    `db:"status"`
+	WinnerID     string    `db:"winner_id"`
 	CreatedAt    time.Time `db:"created_at"`
 	UpdatedAt    time.Time `db:"updated_at"`
 }
@@ -30,10 +33,12 @@ func toAuction(db AuctionDB) domain.Auction {
 	return domain.Auction{
 		ID:           db.ID,
 		Description:  db.Description,
+		SellerID:     db.SellerID,
 		Regions:      db.Regions,
 		InitialOffer: db.InitialOffer,
 		CurrentBid:   db.CurrentBid,
 		Status:       domain.FromString(db.Status),
+		WinnerID:     db.WinnerID,
 		CreatedAt:    db.CreatedAt,
 		UpdatedAt:    db.UpdatedAt,
 	}
@@ -55,7 +60,12 @@ func (r *AuctionRepository) Find(ctx context.Context, id string) (domain.Auction
 	var result AuctionDB
 	start := time.Now()
 
-	q := "select id, description from auctions where id = ?"
+	q := "select id, description, seller_id, coalesce(status, ''), coalesce(current_bid, 0), coalesce(winner_id, '') from auctions where id = ?"
+
+	// concurrent bids on the auction wait for each other, each checks the amount against the bid before it
+	if sqltx.InTransaction(ctx) {
+		q += " for update"
+	}
 
 	r.logger.Debug("AuctionRepository.Find ", zap.Any("query", q), zap.Any("args", id))
 
@@ -63,14 +73,14 @@ func (r *AuctionRepository) Find(ctx context.Context, id string) (domain.Auction
 		log.Printf("Query took %s: %s", time.Since(start), q)
 	}()
 
-	row := r.db.QueryRowContext(ctx, q, id)
+	row := sqltx.Conn(ctx, r.db).QueryRowContext(ctx, q, id)
 
 	if row.Err() != nil {
 		r.logger.Error("AuctionRepository.Find failed fetching result ", zap.Error(row.Err()), zap.String("id", id))
 		return domain.Auction{}, row.Err()
 	}
 
-	if err := row.Scan(&result.ID, &result.Description); err != nil {
+	if err := row.Scan(&result.ID, &result.Description, &result.SellerID, &result.Status, &result.CurrentBid, &result.WinnerID); err != nil {
 		r.logger.Error("failed getting db result", zap.Error(err))
 		return domain.Auction{}, err
 	}
@@ -85,7 +95,7 @@ func (r *AuctionRepository) FindAll(ctx context.Context, request domain.AuctionR
 
 	r.logger.Debug("AuctionRepository.FindAll", zap.String("query", q))
 
-	rows, err := r.db.QueryContext(ctx, q)
+	rows, err := sqltx.Conn(ctx, r.db).QueryContext(ctx, q)
 
 	if err != nil {
 		r.logger.Error("AuctionRepository.FindAll failed to query", zap.Error(err))
@@ -124,7 +134,7 @@ func (r *AuctionRepository) Update(ctx context.Context, auction domain.AuctionRe
 		return err
 	}
 
-	_, err = r.db.ExecContext(ctx, q, args...)
+	_, err = sqltx.Conn(ctx, r.db).ExecContext(ctx, q, args...)
 
 	if err != nil {
 		r.logger.Error("AuctionRepositoryUpdate. failed update query ", zap.Error(err))
@@ -145,13 +155,25 @@ func buildUpdateQuery(auction domain.AuctionRequest) (string, []interface{}, err
 	}
 
 	if len(auction.Regions) != 0 {
+		sets = append(sets, "regions = ?")
 		args = append(args, auction.Regions)
 	}
 
 	if auction.Status != "" {
+		sets = append(sets, "status = ?")
 		args = append(args, auction.Status)
 	}
 
+	if auction.CurrentBid != 0 {
+		sets = append(sets, "current_bid = ?")
+		args = append(args, auction.CurrentBid)
+	}
+
+	if auction.WinnerId != "" {
+		sets = append(sets, "winner_id = ?")
+		args = append(args, auction.WinnerId)
+	}
+
 	if len(sets) == 0 {
 		return "", nil, fmt.Errorf("no fields to update")
 	}
@@ -167,7 +189,7 @@ func (r *AuctionRepository) Create(ctx context.Context, auction domain.AuctionRe
 
 	r.logger.Debug("AuctionRepository.Create", zap.String("query", q), zap.Any("args", auction))
 
-	_, err := r.db.ExecContext(ctx, q, auction.ID, auction.Description, auction.SellerId, auction.Regions, auction.Status, auction.InitialOffer, auction.CreatedAt, auction.UpdatedAt)
+	_, err := sqltx.Conn(ctx, r.db).ExecContext(ctx, q, auction.ID, auction.Description, auction.SellerId, auction.Regions, auction.Status, auction.InitialOffer, auction.CreatedAt, auction.UpdatedAt)
 
 	if err != nil {
 		r.logger.Error("AuctionRepository.Create failed to insert ", zap.Error(err))
@@ -183,7 +205,7 @@ func (r *AuctionRepository) Delete(ctx context.Context, id string) error {
 
 	r.logger.Debug("AuctionRepository.Delete", zap.String("query", q), zap.Any("args", id))
 
-	_, err := r.db.ExecContext(ctx, q, id)
+	_, err := sqltx.Conn(ctx, r.db).ExecContext(ctx, q, id)
 
 	if err != nil {
 		return fmt.Errorf("AuctionRepository.Delete failed deleting %w", err)
@@ -199,7 +221,7 @@ func (r *AuctionRepository) DeleteMany(ctx context.Context, ids []interface{}) e
 	startTime := time.Now()
 	r.logger.Debug("AuctionRepository.DeleteMany", zap.String("query", q), zap.Any("args", args))
 
-	if _, err := r.db.ExecContext(ctx, q, args...); err != nil {
+	if _, err := sqltx.Conn(ctx, r.db).ExecContext(ctx, q, args...); err != nil {
 		r.logger.Error("AuctionRepository.DeleteMany failed deleting", zap.Error(err))
 		return fmt.Errorf("AuctionRepository.DeleteMany failed deleting %w", err)
 	}
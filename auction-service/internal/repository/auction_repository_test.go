@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/ireuven89/auctions/auction-service/domain"
+	"github.com/ireuven89/auctions/shared/sqltx"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -40,6 +41,13 @@ func TestUpdateQuery(t *testing.T) {
 			ExpectedArgs:  nil,
 			ExpectedErr:   true,
 		},
+		{
+			Name:          "closing with the winning bid",
+			Request:       domain.AuctionRequest{ID: "testdata-id", Status: domain.Completed.String(), CurrentBid: 120, WinnerId: "bidder-id"},
+			ExpectedQuery: "UPDATE auctions SET status = ?, current_bid = ?, winner_id = ? WHERE id = ?",
+			ExpectedArgs:  []interface{}{domain.Completed.String(), float64(120), "bidder-id", "testdata-id"},
+			ExpectedErr:   false,
+		},
 		{
 			Name:          "another query",
 			Request:       domain.AuctionRequest{ID: "testdata-id", Description: "name"},
@@ -93,3 +101,33 @@ func TestRepo_FindAll(t *testing.T) {
 	require.Equal(t, "a1", auctions[0].ID)
 	require.Equal(t, "car auction", auctions[0].Description)
 }
+
+func TestRepo_Find_LocksInTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	r := &AuctionRepository{db: db, logger: zaptest.NewLogger(t)}
+	query := "select id, description, seller_id, coalesce(status, ''), coalesce(current_bid, 0), coalesce(winner_id, '') from auctions where id = ?"
+	columns := []string{"id", "description", "seller_id", "status", "current_bid", "winner_id"}
+
+	mock.ExpectQuery(regexp.QuoteMeta(query) + "$").WithArgs("a1").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("a1", "bike", "seller", domain.Active.String(), 100.0, ""))
+
+	_, err = r.Find(context.Background(), "a1")
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(query + " for update")).WithArgs("a1").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("a1", "bike", "seller", domain.Active.String(), 100.0, ""))
+	mock.ExpectCommit()
+
+	err = sqltx.NewManager(db).WithinTransaction(context.Background(), func(ctx context.Context) error {
+		auction, err := r.Find(ctx, "a1")
+		assert.Equal(t, 100.0, auction.CurrentBid)
+
+		return err
+	})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/ireuven89/auctions/auction-service/domain"
+	"github.com/ireuven89/auctions/shared/sqltx"
 	"go.uber.org/zap"
 )
 
@@ -14,18 +15,54 @@ type BidRepository struct {
 	db     *sql.DB
 }
 
+func NewBidRepo(db *sql.DB, logger *zap.Logger) *BidRepository {
+
+	return &BidRepository{
+		logger: logger,
+		db:     db,
+	}
+}
+
 func (r *BidRepository) Find(ctx context.Context, id string) (domain.Bid, error) {
-	_, err := r.db.ExecContext(ctx, "select id, auction_id, bidder_id, price from bids where id = ?", id)
+	var bid domain.Bid
 
-	if err != nil {
+	row := sqltx.Conn(ctx, r.db).QueryRowContext(ctx, "select id, auction_id, bidder_id, bid from bid where id = ?", id)
+
+	if err := row.Scan(&bid.ID, &bid.AuctionID, &bid.BidderID, &bid.Price); err != nil {
 		return domain.Bid{}, fmt.Errorf("BidRepository.Find %w", err)
 	}
 
-	return domain.Bid{}, nil
+	return bid, nil
+}
+
+// Highest returns the highest bid on the auction, sql.ErrNoRows when nobody bid
+func (r *BidRepository) Highest(ctx context.Context, auctionID string) (domain.Bid, error) {
+	var bid domain.Bid
+
+	row := sqltx.Conn(ctx, r.db).QueryRowContext(ctx, "select id, auction_id, bidder_id, bid from bid where auction_id = ? order by bid desc limit 1", auctionID)
+
+	if err := row.Scan(&bid.ID, &bid.AuctionID, &bid.BidderID, &bid.Price); err != nil {
+		return domain.Bid{}, fmt.Errorf("BidRepository.Highest %w", err)
+	}
+
+	return bid, nil
+}
+
+// HasBid reports whether the bidder bid on the auction
+func (r *BidRepository) HasBid(ctx context.Context, auctionID, bidderID string) (bool, error) {
+	var found bool
+
+	row := sqltx.Conn(ctx, r.db).QueryRowContext(ctx, "select exists(select 1 from bid where auction_id = ? and bidder_id = ?)", auctionID, bidderID)
+
+	if err := row.Scan(&found); err != nil {
+		return false, fmt.Errorf("BidRepository.HasBid %w", err)
+	}
+
+	return found, nil
 }
 
 func (r *BidRepository) Create(ctx context.Context, bid domain.Bid) error {
-	_, err := r.db.ExecContext(ctx, "insert into bids (id, auction_id, bidder_id, price) values (?,?,?,?,?)", bid.ID, bid.BidderID, bid.AuctionID, bid.Winner, bid.CreateAt)
+	_, err := sqltx.Conn(ctx, r.db).ExecContext(ctx, "insert into bid (id, auction_id, bidder_id, bid) values (?, ?, ?, ?)", bid.ID, bid.AuctionID, bid.BidderID, bid.Price)
 
 	if err != nil {
 		return fmt.Errorf("BidRepository.Create %w", err)
@@ -35,7 +72,7 @@ func (r *BidRepository) Create(ctx context.Context, bid domain.Bid) error {
 }
 
 func (r *BidRepository) Update(ctx context.Context, bid domain.Bid) error {
-	_, err := r.db.ExecContext(ctx, "update bids set price = ? where id = ?", bid.Price, bid.ID)
+	_, err := sqltx.Conn(ctx, r.db).ExecContext(ctx, "update bid set bid = ? where id = ?", bid.Price, bid.ID)
 
 	if err != nil {
 		return fmt.Errorf("BidRepository.Update %w", err)
@@ -45,7 +82,7 @@ func (r *BidRepository) Update(ctx context.Context, bid domain.Bid) error {
 }
 
 func (r *BidRepository) Delete(ctx context.Context, id string) error {
-	_, err := r.db.ExecContext(ctx, "delete from bids where id = ?", id)
+	_, err := sqltx.Conn(ctx, r.db).ExecContext(ctx, "delete from bid where id = ?", id)
 
 	if err != nil {
 		return fmt.Errorf("BidRepository.Delete failed deleting %w", err)
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ireuven89/auctions/auction-service/domain"
+	"github.com/ireuven89/auctions/shared/sqltx"
+	"go.uber.org/zap"
+)
+
+type HistoryRepository struct {
+	logger *zap.Logger
+	db     *sql.DB
+}
+
+func NewHistoryRepo(db *sql.DB, logger *zap.Logger) *HistoryRepository {
+
+	return &HistoryRepository{
+		logger: logger,
+		db:     db,
+	}
+}
+
+// Append adds the entry as the next version of the auction, in the transaction of the context so the
+// history has the change if and only if it was made. The version of the entry is ignored
+func (r *HistoryRepository) Append(ctx context.Context, entry domain.HistoryEntry) error {
+	changes, err := json.Marshal(entry.Changes)
+
+	if err != nil {
+		return fmt.Errorf("HistoryRepository.Append %w", err)
+	}
+
+	var bid []byte
+	if entry.Bid != nil {
+		if bid, err = json.Marshal(entry.Bid); err != nil {
+			return fmt.Errorf("HistoryRepository.Append %w", err)
+		}
+	}
+
+	// two changes of the same auction racing for a version fail the later one on the unique key
+	q := `insert into auction_history (auction_id, version, type, changes, bid, actor, impersonator, occurred_at)
+		select ?, coalesce(max(version), 0) + 1, ?, ?, ?, ?, ?, ? from auction_history where auction_id = ?`
+
+	_, err = sqltx.Conn(ctx, r.db).ExecContext(ctx, q, entry.AuctionID, entry.Type, changes, bid, entry.Actor, entry.Impersonator,
+		entry.OccurredAt.UTC(), entry.AuctionID)
+
+	if err != nil {
+		r.logger.Error("HistoryRepository.Append failed", zap.Error(err), zap.String("auctionId", entry.AuctionID))
+		return fmt.Errorf("HistoryRepository.Append %w", err)
+	}
+
+	return nil
+}
+
+// List returns the history of the auction up to the time, oldest first
+func (r *HistoryRepository) List(ctx context.Context, auctionID string, until time.Time) ([]domain.HistoryEntry, error) {
+	q := `select version, type, changes, bid, actor, impersonator, occurred_at from auction_history
+		where auction_id = ? and occurred_at <= ? order by version`
+
+	rows, err := sqltx.Conn(ctx, r.db).QueryContext(ctx, q, auctionID, until.UTC())
+
+	if err != nil {
+		return nil, fmt.Errorf("HistoryRepository.List %w", err)
+	}
+	defer rows.Close()
+
+	var entries []domain.HistoryEntry
+
+	for rows.Next() {
+		entry := domain.HistoryEntry{AuctionID: auctionID}
+		var changes, bid []byte
+
+		if err = rows.Scan(&entry.Version, &entry.Type, &changes, &bid, &entry.Actor, &entry.Impersonator, &entry.OccurredAt); err != nil {
+			return nil, fmt.Errorf("HistoryRepository.List %w", err)
+		}
+
+		if err = json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, fmt.Errorf("HistoryRepository.List malformed changes of version %d %w", entry.Version, err)
+		}
+
+		if bid != nil {
+			entry.Bid = &domain.HistoryBid{}
+			if err = json.Unmarshal(bid, entry.Bid); err != nil {
+				return nil, fmt.Errorf("HistoryRepository.List malformed bid of version %d %w", entry.Version, err)
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("HistoryRepository.List %w", err)
+	}
+
+	return entries, nil
+}
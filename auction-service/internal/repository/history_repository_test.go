@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ireuven89/auctions/auction-service/domain"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestHistoryRepo_Append(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	r := NewHistoryRepo(db, zaptest.NewLogger(t))
+
+	amount := float64(15)
+	occurredAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	entry := domain.HistoryEntry{
+		AuctionID:  "auction-id",
+		Type:       domain.HistoryBidPlaced,
+		Changes:    domain.AuctionChanges{CurrentBid: &amount},
+		Bid:        &domain.HistoryBid{ID: "bid-id", BidderID: "bidder-id", Amount: amount},
+		Actor:      "user-id",
+		OccurredAt: occurredAt,
+	}
+
+	mock.ExpectExec("insert into auction_history").
+		WithArgs("auction-id", domain.HistoryBidPlaced, []byte(`{"currentBid":15}`), []byte(`{"id":"bid-id","bidderId":"bidder-id","amount":15}`),
+			"user-id", "", occurredAt, "auction-id").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	require.NoError(t, r.Append(context.Background(), entry))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHistoryRepo_List(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	r := NewHistoryRepo(db, zaptest.NewLogger(t))
+	until := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	rows := sqlmock.NewRows([]string{"version", "type", "changes", "bid", "actor", "impersonator", "occurred_at"}).
+		AddRow(1, domain.HistoryCreated, []byte(`{"description":"lamp","status":"pending"}`), nil, "user-id", "", until.Add(-time.Hour)).
+		AddRow(2, domain.HistoryBidPlaced, []byte(`{"currentBid":15}`), []byte(`{"id":"bid-id","bidderId":"bidder-id","amount":15}`), "bidder-id", "", until)
+
+	mock.ExpectQuery("select version, type, changes, bid, actor, impersonator, occurred_at from auction_history").
+		WithArgs("auction-id", until).
+		WillReturnRows(rows)
+
+	entries, err := r.List(context.Background(), "auction-id", until)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	require.Equal(t, "lamp", *entries[0].Changes.Description)
+	require.Nil(t, entries[0].Bid)
+	require.Equal(t, 2, entries[1].Version)
+	require.Equal(t, "bidder-id", entries[1].Bid.BidderID)
+	require.Equal(t, float64(15), *entries[1].Changes.CurrentBid)
+}
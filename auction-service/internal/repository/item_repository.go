@@ -204,7 +204,7 @@ func (r *ItemRepository) CreateBulk(ctx context.Context, request []domain.Item)
 	tx, err := r.db.Begin()
 
 	if err != nil {
-		fmt.Errorf("ItemRepository.CreateBulk %w", err)
+		return fmt.Errorf("ItemRepository.CreateBulk %w", err)
 	}
 
 	defer func() {
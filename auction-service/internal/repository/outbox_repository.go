@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/ireuven89/auctions/shared/messaging/outbox"
+	"github.com/ireuven89/auctions/shared/sqltx"
+	"go.uber.org/zap"
+)
+
+type OutboxRepository struct {
+	logger *zap.Logger
+	db     *sql.DB
+}
+
+func NewOutboxRepo(db *sql.DB, logger *zap.Logger) *OutboxRepository {
+
+	return &OutboxRepository{
+		logger: logger,
+		db:     db,
+	}
+}
+
+// Add writes the event in the transaction of the context, the change it describes has to be written with it
+func (r *OutboxRepository) Add(ctx context.Context, aggregateID, topic string, envelope messaging.Envelope) error {
+	r.logger.Debug("OutboxRepository.Add", zap.String("type", envelope.Type), zap.String("aggregateId", aggregateID))
+
+	if err := outbox.Insert(ctx, sqltx.Conn(ctx, r.db), outbox.Event{AggregateID: aggregateID, Topic: topic, Envelope: envelope}); err != nil {
+		return fmt.Errorf("OutboxRepository.Add %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,409 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ireuven89/auctions/auction-service/domain"
+	"github.com/ireuven89/auctions/auction-service/internal/webhook"
+	"github.com/ireuven89/auctions/shared/sqltx"
+	"go.uber.org/zap"
+)
+
+const webhookColumns = "id, seller_id, url, secret, event_types, active, failures, disabled_at, created_at, updated_at"
+
+const deliveryColumns = "id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, coalesce(last_error, ''), coalesce(replay_of, ''), created_at, delivered_at"
+
+// WebhookRepository keeps the webhooks and their deliveries, it is the store of the webhook dispatcher too
+type WebhookRepository struct {
+	logger *zap.Logger
+	db     *sql.DB
+}
+
+func NewWebhookRepo(db *sql.DB, logger *zap.Logger) *WebhookRepository {
+
+	return &WebhookRepository{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (r *WebhookRepository) Create(ctx context.Context, hook domain.Webhook) error {
+	eventTypes, err := json.Marshal(nonNil(hook.EventTypes))
+
+	if err != nil {
+		return fmt.Errorf("WebhookRepository.Create %w", err)
+	}
+
+	_, err = sqltx.Conn(ctx, r.db).ExecContext(ctx, "insert into webhooks (id, seller_id, url, secret, event_types, active, created_at, updated_at) values (?, ?, ?, ?, ?, ?, ?, ?)",
+		hook.ID, hook.SellerID, hook.URL, hook.Secret, eventTypes, hook.Active, hook.CreatedAt, hook.UpdatedAt)
+
+	if err != nil {
+		r.logger.Error("WebhookRepository.Create failed", zap.Error(err))
+		return fmt.Errorf("WebhookRepository.Create %w", err)
+	}
+
+	return nil
+}
+
+// Find returns sql.ErrNoRows when there is no such webhook
+func (r *WebhookRepository) Find(ctx context.Context, id string) (domain.Webhook, error) {
+	hook, err := scanWebhook(sqltx.Conn(ctx, r.db).QueryRowContext(ctx, "select "+webhookColumns+" from webhooks where id = ?", id))
+
+	if err != nil {
+		return domain.Webhook{}, fmt.Errorf("WebhookRepository.Find %w", err)
+	}
+
+	return hook, nil
+}
+
+func (r *WebhookRepository) FindBySeller(ctx context.Context, sellerID string) ([]domain.Webhook, error) {
+	rows, err := sqltx.Conn(ctx, r.db).QueryContext(ctx, "select "+webhookColumns+" from webhooks where seller_id = ? order by created_at", sellerID)
+
+	if err != nil {
+		return nil, fmt.Errorf("WebhookRepository.FindBySeller %w", err)
+	}
+	defer rows.Close()
+
+	var hooks []domain.Webhook
+
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+
+		if err != nil {
+			return nil, fmt.Errorf("WebhookRepository.FindBySeller %w", err)
+		}
+
+		hooks = append(hooks, hook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("WebhookRepository.FindBySeller %w", err)
+	}
+
+	return hooks, nil
+}
+
+// Update saves the url, event types and whether the webhook is active, enabling it clears its failures
+func (r *WebhookRepository) Update(ctx context.Context, hook domain.Webhook) error {
+	eventTypes, err := json.Marshal(nonNil(hook.EventTypes))
+
+	if err != nil {
+		return fmt.Errorf("WebhookRepository.Update %w", err)
+	}
+
+	_, err = sqltx.Conn(ctx, r.db).ExecContext(ctx, `update webhooks set url = ?, event_types = ?, updated_at = ?,
+		failures = if(? and not active, 0, failures), disabled_at = if(?, null, coalesce(disabled_at, ?)), active = ? where id = ?`,
+		hook.URL, eventTypes, hook.UpdatedAt, hook.Active, hook.Active, hook.UpdatedAt, hook.Active, hook.ID)
+
+	if err != nil {
+		return fmt.Errorf("WebhookRepository.Update %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes the webhook with its delivery log
+func (r *WebhookRepository) Delete(ctx context.Context, id string) error {
+	if _, err := sqltx.Conn(ctx, r.db).ExecContext(ctx, "delete from webhooks where id = ?", id); err != nil {
+		return fmt.Errorf("WebhookRepository.Delete %w", err)
+	}
+
+	return nil
+}
+
+// Enqueue adds the deliveries due now. A delivery of an event the webhook already has is skipped, replays
+// are always added
+func (r *WebhookRepository) Enqueue(ctx context.Context, deliveries ...domain.WebhookDelivery) error {
+	for _, delivery := range deliveries {
+		var dedupe, replayOf interface{}
+
+		if delivery.ReplayOf == "" {
+			dedupe = delivery.EventID
+		} else {
+			replayOf = delivery.ReplayOf
+		}
+
+		_, err := sqltx.Conn(ctx, r.db).ExecContext(ctx, `insert ignore into webhook_deliveries
+			(id, webhook_id, event_id, event_type, payload, status, next_attempt_at, replay_of, dedupe, created_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			delivery.ID, delivery.WebhookID, delivery.EventID, delivery.EventType, []byte(delivery.Payload), domain.DeliveryPending,
+			delivery.CreatedAt, replayOf, dedupe, delivery.CreatedAt)
+
+		if err != nil {
+			return fmt.Errorf("WebhookRepository.Enqueue %s to %s %w", delivery.EventID, delivery.WebhookID, err)
+		}
+	}
+
+	return nil
+}
+
+// Deliveries returns the log of the webhook, latest first
+func (r *WebhookRepository) Deliveries(ctx context.Context, filter domain.DeliveryFilter) ([]domain.WebhookDelivery, error) {
+	q := "select " + deliveryColumns + " from webhook_deliveries where webhook_id = ?"
+	args := []interface{}{filter.WebhookID}
+
+	if filter.Status != "" {
+		q += " and status = ?"
+		args = append(args, filter.Status)
+	}
+
+	q += " order by created_at desc limit ?"
+	args = append(args, filter.Limit)
+
+	rows, err := sqltx.Conn(ctx, r.db).QueryContext(ctx, q, args...)
+
+	if err != nil {
+		return nil, fmt.Errorf("WebhookRepository.Deliveries %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []domain.WebhookDelivery
+
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+
+		if err != nil {
+			return nil, fmt.Errorf("WebhookRepository.Deliveries %w", err)
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("WebhookRepository.Deliveries %w", err)
+	}
+
+	return deliveries, nil
+}
+
+// FindDelivery returns sql.ErrNoRows when the webhook has no such delivery
+func (r *WebhookRepository) FindDelivery(ctx context.Context, webhookID, id string) (domain.WebhookDelivery, error) {
+	delivery, err := scanDelivery(sqltx.Conn(ctx, r.db).QueryRowContext(ctx, "select "+deliveryColumns+" from webhook_deliveries where webhook_id = ? and id = ?", webhookID, id))
+
+	if err != nil {
+		return domain.WebhookDelivery{}, fmt.Errorf("WebhookRepository.FindDelivery %w", err)
+	}
+
+	return delivery, nil
+}
+
+// ReplaySince delivers again the events the webhook got since the time, whatever became of them, and
+// returns how many
+func (r *WebhookRepository) ReplaySince(ctx context.Context, webhookID string, since time.Time) (int, error) {
+	now := time.Now().UTC()
+
+	res, err := sqltx.Conn(ctx, r.db).ExecContext(ctx, `insert into webhook_deliveries
+		(id, webhook_id, event_id, event_type, payload, status, next_attempt_at, replay_of, created_at)
+		select uuid(), webhook_id, event_id, event_type, payload, ?, ?, id, ? from webhook_deliveries
+		where webhook_id = ? and replay_of is null and created_at >= ? order by created_at`,
+		domain.DeliveryPending, now, now, webhookID, since.UTC())
+
+	if err != nil {
+		return 0, fmt.Errorf("WebhookRepository.ReplaySince %w", err)
+	}
+
+	replayed, err := res.RowsAffected()
+
+	if err != nil {
+		return 0, fmt.Errorf("WebhookRepository.ReplaySince %w", err)
+	}
+
+	return int(replayed), nil
+}
+
+// Claim pushes the next attempt of the deliveries it returns past the lease, a dispatcher that dies with
+// them leaves them to the others once it expires
+func (r *WebhookRepository) Claim(ctx context.Context, limit int, lease time.Duration) ([]webhook.Pending, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("WebhookRepository.Claim %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	rows, err := tx.QueryContext(ctx, `select d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.attempts, w.url, w.secret
+		from webhook_deliveries d join webhooks w on w.id = d.webhook_id
+		where d.status = ? and d.next_attempt_at <= ? and w.active order by d.next_attempt_at limit ? for update of d skip locked`,
+		domain.DeliveryPending, now, limit)
+
+	if err != nil {
+		return nil, fmt.Errorf("WebhookRepository.Claim %w", err)
+	}
+
+	var claimed []webhook.Pending
+	var ids []interface{}
+
+	for rows.Next() {
+		var pending webhook.Pending
+		var payload []byte
+
+		if err = rows.Scan(&pending.ID, &pending.WebhookID, &pending.EventID, &pending.EventType, &payload, &pending.Attempts, &pending.URL, &pending.Secret); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("WebhookRepository.Claim %w", err)
+		}
+
+		pending.Payload = payload
+		pending.Status = domain.DeliveryPending
+		claimed = append(claimed, pending)
+		ids = append(ids, pending.ID)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("WebhookRepository.Claim %w", err)
+	}
+
+	if len(claimed) == 0 {
+		return nil, nil
+	}
+
+	args := append([]interface{}{now.Add(lease)}, ids...)
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("update webhook_deliveries set next_attempt_at = ? where id in (%s)", placeholders(len(ids))), args...); err != nil {
+		return nil, fmt.Errorf("WebhookRepository.Claim %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("WebhookRepository.Claim %w", err)
+	}
+
+	return claimed, nil
+}
+
+// Succeeded marks the delivery delivered and clears the failures of its webhook
+func (r *WebhookRepository) Succeeded(ctx context.Context, id string, statusCode int) error {
+	now := time.Now().UTC()
+
+	_, err := r.db.ExecContext(ctx, `update webhook_deliveries d join webhooks w on w.id = d.webhook_id
+		set d.status = ?, d.attempts = d.attempts + 1, d.last_status_code = ?, d.last_error = null, d.delivered_at = ?, w.failures = 0
+		where d.id = ?`, domain.DeliverySucceeded, statusCode, now, id)
+
+	if err != nil {
+		return fmt.Errorf("WebhookRepository.Succeeded %w", err)
+	}
+
+	return nil
+}
+
+func (r *WebhookRepository) Retry(ctx context.Context, id string, statusCode int, reason string, at time.Time) error {
+	_, err := r.db.ExecContext(ctx, "update webhook_deliveries set attempts = attempts + 1, last_status_code = ?, last_error = ?, next_attempt_at = ? where id = ?",
+		statusCode, reason, at.UTC(), id)
+
+	if err != nil {
+		return fmt.Errorf("WebhookRepository.Retry %w", err)
+	}
+
+	return nil
+}
+
+func (r *WebhookRepository) Fail(ctx context.Context, id string, statusCode int, reason string, disableAfter int) (bool, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return false, fmt.Errorf("WebhookRepository.Fail %w", err)
+	}
+	defer tx.Rollback()
+
+	var webhookID string
+
+	if err = tx.QueryRowContext(ctx, "select webhook_id from webhook_deliveries where id = ? for update", id).Scan(&webhookID); err != nil {
+		return false, fmt.Errorf("WebhookRepository.Fail %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "update webhook_deliveries set status = ?, attempts = attempts + 1, last_status_code = ?, last_error = ? where id = ?",
+		domain.DeliveryFailed, statusCode, reason, id)
+
+	if err != nil {
+		return false, fmt.Errorf("WebhookRepository.Fail %w", err)
+	}
+
+	// the assignments are made left to right, disabled_at and active see the new failures
+	_, err = tx.ExecContext(ctx, `update webhooks set failures = failures + 1,
+		disabled_at = if(active and failures >= ?, ?, disabled_at), active = active and failures < ? where id = ?`,
+		disableAfter, time.Now().UTC(), disableAfter, webhookID)
+
+	if err != nil {
+		return false, fmt.Errorf("WebhookRepository.Fail %w", err)
+	}
+
+	var active bool
+
+	if err = tx.QueryRowContext(ctx, "select active from webhooks where id = ?", webhookID).Scan(&active); err != nil {
+		return false, fmt.Errorf("WebhookRepository.Fail %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("WebhookRepository.Fail %w", err)
+	}
+
+	if !active {
+		r.logger.Warn("WebhookRepository.Fail webhook disabled after failed deliveries", zap.String("webhookId", webhookID))
+	}
+
+	return !active, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWebhook(row scanner) (domain.Webhook, error) {
+	var hook domain.Webhook
+	var eventTypes []byte
+	var disabledAt sql.NullTime
+
+	err := row.Scan(&hook.ID, &hook.SellerID, &hook.URL, &hook.Secret, &eventTypes, &hook.Active, &hook.Failures, &disabledAt, &hook.CreatedAt, &hook.UpdatedAt)
+
+	if err != nil {
+		return domain.Webhook{}, err
+	}
+
+	if err = json.Unmarshal(eventTypes, &hook.EventTypes); err != nil {
+		return domain.Webhook{}, fmt.Errorf("malformed event types of %s %w", hook.ID, err)
+	}
+
+	if disabledAt.Valid {
+		hook.DisabledAt = &disabledAt.Time
+	}
+
+	return hook, nil
+}
+
+func scanDelivery(row scanner) (domain.WebhookDelivery, error) {
+	var delivery domain.WebhookDelivery
+	var payload []byte
+	var deliveredAt sql.NullTime
+
+	err := row.Scan(&delivery.ID, &delivery.WebhookID, &delivery.EventID, &delivery.EventType, &payload, &delivery.Status, &delivery.Attempts,
+		&delivery.NextAttemptAt, &delivery.LastStatusCode, &delivery.LastError, &delivery.ReplayOf, &delivery.CreatedAt, &deliveredAt)
+
+	if err != nil {
+		return domain.WebhookDelivery{}, err
+	}
+
+	delivery.Payload = payload
+
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+
+	return delivery, nil
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+
+	return values
+}
+
+func placeholders(n int) string {
+
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
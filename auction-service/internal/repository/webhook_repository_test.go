@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestWebhookRepo_Fail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	r := NewWebhookRepo(db, zaptest.NewLogger(t))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select webhook_id from webhook_deliveries where id = ? for update")).
+		WithArgs("delivery-id").
+		WillReturnRows(sqlmock.NewRows([]string{"webhook_id"}).AddRow("webhook-id"))
+	mock.ExpectExec(regexp.QuoteMeta("update webhook_deliveries set status = ?")).
+		WithArgs("failed", 500, "endpoint responded 500", "delivery-id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("update webhooks set failures = failures + 1")).
+		WithArgs(5, sqlmock.AnyArg(), 5, "webhook-id").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("select active from webhooks where id = ?")).
+		WithArgs("webhook-id").
+		WillReturnRows(sqlmock.NewRows([]string{"active"}).AddRow(false))
+	mock.ExpectCommit()
+
+	disabled, err := r.Fail(context.Background(), "delivery-id", 500, "endpoint responded 500", 5)
+	require.NoError(t, err)
+	require.True(t, disabled)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"mime/multipart"
 	"os"
@@ -13,12 +14,16 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/ireuven89/auctions/auction-service/domain"
 	"github.com/ireuven89/auctions/shared/config"
+	"github.com/ireuven89/auctions/shared/events"
+	http2 "github.com/ireuven89/auctions/shared/http"
+	"github.com/ireuven89/auctions/shared/messaging"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
 type Repository interface {
+	// Find locks the auction until the transaction of the context ends, when there is one
 	Find(ctx context.Context, id string) (domain.Auction, error)
 	FindAll(ctx context.Context, request domain.AuctionRequest) ([]domain.Auction, error)
 	Update(ctx context.Context, auction domain.AuctionRequest) error
@@ -45,19 +50,40 @@ type ItemPictureRepository interface {
 }
 type BidRepository interface {
 	Find(ctx context.Context, id string) (domain.Bid, error)
+	// Highest returns sql.ErrNoRows when nobody bid on the auction
+	Highest(ctx context.Context, auctionID string) (domain.Bid, error)
+	HasBid(ctx context.Context, auctionID, bidderID string) (bool, error)
 	Create(ctx context.Context, bid domain.Bid) error
 }
 
+// Transactor runs fn in a transaction, the repositories called with the context it gets join the transaction
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Outbox keeps the events for publishing, written in the transaction of the change they describe
+type Outbox interface {
+	Add(ctx context.Context, aggregateID, topic string, envelope messaging.Envelope) error
+}
+
+// HistoryRepository keeps the changes of every auction, appended in the transaction of the change
+type HistoryRepository interface {
+	Append(ctx context.Context, entry domain.HistoryEntry) error
+	List(ctx context.Context, auctionID string, until time.Time) ([]domain.HistoryEntry, error)
+}
+
 type Service interface {
 	Fetch(ctx context.Context, id string) (*domain.Auction, error)
 	Search(ctx context.Context, request domain.AuctionRequest) ([]domain.Auction, error)
 	Update(ctx context.Context, auction domain.AuctionRequest) error
 	Create(ctx context.Context, auction domain.AuctionRequest) (string, error)
 	CreateAuctionItems(ctx context.Context, auctionId string, items []domain.Item) error
+	FindAuctionItems(ctx context.Context, auctionId string) ([]domain.Item, error)
 	CreateAuctionPictures(ctx context.Context, id string, request []*multipart.FileHeader) error
 	Delete(ctx context.Context, id string) error
 	DeleteMany(ctx context.Context, ids []string) error
 	PlaceBid(ctx context.Context, bid domain.PlaceBidRequest) error
+	History(ctx context.Context, id string, asOf time.Time) (domain.AuctionHistory, error)
 }
 
 type AuctionService struct {
@@ -65,16 +91,23 @@ type AuctionService struct {
 	itemRepo        ItemRepository
 	itemPictureRepo ItemPictureRepository
 	bidRepo         BidRepository
+	tx              Transactor
+	outbox          Outbox
+	history         HistoryRepository
 	logger          *zap.Logger
 	awsConfig       config.AWSConfig
 }
 
-func NewService(repo Repository, itemRepo ItemRepository, logger *zap.Logger) Service {
+func NewService(repo Repository, itemRepo ItemRepository, bidRepo BidRepository, tx Transactor, outbox Outbox, history HistoryRepository, logger *zap.Logger) Service {
 
 	return &AuctionService{
 		logger:   logger,
 		repo:     repo,
 		itemRepo: itemRepo,
+		bidRepo:  bidRepo,
+		tx:       tx,
+		outbox:   outbox,
+		history:  history,
 	}
 }
 
@@ -104,9 +137,133 @@ func (s *AuctionService) Search(ctx context.Context, request domain.AuctionReque
 	return res, nil
 }
 
+// authorizeSeller lets the members of the selling organization who manage listings through, and services.
+// Calls without claims didn't come through the API and are trusted
+func authorizeSeller(ctx context.Context, sellerID string) error {
+	claims, ok := http2.ClaimsFromContext(ctx)
+
+	if !ok || http2.IsServiceToken(claims) {
+		return nil
+	}
+
+	org, ok := http2.OrganizationOf(claims)
+
+	if !ok || org.ID != sellerID || !org.CanManageListings() {
+		return fmt.Errorf("not allowed to manage the listings of seller %s %w", sellerID, domain.ErrForbidden)
+	}
+
+	return nil
+}
+
+// authorizeAuction checks the caller may manage the auction, see authorizeSeller
+func (s *AuctionService) authorizeAuction(ctx context.Context, id string) error {
+	if _, ok := http2.ClaimsFromContext(ctx); !ok {
+		return nil
+	}
+
+	_, err := s.managedAuction(ctx, id)
+
+	return err
+}
+
+// authorizeHistory lets the members of the selling organization read the history of the auction, and for
+// disputes the bidders who bid on it and support staff, admins and admins impersonating a user. Services need
+// the auctions:read scope
+func (s *AuctionService) authorizeHistory(ctx context.Context, id string) error {
+	claims, ok := http2.ClaimsFromContext(ctx)
+
+	if !ok || http2.IsServiceToken(claims) || http2.IsAdmin(claims) {
+		return nil
+	}
+
+	if _, impersonating := http2.Actor(claims); impersonating {
+		return nil
+	}
+
+	auction, err := s.repo.Find(ctx, id)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.ErrNotFound
+		}
+
+		return err
+	}
+
+	if org, ok := http2.OrganizationOf(claims); ok && org.ID == auction.SellerID {
+		return nil
+	}
+
+	bidderID, _ := claims["sub"].(string)
+
+	if bidderID != "" {
+		bid, err := s.bidRepo.HasBid(ctx, id, bidderID)
+
+		if err != nil {
+			return err
+		}
+
+		if bid {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("not allowed to read the history of auction %s %w", id, domain.ErrForbidden)
+}
+
+// managedAuction finds the auction when the caller may manage it, see authorizeSeller
+func (s *AuctionService) managedAuction(ctx context.Context, id string) (domain.Auction, error) {
+	auction, err := s.repo.Find(ctx, id)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Auction{}, domain.ErrNotFound
+		}
+
+		return domain.Auction{}, err
+	}
+
+	if err = authorizeSeller(ctx, auction.SellerID); err != nil {
+		return domain.Auction{}, err
+	}
+
+	return auction, nil
+}
+
+// Update checks the caller may manage the auction within the transaction of the update, the auction stays
+// locked from the check to the change. The current bid and the winner follow from the bids, the caller
+// can't set them
 func (s *AuctionService) Update(ctx context.Context, auction domain.AuctionRequest) error {
 	auction.UpdatedAt = time.Now()
-	if err := s.repo.Update(ctx, auction); err != nil {
+	auction.CurrentBid, auction.WinnerId = 0, ""
+
+	err := s.ExecuteInTransaction(ctx, func(txCtx context.Context) error {
+		if err := s.authorizeAuction(txCtx, auction.ID); err != nil {
+			return err
+		}
+
+		if auction.Status == domain.Completed.String() {
+			winner, err := s.winner(txCtx, auction.ID)
+
+			if err != nil {
+				return err
+			}
+
+			auction.WinnerId = winner
+		}
+
+		if err := s.repo.Update(txCtx, auction); err != nil {
+			return err
+		}
+
+		if err := s.record(txCtx, updateEntry(auction)); err != nil {
+			return err
+		}
+
+		return s.emitUpdate(txCtx, auction)
+	})
+
+	if err != nil {
 		s.logger.Error("AuctionService failed to update auction", zap.Error(err))
 		return fmt.Errorf("AuctionService.Update failed updating %w", err)
 	}
@@ -114,12 +271,143 @@ func (s *AuctionService) Update(ctx context.Context, auction domain.AuctionReque
 	return nil
 }
 
+// winner is the bidder of the highest bid on the auction, none when nobody bid
+func (s *AuctionService) winner(ctx context.Context, auctionID string) (string, error) {
+	bid, err := s.bidRepo.Highest(ctx, auctionID)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return bid.BidderID, nil
+}
+
+// emitUpdate tells closing and cancelling apart from other changes, consumers mostly care about those. The
+// update only carries what it changes, the closing is told from the auction as stored
+func (s *AuctionService) emitUpdate(ctx context.Context, auction domain.AuctionRequest) error {
+	switch auction.Status {
+	case domain.Completed.String():
+		stored, err := s.repo.Find(ctx, auction.ID)
+
+		if err != nil {
+			return err
+		}
+
+		return s.emit(ctx, auction.ID, events.TopicAuctionClosed, events.TypeAuctionClosed, events.AuctionClosed{
+			AuctionID: stored.ID,
+			WinnerID:  stored.WinnerID,
+			FinalBid:  stored.CurrentBid,
+			ClosedAt:  auction.UpdatedAt.UTC(),
+		})
+	case domain.Cancelled.String():
+		return s.emit(ctx, auction.ID, events.TopicAuctionCancelled, events.TypeAuctionCancelled, events.AuctionCancelled{
+			AuctionID:   auction.ID,
+			CancelledAt: auction.UpdatedAt.UTC(),
+		})
+	default:
+		return s.emit(ctx, auction.ID, events.TopicAuctionUpdated, events.TypeAuctionUpdated, events.AuctionUpdated{
+			AuctionID:   auction.ID,
+			Description: auction.Description,
+			Status:      auction.Status,
+			UpdatedAt:   auction.UpdatedAt.UTC(),
+		})
+	}
+}
+
+// updateEntry records the fields the update sets, see the update query of the repository
+func updateEntry(auction domain.AuctionRequest) domain.HistoryEntry {
+	entry := domain.HistoryEntry{AuctionID: auction.ID, Type: domain.HistoryEdited, OccurredAt: auction.UpdatedAt}
+
+	if auction.Description != "" {
+		entry.Changes.Description = &auction.Description
+	}
+	if len(auction.Regions) != 0 {
+		entry.Changes.Regions = auction.Regions
+	}
+	if auction.CurrentBid != 0 {
+		entry.Changes.CurrentBid = &auction.CurrentBid
+	}
+	if auction.WinnerId != "" {
+		entry.Changes.WinnerID = &auction.WinnerId
+	}
+
+	if auction.Status != "" {
+		entry.Changes.Status = &auction.Status
+
+		switch auction.Status {
+		case domain.Completed.String():
+			entry.Type = domain.HistoryClosed
+		case domain.Cancelled.String():
+			entry.Type = domain.HistoryCancelled
+		default:
+			entry.Type = domain.HistoryStatusChanged
+		}
+	}
+
+	return entry
+}
+
+// record appends the change to the history of the auction with who made it, it has to be called within
+// the transaction of the change
+func (s *AuctionService) record(ctx context.Context, entry domain.HistoryEntry) error {
+	if identities, ok := http2.IdentitiesFromContext(ctx); ok {
+		entry.Actor = identities.Subject
+		entry.Impersonator = identities.Actor
+	}
+
+	return s.history.Append(ctx, entry)
+}
+
+// History returns the changes of the auction up to asOf, now when zero, and the auction as it was then
+func (s *AuctionService) History(ctx context.Context, id string, asOf time.Time) (domain.AuctionHistory, error) {
+	if err := s.authorizeHistory(ctx, id); err != nil {
+		return domain.AuctionHistory{}, fmt.Errorf("AuctionService.History %w", err)
+	}
+
+	if asOf.IsZero() {
+		asOf = time.Now()
+	}
+
+	entries, err := s.history.List(ctx, id, asOf)
+
+	if err != nil {
+		s.logger.Error("AuctionService.History failed listing history", zap.Error(err), zap.String("id", id))
+		return domain.AuctionHistory{}, fmt.Errorf("AuctionService.History %w", err)
+	}
+
+	state, ok := domain.ReplayHistory(entries, asOf)
+
+	if !ok {
+		return domain.AuctionHistory{}, fmt.Errorf("AuctionService.History auction %s did not exist at %s %w", id, asOf.Format(time.RFC3339), domain.ErrNotFound)
+	}
+
+	return domain.AuctionHistory{Entries: entries, State: state}, nil
+}
+
+// emit adds an event to the outbox, it has to be called within the transaction of the change
+func (s *AuctionService) emit(ctx context.Context, auctionID, topic, eventType string, payload interface{}) error {
+	envelope, err := events.NewEnvelope(ctx, eventType, payload)
+
+	if err != nil {
+		return err
+	}
+
+	return s.outbox.Add(ctx, auctionID, topic, envelope)
+}
+
 func (s *AuctionService) CreateAuctionItems(ctx context.Context, auctionId string, items []domain.Item) error {
+	if err := s.authorizeAuction(ctx, auctionId); err != nil {
+		return fmt.Errorf("AuctionService.CreateAuctionItems %w", err)
+	}
 
-	for _, item := range items {
-		item.ID = generateID()
-		item.AuctionID = auctionId
-		item.CreatedAt = time.Now()
+	for i := range items {
+		items[i].ID = generateID()
+		items[i].AuctionID = auctionId
+		items[i].CreatedAt = time.Now()
 	}
 
 	if err := s.itemRepo.CreateBulk(ctx, items); err != nil {
@@ -135,11 +423,58 @@ func (s *AuctionService) Create(ctx context.Context, auction domain.AuctionReque
 		return "", domain.ErrBadRequest
 	}
 
+	// members list for their active organization unless they name it
+	if org, ok := http2.OrganizationFromContext(ctx); ok && auction.SellerId == "" {
+		auction.SellerId = org.ID
+	}
+
+	if err := authorizeSeller(ctx, auction.SellerId); err != nil {
+		return "", fmt.Errorf("AuctionService.Create %w", err)
+	}
+
 	auction.ID = generateID()
+	if auction.Status == "" {
+		auction.Status = domain.Pending.String()
+	}
 	auction.CreatedAt = time.Now()
 	auction.UpdatedAt = time.Now()
 
-	if err := s.repo.Create(ctx, auction); err != nil {
+	err := s.ExecuteInTransaction(ctx, func(txCtx context.Context) error {
+		if err := s.repo.Create(txCtx, auction); err != nil {
+			return err
+		}
+
+		initialOffer, minIncrement := float64(auction.InitialOffer), float64(auction.MinIncrement)
+		err := s.record(txCtx, domain.HistoryEntry{
+			AuctionID: auction.ID,
+			Type:      domain.HistoryCreated,
+			Changes: domain.AuctionChanges{
+				Description:  &auction.Description,
+				SellerID:     &auction.SellerId,
+				Regions:      auction.Regions,
+				InitialOffer: &initialOffer,
+				MinIncrement: &minIncrement,
+				Status:       &auction.Status,
+			},
+			OccurredAt: auction.CreatedAt,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return s.emit(txCtx, auction.ID, events.TopicAuctionCreated, events.TypeAuctionCreated, events.AuctionCreated{
+			AuctionID:    auction.ID,
+			SellerID:     auction.SellerId,
+			Description:  auction.Description,
+			InitialOffer: auction.InitialOffer,
+			MinIncrement: auction.MinIncrement,
+			Status:       auction.Status,
+			CreatedAt:    auction.CreatedAt.UTC(),
+		})
+	})
+
+	if err != nil {
 		s.logger.Error("AuctionService.Failed to create auction ", zap.Error(err))
 		return "", fmt.Errorf("AuctionService.Create failed creating %w", err)
 	}
@@ -157,9 +492,24 @@ func (s *AuctionService) validateAuction(auction domain.AuctionRequest) bool {
 	return auction.Description != "" && auction.InitialOffer != 0 && auction.MinIncrement != 0
 }
 
+// Delete deletes the auction, its history keeps the deletion and AuctionDeleted tells the consumers
 func (s *AuctionService) Delete(ctx context.Context, id string) error {
+	deletedAt := time.Now()
+	err := s.ExecuteInTransaction(ctx, func(txCtx context.Context) error {
+		auction, err := s.managedAuction(txCtx, id)
+
+		if err != nil {
+			return err
+		}
+
+		if err = s.repo.Delete(txCtx, id); err != nil {
+			return err
+		}
+
+		return s.recordDeletion(txCtx, auction, deletedAt)
+	})
 
-	if err := s.repo.Delete(ctx, id); err != nil {
+	if err != nil {
 		s.logger.Error("AuctionService.Delete failed deleting bidder", zap.Error(err))
 		return fmt.Errorf("AuctionService.Delete failed deleting %w", err)
 	}
@@ -167,14 +517,38 @@ func (s *AuctionService) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
+// DeleteMany deletes the auctions in one transaction, none of them unless the caller may manage every one
 func (s *AuctionService) DeleteMany(ctx context.Context, ids []string) error {
-	var vals []interface{}
+	deletedAt := time.Now()
+	err := s.ExecuteInTransaction(ctx, func(txCtx context.Context) error {
+		var vals []interface{}
+		var auctions []domain.Auction
 
-	for _, id := range ids {
-		vals = append(vals, id)
-	}
+		for _, id := range ids {
+			auction, err := s.managedAuction(txCtx, id)
+
+			if err != nil {
+				return err
+			}
+
+			vals = append(vals, id)
+			auctions = append(auctions, auction)
+		}
+
+		if err := s.repo.DeleteMany(txCtx, vals); err != nil {
+			return err
+		}
+
+		for _, auction := range auctions {
+			if err := s.recordDeletion(txCtx, auction, deletedAt); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 
-	if err := s.repo.DeleteMany(ctx, vals); err != nil {
+	if err != nil {
 		s.logger.Error("AuctionService.DeleteMany failed deleting ids", zap.Error(err))
 		return fmt.Errorf("AuctionService.DeleteMany failed deleting %w", err)
 	}
@@ -182,6 +556,20 @@ func (s *AuctionService) DeleteMany(ctx context.Context, ids []string) error {
 	return nil
 }
 
+// recordDeletion appends the deletion to the history of the auction and emits AuctionDeleted, it has to be
+// called within the transaction of the deletion
+func (s *AuctionService) recordDeletion(ctx context.Context, auction domain.Auction, deletedAt time.Time) error {
+	if err := s.record(ctx, domain.HistoryEntry{AuctionID: auction.ID, Type: domain.HistoryDeleted, OccurredAt: deletedAt}); err != nil {
+		return err
+	}
+
+	return s.emit(ctx, auction.ID, events.TopicAuctionDeleted, events.TypeAuctionDeleted, events.AuctionDeleted{
+		AuctionID: auction.ID,
+		SellerID:  auction.SellerID,
+		DeletedAt: deletedAt.UTC(),
+	})
+}
+
 func (s *AuctionService) CreateAuctionPictures(ctx context.Context, itemId string, files []*multipart.FileHeader) error {
 	downloadUrlChannel := make(chan string, len(files))
 	var wg *sync.WaitGroup
@@ -218,8 +606,14 @@ func (s *AuctionService) FindAuctionItems(ctx context.Context, auctionId string)
 
 func (s *AuctionService) PlaceBid(ctx context.Context, req domain.PlaceBidRequest) error {
 	return s.ExecuteInTransaction(ctx, func(txCtx context.Context) error {
-		// 1. Get auction (delegates to repository)
-		auction, err := s.repo.Find(ctx, req.AuctionID)
+		auction, err := s.repo.Find(txCtx, req.AuctionID)
+
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return domain.ErrNotFound
+			}
+			return err
+		}
 
 		if err = s.validateAuctionForBidding(auction); err != nil {
 			return err
@@ -229,7 +623,6 @@ func (s *AuctionService) PlaceBid(ctx context.Context, req domain.PlaceBidReques
 			return err
 		}
 
-		// All database operations delegated to repositories
 		bid := domain.Bid{
 			ID:        generateID(),
 			AuctionID: auction.ID,
@@ -238,22 +631,41 @@ func (s *AuctionService) PlaceBid(ctx context.Context, req domain.PlaceBidReques
 			BidderID:  req.BidderID,
 			Price:     req.Amount,
 		}
-		if err = s.bidRepo.Create(ctx, bid); err != nil {
+		if err = s.bidRepo.Create(txCtx, bid); err != nil {
 			return err
 		}
 		auction.CurrentBid = req.Amount
 		auctionUpdate := domain.AuctionRequest{ID: auction.ID, CurrentBid: req.Amount}
-		if err = s.repo.Update(ctx, auctionUpdate); err != nil {
+		if err = s.repo.Update(txCtx, auctionUpdate); err != nil {
 			return err
 		}
 
-		return nil
+		err = s.record(txCtx, domain.HistoryEntry{
+			AuctionID:  auction.ID,
+			Type:       domain.HistoryBidPlaced,
+			Changes:    domain.AuctionChanges{CurrentBid: &bid.Price},
+			Bid:        &domain.HistoryBid{ID: bid.ID, BidderID: bid.BidderID, Amount: bid.Price},
+			OccurredAt: bid.CreateAt,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return s.emit(txCtx, auction.ID, events.TopicBidPlaced, events.TypeBidPlaced, events.BidPlaced{
+			BidID:     bid.ID,
+			AuctionID: auction.ID,
+			BidderID:  bid.BidderID,
+			Amount:    bid.Price,
+			PlacedAt:  bid.CreateAt.UTC(),
+		})
 	})
 }
 
+// ExecuteInTransaction runs txFunc in a transaction, the outbox events it adds are only kept if it commits
 func (s *AuctionService) ExecuteInTransaction(ctx context.Context, txFunc func(txCtx context.Context) error) error {
 
-	return nil
+	return s.tx.WithinTransaction(ctx, txFunc)
 }
 
 func (s *AuctionService) validateAuctionForBidding(auction domain.Auction) error {
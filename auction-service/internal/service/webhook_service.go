@@ -0,0 +1,379 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/ireuven89/auctions/auction-service/domain"
+	"github.com/ireuven89/auctions/auction-service/internal/webhook"
+	"github.com/ireuven89/auctions/shared/events"
+	http2 "github.com/ireuven89/auctions/shared/http"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 200
+)
+
+// WebhookEventTypes are the auction events sellers may have delivered to their webhooks
+var WebhookEventTypes = []string{
+	events.TypeAuctionCreated,
+	events.TypeAuctionUpdated,
+	events.TypeBidPlaced,
+	events.TypeAuctionClosed,
+	events.TypeAuctionCancelled,
+	events.TypeAuctionDeleted,
+}
+
+type WebhookRepository interface {
+	Create(ctx context.Context, hook domain.Webhook) error
+	Find(ctx context.Context, id string) (domain.Webhook, error)
+	FindBySeller(ctx context.Context, sellerID string) ([]domain.Webhook, error)
+	Update(ctx context.Context, hook domain.Webhook) error
+	Delete(ctx context.Context, id string) error
+	Enqueue(ctx context.Context, deliveries ...domain.WebhookDelivery) error
+	Deliveries(ctx context.Context, filter domain.DeliveryFilter) ([]domain.WebhookDelivery, error)
+	FindDelivery(ctx context.Context, webhookID, id string) (domain.WebhookDelivery, error)
+	ReplaySince(ctx context.Context, webhookID string, since time.Time) (int, error)
+}
+
+type WebhookService interface {
+	RegisterWebhook(ctx context.Context, request domain.WebhookRequest) (domain.Webhook, error)
+	// Webhooks lists the webhooks of the seller, the active organization of the caller when empty
+	Webhooks(ctx context.Context, sellerID string) ([]domain.Webhook, error)
+	UpdateWebhook(ctx context.Context, request domain.WebhookRequest) (domain.Webhook, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	Deliveries(ctx context.Context, filter domain.DeliveryFilter) ([]domain.WebhookDelivery, error)
+	// ReplayDelivery posts the event of the delivery again as a new delivery, and returns it
+	ReplayDelivery(ctx context.Context, webhookID, deliveryID string) (domain.WebhookDelivery, error)
+	// ReplayDeliveries posts again every event the webhook got since the time, and returns how many
+	ReplayDeliveries(ctx context.Context, webhookID string, since time.Time) (int, error)
+	// EnqueueEvent delivers the auction event to the webhooks of the seller of the auction subscribed to it
+	EnqueueEvent(ctx context.Context, envelope messaging.Envelope) error
+}
+
+type WebhookManager struct {
+	hooks    WebhookRepository
+	auctions Repository
+	logger   *zap.Logger
+}
+
+func NewWebhookService(hooks WebhookRepository, auctions Repository, logger *zap.Logger) WebhookService {
+
+	return &WebhookManager{
+		hooks:    hooks,
+		auctions: auctions,
+		logger:   logger,
+	}
+}
+
+func (s *WebhookManager) RegisterWebhook(ctx context.Context, request domain.WebhookRequest) (domain.Webhook, error) {
+	if err := validateWebhook(ctx, request); err != nil {
+		return domain.Webhook{}, fmt.Errorf("WebhookManager.RegisterWebhook %w", err)
+	}
+
+	// members register for their active organization unless they name it
+	if org, ok := http2.OrganizationFromContext(ctx); ok && request.SellerID == "" {
+		request.SellerID = org.ID
+	}
+
+	if request.SellerID == "" {
+		return domain.Webhook{}, fmt.Errorf("WebhookManager.RegisterWebhook sellerId is required %w", domain.ErrBadRequest)
+	}
+
+	if err := authorizeSeller(ctx, request.SellerID); err != nil {
+		return domain.Webhook{}, fmt.Errorf("WebhookManager.RegisterWebhook %w", err)
+	}
+
+	secret, err := webhook.NewSecret()
+
+	if err != nil {
+		return domain.Webhook{}, fmt.Errorf("WebhookManager.RegisterWebhook %w", err)
+	}
+
+	now := time.Now().UTC()
+	hook := domain.Webhook{
+		ID:         generateID(),
+		SellerID:   request.SellerID,
+		URL:        request.URL,
+		Secret:     secret,
+		EventTypes: request.EventTypes,
+		Active:     true,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if err = s.hooks.Create(ctx, hook); err != nil {
+		s.logger.Error("WebhookManager.RegisterWebhook failed creating", zap.Error(err))
+		return domain.Webhook{}, fmt.Errorf("WebhookManager.RegisterWebhook %w", err)
+	}
+
+	return hook, nil
+}
+
+// validateWebhook only accepts https endpoints on public addresses and the event types webhooks deliver
+func validateWebhook(ctx context.Context, request domain.WebhookRequest) error {
+	endpoint, err := url.Parse(request.URL)
+
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return fmt.Errorf("url must be an absolute https url %w", domain.ErrBadRequest)
+	}
+
+	if err = webhook.CheckHost(ctx, endpoint.Hostname()); err != nil {
+		return fmt.Errorf("url %v %w", err, domain.ErrBadRequest)
+	}
+
+	for _, eventType := range request.EventTypes {
+		known := false
+		for _, t := range WebhookEventTypes {
+			known = known || t == eventType
+		}
+
+		if !known {
+			return fmt.Errorf("unknown event type %s %w", eventType, domain.ErrBadRequest)
+		}
+	}
+
+	return nil
+}
+
+func (s *WebhookManager) Webhooks(ctx context.Context, sellerID string) ([]domain.Webhook, error) {
+	if org, ok := http2.OrganizationFromContext(ctx); ok && sellerID == "" {
+		sellerID = org.ID
+	}
+
+	if sellerID == "" {
+		return nil, fmt.Errorf("WebhookManager.Webhooks sellerId is required %w", domain.ErrBadRequest)
+	}
+
+	if err := authorizeSeller(ctx, sellerID); err != nil {
+		return nil, fmt.Errorf("WebhookManager.Webhooks %w", err)
+	}
+
+	hooks, err := s.hooks.FindBySeller(ctx, sellerID)
+
+	if err != nil {
+		return nil, fmt.Errorf("WebhookManager.Webhooks %w", err)
+	}
+
+	return hooks, nil
+}
+
+// authorizeWebhook returns the webhook when the caller may manage the webhooks of its seller
+func (s *WebhookManager) authorizeWebhook(ctx context.Context, id string) (domain.Webhook, error) {
+	hook, err := s.hooks.Find(ctx, id)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.Webhook{}, domain.ErrNotFound
+		}
+
+		return domain.Webhook{}, err
+	}
+
+	if err = authorizeSeller(ctx, hook.SellerID); err != nil {
+		return domain.Webhook{}, err
+	}
+
+	return hook, nil
+}
+
+// UpdateWebhook changes the fields set, enabling a disabled webhook delivers what waited for it
+func (s *WebhookManager) UpdateWebhook(ctx context.Context, request domain.WebhookRequest) (domain.Webhook, error) {
+	hook, err := s.authorizeWebhook(ctx, request.ID)
+
+	if err != nil {
+		return domain.Webhook{}, fmt.Errorf("WebhookManager.UpdateWebhook %w", err)
+	}
+
+	if request.URL != "" {
+		hook.URL = request.URL
+	}
+
+	if request.EventTypes != nil {
+		hook.EventTypes = request.EventTypes
+	}
+
+	if err = validateWebhook(ctx, domain.WebhookRequest{URL: hook.URL, EventTypes: hook.EventTypes}); err != nil {
+		return domain.Webhook{}, fmt.Errorf("WebhookManager.UpdateWebhook %w", err)
+	}
+
+	hook.UpdatedAt = time.Now().UTC()
+
+	if request.Active != nil && *request.Active != hook.Active {
+		hook.Active = *request.Active
+		hook.DisabledAt = &hook.UpdatedAt
+
+		if hook.Active {
+			hook.Failures = 0
+			hook.DisabledAt = nil
+		}
+	}
+
+	if err = s.hooks.Update(ctx, hook); err != nil {
+		s.logger.Error("WebhookManager.UpdateWebhook failed updating", zap.Error(err))
+		return domain.Webhook{}, fmt.Errorf("WebhookManager.UpdateWebhook %w", err)
+	}
+
+	return hook, nil
+}
+
+func (s *WebhookManager) DeleteWebhook(ctx context.Context, id string) error {
+	if _, err := s.authorizeWebhook(ctx, id); err != nil {
+		return fmt.Errorf("WebhookManager.DeleteWebhook %w", err)
+	}
+
+	if err := s.hooks.Delete(ctx, id); err != nil {
+		return fmt.Errorf("WebhookManager.DeleteWebhook %w", err)
+	}
+
+	return nil
+}
+
+func (s *WebhookManager) Deliveries(ctx context.Context, filter domain.DeliveryFilter) ([]domain.WebhookDelivery, error) {
+	if _, err := s.authorizeWebhook(ctx, filter.WebhookID); err != nil {
+		return nil, fmt.Errorf("WebhookManager.Deliveries %w", err)
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = defaultDeliveriesLimit
+	}
+	filter.Limit = min(filter.Limit, maxDeliveriesLimit)
+
+	deliveries, err := s.hooks.Deliveries(ctx, filter)
+
+	if err != nil {
+		return nil, fmt.Errorf("WebhookManager.Deliveries %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func (s *WebhookManager) ReplayDelivery(ctx context.Context, webhookID, deliveryID string) (domain.WebhookDelivery, error) {
+	if _, err := s.authorizeWebhook(ctx, webhookID); err != nil {
+		return domain.WebhookDelivery{}, fmt.Errorf("WebhookManager.ReplayDelivery %w", err)
+	}
+
+	original, err := s.hooks.FindDelivery(ctx, webhookID, deliveryID)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return domain.WebhookDelivery{}, fmt.Errorf("WebhookManager.ReplayDelivery %w", domain.ErrNotFound)
+		}
+
+		return domain.WebhookDelivery{}, fmt.Errorf("WebhookManager.ReplayDelivery %w", err)
+	}
+
+	replay := domain.WebhookDelivery{
+		ID:        generateID(),
+		WebhookID: webhookID,
+		EventID:   original.EventID,
+		EventType: original.EventType,
+		Payload:   original.Payload,
+		Status:    domain.DeliveryPending,
+		ReplayOf:  original.ID,
+		CreatedAt: time.Now().UTC(),
+	}
+	replay.NextAttemptAt = replay.CreatedAt
+
+	if err = s.hooks.Enqueue(ctx, replay); err != nil {
+		return domain.WebhookDelivery{}, fmt.Errorf("WebhookManager.ReplayDelivery %w", err)
+	}
+
+	return replay, nil
+}
+
+func (s *WebhookManager) ReplayDeliveries(ctx context.Context, webhookID string, since time.Time) (int, error) {
+	if _, err := s.authorizeWebhook(ctx, webhookID); err != nil {
+		return 0, fmt.Errorf("WebhookManager.ReplayDeliveries %w", err)
+	}
+
+	replayed, err := s.hooks.ReplaySince(ctx, webhookID, since)
+
+	if err != nil {
+		return 0, fmt.Errorf("WebhookManager.ReplayDeliveries %w", err)
+	}
+
+	s.logger.Info("WebhookManager.ReplayDeliveries replaying", zap.String("webhookId", webhookID), zap.Int("count", replayed))
+
+	return replayed, nil
+}
+
+// auctionEvent is what the auction events have in common, only AuctionCreated names the seller
+type auctionEvent struct {
+	AuctionID string `json:"auctionId"`
+	SellerID  string `json:"sellerId"`
+}
+
+// EnqueueEvent may see an event more than once, the repository keeps one delivery of it per webhook
+func (s *WebhookManager) EnqueueEvent(ctx context.Context, envelope messaging.Envelope) error {
+	event, err := messaging.Payload[auctionEvent](envelope)
+
+	if err != nil || event.AuctionID == "" {
+		return messaging.Permanent(fmt.Errorf("WebhookManager.EnqueueEvent malformed %s %s %v", envelope.Type, envelope.ID, err))
+	}
+
+	if event.SellerID == "" {
+		auction, err := s.auctions.Find(ctx, event.AuctionID)
+
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				s.logger.Debug("WebhookManager.EnqueueEvent auction is gone", zap.String("auctionId", event.AuctionID), zap.String("id", envelope.ID))
+				return nil
+			}
+
+			return fmt.Errorf("WebhookManager.EnqueueEvent %w", err)
+		}
+
+		event.SellerID = auction.SellerID
+	}
+
+	hooks, err := s.hooks.FindBySeller(ctx, event.SellerID)
+
+	if err != nil {
+		return fmt.Errorf("WebhookManager.EnqueueEvent %w", err)
+	}
+
+	body, err := json.Marshal(envelope)
+
+	if err != nil {
+		return messaging.Permanent(fmt.Errorf("WebhookManager.EnqueueEvent %w", err))
+	}
+
+	now := time.Now().UTC()
+	var deliveries []domain.WebhookDelivery
+
+	for _, hook := range hooks {
+		if !hook.Active || !hook.Subscribed(envelope.Type) {
+			continue
+		}
+
+		deliveries = append(deliveries, domain.WebhookDelivery{
+			ID:            generateID(),
+			WebhookID:     hook.ID,
+			EventID:       envelope.ID,
+			EventType:     envelope.Type,
+			Payload:       body,
+			Status:        domain.DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	if err = s.hooks.Enqueue(ctx, deliveries...); err != nil {
+		return fmt.Errorf("WebhookManager.EnqueueEvent %w", err)
+	}
+
+	return nil
+}
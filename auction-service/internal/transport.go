@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/ireuven89/auctions/auction-service/domain"
 	"github.com/ireuven89/auctions/auction-service/internal/service"
@@ -32,10 +33,13 @@ type Transport struct {
 	s      service.Service
 }
 
-func (t *Transport) ListenAndServe(port string, publicKey *rsa.PublicKey) {
+// ListenAndServe accepts API keys as well as JWTs when apiKeys is set, the requests made while impersonating
+// a user are handed to impersonations
+func (t *Transport) ListenAndServe(port string, publicKey *rsa.PublicKey, apiKeys http2.APIKeyResolver, impersonations http2.ImpersonationRecorder) {
 	log.Printf("starting auction service on port %s", port)
-	jwtMw := http2.JWTMiddleware(publicKey, []string{"/login", "/health"})
-	wrappedRouter := jwtMw(t.router)
+	jwtMw := http2.AuthMiddleware(publicKey, apiKeys, []string{"/login", "/health"})
+	audit := http2.AuditImpersonation(impersonations)
+	wrappedRouter := jwtMw(audit(t.router))
 	err := http.ListenAndServe(":"+port, wrappedRouter)
 	if err != nil {
 		log.Fatalf("Server failed to start: %v", err)
@@ -58,6 +62,13 @@ func RegisterRoutes(router *httprouter.Router, s service.Service) {
 		kithttp.ServerErrorEncoder(errorEncoder),
 	)
 
+	getAuctionHistoryHandler := kithttp.NewServer(
+		MakeEndpointGetAuctionHistory(s),
+		decodeGetAuctionHistoryRequest,
+		encodeGetAuctionHistoryResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
 	createAuctionHandler := kithttp.NewServer(
 		MakeEndpointCreateAuction(s),
 		decodeCreateAuctionRequest,
@@ -86,6 +97,13 @@ func RegisterRoutes(router *httprouter.Router, s service.Service) {
 		kithttp.ServerErrorEncoder(errorEncoder),
 	)
 
+	getAuctionItemsHandler := kithttp.NewServer(
+		MakeEndpointGetAuctionItems(s),
+		decodeGetAuctionItemsRequest,
+		encodeGetAuctionItemsResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
 	AuctionItemsPicturesHandler := kithttp.NewServer(
 		MakeEndpointCreateAuctionItemPictures(s),
 		decodeCreateItemPicturesRequest,
@@ -93,13 +111,23 @@ func RegisterRoutes(router *httprouter.Router, s service.Service) {
 		kithttp.ServerErrorEncoder(errorEncoder),
 	)
 
-	router.Handler(http.MethodGet, "/auctions/:id", getAuctionHandler)
-	router.Handler(http.MethodGet, "/auctions", getAuctionsHandler)
-	router.Handler(http.MethodPost, "/auctions", createAuctionHandler)
-	router.Handler(http.MethodPut, "/auctions/:id", updateAuctionHandler)
-	router.Handler(http.MethodDelete, "/auctions/:id", deleteAuctionHandler)
-	router.Handler(http.MethodPost, "/auctions/:id/items", auctionItemsHandler)
-	router.Handler(http.MethodPost, "/auctions/:id/items/:itemId/pictures", AuctionItemsPicturesHandler)
+	// scopes only restrict API keys and service tokens, users keep full access
+	auctionsRead := http2.RequireScopes("auctions:read")
+	auctionsWrite := http2.RequireScopes("auctions:write")
+	itemsRead := http2.RequireScopes("items:read")
+	itemsWrite := http2.RequireScopes("items:write")
+	// support staff impersonating a seller may look around but not change or delete anything
+	notImpersonating := http2.DenyImpersonation()
+
+	router.Handler(http.MethodGet, "/auctions/:id", auctionsRead(getAuctionHandler))
+	router.Handler(http.MethodGet, "/auctions", auctionsRead(getAuctionsHandler))
+	router.Handler(http.MethodGet, "/auctions/:id/history", auctionsRead(getAuctionHistoryHandler))
+	router.Handler(http.MethodPost, "/auctions", auctionsWrite(createAuctionHandler))
+	router.Handler(http.MethodPut, "/auctions/:id", auctionsWrite(notImpersonating(updateAuctionHandler)))
+	router.Handler(http.MethodDelete, "/auctions/:id", auctionsWrite(notImpersonating(deleteAuctionHandler)))
+	router.Handler(http.MethodGet, "/auctions/:id/items", itemsRead(getAuctionItemsHandler))
+	router.Handler(http.MethodPost, "/auctions/:id/items", itemsWrite(auctionItemsHandler))
+	router.Handler(http.MethodPost, "/auctions/:id/items/:itemId/pictures", itemsWrite(AuctionItemsPicturesHandler))
 
 }
 
@@ -149,6 +177,38 @@ func encodeGetAuctionsResponse(c context.Context, w http.ResponseWriter, respons
 	return json.NewEncoder(w).Encode(&formatted)
 }
 
+// decodeGetAuctionHistoryRequest reads the point in time from asOf, an RFC 3339 timestamp, and leaves it
+// zero for now when missing
+func decodeGetAuctionHistoryRequest(c context.Context, r *http.Request) (interface{}, error) {
+	req := GetAuctionHistoryRequestModel{
+		id: httprouter.ParamsFromContext(c).ByName("id"),
+	}
+
+	if asOf := r.URL.Query().Get("asOf"); asOf != "" {
+		t, err := time.Parse(time.RFC3339, asOf)
+
+		if err != nil {
+			return nil, fmt.Errorf("decodeGetAuctionHistoryRequest asOf is not an RFC 3339 timestamp %w", domain.ErrBadRequest)
+		}
+
+		req.asOf = t
+	}
+
+	return req, nil
+}
+
+func encodeGetAuctionHistoryResponse(c context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(GetAuctionHistoryResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeGetAuctionHistoryResponse failed parsing reponse")
+	}
+
+	formatted := formatHistory(res.history)
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
 func decodeCreateAuctionRequest(c context.Context, r *http.Request) (interface{}, error) {
 	var req CreateAuctionRequestModel
 
@@ -169,6 +229,29 @@ func decodeCreateItemRequest(c context.Context, r *http.Request) (interface{}, e
 	}, nil
 }
 
+func decodeGetAuctionItemsRequest(c context.Context, r *http.Request) (interface{}, error) {
+
+	return GetAuctionItemsRequestModel{
+		auctionID: httprouter.ParamsFromContext(c).ByName("id"),
+	}, nil
+}
+
+func encodeGetAuctionItemsResponse(c context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(GetAuctionItemsResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeGetAuctionItemsResponse failed parsing response")
+	}
+
+	formatted := []map[string]interface{}{}
+
+	for _, item := range res.items {
+		formatted = append(formatted, formatItem(&item))
+	}
+
+	return json.NewEncoder(w).Encode(formatted)
+}
+
 func decodeCreateItemPicturesRequest(c context.Context, r *http.Request) (interface{}, error) {
 
 	if err := r.ParseMultipartForm(20 << 20); err != nil {
@@ -212,14 +295,10 @@ func decodeUpdateAuctionRequest(c context.Context, r *http.Request) (interface{}
 }
 
 func decodeDeleteAuctionRequest(c context.Context, r *http.Request) (interface{}, error) {
-	var req DeleteAuctionRequestModel
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		fmt.Printf("decodeDeleteAuctionRequest failed decoding request %v", err)
-		return nil, fmt.Errorf("decodeDeleteAuctionRequest %w", err)
-	}
-
-	return req, nil
+	return DeleteAuctionRequestModel{
+		id: httprouter.ParamsFromContext(c).ByName("id"),
+	}, nil
 }
 
 func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
@@ -232,6 +311,8 @@ func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
 		w.WriteHeader(http.StatusTooManyRequests)
 	case errors.Is(err, domain.ErrUnAuthorized):
 		w.WriteHeader(http.StatusUnauthorized)
+	case errors.Is(err, domain.ErrForbidden):
+		w.WriteHeader(http.StatusForbidden)
 	case errors.Is(err, domain.ErrBadRequest):
 		w.WriteHeader(http.StatusBadRequest)
 	default:
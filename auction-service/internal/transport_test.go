@@ -7,10 +7,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/ireuven89/auctions/auction-service/domain"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/ireuven89/auctions/auction-service/internal/mocks"
+	http2 "github.com/ireuven89/auctions/shared/http"
 	"github.com/julienschmidt/httprouter"
 	"github.com/stretchr/testify/assert"
 )
@@ -75,6 +78,62 @@ func TestUpdateAuctionTransport(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.Code)
 }
 
+func TestTransport_Impersonating(t *testing.T) {
+	s := &mocks.MockAuctionService{
+		UpdateFunc: func(ctx context.Context, a domain.AuctionRequest) error {
+			t.Fatal("an impersonating admin updated an auction")
+			return nil
+		},
+	}
+	r := httprouter.New()
+	NewTransport(s, r)
+	RegisterWebhookRoutes(r, nil)
+	claims := jwt.MapClaims{"sub": "seller-id", http2.ActorClaim: map[string]interface{}{"sub": "admin-id"}}
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPut, "/auctions/456"},
+		{http.MethodPost, "/webhooks"},
+		{http.MethodPut, "/webhooks/456"},
+	} {
+		req := httptest.NewRequest(route.method, route.path, bytes.NewBufferString(`{"url":"https://example.com"}`))
+		req = req.WithContext(http2.ContextWithClaims(req.Context(), claims))
+		resp := httptest.NewRecorder()
+
+		r.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusForbidden, resp.Code, route.path)
+	}
+}
+
+func TestGetAuctionItemsTransport(t *testing.T) {
+	s := &mocks.MockAuctionService{
+		FindAuctionItemsFunc: func(ctx context.Context, auctionId string) ([]domain.Item, error) {
+			return []domain.Item{{ID: "item-id", AuctionID: auctionId, Description: "Chair"}}, nil
+		},
+	}
+	r := httprouter.New()
+	NewTransport(s, r)
+
+	get := func(scope string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auctions/123/items", nil)
+		claims := jwt.MapClaims{"sub": "seller-id", "typ": http2.TokenTypeAPIKey, "scope": scope}
+		req = req.WithContext(http2.ContextWithClaims(req.Context(), claims))
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+
+		return resp
+	}
+
+	resp := get("items:read")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var items []map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&items))
+	assert.Len(t, items, 1)
+	assert.Equal(t, "123", items[0]["auction_id"])
+
+	// writing items doesn't let an API key read them
+	assert.Equal(t, http.StatusForbidden, get("items:write").Code)
+}
+
 func TestDeleteAuctionTransport(t *testing.T) {
 	s := &mocks.MockAuctionService{
 		DeleteFunc: func(ctx context.Context, id string) error {
@@ -108,3 +167,33 @@ func TestSearchAuctionTransport(t *testing.T) {
 	r.ServeHTTP(resp, req)
 	assert.Equal(t, http.StatusOK, resp.Code)
 }
+
+func TestGetAuctionHistoryTransport(t *testing.T) {
+	var asOf time.Time
+	s := &mocks.MockAuctionService{
+		HistoryFunc: func(ctx context.Context, id string, t time.Time) (domain.AuctionHistory, error) {
+			asOf = t
+			return domain.AuctionHistory{State: domain.AuctionState{ID: id, Description: "lamp", Version: 1}}, nil
+		},
+	}
+	r := httprouter.New()
+	NewTransport(s, r)
+
+	req := httptest.NewRequest(http.MethodGet, "/auctions/123/history?asOf=2026-03-01T12:00:00Z", nil)
+	resp := httptest.NewRecorder()
+
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.True(t, asOf.Equal(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)))
+
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	assert.Equal(t, "123", result["auctionId"])
+	assert.Equal(t, "lamp", result["state"].(map[string]interface{})["description"])
+
+	req = httptest.NewRequest(http.MethodGet, "/auctions/123/history?asOf=yesterday", nil)
+	resp = httptest.NewRecorder()
+
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is a webhook endpoint on loopback, link-local, private or other addresses that are not
+// on the internet, posting there would let sellers reach our own network
+var ErrForbiddenAddress = errors.New("webhook: address is not public")
+
+// nonPublicNets are the ranges IsPrivate and friends don't cover
+var nonPublicNets = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	// carrier-grade NAT
+	mustParseCIDR("100.64.0.0/10"),
+	// benchmarking
+	mustParseCIDR("198.18.0.0/15"),
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(cidr)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return ipNet
+}
+
+// PublicIP tells whether deliveries may be posted to ip
+func PublicIP(ip net.IP) bool {
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+
+	for _, ipNet := range nonPublicNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckHost refuses a host that is, or resolves to, an address that is not public. A host that does not
+// resolve is let through, the client of NewClient checks the address it connects to anyway
+func CheckHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !PublicIP(ip) {
+			return fmt.Errorf("%s %w", host, ErrForbiddenAddress)
+		}
+
+		return nil
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+
+	if err != nil {
+		return nil
+	}
+
+	for _, ip := range ips {
+		if !PublicIP(ip) {
+			return fmt.Errorf("%s resolves to %s %w", host, ip, ErrForbiddenAddress)
+		}
+	}
+
+	return nil
+}
+
+// NewClient returns a client that only connects to public addresses. The address is checked once resolved,
+// right before connecting, so a host resolving to another address since it was registered is refused too
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+				return fmt.Errorf("%s %w", address, ErrForbiddenAddress)
+			}
+
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would connect to the webhook on our behalf, past the check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
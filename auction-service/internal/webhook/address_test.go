@@ -0,0 +1,42 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublicIP(t *testing.T) {
+	for _, address := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "fd00::1", "0.0.0.0", "100.64.0.1", "224.0.0.1"} {
+		assert.False(t, PublicIP(net.ParseIP(address)), address)
+	}
+
+	for _, address := range []string{"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"} {
+		assert.True(t, PublicIP(net.ParseIP(address)), address)
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	assert.ErrorIs(t, CheckHost(context.Background(), "127.0.0.1"), ErrForbiddenAddress)
+	assert.ErrorIs(t, CheckHost(context.Background(), "169.254.169.254"), ErrForbiddenAddress)
+	assert.ErrorIs(t, CheckHost(context.Background(), "localhost"), ErrForbiddenAddress)
+	assert.NoError(t, CheckHost(context.Background(), "93.184.216.34"))
+}
+
+func TestNewClient_RefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// the URL passed validation once, its host now resolving to us is caught when connecting
+	_, err := NewClient(time.Second).Get(server.URL)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrForbiddenAddress)
+}
@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ireuven89/auctions/auction-service/domain"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultBatchSize    = 20
+	DefaultPollInterval = 5 * time.Second
+	// DefaultDisableAfter is how many deliveries in a row may fail every attempt before the webhook is disabled
+	DefaultDisableAfter = 5
+	// claimLease is how long a claimed delivery is left to its dispatcher before another may claim it
+	claimLease      = 2 * time.Minute
+	deliveryTimeout = 10 * time.Second
+	// maxErrorLength keeps the last delivery error readable in the log
+	maxErrorLength = 1024
+)
+
+// DefaultDeliveryPolicy retries a delivery for about a day before giving up on it
+var DefaultDeliveryPolicy = messaging.RetryPolicy{
+	MaxAttempts:  8,
+	InitialDelay: 30 * time.Second,
+	MaxDelay:     6 * time.Hour,
+	Multiplier:   4,
+}
+
+// Pending is a delivery due and where to post it
+type Pending struct {
+	domain.WebhookDelivery
+	URL    string
+	Secret string
+}
+
+// Store keeps the deliveries for the dispatcher
+type Store interface {
+	// Claim returns up to limit deliveries due to active webhooks and leaves them to the caller until the
+	// lease expires, dispatchers running on several instances do not post the same delivery
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]Pending, error)
+	Succeeded(ctx context.Context, id string, statusCode int) error
+	// Retry records the failed attempt and when to try again
+	Retry(ctx context.Context, id string, statusCode int, reason string, at time.Time) error
+	// Fail records the last attempt failed and gives up on the delivery, the webhook is disabled when it
+	// reaches disableAfter deliveries failed in a row. It tells whether it was
+	Fail(ctx context.Context, id string, statusCode int, reason string, disableAfter int) (bool, error)
+}
+
+// Dispatcher posts the deliveries due, retrying the failed ones with backoff
+type Dispatcher struct {
+	store        Store
+	client       *http.Client
+	policy       messaging.RetryPolicy
+	disableAfter int
+	batchSize    int
+	pollInterval time.Duration
+	logger       *zap.Logger
+}
+
+func NewDispatcher(store Store, logger *zap.Logger) *Dispatcher {
+
+	return &Dispatcher{
+		store:        store,
+		client:       NewClient(deliveryTimeout),
+		policy:       DefaultDeliveryPolicy,
+		disableAfter: DefaultDisableAfter,
+		batchSize:    DefaultBatchSize,
+		pollInterval: DefaultPollInterval,
+		logger:       logger,
+	}
+}
+
+// Run dispatches until the context is done, polling when nothing is due
+func (d *Dispatcher) Run(ctx context.Context) error {
+	for {
+		dispatched, err := d.DispatchOnce(ctx)
+		wait := d.pollInterval
+
+		if err != nil {
+			d.logger.Error("Dispatcher.Run failed dispatching", zap.Error(err))
+		} else if dispatched == d.batchSize {
+			// there is probably more due
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// DispatchOnce posts a batch of the deliveries due and returns how many were attempted
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	due, err := d.store.Claim(ctx, d.batchSize, claimLease)
+
+	if err != nil {
+		return 0, fmt.Errorf("Dispatcher.DispatchOnce %w", err)
+	}
+
+	for _, pending := range due {
+		if err = d.dispatch(ctx, pending); err != nil {
+			return 0, fmt.Errorf("Dispatcher.DispatchOnce %w", err)
+		}
+	}
+
+	return len(due), nil
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, pending Pending) error {
+	statusCode, err := d.post(ctx, pending)
+
+	if err == nil {
+		return d.store.Succeeded(ctx, pending.ID, statusCode)
+	}
+
+	reason := err.Error()
+	if len(reason) > maxErrorLength {
+		reason = reason[:maxErrorLength]
+	}
+
+	attempt := pending.Attempts + 1
+
+	if attempt < d.policy.MaxAttempts {
+		return d.store.Retry(ctx, pending.ID, statusCode, reason, time.Now().Add(d.policy.Delay(attempt)))
+	}
+
+	disabled, err := d.store.Fail(ctx, pending.ID, statusCode, reason, d.disableAfter)
+
+	if err != nil {
+		return err
+	}
+
+	d.logger.Warn("Dispatcher gave up on delivery", zap.String("id", pending.ID), zap.String("webhookId", pending.WebhookID),
+		zap.Int("attempts", attempt), zap.Bool("webhookDisabled", disabled))
+
+	return nil
+}
+
+// post delivers the payload signed, any response but a 2xx fails the attempt
+func (d *Dispatcher) post(ctx context.Context, pending Pending) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pending.URL, bytes.NewReader(pending.Payload))
+
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, fmt.Sprint(now.Unix()))
+	req.Header.Set(SignatureHeader, Sign(pending.Secret, now, pending.Payload))
+	req.Header.Set(EventHeader, pending.EventType)
+	req.Header.Set(DeliveryHeader, pending.ID)
+
+	res, err := d.client.Do(req)
+
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("endpoint responded %s", res.Status)
+	}
+
+	return res.StatusCode, nil
+}
@@ -0,0 +1,119 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ireuven89/auctions/auction-service/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type outcome struct {
+	status     string
+	statusCode int
+	retryAt    time.Time
+}
+
+// memoryStore hands out its deliveries once and records what became of them
+type memoryStore struct {
+	mu       sync.Mutex
+	due      []Pending
+	outcomes map[string]outcome
+	disable  bool
+}
+
+func (s *memoryStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]Pending, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := s.due
+	s.due = nil
+
+	return due, nil
+}
+
+func (s *memoryStore) Succeeded(ctx context.Context, id string, statusCode int) error {
+	s.record(id, outcome{status: domain.DeliverySucceeded, statusCode: statusCode})
+	return nil
+}
+
+func (s *memoryStore) Retry(ctx context.Context, id string, statusCode int, reason string, at time.Time) error {
+	s.record(id, outcome{status: domain.DeliveryPending, statusCode: statusCode, retryAt: at})
+	return nil
+}
+
+func (s *memoryStore) Fail(ctx context.Context, id string, statusCode int, reason string, disableAfter int) (bool, error) {
+	s.record(id, outcome{status: domain.DeliveryFailed, statusCode: statusCode})
+	return s.disable, nil
+}
+
+func (s *memoryStore) record(id string, o outcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.outcomes == nil {
+		s.outcomes = map[string]outcome{}
+	}
+	s.outcomes[id] = o
+}
+
+func TestDispatcher(t *testing.T) {
+	const secret = "whsec_test"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		if err := Verify(secret, r.Header.Get(TimestampHeader), r.Header.Get(SignatureHeader), body, time.Minute, time.Now()); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		assert.Equal(t, "BidPlaced", r.Header.Get(EventHeader))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	delivery := func(id, path string, attempts int) Pending {
+		return Pending{
+			WebhookDelivery: domain.WebhookDelivery{ID: id, WebhookID: "webhook-id", EventType: "BidPlaced", Payload: []byte(`{"id":"event-id"}`), Attempts: attempts},
+			URL:             server.URL + path,
+			Secret:          secret,
+		}
+	}
+
+	store := &memoryStore{due: []Pending{
+		delivery("delivered", "/hooks", 0),
+		delivery("retried", "/broken", 2),
+		delivery("given-up", "/broken", DefaultDeliveryPolicy.MaxAttempts-1),
+	}}
+	d := NewDispatcher(store, zap.NewNop())
+	// the test server listens on loopback, which NewClient refuses
+	d.client = server.Client()
+
+	before := time.Now()
+	dispatched, err := d.DispatchOnce(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, dispatched)
+
+	assert.Equal(t, outcome{status: domain.DeliverySucceeded, statusCode: http.StatusNoContent}, store.outcomes["delivered"])
+
+	retried := store.outcomes["retried"]
+	assert.Equal(t, domain.DeliveryPending, retried.status)
+	assert.Equal(t, http.StatusInternalServerError, retried.statusCode)
+	// the third attempt waits for the delay after it
+	assert.WithinDuration(t, before.Add(DefaultDeliveryPolicy.Delay(3)), retried.retryAt, 5*time.Second)
+
+	assert.Equal(t, domain.DeliveryFailed, store.outcomes["given-up"].status)
+}
@@ -0,0 +1,83 @@
+// Package webhook posts the events of their auctions to the webhooks of sellers. Every delivery is signed
+// with the secret of the webhook so the seller can tell it came from us and was not replayed:
+//
+//	X-Webhook-Timestamp: <unix seconds>
+//	X-Webhook-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>" keyed with the secret>
+//
+// Verify is what a receiver does with them
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+	// EventHeader is the type of the event delivered
+	EventHeader = "X-Webhook-Event"
+	// DeliveryHeader identifies the delivery, a replay has its own
+	DeliveryHeader = "X-Webhook-Delivery"
+)
+
+// signaturePrefix names the algorithm in the signature header
+const signaturePrefix = "sha256="
+
+// secretPrefix marks our webhook secrets so they are easy to recognize in secret scanners
+const secretPrefix = "whsec_"
+
+var (
+	ErrInvalidSignature = errors.New("webhook: invalid signature")
+	ErrStaleTimestamp   = errors.New("webhook: timestamp outside the tolerance")
+)
+
+// NewSecret returns a random secret to sign the deliveries of a webhook with
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("webhook.NewSecret %w", err)
+	}
+
+	return secretPrefix + hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature header of the body posted at the time
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of the body against the secret, and that the timestamp is within the
+// tolerance of now so an intercepted delivery cannot be replayed later
+func Verify(secret, timestamp, signature string, body []byte, tolerance time.Duration, now time.Time) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+
+	if err != nil {
+		return fmt.Errorf("webhook.Verify malformed timestamp %w", ErrInvalidSignature)
+	}
+
+	signedAt := time.Unix(seconds, 0)
+
+	if now.Sub(signedAt) > tolerance || signedAt.Sub(now) > tolerance {
+		return ErrStaleTimestamp
+	}
+
+	if !strings.HasPrefix(signature, signaturePrefix) || !hmac.Equal([]byte(signature), []byte(Sign(secret, signedAt, body))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
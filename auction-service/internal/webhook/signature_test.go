@@ -0,0 +1,31 @@
+package webhook
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	secret, err := NewSecret()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, secretPrefix))
+
+	now := time.Unix(1767225600, 0)
+	body := []byte(`{"id":"event-id","type":"BidPlaced"}`)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := Sign(secret, now, body)
+
+	assert.True(t, strings.HasPrefix(signature, "sha256="))
+	assert.NoError(t, Verify(secret, timestamp, signature, body, 5*time.Minute, now.Add(time.Minute)))
+
+	assert.ErrorIs(t, Verify(secret, timestamp, signature, []byte(`{"id":"event-id","type":"AuctionClosed"}`), 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("whsec_other", timestamp, signature, body, 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify(secret, "yesterday", signature, body, 5*time.Minute, now), ErrInvalidSignature)
+	// a delivery captured and posted again later
+	assert.ErrorIs(t, Verify(secret, timestamp, signature, body, 5*time.Minute, now.Add(time.Hour)), ErrStaleTimestamp)
+}
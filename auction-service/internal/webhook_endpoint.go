@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ireuven89/auctions/auction-service/domain"
+	"github.com/ireuven89/auctions/auction-service/internal/service"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+type RegisterWebhookRequestModel struct {
+	domain.WebhookRequest
+}
+
+type WebhookResponseModel struct {
+	webhook domain.Webhook
+	// withSecret shows the secret, only when the webhook is registered
+	withSecret bool
+}
+
+func MakeEndpointRegisterWebhook(s service.WebhookService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(RegisterWebhookRequestModel)
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointRegisterWebhook.failed parsing request")
+		}
+
+		res, err := s.RegisterWebhook(ctx, req.WebhookRequest)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointRegisterWebhook %w", err)
+		}
+
+		return WebhookResponseModel{webhook: res, withSecret: true}, nil
+	}
+}
+
+type GetWebhooksRequestModel struct {
+	sellerID string
+}
+
+type GetWebhooksResponseModel struct {
+	webhooks []domain.Webhook
+}
+
+func MakeEndpointGetWebhooks(s service.WebhookService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(GetWebhooksRequestModel)
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointGetWebhooks.failed parsing request")
+		}
+
+		res, err := s.Webhooks(ctx, req.sellerID)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointGetWebhooks %w", err)
+		}
+
+		return GetWebhooksResponseModel{webhooks: res}, nil
+	}
+}
+
+type UpdateWebhookRequestModel struct {
+	domain.WebhookRequest
+}
+
+func MakeEndpointUpdateWebhook(s service.WebhookService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(UpdateWebhookRequestModel)
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointUpdateWebhook.failed parsing request")
+		}
+
+		res, err := s.UpdateWebhook(ctx, req.WebhookRequest)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointUpdateWebhook %w", err)
+		}
+
+		return WebhookResponseModel{webhook: res}, nil
+	}
+}
+
+type DeleteWebhookRequestModel struct {
+	id string
+}
+
+func MakeEndpointDeleteWebhook(s service.WebhookService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(DeleteWebhookRequestModel)
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointDeleteWebhook.failed parsing request")
+		}
+
+		if err = s.DeleteWebhook(ctx, req.id); err != nil {
+			return nil, fmt.Errorf("MakeEndpointDeleteWebhook %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type GetDeliveriesRequestModel struct {
+	domain.DeliveryFilter
+}
+
+type GetDeliveriesResponseModel struct {
+	deliveries []domain.WebhookDelivery
+}
+
+func MakeEndpointGetDeliveries(s service.WebhookService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(GetDeliveriesRequestModel)
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointGetDeliveries.failed parsing request")
+		}
+
+		res, err := s.Deliveries(ctx, req.DeliveryFilter)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointGetDeliveries %w", err)
+		}
+
+		return GetDeliveriesResponseModel{deliveries: res}, nil
+	}
+}
+
+type ReplayDeliveryRequestModel struct {
+	webhookID  string
+	deliveryID string
+}
+
+type ReplayDeliveryResponseModel struct {
+	delivery domain.WebhookDelivery
+}
+
+func MakeEndpointReplayDelivery(s service.WebhookService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(ReplayDeliveryRequestModel)
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointReplayDelivery.failed parsing request")
+		}
+
+		res, err := s.ReplayDelivery(ctx, req.webhookID, req.deliveryID)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointReplayDelivery %w", err)
+		}
+
+		return ReplayDeliveryResponseModel{delivery: res}, nil
+	}
+}
+
+type ReplayDeliveriesRequestModel struct {
+	webhookID string
+	Since     time.Time `json:"since"`
+}
+
+type ReplayDeliveriesResponseModel struct {
+	Replayed int `json:"replayed"`
+}
+
+func MakeEndpointReplayDeliveries(s service.WebhookService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(ReplayDeliveriesRequestModel)
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointReplayDeliveries.failed parsing request")
+		}
+
+		replayed, err := s.ReplayDeliveries(ctx, req.webhookID, req.Since)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointReplayDeliveries %w", err)
+		}
+
+		return ReplayDeliveriesResponseModel{Replayed: replayed}, nil
+	}
+}
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ireuven89/auctions/auction-service/domain"
+	"github.com/ireuven89/auctions/auction-service/internal/mocks"
+	"github.com/ireuven89/auctions/auction-service/internal/service"
+
+	"github.com/ireuven89/auctions/shared/events"
+	http2 "github.com/ireuven89/auctions/shared/http"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func TestRegisterWebhook(t *testing.T) {
+	hooks := new(mocks.MockWebhooks)
+	svc := service.NewWebhookService(hooks, new(MockRepository), zap.NewNop())
+
+	hook, err := svc.RegisterWebhook(orgContext("org-id", http2.OrgRoleManager), domain.WebhookRequest{
+		URL:        "https://seller.example.com/hooks",
+		EventTypes: []string{events.TypeBidPlaced},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "org-id", hook.SellerID)
+	assert.True(t, hook.Active)
+	assert.NotEmpty(t, hook.Secret)
+	assert.Len(t, hooks.Hooks, 1)
+
+	_, err = svc.RegisterWebhook(orgContext("org-id", http2.OrgRoleManager), domain.WebhookRequest{URL: "http://seller.example.com/hooks"})
+	assert.ErrorIs(t, err, domain.ErrBadRequest)
+
+	_, err = svc.RegisterWebhook(orgContext("org-id", http2.OrgRoleManager), domain.WebhookRequest{URL: "https://seller.example.com/hooks", EventTypes: []string{"UserRegistered"}})
+	assert.ErrorIs(t, err, domain.ErrBadRequest)
+
+	// nothing on our own network
+	for _, endpoint := range []string{"https://127.0.0.1/hooks", "https://localhost:8443/hooks", "https://169.254.169.254/latest", "https://10.0.0.5/hooks", "https://[::1]/hooks"} {
+		_, err = svc.RegisterWebhook(orgContext("org-id", http2.OrgRoleManager), domain.WebhookRequest{URL: endpoint})
+		assert.ErrorIs(t, err, domain.ErrBadRequest, endpoint)
+	}
+
+	// only managers of the seller register its webhooks
+	_, err = svc.RegisterWebhook(orgContext("other-org", http2.OrgRoleManager), domain.WebhookRequest{URL: "https://seller.example.com/hooks", SellerID: "org-id"})
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+
+	_, err = svc.Deliveries(orgContext("other-org", http2.OrgRoleManager), domain.DeliveryFilter{WebhookID: hook.ID})
+	assert.ErrorIs(t, err, domain.ErrForbidden)
+}
+
+func TestEnqueueEvent(t *testing.T) {
+	hooks := &mocks.MockWebhooks{Hooks: []domain.Webhook{
+		{ID: "all", SellerID: "org-id", Active: true},
+		{ID: "bids", SellerID: "org-id", Active: true, EventTypes: []string{events.TypeBidPlaced}},
+		{ID: "disabled", SellerID: "org-id", Active: false},
+		{ID: "other-seller", SellerID: "other-org", Active: true},
+	}}
+	auctions := new(MockRepository)
+	auctions.On("Find", mock.Anything, "auction-id").Return(domain.Auction{ID: "auction-id", SellerID: "org-id"}, nil)
+	auctions.On("Find", mock.Anything, "deleted-id").Return(domain.Auction{}, sql.ErrNoRows)
+	svc := service.NewWebhookService(hooks, auctions, zap.NewNop())
+
+	created, err := events.NewEnvelope(context.Background(), events.TypeAuctionCreated, events.AuctionCreated{
+		AuctionID: "auction-id", SellerID: "org-id", Description: "lamp", InitialOffer: 5, MinIncrement: 1, Status: "pending", CreatedAt: time.Now().UTC(),
+	})
+	assert.NoError(t, err)
+	placed, err := events.NewEnvelope(context.Background(), events.TypeBidPlaced, events.BidPlaced{
+		BidID: "bid-id", AuctionID: "auction-id", BidderID: "bidder-id", Amount: 7, PlacedAt: time.Now().UTC(),
+	})
+	assert.NoError(t, err)
+
+	assert.NoError(t, svc.EnqueueEvent(context.Background(), created))
+	assert.NoError(t, svc.EnqueueEvent(context.Background(), placed))
+	// redelivered by the broker
+	assert.NoError(t, svc.EnqueueEvent(context.Background(), placed))
+
+	var delivered []string
+	for _, d := range hooks.Log {
+		delivered = append(delivered, d.WebhookID+":"+d.EventType)
+	}
+	assert.Equal(t, []string{"all:" + events.TypeAuctionCreated, "all:" + events.TypeBidPlaced, "bids:" + events.TypeBidPlaced}, delivered)
+
+	var sent messaging.Envelope
+	assert.NoError(t, json.Unmarshal(hooks.Log[1].Payload, &sent))
+	assert.Equal(t, placed.ID, sent.ID)
+
+	// events of auctions deleted since are dropped
+	gone, err := events.NewEnvelope(context.Background(), events.TypeAuctionCancelled, events.AuctionCancelled{AuctionID: "deleted-id", CancelledAt: time.Now().UTC()})
+	assert.NoError(t, err)
+	assert.NoError(t, svc.EnqueueEvent(context.Background(), gone))
+	assert.Len(t, hooks.Log, 3)
+
+	replay, err := svc.ReplayDelivery(orgContext("org-id", http2.OrgRoleManager), "bids", hooks.Log[2].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, hooks.Log[2].ID, replay.ReplayOf)
+	assert.Len(t, hooks.Log, 4)
+}
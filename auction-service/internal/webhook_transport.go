@@ -0,0 +1,227 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ireuven89/auctions/auction-service/domain"
+	"github.com/ireuven89/auctions/auction-service/internal/service"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	http2 "github.com/ireuven89/auctions/shared/http"
+	"github.com/julienschmidt/httprouter"
+)
+
+// RegisterWebhookRoutes serves the webhooks of sellers and their delivery log
+func RegisterWebhookRoutes(router *httprouter.Router, s service.WebhookService) {
+	registerWebhookHandler := kithttp.NewServer(
+		MakeEndpointRegisterWebhook(s),
+		decodeRegisterWebhookRequest,
+		encodeWebhookResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	getWebhooksHandler := kithttp.NewServer(
+		MakeEndpointGetWebhooks(s),
+		decodeGetWebhooksRequest,
+		encodeGetWebhooksResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	updateWebhookHandler := kithttp.NewServer(
+		MakeEndpointUpdateWebhook(s),
+		decodeUpdateWebhookRequest,
+		encodeWebhookResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	deleteWebhookHandler := kithttp.NewServer(
+		MakeEndpointDeleteWebhook(s),
+		decodeDeleteWebhookRequest,
+		kithttp.EncodeJSONResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	getDeliveriesHandler := kithttp.NewServer(
+		MakeEndpointGetDeliveries(s),
+		decodeGetDeliveriesRequest,
+		encodeGetDeliveriesResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	replayDeliveryHandler := kithttp.NewServer(
+		MakeEndpointReplayDelivery(s),
+		decodeReplayDeliveryRequest,
+		encodeReplayDeliveryResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	replayDeliveriesHandler := kithttp.NewServer(
+		MakeEndpointReplayDeliveries(s),
+		decodeReplayDeliveriesRequest,
+		kithttp.EncodeJSONResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	webhooksRead := http2.RequireScopes("webhooks:read")
+	webhooksWrite := http2.RequireScopes("webhooks:write")
+	// support staff impersonating a seller may not redirect where the events of the seller go
+	notImpersonating := http2.DenyImpersonation()
+
+	router.Handler(http.MethodPost, "/webhooks", webhooksWrite(notImpersonating(registerWebhookHandler)))
+	router.Handler(http.MethodGet, "/webhooks", webhooksRead(getWebhooksHandler))
+	router.Handler(http.MethodPut, "/webhooks/:id", webhooksWrite(notImpersonating(updateWebhookHandler)))
+	router.Handler(http.MethodDelete, "/webhooks/:id", webhooksWrite(notImpersonating(deleteWebhookHandler)))
+	router.Handler(http.MethodGet, "/webhooks/:id/deliveries", webhooksRead(getDeliveriesHandler))
+	router.Handler(http.MethodPost, "/webhooks/:id/deliveries/:deliveryId/replay", webhooksWrite(replayDeliveryHandler))
+	router.Handler(http.MethodPost, "/webhooks/:id/replay", webhooksWrite(replayDeliveriesHandler))
+}
+
+func decodeRegisterWebhookRequest(c context.Context, r *http.Request) (interface{}, error) {
+	var req RegisterWebhookRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeRegisterWebhookRequest %v %w", err, domain.ErrBadRequest)
+	}
+
+	return req, nil
+}
+
+func encodeWebhookResponse(c context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(WebhookResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeWebhookResponse failed parsing reponse")
+	}
+
+	formatted := formatWebhook(res.webhook)
+
+	if res.withSecret {
+		formatted["secret"] = res.webhook.Secret
+	}
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+func decodeGetWebhooksRequest(c context.Context, r *http.Request) (interface{}, error) {
+
+	return GetWebhooksRequestModel{
+		sellerID: r.URL.Query().Get("sellerId"),
+	}, nil
+}
+
+func encodeGetWebhooksResponse(c context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(GetWebhooksResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeGetWebhooksResponse failed parsing reponse")
+	}
+
+	formatted := make([]map[string]interface{}, 0, len(res.webhooks))
+
+	for _, hook := range res.webhooks {
+		formatted = append(formatted, formatWebhook(hook))
+	}
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+func decodeUpdateWebhookRequest(c context.Context, r *http.Request) (interface{}, error) {
+	var req UpdateWebhookRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeUpdateWebhookRequest %v %w", err, domain.ErrBadRequest)
+	}
+
+	req.ID = httprouter.ParamsFromContext(c).ByName("id")
+
+	return req, nil
+}
+
+func decodeDeleteWebhookRequest(c context.Context, r *http.Request) (interface{}, error) {
+
+	return DeleteWebhookRequestModel{
+		id: httprouter.ParamsFromContext(c).ByName("id"),
+	}, nil
+}
+
+// decodeGetDeliveriesRequest reads the status to filter by and how many deliveries to return
+func decodeGetDeliveriesRequest(c context.Context, r *http.Request) (interface{}, error) {
+	req := GetDeliveriesRequestModel{
+		DeliveryFilter: domain.DeliveryFilter{
+			WebhookID: httprouter.ParamsFromContext(c).ByName("id"),
+			Status:    r.URL.Query().Get("status"),
+		},
+	}
+
+	switch req.Status {
+	case "", domain.DeliveryPending, domain.DeliverySucceeded, domain.DeliveryFailed:
+	default:
+		return nil, fmt.Errorf("decodeGetDeliveriesRequest unknown status %s %w", req.Status, domain.ErrBadRequest)
+	}
+
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("decodeGetDeliveriesRequest limit must be a positive number %w", domain.ErrBadRequest)
+		}
+
+		req.Limit = n
+	}
+
+	return req, nil
+}
+
+func encodeGetDeliveriesResponse(c context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(GetDeliveriesResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeGetDeliveriesResponse failed parsing reponse")
+	}
+
+	formatted := make([]map[string]interface{}, 0, len(res.deliveries))
+
+	for _, delivery := range res.deliveries {
+		formatted = append(formatted, formatDelivery(delivery))
+	}
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+func decodeReplayDeliveryRequest(c context.Context, r *http.Request) (interface{}, error) {
+	params := httprouter.ParamsFromContext(c)
+
+	return ReplayDeliveryRequestModel{
+		webhookID:  params.ByName("id"),
+		deliveryID: params.ByName("deliveryId"),
+	}, nil
+}
+
+func encodeReplayDeliveryResponse(c context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(ReplayDeliveryResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeReplayDeliveryResponse failed parsing reponse")
+	}
+
+	formatted := formatDelivery(res.delivery)
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+// decodeReplayDeliveriesRequest reads since, an RFC 3339 timestamp, from the body
+func decodeReplayDeliveriesRequest(c context.Context, r *http.Request) (interface{}, error) {
+	var req ReplayDeliveriesRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Since.IsZero() {
+		return nil, fmt.Errorf("decodeReplayDeliveriesRequest since must be an RFC 3339 timestamp %w", domain.ErrBadRequest)
+	}
+
+	req.webhookID = httprouter.ParamsFromContext(c).ByName("id")
+
+	return req, nil
+}
@@ -0,0 +1,113 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Prefix marks our keys so they are easy to recognize in scripts and secret scanners
+const Prefix = "ak_"
+
+// displayLength is how much of the key is kept in clear to tell keys apart
+const displayLength = len(Prefix) + 8
+
+const (
+	ScopeAuctionsRead  = "auctions:read"
+	ScopeAuctionsWrite = "auctions:write"
+	ScopeItemsRead     = "items:read"
+	ScopeItemsWrite    = "items:write"
+	ScopeWebhooksRead  = "webhooks:read"
+	ScopeWebhooksWrite = "webhooks:write"
+)
+
+var scopes = map[string]struct{}{
+	ScopeAuctionsRead:  {},
+	ScopeAuctionsWrite: {},
+	ScopeItemsRead:     {},
+	ScopeItemsWrite:    {},
+	ScopeWebhooksRead:  {},
+	ScopeWebhooksWrite: {},
+}
+
+func ValidScope(scope string) bool {
+	_, ok := scopes[scope]
+
+	return ok
+}
+
+// APIKey is a user's key, only the hash of the key itself is stored
+type APIKey struct {
+	ID     string
+	UserID string
+	// OrganizationID is set for keys that act for an organization, the owner must still be a member
+	OrganizationID string
+	Name           string
+	Prefix         string
+	Hash           string
+	Scopes         []string
+	ExpiresAt      *time.Time
+	LastUsedAt     *time.Time
+	CreatedAt      time.Time
+}
+
+func (k APIKey) Expired(now time.Time) bool {
+
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+type APIKeyResponse struct {
+	ID             string     `json:"id"`
+	OrganizationID string     `json:"organizationId,omitempty"`
+	Name           string     `json:"name"`
+	Prefix         string     `json:"prefix"`
+	Scopes         []string   `json:"scopes"`
+	ExpiresAt      *time.Time `json:"expiresAt"`
+	LastUsedAt     *time.Time `json:"lastUsedAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// ToResponse - Do not add the hash to the response
+func ToResponse(key APIKey) APIKeyResponse {
+
+	return APIKeyResponse{
+		ID:             key.ID,
+		OrganizationID: key.OrganizationID,
+		Name:           key.Name,
+		Prefix:         key.Prefix,
+		Scopes:         key.Scopes,
+		ExpiresAt:      key.ExpiresAt,
+		LastUsedAt:     key.LastUsedAt,
+		CreatedAt:      key.CreatedAt,
+	}
+}
+
+// Generate returns a new key and the prefix kept for display
+func Generate() (string, string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("apikey.Generate %w", err)
+	}
+
+	key := Prefix + base64.RawURLEncoding.EncodeToString(b)
+
+	return key, key[:displayLength], nil
+}
+
+// Hash is a plain sha256, keys are random so they don't need a slow password hash and can be looked up by hash
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// LooksValid rejects strings that can't be one of our keys before they reach the database
+func LooksValid(key string) bool {
+
+	return strings.HasPrefix(key, Prefix) && len(key) == len(Prefix)+43
+}
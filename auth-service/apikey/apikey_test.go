@@ -0,0 +1,46 @@
+package apikey
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate(t *testing.T) {
+	key, prefix, err := Generate()
+
+	assert.NoError(t, err)
+	assert.True(t, LooksValid(key))
+	assert.Equal(t, key[:len(prefix)], prefix)
+	assert.Len(t, prefix, displayLength)
+
+	other, _, _ := Generate()
+	assert.NotEqual(t, key, other)
+	assert.NotEqual(t, Hash(key), Hash(other))
+}
+
+func TestLooksValid(t *testing.T) {
+	assert.False(t, LooksValid(""))
+	assert.False(t, LooksValid("ak_short"))
+	assert.False(t, LooksValid("xx_0123456789012345678901234567890123456789012"))
+}
+
+func TestToResponse_NoHash(t *testing.T) {
+	k := APIKey{ID: "id", Name: "ci", Prefix: "ak_abcdefgh", Hash: "secret-hash", CreatedAt: time.Now()}
+
+	data, err := json.Marshal(ToResponse(k))
+
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "secret-hash")
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Second), now.Add(time.Hour)
+
+	assert.False(t, APIKey{}.Expired(now))
+	assert.True(t, APIKey{ExpiresAt: &past}.Expired(now))
+	assert.False(t, APIKey{ExpiresAt: &future}.Expired(now))
+}
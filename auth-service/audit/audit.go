@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// event types
+const (
+	TypeRegister       = "register"
+	TypeLogin          = "login"
+	TypeRefresh        = "refresh"
+	TypeLogout         = "logout"
+	TypePasswordChange = "password_change"
+	TypePasswordReset  = "password_reset"
+	TypeRoleChange     = "role_change"
+	TypeKeyRotation    = "key_rotation"
+	TypeImpersonation  = "impersonation"
+	TypeMFAEnable      = "mfa_enable"
+	TypeMFADisable     = "mfa_disable"
+)
+
+// outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	// OutcomeChallenged is a correct password that still has to pass the two-factor challenge
+	OutcomeChallenged = "challenged"
+)
+
+// ActorSystem is the actor of events nobody asked for, like scheduled key rotations
+const ActorSystem = "system"
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Event is one entry of the append-only audit log. Identifiers people typed in are only kept hashed,
+// failed logins must not turn the log into a list of emails and mistyped passwords
+type Event struct {
+	ID         int64             `json:"id"`
+	Type       string            `json:"type"`
+	Outcome    string            `json:"outcome"`
+	ActorID    string            `json:"actorId,omitempty"`
+	SubjectID  string            `json:"subjectId,omitempty"`
+	Identifier string            `json:"identifier,omitempty"`
+	IP         string            `json:"ip,omitempty"`
+	UserAgent  string            `json:"userAgent,omitempty"`
+	Reason     string            `json:"reason,omitempty"`
+	Details    map[string]string `json:"details,omitempty"`
+	CreatedAt  time.Time         `json:"createdAt"`
+}
+
+// Filter narrows a query, empty fields match everything. Events come newest first, Before continues a page
+type Filter struct {
+	Type       string
+	Outcome    string
+	ActorID    string
+	SubjectID  string
+	Identifier string
+	IP         string
+	From       *time.Time
+	To         *time.Time
+	Before     int64
+	Limit      int
+}
+
+// WithLimit applies the default and the maximum page size
+func (f Filter) WithLimit() Filter {
+	if f.Limit <= 0 {
+		f.Limit = DefaultLimit
+	}
+
+	if f.Limit > MaxLimit {
+		f.Limit = MaxLimit
+	}
+
+	return f
+}
+
+// HashIdentifier is how an identifier is stored and searched for, case and surrounding spaces don't matter
+func HashIdentifier(identifier string) string {
+	if identifier == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(identifier))))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// reasons of failed events
+const (
+	ReasonUnknownUser      = "unknown_user"
+	ReasonInvalidPassword  = "invalid_password"
+	ReasonInvalidCode      = "invalid_code"
+	ReasonInvalidToken     = "invalid_token"
+	ReasonExpiredToken     = "expired_token"
+	ReasonAlreadyExists    = "already_exists"
+	ReasonOtherDevice      = "other_device"
+	ReasonProviderRejected = "provider_rejected"
+	ReasonInternal         = "internal_error"
+	ReasonRequestFailed    = "request_failed"
+)
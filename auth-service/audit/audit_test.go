@@ -0,0 +1,20 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashIdentifier(t *testing.T) {
+	assert.Empty(t, HashIdentifier(""))
+	assert.Equal(t, HashIdentifier("foo@bar.com"), HashIdentifier(" Foo@Bar.com "))
+	assert.NotEqual(t, HashIdentifier("foo@bar.com"), HashIdentifier("bar@bar.com"))
+	assert.Len(t, HashIdentifier("foo@bar.com"), 64)
+}
+
+func TestFilter_WithLimit(t *testing.T) {
+	assert.Equal(t, DefaultLimit, Filter{}.WithLimit().Limit)
+	assert.Equal(t, 10, Filter{Limit: 10}.WithLimit().Limit)
+	assert.Equal(t, MaxLimit, Filter{Limit: MaxLimit + 1}.WithLimit().Limit)
+}
@@ -0,0 +1,33 @@
+// breachindex builds the breached password index the auth service loads from password.breach_index.
+//
+//	breachindex -source pwnedpasswords.txt -out pwned.idx
+//	breachindex -source ./ranges -out pwned.idx
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ireuven89/auctions/auth-service/password"
+)
+
+func main() {
+	source := flag.String("source", "", "HIBP corpus, a HASH:COUNT file or a directory of range files")
+	out := flag.String("out", "", "index file to write")
+	flag.Parse()
+
+	if *source == "" || *out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	count, err := password.BuildBreachIndex(*source, *out)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("indexed %d hashes into %s\n", count, *out)
+}
@@ -1,9 +1,20 @@
 package main
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/ireuven89/auctions/auth-service/db"
+	"github.com/ireuven89/auctions/auth-service/federation"
 	"github.com/ireuven89/auctions/auth-service/internal"
+	"github.com/ireuven89/auctions/auth-service/magiclink"
+	"github.com/ireuven89/auctions/auth-service/mailer"
+	"github.com/ireuven89/auctions/auth-service/password"
 	"github.com/ireuven89/auctions/shared/config"
+	"github.com/ireuven89/auctions/shared/encryption"
+	"github.com/ireuven89/auctions/shared/events"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/ireuven89/auctions/shared/messaging/outbox"
 	"github.com/julienschmidt/httprouter"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -27,6 +38,37 @@ func main() {
 		panic(err)
 	}
 
+	mfaKey, err := config.MustNewEnvVar("MFA_ENCRYPTION_KEY")
+
+	if err != nil {
+		panic(err)
+	}
+
+	mfaEncrypter, err := encryption.NewAESEncrypterFromBase64(mfaKey)
+
+	if err != nil {
+		panic(fmt.Errorf("MFA_ENCRYPTION_KEY must be a base64 encoded 32 byte key %w", err))
+	}
+
+	hasher, err := password.NewHasherFromConfig(cfg.Password.Hash)
+
+	if err != nil {
+		panic(err)
+	}
+
+	var breaches password.BreachChecker
+
+	if cfg.Password.BreachIndex != "" {
+		index, err := password.OpenBreachIndex(cfg.Password.BreachIndex)
+
+		if err != nil {
+			panic(err)
+		}
+		defer index.Close()
+
+		breaches = index
+	}
+
 	authDB, err := db.MustNewDB(cfg.Sql.Host, cfg.Sql.User, cfg.Sql.Password, cfg.Sql.Port)
 	if err != nil {
 		panic(err)
@@ -35,15 +77,52 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	authRepo := db.New(logger, authDB, redisDB)
+
+	keyProvider, err := encryption.NewKeyProviderFromConfig(context.Background(), cfg.AWS, cfg.Encryption)
+	if err != nil {
+		panic(err)
+	}
+	fields, err := encryption.LoadFieldCipher(context.Background(), keyProvider, encryption.NewSQLKeyStore(authDB))
+	if err != nil {
+		panic(fmt.Errorf("failed loading the personal data keys %w", err))
+	}
+	authRepo := db.New(logger, authDB, redisDB, fields)
 
 	router := httprouter.New()
-	s, err := internal.NewAuthService(logger, authRepo, keyId)
+	s, err := internal.NewAuthService(logger, authRepo, keyId, mailer.New(logger, cfg.Mail), mfaEncrypter, hasher, password.NewPolicy(cfg.Password.Policy), breaches, cfg.Auth.Issuer, magiclink.NewOptions(cfg.MagicLink), federation.NewProviders(cfg.OIDC), cfg.Auth.InvitationURL)
 
 	if err != nil {
 		panic(err)
 	}
 	transport := internal.NewTransport(router, s)
+	trustedProxies, err := internal.ParseTrustedProxies(cfg.Server.TrustedProxies)
+
+	if err != nil {
+		panic(err)
+	}
+
+	// without a broker the user events wait in the outbox until one is configured
+	if cfg.Rabbit.URL != "" {
+		broker, err := messaging.DialAMQP(cfg.Rabbit)
+
+		if err != nil {
+			panic(fmt.Errorf("failed connecting broker %w", err))
+		}
+		defer broker.Close()
+
+		go outbox.NewRelay(outbox.NewSQLStore(authDB), broker).Run(context.Background())
+
+		// the requests admins make as other users to the other services join the audit log
+		consumer := messaging.NewConsumer(broker, messaging.NewSQLProcessedStore(authDB))
+		consumer.Handle(internal.ImpersonationEventsSubscription, messaging.DefaultRetryPolicy, events.Validating(internal.NewImpersonationEventsHandler(s)))
+
+		go func() {
+			err := consumer.Run(context.Background())
+			logger.Error("consumer stopped", zap.Error(err))
+		}()
+	} else {
+		logger.Warn("rabbit.url is not set, user events are kept in the outbox and the other services' impersonated requests are not audited")
+	}
 
-	transport.ListenAndServe(cfg.Server.Port)
+	transport.ListenAndServe(cfg.Server.Port, trustedProxies)
 }
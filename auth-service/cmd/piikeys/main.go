@@ -0,0 +1,165 @@
+// piikeys manages the keys of the personal data encryption.
+//
+//	piikeys genkey -keyring config/master.keys   append a new local master key, it becomes the current one
+//	piikeys rewrap                               wrap the data keys with the current master key
+//	piikeys backfill                             encrypt and index the rows written before the encryption
+//
+// Rotating the local master key is genkey followed by rewrap, the old key can be removed from the keyring afterwards.
+// rewrap and backfill read the service config like the service does, from APP_ENV and CONFIG_DIR
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ireuven89/auctions/auth-service/db"
+	"github.com/ireuven89/auctions/shared/config"
+	"github.com/ireuven89/auctions/shared/encryption"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "genkey":
+		err = genKey(os.Args[2:])
+	case "rewrap":
+		err = rewrap(os.Args[2:])
+	case "backfill":
+		err = backfill()
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: piikeys genkey -keyring <file> | rewrap [-all] | backfill")
+	os.Exit(2)
+}
+
+func genKey(args []string) error {
+	flags := flag.NewFlagSet("genkey", flag.ExitOnError)
+	keyring := flags.String("keyring", "", "keyring file the key is appended to, it is created when missing")
+	flags.Parse(args)
+
+	if *keyring == "" {
+		usage()
+	}
+
+	line, err := encryption.NewKeyringLine(time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(*keyring, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err = fmt.Fprintln(file, line); err != nil {
+		return err
+	}
+
+	provider, err := encryption.NewLocalKeyProvider(*keyring)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("master key %s is now current in %s\n", provider.KeyID(), *keyring)
+
+	return nil
+}
+
+func rewrap(args []string) error {
+	flags := flag.NewFlagSet("rewrap", flag.ExitOnError)
+	all := flags.Bool("all", false, "rewrap keys already wrapped with the current master key, e.g. after moving a KMS alias")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	cfg, authDB, err := open()
+
+	if err != nil {
+		return err
+	}
+	defer authDB.Close()
+
+	provider, err := encryption.NewKeyProviderFromConfig(ctx, cfg.AWS, cfg.Encryption)
+
+	if err != nil {
+		return err
+	}
+
+	count, err := encryption.Rewrap(ctx, provider, encryption.NewSQLKeyStore(authDB), *all)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("rewrapped %d data keys with %s\n", count, provider.KeyID())
+
+	return nil
+}
+
+func backfill() error {
+	ctx := context.Background()
+	cfg, authDB, err := open()
+
+	if err != nil {
+		return err
+	}
+	defer authDB.Close()
+
+	provider, err := encryption.NewKeyProviderFromConfig(ctx, cfg.AWS, cfg.Encryption)
+
+	if err != nil {
+		return err
+	}
+
+	fields, err := encryption.LoadFieldCipher(ctx, provider, encryption.NewSQLKeyStore(authDB))
+
+	if err != nil {
+		return err
+	}
+
+	count, err := db.EncryptPersonalData(ctx, authDB, fields)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("encrypted %d users\n", count)
+
+	return nil
+}
+
+func open() (*config.Config, *sql.DB, error) {
+	cfg, err := config.LoadConfig()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authDB, err := db.MustNewDB(cfg.Sql.Host, cfg.Sql.User, cfg.Sql.Password, cfg.Sql.Port)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, authDB, nil
+}
@@ -34,7 +34,7 @@ func MustNewDB(host, user, password string, port int) (*sql.DB, error) {
 	}
 
 	//open conn with DB
-	dsn = fmt.Sprintf(dbConnFormat+"auth", user, password, host, port)
+	dsn = fmt.Sprintf(dbConnFormat+"auth?parseTime=true", user, password, host, port)
 	db, err = sql.Open("mysql", dsn)
 
 	if err != nil {
@@ -0,0 +1,162 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/ireuven89/auctions/shared/encryption"
+)
+
+// EncryptPersonalData encrypts and indexes the personal data written before the encryption was turned on,
+// rows already encrypted are left alone so it can be run again
+func EncryptPersonalData(ctx context.Context, db *sql.DB, fields *encryption.FieldCipher) (int, error) {
+	type plainUser struct {
+		id    string
+		name  string
+		email sql.NullString
+	}
+
+	rows, err := db.QueryContext(ctx, "select id, name, email from users where name_index is null")
+
+	if err != nil {
+		return 0, fmt.Errorf("EncryptPersonalData %w", err)
+	}
+
+	var users []plainUser
+	for rows.Next() {
+		var u plainUser
+
+		if err = rows.Scan(&u.id, &u.name, &u.email); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("EncryptPersonalData failed scanning user %w", err)
+		}
+
+		users = append(users, u)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return 0, fmt.Errorf("EncryptPersonalData %w", err)
+	}
+
+	for i, u := range users {
+		name, err := fields.Encrypt(u.name)
+
+		if err != nil {
+			return i, fmt.Errorf("EncryptPersonalData failed encrypting user %s %w", u.id, err)
+		}
+
+		email, err := fields.Encrypt(u.email.String)
+
+		if err != nil {
+			return i, fmt.Errorf("EncryptPersonalData failed encrypting user %s %w", u.id, err)
+		}
+
+		_, err = db.ExecContext(ctx, "update users set name = ?, name_index = ?, email = ?, email_index = ? where id = ? and name_index is null",
+			name, nullString(fields.BlindIndex(u.name)), nullString(email), nullString(fields.BlindIndex(u.email.String)), u.id)
+
+		if err != nil {
+			return i, fmt.Errorf("EncryptPersonalData failed updating user %s %w", u.id, err)
+		}
+	}
+
+	if err = encryptIdentityEmails(ctx, db, fields); err != nil {
+		return len(users), fmt.Errorf("EncryptPersonalData %w", err)
+	}
+
+	if err = encryptInvitationEmails(ctx, db, fields); err != nil {
+		return len(users), fmt.Errorf("EncryptPersonalData %w", err)
+	}
+
+	return len(users), nil
+}
+
+func encryptIdentityEmails(ctx context.Context, db *sql.DB, fields *encryption.FieldCipher) error {
+	type identity struct {
+		provider, subject, email string
+	}
+
+	rows, err := db.QueryContext(ctx, "select provider, subject, email from user_identities where email is not null and email not like 'enc:%'")
+
+	if err != nil {
+		return err
+	}
+
+	var identities []identity
+	for rows.Next() {
+		var i identity
+
+		if err = rows.Scan(&i.provider, &i.subject, &i.email); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed scanning identity %w", err)
+		}
+
+		identities = append(identities, i)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, i := range identities {
+		email, err := fields.Encrypt(i.email)
+
+		if err != nil {
+			return fmt.Errorf("failed encrypting identity %w", err)
+		}
+
+		if _, err = db.ExecContext(ctx, "update user_identities set email = ? where provider = ? and subject = ?", email, i.provider, i.subject); err != nil {
+			return fmt.Errorf("failed updating identity %w", err)
+		}
+	}
+
+	return nil
+}
+
+func encryptInvitationEmails(ctx context.Context, db *sql.DB, fields *encryption.FieldCipher) error {
+	type invitation struct {
+		id, email string
+	}
+
+	rows, err := db.QueryContext(ctx, "select id, email from organization_invitations where email_index is null")
+
+	if err != nil {
+		return err
+	}
+
+	var invitations []invitation
+	for rows.Next() {
+		var i invitation
+
+		if err = rows.Scan(&i.id, &i.email); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed scanning invitation %w", err)
+		}
+
+		invitations = append(invitations, i)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, i := range invitations {
+		email, err := fields.Encrypt(i.email)
+
+		if err != nil {
+			return fmt.Errorf("failed encrypting invitation %w", err)
+		}
+
+		_, err = db.ExecContext(ctx, "update organization_invitations set email = ?, email_index = ? where id = ? and email_index is null",
+			email, nullString(fields.BlindIndex(i.email)), i.id)
+
+		if err != nil {
+			return fmt.Errorf("failed updating invitation %w", err)
+		}
+	}
+
+	return nil
+}
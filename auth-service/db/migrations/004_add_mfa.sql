@@ -0,0 +1,20 @@
+-- +goose Up
+
+create table user_mfa(
+    user_id varchar(36) primary key,
+    secret varchar(255) not null,
+    enabled boolean not null default false,
+    -- the TOTP period of the last accepted code, a code is only accepted once
+    last_step bigint null,
+    created_at timestamp default current_timestamp,
+    updated_at timestamp default current_timestamp on update current_timestamp,
+    foreign key (user_id) references users (id) on delete cascade
+);
+
+create table user_recovery_codes(
+    user_id varchar(36) not null,
+    code_hash char(64) not null,
+    created_at timestamp default current_timestamp,
+    primary key (user_id, code_hash),
+    foreign key (user_id) references users (id) on delete cascade
+);
@@ -0,0 +1,3 @@
+-- +goose Up
+
+alter table users add column role varchar(20) not null default 'user';
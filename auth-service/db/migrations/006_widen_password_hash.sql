@@ -0,0 +1,4 @@
+-- +goose Up
+
+-- argon2id PHC strings are longer than the 60 characters of bcrypt
+alter table users modify column password varchar(255);
@@ -0,0 +1,9 @@
+-- +goose Up
+
+create table service_clients(
+    id varchar(64) primary key,
+    name varchar(255) not null,
+    secret_hash varchar(255) not null,
+    scopes varchar(1024) not null default '',
+    created_at timestamp default current_timestamp
+);
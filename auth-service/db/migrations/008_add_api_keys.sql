@@ -0,0 +1,14 @@
+-- +goose Up
+
+create table api_keys(
+    id varchar(36) primary key,
+    user_id varchar(36) not null,
+    name varchar(255) not null,
+    prefix varchar(16) not null,
+    key_hash char(64) not null unique,
+    scopes varchar(1024) not null default '',
+    expires_at timestamp null,
+    last_used_at timestamp null,
+    created_at timestamp default current_timestamp,
+    foreign key (user_id) references users (id) on delete cascade
+);
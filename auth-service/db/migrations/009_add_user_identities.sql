@@ -0,0 +1,13 @@
+-- +goose Up
+
+create table user_identities(
+    provider varchar(64) not null,
+    subject varchar(255) not null,
+    user_id varchar(36) not null,
+    email varchar(255),
+    created_at timestamp default current_timestamp,
+    primary key (provider, subject),
+    foreign key (user_id) references users (id) on delete cascade
+);
+
+create index idx_user_identities_user on user_identities (user_id);
@@ -0,0 +1,35 @@
+-- +goose Up
+
+create table organizations(
+    id varchar(36) primary key,
+    name varchar(255) not null,
+    created_at timestamp default current_timestamp
+);
+
+create table organization_members(
+    organization_id varchar(36) not null,
+    user_id varchar(36) not null,
+    role varchar(16) not null,
+    created_at timestamp default current_timestamp,
+    primary key (organization_id, user_id),
+    foreign key (organization_id) references organizations (id) on delete cascade,
+    foreign key (user_id) references users (id) on delete cascade
+);
+
+create index idx_organization_members_user on organization_members (user_id);
+
+create table organization_invitations(
+    id varchar(36) primary key,
+    organization_id varchar(36) not null,
+    email varchar(255) not null,
+    role varchar(16) not null,
+    token_hash char(64) not null unique,
+    invited_by varchar(36) not null,
+    expires_at timestamp not null,
+    accepted_at timestamp null,
+    created_at timestamp default current_timestamp,
+    foreign key (organization_id) references organizations (id) on delete cascade
+);
+
+alter table api_keys add column organization_id varchar(36) null,
+    add foreign key (organization_id) references organizations (id) on delete cascade;
@@ -0,0 +1,31 @@
+-- +goose Up
+
+create table audit_events(
+    id bigint auto_increment primary key,
+    type varchar(32) not null,
+    outcome varchar(16) not null,
+    actor_id varchar(36) null,
+    subject_id varchar(36) null,
+    identifier char(64) null,
+    ip varchar(64) null,
+    user_agent varchar(512) null,
+    reason varchar(255) null,
+    details json null,
+    created_at timestamp(6) not null default current_timestamp(6)
+);
+
+create index idx_audit_events_type on audit_events (type, created_at);
+create index idx_audit_events_actor on audit_events (actor_id, created_at);
+create index idx_audit_events_subject on audit_events (subject_id, created_at);
+create index idx_audit_events_identifier on audit_events (identifier, created_at);
+
+-- the log is append only, rows are never changed or removed by the service
+-- +goose StatementBegin
+create trigger audit_events_no_update before update on audit_events
+for each row signal sqlstate '45000' set message_text = 'audit_events is append only';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+create trigger audit_events_no_delete before delete on audit_events
+for each row signal sqlstate '45000' set message_text = 'audit_events is append only';
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+
+-- data keys of the personal data encryption, wrapped by the master key
+create table encryption_keys(
+    id varchar(64) primary key,
+    purpose varchar(16) not null,
+    wrapped_key varbinary(1024) not null,
+    master_key_id varchar(255) not null,
+    created_at timestamp(6) not null default current_timestamp(6)
+);
+
+-- the encrypted columns are randomized, uniqueness and lookups move to the blind indexes.
+-- rows written before are encrypted and indexed by auth-service/cmd/piikeys backfill
+alter table users modify name varchar(1024) not null,
+    modify email varchar(1024),
+    add column name_index char(64) null,
+    add column email_index char(64) null,
+    drop index unique_name,
+    drop index unique_email,
+    add constraint unique_name_index unique (name_index),
+    add constraint unique_email_index unique (email_index);
+
+alter table user_identities modify email varchar(1024);
+
+-- invitations are accepted by the blind index of the invited email
+alter table organization_invitations modify email varchar(1024) not null,
+    add column email_index char(64) null,
+    add index idx_organization_invitations_email (email_index);
@@ -0,0 +1,9 @@
+-- +goose Up
+
+-- messages the consumers handled, a message delivered again is skipped, see messaging.SQLProcessedStore
+create table if not exists processed_messages (
+    queue varchar(255) not null,
+    message_id varchar(64) not null,
+    processed_at timestamp(6) not null default current_timestamp(6),
+    primary key (queue, message_id)
+);
@@ -3,11 +3,25 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/ireuven89/auctions/auth-service/apikey"
+	"github.com/ireuven89/auctions/auth-service/audit"
+	"github.com/ireuven89/auctions/auth-service/federation"
 	"github.com/ireuven89/auctions/auth-service/key"
+	"github.com/ireuven89/auctions/auth-service/magiclink"
+	"github.com/ireuven89/auctions/auth-service/oauth"
+	"github.com/ireuven89/auctions/auth-service/organization"
 	"github.com/ireuven89/auctions/auth-service/user"
+	"github.com/ireuven89/auctions/shared/encryption"
+	"github.com/ireuven89/auctions/shared/messaging/outbox"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
@@ -20,6 +34,7 @@ type UserDB struct {
 	password string `db:"password"` // <-- do NOT include in public struct or JSON output
 	// <-- do NOT include in public struct or JSON output
 	email string `db:"email"`
+	role  string `db:"role"`
 }
 
 func toUser(userDB UserDB) *user.User {
@@ -28,54 +43,175 @@ func toUser(userDB UserDB) *user.User {
 		ID:       userDB.id,
 		Name:     userDB.name,
 		Email:    userDB.email,
+		Role:     userDB.role,
 		Password: userDB.password,
 	}
 }
 
 var refresh = "refresh:%s"
 var refreshRate = "refresh:rate:%s"
+var sessionKey = "session:%s"
+var userSessions = "user:sessions:%s"
+var passwordReset = "reset:%s"
+var emailChange = "email:change:%s"
+var accessRevoked = "access:revoked:%s"
+var magicLink = "magic:%s"
+var attempts = "attempts:%s"
+var federationState = "oidc:state:%s"
 var MaxRefreshRate = 3
 var refreshRateTtl = 15 * time.Minute
 
 type Repository interface {
-	CreateUser(ctx context.Context, user user.User) error
+	// CreateUser writes the events to the outbox in the transaction creating the user
+	CreateUser(ctx context.Context, user user.User, events ...outbox.Event) error
 	FindUser(ctx context.Context, id string) (*user.User, error)
 	FindUserByCredentials(ctx context.Context, identifier string) (*user.User, error)
-	SaveRefreshToken(ctx context.Context, token string, userInfo string, ttl time.Duration) error
+	SaveRefreshToken(ctx context.Context, token string, session user.Session, ttl time.Duration) error
 	GetToken(ctx context.Context, token string) (string, error)
 	GetRefreshRate(ctx context.Context, token string) (int, error)
-	DeleteUser(ctx context.Context, id string) error
+	DeleteUser(ctx context.Context, id string, events ...outbox.Event) error
+	UpdatePassword(ctx context.Context, id, password string) error
+	RevokeRefreshTokens(ctx context.Context, userID string) error
+	ListSessions(ctx context.Context, userID string) ([]user.Session, error)
+	DeleteSession(ctx context.Context, userID, sessionID string) error
+	SaveResetToken(ctx context.Context, tokenHash, userID string, ttl time.Duration) error
+	ConsumeResetToken(ctx context.Context, tokenHash string) (string, time.Duration, error)
+	UpdateUser(ctx context.Context, user user.User) error
+	SaveEmailChange(ctx context.Context, tokenHash, userID, email string, ttl time.Duration) error
+	ConsumeEmailChange(ctx context.Context, tokenHash string) (string, string, error)
+	SaveMFASecret(ctx context.Context, userID, secret string) error
+	FindMFA(ctx context.Context, userID string) (*user.MFA, error)
+	EnableMFA(ctx context.Context, userID string, recoveryCodeHashes []string) error
+	DisableMFA(ctx context.Context, userID string) error
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	CreateClient(ctx context.Context, client oauth.Client) error
+	FindClient(ctx context.Context, id string) (*oauth.Client, error)
+	DeleteClient(ctx context.Context, id string) error
+	CreateAPIKey(ctx context.Context, key apikey.APIKey) error
+	ListAPIKeys(ctx context.Context, userID string) ([]apikey.APIKey, error)
+	FindAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, error)
+	DeleteAPIKey(ctx context.Context, userID, id string) error
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+	FindRefreshToken(ctx context.Context, token string) (string, time.Duration, error)
+	RevokeAccessTokens(ctx context.Context, userID string, at time.Time, ttl time.Duration) error
+	AccessTokensRevokedAt(ctx context.Context, userID string) (time.Time, error)
+	CountAttempt(ctx context.Context, bucket string, window time.Duration) (int64, error)
+	ResetAttempts(ctx context.Context, bucket string) error
+	SaveMagicLink(ctx context.Context, tokenHash string, link magiclink.Link, ttl time.Duration) error
+	FindMagicLink(ctx context.Context, tokenHash string) (*magiclink.Link, error)
+	ConsumeMagicLink(ctx context.Context, tokenHash string) (*magiclink.Link, error)
+	FindIdentity(ctx context.Context, provider, subject string) (string, error)
+	CreateIdentity(ctx context.Context, userID string, identity federation.Identity) error
+	CreateUserWithIdentity(ctx context.Context, user user.User, identity federation.Identity, events ...outbox.Event) error
+	SaveFederationState(ctx context.Context, state string, values federation.State, ttl time.Duration) error
+	ConsumeFederationState(ctx context.Context, state string) (*federation.State, error)
+	CreateOrganization(ctx context.Context, org organization.Organization, owner organization.Member) error
+	ListOrganizations(ctx context.Context, userID string) ([]organization.Membership, error)
+	FindMember(ctx context.Context, orgID, userID string) (*organization.Member, error)
+	ListMembers(ctx context.Context, orgID string) ([]organization.Member, error)
+	CountOwners(ctx context.Context, orgID string) (int, error)
+	UpdateMemberRole(ctx context.Context, orgID, userID, role string) error
+	DeleteMember(ctx context.Context, orgID, userID string) error
+	CreateInvitation(ctx context.Context, invitation organization.Invitation) error
+	FindInvitation(ctx context.Context, tokenHash string) (*organization.Invitation, error)
+	AcceptInvitation(ctx context.Context, invitationID string, member organization.Member) error
+	DeleteRefreshToken(ctx context.Context, token string) (string, error)
+	AppendAuditEvent(ctx context.Context, event audit.Event) error
+	ListAuditEvents(ctx context.Context, filter audit.Filter) ([]audit.Event, error)
+	ExportAuditEvents(ctx context.Context, filter audit.Filter, fn func(audit.Event) error) error
 }
 
 type UserRepo struct {
 	db     *sql.DB
 	logger *zap.Logger
 	redis  *redis.Client
+	// fields encrypts the personal data columns
+	fields *encryption.FieldCipher
 }
 
-func New(logger *zap.Logger, db *sql.DB, redisDB *redis.Client) Repository {
+func New(logger *zap.Logger, db *sql.DB, redisDB *redis.Client, fields *encryption.FieldCipher) Repository {
 
 	return &UserRepo{
 		db:     db,
 		logger: logger,
 		redis:  redisDB,
+		fields: fields,
 	}
 }
 
-func (r *UserRepo) CreateUser(ctx context.Context, user user.User) error {
-	_, err := r.db.ExecContext(ctx, "insert into users (id, name, password, email) values(?, ?, ?, ?)", user.ID, user.Name, user.Password, user.Email)
+// seal encrypts a personal data value and returns the blind index it is looked up by
+func (r *UserRepo) seal(value string) (string, sql.NullString, error) {
+	sealed, err := r.fields.Encrypt(value)
 
 	if err != nil {
+		return "", sql.NullString{}, err
+	}
+
+	return sealed, nullString(r.fields.BlindIndex(value)), nil
+}
+
+// openUser decrypts the personal data of a user read from the users table
+func (r *UserRepo) openUser(ctx context.Context, userDB *UserDB) error {
+	var err error
+
+	if userDB.name, err = r.fields.Decrypt(ctx, userDB.name); err != nil {
+		return err
+	}
+
+	userDB.email, err = r.fields.Decrypt(ctx, userDB.email)
+
+	return err
+}
+
+func (r *UserRepo) CreateUser(ctx context.Context, user user.User, events ...outbox.Event) error {
+	name, nameIndex, err := r.seal(user.Name)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateUser failed encrypting name %w", err)
+	}
+
+	email, emailIndex, err := r.seal(user.Email)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateUser failed encrypting email %w", err)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateUser %w", err)
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "insert into users (id, name, name_index, password, email, email_index) values(?, ?, ?, ?, ?, ?)",
+		user.ID, name, nameIndex, user.Password, email, emailIndex)
+
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return key.ErrAlreadyExists
+		}
+
 		r.logger.Error("UserRepo.CreateUser", zap.Error(err))
 		return fmt.Errorf("UserRepo.CreateUser %w", err)
 	}
 
+	if err = outbox.Insert(ctx, tx, events...); err != nil {
+		return fmt.Errorf("UserRepo.CreateUser %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UserRepo.CreateUser %w", err)
+	}
+
 	return nil
 }
 
 func (r *UserRepo) FindUser(ctx context.Context, id string) (*user.User, error) {
 	var userDB UserDB
-	row := r.db.QueryRowContext(ctx, "select id, name, email from users where id = ?", id)
+	var password sql.NullString
+	row := r.db.QueryRowContext(ctx, "select id, name, email, role, password from users where id = ?", id)
 
 	if row.Err() != nil {
 		r.logger.Error("UserRepo.FindUser", zap.Error(row.Err()))
@@ -83,20 +219,146 @@ func (r *UserRepo) FindUser(ctx context.Context, id string) (*user.User, error)
 		return nil, fmt.Errorf("UserRepo.FindUser failed fetching user %w", row.Err())
 	}
 
-	if err := row.Scan(&userDB.id, &userDB.name, &userDB.email); err != nil {
+	if err := row.Scan(&userDB.id, &userDB.name, &userDB.email, &userDB.role, &password); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, key.ErrUserNotFound
+		}
+
 		return nil, fmt.Errorf("UserRepo.FindUser failed fetching user %w", err)
 	}
+	userDB.password = password.String
+
+	if err := r.openUser(ctx, &userDB); err != nil {
+		return nil, fmt.Errorf("UserRepo.FindUser failed decrypting user %w", err)
+	}
 
 	return toUser(userDB), nil
 }
 
-func (r *UserRepo) SaveRefreshToken(ctx context.Context, token string, userInfo string, ttl time.Duration) error {
+func (r *UserRepo) UpdateUser(ctx context.Context, user user.User) error {
+	sealed := user
+	var nameIndex, emailIndex sql.NullString
+	var err error
+
+	if sealed.Name, nameIndex, err = r.seal(user.Name); err != nil {
+		return fmt.Errorf("UserRepo.UpdateUser failed encrypting name %w", err)
+	}
+
+	if sealed.Email, emailIndex, err = r.seal(user.Email); err != nil {
+		return fmt.Errorf("UserRepo.UpdateUser failed encrypting email %w", err)
+	}
+
+	q, args, err := prepareUpdateUserQuery(sealed, nameIndex, emailIndex)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.UpdateUser failed preparing query %w", err)
+	}
+
+	res, err := r.db.ExecContext(ctx, q, args...)
+
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return key.ErrAlreadyExists
+		}
+		r.logger.Error("UserRepo.UpdateUser", zap.Error(err), zap.String("id", user.ID))
+		return fmt.Errorf("UserRepo.UpdateUser failed updating user %w", err)
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		if _, err = r.FindUser(ctx, user.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prepareUpdateUserQuery updates only the non empty fields, the password has a dedicated query.
+// The personal fields come encrypted, their blind indexes are updated with them
+func prepareUpdateUserQuery(user user.User, nameIndex, emailIndex sql.NullString) (string, []interface{}, error) {
+	query := "update users set "
+	var sets []string
+	var args []interface{}
+
+	if user.Name != "" {
+		sets = append(sets, "name = ?", "name_index = ?")
+		args = append(args, user.Name, nameIndex)
+	}
+
+	if user.Email != "" {
+		sets = append(sets, "email = ?", "email_index = ?")
+		args = append(args, user.Email, emailIndex)
+	}
+
+	if user.Role != "" {
+		sets = append(sets, "role = ?")
+		args = append(args, user.Role)
+	}
+
+	if len(sets) == 0 {
+		return "", nil, fmt.Errorf("no fields to update")
+	}
+
+	query += strings.Join(sets, ", ") + " where id = ?"
+	args = append(args, user.ID)
+
+	return query, args, nil
+}
+
+func isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+func (r *UserRepo) SaveEmailChange(ctx context.Context, tokenHash, userID, email string, ttl time.Duration) error {
+	k := fmt.Sprintf(emailChange, tokenHash)
+
+	if err := r.redis.HSet(ctx, k, map[string]interface{}{"user_id": userID, "email": email}).Err(); err != nil {
+		return fmt.Errorf("UserRepo.SaveEmailChange failed saving %w", err)
+	}
+
+	if err := r.redis.Expire(ctx, k, ttl).Err(); err != nil {
+		return fmt.Errorf("UserRepo.SaveEmailChange failed setting ttl %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeEmailChange returns the user and the new email of a pending change and deletes it
+func (r *UserRepo) ConsumeEmailChange(ctx context.Context, tokenHash string) (string, string, error) {
+	k := fmt.Sprintf(emailChange, tokenHash)
+
+	//read and delete atomically so the token can't be used twice
+	var getCmd *redis.MapStringStringCmd
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		getCmd = pipe.HGetAll(ctx, k)
+		pipe.Del(ctx, k)
+		return nil
+	})
+
+	if err != nil {
+		return "", "", fmt.Errorf("UserRepo.ConsumeEmailChange %w", err)
+	}
+
+	values := getCmd.Val()
+
+	if values["user_id"] == "" {
+		return "", "", key.ErrInvalidToken
+	}
+
+	return values["user_id"], values["email"], nil
+}
+
+// SaveRefreshToken stores the token together with its session so it can be listed and revoked per device
+func (r *UserRepo) SaveRefreshToken(ctx context.Context, token string, session user.Session, ttl time.Duration) error {
 
 	//set refresh
 	statusCmd := r.redis.HSet(ctx,
 		fmt.Sprintf(refresh, token),
 		map[string]interface{}{
-			"user_info":    userInfo,
+			"user_info":    session.UserID,
+			"session_id":   session.ID,
 			"refresh_rate": MaxRefreshRate,
 		})
 
@@ -111,31 +373,348 @@ func (r *UserRepo) SaveRefreshToken(ctx context.Context, token string, userInfo
 		return fmt.Errorf("SaveRefreshToken failed saving %w", err)
 	}
 
+	_, err = r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, fmt.Sprintf(sessionKey, session.ID), map[string]interface{}{
+			"user_id":      session.UserID,
+			"token":        token,
+			"user_agent":   session.UserAgent,
+			"ip":           session.IP,
+			"device":       session.Device,
+			"created_at":   session.CreatedAt.Unix(),
+			"last_used_at": session.LastUsedAt.Unix(),
+		})
+		pipe.Expire(ctx, fmt.Sprintf(sessionKey, session.ID), ttl)
+		//index the session by user so the user's sessions can be listed and revoked
+		pipe.SAdd(ctx, fmt.Sprintf(userSessions, session.UserID), session.ID)
+		pipe.Expire(ctx, fmt.Sprintf(userSessions, session.UserID), ttl)
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("SaveRefreshToken failed saving session %w", err)
+	}
+
 	return nil
 }
 
-func (r *UserRepo) GetToken(ctx context.Context, token string) (string, error) {
-	val, err := r.redis.Get(ctx, fmt.Sprintf(refresh, token)).Result()
+// ListSessions returns the user's live sessions, expired ones are dropped from the index on the way
+func (r *UserRepo) ListSessions(ctx context.Context, userID string) ([]user.Session, error) {
+	ids, err := r.redis.SMembers(ctx, fmt.Sprintf(userSessions, userID)).Result()
+
+	if err != nil {
+		return nil, fmt.Errorf("UserRepo.ListSessions failed fetching sessions %w", err)
+	}
+
+	sessions := make([]user.Session, 0, len(ids))
+	for _, id := range ids {
+		values, err := r.redis.HGetAll(ctx, fmt.Sprintf(sessionKey, id)).Result()
+
+		if err != nil {
+			return nil, fmt.Errorf("UserRepo.ListSessions failed fetching session %s %w", id, err)
+		}
+
+		if len(values) == 0 {
+			r.redis.SRem(ctx, fmt.Sprintf(userSessions, userID), id)
+			continue
+		}
+
+		sessions = append(sessions, toSession(id, values))
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+func toSession(id string, values map[string]string) user.Session {
+	createdAt, _ := strconv.ParseInt(values["created_at"], 10, 64)
+	lastUsedAt, _ := strconv.ParseInt(values["last_used_at"], 10, 64)
+
+	return user.Session{
+		ID:         id,
+		UserID:     values["user_id"],
+		UserAgent:  values["user_agent"],
+		IP:         values["ip"],
+		Device:     values["device"],
+		CreatedAt:  time.Unix(createdAt, 0).UTC(),
+		LastUsedAt: time.Unix(lastUsedAt, 0).UTC(),
+	}
+}
+
+// DeleteSession revokes a single session, sessions of other users are reported as not found
+func (r *UserRepo) DeleteSession(ctx context.Context, userID, sessionID string) error {
+	values, err := r.redis.HGetAll(ctx, fmt.Sprintf(sessionKey, sessionID)).Result()
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.DeleteSession failed fetching session %w", err)
+	}
+
+	if len(values) == 0 || values["user_id"] != userID {
+		return key.ErrNotFound
+	}
+
+	_, err = r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, fmt.Sprintf(sessionKey, sessionID), fmt.Sprintf(refresh, values["token"]), fmt.Sprintf(refreshRate, values["token"]))
+		pipe.SRem(ctx, fmt.Sprintf(userSessions, userID), sessionID)
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.DeleteSession failed deleting session %w", err)
+	}
+
+	return nil
+}
+
+// DeleteRefreshToken ends the session of the token and returns its user, unknown tokens are reported as expired
+func (r *UserRepo) DeleteRefreshToken(ctx context.Context, token string) (string, error) {
+	values, err := r.redis.HGetAll(ctx, fmt.Sprintf(refresh, token)).Result()
+
+	if err != nil {
+		return "", fmt.Errorf("UserRepo.DeleteRefreshToken failed fetching token %w", err)
+	}
+
+	if len(values) == 0 {
+		return "", key.ErrExpiredToken
+	}
+
+	userID, sessionID := values["user_info"], values["session_id"]
+	_, err = r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, fmt.Sprintf(refresh, token), fmt.Sprintf(refreshRate, token))
+		if sessionID != "" {
+			pipe.Del(ctx, fmt.Sprintf(sessionKey, sessionID))
+			pipe.SRem(ctx, fmt.Sprintf(userSessions, userID), sessionID)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("UserRepo.DeleteRefreshToken failed deleting token %w", err)
+	}
+
+	return userID, nil
+}
+
+func (r *UserRepo) RevokeRefreshTokens(ctx context.Context, userID string) error {
+	ids, err := r.redis.SMembers(ctx, fmt.Sprintf(userSessions, userID)).Result()
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.RevokeRefreshTokens failed fetching sessions %w", err)
+	}
+
+	keys := []string{fmt.Sprintf(userSessions, userID)}
+	for _, id := range ids {
+		token, err := r.redis.HGet(ctx, fmt.Sprintf(sessionKey, id), "token").Result()
+
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("UserRepo.RevokeRefreshTokens failed fetching session %w", err)
+		}
+
+		keys = append(keys, fmt.Sprintf(sessionKey, id))
+		if token != "" {
+			keys = append(keys, fmt.Sprintf(refresh, token), fmt.Sprintf(refreshRate, token))
+		}
+	}
+
+	if err = r.redis.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("UserRepo.RevokeRefreshTokens failed deleting tokens %w", err)
+	}
+
+	return nil
+}
+
+// FindRefreshToken looks a refresh token up without using it, it returns the user and the time the token has left
+func (r *UserRepo) FindRefreshToken(ctx context.Context, token string) (string, time.Duration, error) {
+	var userCmd *redis.StringCmd
+	var ttlCmd *redis.DurationCmd
+	_, err := r.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		userCmd = pipe.HGet(ctx, fmt.Sprintf(refresh, token), "user_info")
+		ttlCmd = pipe.TTL(ctx, fmt.Sprintf(refresh, token))
+		return nil
+	})
+
+	if err != nil && err != redis.Nil {
+		return "", 0, fmt.Errorf("UserRepo.FindRefreshToken %w", err)
+	}
+
+	if userCmd.Val() == "" || ttlCmd.Val() <= 0 {
+		return "", 0, key.ErrExpiredToken
+	}
+
+	return userCmd.Val(), ttlCmd.Val(), nil
+}
+
+// RevokeAccessTokens marks the access tokens the user was issued up to at as revoked,
+// the mark only has to live as long as the tokens it covers
+func (r *UserRepo) RevokeAccessTokens(ctx context.Context, userID string, at time.Time, ttl time.Duration) error {
+
+	if err := r.redis.Set(ctx, fmt.Sprintf(accessRevoked, userID), at.Unix(), ttl).Err(); err != nil {
+		return fmt.Errorf("UserRepo.RevokeAccessTokens %w", err)
+	}
+
+	return nil
+}
+
+// AccessTokensRevokedAt returns when the user's access tokens were last revoked, the zero time if they weren't
+func (r *UserRepo) AccessTokensRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	at, err := r.redis.Get(ctx, fmt.Sprintf(accessRevoked, userID)).Int64()
 
 	if err != nil {
 		if err == redis.Nil {
-			return "", key.ErrExpiredToken
+			return time.Time{}, nil
 		}
 
-		return "", fmt.Errorf("UserRepo.GetToken")
+		return time.Time{}, fmt.Errorf("UserRepo.AccessTokensRevokedAt %w", err)
 	}
 
-	refreshRateVal, err := r.redis.Get(ctx, fmt.Sprintf(refreshRate, token)).Int()
+	return time.Unix(at, 0), nil
+}
+
+// CountAttempt records an attempt in a fixed window and returns how many were made in it so far
+func (r *UserRepo) CountAttempt(ctx context.Context, bucket string, window time.Duration) (int64, error) {
+	k := fmt.Sprintf(attempts, bucket)
+
+	var incrCmd *redis.IntCmd
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incrCmd = pipe.Incr(ctx, k)
+		//only the first attempt opens the window
+		pipe.ExpireNX(ctx, k, window)
+		return nil
+	})
 
 	if err != nil {
-		return "", fmt.Errorf("UserRepo.GetToken failed setting refresh rate %w", err)
+		return 0, fmt.Errorf("UserRepo.CountAttempt %w", err)
+	}
+
+	return incrCmd.Val(), nil
+}
+
+// ResetAttempts closes the window of the bucket, the next attempt opens a new one
+func (r *UserRepo) ResetAttempts(ctx context.Context, bucket string) error {
+
+	if err := r.redis.Del(ctx, fmt.Sprintf(attempts, bucket)).Err(); err != nil {
+		return fmt.Errorf("UserRepo.ResetAttempts %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepo) SaveMagicLink(ctx context.Context, tokenHash string, link magiclink.Link, ttl time.Duration) error {
+	k := fmt.Sprintf(magicLink, tokenHash)
+
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, k, map[string]interface{}{
+			"user":        link.UserID,
+			"fingerprint": link.Fingerprint,
+		})
+		pipe.Expire(ctx, k, ttl)
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.SaveMagicLink failed saving %w", err)
+	}
+
+	return nil
+}
+
+// FindMagicLink returns a link without using it up
+func (r *UserRepo) FindMagicLink(ctx context.Context, tokenHash string) (*magiclink.Link, error) {
+	values, err := r.redis.HGetAll(ctx, fmt.Sprintf(magicLink, tokenHash)).Result()
+
+	if err != nil {
+		return nil, fmt.Errorf("UserRepo.FindMagicLink %w", err)
+	}
+
+	return toMagicLink(values)
+}
+
+// ConsumeMagicLink returns the link and deletes it, so every link works once
+func (r *UserRepo) ConsumeMagicLink(ctx context.Context, tokenHash string) (*magiclink.Link, error) {
+	k := fmt.Sprintf(magicLink, tokenHash)
+
+	var getCmd *redis.MapStringStringCmd
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		getCmd = pipe.HGetAll(ctx, k)
+		pipe.Del(ctx, k)
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("UserRepo.ConsumeMagicLink %w", err)
+	}
+
+	return toMagicLink(getCmd.Val())
+}
+
+func toMagicLink(values map[string]string) (*magiclink.Link, error) {
+	if values["user"] == "" {
+		return nil, key.ErrInvalidToken
+	}
+
+	return &magiclink.Link{UserID: values["user"], Fingerprint: values["fingerprint"]}, nil
+}
+
+func (r *UserRepo) SaveResetToken(ctx context.Context, tokenHash, userID string, ttl time.Duration) error {
+
+	if err := r.redis.Set(ctx, fmt.Sprintf(passwordReset, tokenHash), userID, ttl).Err(); err != nil {
+		return fmt.Errorf("UserRepo.SaveResetToken failed saving %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeResetToken returns the user the token was issued for and how long it had left, and deletes it so a
+// token can be used only once
+func (r *UserRepo) ConsumeResetToken(ctx context.Context, tokenHash string) (string, time.Duration, error) {
+	k := fmt.Sprintf(passwordReset, tokenHash)
+
+	var ttlCmd *redis.DurationCmd
+	var userCmd *redis.StringCmd
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		ttlCmd = pipe.TTL(ctx, k)
+		userCmd = pipe.GetDel(ctx, k)
+		return nil
+	})
+
+	if err != nil && err != redis.Nil {
+		return "", 0, fmt.Errorf("UserRepo.ConsumeResetToken %w", err)
+	}
+
+	if userCmd.Val() == "" || ttlCmd.Val() <= 0 {
+		return "", 0, key.ErrInvalidToken
+	}
+
+	return userCmd.Val(), ttlCmd.Val(), nil
+}
+
+func (r *UserRepo) GetToken(ctx context.Context, token string) (string, error) {
+	values, err := r.redis.HGetAll(ctx, fmt.Sprintf(refresh, token)).Result()
+
+	if err != nil {
+		return "", fmt.Errorf("UserRepo.GetToken %w", err)
+	}
+
+	if len(values) == 0 {
+		return "", key.ErrExpiredToken
 	}
 
-	if err = r.redis.Set(ctx, fmt.Sprintf(refreshRate, token), refreshRateVal-1, refreshRateTtl).Err(); err != nil {
+	if err = r.redis.HIncrBy(ctx, fmt.Sprintf(refresh, token), "refresh_rate", -1).Err(); err != nil {
 		return "", fmt.Errorf("UserRepo.GetToken failed setting refresh rate %w", err)
 	}
 
-	return val, nil
+	if sessionID := values["session_id"]; sessionID != "" {
+		if err = r.redis.HSet(ctx, fmt.Sprintf(sessionKey, sessionID), "last_used_at", time.Now().Unix()).Err(); err != nil {
+			return "", fmt.Errorf("UserRepo.GetToken failed updating session %w", err)
+		}
+	}
+
+	return values["user_info"], nil
 }
 
 func (r *UserRepo) GetRefreshRate(ctx context.Context, token string) (int, error) {
@@ -165,27 +744,896 @@ func (r *UserRepo) SaveAccessToken(ctx context.Context, userId, token string, tt
 
 func (r *UserRepo) FindUserByCredentials(ctx context.Context, identifier string) (*user.User, error) {
 	var userDB UserDB
-	row := r.db.QueryRowContext(ctx, "SELECT id, name, email, password FROM users WHERE name = ? OR email = ?", identifier, identifier)
+	// users who signed up through an identity provider have no password
+	var password sql.NullString
+	// the identifier is either the email or the name, both are indexed the same way. The email is looked up
+	// first so a name picked to read like the email of another user never stands in for that user
+	index := r.fields.BlindIndex(identifier)
+	err := r.db.QueryRowContext(ctx, "SELECT id, name, email, role, password FROM users WHERE email_index = ?", index).
+		Scan(&userDB.id, &userDB.name, &userDB.email, &userDB.role, &password)
 
-	if row.Err() != nil {
-		return nil, fmt.Errorf("failed fetching user %w", row.Err())
+	if errors.Is(err, sql.ErrNoRows) {
+		err = r.db.QueryRowContext(ctx, "SELECT id, name, email, role, password FROM users WHERE name_index = ?", index).
+			Scan(&userDB.id, &userDB.name, &userDB.email, &userDB.role, &password)
 	}
 
-	if err := row.Scan(&userDB.id, &userDB.name, &userDB.email, &userDB.password); err != nil {
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, key.ErrUserNotFound
+		}
+
 		return nil, fmt.Errorf("failed scan user result %w", err)
 	}
+	userDB.password = password.String
+
+	if err := r.openUser(ctx, &userDB); err != nil {
+		return nil, fmt.Errorf("failed decrypting user %w", err)
+	}
 
 	userResult := toUser(userDB)
 
 	return userResult, nil
 }
 
-func (r *UserRepo) DeleteUser(ctx context.Context, id string) error {
+func (r *UserRepo) DeleteUser(ctx context.Context, id string, events ...outbox.Event) error {
 	q := "delete from users where id = ?"
 
-	if _, err := r.db.ExecContext(ctx, q, id); err != nil {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.DeleteUser %w", err)
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, q, id); err != nil {
 		return fmt.Errorf("UserRepo.DeleteUser failed deleting user %w", err)
 	}
 
+	if err = outbox.Insert(ctx, tx, events...); err != nil {
+		return fmt.Errorf("UserRepo.DeleteUser %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UserRepo.DeleteUser %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepo) UpdatePassword(ctx context.Context, id, password string) error {
+	q := "update users set password = ? where id = ?"
+
+	res, err := r.db.ExecContext(ctx, q, password, id)
+
+	if err != nil {
+		r.logger.Error("UserRepo.UpdatePassword", zap.Error(err))
+		return fmt.Errorf("UserRepo.UpdatePassword failed updating password %w", err)
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return key.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// SaveMFASecret stores a pending (not yet confirmed) enrollment, replacing any previous pending one
+func (r *UserRepo) SaveMFASecret(ctx context.Context, userID, secret string) error {
+	q := "insert into user_mfa (user_id, secret, enabled) values(?, ?, false) on duplicate key update secret = values(secret), enabled = false"
+
+	if _, err := r.db.ExecContext(ctx, q, userID, secret); err != nil {
+		r.logger.Error("UserRepo.SaveMFASecret", zap.Error(err))
+		return fmt.Errorf("UserRepo.SaveMFASecret failed saving secret %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepo) FindMFA(ctx context.Context, userID string) (*user.MFA, error) {
+	mfa := user.MFA{UserID: userID}
+	row := r.db.QueryRowContext(ctx, "select secret, enabled from user_mfa where user_id = ?", userID)
+
+	if err := row.Scan(&mfa.Secret, &mfa.Enabled); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, key.ErrMFANotEnabled
+		}
+
+		return nil, fmt.Errorf("UserRepo.FindMFA failed fetching mfa %w", err)
+	}
+
+	return &mfa, nil
+}
+
+// EnableMFA confirms the enrollment and replaces the user's recovery codes
+func (r *UserRepo) EnableMFA(ctx context.Context, userID string, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.EnableMFA %w", err)
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "update user_mfa set enabled = true where user_id = ?", userID); err != nil {
+		return fmt.Errorf("UserRepo.EnableMFA failed enabling %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, "delete from user_recovery_codes where user_id = ?", userID); err != nil {
+		return fmt.Errorf("UserRepo.EnableMFA failed deleting recovery codes %w", err)
+	}
+
+	if len(recoveryCodeHashes) > 0 {
+		placeholders := make([]string, len(recoveryCodeHashes))
+		args := make([]interface{}, 0, len(recoveryCodeHashes)*2)
+		for i, hash := range recoveryCodeHashes {
+			placeholders[i] = "(?, ?)"
+			args = append(args, userID, hash)
+		}
+
+		q := "insert into user_recovery_codes (user_id, code_hash) values " + strings.Join(placeholders, ", ")
+		if _, err = tx.ExecContext(ctx, q, args...); err != nil {
+			return fmt.Errorf("UserRepo.EnableMFA failed saving recovery codes %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UserRepo.EnableMFA %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepo) DisableMFA(ctx context.Context, userID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.DisableMFA %w", err)
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "delete from user_recovery_codes where user_id = ?", userID); err != nil {
+		return fmt.Errorf("UserRepo.DisableMFA failed deleting recovery codes %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, "delete from user_mfa where user_id = ?", userID); err != nil {
+		return fmt.Errorf("UserRepo.DisableMFA failed deleting mfa %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UserRepo.DisableMFA %w", err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode deletes the code so it can't be used again, key.ErrInvalidMFACode is returned for unknown codes
+func (r *UserRepo) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	res, err := r.db.ExecContext(ctx, "delete from user_recovery_codes where user_id = ? and code_hash = ?", userID, codeHash)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.UseRecoveryCode %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.UseRecoveryCode %w", err)
+	}
+
+	if affected == 0 {
+		return key.ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// UseTOTPStep records the period of an accepted TOTP code, key.ErrInvalidMFACode is returned when a code
+// of the same or a later period was accepted already
+func (r *UserRepo) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	q := "update user_mfa set last_step = ? where user_id = ? and (last_step is null or last_step < ?)"
+	res, err := r.db.ExecContext(ctx, q, step, userID, step)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.UseTOTPStep %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.UseTOTPStep %w", err)
+	}
+
+	if affected == 0 {
+		return key.ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func (r *UserRepo) CreateClient(ctx context.Context, client oauth.Client) error {
+	_, err := r.db.ExecContext(ctx, "insert into service_clients (id, name, secret_hash, scopes) values(?, ?, ?, ?)",
+		client.ID, client.Name, client.SecretHash, oauth.FormatScopes(client.Scopes))
+
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return key.ErrAlreadyExists
+		}
+
+		return fmt.Errorf("UserRepo.CreateClient %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepo) FindClient(ctx context.Context, id string) (*oauth.Client, error) {
+	client := oauth.Client{ID: id}
+	var scopes string
+	row := r.db.QueryRowContext(ctx, "select name, secret_hash, scopes from service_clients where id = ?", id)
+
+	if err := row.Scan(&client.Name, &client.SecretHash, &scopes); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, key.ErrInvalidClient
+		}
+
+		return nil, fmt.Errorf("UserRepo.FindClient %w", err)
+	}
+
+	client.Scopes = oauth.ParseScopes(scopes)
+
+	return &client, nil
+}
+
+func (r *UserRepo) DeleteClient(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx, "delete from service_clients where id = ?", id)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.DeleteClient %w", err)
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return key.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *UserRepo) CreateAPIKey(ctx context.Context, apiKey apikey.APIKey) error {
+	_, err := r.db.ExecContext(ctx, "insert into api_keys (id, user_id, organization_id, name, prefix, key_hash, scopes, expires_at) values(?, ?, ?, ?, ?, ?, ?, ?)",
+		apiKey.ID, apiKey.UserID, sql.NullString{String: apiKey.OrganizationID, Valid: apiKey.OrganizationID != ""}, apiKey.Name, apiKey.Prefix, apiKey.Hash, strings.Join(apiKey.Scopes, " "), apiKey.ExpiresAt)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateAPIKey %w", err)
+	}
+
+	return nil
+}
+
+const apiKeyColumns = "id, user_id, organization_id, name, prefix, key_hash, scopes, expires_at, last_used_at, created_at"
+
+func scanAPIKey(row interface{ Scan(dest ...any) error }) (*apikey.APIKey, error) {
+	var apiKey apikey.APIKey
+	var scopes string
+	var orgID sql.NullString
+	var expiresAt, lastUsedAt sql.NullTime
+
+	if err := row.Scan(&apiKey.ID, &apiKey.UserID, &orgID, &apiKey.Name, &apiKey.Prefix, &apiKey.Hash, &scopes, &expiresAt, &lastUsedAt, &apiKey.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	apiKey.OrganizationID = orgID.String
+	apiKey.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		apiKey.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		apiKey.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return &apiKey, nil
+}
+
+func (r *UserRepo) ListAPIKeys(ctx context.Context, userID string) ([]apikey.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, "select "+apiKeyColumns+" from api_keys where user_id = ? order by created_at desc", userID)
+
+	if err != nil {
+		return nil, fmt.Errorf("UserRepo.ListAPIKeys %w", err)
+	}
+	defer rows.Close()
+
+	var keys []apikey.APIKey
+	for rows.Next() {
+		apiKey, err := scanAPIKey(rows)
+
+		if err != nil {
+			return nil, fmt.Errorf("UserRepo.ListAPIKeys failed scanning key %w", err)
+		}
+
+		keys = append(keys, *apiKey)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("UserRepo.ListAPIKeys %w", err)
+	}
+
+	return keys, nil
+}
+
+func (r *UserRepo) FindAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, error) {
+	apiKey, err := scanAPIKey(r.db.QueryRowContext(ctx, "select "+apiKeyColumns+" from api_keys where key_hash = ?", hash))
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, key.ErrInvalidToken
+		}
+
+		return nil, fmt.Errorf("UserRepo.FindAPIKeyByHash %w", err)
+	}
+
+	return apiKey, nil
+}
+
+// DeleteAPIKey only deletes the key of the given user, other keys are reported as not found
+func (r *UserRepo) DeleteAPIKey(ctx context.Context, userID, id string) error {
+	res, err := r.db.ExecContext(ctx, "delete from api_keys where id = ? and user_id = ?", id, userID)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.DeleteAPIKey %w", err)
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return key.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *UserRepo) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+
+	if _, err := r.db.ExecContext(ctx, "update api_keys set last_used_at = ? where id = ?", usedAt, id); err != nil {
+		return fmt.Errorf("UserRepo.TouchAPIKey %w", err)
+	}
+
+	return nil
+}
+
+// FindIdentity returns the local user an external identity is linked to
+func (r *UserRepo) FindIdentity(ctx context.Context, provider, subject string) (string, error) {
+	var userID string
+	row := r.db.QueryRowContext(ctx, "select user_id from user_identities where provider = ? and subject = ?", provider, subject)
+
+	if err := row.Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", key.ErrNotFound
+		}
+
+		return "", fmt.Errorf("UserRepo.FindIdentity %w", err)
+	}
+
+	return userID, nil
+}
+
+func (r *UserRepo) CreateIdentity(ctx context.Context, userID string, identity federation.Identity) error {
+	email, err := r.fields.Encrypt(identity.Email)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateIdentity failed encrypting email %w", err)
+	}
+
+	_, err = r.db.ExecContext(ctx, "insert into user_identities (provider, subject, user_id, email) values (?, ?, ?, ?)",
+		identity.Provider, identity.Subject, userID, email)
+
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return key.ErrAlreadyExists
+		}
+
+		return fmt.Errorf("UserRepo.CreateIdentity %w", err)
+	}
+
+	return nil
+}
+
+// CreateUserWithIdentity creates a user who signed up through an identity provider, the user has no password
+func (r *UserRepo) CreateUserWithIdentity(ctx context.Context, user user.User, identity federation.Identity, events ...outbox.Event) error {
+	name, nameIndex, err := r.seal(user.Name)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateUserWithIdentity failed encrypting name %w", err)
+	}
+
+	email, emailIndex, err := r.seal(user.Email)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateUserWithIdentity failed encrypting email %w", err)
+	}
+
+	identityEmail, err := r.fields.Encrypt(identity.Email)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateUserWithIdentity failed encrypting email %w", err)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateUserWithIdentity %w", err)
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "insert into users (id, name, name_index, email, email_index) values (?, ?, ?, ?, ?)",
+		user.ID, name, nameIndex, email, emailIndex)
+
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return key.ErrAlreadyExists
+		}
+
+		return fmt.Errorf("UserRepo.CreateUserWithIdentity failed creating user %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "insert into user_identities (provider, subject, user_id, email) values (?, ?, ?, ?)",
+		identity.Provider, identity.Subject, user.ID, identityEmail)
+
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return key.ErrAlreadyExists
+		}
+
+		return fmt.Errorf("UserRepo.CreateUserWithIdentity failed linking identity %w", err)
+	}
+
+	if err = outbox.Insert(ctx, tx, events...); err != nil {
+		return fmt.Errorf("UserRepo.CreateUserWithIdentity %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UserRepo.CreateUserWithIdentity %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepo) SaveFederationState(ctx context.Context, state string, values federation.State, ttl time.Duration) error {
+	k := fmt.Sprintf(federationState, state)
+
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, k, map[string]interface{}{
+			"provider": values.Provider,
+			"nonce":    values.Nonce,
+			"verifier": values.Verifier,
+		})
+		pipe.Expire(ctx, k, ttl)
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.SaveFederationState %w", err)
+	}
+
+	return nil
+}
+
+// ConsumeFederationState returns the state of a pending login and deletes it, so a callback can't be replayed
+func (r *UserRepo) ConsumeFederationState(ctx context.Context, state string) (*federation.State, error) {
+	k := fmt.Sprintf(federationState, state)
+
+	var getCmd *redis.MapStringStringCmd
+	_, err := r.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		getCmd = pipe.HGetAll(ctx, k)
+		pipe.Del(ctx, k)
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("UserRepo.ConsumeFederationState %w", err)
+	}
+
+	values := getCmd.Val()
+
+	if values["provider"] == "" {
+		return nil, key.ErrInvalidToken
+	}
+
+	return &federation.State{Provider: values["provider"], Nonce: values["nonce"], Verifier: values["verifier"]}, nil
+}
+
+// CreateOrganization creates the organization with its first owner
+func (r *UserRepo) CreateOrganization(ctx context.Context, org organization.Organization, owner organization.Member) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateOrganization %w", err)
+	}
+
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "insert into organizations (id, name) values (?, ?)", org.ID, org.Name); err != nil {
+		return fmt.Errorf("UserRepo.CreateOrganization failed creating organization %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "insert into organization_members (organization_id, user_id, role) values (?, ?, ?)",
+		org.ID, owner.UserID, owner.Role)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateOrganization failed adding owner %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UserRepo.CreateOrganization %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepo) ListOrganizations(ctx context.Context, userID string) ([]organization.Membership, error) {
+	rows, err := r.db.QueryContext(ctx, "select o.id, o.name, o.created_at, m.role from organizations o "+
+		"join organization_members m on m.organization_id = o.id where m.user_id = ? order by o.name", userID)
+
+	if err != nil {
+		return nil, fmt.Errorf("UserRepo.ListOrganizations %w", err)
+	}
+	defer rows.Close()
+
+	var memberships []organization.Membership
+	for rows.Next() {
+		var membership organization.Membership
+
+		if err = rows.Scan(&membership.ID, &membership.Name, &membership.CreatedAt, &membership.Role); err != nil {
+			return nil, fmt.Errorf("UserRepo.ListOrganizations failed scanning organization %w", err)
+		}
+
+		memberships = append(memberships, membership)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("UserRepo.ListOrganizations %w", err)
+	}
+
+	return memberships, nil
+}
+
+const memberQuery = "select m.organization_id, m.user_id, u.email, m.role, m.created_at from organization_members m " +
+	"join users u on u.id = m.user_id where m.organization_id = ?"
+
+// FindMember reports users outside the organization as not found
+func (r *UserRepo) FindMember(ctx context.Context, orgID, userID string) (*organization.Member, error) {
+	var member organization.Member
+	row := r.db.QueryRowContext(ctx, memberQuery+" and m.user_id = ?", orgID, userID)
+
+	if err := row.Scan(&member.OrganizationID, &member.UserID, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, key.ErrNotFound
+		}
+
+		return nil, fmt.Errorf("UserRepo.FindMember %w", err)
+	}
+
+	var err error
+	if member.Email, err = r.fields.Decrypt(ctx, member.Email); err != nil {
+		return nil, fmt.Errorf("UserRepo.FindMember failed decrypting email %w", err)
+	}
+
+	return &member, nil
+}
+
+func (r *UserRepo) ListMembers(ctx context.Context, orgID string) ([]organization.Member, error) {
+	rows, err := r.db.QueryContext(ctx, memberQuery+" order by m.created_at", orgID)
+
+	if err != nil {
+		return nil, fmt.Errorf("UserRepo.ListMembers %w", err)
+	}
+	defer rows.Close()
+
+	var members []organization.Member
+	for rows.Next() {
+		var member organization.Member
+
+		if err = rows.Scan(&member.OrganizationID, &member.UserID, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+			return nil, fmt.Errorf("UserRepo.ListMembers failed scanning member %w", err)
+		}
+
+		if member.Email, err = r.fields.Decrypt(ctx, member.Email); err != nil {
+			return nil, fmt.Errorf("UserRepo.ListMembers failed decrypting email %w", err)
+		}
+
+		members = append(members, member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("UserRepo.ListMembers %w", err)
+	}
+
+	return members, nil
+}
+
+func (r *UserRepo) CountOwners(ctx context.Context, orgID string) (int, error) {
+	var owners int
+	row := r.db.QueryRowContext(ctx, "select count(*) from organization_members where organization_id = ? and role = ?", orgID, organization.RoleOwner)
+
+	if err := row.Scan(&owners); err != nil {
+		return 0, fmt.Errorf("UserRepo.CountOwners %w", err)
+	}
+
+	return owners, nil
+}
+
+func (r *UserRepo) UpdateMemberRole(ctx context.Context, orgID, userID, role string) error {
+	res, err := r.db.ExecContext(ctx, "update organization_members set role = ? where organization_id = ? and user_id = ?", role, orgID, userID)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.UpdateMemberRole %w", err)
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return key.ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteMember also deletes the member's API keys of the organization
+func (r *UserRepo) DeleteMember(ctx context.Context, orgID, userID string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.DeleteMember %w", err)
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "delete from organization_members where organization_id = ? and user_id = ?", orgID, userID)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.DeleteMember %w", err)
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return key.ErrNotFound
+	}
+
+	if _, err = tx.ExecContext(ctx, "delete from api_keys where organization_id = ? and user_id = ?", orgID, userID); err != nil {
+		return fmt.Errorf("UserRepo.DeleteMember failed deleting api keys %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UserRepo.DeleteMember %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepo) CreateInvitation(ctx context.Context, invitation organization.Invitation) error {
+	email, emailIndex, err := r.seal(invitation.Email)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateInvitation %w", err)
+	}
+
+	_, err = r.db.ExecContext(ctx, "insert into organization_invitations (id, organization_id, email, email_index, role, token_hash, invited_by, expires_at) values (?, ?, ?, ?, ?, ?, ?, ?)",
+		invitation.ID, invitation.OrganizationID, email, emailIndex, invitation.Role, invitation.TokenHash, invitation.InvitedBy, invitation.ExpiresAt)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.CreateInvitation %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepo) FindInvitation(ctx context.Context, tokenHash string) (*organization.Invitation, error) {
+	var invitation organization.Invitation
+	var acceptedAt sql.NullTime
+	row := r.db.QueryRowContext(ctx, "select id, organization_id, email, role, token_hash, invited_by, expires_at, accepted_at, created_at "+
+		"from organization_invitations where token_hash = ?", tokenHash)
+
+	err := row.Scan(&invitation.ID, &invitation.OrganizationID, &invitation.Email, &invitation.Role, &invitation.TokenHash,
+		&invitation.InvitedBy, &invitation.ExpiresAt, &acceptedAt, &invitation.CreatedAt)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, key.ErrInvalidToken
+		}
+
+		return nil, fmt.Errorf("UserRepo.FindInvitation %w", err)
+	}
+
+	if invitation.Email, err = r.fields.Decrypt(ctx, invitation.Email); err != nil {
+		return nil, fmt.Errorf("UserRepo.FindInvitation %w", err)
+	}
+
+	if acceptedAt.Valid {
+		invitation.AcceptedAt = &acceptedAt.Time
+	}
+
+	return &invitation, nil
+}
+
+// AcceptInvitation adds the member and uses up the invitation, an invitation accepted concurrently
+// or sent to another email than the member's is reported as invalid
+func (r *UserRepo) AcceptInvitation(ctx context.Context, invitationID string, member organization.Member) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.AcceptInvitation %w", err)
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "update organization_invitations set accepted_at = ? where id = ? and email_index = ? and accepted_at is null",
+		time.Now().UTC(), invitationID, r.fields.BlindIndex(member.Email))
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.AcceptInvitation %w", err)
+	}
+
+	if affected, err := res.RowsAffected(); err == nil && affected == 0 {
+		return key.ErrInvalidToken
+	}
+
+	_, err = tx.ExecContext(ctx, "insert into organization_members (organization_id, user_id, role) values (?, ?, ?)",
+		member.OrganizationID, member.UserID, member.Role)
+
+	if err != nil {
+		if isDuplicateEntry(err) {
+			return key.ErrAlreadyExists
+		}
+
+		return fmt.Errorf("UserRepo.AcceptInvitation failed adding member %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("UserRepo.AcceptInvitation %w", err)
+	}
+
+	return nil
+}
+
+func nullString(value string) sql.NullString {
+
+	return sql.NullString{String: value, Valid: value != ""}
+}
+
+func (r *UserRepo) AppendAuditEvent(ctx context.Context, event audit.Event) error {
+	var details []byte
+
+	if len(event.Details) > 0 {
+		var err error
+		if details, err = json.Marshal(event.Details); err != nil {
+			return fmt.Errorf("UserRepo.AppendAuditEvent failed encoding details %w", err)
+		}
+	}
+
+	_, err := r.db.ExecContext(ctx, "insert into audit_events (type, outcome, actor_id, subject_id, identifier, ip, user_agent, reason, details, created_at) "+
+		"values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		event.Type, event.Outcome, nullString(event.ActorID), nullString(event.SubjectID), nullString(event.Identifier),
+		nullString(event.IP), nullString(event.UserAgent), nullString(event.Reason), details, event.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.AppendAuditEvent %w", err)
+	}
+
+	return nil
+}
+
+const auditColumns = "id, type, outcome, actor_id, subject_id, identifier, ip, user_agent, reason, details, created_at"
+
+// prepareAuditQuery builds the query of the filter, newest events first. The page size is only applied when limited
+func prepareAuditQuery(filter audit.Filter, limited bool) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	for _, field := range []struct {
+		column string
+		value  string
+	}{
+		{"type", filter.Type},
+		{"outcome", filter.Outcome},
+		{"actor_id", filter.ActorID},
+		{"subject_id", filter.SubjectID},
+		{"identifier", filter.Identifier},
+		{"ip", filter.IP},
+	} {
+		if field.value != "" {
+			conditions = append(conditions, field.column+" = ?")
+			args = append(args, field.value)
+		}
+	}
+
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.From)
+	}
+
+	if filter.To != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, *filter.To)
+	}
+
+	if filter.Before > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.Before)
+	}
+
+	q := "select " + auditColumns + " from audit_events"
+	if len(conditions) > 0 {
+		q += " where " + strings.Join(conditions, " and ")
+	}
+	q += " order by id desc"
+
+	if limited {
+		q += " limit ?"
+		args = append(args, filter.WithLimit().Limit)
+	}
+
+	return q, args
+}
+
+func scanAuditEvent(row interface{ Scan(...any) error }) (*audit.Event, error) {
+	var event audit.Event
+	var actorID, subjectID, identifier, ip, userAgent, reason sql.NullString
+	var details []byte
+
+	err := row.Scan(&event.ID, &event.Type, &event.Outcome, &actorID, &subjectID, &identifier, &ip, &userAgent, &reason, &details, &event.CreatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	event.ActorID, event.SubjectID, event.Identifier = actorID.String, subjectID.String, identifier.String
+	event.IP, event.UserAgent, event.Reason = ip.String, userAgent.String, reason.String
+
+	if len(details) > 0 {
+		if err = json.Unmarshal(details, &event.Details); err != nil {
+			return nil, fmt.Errorf("failed decoding details %w", err)
+		}
+	}
+
+	return &event, nil
+}
+
+func (r *UserRepo) ListAuditEvents(ctx context.Context, filter audit.Filter) ([]audit.Event, error) {
+	events := []audit.Event{}
+
+	err := r.ExportAuditEvents(ctx, filter.WithLimit(), func(event audit.Event) error {
+		events = append(events, event)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("UserRepo.ListAuditEvents %w", err)
+	}
+
+	return events, nil
+}
+
+// ExportAuditEvents hands every event of the filter to fn while reading them, a filter limit caps the export
+func (r *UserRepo) ExportAuditEvents(ctx context.Context, filter audit.Filter, fn func(audit.Event) error) error {
+	q, args := prepareAuditQuery(filter, filter.Limit > 0)
+	rows, err := r.db.QueryContext(ctx, q, args...)
+
+	if err != nil {
+		return fmt.Errorf("UserRepo.ExportAuditEvents %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+
+		if err != nil {
+			return fmt.Errorf("UserRepo.ExportAuditEvents failed scanning event %w", err)
+		}
+
+		if err = fn(*event); err != nil {
+			return err
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("UserRepo.ExportAuditEvents %w", err)
+	}
+
 	return nil
 }
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ireuven89/auctions/auth-service/audit"
+	"github.com/ireuven89/auctions/auth-service/user"
+	"github.com/stretchr/testify/assert"
+)
+
+type UpdateUserQueryTest struct {
+	name          string
+	request       user.User
+	expectedQuery string
+	expectedArgs  []interface{}
+	expectedErr   bool
+}
+
+func TestPrepareUpdateUserQuery(t *testing.T) {
+	nameIndex, emailIndex := nullString("name-index"), nullString("email-index")
+	tests := []UpdateUserQueryTest{
+		{
+			name:          "name only",
+			request:       user.User{ID: "id", Name: "name"},
+			expectedQuery: "update users set name = ?, name_index = ? where id = ?",
+			expectedArgs:  []interface{}{"name", nameIndex, "id"},
+		},
+		{
+			name:          "all fields",
+			request:       user.User{ID: "id", Name: "name", Email: "foo@bar.com", Role: user.RoleAdmin},
+			expectedQuery: "update users set name = ?, name_index = ?, email = ?, email_index = ?, role = ? where id = ?",
+			expectedArgs:  []interface{}{"name", nameIndex, "foo@bar.com", emailIndex, user.RoleAdmin, "id"},
+		},
+		{
+			name:        "password is never updated",
+			request:     user.User{ID: "id", Password: "secret"},
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		q, args, err := prepareUpdateUserQuery(test.request, nameIndex, emailIndex)
+		assert.Equal(t, test.expectedErr, err != nil, test.name)
+		assert.Equal(t, test.expectedQuery, q, test.name)
+		assert.Equal(t, test.expectedArgs, args, test.name)
+	}
+}
+
+func TestPrepareAuditQuery(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	q, args := prepareAuditQuery(audit.Filter{}, true)
+	assert.Equal(t, "select "+auditColumns+" from audit_events order by id desc limit ?", q)
+	assert.Equal(t, []interface{}{audit.DefaultLimit}, args)
+
+	q, args = prepareAuditQuery(audit.Filter{Type: audit.TypeLogin, Outcome: audit.OutcomeFailure, IP: "10.0.0.1", From: &from, Before: 42, Limit: 5000}, true)
+	assert.Equal(t, "select "+auditColumns+" from audit_events where type = ? and outcome = ? and ip = ? and created_at >= ? and id < ? order by id desc limit ?", q)
+	assert.Equal(t, []interface{}{audit.TypeLogin, audit.OutcomeFailure, "10.0.0.1", from, int64(42), audit.MaxLimit}, args)
+
+	q, args = prepareAuditQuery(audit.Filter{SubjectID: "user"}, false)
+	assert.Equal(t, "select "+auditColumns+" from audit_events where subject_id = ? order by id desc", q)
+	assert.Equal(t, []interface{}{"user"}, args)
+}
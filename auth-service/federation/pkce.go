@@ -0,0 +1,32 @@
+package federation
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// State is kept server side between the redirect to the provider and the callback
+type State struct {
+	Provider string
+	Nonce    string
+	Verifier string
+}
+
+// RandomString returns 32 random bytes in the unpadded base64url form PKCE verifiers, states and nonces use
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge is the S256 code challenge of RFC 7636 for the verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
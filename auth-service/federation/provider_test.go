@@ -0,0 +1,83 @@
+package federation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ireuven89/auctions/auth-service/federation/oidctest"
+	"github.com/ireuven89/auctions/shared/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Server) {
+	t.Helper()
+	server, err := oidctest.NewServer("auctions", "secret")
+	assert.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	provider := NewProvider("test", config.OIDCProviderConfig{
+		Issuer:       server.URL,
+		ClientID:     "auctions",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8099/auth/oidc/test/callback",
+	})
+
+	return provider, server
+}
+
+func TestProvider_CodeFlow(t *testing.T) {
+	provider, server := newTestProvider(t)
+	server.SignIn(oidctest.User{Subject: "42", Email: "jane@example.com", EmailVerified: true, Name: "Jane"})
+	verifier, _ := RandomString()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", Challenge(verifier))
+	assert.NoError(t, err)
+
+	callback, err := server.Authorize(authURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "state", callback.Query().Get("state"))
+
+	identity, err := provider.Exchange(context.Background(), callback.Query().Get("code"), verifier, "nonce")
+
+	assert.NoError(t, err)
+	assert.Equal(t, &Identity{Provider: "test", Subject: "42", Email: "jane@example.com", EmailVerified: true, Name: "Jane"}, identity)
+}
+
+func TestProvider_WrongVerifier(t *testing.T) {
+	provider, server := newTestProvider(t)
+	verifier, _ := RandomString()
+	other, _ := RandomString()
+
+	authURL, _ := provider.AuthCodeURL(context.Background(), "state", "nonce", Challenge(verifier))
+	callback, err := server.Authorize(authURL)
+	assert.NoError(t, err)
+
+	_, err = provider.Exchange(context.Background(), callback.Query().Get("code"), other, "nonce")
+
+	assert.ErrorIs(t, err, ErrInvalidIDToken)
+}
+
+func TestProvider_NonceMismatch(t *testing.T) {
+	provider, server := newTestProvider(t)
+	server.Nonce = "replayed"
+	verifier, _ := RandomString()
+
+	authURL, _ := provider.AuthCodeURL(context.Background(), "state", "nonce", Challenge(verifier))
+	callback, err := server.Authorize(authURL)
+	assert.NoError(t, err)
+
+	_, err = provider.Exchange(context.Background(), callback.Query().Get("code"), verifier, "nonce")
+
+	assert.ErrorIs(t, err, ErrInvalidIDToken)
+}
+
+func TestProviders_Get(t *testing.T) {
+	providers := NewProviders(config.OIDCConfig{Providers: map[string]config.OIDCProviderConfig{"google": {Issuer: "https://accounts.google.com"}}})
+
+	provider, err := providers.Get("google")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultScopes, provider.scopes)
+
+	_, err = providers.Get("github")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+}
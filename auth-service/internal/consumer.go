@@ -0,0 +1,40 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ireuven89/auctions/shared/events"
+	sharedhttp "github.com/ireuven89/auctions/shared/http"
+	"github.com/ireuven89/auctions/shared/messaging"
+)
+
+// ImpersonationEventsSubscription is the queue the requests admins made as other users to the other services
+// reach auth-service on
+var ImpersonationEventsSubscription = messaging.Subscription{
+	Queue:  "auth-service.impersonations",
+	Topics: []string{events.TopicImpersonatedRequest},
+}
+
+// NewImpersonationEventsHandler keeps the impersonated requests of the other services in the audit log
+func NewImpersonationEventsHandler(s Service) messaging.Handler {
+	return func(ctx context.Context, msg messaging.Message) error {
+		request, err := messaging.Payload[events.ImpersonatedRequest](msg.Envelope)
+
+		if err != nil || request.ActorID == "" || request.SubjectID == "" {
+			return messaging.Permanent(fmt.Errorf("malformed %s %s %v", msg.Type, msg.ID, err))
+		}
+
+		s.RecordImpersonation(ctx, sharedhttp.ImpersonationEntry{
+			Service: request.Service,
+			Actor:   request.ActorID,
+			Subject: request.SubjectID,
+			Method:  request.Method,
+			Path:    request.Path,
+			Status:  request.Status,
+			At:      request.At,
+		})
+
+		return nil
+	}
+}
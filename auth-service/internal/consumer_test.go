@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ireuven89/auctions/auth-service/internal/mocks"
+	"github.com/ireuven89/auctions/shared/events"
+	sharedhttp "github.com/ireuven89/auctions/shared/http"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImpersonationEventsHandler(t *testing.T) {
+	var recorded []sharedhttp.ImpersonationEntry
+	svc := &mocks.MockService{
+		RecordImpersonationFunc: func(ctx context.Context, entry sharedhttp.ImpersonationEntry) {
+			recorded = append(recorded, entry)
+		},
+	}
+	handler := NewImpersonationEventsHandler(svc)
+
+	envelope, err := messaging.NewEnvelope(context.Background(), events.TypeImpersonatedRequest, 1, events.ImpersonatedRequest{
+		Service: "auction-service", ActorID: "admin-id", SubjectID: "user-id", Method: "DELETE", Path: "/auctions/auction-id", Status: 204, At: time.Now()})
+	assert.NoError(t, err)
+
+	assert.NoError(t, handler(context.Background(), messaging.Message{Envelope: envelope}))
+	assert.Len(t, recorded, 1)
+	assert.Equal(t, "auction-service", recorded[0].Service)
+	assert.Equal(t, "admin-id", recorded[0].Actor)
+	assert.Equal(t, "/auctions/auction-id", recorded[0].Path)
+
+	// malformed events are not retried
+	envelope, _ = messaging.NewEnvelope(context.Background(), events.TypeImpersonatedRequest, 1, events.ImpersonatedRequest{})
+	assert.True(t, messaging.IsPermanent(handler(context.Background(), messaging.Message{Envelope: envelope})))
+}
@@ -4,15 +4,115 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
+	sharedhttp "github.com/ireuven89/auctions/shared/http"
 	"github.com/ireuven89/auctions/shared/jwksprovider"
 
+	"github.com/ireuven89/auctions/auth-service/apikey"
+	"github.com/ireuven89/auctions/auth-service/audit"
 	"github.com/ireuven89/auctions/auth-service/user"
 
 	"github.com/go-kit/kit/endpoint"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/ireuven89/auctions/auth-service/key"
+	"github.com/ireuven89/auctions/auth-service/oauth"
+	"github.com/ireuven89/auctions/auth-service/oidc"
+	"github.com/ireuven89/auctions/auth-service/organization"
 )
 
+type contextKey string
+
+const userIDContextKey contextKey = "user_id"
+const claimsContextKey contextKey = "claims"
+
+// MakeAuthenticationMiddleware validates the bearer token the transport put in the context and
+// passes the authenticated user id to the wrapped endpoint
+func MakeAuthenticationMiddleware(s Service) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			token, _ := ctx.Value(bearerTokenContextKey).(string)
+
+			if token == "" {
+				return nil, key.ErrInvalidToken
+			}
+
+			claims, err := s.VerifyAccessToken(ctx, token)
+
+			if err != nil {
+				return nil, err
+			}
+
+			userID, _ := claims["sub"].(string)
+
+			if userID == "" {
+				return nil, key.ErrInvalidToken
+			}
+
+			ctx = context.WithValue(ctx, claimsContextKey, claims)
+			ctx = context.WithValue(ctx, userIDContextKey, userID)
+
+			actor, impersonated := sharedhttp.Actor(claims)
+
+			if !impersonated {
+				return next(ctx, request)
+			}
+
+			// every request made while impersonating is audited, refused ones included
+			response, err = next(ctx, request)
+
+			entry := sharedhttp.ImpersonationEntry{Actor: actor, Subject: userID, At: time.Now().UTC()}
+			entry.Method, _ = ctx.Value(requestMethodContextKey).(string)
+			entry.Path, _ = ctx.Value(requestPathContextKey).(string)
+
+			if err != nil {
+				entry.Error = err.Error()
+			}
+
+			s.RecordImpersonation(ctx, entry)
+
+			return response, err
+		}
+	}
+}
+
+// MakeDenyImpersonationMiddleware keeps impersonating admins away from destructive actions,
+// it must be wrapped by MakeAuthenticationMiddleware
+func MakeDenyImpersonationMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			claims, _ := ctx.Value(claimsContextKey).(jwt.MapClaims)
+
+			if _, impersonated := sharedhttp.Actor(claims); impersonated {
+				return nil, fmt.Errorf("not allowed while impersonating %w", key.ErrForbidden)
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
+
+// MakeAdminMiddleware must be wrapped by MakeAuthenticationMiddleware
+func MakeAdminMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			claims, _ := ctx.Value(claimsContextKey).(jwt.MapClaims)
+
+			if claims == nil || claims["role"] != user.RoleAdmin {
+				return nil, key.ErrForbidden
+			}
+
+			return next(ctx, request)
+		}
+	}
+}
+
+func userIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDContextKey).(string)
+
+	return userID
+}
+
 type GetJWKSesponse struct {
 	jwksprovider.JWKS
 }
@@ -70,6 +170,8 @@ type LoginRequestModel struct {
 type LoginResponseModel struct {
 	AccessToken  string
 	RefreshToken string
+	// MFAToken is returned instead of the tokens when the user has two-factor enabled
+	MFAToken string
 }
 
 func MakeEndpointLogin(s Service) endpoint.Endpoint {
@@ -94,24 +196,28 @@ func MakeEndpointLogin(s Service) endpoint.Endpoint {
 		return LoginResponseModel{
 			AccessToken:  token.Access,
 			RefreshToken: token.Refresh,
+			MFAToken:     token.MFAPending,
 		}, nil
 	}
 }
 
+type LogoutRequestModel struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
 func MakeEndpointLogout(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
-		req, ok := request.(LoginRequestModel)
+		req, ok := request.(LogoutRequestModel)
 
 		if !ok {
 			return nil, fmt.Errorf("MakeEndpointLogout failed casting request")
 		}
-		token, err := s.Login(ctx, req.Identifier, req.Password)
 
-		if err != nil {
-			return nil, fmt.Errorf("MakeEndpointLogout.failed  %w", err)
+		if err = s.Logout(ctx, req.RefreshToken); err != nil {
+			return nil, fmt.Errorf("MakeEndpointLogout %w", err)
 		}
 
-		return token, nil
+		return nil, nil
 	}
 }
 
@@ -141,3 +247,1014 @@ func MakeEndpointRefreshToken(s Service) endpoint.Endpoint {
 		}, nil
 	}
 }
+
+type ForgotPasswordRequestModel struct {
+	Identifier string
+}
+
+func MakeEndpointForgotPassword(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(ForgotPasswordRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointForgotPassword failed casting request")
+		}
+
+		if err = s.ForgotPassword(ctx, req.Identifier); err != nil {
+			return nil, fmt.Errorf("MakeEndpointForgotPassword %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type ResetPasswordRequestModel struct {
+	Token    string
+	Password string
+}
+
+func MakeEndpointResetPassword(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(ResetPasswordRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointResetPassword failed casting request")
+		}
+
+		if err = s.ResetPassword(ctx, req.Token, req.Password); err != nil {
+			return nil, fmt.Errorf("MakeEndpointResetPassword %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type EnrollMFAResponseModel struct {
+	Secret string
+	URI    string
+}
+
+func MakeEndpointEnrollMFA(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		secret, uri, err := s.EnrollMFA(ctx, userIDFromContext(ctx))
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointEnrollMFA %w", err)
+		}
+
+		return EnrollMFAResponseModel{
+			Secret: secret,
+			URI:    uri,
+		}, nil
+	}
+}
+
+type MFACodeRequestModel struct {
+	Code string
+}
+
+type ConfirmMFAResponseModel struct {
+	RecoveryCodes []string
+}
+
+func MakeEndpointConfirmMFA(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(MFACodeRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointConfirmMFA failed casting request")
+		}
+
+		codes, err := s.ConfirmMFA(ctx, userIDFromContext(ctx), req.Code)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointConfirmMFA %w", err)
+		}
+
+		return ConfirmMFAResponseModel{RecoveryCodes: codes}, nil
+	}
+}
+
+func MakeEndpointDisableMFA(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(MFACodeRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointDisableMFA failed casting request")
+		}
+
+		if err = s.DisableMFA(ctx, userIDFromContext(ctx), req.Code); err != nil {
+			return nil, fmt.Errorf("MakeEndpointDisableMFA %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type VerifyMFARequestModel struct {
+	MFAToken string
+	Code     string
+}
+
+func MakeEndpointVerifyMFA(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(VerifyMFARequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointVerifyMFA failed casting request")
+		}
+
+		token, err := s.VerifyMFA(ctx, req.MFAToken, req.Code)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointVerifyMFA %w", err)
+		}
+
+		return LoginResponseModel{
+			AccessToken:  token.Access,
+			RefreshToken: token.Refresh,
+		}, nil
+	}
+}
+
+type UserResponseModel struct {
+	User user.UserResponse
+}
+
+func MakeEndpointGetMe(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		u, err := s.GetUser(ctx, userIDFromContext(ctx))
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointGetMe %w", err)
+		}
+
+		return UserResponseModel{User: user.ToResponse(*u)}, nil
+	}
+}
+
+type UpdateProfileRequestModel struct {
+	Name string
+}
+
+func MakeEndpointUpdateMe(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(UpdateProfileRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointUpdateMe failed casting request")
+		}
+
+		if err = s.UpdateProfile(ctx, userIDFromContext(ctx), req.Name); err != nil {
+			return nil, fmt.Errorf("MakeEndpointUpdateMe %w", err)
+		}
+
+		u, err := s.GetUser(ctx, userIDFromContext(ctx))
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointUpdateMe %w", err)
+		}
+
+		return UserResponseModel{User: user.ToResponse(*u)}, nil
+	}
+}
+
+type ChangePasswordRequestModel struct {
+	CurrentPassword string
+	NewPassword     string
+}
+
+func MakeEndpointChangePassword(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(ChangePasswordRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointChangePassword failed casting request")
+		}
+
+		if err = s.ChangePassword(ctx, userIDFromContext(ctx), req.CurrentPassword, req.NewPassword); err != nil {
+			return nil, fmt.Errorf("MakeEndpointChangePassword %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type ChangeEmailRequestModel struct {
+	Email    string
+	Password string
+}
+
+func MakeEndpointChangeEmail(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(ChangeEmailRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointChangeEmail failed casting request")
+		}
+
+		if err = s.RequestEmailChange(ctx, userIDFromContext(ctx), req.Email, req.Password); err != nil {
+			return nil, fmt.Errorf("MakeEndpointChangeEmail %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type VerifyEmailRequestModel struct {
+	Token string
+}
+
+func MakeEndpointVerifyEmail(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(VerifyEmailRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointVerifyEmail failed casting request")
+		}
+
+		if err = s.ConfirmEmailChange(ctx, req.Token); err != nil {
+			return nil, fmt.Errorf("MakeEndpointVerifyEmail %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type DeleteMeRequestModel struct {
+	Password string
+}
+
+func MakeEndpointDeleteMe(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(DeleteMeRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointDeleteMe failed casting request")
+		}
+
+		if err = s.DeleteAccount(ctx, userIDFromContext(ctx), req.Password); err != nil {
+			return nil, fmt.Errorf("MakeEndpointDeleteMe %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type UserIDRequestModel struct {
+	id string
+}
+
+func MakeEndpointGetUser(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(UserIDRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointGetUser failed casting request")
+		}
+
+		u, err := s.GetUser(ctx, req.id)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointGetUser %w", err)
+		}
+
+		return UserResponseModel{User: user.ToResponse(*u)}, nil
+	}
+}
+
+type UpdateUserRequestModel struct {
+	id    string
+	Name  string
+	Email string
+	Role  string
+}
+
+func MakeEndpointUpdateUser(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(UpdateUserRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointUpdateUser failed casting request")
+		}
+
+		if err = s.UpdateUser(ctx, user.User{ID: req.id, Name: req.Name, Email: req.Email, Role: req.Role}); err != nil {
+			return nil, fmt.Errorf("MakeEndpointUpdateUser %w", err)
+		}
+
+		u, err := s.GetUser(ctx, req.id)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointUpdateUser %w", err)
+		}
+
+		return UserResponseModel{User: user.ToResponse(*u)}, nil
+	}
+}
+
+func MakeEndpointDeleteUser(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(UserIDRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointDeleteUser failed casting request")
+		}
+
+		if err = s.DeleteUser(ctx, req.id); err != nil {
+			return nil, fmt.Errorf("MakeEndpointDeleteUser %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type ListSessionsResponseModel struct {
+	Sessions []user.SessionResponse
+}
+
+func MakeEndpointListSessions(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		sessions, err := s.ListSessions(ctx, userIDFromContext(ctx))
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointListSessions %w", err)
+		}
+
+		res := ListSessionsResponseModel{Sessions: make([]user.SessionResponse, 0, len(sessions))}
+		for _, session := range sessions {
+			res.Sessions = append(res.Sessions, user.ToSessionResponse(session))
+		}
+
+		return res, nil
+	}
+}
+
+type SessionIDRequestModel struct {
+	id string
+}
+
+func MakeEndpointRevokeSession(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(SessionIDRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointRevokeSession failed casting request")
+		}
+
+		if err = s.RevokeSession(ctx, userIDFromContext(ctx), req.id); err != nil {
+			return nil, fmt.Errorf("MakeEndpointRevokeSession %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type ClientTokenRequestModel struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+type ClientTokenResponseModel struct {
+	AccessToken string
+	Scopes      []string
+}
+
+func MakeEndpointClientToken(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(ClientTokenRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointClientToken failed casting request")
+		}
+
+		if req.GrantType != oauth.GrantClientCredentials {
+			return nil, key.ErrUnsupportedGrantType
+		}
+
+		if req.ClientID == "" || req.ClientSecret == "" {
+			return nil, key.ErrInvalidClient
+		}
+
+		token, scopes, err := s.IssueClientToken(ctx, req.ClientID, req.ClientSecret, req.Scopes)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointClientToken %w", err)
+		}
+
+		return ClientTokenResponseModel{AccessToken: token, Scopes: scopes}, nil
+	}
+}
+
+type CreateClientRequestModel struct {
+	ClientID string   `json:"clientId"`
+	Name     string   `json:"name"`
+	Scopes   []string `json:"scopes"`
+}
+
+type CreateClientResponseModel struct {
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+func MakeEndpointCreateClient(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(CreateClientRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointCreateClient failed casting request")
+		}
+
+		secret, err := s.CreateClient(ctx, oauth.Client{ID: req.ClientID, Name: req.Name, Scopes: req.Scopes})
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointCreateClient %w", err)
+		}
+
+		return CreateClientResponseModel{ClientID: req.ClientID, ClientSecret: secret, Scopes: req.Scopes}, nil
+	}
+}
+
+type ClientIDRequestModel struct {
+	id string
+}
+
+func MakeEndpointDeleteClient(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(ClientIDRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointDeleteClient failed casting request")
+		}
+
+		if err = s.DeleteClient(ctx, req.id); err != nil {
+			return nil, fmt.Errorf("MakeEndpointDeleteClient %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type CreateAPIKeyRequestModel struct {
+	// OrganizationID makes the key act for one of the user's organizations
+	OrganizationID string     `json:"organizationId"`
+	Name           string     `json:"name"`
+	Scopes         []string   `json:"scopes"`
+	ExpiresAt      *time.Time `json:"expiresAt"`
+}
+
+type CreateAPIKeyResponseModel struct {
+	Key    string
+	APIKey apikey.APIKeyResponse
+}
+
+func MakeEndpointCreateAPIKey(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(CreateAPIKeyRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointCreateAPIKey failed casting request")
+		}
+
+		plain, created, err := s.CreateAPIKey(ctx, userIDFromContext(ctx), req.OrganizationID, req.Name, req.Scopes, req.ExpiresAt)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointCreateAPIKey %w", err)
+		}
+
+		return CreateAPIKeyResponseModel{Key: plain, APIKey: apikey.ToResponse(*created)}, nil
+	}
+}
+
+type ListAPIKeysResponseModel struct {
+	APIKeys []apikey.APIKeyResponse
+}
+
+func MakeEndpointListAPIKeys(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		keys, err := s.ListAPIKeys(ctx, userIDFromContext(ctx))
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointListAPIKeys %w", err)
+		}
+
+		res := ListAPIKeysResponseModel{APIKeys: make([]apikey.APIKeyResponse, 0, len(keys))}
+		for _, k := range keys {
+			res.APIKeys = append(res.APIKeys, apikey.ToResponse(k))
+		}
+
+		return res, nil
+	}
+}
+
+type APIKeyIDRequestModel struct {
+	id string
+}
+
+func MakeEndpointRevokeAPIKey(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(APIKeyIDRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointRevokeAPIKey failed casting request")
+		}
+
+		if err = s.RevokeAPIKey(ctx, userIDFromContext(ctx), req.id); err != nil {
+			return nil, fmt.Errorf("MakeEndpointRevokeAPIKey %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type ResolveAPIKeyRequestModel struct {
+	Key          string `json:"key"`
+	ClientID     string `json:"-"`
+	ClientSecret string `json:"-"`
+}
+
+type ResolveAPIKeyResponseModel struct {
+	Claims jwt.MapClaims
+}
+
+func MakeEndpointResolveAPIKey(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(ResolveAPIKeyRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointResolveAPIKey failed casting request")
+		}
+
+		claims, err := s.ResolveAPIKey(ctx, req.ClientID, req.ClientSecret, req.Key)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointResolveAPIKey %w", err)
+		}
+
+		return ResolveAPIKeyResponseModel{Claims: claims}, nil
+	}
+}
+
+type DiscoveryResponseModel struct {
+	Configuration oidc.Configuration
+}
+
+func MakeEndpointDiscovery(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+
+		return DiscoveryResponseModel{Configuration: s.Discovery(ctx)}, nil
+	}
+}
+
+type UserInfoResponseModel struct {
+	Claims jwt.MapClaims
+}
+
+// MakeEndpointUserInfo answers for the bearer token itself, it doesn't go through the authentication middleware
+// because userinfo also has to reject tokens that were revoked
+func MakeEndpointUserInfo(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		token, _ := ctx.Value(bearerTokenContextKey).(string)
+
+		if token == "" {
+			return nil, key.ErrInvalidToken
+		}
+
+		claims, err := s.UserInfo(ctx, token)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointUserInfo %w", err)
+		}
+
+		return UserInfoResponseModel{Claims: claims}, nil
+	}
+}
+
+type IntrospectRequestModel struct {
+	Token         string
+	TokenTypeHint string
+	ClientID      string
+	ClientSecret  string
+}
+
+type IntrospectResponseModel struct {
+	Introspection oidc.Introspection
+}
+
+func MakeEndpointIntrospect(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(IntrospectRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointIntrospect failed casting request")
+		}
+
+		if req.ClientID == "" || req.ClientSecret == "" {
+			return nil, key.ErrInvalidClient
+		}
+
+		result, err := s.IntrospectToken(ctx, req.ClientID, req.ClientSecret, req.Token, req.TokenTypeHint)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointIntrospect %w", err)
+		}
+
+		return IntrospectResponseModel{Introspection: result}, nil
+	}
+}
+
+type MagicLinkRequestModel struct {
+	Identifier string `json:"identifier"`
+	// DeviceID optionally identifies the device, the link is bound to the user agent without it
+	DeviceID string `json:"deviceId"`
+}
+
+func MakeEndpointRequestMagicLink(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(MagicLinkRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointRequestMagicLink failed casting request")
+		}
+
+		if err = s.RequestMagicLink(ctx, req.Identifier, req.DeviceID); err != nil {
+			return nil, fmt.Errorf("MakeEndpointRequestMagicLink %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type ConsumeMagicLinkRequestModel struct {
+	Token    string `json:"token"`
+	DeviceID string `json:"deviceId"`
+}
+
+func MakeEndpointConsumeMagicLink(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(ConsumeMagicLinkRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointConsumeMagicLink failed casting request")
+		}
+
+		if req.Token == "" {
+			return nil, key.ErrInvalidToken
+		}
+
+		token, err := s.ConsumeMagicLink(ctx, req.Token, req.DeviceID)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointConsumeMagicLink %w", err)
+		}
+
+		return LoginResponseModel{
+			AccessToken:  token.Access,
+			RefreshToken: token.Refresh,
+			MFAToken:     token.MFAPending,
+		}, nil
+	}
+}
+
+type FederatedLoginRequestModel struct {
+	provider string
+}
+
+type FederatedLoginResponseModel struct {
+	URL string
+}
+
+func MakeEndpointStartFederatedLogin(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(FederatedLoginRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointStartFederatedLogin failed casting request")
+		}
+
+		authURL, err := s.StartFederatedLogin(ctx, req.provider)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointStartFederatedLogin %w", err)
+		}
+
+		return FederatedLoginResponseModel{URL: authURL}, nil
+	}
+}
+
+type FederatedCallbackRequestModel struct {
+	provider string
+	Code     string
+	State    string
+	// Error is set by the provider when the user denied the login or it failed
+	Error string
+}
+
+func MakeEndpointFederatedCallback(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(FederatedCallbackRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointFederatedCallback failed casting request")
+		}
+
+		if req.Error != "" {
+			return nil, fmt.Errorf("MakeEndpointFederatedCallback provider answered %s %w", req.Error, key.ErrInvalidCredentials)
+		}
+
+		if req.Code == "" || req.State == "" {
+			return nil, fmt.Errorf("MakeEndpointFederatedCallback missing code or state %w", key.ErrBadRequest)
+		}
+
+		token, err := s.CompleteFederatedLogin(ctx, req.provider, req.Code, req.State)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointFederatedCallback %w", err)
+		}
+
+		return LoginResponseModel{
+			AccessToken:  token.Access,
+			RefreshToken: token.Refresh,
+			MFAToken:     token.MFAPending,
+		}, nil
+	}
+}
+
+type ImpersonateRequestModel struct {
+	userID string
+}
+
+type ImpersonateResponseModel struct {
+	AccessToken string
+	ExpiresIn   int
+}
+
+func MakeEndpointImpersonate(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(ImpersonateRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointImpersonate failed casting request")
+		}
+
+		token, err := s.Impersonate(ctx, userIDFromContext(ctx), req.userID)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointImpersonate %w", err)
+		}
+
+		return ImpersonateResponseModel{AccessToken: token, ExpiresIn: int(impersonationTTL.Seconds())}, nil
+	}
+}
+
+type CreateOrganizationRequestModel struct {
+	Name string `json:"name"`
+}
+
+type OrganizationResponseModel struct {
+	Organization organization.OrganizationResponse
+}
+
+func MakeEndpointCreateOrganization(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(CreateOrganizationRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointCreateOrganization failed casting request")
+		}
+
+		created, err := s.CreateOrganization(ctx, userIDFromContext(ctx), req.Name)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointCreateOrganization %w", err)
+		}
+
+		membership := organization.Membership{Organization: *created, Role: organization.RoleOwner}
+
+		return OrganizationResponseModel{Organization: organization.ToResponse(membership)}, nil
+	}
+}
+
+type ListOrganizationsResponseModel struct {
+	Organizations []organization.OrganizationResponse
+}
+
+func MakeEndpointListOrganizations(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		memberships, err := s.ListOrganizations(ctx, userIDFromContext(ctx))
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointListOrganizations %w", err)
+		}
+
+		res := ListOrganizationsResponseModel{Organizations: make([]organization.OrganizationResponse, 0, len(memberships))}
+		for _, membership := range memberships {
+			res.Organizations = append(res.Organizations, organization.ToResponse(membership))
+		}
+
+		return res, nil
+	}
+}
+
+type OrganizationIDRequestModel struct {
+	orgID string
+}
+
+type ListMembersResponseModel struct {
+	Members []organization.MemberResponse
+}
+
+func MakeEndpointListMembers(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(OrganizationIDRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointListMembers failed casting request")
+		}
+
+		members, err := s.ListMembers(ctx, userIDFromContext(ctx), req.orgID)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointListMembers %w", err)
+		}
+
+		res := ListMembersResponseModel{Members: make([]organization.MemberResponse, 0, len(members))}
+		for _, member := range members {
+			res.Members = append(res.Members, organization.ToMemberResponse(member))
+		}
+
+		return res, nil
+	}
+}
+
+type InviteMemberRequestModel struct {
+	orgID string
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type InvitationResponseModel struct {
+	Invitation organization.InvitationResponse
+}
+
+func MakeEndpointInviteMember(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(InviteMemberRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointInviteMember failed casting request")
+		}
+
+		invitation, err := s.InviteMember(ctx, userIDFromContext(ctx), req.orgID, req.Email, req.Role)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointInviteMember %w", err)
+		}
+
+		return InvitationResponseModel{Invitation: organization.ToInvitationResponse(*invitation)}, nil
+	}
+}
+
+type AcceptInvitationRequestModel struct {
+	Token string `json:"token"`
+}
+
+type MemberResponseModel struct {
+	Member organization.MemberResponse
+}
+
+func MakeEndpointAcceptInvitation(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(AcceptInvitationRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointAcceptInvitation failed casting request")
+		}
+
+		member, err := s.AcceptInvitation(ctx, userIDFromContext(ctx), req.Token)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointAcceptInvitation %w", err)
+		}
+
+		return MemberResponseModel{Member: organization.ToMemberResponse(*member)}, nil
+	}
+}
+
+type MemberRequestModel struct {
+	orgID  string
+	userID string
+	Role   string `json:"role"`
+}
+
+func MakeEndpointUpdateMemberRole(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(MemberRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointUpdateMemberRole failed casting request")
+		}
+
+		if err = s.UpdateMemberRole(ctx, userIDFromContext(ctx), req.orgID, req.userID, req.Role); err != nil {
+			return nil, fmt.Errorf("MakeEndpointUpdateMemberRole %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+func MakeEndpointRemoveMember(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(MemberRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointRemoveMember failed casting request")
+		}
+
+		if err = s.RemoveMember(ctx, userIDFromContext(ctx), req.orgID, req.userID); err != nil {
+			return nil, fmt.Errorf("MakeEndpointRemoveMember %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type SwitchOrganizationResponseModel struct {
+	AccessToken string
+	ExpiresIn   int
+}
+
+func MakeEndpointSwitchOrganization(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(OrganizationIDRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointSwitchOrganization failed casting request")
+		}
+
+		token, err := s.SwitchOrganization(ctx, userIDFromContext(ctx), req.orgID)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointSwitchOrganization %w", err)
+		}
+
+		return SwitchOrganizationResponseModel{AccessToken: token, ExpiresIn: int(accessTokenTTL.Seconds())}, nil
+	}
+}
+
+type AuditEventsRequestModel struct {
+	filter audit.Filter
+}
+
+// ListAuditEventsResponseModel - Next is the cursor of the following page, zero on the last one
+type ListAuditEventsResponseModel struct {
+	Events []audit.Event
+	Next   int64
+}
+
+func MakeEndpointListAuditEvents(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(AuditEventsRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointListAuditEvents failed casting request")
+		}
+
+		filter := req.filter.WithLimit()
+		events, err := s.ListAuditEvents(ctx, filter)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointListAuditEvents %w", err)
+		}
+
+		res := ListAuditEventsResponseModel{Events: events}
+		if len(events) == filter.Limit {
+			res.Next = events[len(events)-1].ID
+		}
+
+		return res, nil
+	}
+}
+
+// ExportAuditEventsResponseModel - the events are read while they are written, Stream hands each of them to write
+type ExportAuditEventsResponseModel struct {
+	Stream func(write func(audit.Event) error) error
+}
+
+func MakeEndpointExportAuditEvents(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(AuditEventsRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointExportAuditEvents failed casting request")
+		}
+
+		return ExportAuditEventsResponseModel{
+			Stream: func(write func(audit.Event) error) error {
+				return s.ExportAuditEvents(ctx, req.filter, write)
+			},
+		}, nil
+	}
+}
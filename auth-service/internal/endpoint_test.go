@@ -6,10 +6,17 @@ import (
 	"errors"
 	"github.com/ireuven89/auctions/shared/jwksprovider"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/ireuven89/auctions/auth-service/apikey"
+	"github.com/ireuven89/auctions/auth-service/audit"
 	"github.com/ireuven89/auctions/auth-service/internal/mocks"
 	"github.com/ireuven89/auctions/auth-service/key"
+	"github.com/ireuven89/auctions/auth-service/organization"
 	user2 "github.com/ireuven89/auctions/auth-service/user"
+	sharedhttp "github.com/ireuven89/auctions/shared/http"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,7 +31,7 @@ func TestMakeEndpointGetPublicKey(t *testing.T) {
 
 	resp, err := endpoint(context.Background(), nil)
 	assert.NoError(t, err)
-	assert.Equal(t, []json.RawMessage{[]byte{'E'}}, resp.(GetPublicKeyResponse).PublicKey)
+	assert.Equal(t, []json.RawMessage{[]byte{'E'}}, resp.(GetPublicKeyResponse).PublicKey.Keys)
 }
 
 // REGISTER USER
@@ -108,35 +115,32 @@ func TestMakeEndpointLogin_BadRequest(t *testing.T) {
 }
 
 // LOGOUT
-/*func TestMakeEndpointLogout_Success(t *testing.T) {
+func TestMakeEndpointLogout_Success(t *testing.T) {
 	mock := &mocks.MockService{
-		LoginFunc: func(ctx context.Context, identifier, password string) (*key.Token, error) {
-			return &key.Token{Access: "acc", Refresh: "ref"}, nil
+		LogoutFunc: func(ctx context.Context, refreshToken string) error {
+			assert.Equal(t, "ref", refreshToken)
+			return nil
 		},
 	}
 	endpoint := MakeEndpointLogout(mock)
 
-	req := LoginRequestModel{Identifier: "foo", Password: "bar"}
-	resp, err := endpoint(context.Background(), req)
+	resp, err := endpoint(context.Background(), LogoutRequestModel{RefreshToken: "ref"})
 	assert.NoError(t, err)
-	r := resp.(key.Token)
-	assert.Equal(t, "acc", r.Access)
-	assert.Equal(t, "ref", r.Refresh)
+	assert.Nil(t, resp)
 }
 
 func TestMakeEndpointLogout_Error(t *testing.T) {
 	mock := &mocks.MockService{
-		LO: func(ctx context.Context, identifier, password string) (Token, error) {
-			return Token{}, errors.New("logout error")
+		LogoutFunc: func(ctx context.Context, refreshToken string) error {
+			return errors.New("logout error")
 		},
 	}
 	endpoint := MakeEndpointLogout(mock)
 
-	req := LoginRequestModel{Identifier: "foo", Password: "bar"}
-	resp, err := endpoint(context.Background(), req)
+	resp, err := endpoint(context.Background(), LogoutRequestModel{RefreshToken: "ref"})
 	assert.Nil(t, resp)
 	assert.Error(t, err)
-}*/
+}
 
 func TestMakeEndpointLogout_BadRequest(t *testing.T) {
 	mock := &mocks.MockService{}
@@ -185,3 +189,333 @@ func TestMakeEndpointRefreshToken_BadRequest(t *testing.T) {
 	assert.Nil(t, resp)
 	assert.Error(t, err)
 }
+
+// PASSWORD RESET
+func TestMakeEndpointForgotPassword_Success(t *testing.T) {
+	mock := &mocks.MockService{
+		ForgotPasswordFunc: func(ctx context.Context, identifier string) error {
+			assert.Equal(t, "foo@bar.com", identifier)
+			return nil
+		},
+	}
+	endpoint := MakeEndpointForgotPassword(mock)
+
+	resp, err := endpoint(context.Background(), ForgotPasswordRequestModel{Identifier: "foo@bar.com"})
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+}
+
+func TestMakeEndpointForgotPassword_BadRequest(t *testing.T) {
+	endpoint := MakeEndpointForgotPassword(&mocks.MockService{})
+
+	resp, err := endpoint(context.Background(), "not a request")
+	assert.Nil(t, resp)
+	assert.Error(t, err)
+}
+
+func TestMakeEndpointResetPassword_Error(t *testing.T) {
+	mock := &mocks.MockService{
+		ResetPasswordFunc: func(ctx context.Context, token, password string) error {
+			return key.ErrInvalidToken
+		},
+	}
+	endpoint := MakeEndpointResetPassword(mock)
+
+	resp, err := endpoint(context.Background(), ResetPasswordRequestModel{Token: "t", Password: "p"})
+	assert.Nil(t, resp)
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+// AUTHENTICATION
+func TestMakeAuthenticationMiddleware_MissingToken(t *testing.T) {
+	endpoint := MakeAuthenticationMiddleware(&mocks.MockService{})(func(ctx context.Context, request interface{}) (interface{}, error) {
+		t.Fatal("endpoint should not be called")
+		return nil, nil
+	})
+
+	_, err := endpoint(context.Background(), nil)
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+func TestMakeAuthenticationMiddleware_ValidToken(t *testing.T) {
+	mock := &mocks.MockService{
+		VerifyAccessTokenFunc: func(ctx context.Context, accessToken string) (jwt.MapClaims, error) {
+			assert.Equal(t, "tok", accessToken)
+			return jwt.MapClaims{"sub": "user-id"}, nil
+		},
+	}
+	endpoint := MakeAuthenticationMiddleware(mock)(func(ctx context.Context, request interface{}) (interface{}, error) {
+		return userIDFromContext(ctx), nil
+	})
+
+	ctx := context.WithValue(context.Background(), bearerTokenContextKey, "tok")
+	resp, err := endpoint(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-id", resp)
+}
+
+// TWO FACTOR
+func TestMakeEndpointConfirmMFA_Success(t *testing.T) {
+	mock := &mocks.MockService{
+		ConfirmMFAFunc: func(ctx context.Context, userID, code string) ([]string, error) {
+			assert.Equal(t, "user-id", userID)
+			return []string{"a", "b"}, nil
+		},
+	}
+	endpoint := MakeEndpointConfirmMFA(mock)
+
+	ctx := context.WithValue(context.Background(), userIDContextKey, "user-id")
+	resp, err := endpoint(ctx, MFACodeRequestModel{Code: "123456"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, resp.(ConfirmMFAResponseModel).RecoveryCodes)
+}
+
+func TestMakeEndpointVerifyMFA_Error(t *testing.T) {
+	mock := &mocks.MockService{
+		VerifyMFAFunc: func(ctx context.Context, mfaToken, code string) (*key.Token, error) {
+			return nil, key.ErrInvalidMFACode
+		},
+	}
+	endpoint := MakeEndpointVerifyMFA(mock)
+
+	resp, err := endpoint(context.Background(), VerifyMFARequestModel{MFAToken: "t", Code: "c"})
+	assert.Nil(t, resp)
+	assert.ErrorIs(t, err, key.ErrInvalidMFACode)
+}
+
+// ACCOUNT
+func TestMakeEndpointGetMe_Success(t *testing.T) {
+	mock := &mocks.MockService{
+		GetUserFunc: func(ctx context.Context, id string) (*user2.User, error) {
+			return &user2.User{ID: id, Name: "foo", Email: "foo@bar.com", Password: "hash"}, nil
+		},
+	}
+	endpoint := MakeEndpointGetMe(mock)
+
+	ctx := context.WithValue(context.Background(), userIDContextKey, "user-id")
+	resp, err := endpoint(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, user2.UserResponse{ID: "user-id", Username: "foo", Email: "foo@bar.com"}, resp.(UserResponseModel).User)
+}
+
+func TestMakeAdminMiddleware_Forbidden(t *testing.T) {
+	endpoint := MakeAdminMiddleware()(func(ctx context.Context, request interface{}) (interface{}, error) {
+		t.Fatal("endpoint should not be called")
+		return nil, nil
+	})
+
+	ctx := context.WithValue(context.Background(), claimsContextKey, jwt.MapClaims{"sub": "user-id", "role": user2.RoleUser})
+	_, err := endpoint(ctx, nil)
+	assert.ErrorIs(t, err, key.ErrForbidden)
+}
+
+func TestMakeAdminMiddleware_Admin(t *testing.T) {
+	endpoint := MakeAdminMiddleware()(func(ctx context.Context, request interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+
+	ctx := context.WithValue(context.Background(), claimsContextKey, jwt.MapClaims{"sub": "admin-id", "role": user2.RoleAdmin})
+	resp, err := endpoint(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+}
+
+func TestMakeEndpointDeleteUser_Error(t *testing.T) {
+	mock := &mocks.MockService{
+		DeleteUserFunc: func(ctx context.Context, id string) error {
+			return errors.New("delete error")
+		},
+	}
+	endpoint := MakeEndpointDeleteUser(mock)
+
+	resp, err := endpoint(context.Background(), UserIDRequestModel{id: "user-id"})
+	assert.Nil(t, resp)
+	assert.Error(t, err)
+}
+
+// SESSIONS
+func TestMakeEndpointListSessions_Success(t *testing.T) {
+	mock := &mocks.MockService{
+		ListSessionsFunc: func(ctx context.Context, userID string) ([]user2.Session, error) {
+			assert.Equal(t, "user-id", userID)
+			return []user2.Session{{ID: "session-id", UserID: userID, Device: "Chrome on macOS"}}, nil
+		},
+	}
+	endpoint := MakeEndpointListSessions(mock)
+
+	ctx := context.WithValue(context.Background(), userIDContextKey, "user-id")
+	resp, err := endpoint(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []user2.SessionResponse{{ID: "session-id", Device: "Chrome on macOS"}}, resp.(ListSessionsResponseModel).Sessions)
+}
+
+func TestMakeEndpointRevokeSession_Success(t *testing.T) {
+	mock := &mocks.MockService{
+		RevokeSessionFunc: func(ctx context.Context, userID, sessionID string) error {
+			assert.Equal(t, "user-id", userID)
+			assert.Equal(t, "session-id", sessionID)
+			return nil
+		},
+	}
+	endpoint := MakeEndpointRevokeSession(mock)
+
+	ctx := context.WithValue(context.Background(), userIDContextKey, "user-id")
+	_, err := endpoint(ctx, SessionIDRequestModel{id: "session-id"})
+	assert.NoError(t, err)
+}
+
+// CLIENT CREDENTIALS
+func TestMakeEndpointClientToken_UnsupportedGrant(t *testing.T) {
+	endpoint := MakeEndpointClientToken(&mocks.MockService{})
+
+	_, err := endpoint(context.Background(), ClientTokenRequestModel{GrantType: "password", ClientID: "id", ClientSecret: "secret"})
+	assert.ErrorIs(t, err, key.ErrUnsupportedGrantType)
+}
+
+func TestMakeEndpointClientToken_Success(t *testing.T) {
+	mock := &mocks.MockService{
+		IssueClientTokenFunc: func(ctx context.Context, clientID, secret string, scopes []string) (string, []string, error) {
+			assert.Equal(t, "auction-service", clientID)
+			assert.Equal(t, "secret", secret)
+			return "token", scopes, nil
+		},
+	}
+	endpoint := MakeEndpointClientToken(mock)
+
+	resp, err := endpoint(context.Background(), ClientTokenRequestModel{GrantType: "client_credentials", ClientID: "auction-service", ClientSecret: "secret", Scopes: []string{"bidders:read"}})
+	assert.NoError(t, err)
+	assert.Equal(t, ClientTokenResponseModel{AccessToken: "token", Scopes: []string{"bidders:read"}}, resp)
+}
+
+// API KEYS
+func TestMakeEndpointCreateAPIKey_Success(t *testing.T) {
+	mock := &mocks.MockService{
+		CreateAPIKeyFunc: func(ctx context.Context, userID, orgID, name string, scopes []string, expiresAt *time.Time) (string, *apikey.APIKey, error) {
+			assert.Equal(t, "user-id", userID)
+			return "ak_plain", &apikey.APIKey{ID: "key-id", UserID: userID, Name: name, Prefix: "ak_plain", Hash: "hash", Scopes: scopes}, nil
+		},
+	}
+	endpoint := MakeEndpointCreateAPIKey(mock)
+
+	ctx := context.WithValue(context.Background(), userIDContextKey, "user-id")
+	resp, err := endpoint(ctx, CreateAPIKeyRequestModel{Name: "ci", Scopes: []string{apikey.ScopeAuctionsWrite}})
+	assert.NoError(t, err)
+	assert.Equal(t, "ak_plain", resp.(CreateAPIKeyResponseModel).Key)
+	assert.Equal(t, "key-id", resp.(CreateAPIKeyResponseModel).APIKey.ID)
+}
+
+func TestMakeEndpointResolveAPIKey_Invalid(t *testing.T) {
+	mock := &mocks.MockService{
+		ResolveAPIKeyFunc: func(ctx context.Context, clientID, secret, k string) (jwt.MapClaims, error) {
+			return nil, key.ErrInvalidToken
+		},
+	}
+	endpoint := MakeEndpointResolveAPIKey(mock)
+
+	_, err := endpoint(context.Background(), ResolveAPIKeyRequestModel{Key: "ak_unknown"})
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+// IMPERSONATION
+func TestMakeEndpointImpersonate_Success(t *testing.T) {
+	mock := &mocks.MockService{
+		ImpersonateFunc: func(ctx context.Context, adminID, userID string) (string, error) {
+			assert.Equal(t, "admin-id", adminID)
+			assert.Equal(t, "user-id", userID)
+			return "token", nil
+		},
+	}
+	endpoint := MakeEndpointImpersonate(mock)
+
+	ctx := context.WithValue(context.Background(), userIDContextKey, "admin-id")
+	resp, err := endpoint(ctx, ImpersonateRequestModel{userID: "user-id"})
+	assert.NoError(t, err)
+	assert.Equal(t, "token", resp.(ImpersonateResponseModel).AccessToken)
+}
+
+func TestMakeAuthenticationMiddleware_RecordsImpersonation(t *testing.T) {
+	var recorded []sharedhttp.ImpersonationEntry
+	mock := &mocks.MockService{
+		VerifyAccessTokenFunc: func(ctx context.Context, accessToken string) (jwt.MapClaims, error) {
+			return jwt.MapClaims{"sub": "user-id", "act": map[string]interface{}{"sub": "admin-id"}}, nil
+		},
+		RecordImpersonationFunc: func(ctx context.Context, entry sharedhttp.ImpersonationEntry) {
+			recorded = append(recorded, entry)
+		},
+	}
+	endpoint := MakeAuthenticationMiddleware(mock)(MakeDenyImpersonationMiddleware()(func(ctx context.Context, request interface{}) (interface{}, error) {
+		t.Fatal("endpoint should not be called")
+		return nil, nil
+	}))
+
+	ctx := context.WithValue(context.Background(), bearerTokenContextKey, "tok")
+	ctx = context.WithValue(ctx, requestMethodContextKey, "DELETE")
+	ctx = context.WithValue(ctx, requestPathContextKey, "/auth/me")
+	_, err := endpoint(ctx, nil)
+
+	assert.ErrorIs(t, err, key.ErrForbidden)
+	assert.Len(t, recorded, 1)
+	assert.Equal(t, "admin-id", recorded[0].Actor)
+	assert.Equal(t, "user-id", recorded[0].Subject)
+	assert.Equal(t, "DELETE", recorded[0].Method)
+	assert.Equal(t, "/auth/me", recorded[0].Path)
+	assert.NotEmpty(t, recorded[0].Error)
+}
+
+func TestMakeDenyImpersonationMiddleware_NotImpersonating(t *testing.T) {
+	endpoint := MakeDenyImpersonationMiddleware()(func(ctx context.Context, request interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+
+	ctx := context.WithValue(context.Background(), claimsContextKey, jwt.MapClaims{"sub": "user-id"})
+	resp, err := endpoint(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", resp)
+}
+
+// ORGANIZATIONS
+func TestMakeEndpointInviteMember_Success(t *testing.T) {
+	mock := &mocks.MockService{
+		InviteMemberFunc: func(ctx context.Context, userID, orgID, email, role string) (*organization.Invitation, error) {
+			assert.Equal(t, "user-id", userID)
+			assert.Equal(t, "org-id", orgID)
+			return &organization.Invitation{ID: "invitation-id", Email: email, Role: role, TokenHash: "hash"}, nil
+		},
+	}
+	endpoint := MakeEndpointInviteMember(mock)
+
+	ctx := context.WithValue(context.Background(), userIDContextKey, "user-id")
+	resp, err := endpoint(ctx, InviteMemberRequestModel{orgID: "org-id", Email: "foo@bar.com", Role: organization.RoleManager})
+	assert.NoError(t, err)
+	assert.Equal(t, organization.InvitationResponse{ID: "invitation-id", Email: "foo@bar.com", Role: organization.RoleManager}, resp.(InvitationResponseModel).Invitation)
+}
+
+func TestMakeEndpointSwitchOrganization_NotMember(t *testing.T) {
+	mock := &mocks.MockService{
+		SwitchOrganizationFunc: func(ctx context.Context, userID, orgID string) (string, error) {
+			return "", key.ErrNotFound
+		},
+	}
+	endpoint := MakeEndpointSwitchOrganization(mock)
+
+	ctx := context.WithValue(context.Background(), userIDContextKey, "user-id")
+	_, err := endpoint(ctx, OrganizationIDRequestModel{orgID: "org-id"})
+	assert.ErrorIs(t, err, key.ErrNotFound)
+}
+
+func TestMakeEndpointListAuditEvents_Next(t *testing.T) {
+	mock := &mocks.MockService{}
+	mock.ListAuditEventsFunc = func(ctx context.Context, filter audit.Filter) ([]audit.Event, error) {
+		return []audit.Event{{ID: 9}, {ID: 7}}, nil
+	}
+	endpoint := MakeEndpointListAuditEvents(mock)
+
+	resp, err := endpoint(context.Background(), AuditEventsRequestModel{filter: audit.Filter{Limit: 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), resp.(ListAuditEventsResponseModel).Next)
+
+	// a short page is the last one
+	resp, err = endpoint(context.Background(), AuditEventsRequestModel{filter: audit.Filter{Limit: 3}})
+	assert.NoError(t, err)
+	assert.Zero(t, resp.(ListAuditEventsResponseModel).Next)
+}
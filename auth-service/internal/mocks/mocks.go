@@ -2,69 +2,390 @@ package mocks
 
 import (
 	"context"
-<<<<<<< HEAD
-=======
-	"github.com/ireuven89/auctions/shared/jwksprovider"
->>>>>>> a2466c3 (items logic)
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	sharedhttp "github.com/ireuven89/auctions/shared/http"
+	"github.com/ireuven89/auctions/shared/jwksprovider"
+	"github.com/ireuven89/auctions/shared/messaging/outbox"
+
+	"github.com/ireuven89/auctions/auth-service/apikey"
+	"github.com/ireuven89/auctions/auth-service/audit"
+	"github.com/ireuven89/auctions/auth-service/federation"
 	"github.com/ireuven89/auctions/auth-service/key"
+	"github.com/ireuven89/auctions/auth-service/magiclink"
+	"github.com/ireuven89/auctions/auth-service/mailer"
+	"github.com/ireuven89/auctions/auth-service/oauth"
+	"github.com/ireuven89/auctions/auth-service/oidc"
+	"github.com/ireuven89/auctions/auth-service/organization"
 
 	"github.com/ireuven89/auctions/auth-service/user"
 )
 
 // MockRepository mocks the repository interface
 type MockRepo struct {
-	CreateUserFunc            func(ctx context.Context, u user.User) error
-	FindUserFunc              func(ctx context.Context, id string) error
-	FindUserByCredentialsFunc func(ctx context.Context, identifier string) (*user.User, error)
-	GetTokenFunc              func(ctx context.Context, token string) (string, error)
-	SaveRefreshTokenFunc      func(ctx context.Context, token string, userId string, ttl time.Duration) error
-<<<<<<< HEAD
-	GetRefreshRateFunc        func(ctx context.Context, token string) (int, error)
+	CreateUserFunc             func(ctx context.Context, u user.User, events ...outbox.Event) error
+	FindUserFunc               func(ctx context.Context, id string) (*user.User, error)
+	FindUserByCredentialsFunc  func(ctx context.Context, identifier string) (*user.User, error)
+	GetTokenFunc               func(ctx context.Context, token string) (string, error)
+	SaveRefreshTokenFunc       func(ctx context.Context, token string, session user.Session, ttl time.Duration) error
+	GetRefreshRateFunc         func(ctx context.Context, token string) (int, error)
+	DeleteUserFunc             func(ctx context.Context, id string, events ...outbox.Event) error
+	UpdatePasswordFunc         func(ctx context.Context, id, password string) error
+	RevokeRefreshTokensFunc    func(ctx context.Context, userID string) error
+	SaveResetTokenFunc         func(ctx context.Context, tokenHash, userID string, ttl time.Duration) error
+	ConsumeResetTokenFunc      func(ctx context.Context, tokenHash string) (string, time.Duration, error)
+	SaveMFASecretFunc          func(ctx context.Context, userID, secret string) error
+	FindMFAFunc                func(ctx context.Context, userID string) (*user.MFA, error)
+	EnableMFAFunc              func(ctx context.Context, userID string, recoveryCodeHashes []string) error
+	DisableMFAFunc             func(ctx context.Context, userID string) error
+	UseRecoveryCodeFunc        func(ctx context.Context, userID, codeHash string) error
+	UseTOTPStepFunc            func(ctx context.Context, userID string, step int64) error
+	UpdateUserFunc             func(ctx context.Context, u user.User) error
+	SaveEmailChangeFunc        func(ctx context.Context, tokenHash, userID, email string, ttl time.Duration) error
+	ConsumeEmailChangeFunc     func(ctx context.Context, tokenHash string) (string, string, error)
+	ListSessionsFunc           func(ctx context.Context, userID string) ([]user.Session, error)
+	DeleteSessionFunc          func(ctx context.Context, userID, sessionID string) error
+	CreateClientFunc           func(ctx context.Context, client oauth.Client) error
+	FindClientFunc             func(ctx context.Context, id string) (*oauth.Client, error)
+	DeleteClientFunc           func(ctx context.Context, id string) error
+	CreateAPIKeyFunc           func(ctx context.Context, key apikey.APIKey) error
+	ListAPIKeysFunc            func(ctx context.Context, userID string) ([]apikey.APIKey, error)
+	FindAPIKeyByHashFunc       func(ctx context.Context, hash string) (*apikey.APIKey, error)
+	DeleteAPIKeyFunc           func(ctx context.Context, userID, id string) error
+	TouchAPIKeyFunc            func(ctx context.Context, id string, usedAt time.Time) error
+	FindRefreshTokenFunc       func(ctx context.Context, token string) (string, time.Duration, error)
+	RevokeAccessTokensFunc     func(ctx context.Context, userID string, at time.Time, ttl time.Duration) error
+	AccessTokensRevokedAtFunc  func(ctx context.Context, userID string) (time.Time, error)
+	CountAttemptFunc           func(ctx context.Context, bucket string, window time.Duration) (int64, error)
+	ResetAttemptsFunc          func(ctx context.Context, bucket string) error
+	SaveMagicLinkFunc          func(ctx context.Context, tokenHash string, link magiclink.Link, ttl time.Duration) error
+	FindMagicLinkFunc          func(ctx context.Context, tokenHash string) (*magiclink.Link, error)
+	ConsumeMagicLinkFunc       func(ctx context.Context, tokenHash string) (*magiclink.Link, error)
+	FindIdentityFunc           func(ctx context.Context, provider, subject string) (string, error)
+	CreateIdentityFunc         func(ctx context.Context, userID string, identity federation.Identity) error
+	CreateUserWithIdentityFunc func(ctx context.Context, u user.User, identity federation.Identity, events ...outbox.Event) error
+	SaveFederationStateFunc    func(ctx context.Context, state string, values federation.State, ttl time.Duration) error
+	ConsumeFederationStateFunc func(ctx context.Context, state string) (*federation.State, error)
+	CreateOrganizationFunc     func(ctx context.Context, org organization.Organization, owner organization.Member) error
+	ListOrganizationsFunc      func(ctx context.Context, userID string) ([]organization.Membership, error)
+	FindMemberFunc             func(ctx context.Context, orgID, userID string) (*organization.Member, error)
+	ListMembersFunc            func(ctx context.Context, orgID string) ([]organization.Member, error)
+	CountOwnersFunc            func(ctx context.Context, orgID string) (int, error)
+	UpdateMemberRoleFunc       func(ctx context.Context, orgID, userID, role string) error
+	DeleteMemberFunc           func(ctx context.Context, orgID, userID string) error
+	CreateInvitationFunc       func(ctx context.Context, invitation organization.Invitation) error
+	FindInvitationFunc         func(ctx context.Context, tokenHash string) (*organization.Invitation, error)
+	AcceptInvitationFunc       func(ctx context.Context, invitationID string, member organization.Member) error
+	DeleteRefreshTokenFunc     func(ctx context.Context, token string) (string, error)
+	AppendAuditEventFunc       func(ctx context.Context, event audit.Event) error
+	ListAuditEventsFunc        func(ctx context.Context, filter audit.Filter) ([]audit.Event, error)
+	ExportAuditEventsFunc      func(ctx context.Context, filter audit.Filter, fn func(audit.Event) error) error
 }
 
 func (m *MockRepo) GetRefreshRate(ctx context.Context, token string) (int, error) {
 	return m.GetRefreshRateFunc(ctx, token)
-=======
-	DeleteUserFunc            func(ctx context.Context, id string) error
->>>>>>> a2466c3 (items logic)
 }
 
 func (m *MockRepo) FindUser(ctx context.Context, id string) (*user.User, error) {
-	return m.FindUser(ctx, id)
+	return m.FindUserFunc(ctx, id)
 }
 
 func (m *MockRepo) FindUserByCredentials(ctx context.Context, identifier string) (*user.User, error) {
-	return m.FindUserByCredentials(ctx, identifier)
+	return m.FindUserByCredentialsFunc(ctx, identifier)
 }
 
-func (m *MockRepo) SaveRefreshToken(ctx context.Context, token string, userId string, ttl time.Duration) error {
-	return m.SaveRefreshTokenFunc(ctx, token, userId, ttl)
+func (m *MockRepo) SaveRefreshToken(ctx context.Context, token string, session user.Session, ttl time.Duration) error {
+	return m.SaveRefreshTokenFunc(ctx, token, session, ttl)
 }
 
 func (m *MockRepo) GetToken(ctx context.Context, token string) (string, error) {
-	return m.GetToken(ctx, token)
+	return m.GetTokenFunc(ctx, token)
 }
 
-func (m *MockRepo) CreateUser(ctx context.Context, u user.User) error {
-	return m.CreateUserFunc(ctx, u)
+func (m *MockRepo) CreateUser(ctx context.Context, u user.User, events ...outbox.Event) error {
+	return m.CreateUserFunc(ctx, u, events...)
 }
 
-func (m *MockRepo) DeleteUser(ctx context.Context, id string) error {
-	return m.DeleteUserFunc(ctx, id)
+func (m *MockRepo) DeleteUser(ctx context.Context, id string, events ...outbox.Event) error {
+	return m.DeleteUserFunc(ctx, id, events...)
+}
+
+func (m *MockRepo) UpdatePassword(ctx context.Context, id, password string) error {
+	return m.UpdatePasswordFunc(ctx, id, password)
+}
+
+func (m *MockRepo) RevokeRefreshTokens(ctx context.Context, userID string) error {
+	return m.RevokeRefreshTokensFunc(ctx, userID)
+}
+
+func (m *MockRepo) SaveResetToken(ctx context.Context, tokenHash, userID string, ttl time.Duration) error {
+	return m.SaveResetTokenFunc(ctx, tokenHash, userID, ttl)
+}
+
+func (m *MockRepo) ConsumeResetToken(ctx context.Context, tokenHash string) (string, time.Duration, error) {
+	return m.ConsumeResetTokenFunc(ctx, tokenHash)
+}
+
+func (m *MockRepo) SaveMFASecret(ctx context.Context, userID, secret string) error {
+	return m.SaveMFASecretFunc(ctx, userID, secret)
+}
+
+func (m *MockRepo) FindMFA(ctx context.Context, userID string) (*user.MFA, error) {
+	return m.FindMFAFunc(ctx, userID)
+}
+
+func (m *MockRepo) EnableMFA(ctx context.Context, userID string, recoveryCodeHashes []string) error {
+	return m.EnableMFAFunc(ctx, userID, recoveryCodeHashes)
+}
+
+func (m *MockRepo) DisableMFA(ctx context.Context, userID string) error {
+	return m.DisableMFAFunc(ctx, userID)
+}
+
+func (m *MockRepo) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	return m.UseRecoveryCodeFunc(ctx, userID, codeHash)
+}
+
+func (m *MockRepo) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return m.UseTOTPStepFunc(ctx, userID, step)
+}
+
+func (m *MockRepo) UpdateUser(ctx context.Context, u user.User) error {
+	return m.UpdateUserFunc(ctx, u)
+}
+
+func (m *MockRepo) SaveEmailChange(ctx context.Context, tokenHash, userID, email string, ttl time.Duration) error {
+	return m.SaveEmailChangeFunc(ctx, tokenHash, userID, email, ttl)
+}
+
+func (m *MockRepo) ConsumeEmailChange(ctx context.Context, tokenHash string) (string, string, error) {
+	return m.ConsumeEmailChangeFunc(ctx, tokenHash)
+}
+
+func (m *MockRepo) ListSessions(ctx context.Context, userID string) ([]user.Session, error) {
+	return m.ListSessionsFunc(ctx, userID)
+}
+
+func (m *MockRepo) DeleteSession(ctx context.Context, userID, sessionID string) error {
+	return m.DeleteSessionFunc(ctx, userID, sessionID)
+}
+
+func (m *MockRepo) CreateClient(ctx context.Context, client oauth.Client) error {
+	return m.CreateClientFunc(ctx, client)
+}
+
+func (m *MockRepo) FindClient(ctx context.Context, id string) (*oauth.Client, error) {
+	return m.FindClientFunc(ctx, id)
+}
+
+func (m *MockRepo) DeleteClient(ctx context.Context, id string) error {
+	return m.DeleteClientFunc(ctx, id)
+}
+
+func (m *MockRepo) CreateAPIKey(ctx context.Context, key apikey.APIKey) error {
+	return m.CreateAPIKeyFunc(ctx, key)
+}
+
+func (m *MockRepo) ListAPIKeys(ctx context.Context, userID string) ([]apikey.APIKey, error) {
+	return m.ListAPIKeysFunc(ctx, userID)
+}
+
+func (m *MockRepo) FindAPIKeyByHash(ctx context.Context, hash string) (*apikey.APIKey, error) {
+	return m.FindAPIKeyByHashFunc(ctx, hash)
+}
+
+func (m *MockRepo) DeleteAPIKey(ctx context.Context, userID, id string) error {
+	return m.DeleteAPIKeyFunc(ctx, userID, id)
+}
+
+func (m *MockRepo) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	return m.TouchAPIKeyFunc(ctx, id, usedAt)
+}
+
+func (m *MockRepo) FindRefreshToken(ctx context.Context, token string) (string, time.Duration, error) {
+	return m.FindRefreshTokenFunc(ctx, token)
+}
+
+func (m *MockRepo) RevokeAccessTokens(ctx context.Context, userID string, at time.Time, ttl time.Duration) error {
+	return m.RevokeAccessTokensFunc(ctx, userID, at, ttl)
+}
+
+func (m *MockRepo) AccessTokensRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	return m.AccessTokensRevokedAtFunc(ctx, userID)
+}
+
+func (m *MockRepo) CountAttempt(ctx context.Context, bucket string, window time.Duration) (int64, error) {
+	return m.CountAttemptFunc(ctx, bucket, window)
+}
+
+func (m *MockRepo) ResetAttempts(ctx context.Context, bucket string) error {
+	return m.ResetAttemptsFunc(ctx, bucket)
+}
+
+func (m *MockRepo) SaveMagicLink(ctx context.Context, tokenHash string, link magiclink.Link, ttl time.Duration) error {
+	return m.SaveMagicLinkFunc(ctx, tokenHash, link, ttl)
+}
+
+func (m *MockRepo) FindMagicLink(ctx context.Context, tokenHash string) (*magiclink.Link, error) {
+	return m.FindMagicLinkFunc(ctx, tokenHash)
+}
+
+func (m *MockRepo) ConsumeMagicLink(ctx context.Context, tokenHash string) (*magiclink.Link, error) {
+	return m.ConsumeMagicLinkFunc(ctx, tokenHash)
+}
+
+func (m *MockRepo) FindIdentity(ctx context.Context, provider, subject string) (string, error) {
+	return m.FindIdentityFunc(ctx, provider, subject)
+}
+
+func (m *MockRepo) CreateIdentity(ctx context.Context, userID string, identity federation.Identity) error {
+	return m.CreateIdentityFunc(ctx, userID, identity)
+}
+
+func (m *MockRepo) CreateUserWithIdentity(ctx context.Context, u user.User, identity federation.Identity, events ...outbox.Event) error {
+	return m.CreateUserWithIdentityFunc(ctx, u, identity, events...)
+}
+
+func (m *MockRepo) SaveFederationState(ctx context.Context, state string, values federation.State, ttl time.Duration) error {
+	return m.SaveFederationStateFunc(ctx, state, values, ttl)
+}
+
+func (m *MockRepo) ConsumeFederationState(ctx context.Context, state string) (*federation.State, error) {
+	return m.ConsumeFederationStateFunc(ctx, state)
+}
+
+func (m *MockRepo) CreateOrganization(ctx context.Context, org organization.Organization, owner organization.Member) error {
+	return m.CreateOrganizationFunc(ctx, org, owner)
+}
+
+func (m *MockRepo) ListOrganizations(ctx context.Context, userID string) ([]organization.Membership, error) {
+	return m.ListOrganizationsFunc(ctx, userID)
+}
+
+func (m *MockRepo) FindMember(ctx context.Context, orgID, userID string) (*organization.Member, error) {
+	return m.FindMemberFunc(ctx, orgID, userID)
+}
+
+func (m *MockRepo) ListMembers(ctx context.Context, orgID string) ([]organization.Member, error) {
+	return m.ListMembersFunc(ctx, orgID)
+}
+
+func (m *MockRepo) CountOwners(ctx context.Context, orgID string) (int, error) {
+	return m.CountOwnersFunc(ctx, orgID)
+}
+
+func (m *MockRepo) UpdateMemberRole(ctx context.Context, orgID, userID, role string) error {
+	return m.UpdateMemberRoleFunc(ctx, orgID, userID, role)
+}
+
+func (m *MockRepo) DeleteMember(ctx context.Context, orgID, userID string) error {
+	return m.DeleteMemberFunc(ctx, orgID, userID)
+}
+
+func (m *MockRepo) CreateInvitation(ctx context.Context, invitation organization.Invitation) error {
+	return m.CreateInvitationFunc(ctx, invitation)
+}
+
+func (m *MockRepo) FindInvitation(ctx context.Context, tokenHash string) (*organization.Invitation, error) {
+	return m.FindInvitationFunc(ctx, tokenHash)
+}
+
+func (m *MockRepo) AcceptInvitation(ctx context.Context, invitationID string, member organization.Member) error {
+	return m.AcceptInvitationFunc(ctx, invitationID, member)
+}
+
+func (m *MockRepo) DeleteRefreshToken(ctx context.Context, token string) (string, error) {
+	return m.DeleteRefreshTokenFunc(ctx, token)
+}
+
+// AppendAuditEvent is recorded on most flows, tests that don't check the audit leave the func unset
+func (m *MockRepo) AppendAuditEvent(ctx context.Context, event audit.Event) error {
+	if m.AppendAuditEventFunc != nil {
+		return m.AppendAuditEventFunc(ctx, event)
+	}
+
+	return nil
+}
+
+// ListAuditEvents and ExportAuditEvents serve the MockService as well, the service methods have the same signature
+func (m *MockRepo) ListAuditEvents(ctx context.Context, filter audit.Filter) ([]audit.Event, error) {
+	return m.ListAuditEventsFunc(ctx, filter)
+}
+
+func (m *MockRepo) ExportAuditEvents(ctx context.Context, filter audit.Filter, fn func(audit.Event) error) error {
+	return m.ExportAuditEventsFunc(ctx, filter, fn)
+}
+
+// MockMailer records every sent message
+type MockMailer struct {
+	Sent     []mailer.Message
+	SendFunc func(ctx context.Context, msg mailer.Message) error
+}
+
+func (m *MockMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.Sent = append(m.Sent, msg)
+
+	if m.SendFunc != nil {
+		return m.SendFunc(ctx, msg)
+	}
+
+	return nil
 }
 
 // MockService embeds service.Service and mocks token functions
 type MockService struct {
 	PubKey key.JWK
 	MockRepo
-	signTokenFunc        func(ctx context.Context, u user.User) (string, error)
-	generateRefreshToken func(ctx context.Context, id string) (string, error)
-	LoginFunc            func(ctx context.Context, userIdentifier, password string) (*key.Token, error)
-	RefreshTokenFunc     func(ctx context.Context, refreshToken string) (string, error)
-	GetPublicKeyFunc     func(ctx context.Context) jwksprovider.JWKS
-	RegisterFunc         func(ctx context.Context, user user.User) (string, string, error)
+	signTokenFunc              func(ctx context.Context, u user.User) (string, error)
+	generateRefreshToken       func(ctx context.Context, id string) (string, error)
+	LoginFunc                  func(ctx context.Context, userIdentifier, password string) (*key.Token, error)
+	LogoutFunc                 func(ctx context.Context, refreshToken string) error
+	RefreshTokenFunc           func(ctx context.Context, refreshToken string) (string, error)
+	GetPublicKeyFunc           func(ctx context.Context) jwksprovider.JWKS
+	RegisterFunc               func(ctx context.Context, user user.User) (string, string, error)
+	ForgotPasswordFunc         func(ctx context.Context, identifier string) error
+	ResetPasswordFunc          func(ctx context.Context, token, password string) error
+	VerifyAccessTokenFunc      func(ctx context.Context, accessToken string) (jwt.MapClaims, error)
+	EnrollMFAFunc              func(ctx context.Context, userID string) (string, string, error)
+	ConfirmMFAFunc             func(ctx context.Context, userID, code string) ([]string, error)
+	DisableMFAFunc             func(ctx context.Context, userID, code string) error
+	VerifyMFAFunc              func(ctx context.Context, mfaToken, code string) (*key.Token, error)
+	GetUserFunc                func(ctx context.Context, id string) (*user.User, error)
+	UpdateProfileFunc          func(ctx context.Context, id, name string) error
+	ChangePasswordFunc         func(ctx context.Context, id, currentPassword, newPassword string) error
+	RequestEmailChangeFunc     func(ctx context.Context, id, email, password string) error
+	ConfirmEmailChangeFunc     func(ctx context.Context, token string) error
+	DeleteAccountFunc          func(ctx context.Context, id, password string) error
+	UpdateUserFunc             func(ctx context.Context, u user.User) error
+	DeleteUserFunc             func(ctx context.Context, id string) error
+	ListSessionsFunc           func(ctx context.Context, userID string) ([]user.Session, error)
+	RevokeSessionFunc          func(ctx context.Context, userID, sessionID string) error
+	IssueClientTokenFunc       func(ctx context.Context, clientID, secret string, scopes []string) (string, []string, error)
+	CreateClientFunc           func(ctx context.Context, client oauth.Client) (string, error)
+	DeleteClientFunc           func(ctx context.Context, id string) error
+	CreateAPIKeyFunc           func(ctx context.Context, userID, orgID, name string, scopes []string, expiresAt *time.Time) (string, *apikey.APIKey, error)
+	ListAPIKeysFunc            func(ctx context.Context, userID string) ([]apikey.APIKey, error)
+	RevokeAPIKeyFunc           func(ctx context.Context, userID, id string) error
+	ResolveAPIKeyFunc          func(ctx context.Context, clientID, secret, key string) (jwt.MapClaims, error)
+	DiscoveryFunc              func(ctx context.Context) oidc.Configuration
+	UserInfoFunc               func(ctx context.Context, accessToken string) (jwt.MapClaims, error)
+	IntrospectTokenFunc        func(ctx context.Context, clientID, secret, token, hint string) (oidc.Introspection, error)
+	RequestMagicLinkFunc       func(ctx context.Context, identifier, deviceID string) error
+	ConsumeMagicLinkFunc       func(ctx context.Context, token, deviceID string) (*key.Token, error)
+	StartFederatedLoginFunc    func(ctx context.Context, provider string) (string, error)
+	CompleteFederatedLoginFunc func(ctx context.Context, provider, code, state string) (*key.Token, error)
+	ImpersonateFunc            func(ctx context.Context, adminID, userID string) (string, error)
+	RecordImpersonationFunc    func(ctx context.Context, entry sharedhttp.ImpersonationEntry)
+	// the organization funcs shadow the MockRepo ones of the same name
+	CreateOrganizationFunc func(ctx context.Context, userID, name string) (*organization.Organization, error)
+	ListOrganizationsFunc  func(ctx context.Context, userID string) ([]organization.Membership, error)
+	ListMembersFunc        func(ctx context.Context, userID, orgID string) ([]organization.Member, error)
+	InviteMemberFunc       func(ctx context.Context, userID, orgID, email, role string) (*organization.Invitation, error)
+	AcceptInvitationFunc   func(ctx context.Context, userID, token string) (*organization.Member, error)
+	UpdateMemberRoleFunc   func(ctx context.Context, userID, orgID, memberID, role string) error
+	RemoveMemberFunc       func(ctx context.Context, userID, orgID, memberID string) error
+	SwitchOrganizationFunc func(ctx context.Context, userID, orgID string) (string, error)
 }
 
 func (m *MockService) SignToken(ctx context.Context, u user.User) (string, error) {
@@ -80,10 +401,183 @@ func (m *MockService) Login(ctx context.Context, userIdentifier, password string
 func (m *MockService) RefreshToken(ctx context.Context, refreshToken string) (string, error) {
 	return m.RefreshTokenFunc(ctx, refreshToken)
 }
-func (m *MockService) GetPublicKey(ctx context.Context) jwksprovider.JWKS{
+func (m *MockService) GetPublicKey(ctx context.Context) jwksprovider.JWKS {
 	return m.GetPublicKeyFunc(ctx)
 }
 
 func (m *MockService) Register(ctx context.Context, user user.User) (string, string, error) {
 	return m.RegisterFunc(ctx, user)
 }
+
+func (m *MockService) ForgotPassword(ctx context.Context, identifier string) error {
+	return m.ForgotPasswordFunc(ctx, identifier)
+}
+
+func (m *MockService) ResetPassword(ctx context.Context, token, password string) error {
+	return m.ResetPasswordFunc(ctx, token, password)
+}
+
+func (m *MockService) VerifyAccessToken(ctx context.Context, accessToken string) (jwt.MapClaims, error) {
+	return m.VerifyAccessTokenFunc(ctx, accessToken)
+}
+
+func (m *MockService) EnrollMFA(ctx context.Context, userID string) (string, string, error) {
+	return m.EnrollMFAFunc(ctx, userID)
+}
+
+func (m *MockService) ConfirmMFA(ctx context.Context, userID, code string) ([]string, error) {
+	return m.ConfirmMFAFunc(ctx, userID, code)
+}
+
+// DisableMFA shadows MockRepo.DisableMFA, the service variant also takes the code
+func (m *MockService) DisableMFA(ctx context.Context, userID, code string) error {
+	return m.DisableMFAFunc(ctx, userID, code)
+}
+
+func (m *MockService) VerifyMFA(ctx context.Context, mfaToken, code string) (*key.Token, error) {
+	return m.VerifyMFAFunc(ctx, mfaToken, code)
+}
+
+func (m *MockService) GetUser(ctx context.Context, id string) (*user.User, error) {
+	return m.GetUserFunc(ctx, id)
+}
+
+func (m *MockService) UpdateProfile(ctx context.Context, id, name string) error {
+	return m.UpdateProfileFunc(ctx, id, name)
+}
+
+func (m *MockService) ChangePassword(ctx context.Context, id, currentPassword, newPassword string) error {
+	return m.ChangePasswordFunc(ctx, id, currentPassword, newPassword)
+}
+
+func (m *MockService) RequestEmailChange(ctx context.Context, id, email, password string) error {
+	return m.RequestEmailChangeFunc(ctx, id, email, password)
+}
+
+func (m *MockService) ConfirmEmailChange(ctx context.Context, token string) error {
+	return m.ConfirmEmailChangeFunc(ctx, token)
+}
+
+func (m *MockService) DeleteAccount(ctx context.Context, id, password string) error {
+	return m.DeleteAccountFunc(ctx, id, password)
+}
+
+func (m *MockService) UpdateUser(ctx context.Context, u user.User) error {
+	return m.UpdateUserFunc(ctx, u)
+}
+
+func (m *MockService) DeleteUser(ctx context.Context, id string) error {
+	return m.DeleteUserFunc(ctx, id)
+}
+
+func (m *MockService) ListSessions(ctx context.Context, userID string) ([]user.Session, error) {
+	return m.ListSessionsFunc(ctx, userID)
+}
+
+func (m *MockService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	return m.RevokeSessionFunc(ctx, userID, sessionID)
+}
+
+func (m *MockService) IssueClientToken(ctx context.Context, clientID, secret string, scopes []string) (string, []string, error) {
+	return m.IssueClientTokenFunc(ctx, clientID, secret, scopes)
+}
+
+// CreateClient shadows MockRepo.CreateClient, the service variant returns the generated secret
+func (m *MockService) CreateClient(ctx context.Context, client oauth.Client) (string, error) {
+	return m.CreateClientFunc(ctx, client)
+}
+
+func (m *MockService) DeleteClient(ctx context.Context, id string) error {
+	return m.DeleteClientFunc(ctx, id)
+}
+
+// CreateAPIKey shadows MockRepo.CreateAPIKey, the service variant generates the key
+func (m *MockService) CreateAPIKey(ctx context.Context, userID, orgID, name string, scopes []string, expiresAt *time.Time) (string, *apikey.APIKey, error) {
+	return m.CreateAPIKeyFunc(ctx, userID, orgID, name, scopes, expiresAt)
+}
+
+func (m *MockService) ListAPIKeys(ctx context.Context, userID string) ([]apikey.APIKey, error) {
+	return m.ListAPIKeysFunc(ctx, userID)
+}
+
+func (m *MockService) RevokeAPIKey(ctx context.Context, userID, id string) error {
+	return m.RevokeAPIKeyFunc(ctx, userID, id)
+}
+
+func (m *MockService) ResolveAPIKey(ctx context.Context, clientID, secret, key string) (jwt.MapClaims, error) {
+	return m.ResolveAPIKeyFunc(ctx, clientID, secret, key)
+}
+
+func (m *MockService) Discovery(ctx context.Context) oidc.Configuration {
+	return m.DiscoveryFunc(ctx)
+}
+
+func (m *MockService) UserInfo(ctx context.Context, accessToken string) (jwt.MapClaims, error) {
+	return m.UserInfoFunc(ctx, accessToken)
+}
+
+func (m *MockService) IntrospectToken(ctx context.Context, clientID, secret, token, hint string) (oidc.Introspection, error) {
+	return m.IntrospectTokenFunc(ctx, clientID, secret, token, hint)
+}
+
+func (m *MockService) RequestMagicLink(ctx context.Context, identifier, deviceID string) error {
+	return m.RequestMagicLinkFunc(ctx, identifier, deviceID)
+}
+
+func (m *MockService) ConsumeMagicLink(ctx context.Context, token, deviceID string) (*key.Token, error) {
+	return m.ConsumeMagicLinkFunc(ctx, token, deviceID)
+}
+
+func (m *MockService) StartFederatedLogin(ctx context.Context, provider string) (string, error) {
+	return m.StartFederatedLoginFunc(ctx, provider)
+}
+
+func (m *MockService) CompleteFederatedLogin(ctx context.Context, provider, code, state string) (*key.Token, error) {
+	return m.CompleteFederatedLoginFunc(ctx, provider, code, state)
+}
+
+func (m *MockService) Impersonate(ctx context.Context, adminID, userID string) (string, error) {
+	return m.ImpersonateFunc(ctx, adminID, userID)
+}
+
+func (m *MockService) RecordImpersonation(ctx context.Context, entry sharedhttp.ImpersonationEntry) {
+	if m.RecordImpersonationFunc != nil {
+		m.RecordImpersonationFunc(ctx, entry)
+	}
+}
+
+func (m *MockService) CreateOrganization(ctx context.Context, userID, name string) (*organization.Organization, error) {
+	return m.CreateOrganizationFunc(ctx, userID, name)
+}
+
+func (m *MockService) ListOrganizations(ctx context.Context, userID string) ([]organization.Membership, error) {
+	return m.ListOrganizationsFunc(ctx, userID)
+}
+
+func (m *MockService) ListMembers(ctx context.Context, userID, orgID string) ([]organization.Member, error) {
+	return m.ListMembersFunc(ctx, userID, orgID)
+}
+
+func (m *MockService) InviteMember(ctx context.Context, userID, orgID, email, role string) (*organization.Invitation, error) {
+	return m.InviteMemberFunc(ctx, userID, orgID, email, role)
+}
+
+func (m *MockService) AcceptInvitation(ctx context.Context, userID, token string) (*organization.Member, error) {
+	return m.AcceptInvitationFunc(ctx, userID, token)
+}
+
+func (m *MockService) UpdateMemberRole(ctx context.Context, userID, orgID, memberID, role string) error {
+	return m.UpdateMemberRoleFunc(ctx, userID, orgID, memberID, role)
+}
+
+func (m *MockService) RemoveMember(ctx context.Context, userID, orgID, memberID string) error {
+	return m.RemoveMemberFunc(ctx, userID, orgID, memberID)
+}
+
+func (m *MockService) SwitchOrganization(ctx context.Context, userID, orgID string) (string, error) {
+	return m.SwitchOrganizationFunc(ctx, userID, orgID)
+}
+
+func (m *MockService) Logout(ctx context.Context, refreshToken string) error {
+	return m.LogoutFunc(ctx, refreshToken)
+}
@@ -4,17 +4,29 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
+	"net/http"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ireuven89/auctions/shared/events"
+	sharedhttp "github.com/ireuven89/auctions/shared/http"
 	"github.com/ireuven89/auctions/shared/jwksprovider"
+	"github.com/ireuven89/auctions/shared/messaging/outbox"
 
 	"github.com/ireuven89/auctions/auth-service/user"
 
@@ -22,19 +34,73 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/ireuven89/auctions/auth-service/apikey"
+	"github.com/ireuven89/auctions/auth-service/audit"
 	"github.com/ireuven89/auctions/auth-service/db"
+	"github.com/ireuven89/auctions/auth-service/federation"
 	"github.com/ireuven89/auctions/auth-service/key"
+	"github.com/ireuven89/auctions/auth-service/magiclink"
+	"github.com/ireuven89/auctions/auth-service/mailer"
+	"github.com/ireuven89/auctions/auth-service/oauth"
+	"github.com/ireuven89/auctions/auth-service/oidc"
+	"github.com/ireuven89/auctions/auth-service/organization"
+	"github.com/ireuven89/auctions/auth-service/password"
+	"github.com/ireuven89/auctions/auth-service/totp"
+	"github.com/ireuven89/auctions/shared/encryption"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type Service interface {
 	SignToken(ctx context.Context, user user.User) (string, error)
 	Login(ctx context.Context, userIdentifier, password string) (*key.Token, error)
 	RefreshToken(ctx context.Context, refreshToken string) (string, error)
+	Logout(ctx context.Context, refreshToken string) error
 	GenerateRefreshToken(ctx context.Context, userInfo string) (string, error)
 	GetPublicKey(ctx context.Context) jwksprovider.JWKS
 	Register(ctx context.Context, user user.User) (string, string, error)
+	ForgotPassword(ctx context.Context, identifier string) error
+	ResetPassword(ctx context.Context, token, password string) error
+	VerifyAccessToken(ctx context.Context, accessToken string) (jwt.MapClaims, error)
+	EnrollMFA(ctx context.Context, userID string) (string, string, error)
+	ConfirmMFA(ctx context.Context, userID, code string) ([]string, error)
+	DisableMFA(ctx context.Context, userID, code string) error
+	VerifyMFA(ctx context.Context, mfaToken, code string) (*key.Token, error)
+	GetUser(ctx context.Context, id string) (*user.User, error)
+	UpdateProfile(ctx context.Context, id, name string) error
+	ChangePassword(ctx context.Context, id, currentPassword, newPassword string) error
+	RequestEmailChange(ctx context.Context, id, email, password string) error
+	ConfirmEmailChange(ctx context.Context, token string) error
+	DeleteAccount(ctx context.Context, id, password string) error
+	UpdateUser(ctx context.Context, user user.User) error
+	DeleteUser(ctx context.Context, id string) error
+	ListSessions(ctx context.Context, userID string) ([]user.Session, error)
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+	IssueClientToken(ctx context.Context, clientID, secret string, scopes []string) (string, []string, error)
+	CreateClient(ctx context.Context, client oauth.Client) (string, error)
+	DeleteClient(ctx context.Context, id string) error
+	CreateAPIKey(ctx context.Context, userID, orgID, name string, scopes []string, expiresAt *time.Time) (string, *apikey.APIKey, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]apikey.APIKey, error)
+	RevokeAPIKey(ctx context.Context, userID, id string) error
+	ResolveAPIKey(ctx context.Context, clientID, secret, key string) (jwt.MapClaims, error)
+	Discovery(ctx context.Context) oidc.Configuration
+	UserInfo(ctx context.Context, accessToken string) (jwt.MapClaims, error)
+	IntrospectToken(ctx context.Context, clientID, secret, token, hint string) (oidc.Introspection, error)
+	RequestMagicLink(ctx context.Context, identifier, deviceID string) error
+	ConsumeMagicLink(ctx context.Context, token, deviceID string) (*key.Token, error)
+	StartFederatedLogin(ctx context.Context, provider string) (string, error)
+	CompleteFederatedLogin(ctx context.Context, provider, code, state string) (*key.Token, error)
+	Impersonate(ctx context.Context, adminID, userID string) (string, error)
+	RecordImpersonation(ctx context.Context, entry sharedhttp.ImpersonationEntry)
+	CreateOrganization(ctx context.Context, userID, name string) (*organization.Organization, error)
+	ListOrganizations(ctx context.Context, userID string) ([]organization.Membership, error)
+	ListMembers(ctx context.Context, userID, orgID string) ([]organization.Member, error)
+	InviteMember(ctx context.Context, userID, orgID, email, role string) (*organization.Invitation, error)
+	AcceptInvitation(ctx context.Context, userID, token string) (*organization.Member, error)
+	UpdateMemberRole(ctx context.Context, userID, orgID, memberID, role string) error
+	RemoveMember(ctx context.Context, userID, orgID, memberID string) error
+	SwitchOrganization(ctx context.Context, userID, orgID string) (string, error)
+	ListAuditEvents(ctx context.Context, filter audit.Filter) ([]audit.Event, error)
+	ExportAuditEvents(ctx context.Context, filter audit.Filter, fn func(audit.Event) error) error
 }
 
 type service struct {
@@ -45,13 +111,48 @@ type service struct {
 	RotateTicker *time.Ticker
 	KeyMutex     sync.RWMutex
 	repository   db.Repository
+	mailer       mailer.Mailer
+	encrypter    encryption.Encrypter
+	hasher       *password.Hasher
+	policy       password.Policy
+	breaches     password.BreachChecker
+	issuer       string
+	magicLink    magiclink.Options
+	providers    federation.Providers
+	// invitationURL is the page organization invitations link to
+	invitationURL string
+	// mails are the emails being sent off the request path
+	mails sync.WaitGroup
 }
 
 const refreshTokenTTL = 24 * 30 * time.Hour
 const refreshMaxRate = 3
 const accessTokenTTL = 15 * time.Minute
+const resetTokenTTL = 15 * time.Minute
 
-func NewAuthService(logger *zap.Logger, repo db.Repository, secretName string) (Service, error) {
+// an identifier gets at most forgotPasswordMaxRequests reset emails per forgotPasswordWindow, and an address can
+// ask for forgotPasswordMaxPerIP whatever the identifiers
+const forgotPasswordMaxRequests = 5
+const forgotPasswordMaxPerIP = 20
+const forgotPasswordWindow = time.Hour
+const mfaPendingTTL = 5 * time.Minute
+
+// mfaMaxFailures wrong codes lock the user's two-factor challenge for the rest of mfaLockout, a new
+// pending token from another password login doesn't reset it
+const mfaMaxFailures = 5
+const mfaLockout = 15 * time.Minute
+const emailChangeTTL = 24 * time.Hour
+const mfaIssuer = "Auctions"
+const recoveryCodesCount = 10
+
+const serviceTokenTTL = 5 * time.Minute
+const federationStateTTL = 10 * time.Minute
+const impersonationTTL = 10 * time.Minute
+
+// tokenTypeMFAPending marks tokens that only allow completing the two-factor challenge
+const tokenTypeMFAPending = "mfa_pending"
+
+func NewAuthService(logger *zap.Logger, repo db.Repository, secretName string, mail mailer.Mailer, encrypter encryption.Encrypter, hasher *password.Hasher, policy password.Policy, breaches password.BreachChecker, issuer string, magicLink magiclink.Options, providers federation.Providers, invitationURL string) (Service, error) {
 
 	privateKey, err := loadPrivateKeyFromLocal()
 	if err != nil {
@@ -63,12 +164,14 @@ func NewAuthService(logger *zap.Logger, repo db.Repository, secretName string) (
 		return nil, fmt.Errorf("failed starting service %w", err)
 	}
 
-	s := service{privateKey: privateKey, publicKey: generateJWKSFromPublicKey(publicKey), logger: logger, repository: repo, RotateTicker: time.NewTicker(10 * time.Minute)}
+	s := service{privateKey: privateKey, publicKey: generateJWKSFromPublicKey(publicKey), logger: logger, repository: repo, mailer: mail, encrypter: encrypter, hasher: hasher, policy: policy, breaches: breaches, issuer: issuer, magicLink: magicLink, providers: providers, invitationURL: invitationURL, RotateTicker: time.NewTicker(10 * time.Minute)}
 
 	//todo remove this when key rotation is implmented in shared
 
 	//	go s.startKeyRotation()
 
+	s.auditSigningKey(context.Background(), publicKey)
+
 	return &s, nil
 }
 
@@ -192,17 +295,27 @@ func loadPublicKeyFromSecretsManager(secretName string) (*rsa.PublicKey, error)
 }
 
 func (s *service) SignToken(ctx context.Context, userInfo user.User) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, s.accessClaims(userInfo))
+
+	return token.SignedString(s.privateKey)
+}
+
+// accessClaims are the claims of a user's access token
+func (s *service) accessClaims(userInfo user.User) jwt.MapClaims {
 	claims := jwt.MapClaims{
 		"sub":   userInfo.ID,
 		"exp":   time.Now().Add(accessTokenTTL).Unix(),
 		"iat":   time.Now().Unix(),
 		"email": userInfo.Email,
+		"role":  userInfo.Role,
+		"jti":   generateID(),
 	}
 
-	jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if s.issuer != "" {
+		claims["iss"] = s.issuer
+	}
 
-	return token.SignedString(s.privateKey)
+	return claims
 }
 
 func (s *service) GetPublicKey(ctx context.Context) jwksprovider.JWKS {
@@ -212,13 +325,46 @@ func (s *service) GetPublicKey(ctx context.Context) jwksprovider.JWKS {
 
 func (s *service) startKeyRotation() {
 	for range s.RotateTicker.C {
-		newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+		newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			s.record(context.Background(), audit.Event{Type: audit.TypeKeyRotation, Outcome: audit.OutcomeFailure, ActorID: audit.ActorSystem, Reason: audit.ReasonInternal})
+			continue
+		}
 		s.KeyMutex.Lock()
 		s.privateKey = newKey
 		s.publicKey = generateJWKSFromPublicKey(&newKey.PublicKey)
 		s.KeyMutex.Unlock()
 		log.Println("🔄 AuthService rotated RSA key")
+		s.auditSigningKey(context.Background(), &newKey.PublicKey)
+	}
+}
+
+// auditSigningKey records a key rotation when the signing key differs from the last one audited. Keys are
+// rotated by deploying new key files, so this runs when the service starts
+func (s *service) auditSigningKey(ctx context.Context, publicKey *rsa.PublicKey) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+
+	if err != nil {
+		s.logger.Error("service.auditSigningKey failed encoding key", zap.Error(err))
+		return
+	}
+
+	sum := sha256.Sum256(der)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	last, err := s.repository.ListAuditEvents(ctx, audit.Filter{Type: audit.TypeKeyRotation, Outcome: audit.OutcomeSuccess, Limit: 1})
+
+	if err != nil {
+		s.logger.Error("service.auditSigningKey failed fetching the last rotation", zap.Error(err))
+		return
+	}
+
+	if len(last) > 0 && last[0].Details["fingerprint"] == fingerprint {
+		return
 	}
+
+	s.record(ctx, audit.Event{Type: audit.TypeKeyRotation, Outcome: audit.OutcomeSuccess, ActorID: audit.ActorSystem,
+		Details: map[string]string{"fingerprint": fingerprint}})
 }
 
 // generateJWKSFromPublicKey - this method exposes on ly the public key
@@ -226,8 +372,10 @@ func generateJWKSFromPublicKey(pub *rsa.PublicKey) jwksprovider.JWKS {
 	jwk := map[string]interface{}{
 		"kty": "RSA",
 		"kid": "current",
-		"n":   pub.N.String(),
-		"e":   "AQAB",
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
 	}
 	raw, _ := json.Marshal(jwk)
 	return jwksprovider.JWKS{
@@ -237,10 +385,14 @@ func generateJWKSFromPublicKey(pub *rsa.PublicKey) jwksprovider.JWKS {
 
 func (s *service) Register(ctx context.Context, userCredentials user.User) (string, string, error) {
 	if ok := validateEmail(userCredentials.Email); !ok {
-		return "", "", fmt.Errorf("invalid email pattern")
+		return "", "", key.ErrInvalidEmail
+	}
+
+	if err := s.validatePassword(ctx, userCredentials.Password, userCredentials.Email, userCredentials.Name); err != nil {
+		return "", "", err
 	}
 
-	hashedPassword, err := hashPassword(userCredentials.Password)
+	hashedPassword, err := s.hasher.Hash(userCredentials.Password)
 
 	if err != nil {
 		return "", "", fmt.Errorf("service.Register %w", err)
@@ -250,10 +402,23 @@ func (s *service) Register(ctx context.Context, userCredentials user.User) (stri
 
 	userCredentials.ID = userID
 	userCredentials.Password = hashedPassword
+	userCredentials.Role = user.RoleUser
+
+	registered, err := userRegistered(ctx, userCredentials, events.RegistrationPassword)
+
+	if err != nil {
+		return "", "", fmt.Errorf("service.Register %w", err)
+	}
 
-	err = s.repository.CreateUser(ctx, userCredentials)
+	err = s.repository.CreateUser(ctx, userCredentials, registered)
 
 	if err != nil {
+		reason := audit.ReasonInternal
+		if errors.Is(err, key.ErrAlreadyExists) {
+			reason = audit.ReasonAlreadyExists
+		}
+		s.record(ctx, audit.Event{Type: audit.TypeRegister, Outcome: audit.OutcomeFailure, Identifier: audit.HashIdentifier(userCredentials.Email), Reason: reason})
+
 		return "", "", fmt.Errorf("service.Register failed %w", err)
 	}
 
@@ -265,6 +430,8 @@ func (s *service) Register(ctx context.Context, userCredentials user.User) (stri
 
 	refreshToken, err := s.GenerateRefreshToken(ctx, userID)
 
+	s.record(ctx, audit.Event{Type: audit.TypeRegister, Outcome: audit.OutcomeSuccess, ActorID: userID, SubjectID: userID, Identifier: audit.HashIdentifier(userCredentials.Email)})
+
 	return token, refreshToken, nil
 }
 
@@ -286,7 +453,7 @@ func (s *service) RefreshToken(ctx context.Context, refreshToken string) (string
 	userId, err := s.repository.GetToken(ctx, "refresh:"+refreshToken)
 	if err != nil {
 		if errors.Is(err, key.ErrExpiredToken) {
-			s.logger.Error("service.RefreshToken", zap.Error(err), zap.String("token", refreshToken))
+			s.record(ctx, audit.Event{Type: audit.TypeRefresh, Outcome: audit.OutcomeFailure, Reason: audit.ReasonExpiredToken})
 			return "", key.ErrExpiredToken
 		}
 
@@ -307,9 +474,33 @@ func (s *service) RefreshToken(ctx context.Context, refreshToken string) (string
 		return "", fmt.Errorf("RefreshToken failed refreshing token %w", err)
 	}
 
+	s.record(ctx, audit.Event{Type: audit.TypeRefresh, Outcome: audit.OutcomeSuccess, ActorID: user.ID, SubjectID: user.ID})
+
 	return accessToken, nil
 }
 
+// Logout ends the session of the refresh token, logging out with a token that is already gone succeeds as well
+func (s *service) Logout(ctx context.Context, refreshToken string) error {
+	if refreshToken == "" {
+		return fmt.Errorf("service.Logout missing refresh token %w", key.ErrBadRequest)
+	}
+
+	userID, err := s.repository.DeleteRefreshToken(ctx, "refresh:"+refreshToken)
+
+	if err != nil {
+		if errors.Is(err, key.ErrExpiredToken) {
+			s.record(ctx, audit.Event{Type: audit.TypeLogout, Outcome: audit.OutcomeFailure, Reason: audit.ReasonExpiredToken})
+			return nil
+		}
+
+		return fmt.Errorf("service.Logout %w", err)
+	}
+
+	s.record(ctx, audit.Event{Type: audit.TypeLogout, Outcome: audit.OutcomeSuccess, ActorID: userID, SubjectID: userID})
+
+	return nil
+}
+
 /*
 	func (s *service) IsRefreshAllowed(ctx context.Context, refreshToken string) bool {
 		rate, err := s.repository.GetRefreshRate(ctx, refreshToken)
@@ -337,18 +528,50 @@ func (s *service) GenerateAccessToken(ctx context.Context, userId string) (strin
 	return token.SignedString(s.privateKey)
 }
 
-// TODO	change to user info - save in redis user as JSON
+// GenerateRefreshToken opens a new session for the user, the client details are taken from the request context
 func (s *service) GenerateRefreshToken(ctx context.Context, userID string) (string, error) {
 
 	token := uuid.New().String()
+	userAgent, ip := clientFromContext(ctx)
+	now := time.Now().UTC()
+
+	session := user.Session{
+		ID:         generateID(),
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		Device:     user.DeviceLabel(userAgent),
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
 
-	if err := s.repository.SaveRefreshToken(ctx, fmt.Sprintf("refresh:%s", token), userID, refreshTokenTTL); err != nil {
+	if err := s.repository.SaveRefreshToken(ctx, fmt.Sprintf("refresh:%s", token), session, refreshTokenTTL); err != nil {
 		return "", fmt.Errorf("GenerateRefreshToken %w", err)
 	}
 
 	return token, nil
 }
 
+func (s *service) ListSessions(ctx context.Context, userID string) ([]user.Session, error) {
+	sessions, err := s.repository.ListSessions(ctx, userID)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.ListSessions %w", err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession logs a single device out, the access token it holds stays valid until it expires
+func (s *service) RevokeSession(ctx context.Context, userID, sessionID string) error {
+
+	if err := s.repository.DeleteSession(ctx, userID, sessionID); err != nil {
+		return fmt.Errorf("service.RevokeSession %w", err)
+	}
+
+	return nil
+}
+
 func generateID() string {
 
 	return uuid.New().String()
@@ -359,25 +582,101 @@ func (s *service) Login(ctx context.Context, identifier, password string) (*key.
 	user, err := s.repository.FindUserByCredentials(ctx, identifier)
 
 	if err != nil {
-		s.logger.Error("service.Login unauthorized user", zap.Error(err), zap.String("identifier", identifier))
+		s.logger.Warn("service.Login unauthorized user", zap.Error(err))
+		s.record(ctx, audit.Event{Type: audit.TypeLogin, Outcome: audit.OutcomeFailure, Identifier: audit.HashIdentifier(identifier), Reason: audit.ReasonUnknownUser})
 		return nil, key.ErrInvalidCredentials
 	}
 
-	if ok := verifyUser(user.Password, password); !ok {
-		s.logger.Error("service.Login unauthorized user", zap.Error(err), zap.String("identifier", identifier))
+	ok, rehash, err := s.hasher.Verify(user.Password, password)
+
+	if err != nil || !ok {
+		s.logger.Warn("service.Login unauthorized user", zap.Error(err), zap.String("user", user.ID))
+		s.record(ctx, audit.Event{Type: audit.TypeLogin, Outcome: audit.OutcomeFailure, SubjectID: user.ID, Identifier: audit.HashIdentifier(identifier), Reason: audit.ReasonInvalidPassword})
 		return nil, key.ErrInvalidCredentials
 	}
 
-	accessToken, err := s.SignToken(ctx, *user)
+	if rehash {
+		s.rehashPassword(ctx, user.ID, password)
+	}
+
+	token, err := s.completeLogin(ctx, *user)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.Login %w", err)
+	}
+
+	s.recordLogin(ctx, user.ID, "password", token)
+
+	return token, nil
+}
+
+// recordLogin audits a login that passed its first factor, method is how the user proved who they are
+func (s *service) recordLogin(ctx context.Context, userID, method string, token *key.Token) {
+
+	s.record(ctx, audit.Event{Type: audit.TypeLogin, Outcome: loginOutcome(token), ActorID: userID, SubjectID: userID, Details: map[string]string{"method": method}})
+}
+
+func loginOutcome(token *key.Token) string {
+	if token.MFAPending != "" {
+		return audit.OutcomeChallenged
+	}
+
+	return audit.OutcomeSuccess
+}
+
+// record appends an event to the audit log, the client is taken from the request context.
+// A failing audit store doesn't fail the request the event describes
+func (s *service) record(ctx context.Context, event audit.Event) {
+	userAgent, ip := clientFromContext(ctx)
+
+	if event.IP == "" {
+		event.IP = ip
+	}
+
+	if event.UserAgent == "" {
+		event.UserAgent = userAgent
+	}
+
+	event.CreatedAt = time.Now().UTC()
+
+	if err := s.repository.AppendAuditEvent(ctx, event); err != nil {
+		s.logger.Error("service.record failed appending audit event", zap.Error(err), zap.String("type", event.Type), zap.String("outcome", event.Outcome))
+	}
+}
+
+// completeLogin issues the tokens for a user who proved who they are, or the two-factor challenge when it is enabled
+func (s *service) completeLogin(ctx context.Context, user user.User) (*key.Token, error) {
+	mfa, err := s.repository.FindMFA(ctx, user.ID)
+
+	if err != nil && !errors.Is(err, key.ErrMFANotEnabled) {
+		return nil, fmt.Errorf("failed fetching two-factor settings %w", err)
+	}
+
+	if mfa != nil && mfa.Enabled {
+		pendingToken, err := s.signMFAPendingToken(user.ID)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed creating mfa token %w", err)
+		}
+
+		return &key.Token{MFAPending: pendingToken}, nil
+	}
+
+	return s.issueTokens(ctx, user)
+}
+
+// issueTokens creates the access and refresh token pair for an authenticated user
+func (s *service) issueTokens(ctx context.Context, user user.User) (*key.Token, error) {
+	accessToken, err := s.SignToken(ctx, user)
 
 	if err != nil {
-		return nil, fmt.Errorf("service.Login failed creating access token %w", err)
+		return nil, fmt.Errorf("failed creating access token %w", err)
 	}
 
 	refreshToken, err := s.GenerateRefreshToken(ctx, user.ID)
 
 	if err != nil {
-		return nil, fmt.Errorf("service.Login failed creating refresh token %w", err)
+		return nil, fmt.Errorf("failed creating refresh token %w", err)
 
 	}
 
@@ -387,21 +686,1763 @@ func (s *service) Login(ctx context.Context, identifier, password string) (*key.
 	}, nil
 }
 
-func verifyUser(hashedPassword string, password string) bool {
+// validatePassword applies the password policy and rejects breached passwords, a nil breach checker disables the check
+func (s *service) validatePassword(ctx context.Context, pw string, personalInfo ...string) error {
+	if err := s.policy.Validate(pw, personalInfo...); err != nil {
+		return err
+	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)); err != nil {
-		return false
+	if s.breaches == nil {
+		return nil
+	}
+
+	breached, err := s.breaches.IsBreached(ctx, pw)
+
+	// an unreadable index must not lock users out of registering
+	if err != nil {
+		s.logger.Error("service.validatePassword failed checking breached passwords", zap.Error(err))
+		return nil
+	}
+
+	if breached {
+		return &password.PolicyError{Violations: []string{password.BreachedViolation}}
 	}
 
-	return true
+	return nil
 }
 
-func hashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// rehashPassword moves the user to the current hash algorithm, failing it must not fail the login
+func (s *service) rehashPassword(ctx context.Context, userID, password string) {
+	hashedPassword, err := s.hasher.Hash(password)
 
 	if err != nil {
-		return "", err
+		s.logger.Error("service.rehashPassword failed hashing", zap.Error(err), zap.String("user", userID))
+		return
+	}
+
+	if err = s.repository.UpdatePassword(ctx, userID, hashedPassword); err != nil {
+		s.logger.Error("service.rehashPassword failed updating password", zap.Error(err), zap.String("user", userID))
+	}
+}
+
+// ForgotPassword sends a single use reset token to the user's email.
+// It never reports whether the user exists, so callers can't use it to enumerate accounts: unknown identifiers
+// get a token stored the same way, one nobody receives, and the email goes out off the request path so the
+// answer takes as long either way. Requests are limited per identifier and per address
+func (s *service) ForgotPassword(ctx context.Context, identifier string) error {
+	if err := s.limitForgotPassword(ctx, identifier); err != nil {
+		return err
+	}
+
+	userID := ""
+	user, err := s.repository.FindUserByCredentials(ctx, identifier)
+
+	if err != nil {
+		s.logger.Warn("service.ForgotPassword user not found", zap.Error(err))
+	} else {
+		userID = user.ID
+	}
+
+	token, err := generateSecureToken()
+
+	if err != nil {
+		s.logger.Error("service.ForgotPassword failed generating token", zap.Error(err))
+		return nil
+	}
+
+	if err = s.repository.SaveResetToken(ctx, hashToken(token), userID, resetTokenTTL); err != nil {
+		s.logger.Error("service.ForgotPassword failed saving token", zap.Error(err))
+		return nil
+	}
+
+	if userID == "" {
+		return nil
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Use the following token to reset your password: %s\nThe token expires in %d minutes.", token, int(resetTokenTTL.Minutes())),
+	}
+
+	s.mails.Add(1)
+	go func() {
+		defer s.mails.Done()
+
+		if err := s.mailer.Send(context.WithoutCancel(ctx), msg); err != nil {
+			s.logger.Error("service.ForgotPassword failed sending reset email", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// limitForgotPassword counts the request against the identifier and the caller's address, the identifier
+// is counted whether or not it belongs to a user
+func (s *service) limitForgotPassword(ctx context.Context, identifier string) error {
+	_, ip := clientFromContext(ctx)
+	buckets := map[string]int64{"forgot:" + audit.HashIdentifier(identifier): forgotPasswordMaxRequests}
+
+	if ip != "" {
+		buckets["forgot-ip:"+ip] = forgotPasswordMaxPerIP
+	}
+
+	for bucket, limit := range buckets {
+		count, err := s.repository.CountAttempt(ctx, bucket, forgotPasswordWindow)
+
+		if err != nil {
+			return fmt.Errorf("service.ForgotPassword %w", err)
+		}
+
+		if count > limit {
+			s.logger.Warn("service.ForgotPassword rate limited", zap.String("identifier", audit.HashIdentifier(identifier)), zap.String("ip", ip))
+			return key.ErrTooManyRequests
+		}
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password for the user the token was issued to and logs out all of the user's sessions
+func (s *service) ResetPassword(ctx context.Context, token, password string) error {
+	if token == "" {
+		return key.ErrInvalidToken
+	}
+
+	// the personal info rules need the user, which is only known once the token is consumed
+	if err := s.validatePassword(ctx, password); err != nil {
+		return err
+	}
+
+	userID, ttl, err := s.repository.ConsumeResetToken(ctx, hashToken(token))
+
+	if err != nil {
+		if errors.Is(err, key.ErrInvalidToken) {
+			s.record(ctx, audit.Event{Type: audit.TypePasswordReset, Outcome: audit.OutcomeFailure, Reason: audit.ReasonInvalidToken})
+			return key.ErrInvalidToken
+		}
+
+		return fmt.Errorf("service.ResetPassword %w", err)
+	}
+
+	u, err := s.repository.FindUser(ctx, userID)
+
+	if err != nil {
+		return fmt.Errorf("service.ResetPassword %w", err)
+	}
+
+	if err = s.validatePassword(ctx, password, u.Email, u.Name); err != nil {
+		// give the token back for the rest of its life so the user can pick another password
+		if saveErr := s.repository.SaveResetToken(ctx, hashToken(token), userID, ttl); saveErr != nil {
+			s.logger.Error("service.ResetPassword failed restoring token", zap.Error(saveErr), zap.String("user", userID))
+		}
+
+		return err
+	}
+
+	hashedPassword, err := s.hasher.Hash(password)
+
+	if err != nil {
+		return fmt.Errorf("service.ResetPassword %w", err)
+	}
+
+	if err = s.repository.UpdatePassword(ctx, userID, hashedPassword); err != nil {
+		s.logger.Error("service.ResetPassword failed updating password", zap.Error(err), zap.String("user", userID))
+		return fmt.Errorf("service.ResetPassword %w", err)
+	}
+
+	if err = s.revokeUserTokens(ctx, userID); err != nil {
+		s.logger.Error("service.ResetPassword failed revoking sessions", zap.Error(err), zap.String("user", userID))
+		return fmt.Errorf("service.ResetPassword %w", err)
+	}
+
+	s.record(ctx, audit.Event{Type: audit.TypePasswordReset, Outcome: audit.OutcomeSuccess, ActorID: userID, SubjectID: userID})
+
+	return nil
+}
+
+// VerifyAccessToken validates an access token issued by this service and returns its claims, tokens issued
+// before the user's sessions were revoked are refused
+func (s *service) VerifyAccessToken(ctx context.Context, accessToken string) (jwt.MapClaims, error) {
+	claims, err := s.parseToken(accessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// only user access tokens are untyped, half logged in users and other services can't act as a user
+	if typ, _ := claims["typ"].(string); typ != "" {
+		return nil, key.ErrInvalidToken
+	}
+
+	revoked, err := s.accessTokenRevoked(ctx, claims)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.VerifyAccessToken %w", err)
+	}
+
+	if revoked {
+		return nil, key.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+func (s *service) parseToken(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return &s.privateKey.PublicKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, key.ErrExpiredToken
+		}
+
+		return nil, key.ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+func (s *service) signMFAPendingToken(userID string) (string, error) {
+	claims := jwt.MapClaims{
+		"sub": userID,
+		"typ": tokenTypeMFAPending,
+		"exp": time.Now().Add(mfaPendingTTL).Unix(),
+		"iat": time.Now().Unix(),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(s.privateKey)
+}
+
+// EnrollMFA starts a TOTP enrollment and returns the secret and the otpauth URI for the authenticator app.
+// The enrollment is only active after it is confirmed with a valid code
+func (s *service) EnrollMFA(ctx context.Context, userID string) (string, string, error) {
+	mfa, err := s.repository.FindMFA(ctx, userID)
+
+	if err != nil && !errors.Is(err, key.ErrMFANotEnabled) {
+		return "", "", fmt.Errorf("service.EnrollMFA %w", err)
+	}
+
+	if mfa != nil && mfa.Enabled {
+		return "", "", key.ErrMFAAlreadyEnabled
+	}
+
+	user, err := s.repository.FindUser(ctx, userID)
+
+	if err != nil {
+		return "", "", fmt.Errorf("service.EnrollMFA failed fetching user %w", err)
+	}
+
+	secret, err := totp.GenerateSecret()
+
+	if err != nil {
+		return "", "", fmt.Errorf("service.EnrollMFA %w", err)
+	}
+
+	encryptedSecret, err := s.encrypter.Encrypt([]byte(secret))
+
+	if err != nil {
+		return "", "", fmt.Errorf("service.EnrollMFA failed encrypting secret %w", err)
+	}
+
+	if err = s.repository.SaveMFASecret(ctx, userID, encryptedSecret); err != nil {
+		return "", "", fmt.Errorf("service.EnrollMFA %w", err)
+	}
+
+	return secret, totp.URI(mfaIssuer, user.Email, secret), nil
+}
+
+// ConfirmMFA enables two-factor authentication and returns the recovery codes, they are shown only once
+func (s *service) ConfirmMFA(ctx context.Context, userID, code string) ([]string, error) {
+	mfa, err := s.repository.FindMFA(ctx, userID)
+
+	if err != nil {
+		if errors.Is(err, key.ErrMFANotEnabled) {
+			return nil, key.ErrMFANotEnabled
+		}
+
+		return nil, fmt.Errorf("service.ConfirmMFA %w", err)
+	}
+
+	if mfa.Enabled {
+		return nil, key.ErrMFAAlreadyEnabled
+	}
+
+	step, ok, err := s.validateTOTP(mfa, code)
+
+	if err != nil || !ok {
+		if err != nil {
+			s.logger.Error("service.ConfirmMFA", zap.Error(err), zap.String("user", userID))
+		}
+		return nil, key.ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+
+	if err != nil {
+		return nil, fmt.Errorf("service.ConfirmMFA %w", err)
+	}
+
+	if err = s.repository.EnableMFA(ctx, userID, hashes); err != nil {
+		return nil, fmt.Errorf("service.ConfirmMFA %w", err)
+	}
+
+	s.record(ctx, audit.Event{Type: audit.TypeMFAEnable, Outcome: audit.OutcomeSuccess, ActorID: userID, SubjectID: userID})
+
+	// the confirmation code can't be used to log in again
+	if err = s.repository.UseTOTPStep(ctx, userID, step); err != nil {
+		s.logger.Error("service.ConfirmMFA failed recording code", zap.Error(err), zap.String("user", userID))
+	}
+
+	return codes, nil
+}
+
+// DisableMFA requires a valid TOTP or recovery code, so a stolen access token alone can't turn it off
+func (s *service) DisableMFA(ctx context.Context, userID, code string) error {
+	if err := s.checkMFACode(ctx, userID, code); err != nil {
+		if errors.Is(err, key.ErrInvalidMFACode) {
+			s.record(ctx, audit.Event{Type: audit.TypeMFADisable, Outcome: audit.OutcomeFailure, ActorID: userID, SubjectID: userID, Reason: audit.ReasonInvalidCode})
+		}
+
+		return err
+	}
+
+	if err := s.repository.DisableMFA(ctx, userID); err != nil {
+		return fmt.Errorf("service.DisableMFA %w", err)
+	}
+
+	s.record(ctx, audit.Event{Type: audit.TypeMFADisable, Outcome: audit.OutcomeSuccess, ActorID: userID, SubjectID: userID})
+
+	return nil
+}
+
+// VerifyMFA exchanges the mfa pending token Login returned and a valid code for the token pair
+func (s *service) VerifyMFA(ctx context.Context, mfaToken, code string) (*key.Token, error) {
+	claims, err := s.parseToken(mfaToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	userID, _ := claims["sub"].(string)
+
+	if claims["typ"] != tokenTypeMFAPending || userID == "" {
+		return nil, key.ErrInvalidToken
+	}
+
+	if err = s.checkMFACode(ctx, userID, code); err != nil {
+		s.logger.Warn("service.VerifyMFA invalid code", zap.Error(err), zap.String("user", userID))
+		s.record(ctx, audit.Event{Type: audit.TypeLogin, Outcome: audit.OutcomeFailure, SubjectID: userID, Reason: audit.ReasonInvalidCode, Details: map[string]string{"method": "mfa"}})
+		return nil, err
+	}
+
+	user, err := s.repository.FindUser(ctx, userID)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.VerifyMFA failed fetching user %w", err)
+	}
+
+	token, err := s.issueTokens(ctx, *user)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.VerifyMFA %w", err)
+	}
+
+	s.recordLogin(ctx, user.ID, "mfa", token)
+
+	return token, nil
+}
+
+// checkMFACode accepts either a TOTP code or an unused recovery code for a user with two-factor enabled.
+// Every TOTP code is accepted once, after mfaMaxFailures wrong codes the user is locked out for mfaLockout
+func (s *service) checkMFACode(ctx context.Context, userID, code string) error {
+	bucket := "mfa:" + userID
+	failures, err := s.repository.CountAttempt(ctx, bucket, mfaLockout)
+
+	if err != nil {
+		return fmt.Errorf("service.checkMFACode %w", err)
+	}
+
+	if failures > mfaMaxFailures {
+		s.logger.Warn("service.checkMFACode locked out", zap.String("user", userID))
+		return key.ErrTooManyRequests
+	}
+
+	if err = s.verifyMFACode(ctx, userID, code); err != nil {
+		return err
+	}
+
+	// every attempt is counted before it is checked so parallel guesses can't get past the limit,
+	// only the failed ones are kept
+	if err = s.repository.ResetAttempts(ctx, bucket); err != nil {
+		s.logger.Error("service.checkMFACode failed resetting attempts", zap.Error(err), zap.String("user", userID))
+	}
+
+	return nil
+}
+
+func (s *service) verifyMFACode(ctx context.Context, userID, code string) error {
+	mfa, err := s.repository.FindMFA(ctx, userID)
+
+	if err != nil {
+		if errors.Is(err, key.ErrMFANotEnabled) {
+			return key.ErrMFANotEnabled
+		}
+
+		return fmt.Errorf("service.verifyMFACode %w", err)
+	}
+
+	if !mfa.Enabled {
+		return key.ErrMFANotEnabled
+	}
+
+	step, ok, err := s.validateTOTP(mfa, code)
+
+	if err != nil {
+		return fmt.Errorf("service.verifyMFACode %w", err)
+	}
+
+	if ok {
+		if err = s.repository.UseTOTPStep(ctx, userID, step); err != nil {
+			if errors.Is(err, key.ErrInvalidMFACode) {
+				return key.ErrInvalidMFACode
+			}
+
+			return fmt.Errorf("service.verifyMFACode %w", err)
+		}
+
+		return nil
+	}
+
+	if len(code) == totp.Digits {
+		return key.ErrInvalidMFACode
+	}
+
+	if err = s.repository.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code))); err != nil {
+		if errors.Is(err, key.ErrInvalidMFACode) {
+			return key.ErrInvalidMFACode
+		}
+
+		return fmt.Errorf("service.verifyMFACode %w", err)
+	}
+
+	return nil
+}
+
+// validateTOTP returns the step of a valid code
+func (s *service) validateTOTP(mfa *user.MFA, code string) (int64, bool, error) {
+	secret, err := s.encrypter.Decrypt(mfa.Secret)
+
+	if err != nil {
+		return 0, false, fmt.Errorf("failed decrypting mfa secret %w", err)
+	}
+
+	step, ok := totp.Step(string(secret), code, time.Now())
+
+	return step, ok, nil
+}
+
+// generateRecoveryCodes returns the codes to show the user and their hashes to store
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodesCount)
+	hashes := make([]string, recoveryCodesCount)
+
+	for i := range codes {
+		b := make([]byte, 10)
+
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("generateRecoveryCodes %w", err)
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = code[:8] + "-" + code[8:]
+		hashes[i] = hashToken(code)
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+
+	return strings.ReplaceAll(code, "-", "")
+}
+
+func (s *service) GetUser(ctx context.Context, id string) (*user.User, error) {
+	u, err := s.repository.FindUser(ctx, id)
+
+	if err != nil {
+		if errors.Is(err, key.ErrUserNotFound) {
+			return nil, key.ErrNotFound
+		}
+
+		return nil, fmt.Errorf("service.GetUser %w", err)
+	}
+
+	return u, nil
+}
+
+func (s *service) UpdateProfile(ctx context.Context, id, name string) error {
+	if name == "" {
+		return fmt.Errorf("service.UpdateProfile name is required %w", key.ErrBadRequest)
+	}
+
+	if err := s.repository.UpdateUser(ctx, user.User{ID: id, Name: name}); err != nil {
+		return fmt.Errorf("service.UpdateProfile %w", err)
+	}
+
+	return nil
+}
+
+// ChangePassword requires the current password and logs out all the user's sessions
+func (s *service) ChangePassword(ctx context.Context, id, currentPassword, newPassword string) error {
+	u, err := s.verifyPassword(ctx, id, currentPassword)
+
+	if err != nil {
+		if errors.Is(err, key.ErrInvalidCredentials) {
+			s.record(ctx, audit.Event{Type: audit.TypePasswordChange, Outcome: audit.OutcomeFailure, ActorID: id, SubjectID: id, Reason: audit.ReasonInvalidPassword})
+		}
+
+		return err
+	}
+
+	if err = s.validatePassword(ctx, newPassword, u.Email, u.Name); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hasher.Hash(newPassword)
+
+	if err != nil {
+		return fmt.Errorf("service.ChangePassword %w", err)
+	}
+
+	if err = s.repository.UpdatePassword(ctx, id, hashedPassword); err != nil {
+		return fmt.Errorf("service.ChangePassword %w", err)
+	}
+
+	if err = s.revokeUserTokens(ctx, id); err != nil {
+		return fmt.Errorf("service.ChangePassword %w", err)
+	}
+
+	s.record(ctx, audit.Event{Type: audit.TypePasswordChange, Outcome: audit.OutcomeSuccess, ActorID: id, SubjectID: id})
+
+	return nil
+}
+
+// RequestEmailChange sends a verification token to the new address, the email is changed only once it is verified
+func (s *service) RequestEmailChange(ctx context.Context, id, email, password string) error {
+	if ok := validateEmail(email); !ok {
+		return key.ErrInvalidEmail
+	}
+
+	if _, err := s.verifyPassword(ctx, id, password); err != nil {
+		return err
+	}
+
+	token, err := generateSecureToken()
+
+	if err != nil {
+		return fmt.Errorf("service.RequestEmailChange %w", err)
+	}
+
+	if err = s.repository.SaveEmailChange(ctx, hashToken(token), id, email, emailChangeTTL); err != nil {
+		return fmt.Errorf("service.RequestEmailChange %w", err)
+	}
+
+	msg := mailer.Message{
+		To:      email,
+		Subject: "Verify your new email",
+		Body:    fmt.Sprintf("Use the following token to verify your new email: %s\nThe token expires in %d hours.", token, int(emailChangeTTL.Hours())),
+	}
+
+	if err = s.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("service.RequestEmailChange failed sending verification email %w", err)
+	}
+
+	return nil
+}
+
+// ConfirmEmailChange sets the verified email and logs out all of the user's sessions
+func (s *service) ConfirmEmailChange(ctx context.Context, token string) error {
+	if token == "" {
+		return key.ErrInvalidToken
+	}
+
+	userID, email, err := s.repository.ConsumeEmailChange(ctx, hashToken(token))
+
+	if err != nil {
+		if errors.Is(err, key.ErrInvalidToken) {
+			return key.ErrInvalidToken
+		}
+
+		return fmt.Errorf("service.ConfirmEmailChange %w", err)
+	}
+
+	if err = s.repository.UpdateUser(ctx, user.User{ID: userID, Email: email}); err != nil {
+		return fmt.Errorf("service.ConfirmEmailChange %w", err)
+	}
+
+	if err = s.revokeUserTokens(ctx, userID); err != nil {
+		s.logger.Error("service.ConfirmEmailChange failed revoking sessions", zap.Error(err), zap.String("user", userID))
+		return fmt.Errorf("service.ConfirmEmailChange %w", err)
+	}
+
+	return nil
+}
+
+// DeleteAccount deletes the user's own account, the password is required to confirm
+func (s *service) DeleteAccount(ctx context.Context, id, password string) error {
+	if _, err := s.verifyPassword(ctx, id, password); err != nil {
+		return err
+	}
+
+	return s.DeleteUser(ctx, id)
+}
+
+// UpdateUser - admin update, unlike the self service flows the email is changed without verification
+func (s *service) UpdateUser(ctx context.Context, u user.User) error {
+	if u.Email != "" && !validateEmail(u.Email) {
+		return key.ErrInvalidEmail
+	}
+
+	if u.Role != "" && !user.ValidRole(u.Role) {
+		return key.ErrInvalidRole
+	}
+
+	if err := s.repository.UpdateUser(ctx, u); err != nil {
+		if errors.Is(err, key.ErrUserNotFound) {
+			return key.ErrNotFound
+		}
+
+		return fmt.Errorf("service.UpdateUser %w", err)
+	}
+
+	if u.Role != "" {
+		claims, _ := ctx.Value(claimsContextKey).(jwt.MapClaims)
+		adminID, _ := claims["sub"].(string)
+		s.record(ctx, audit.Event{Type: audit.TypeRoleChange, Outcome: audit.OutcomeSuccess, ActorID: adminID, SubjectID: u.ID, Details: map[string]string{"role": u.Role}})
+	}
+
+	return nil
+}
+
+func (s *service) DeleteUser(ctx context.Context, id string) error {
+	if err := s.revokeUserTokens(ctx, id); err != nil {
+		return fmt.Errorf("service.DeleteUser %w", err)
+	}
+
+	deleted, err := events.NewEnvelope(ctx, events.TypeUserDeleted, events.UserDeleted{
+		UserID:    id,
+		DeletedAt: time.Now().UTC(),
+	})
+
+	if err != nil {
+		return fmt.Errorf("service.DeleteUser %w", err)
+	}
+
+	if err = s.repository.DeleteUser(ctx, id, outbox.Event{AggregateID: id, Topic: events.TopicUserDeleted, Envelope: deleted}); err != nil {
+		return fmt.Errorf("service.DeleteUser %w", err)
+	}
+
+	return nil
+}
+
+// userRegistered is the event telling the other services about a new user. It carries no personal data, the
+// outbox keeps it in plaintext
+func userRegistered(ctx context.Context, u user.User, method string) (outbox.Event, error) {
+	registered, err := events.NewEnvelope(ctx, events.TypeUserRegistered, events.UserRegistered{
+		UserID:       u.ID,
+		Method:       method,
+		RegisteredAt: time.Now().UTC(),
+	})
+
+	return outbox.Event{AggregateID: u.ID, Topic: events.TopicUserRegistered, Envelope: registered}, err
+}
+
+// revokeUserTokens ends all of the user's sessions and revokes the access tokens already handed out,
+// services that introspect tokens stop accepting them right away, the others once they expire
+func (s *service) revokeUserTokens(ctx context.Context, userID string) error {
+
+	if err := s.repository.RevokeRefreshTokens(ctx, userID); err != nil {
+		return err
+	}
+
+	return s.repository.RevokeAccessTokens(ctx, userID, time.Now(), accessTokenTTL)
+}
+
+// verifyPassword re-authenticates the user for sensitive account changes
+func (s *service) verifyPassword(ctx context.Context, id, password string) (*user.User, error) {
+	u, err := s.repository.FindUser(ctx, id)
+
+	if err != nil {
+		if errors.Is(err, key.ErrUserNotFound) {
+			return nil, key.ErrUserNotFound
+		}
+
+		return nil, fmt.Errorf("service.verifyPassword %w", err)
+	}
+
+	if ok, _, err := s.hasher.Verify(u.Password, password); err != nil || !ok {
+		return nil, key.ErrInvalidCredentials
+	}
+
+	return u, nil
+}
+
+// generateSecureToken returns a random url safe token to be sent to the user
+func generateSecureToken() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken - tokens are stored hashed so a leaked store can't be used to reset passwords
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+var clientIDPattern = regexp.MustCompile("^[a-z0-9][a-z0-9-]{1,63}$")
+
+// IssueClientToken implements the client_credentials grant, no scopes requested means all of the client's scopes
+func (s *service) IssueClientToken(ctx context.Context, clientID, secret string, scopes []string) (string, []string, error) {
+	client, err := s.authenticateClient(ctx, clientID, secret)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("service.IssueClientToken %w", err)
+	}
+
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+
+	if !client.Allows(scopes) {
+		return "", nil, key.ErrInvalidScope
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":   client.ID,
+		"azp":   client.ID,
+		"scope": oauth.FormatScopes(scopes),
+		"typ":   sharedhttp.TokenTypeService,
+		"exp":   now.Add(serviceTokenTTL).Unix(),
+		"iat":   now.Unix(),
+		"jti":   generateID(),
+	}
+
+	if s.issuer != "" {
+		claims["iss"] = s.issuer
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(s.privateKey)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("service.IssueClientToken %w", err)
+	}
+
+	return token, scopes, nil
+}
+
+// authenticateClient checks the client secret, unknown clients and wrong secrets both fail with key.ErrInvalidClient
+func (s *service) authenticateClient(ctx context.Context, clientID, secret string) (*oauth.Client, error) {
+	client, err := s.repository.FindClient(ctx, clientID)
+
+	if err != nil {
+		if errors.Is(err, key.ErrInvalidClient) {
+			return nil, key.ErrInvalidClient
+		}
+
+		return nil, err
+	}
+
+	if ok, _, err := s.hasher.Verify(client.SecretHash, secret); err != nil || !ok {
+		s.logger.Warn("service.authenticateClient invalid client secret", zap.String("client", clientID))
+		return nil, key.ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+// CreateClient registers a service client and returns its secret, which is not stored and can't be shown again
+func (s *service) CreateClient(ctx context.Context, client oauth.Client) (string, error) {
+	if !clientIDPattern.MatchString(client.ID) {
+		return "", fmt.Errorf("service.CreateClient invalid client id %w", key.ErrBadRequest)
+	}
+
+	for _, scope := range client.Scopes {
+		if !oauth.ValidScope(scope) {
+			return "", fmt.Errorf("service.CreateClient invalid scope %q %w", scope, key.ErrBadRequest)
+		}
+	}
+
+	if client.Name == "" {
+		client.Name = client.ID
+	}
+
+	secret, err := generateSecureToken()
+
+	if err != nil {
+		return "", fmt.Errorf("service.CreateClient %w", err)
+	}
+
+	if client.SecretHash, err = s.hasher.Hash(secret); err != nil {
+		return "", fmt.Errorf("service.CreateClient %w", err)
+	}
+
+	if err = s.repository.CreateClient(ctx, client); err != nil {
+		return "", fmt.Errorf("service.CreateClient %w", err)
+	}
+
+	return secret, nil
+}
+
+func (s *service) DeleteClient(ctx context.Context, id string) error {
+
+	if err := s.repository.DeleteClient(ctx, id); err != nil {
+		return fmt.Errorf("service.DeleteClient %w", err)
+	}
+
+	return nil
+}
+
+// CreateAPIKey returns the new key, it is only stored hashed and can't be shown again
+func (s *service) CreateAPIKey(ctx context.Context, userID, orgID, name string, scopes []string, expiresAt *time.Time) (string, *apikey.APIKey, error) {
+	if name == "" {
+		return "", nil, fmt.Errorf("service.CreateAPIKey name is required %w", key.ErrBadRequest)
+	}
+
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("service.CreateAPIKey at least one scope is required %w", key.ErrBadRequest)
+	}
+
+	for _, scope := range scopes {
+		if !apikey.ValidScope(scope) {
+			return "", nil, fmt.Errorf("service.CreateAPIKey unknown scope %q %w", scope, key.ErrBadRequest)
+		}
+	}
+
+	now := time.Now().UTC()
+
+	if expiresAt != nil && !expiresAt.After(now) {
+		return "", nil, fmt.Errorf("service.CreateAPIKey expiry must be in the future %w", key.ErrBadRequest)
+	}
+
+	if orgID != "" {
+		if _, err := s.member(ctx, orgID, userID); err != nil {
+			return "", nil, fmt.Errorf("service.CreateAPIKey %w", err)
+		}
+	}
+
+	plain, prefix, err := apikey.Generate()
+
+	if err != nil {
+		return "", nil, fmt.Errorf("service.CreateAPIKey %w", err)
+	}
+
+	created := apikey.APIKey{
+		ID:             generateID(),
+		UserID:         userID,
+		OrganizationID: orgID,
+		Name:           name,
+		Prefix:         prefix,
+		Hash:           apikey.Hash(plain),
+		Scopes:         scopes,
+		ExpiresAt:      expiresAt,
+		CreatedAt:      now,
+	}
+
+	if err = s.repository.CreateAPIKey(ctx, created); err != nil {
+		return "", nil, fmt.Errorf("service.CreateAPIKey %w", err)
+	}
+
+	return plain, &created, nil
+}
+
+func (s *service) ListAPIKeys(ctx context.Context, userID string) ([]apikey.APIKey, error) {
+	keys, err := s.repository.ListAPIKeys(ctx, userID)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.ListAPIKeys %w", err)
+	}
+
+	return keys, nil
+}
+
+func (s *service) RevokeAPIKey(ctx context.Context, userID, id string) error {
+
+	if err := s.repository.DeleteAPIKey(ctx, userID, id); err != nil {
+		return fmt.Errorf("service.RevokeAPIKey %w", err)
+	}
+
+	return nil
+}
+
+// ResolveAPIKey returns the principal of the key in the same shape as access token claims, only registered
+// clients may ask so the endpoint can't be used to probe for keys
+func (s *service) ResolveAPIKey(ctx context.Context, clientID, secret, plain string) (jwt.MapClaims, error) {
+	if _, err := s.authenticateClient(ctx, clientID, secret); err != nil {
+		return nil, fmt.Errorf("service.ResolveAPIKey %w", err)
+	}
+
+	if !apikey.LooksValid(plain) {
+		return nil, key.ErrInvalidToken
+	}
+
+	found, err := s.repository.FindAPIKeyByHash(ctx, apikey.Hash(plain))
+
+	if err != nil {
+		if errors.Is(err, key.ErrInvalidToken) {
+			return nil, key.ErrInvalidToken
+		}
+
+		return nil, fmt.Errorf("service.ResolveAPIKey %w", err)
+	}
+
+	now := time.Now().UTC()
+
+	if found.Expired(now) {
+		return nil, key.ErrExpiredToken
+	}
+
+	owner, err := s.repository.FindUser(ctx, found.UserID)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.ResolveAPIKey failed fetching owner %w", err)
+	}
+
+	// last use is informational, failing to record it must not fail the request
+	if err = s.repository.TouchAPIKey(ctx, found.ID, now); err != nil {
+		s.logger.Warn("service.ResolveAPIKey failed recording use", zap.Error(err), zap.String("key", found.ID))
+	}
+
+	claims := jwt.MapClaims{
+		"sub":   found.UserID,
+		"email": owner.Email,
+		"role":  owner.Role,
+		"scope": strings.Join(found.Scopes, " "),
+		"typ":   sharedhttp.TokenTypeAPIKey,
+		"jti":   found.ID,
+		"iat":   found.CreatedAt.Unix(),
+	}
+
+	if found.ExpiresAt != nil {
+		claims["exp"] = found.ExpiresAt.Unix()
+	}
+
+	// the role comes from the membership rather than the key, so a demoted member's keys are demoted too
+	if found.OrganizationID != "" {
+		member, err := s.repository.FindMember(ctx, found.OrganizationID, found.UserID)
+
+		if err != nil {
+			if errors.Is(err, key.ErrNotFound) {
+				return nil, key.ErrInvalidToken
+			}
+
+			return nil, fmt.Errorf("service.ResolveAPIKey failed fetching membership %w", err)
+		}
+
+		claims[sharedhttp.OrgClaim] = member.OrganizationID
+		claims[sharedhttp.OrgRoleClaim] = member.Role
+	}
+
+	return claims, nil
+}
+
+func (s *service) Discovery(ctx context.Context) oidc.Configuration {
+
+	return oidc.NewConfiguration(s.issuer)
+}
+
+// UserInfo returns the OpenID Connect claims of the user the access token was issued to
+func (s *service) UserInfo(ctx context.Context, accessToken string) (jwt.MapClaims, error) {
+	claims, err := s.VerifyAccessToken(ctx, accessToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	userID, _ := claims["sub"].(string)
+	found, err := s.repository.FindUser(ctx, userID)
+
+	if err != nil {
+		if errors.Is(err, key.ErrUserNotFound) {
+			return nil, key.ErrInvalidToken
+		}
+
+		return nil, fmt.Errorf("service.UserInfo %w", err)
+	}
+
+	return jwt.MapClaims{
+		"sub":                found.ID,
+		"email":              found.Email,
+		"name":               found.Name,
+		"preferred_username": found.Name,
+		"role":               found.Role,
+	}, nil
+}
+
+// IntrospectToken implements RFC 7662 for registered clients, access and refresh tokens are both understood
+// and the hint only decides which kind is tried first
+func (s *service) IntrospectToken(ctx context.Context, clientID, secret, token, hint string) (oidc.Introspection, error) {
+	if _, err := s.authenticateClient(ctx, clientID, secret); err != nil {
+		return oidc.Inactive, fmt.Errorf("service.IntrospectToken %w", err)
+	}
+
+	if token == "" {
+		return oidc.Inactive, fmt.Errorf("service.IntrospectToken missing token %w", key.ErrBadRequest)
+	}
+
+	lookups := []func(context.Context, string) (oidc.Introspection, error){s.introspectAccessToken, s.introspectRefreshToken}
+
+	if hint == oidc.HintRefreshToken {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	for _, lookup := range lookups {
+		result, err := lookup(ctx, token)
+
+		if err != nil {
+			return oidc.Inactive, fmt.Errorf("service.IntrospectToken %w", err)
+		}
+
+		if result.Active {
+			return result, nil
+		}
+	}
+
+	return oidc.Inactive, nil
+}
+
+func (s *service) introspectAccessToken(ctx context.Context, token string) (oidc.Introspection, error) {
+	claims, err := s.parseToken(token)
+
+	if err != nil {
+		return oidc.Inactive, nil
+	}
+
+	if typ, _ := claims["typ"].(string); typ != "" && typ != sharedhttp.TokenTypeService {
+		return oidc.Inactive, nil
+	}
+
+	revoked, err := s.accessTokenRevoked(ctx, claims)
+
+	if err != nil {
+		return oidc.Inactive, err
+	}
+
+	if revoked {
+		return oidc.Inactive, nil
+	}
+
+	result := oidc.Introspection{Active: true, TokenType: "Bearer"}
+	result.Sub, _ = claims["sub"].(string)
+	result.Iss, _ = claims["iss"].(string)
+	result.Jti, _ = claims["jti"].(string)
+	result.Scope, _ = claims["scope"].(string)
+	result.ClientID, _ = claims["azp"].(string)
+	result.Username, _ = claims["email"].(string)
+
+	if actor, ok := sharedhttp.Actor(claims); ok {
+		result.Act = &oidc.Actor{Sub: actor}
+	}
+
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		result.Exp = exp.Unix()
+	}
+
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		result.Iat = iat.Unix()
+	}
+
+	return result, nil
+}
+
+func (s *service) introspectRefreshToken(ctx context.Context, token string) (oidc.Introspection, error) {
+	userID, ttl, err := s.repository.FindRefreshToken(ctx, "refresh:"+token)
+
+	if err != nil {
+		if errors.Is(err, key.ErrExpiredToken) {
+			return oidc.Inactive, nil
+		}
+
+		return oidc.Inactive, err
+	}
+
+	return oidc.Introspection{
+		Active:    true,
+		TokenType: oidc.HintRefreshToken,
+		Sub:       userID,
+		Iss:       s.issuer,
+		Exp:       time.Now().Add(ttl).Unix(),
+	}, nil
+}
+
+// accessTokenRevoked reports whether the user's tokens were revoked after this one was issued, service tokens
+// are never revoked this way. iat only has second precision, so a token signed in the same second as the
+// revocation is kept, otherwise logging in right after a password change would fail
+func (s *service) accessTokenRevoked(ctx context.Context, claims jwt.MapClaims) (bool, error) {
+	if claims["typ"] == sharedhttp.TokenTypeService {
+		return false, nil
+	}
+
+	iat, err := claims.GetIssuedAt()
+
+	if err != nil || iat == nil {
+		return true, nil
+	}
+
+	userID, _ := claims["sub"].(string)
+	revokedAt, err := s.repository.AccessTokensRevokedAt(ctx, userID)
+
+	if err != nil {
+		return false, err
+	}
+
+	return !revokedAt.IsZero() && iat.Unix() < revokedAt.Unix(), nil
+}
+
+// RequestMagicLink mails the user a single use login link. Like ForgotPassword it doesn't tell whether the user
+// exists, only the rate limit, which counts every identifier, is reported
+func (s *service) RequestMagicLink(ctx context.Context, identifier, deviceID string) error {
+	identifier = magiclink.Identifier(identifier)
+
+	if identifier == "" {
+		return fmt.Errorf("service.RequestMagicLink missing identifier %w", key.ErrBadRequest)
+	}
+
+	count, err := s.repository.CountAttempt(ctx, "magic:"+hashToken(identifier), s.magicLink.Window)
+
+	if err != nil {
+		return fmt.Errorf("service.RequestMagicLink %w", err)
+	}
+
+	if count > int64(s.magicLink.MaxRequests) {
+		s.logger.Warn("service.RequestMagicLink rate limited", zap.String("identifier", audit.HashIdentifier(identifier)))
+		return key.ErrTooManyRequests
+	}
+
+	user, err := s.repository.FindUserByCredentials(ctx, identifier)
+
+	if err != nil {
+		s.logger.Warn("service.RequestMagicLink user not found", zap.Error(err))
+		return nil
+	}
+
+	token, err := generateSecureToken()
+
+	if err != nil {
+		s.logger.Error("service.RequestMagicLink failed generating link", zap.Error(err))
+		return nil
+	}
+
+	userAgent, _ := clientFromContext(ctx)
+	link := magiclink.Link{UserID: user.ID, Fingerprint: magiclink.Fingerprint(deviceID, userAgent)}
+
+	if err = s.repository.SaveMagicLink(ctx, hashToken(token), link, s.magicLink.TTL); err != nil {
+		s.logger.Error("service.RequestMagicLink failed saving link", zap.Error(err))
+		return nil
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Your sign in link",
+		Body:    fmt.Sprintf("Use the following link to sign in: %s\nThe link works once and expires in %d minutes.", s.magicLink.Link(token), int(s.magicLink.TTL.Minutes())),
+	}
+
+	if err = s.mailer.Send(ctx, msg); err != nil {
+		s.logger.Error("service.RequestMagicLink failed sending link", zap.Error(err))
+	}
+
+	return nil
+}
+
+// ConsumeMagicLink exchanges a link for the tokens Login returns. The device is checked before the link is used up,
+// so a link opened on the wrong device still works on the right one
+func (s *service) ConsumeMagicLink(ctx context.Context, token, deviceID string) (*key.Token, error) {
+	if token == "" {
+		return nil, key.ErrInvalidToken
+	}
+
+	tokenHash := hashToken(token)
+
+	if s.magicLink.BindDevice {
+		link, err := s.repository.FindMagicLink(ctx, tokenHash)
+
+		if err != nil {
+			if errors.Is(err, key.ErrInvalidToken) {
+				return nil, key.ErrInvalidToken
+			}
+
+			return nil, fmt.Errorf("service.ConsumeMagicLink %w", err)
+		}
+
+		userAgent, _ := clientFromContext(ctx)
+
+		if link.Fingerprint != magiclink.Fingerprint(deviceID, userAgent) {
+			s.logger.Warn("service.ConsumeMagicLink link opened on another device", zap.String("user", link.UserID))
+			s.record(ctx, audit.Event{Type: audit.TypeLogin, Outcome: audit.OutcomeFailure, SubjectID: link.UserID, Reason: audit.ReasonOtherDevice, Details: map[string]string{"method": "magic_link"}})
+			return nil, key.ErrInvalidToken
+		}
+	}
+
+	link, err := s.repository.ConsumeMagicLink(ctx, tokenHash)
+
+	if err != nil {
+		if errors.Is(err, key.ErrInvalidToken) {
+			return nil, key.ErrInvalidToken
+		}
+
+		return nil, fmt.Errorf("service.ConsumeMagicLink %w", err)
+	}
+
+	userID := link.UserID
+	found, err := s.repository.FindUser(ctx, userID)
+
+	if err != nil {
+		if errors.Is(err, key.ErrUserNotFound) {
+			return nil, key.ErrInvalidToken
+		}
+
+		return nil, fmt.Errorf("service.ConsumeMagicLink %w", err)
+	}
+
+	loginToken, err := s.completeLogin(ctx, *found)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.ConsumeMagicLink %w", err)
+	}
+
+	s.recordLogin(ctx, found.ID, "magic_link", loginToken)
+
+	return loginToken, nil
+}
+
+// StartFederatedLogin returns the provider URL the user is redirected to, the PKCE verifier and the nonce stay here
+func (s *service) StartFederatedLogin(ctx context.Context, providerName string) (string, error) {
+	provider, err := s.providers.Get(providerName)
+
+	if err != nil {
+		return "", fmt.Errorf("service.StartFederatedLogin %s %w", providerName, key.ErrNotFound)
+	}
+
+	state := federation.State{Provider: provider.Name}
+	id, err := federation.RandomString()
+
+	if err == nil {
+		state.Nonce, err = federation.RandomString()
+	}
+
+	if err == nil {
+		state.Verifier, err = federation.RandomString()
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("service.StartFederatedLogin %w", err)
+	}
+
+	if err = s.repository.SaveFederationState(ctx, id, state, federationStateTTL); err != nil {
+		return "", fmt.Errorf("service.StartFederatedLogin %w", err)
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, id, state.Nonce, federation.Challenge(state.Verifier))
+
+	if err != nil {
+		return "", fmt.Errorf("service.StartFederatedLogin %w", err)
+	}
+
+	return authURL, nil
+}
+
+// CompleteFederatedLogin handles the provider's callback and signs the user in with our own tokens.
+// An identity seen for the first time is linked to the local user with the same email when the provider verified
+// that email, otherwise a new user without a password is created
+func (s *service) CompleteFederatedLogin(ctx context.Context, providerName, code, stateID string) (*key.Token, error) {
+	state, err := s.repository.ConsumeFederationState(ctx, stateID)
+
+	if err != nil {
+		if errors.Is(err, key.ErrInvalidToken) {
+			return nil, key.ErrInvalidToken
+		}
+
+		return nil, fmt.Errorf("service.CompleteFederatedLogin %w", err)
+	}
+
+	// the state is bound to the provider the login started with
+	if state.Provider != providerName {
+		return nil, key.ErrInvalidToken
+	}
+
+	provider, err := s.providers.Get(providerName)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.CompleteFederatedLogin %s %w", providerName, key.ErrNotFound)
+	}
+
+	identity, err := provider.Exchange(ctx, code, state.Verifier, state.Nonce)
+
+	if err != nil {
+		s.logger.Warn("service.CompleteFederatedLogin rejected", zap.String("provider", providerName), zap.Error(err))
+		s.record(ctx, audit.Event{Type: audit.TypeLogin, Outcome: audit.OutcomeFailure, Reason: audit.ReasonProviderRejected, Details: map[string]string{"method": "federated", "provider": providerName}})
+		return nil, key.ErrInvalidCredentials
+	}
+
+	found, err := s.federatedUser(ctx, *identity)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.CompleteFederatedLogin %w", err)
+	}
+
+	token, err := s.completeLogin(ctx, *found)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.CompleteFederatedLogin %w", err)
+	}
+
+	s.record(ctx, audit.Event{Type: audit.TypeLogin, Outcome: loginOutcome(token), ActorID: found.ID, SubjectID: found.ID, Details: map[string]string{"method": "federated", "provider": providerName}})
+
+	return token, nil
+}
+
+// federatedUser finds, links or creates the local user of an external identity
+func (s *service) federatedUser(ctx context.Context, identity federation.Identity) (*user.User, error) {
+	userID, err := s.repository.FindIdentity(ctx, identity.Provider, identity.Subject)
+
+	if err == nil {
+		return s.repository.FindUser(ctx, userID)
+	}
+
+	if !errors.Is(err, key.ErrNotFound) {
+		return nil, err
+	}
+
+	if identity.Email == "" || !validateEmail(identity.Email) {
+		return nil, fmt.Errorf("provider %s shared no email %w", identity.Provider, key.ErrBadRequest)
+	}
+
+	existing, err := s.repository.FindUserByCredentials(ctx, identity.Email)
+
+	if err != nil && !errors.Is(err, key.ErrUserNotFound) {
+		return nil, err
+	}
+
+	if err == nil && strings.EqualFold(existing.Email, identity.Email) {
+		// an unverified email could belong to anyone, linking it would hand them the account
+		if !identity.EmailVerified {
+			return nil, fmt.Errorf("email %s is taken and the provider didn't verify it %w", identity.Email, key.ErrAlreadyExists)
+		}
+
+		if err = s.repository.CreateIdentity(ctx, existing.ID, identity); err != nil {
+			return nil, err
+		}
+
+		s.logger.Info("service.federatedUser linked identity", zap.String("user", existing.ID), zap.String("provider", identity.Provider))
+
+		return existing, nil
+	}
+
+	created := user.User{
+		ID:    generateID(),
+		Name:  identity.Email,
+		Email: identity.Email,
+		Role:  user.RoleUser,
+	}
+
+	registered, err := userRegistered(ctx, created, events.RegistrationFederated)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.repository.CreateUserWithIdentity(ctx, created, identity, registered); err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+// Impersonate issues a short lived access token for the user that names the admin in its act claim.
+// There is no refresh token, the admin asks again once it expires
+func (s *service) Impersonate(ctx context.Context, adminID, userID string) (string, error) {
+	if adminID == userID {
+		return "", fmt.Errorf("service.Impersonate can't impersonate yourself %w", key.ErrBadRequest)
+	}
+
+	target, err := s.repository.FindUser(ctx, userID)
+
+	if err != nil {
+		if errors.Is(err, key.ErrUserNotFound) {
+			return "", key.ErrNotFound
+		}
+
+		return "", fmt.Errorf("service.Impersonate %w", err)
+	}
+
+	// an admin acting as another admin would get around the audit of who did what
+	if target.Role == user.RoleAdmin {
+		return "", fmt.Errorf("service.Impersonate can't impersonate an admin %w", key.ErrForbidden)
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":                 target.ID,
+		"email":               target.Email,
+		"role":                target.Role,
+		"jti":                 generateID(),
+		"exp":                 now.Add(impersonationTTL).Unix(),
+		"iat":                 now.Unix(),
+		sharedhttp.ActorClaim: map[string]interface{}{"sub": adminID},
+	}
+
+	if s.issuer != "" {
+		claims["iss"] = s.issuer
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(s.privateKey)
+
+	if err != nil {
+		return "", fmt.Errorf("service.Impersonate %w", err)
+	}
+
+	s.logger.Info("service.Impersonate impersonation started", zap.String("admin", adminID), zap.String("user", userID), zap.Any("jti", claims["jti"]))
+	s.record(ctx, audit.Event{Type: audit.TypeImpersonation, Outcome: audit.OutcomeSuccess, ActorID: adminID, SubjectID: userID,
+		Details: map[string]string{"jti": claims["jti"].(string)}})
+
+	return token, nil
+}
+
+// RecordImpersonation audits every request made with an impersonation token, to auth-service or, through
+// their outbox, to the other services
+func (s *service) RecordImpersonation(ctx context.Context, entry sharedhttp.ImpersonationEntry) {
+	event := audit.Event{Type: audit.TypeImpersonation, Outcome: audit.OutcomeSuccess, ActorID: entry.Actor, SubjectID: entry.Subject,
+		Details: map[string]string{"method": entry.Method, "path": entry.Path}}
+
+	if entry.Service != "" {
+		event.Details["service"] = entry.Service
+	}
+
+	if !entry.At.IsZero() {
+		event.Details["at"] = entry.At.UTC().Format(time.RFC3339Nano)
+	}
+
+	if entry.Status != 0 {
+		event.Details["status"] = strconv.Itoa(entry.Status)
+	}
+
+	if entry.Error != "" || entry.Status >= http.StatusBadRequest {
+		event.Outcome, event.Reason = audit.OutcomeFailure, audit.ReasonRequestFailed
+	}
+
+	if entry.Error != "" {
+		event.Details["error"] = entry.Error
+	}
+
+	s.record(ctx, event)
+}
+
+func (s *service) CreateOrganization(ctx context.Context, userID, name string) (*organization.Organization, error) {
+	name = strings.TrimSpace(name)
+
+	if name == "" {
+		return nil, fmt.Errorf("service.CreateOrganization name is required %w", key.ErrBadRequest)
+	}
+
+	org := organization.Organization{ID: generateID(), Name: name, CreatedAt: time.Now().UTC()}
+	owner := organization.Member{OrganizationID: org.ID, UserID: userID, Role: organization.RoleOwner, CreatedAt: org.CreatedAt}
+
+	if err := s.repository.CreateOrganization(ctx, org, owner); err != nil {
+		return nil, fmt.Errorf("service.CreateOrganization %w", err)
+	}
+
+	return &org, nil
+}
+
+func (s *service) ListOrganizations(ctx context.Context, userID string) ([]organization.Membership, error) {
+	memberships, err := s.repository.ListOrganizations(ctx, userID)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.ListOrganizations %w", err)
+	}
+
+	return memberships, nil
+}
+
+// member returns the user's membership, organizations the user isn't part of are reported as not found
+func (s *service) member(ctx context.Context, orgID, userID string) (*organization.Member, error) {
+	member, err := s.repository.FindMember(ctx, orgID, userID)
+
+	if err != nil {
+		if errors.Is(err, key.ErrNotFound) {
+			return nil, key.ErrNotFound
+		}
+
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// owner returns the user's membership when the user may manage the members of the organization
+func (s *service) owner(ctx context.Context, orgID, userID string) (*organization.Member, error) {
+	member, err := s.member(ctx, orgID, userID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !member.CanManageMembers() {
+		return nil, fmt.Errorf("only owners manage members %w", key.ErrForbidden)
+	}
+
+	return member, nil
+}
+
+func (s *service) ListMembers(ctx context.Context, userID, orgID string) ([]organization.Member, error) {
+	if _, err := s.member(ctx, orgID, userID); err != nil {
+		return nil, fmt.Errorf("service.ListMembers %w", err)
+	}
+
+	members, err := s.repository.ListMembers(ctx, orgID)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.ListMembers %w", err)
+	}
+
+	return members, nil
+}
+
+// InviteMember mails an accept link to the address, the invitation can only be accepted by the account with that email
+func (s *service) InviteMember(ctx context.Context, userID, orgID, email, role string) (*organization.Invitation, error) {
+	email = organization.Email(email)
+
+	if !validateEmail(email) {
+		return nil, key.ErrInvalidEmail
+	}
+
+	if !organization.ValidRole(role) {
+		return nil, fmt.Errorf("service.InviteMember unknown role %q %w", role, key.ErrBadRequest)
+	}
+
+	if _, err := s.owner(ctx, orgID, userID); err != nil {
+		return nil, fmt.Errorf("service.InviteMember %w", err)
+	}
+
+	token, err := generateSecureToken()
+
+	if err != nil {
+		return nil, fmt.Errorf("service.InviteMember %w", err)
+	}
+
+	now := time.Now().UTC()
+	invitation := organization.Invitation{
+		ID:             generateID(),
+		OrganizationID: orgID,
+		Email:          email,
+		Role:           role,
+		TokenHash:      hashToken(token),
+		InvitedBy:      userID,
+		ExpiresAt:      now.Add(organization.InvitationTTL),
+		CreatedAt:      now,
+	}
+
+	if err = s.repository.CreateInvitation(ctx, invitation); err != nil {
+		return nil, fmt.Errorf("service.InviteMember %w", err)
+	}
+
+	msg := mailer.Message{
+		To:      email,
+		Subject: "You were invited to join a seller team",
+		Body: fmt.Sprintf("Open the following link to join as %s: %s\nThe invitation expires in %d days.",
+			role, organization.InvitationLink(s.invitationURL, token), int(organization.InvitationTTL.Hours()/24)),
+	}
+
+	if err = s.mailer.Send(ctx, msg); err != nil {
+		return nil, fmt.Errorf("service.InviteMember failed sending invitation %w", err)
+	}
+
+	return &invitation, nil
+}
+
+// AcceptInvitation adds the signed in user to the organization when the invitation was sent to the user's email
+func (s *service) AcceptInvitation(ctx context.Context, userID, token string) (*organization.Member, error) {
+	invitation, err := s.repository.FindInvitation(ctx, hashToken(token))
+
+	if err != nil {
+		if errors.Is(err, key.ErrInvalidToken) {
+			return nil, key.ErrInvalidToken
+		}
+
+		return nil, fmt.Errorf("service.AcceptInvitation %w", err)
+	}
+
+	if invitation.AcceptedAt != nil {
+		return nil, key.ErrInvalidToken
+	}
+
+	if invitation.Expired(time.Now()) {
+		return nil, key.ErrExpiredToken
+	}
+
+	invited, err := s.repository.FindUser(ctx, userID)
+
+	if err != nil {
+		return nil, fmt.Errorf("service.AcceptInvitation %w", err)
+	}
+
+	// a forwarded link must not let someone else in
+	if organization.Email(invited.Email) != invitation.Email {
+		return nil, fmt.Errorf("service.AcceptInvitation invitation is for another email %w", key.ErrForbidden)
+	}
+
+	member := organization.Member{
+		OrganizationID: invitation.OrganizationID,
+		UserID:         userID,
+		Email:          invited.Email,
+		Role:           invitation.Role,
+		CreatedAt:      time.Now().UTC(),
+	}
+
+	if err = s.repository.AcceptInvitation(ctx, invitation.ID, member); err != nil {
+		if errors.Is(err, key.ErrInvalidToken) || errors.Is(err, key.ErrAlreadyExists) {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("service.AcceptInvitation %w", err)
+	}
+
+	return &member, nil
+}
+
+// keepOwner refuses to take the owner role away from the last owner, nobody could manage the members anymore
+func (s *service) keepOwner(ctx context.Context, member *organization.Member) error {
+	if member.Role != organization.RoleOwner {
+		return nil
+	}
+
+	owners, err := s.repository.CountOwners(ctx, member.OrganizationID)
+
+	if err != nil {
+		return err
+	}
+
+	if owners <= 1 {
+		return fmt.Errorf("an organization needs an owner %w", key.ErrBadRequest)
+	}
+
+	return nil
+}
+
+func (s *service) UpdateMemberRole(ctx context.Context, userID, orgID, memberID, role string) error {
+	if !organization.ValidRole(role) {
+		return fmt.Errorf("service.UpdateMemberRole unknown role %q %w", role, key.ErrBadRequest)
+	}
+
+	if _, err := s.owner(ctx, orgID, userID); err != nil {
+		return fmt.Errorf("service.UpdateMemberRole %w", err)
+	}
+
+	member, err := s.member(ctx, orgID, memberID)
+
+	if err != nil {
+		return fmt.Errorf("service.UpdateMemberRole %w", err)
+	}
+
+	if role != organization.RoleOwner {
+		if err = s.keepOwner(ctx, member); err != nil {
+			return fmt.Errorf("service.UpdateMemberRole %w", err)
+		}
+	}
+
+	previous := member.Role
+
+	if err = s.repository.UpdateMemberRole(ctx, orgID, memberID, role); err != nil {
+		return fmt.Errorf("service.UpdateMemberRole %w", err)
+	}
+
+	s.record(ctx, audit.Event{Type: audit.TypeRoleChange, Outcome: audit.OutcomeSuccess, ActorID: userID, SubjectID: memberID,
+		Details: map[string]string{"organization": orgID, "role": role, "previousRole": previous}})
+
+	return nil
+}
+
+// RemoveMember is for owners, and for members leaving the organization themselves.
+// Tokens already issued for the organization stay valid until they expire
+func (s *service) RemoveMember(ctx context.Context, userID, orgID, memberID string) error {
+	if userID != memberID {
+		if _, err := s.owner(ctx, orgID, userID); err != nil {
+			return fmt.Errorf("service.RemoveMember %w", err)
+		}
+	}
+
+	member, err := s.member(ctx, orgID, memberID)
+
+	if err != nil {
+		return fmt.Errorf("service.RemoveMember %w", err)
+	}
+
+	if err = s.keepOwner(ctx, member); err != nil {
+		return fmt.Errorf("service.RemoveMember %w", err)
+	}
+
+	if err = s.repository.DeleteMember(ctx, orgID, memberID); err != nil {
+		return fmt.Errorf("service.RemoveMember %w", err)
+	}
+
+	return nil
+}
+
+// SwitchOrganization issues an access token acting for the organization. Refreshed tokens are not scoped,
+// clients switch again after refreshing
+func (s *service) SwitchOrganization(ctx context.Context, userID, orgID string) (string, error) {
+	member, err := s.member(ctx, orgID, userID)
+
+	if err != nil {
+		return "", fmt.Errorf("service.SwitchOrganization %w", err)
+	}
+
+	u, err := s.repository.FindUser(ctx, userID)
+
+	if err != nil {
+		return "", fmt.Errorf("service.SwitchOrganization %w", err)
+	}
+
+	claims := s.accessClaims(*u)
+	claims[sharedhttp.OrgClaim] = member.OrganizationID
+	claims[sharedhttp.OrgRoleClaim] = member.Role
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(s.privateKey)
+
+	if err != nil {
+		return "", fmt.Errorf("service.SwitchOrganization %w", err)
+	}
+
+	return token, nil
+}
+
+func (s *service) ListAuditEvents(ctx context.Context, filter audit.Filter) ([]audit.Event, error) {
+	events, err := s.repository.ListAuditEvents(ctx, filter.WithLimit())
+
+	if err != nil {
+		return nil, fmt.Errorf("service.ListAuditEvents %w", err)
+	}
+
+	return events, nil
+}
+
+// ExportAuditEvents streams every event of the filter, unlike the listing the export isn't paged
+func (s *service) ExportAuditEvents(ctx context.Context, filter audit.Filter, fn func(audit.Event) error) error {
+
+	if err := s.repository.ExportAuditEvents(ctx, filter, fn); err != nil {
+		return fmt.Errorf("service.ExportAuditEvents %w", err)
 	}
 
-	return string(hashedPassword), nil
+	return nil
 }
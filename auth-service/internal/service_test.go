@@ -1,15 +1,54 @@
 package internal
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ireuven89/auctions/auth-service/apikey"
+	"github.com/ireuven89/auctions/auth-service/audit"
+	"github.com/ireuven89/auctions/auth-service/federation"
+	"github.com/ireuven89/auctions/auth-service/federation/oidctest"
 	"github.com/ireuven89/auctions/auth-service/internal/mocks"
+	"github.com/ireuven89/auctions/auth-service/key"
+	"github.com/ireuven89/auctions/auth-service/magiclink"
+	"github.com/ireuven89/auctions/auth-service/oauth"
+	"github.com/ireuven89/auctions/auth-service/oidc"
+	"github.com/ireuven89/auctions/auth-service/organization"
+	"github.com/ireuven89/auctions/auth-service/password"
+	"github.com/ireuven89/auctions/auth-service/totp"
+	"github.com/ireuven89/auctions/auth-service/user"
+	"github.com/ireuven89/auctions/shared/config"
+	"github.com/ireuven89/auctions/shared/encryption"
+	"github.com/ireuven89/auctions/shared/events"
+	sharedhttp "github.com/ireuven89/auctions/shared/http"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/ireuven89/auctions/shared/messaging/outbox"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// testHasher keeps the argon2id cost low so the tests stay fast
+var testHasher = password.NewHasher(password.NewArgon2id(password.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}), password.NewBcrypt(bcrypt.MinCost))
+
+func verifyTestPassword(encoded, pw string) bool {
+	ok, _, _ := testHasher.Verify(encoded, pw)
+
+	return ok
+}
+
 func setupTestKeys(t *testing.T) (privPath, pubPath string) {
 	t.Helper()
 	privPath = filepath.Join("testdata", "test_private.pem")
@@ -30,7 +69,7 @@ func setupTestKeys(t *testing.T) (privPath, pubPath string) {
 	logger := zap.NewNop()
 	repo := &mocks.MockRepo{}
 
-	svc, err := NewAuthService(logger, repo, "ignored")
+	svc, err := NewAuthService(logger, repo, "ignored", &mocks.MockMailer{}, nil, testHasher, password.Policy{}, nil)
 	assert.NoError(t, err)
 	assert.NotNil(t, svc)
 }
@@ -40,7 +79,7 @@ func TestNewAuthService_PrivateKeyFail(t *testing.T) {
 	os.Setenv("JWT_PUBLIC_KEY_PATH", "nonexistent_pub.pem")
 	logger := zap.NewNop()
 	repo := &mocks.MockRepo{}
-	svc, err := NewAuthService(logger, repo, "ignored")
+	svc, err := NewAuthService(logger, repo, "ignored", &mocks.MockMailer{}, nil, testHasher, password.Policy{}, nil, "", magiclink.Options{}, nil)
 	assert.Error(t, err)
 	assert.Nil(t, svc)
 }*/
@@ -54,7 +93,7 @@ func TestNewAuthService_PublicKeyFail(t *testing.T) {
 	assert.NoError(t, os.WriteFile(tmpPriv, []byte("BAD DATA"), 0600))
 	logger := zap.NewNop()
 	repo := &mocks.MockRepo{}
-	svc, err := NewAuthService(logger, repo, "ignored")
+	svc, err := NewAuthService(logger, repo, "ignored", &mocks.MockMailer{}, nil, testHasher, password.Policy{}, nil, "", magiclink.Options{}, nil, "")
 	assert.Error(t, err)
 	assert.Nil(t, svc)
 }
@@ -66,12 +105,12 @@ func TestService_Register_Success(t *testing.T) {
 
 	logger := zap.NewNop()
 	repo := &mocks.MockRepo{
-		CreateUserFunc:       func(ctx context.Context, user user.User) error { return nil },
-		SaveRefreshTokenFunc: func(ctx context.Context, key, userId string, ttl time.Duration) error { return nil },
+		CreateUserFunc:       func(ctx context.Context, user user.User, events ...outbox.Event) error { return nil },
+		SaveRefreshTokenFunc: func(ctx context.Context, key string, session user.Session, ttl time.Duration) error { return nil },
 	}
 
 	// Example: If NewAuthService takes key path as param
-	svc, err := NewAuthService(logger, repo, "")
+	svc, err := NewAuthService(logger, repo, "", &mocks.MockMailer{}, nil, testHasher, password.Policy{}, nil)
 	assert.NoError(t, err)
 	_, _, err = svc.Register(context.Background(), user.User{Email: "foo@bar.com", Password: "pass"})
 	assert.NoError(t, err)
@@ -82,9 +121,9 @@ func TestService_Register_Success(t *testing.T) {
 	setupTestKeys(t)
 	logger := zap.NewNop()
 	repo := &mocks.MockRepo{
-		CreateUserFunc: func(ctx context.Context, user user.User) error { return errors.New("fail create") },
+		CreateUserFunc: func(ctx context.Context, user user.User, events ...outbox.Event) error { return errors.New("fail create") },
 	}
-	svc, err := NewAuthService(logger, repo, "ignored")
+	svc, err := NewAuthService(logger, repo, "ignored", &mocks.MockMailer{}, nil, testHasher, password.Policy{}, nil)
 	assert.NoError(t, err)
 	_, _, err = svc.Register(context.Background(), user.User{Email: "foo@bar.com", Password: "pass"})
 	assert.Error(t, err)
@@ -97,7 +136,7 @@ func TestService_RefreshToken_TokenNotFound(t *testing.T) {
 	repo := &mocks.MockRepo{
 		GetTokenFunc: func(ctx context.Context, key string) (string, error) { return "", errors.New("not found") },
 	}
-	svc, err := NewAuthService(logger, repo, "ignored")
+	svc, err := NewAuthService(logger, repo, "ignored", &mocks.MockMailer{}, nil, testHasher, password.Policy{}, nil)
 	assert.NoError(t, err)
 	_, err = svc.RefreshToken(context.Background(), "badtoken")
 	assert.Error(t, err)
@@ -135,3 +174,1726 @@ func TestRegex(t *testing.T) {
 		assert.Equal(t, test.valid, validateEmail(test.pattern))
 	}
 }
+
+// countAttempts counts the attempts of every bucket in memory
+func countAttempts(repo *mocks.MockRepo) {
+	attempts := map[string]int64{}
+	repo.CountAttemptFunc = func(ctx context.Context, bucket string, window time.Duration) (int64, error) {
+		attempts[bucket]++
+		return attempts[bucket], nil
+	}
+}
+
+func TestService_ForgotPassword_SendsToken(t *testing.T) {
+	var savedHash string
+	mail := &mocks.MockMailer{}
+	repo := &mocks.MockRepo{
+		FindUserByCredentialsFunc: func(ctx context.Context, identifier string) (*user.User, error) {
+			return &user.User{ID: "user-id", Email: "foo@bar.com"}, nil
+		},
+		SaveResetTokenFunc: func(ctx context.Context, tokenHash, userID string, ttl time.Duration) error {
+			savedHash = tokenHash
+			assert.Equal(t, "user-id", userID)
+			assert.Equal(t, resetTokenTTL, ttl)
+			return nil
+		},
+	}
+	countAttempts(repo)
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher, mailer: mail}
+
+	err := svc.ForgotPassword(context.Background(), "foo@bar.com")
+	svc.mails.Wait()
+
+	assert.NoError(t, err)
+	assert.Len(t, mail.Sent, 1)
+	assert.Equal(t, "foo@bar.com", mail.Sent[0].To)
+	// only the hash is stored, the token itself is only in the email
+	assert.NotContains(t, mail.Sent[0].Body, savedHash)
+	token := strings.TrimPrefix(strings.Split(mail.Sent[0].Body, "\n")[0], "Use the following token to reset your password: ")
+	assert.Equal(t, savedHash, hashToken(token))
+}
+
+func TestService_ForgotPassword_UnknownUser(t *testing.T) {
+	var saved bool
+	mail := &mocks.MockMailer{}
+	repo := &mocks.MockRepo{
+		FindUserByCredentialsFunc: func(ctx context.Context, identifier string) (*user.User, error) {
+			return nil, errors.New("no rows")
+		},
+		// a token is stored all the same, so the request costs what it costs for a user
+		SaveResetTokenFunc: func(ctx context.Context, tokenHash, userID string, ttl time.Duration) error {
+			saved = true
+			assert.Empty(t, userID)
+			assert.Equal(t, resetTokenTTL, ttl)
+			return nil
+		},
+	}
+	countAttempts(repo)
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher, mailer: mail}
+
+	err := svc.ForgotPassword(context.Background(), "nobody@bar.com")
+	svc.mails.Wait()
+
+	assert.NoError(t, err)
+	assert.True(t, saved)
+	assert.Empty(t, mail.Sent)
+}
+
+func TestService_ForgotPassword_RateLimited(t *testing.T) {
+	repo := &mocks.MockRepo{
+		FindUserByCredentialsFunc: func(ctx context.Context, identifier string) (*user.User, error) {
+			return nil, errors.New("no rows")
+		},
+		SaveResetTokenFunc: func(ctx context.Context, tokenHash, userID string, ttl time.Duration) error { return nil },
+	}
+	countAttempts(repo)
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher, mailer: &mocks.MockMailer{}}
+
+	// per identifier, however it is spelled
+	for i := 0; i < forgotPasswordMaxRequests; i++ {
+		assert.NoError(t, svc.ForgotPassword(context.Background(), "foo@bar.com"))
+	}
+	assert.ErrorIs(t, svc.ForgotPassword(context.Background(), " Foo@Bar.com"), key.ErrTooManyRequests)
+
+	// per address, whatever the identifier
+	ctx := context.WithValue(context.Background(), clientIPContextKey, "10.0.0.1")
+	for i := 0; i < forgotPasswordMaxPerIP; i++ {
+		assert.NoError(t, svc.ForgotPassword(ctx, fmt.Sprintf("user%d@bar.com", i)))
+	}
+	assert.ErrorIs(t, svc.ForgotPassword(ctx, "other@bar.com"), key.ErrTooManyRequests)
+}
+
+func TestService_ResetPassword_Success(t *testing.T) {
+	var revoked, revokedAccess, updatedPassword string
+	repo := &mocks.MockRepo{
+		ConsumeResetTokenFunc: func(ctx context.Context, tokenHash string) (string, time.Duration, error) {
+			assert.Equal(t, hashToken("token"), tokenHash)
+			return "user-id", resetTokenTTL, nil
+		},
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return &user.User{ID: id, Name: "foo", Email: "foo@bar.com"}, nil
+		},
+		UpdatePasswordFunc: func(ctx context.Context, id, password string) error {
+			updatedPassword = password
+			return nil
+		},
+		RevokeRefreshTokensFunc: func(ctx context.Context, userID string) error {
+			revoked = userID
+			return nil
+		},
+		RevokeAccessTokensFunc: func(ctx context.Context, userID string, at time.Time, ttl time.Duration) error {
+			revokedAccess = userID
+			assert.Equal(t, accessTokenTTL, ttl)
+			return nil
+		},
+	}
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher}
+
+	err := svc.ResetPassword(context.Background(), "token", "new-password")
+
+	assert.NoError(t, err)
+	assert.True(t, verifyTestPassword(updatedPassword, "new-password"))
+	assert.Equal(t, "user-id", revoked)
+	assert.Equal(t, "user-id", revokedAccess)
+}
+
+func TestService_ResetPassword_InvalidToken(t *testing.T) {
+	repo := &mocks.MockRepo{
+		ConsumeResetTokenFunc: func(ctx context.Context, tokenHash string) (string, time.Duration, error) {
+			return "", 0, key.ErrInvalidToken
+		},
+	}
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher}
+
+	err := svc.ResetPassword(context.Background(), "used-token", "new-password")
+
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+func TestService_ResetPassword_EmptyPassword(t *testing.T) {
+	svc := &service{logger: zap.NewNop(), repository: &mocks.MockRepo{}, hasher: testHasher}
+
+	err := svc.ResetPassword(context.Background(), "token", "")
+
+	assert.ErrorIs(t, err, key.ErrInvalidPassword)
+}
+
+// newTestService builds a service with a freshly generated signing key, the keys in testdata are not parseable
+func newTestService(t *testing.T, repo *mocks.MockRepo) *service {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	encrypter, err := encryption.NewAESEncrypter(bytes.Repeat([]byte{7}, 32))
+	assert.NoError(t, err)
+
+	return &service{logger: zap.NewNop(), repository: repo, hasher: testHasher, privateKey: privateKey, encrypter: encrypter, mailer: &mocks.MockMailer{}}
+}
+
+func enabledMFA(t *testing.T, svc *service, secret string) *user.MFA {
+	t.Helper()
+	encrypted, err := svc.encrypter.Encrypt([]byte(secret))
+	assert.NoError(t, err)
+
+	return &user.MFA{UserID: "user-id", Secret: encrypted, Enabled: true}
+}
+
+// withMFAState keeps the attempts and the last accepted step of the two-factor challenge in memory
+func withMFAState(repo *mocks.MockRepo) {
+	attempts := map[string]int64{}
+	steps := map[string]int64{}
+	repo.CountAttemptFunc = func(ctx context.Context, bucket string, window time.Duration) (int64, error) {
+		attempts[bucket]++
+		return attempts[bucket], nil
+	}
+	repo.ResetAttemptsFunc = func(ctx context.Context, bucket string) error {
+		delete(attempts, bucket)
+		return nil
+	}
+	repo.UseTOTPStepFunc = func(ctx context.Context, userID string, step int64) error {
+		if last, ok := steps[userID]; ok && last >= step {
+			return key.ErrInvalidMFACode
+		}
+		steps[userID] = step
+		return nil
+	}
+}
+
+func TestService_Login_MFARequired(t *testing.T) {
+	hashed, _ := testHasher.Hash("pass")
+	repo := &mocks.MockRepo{
+		FindUserByCredentialsFunc: func(ctx context.Context, identifier string) (*user.User, error) {
+			return &user.User{ID: "user-id", Password: hashed}, nil
+		},
+	}
+	svc := newTestService(t, repo)
+	secret, _ := totp.GenerateSecret()
+	repo.FindMFAFunc = func(ctx context.Context, userID string) (*user.MFA, error) {
+		return enabledMFA(t, svc, secret), nil
+	}
+
+	token, err := svc.Login(context.Background(), "foo", "pass")
+
+	assert.NoError(t, err)
+	assert.Empty(t, token.Access)
+	assert.Empty(t, token.Refresh)
+	assert.NotEmpty(t, token.MFAPending)
+
+	// the pending token must not be usable as an access token
+	_, err = svc.VerifyAccessToken(context.Background(), token.MFAPending)
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+func TestService_VerifyMFA_TOTPCode(t *testing.T) {
+	repo := &mocks.MockRepo{
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return &user.User{ID: id}, nil
+		},
+		SaveRefreshTokenFunc: func(ctx context.Context, token string, session user.Session, ttl time.Duration) error { return nil },
+	}
+	withMFAState(repo)
+	svc := newTestService(t, repo)
+	secret, _ := totp.GenerateSecret()
+	repo.FindMFAFunc = func(ctx context.Context, userID string) (*user.MFA, error) {
+		return enabledMFA(t, svc, secret), nil
+	}
+	pending, err := svc.signMFAPendingToken("user-id")
+	assert.NoError(t, err)
+	code, _ := totp.GenerateCode(secret, time.Now())
+
+	token, err := svc.VerifyMFA(context.Background(), pending, code)
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.Access)
+	assert.NotEmpty(t, token.Refresh)
+
+	// the code is still in its window but was used already
+	_, err = svc.VerifyMFA(context.Background(), pending, code)
+
+	assert.ErrorIs(t, err, key.ErrInvalidMFACode)
+}
+
+func TestService_VerifyMFA_RecoveryCode(t *testing.T) {
+	var usedHash string
+	repo := &mocks.MockRepo{
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return &user.User{ID: id}, nil
+		},
+		SaveRefreshTokenFunc: func(ctx context.Context, token string, session user.Session, ttl time.Duration) error { return nil },
+		UseRecoveryCodeFunc: func(ctx context.Context, userID, codeHash string) error {
+			usedHash = codeHash
+			return nil
+		},
+	}
+	withMFAState(repo)
+	svc := newTestService(t, repo)
+	secret, _ := totp.GenerateSecret()
+	repo.FindMFAFunc = func(ctx context.Context, userID string) (*user.MFA, error) {
+		return enabledMFA(t, svc, secret), nil
+	}
+	pending, _ := svc.signMFAPendingToken("user-id")
+
+	_, err := svc.VerifyMFA(context.Background(), pending, "ABCDEFGH-ijklmnop")
+
+	assert.NoError(t, err)
+	assert.Equal(t, hashToken("abcdefghijklmnop"), usedHash)
+}
+
+func TestService_VerifyMFA_InvalidCode(t *testing.T) {
+	repo := &mocks.MockRepo{}
+	withMFAState(repo)
+	svc := newTestService(t, repo)
+	secret, _ := totp.GenerateSecret()
+	repo.FindMFAFunc = func(ctx context.Context, userID string) (*user.MFA, error) {
+		return enabledMFA(t, svc, secret), nil
+	}
+	pending, _ := svc.signMFAPendingToken("user-id")
+	code, _ := totp.GenerateCode(secret, time.Now().Add(-10*totp.Period))
+
+	_, err := svc.VerifyMFA(context.Background(), pending, code)
+
+	assert.ErrorIs(t, err, key.ErrInvalidMFACode)
+}
+
+func TestService_VerifyMFA_LockedOut(t *testing.T) {
+	repo := &mocks.MockRepo{
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return &user.User{ID: id}, nil
+		},
+		SaveRefreshTokenFunc: func(ctx context.Context, token string, session user.Session, ttl time.Duration) error { return nil },
+	}
+	withMFAState(repo)
+	svc := newTestService(t, repo)
+	secret, _ := totp.GenerateSecret()
+	repo.FindMFAFunc = func(ctx context.Context, userID string) (*user.MFA, error) {
+		return enabledMFA(t, svc, secret), nil
+	}
+	pending, _ := svc.signMFAPendingToken("user-id")
+	wrong, _ := totp.GenerateCode(secret, time.Now().Add(-10*totp.Period))
+
+	for i := 0; i < mfaMaxFailures; i++ {
+		_, err := svc.VerifyMFA(context.Background(), pending, wrong)
+		assert.ErrorIs(t, err, key.ErrInvalidMFACode)
+	}
+
+	// a fresh pending token doesn't help and even the right code is refused now
+	pending, _ = svc.signMFAPendingToken("user-id")
+	code, _ := totp.GenerateCode(secret, time.Now())
+	_, err := svc.VerifyMFA(context.Background(), pending, code)
+
+	assert.ErrorIs(t, err, key.ErrTooManyRequests)
+}
+
+func TestService_VerifyMFA_RejectsAccessToken(t *testing.T) {
+	svc := newTestService(t, &mocks.MockRepo{})
+	accessToken, err := svc.SignToken(context.Background(), user.User{ID: "user-id"})
+	assert.NoError(t, err)
+
+	_, err = svc.VerifyMFA(context.Background(), accessToken, "123456")
+
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+func TestService_ConfirmMFA(t *testing.T) {
+	var storedHashes []string
+	repo := &mocks.MockRepo{
+		EnableMFAFunc: func(ctx context.Context, userID string, recoveryCodeHashes []string) error {
+			storedHashes = recoveryCodeHashes
+			return nil
+		},
+	}
+	withMFAState(repo)
+	svc := newTestService(t, repo)
+	secret, _ := totp.GenerateSecret()
+	repo.FindMFAFunc = func(ctx context.Context, userID string) (*user.MFA, error) {
+		mfa := enabledMFA(t, svc, secret)
+		mfa.Enabled = false
+		return mfa, nil
+	}
+	code, _ := totp.GenerateCode(secret, time.Now())
+
+	codes, err := svc.ConfirmMFA(context.Background(), "user-id", code)
+
+	assert.NoError(t, err)
+	assert.Len(t, codes, recoveryCodesCount)
+	assert.Len(t, storedHashes, recoveryCodesCount)
+	assert.Equal(t, hashToken(normalizeRecoveryCode(codes[0])), storedHashes[0])
+}
+
+func TestService_DisableMFA_Audited(t *testing.T) {
+	repo := &mocks.MockRepo{
+		DisableMFAFunc: func(ctx context.Context, userID string) error { return nil },
+	}
+	withMFAState(repo)
+	events := auditRepo(repo)
+	svc := newTestService(t, repo)
+	secret, _ := totp.GenerateSecret()
+	repo.FindMFAFunc = func(ctx context.Context, userID string) (*user.MFA, error) {
+		return enabledMFA(t, svc, secret), nil
+	}
+	wrong, _ := totp.GenerateCode(secret, time.Now().Add(-10*totp.Period))
+	code, _ := totp.GenerateCode(secret, time.Now())
+
+	assert.ErrorIs(t, svc.DisableMFA(context.Background(), "user-id", wrong), key.ErrInvalidMFACode)
+	assert.NoError(t, svc.DisableMFA(context.Background(), "user-id", code))
+
+	assert.Len(t, *events, 2)
+	assert.Equal(t, audit.TypeMFADisable, (*events)[0].Type)
+	assert.Equal(t, audit.ReasonInvalidCode, (*events)[0].Reason)
+	assert.Equal(t, audit.OutcomeSuccess, (*events)[1].Outcome)
+	assert.Equal(t, "user-id", (*events)[1].SubjectID)
+}
+
+func TestService_EnrollMFA_AlreadyEnabled(t *testing.T) {
+	repo := &mocks.MockRepo{
+		FindMFAFunc: func(ctx context.Context, userID string) (*user.MFA, error) {
+			return &user.MFA{UserID: userID, Enabled: true}, nil
+		},
+	}
+	svc := newTestService(t, repo)
+
+	_, _, err := svc.EnrollMFA(context.Background(), "user-id")
+
+	assert.ErrorIs(t, err, key.ErrMFAAlreadyEnabled)
+}
+
+func TestService_ChangePassword_WrongCurrentPassword(t *testing.T) {
+	hashed, _ := testHasher.Hash("current")
+	repo := &mocks.MockRepo{
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return &user.User{ID: id, Password: hashed}, nil
+		},
+	}
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher}
+
+	err := svc.ChangePassword(context.Background(), "user-id", "wrong", "new-password")
+
+	assert.ErrorIs(t, err, key.ErrInvalidCredentials)
+}
+
+func TestService_ChangePassword_Success(t *testing.T) {
+	var revoked bool
+	hashed, _ := testHasher.Hash("current")
+	repo := &mocks.MockRepo{
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return &user.User{ID: id, Password: hashed}, nil
+		},
+		UpdatePasswordFunc: func(ctx context.Context, id, password string) error {
+			assert.True(t, verifyTestPassword(password, "new-password"))
+			return nil
+		},
+		RevokeRefreshTokensFunc: func(ctx context.Context, userID string) error {
+			revoked = true
+			return nil
+		},
+		RevokeAccessTokensFunc: func(ctx context.Context, userID string, at time.Time, ttl time.Duration) error {
+			return nil
+		},
+	}
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher}
+
+	err := svc.ChangePassword(context.Background(), "user-id", "current", "new-password")
+
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestService_RequestEmailChange_SendsToNewAddress(t *testing.T) {
+	hashed, _ := testHasher.Hash("pass")
+	mail := &mocks.MockMailer{}
+	repo := &mocks.MockRepo{
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return &user.User{ID: id, Email: "old@bar.com", Password: hashed}, nil
+		},
+		SaveEmailChangeFunc: func(ctx context.Context, tokenHash, userID, email string, ttl time.Duration) error {
+			assert.Equal(t, "new@bar.com", email)
+			return nil
+		},
+		UpdateUserFunc: func(ctx context.Context, u user.User) error {
+			t.Fatal("email must not change before it is verified")
+			return nil
+		},
+	}
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher, mailer: mail}
+
+	err := svc.RequestEmailChange(context.Background(), "user-id", "new@bar.com", "pass")
+
+	assert.NoError(t, err)
+	assert.Len(t, mail.Sent, 1)
+	assert.Equal(t, "new@bar.com", mail.Sent[0].To)
+}
+
+func TestService_ConfirmEmailChange(t *testing.T) {
+	var updated user.User
+	var revokedRefresh, revokedAccess bool
+	repo := &mocks.MockRepo{
+		ConsumeEmailChangeFunc: func(ctx context.Context, tokenHash string) (string, string, error) {
+			assert.Equal(t, hashToken("token"), tokenHash)
+			return "user-id", "new@bar.com", nil
+		},
+		UpdateUserFunc: func(ctx context.Context, u user.User) error {
+			updated = u
+			return nil
+		},
+		RevokeRefreshTokensFunc: func(ctx context.Context, userID string) error {
+			revokedRefresh = userID == "user-id"
+			return nil
+		},
+		RevokeAccessTokensFunc: func(ctx context.Context, userID string, at time.Time, ttl time.Duration) error {
+			revokedAccess = userID == "user-id"
+			return nil
+		},
+	}
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher}
+
+	err := svc.ConfirmEmailChange(context.Background(), "token")
+
+	assert.NoError(t, err)
+	assert.Equal(t, user.User{ID: "user-id", Email: "new@bar.com"}, updated)
+	assert.True(t, revokedRefresh)
+	assert.True(t, revokedAccess)
+}
+
+func TestService_UpdateUser_InvalidRole(t *testing.T) {
+	svc := &service{logger: zap.NewNop(), repository: &mocks.MockRepo{}, hasher: testHasher}
+
+	err := svc.UpdateUser(context.Background(), user.User{ID: "user-id", Role: "superuser"})
+
+	assert.ErrorIs(t, err, key.ErrInvalidRole)
+}
+
+func TestService_GetUser_NotFound(t *testing.T) {
+	repo := &mocks.MockRepo{
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return nil, key.ErrUserNotFound
+		},
+	}
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher}
+
+	_, err := svc.GetUser(context.Background(), "missing")
+
+	assert.ErrorIs(t, err, key.ErrNotFound)
+}
+
+func TestService_GenerateRefreshToken_RecordsSession(t *testing.T) {
+	var saved user.Session
+	repo := &mocks.MockRepo{
+		SaveRefreshTokenFunc: func(ctx context.Context, token string, session user.Session, ttl time.Duration) error {
+			saved = session
+			return nil
+		},
+	}
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher}
+
+	ctx := context.WithValue(context.Background(), userAgentContextKey, "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0")
+	ctx = context.WithValue(ctx, clientIPContextKey, "10.0.0.1")
+	token, err := svc.GenerateRefreshToken(ctx, "user-id")
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.NotEmpty(t, saved.ID)
+	assert.NotEqual(t, token, saved.ID)
+	assert.Equal(t, "user-id", saved.UserID)
+	assert.Equal(t, "10.0.0.1", saved.IP)
+	assert.Equal(t, "Firefox on Linux", saved.Device)
+	assert.False(t, saved.CreatedAt.IsZero())
+}
+
+func TestService_RevokeSession_NotFound(t *testing.T) {
+	repo := &mocks.MockRepo{
+		DeleteSessionFunc: func(ctx context.Context, userID, sessionID string) error {
+			return key.ErrNotFound
+		},
+	}
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher}
+
+	err := svc.RevokeSession(context.Background(), "user-id", "other-users-session")
+
+	assert.ErrorIs(t, err, key.ErrNotFound)
+}
+
+func TestService_Login_RehashesLegacyPassword(t *testing.T) {
+	legacy, err := password.NewBcrypt(bcrypt.MinCost).Hash("pass")
+	assert.NoError(t, err)
+
+	var rehashed string
+	repo := &mocks.MockRepo{
+		FindUserByCredentialsFunc: func(ctx context.Context, identifier string) (*user.User, error) {
+			return &user.User{ID: "user-id", Password: legacy}, nil
+		},
+		FindMFAFunc: func(ctx context.Context, userID string) (*user.MFA, error) {
+			return nil, key.ErrMFANotEnabled
+		},
+		UpdatePasswordFunc: func(ctx context.Context, id, password string) error {
+			rehashed = password
+			return nil
+		},
+		SaveRefreshTokenFunc: func(ctx context.Context, token string, session user.Session, ttl time.Duration) error { return nil },
+	}
+	svc := newTestService(t, repo)
+
+	token, err := svc.Login(context.Background(), "foo@bar.com", "pass")
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.Access)
+	assert.True(t, strings.HasPrefix(rehashed, "$argon2id$"))
+	assert.True(t, verifyTestPassword(rehashed, "pass"))
+}
+
+func TestService_Register_PolicyViolation(t *testing.T) {
+	repo := &mocks.MockRepo{
+		CreateUserFunc: func(ctx context.Context, u user.User, events ...outbox.Event) error {
+			t.Fatal("user must not be created")
+			return nil
+		},
+	}
+	svc := newTestService(t, repo)
+	svc.policy = password.Policy{MinLength: 10, RejectPersonalInfo: true}
+
+	_, _, err := svc.Register(context.Background(), user.User{Name: "foo", Email: "johnny@bar.com", Password: "johnny"})
+
+	var policyErr *password.PolicyError
+	assert.ErrorIs(t, err, key.ErrInvalidPassword)
+	assert.ErrorAs(t, err, &policyErr)
+	assert.Len(t, policyErr.Violations, 2)
+}
+
+func TestService_ChangePassword_RejectsPersonalInfo(t *testing.T) {
+	hashed, _ := testHasher.Hash("current")
+	repo := &mocks.MockRepo{
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return &user.User{ID: id, Name: "Johnny Walker", Email: "foo@bar.com", Password: hashed}, nil
+		},
+	}
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher, policy: password.Policy{RejectPersonalInfo: true}}
+
+	err := svc.ChangePassword(context.Background(), "user-id", "current", "walker-2024!")
+
+	assert.ErrorIs(t, err, key.ErrInvalidPassword)
+}
+
+type breachList []string
+
+func (b breachList) IsBreached(ctx context.Context, pw string) (bool, error) {
+	for _, breached := range b {
+		if breached == pw {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func TestService_Register_BreachedPassword(t *testing.T) {
+	svc := newTestService(t, &mocks.MockRepo{})
+	svc.breaches = breachList{"P@ssw0rd1234"}
+
+	_, _, err := svc.Register(context.Background(), user.User{Name: "foo", Email: "foo@bar.com", Password: "P@ssw0rd1234"})
+
+	var policyErr *password.PolicyError
+	assert.ErrorIs(t, err, key.ErrInvalidPassword)
+	assert.ErrorAs(t, err, &policyErr)
+	assert.Equal(t, []string{password.BreachedViolation}, policyErr.Violations)
+}
+
+func TestService_ResetPassword_BreachedPasswordKeepsToken(t *testing.T) {
+	repo := &mocks.MockRepo{
+		ConsumeResetTokenFunc: func(ctx context.Context, tokenHash string) (string, time.Duration, error) {
+			t.Fatal("token must not be consumed")
+			return "", 0, nil
+		},
+	}
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher, breaches: breachList{"letmein"}}
+
+	err := svc.ResetPassword(context.Background(), "token", "letmein")
+
+	assert.ErrorIs(t, err, key.ErrInvalidPassword)
+}
+
+func TestService_ResetPassword_PersonalInfoRestoresToken(t *testing.T) {
+	var restoredTTL time.Duration
+	repo := &mocks.MockRepo{
+		ConsumeResetTokenFunc: func(ctx context.Context, tokenHash string) (string, time.Duration, error) {
+			return "user-id", 4 * time.Minute, nil
+		},
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return &user.User{ID: id, Name: "jonathan", Email: "jonathan@bar.com"}, nil
+		},
+		SaveResetTokenFunc: func(ctx context.Context, tokenHash, userID string, ttl time.Duration) error {
+			assert.Equal(t, hashToken("token"), tokenHash)
+			assert.Equal(t, "user-id", userID)
+			restoredTTL = ttl
+			return nil
+		},
+	}
+	svc := &service{logger: zap.NewNop(), repository: repo, hasher: testHasher, policy: password.Policy{RejectPersonalInfo: true}}
+
+	err := svc.ResetPassword(context.Background(), "token", "jonathan-secret")
+
+	assert.ErrorIs(t, err, key.ErrInvalidPassword)
+	// the token keeps the time it had left rather than getting a new lifetime
+	assert.Equal(t, 4*time.Minute, restoredTTL)
+}
+
+func serviceClient(t *testing.T, secret string) *oauth.Client {
+	t.Helper()
+	hash, err := testHasher.Hash(secret)
+	assert.NoError(t, err)
+
+	return &oauth.Client{ID: "auction-service", Name: "auction-service", SecretHash: hash, Scopes: []string{"bidders:read", "users:read"}}
+}
+
+func TestService_IssueClientToken_Success(t *testing.T) {
+	repo := &mocks.MockRepo{
+		FindClientFunc: func(ctx context.Context, id string) (*oauth.Client, error) {
+			return serviceClient(t, "secret"), nil
+		},
+	}
+	svc := newTestService(t, repo)
+
+	token, scopes, err := svc.IssueClientToken(context.Background(), "auction-service", "secret", []string{"bidders:read"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bidders:read"}, scopes)
+
+	claims, err := svc.parseToken(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "auction-service", claims["azp"])
+	assert.Equal(t, "bidders:read", claims["scope"])
+
+	_, err = svc.VerifyAccessToken(context.Background(), token)
+	assert.ErrorIs(t, err, key.ErrInvalidToken, "service tokens must not authenticate users")
+}
+
+func TestService_IssueClientToken_DefaultsToAllScopes(t *testing.T) {
+	repo := &mocks.MockRepo{
+		FindClientFunc: func(ctx context.Context, id string) (*oauth.Client, error) {
+			return serviceClient(t, "secret"), nil
+		},
+	}
+	svc := newTestService(t, repo)
+
+	_, scopes, err := svc.IssueClientToken(context.Background(), "auction-service", "secret", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bidders:read", "users:read"}, scopes)
+}
+
+func TestService_IssueClientToken_Errors(t *testing.T) {
+	repo := &mocks.MockRepo{
+		FindClientFunc: func(ctx context.Context, id string) (*oauth.Client, error) {
+			if id == "unknown" {
+				return nil, key.ErrInvalidClient
+			}
+			return serviceClient(t, "secret"), nil
+		},
+	}
+	svc := newTestService(t, repo)
+
+	_, _, err := svc.IssueClientToken(context.Background(), "unknown", "secret", nil)
+	assert.ErrorIs(t, err, key.ErrInvalidClient)
+
+	_, _, err = svc.IssueClientToken(context.Background(), "auction-service", "wrong", nil)
+	assert.ErrorIs(t, err, key.ErrInvalidClient)
+
+	_, _, err = svc.IssueClientToken(context.Background(), "auction-service", "secret", []string{"users:write"})
+	assert.ErrorIs(t, err, key.ErrInvalidScope)
+}
+
+func TestService_CreateClient(t *testing.T) {
+	var created oauth.Client
+	repo := &mocks.MockRepo{
+		CreateClientFunc: func(ctx context.Context, client oauth.Client) error {
+			created = client
+			return nil
+		},
+	}
+	svc := newTestService(t, repo)
+
+	secret, err := svc.CreateClient(context.Background(), oauth.Client{ID: "bidder-service", Scopes: []string{"auctions:read"}})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, secret)
+	assert.NotContains(t, created.SecretHash, secret)
+	assert.True(t, verifyTestPassword(created.SecretHash, secret))
+
+	_, err = svc.CreateClient(context.Background(), oauth.Client{ID: "Bad Client"})
+	assert.ErrorIs(t, err, key.ErrBadRequest)
+}
+
+func TestService_CreateAPIKey(t *testing.T) {
+	var stored apikey.APIKey
+	repo := &mocks.MockRepo{
+		CreateAPIKeyFunc: func(ctx context.Context, k apikey.APIKey) error {
+			stored = k
+			return nil
+		},
+	}
+	svc := newTestService(t, repo)
+	expiresAt := time.Now().Add(time.Hour)
+
+	plain, created, err := svc.CreateAPIKey(context.Background(), "user-id", "", "ci", []string{apikey.ScopeAuctionsWrite}, &expiresAt)
+
+	assert.NoError(t, err)
+	assert.True(t, apikey.LooksValid(plain))
+	assert.Equal(t, apikey.Hash(plain), stored.Hash)
+	assert.True(t, strings.HasPrefix(plain, created.Prefix))
+	assert.Equal(t, []string{apikey.ScopeAuctionsWrite}, stored.Scopes)
+	assert.Equal(t, &expiresAt, stored.ExpiresAt)
+}
+
+func TestService_CreateAPIKey_Invalid(t *testing.T) {
+	svc := newTestService(t, &mocks.MockRepo{})
+	past := time.Now().Add(-time.Hour)
+
+	_, _, err := svc.CreateAPIKey(context.Background(), "user-id", "", "ci", []string{"users:write"}, nil)
+	assert.ErrorIs(t, err, key.ErrBadRequest)
+
+	_, _, err = svc.CreateAPIKey(context.Background(), "user-id", "", "ci", []string{apikey.ScopeItemsWrite}, &past)
+	assert.ErrorIs(t, err, key.ErrBadRequest)
+
+	_, _, err = svc.CreateAPIKey(context.Background(), "user-id", "", "", []string{apikey.ScopeItemsWrite}, nil)
+	assert.ErrorIs(t, err, key.ErrBadRequest)
+}
+
+func TestService_ResolveAPIKey(t *testing.T) {
+	plain, prefix, err := apikey.Generate()
+	assert.NoError(t, err)
+
+	var touched string
+	repo := &mocks.MockRepo{
+		FindAPIKeyByHashFunc: func(ctx context.Context, hash string) (*apikey.APIKey, error) {
+			assert.Equal(t, apikey.Hash(plain), hash)
+			return &apikey.APIKey{ID: "key-id", UserID: "user-id", Prefix: prefix, Scopes: []string{apikey.ScopeAuctionsWrite, apikey.ScopeItemsWrite}}, nil
+		},
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return &user.User{ID: id, Email: "seller@bar.com", Role: user.RoleUser}, nil
+		},
+		TouchAPIKeyFunc: func(ctx context.Context, id string, usedAt time.Time) error {
+			touched = id
+			return nil
+		},
+		FindClientFunc: gatewayClient(t),
+	}
+	svc := newTestService(t, repo)
+
+	claims, err := svc.ResolveAPIKey(context.Background(), "gateway", "secret", plain)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "user-id", claims["sub"])
+	assert.Equal(t, "auctions:write items:write", claims["scope"])
+	assert.Equal(t, sharedhttp.TokenTypeAPIKey, claims["typ"])
+	assert.Equal(t, "key-id", touched)
+}
+
+func TestService_ResolveAPIKey_Expired(t *testing.T) {
+	plain, _, _ := apikey.Generate()
+	expired := time.Now().Add(-time.Minute)
+	repo := &mocks.MockRepo{
+		FindAPIKeyByHashFunc: func(ctx context.Context, hash string) (*apikey.APIKey, error) {
+			return &apikey.APIKey{ID: "key-id", UserID: "user-id", ExpiresAt: &expired}, nil
+		},
+		FindClientFunc: gatewayClient(t),
+	}
+	svc := newTestService(t, repo)
+
+	_, err := svc.ResolveAPIKey(context.Background(), "gateway", "secret", plain)
+	assert.ErrorIs(t, err, key.ErrExpiredToken)
+
+	_, err = svc.ResolveAPIKey(context.Background(), "gateway", "secret", "not-a-key")
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+func TestService_ResolveAPIKey_InvalidClient(t *testing.T) {
+	plain, _, _ := apikey.Generate()
+	svc := newTestService(t, &mocks.MockRepo{FindClientFunc: gatewayClient(t)})
+
+	_, err := svc.ResolveAPIKey(context.Background(), "gateway", "wrong", plain)
+	assert.ErrorIs(t, err, key.ErrInvalidClient)
+
+	_, err = svc.ResolveAPIKey(context.Background(), "unknown", "secret", plain)
+	assert.ErrorIs(t, err, key.ErrInvalidClient)
+}
+
+func TestService_SignToken_IssuerAndID(t *testing.T) {
+	svc := newTestService(t, &mocks.MockRepo{})
+	svc.issuer = "https://auth.example.com"
+
+	first, err := svc.SignToken(context.Background(), user.User{ID: "user-id"})
+	assert.NoError(t, err)
+	second, _ := svc.SignToken(context.Background(), user.User{ID: "user-id"})
+
+	claims, err := svc.parseToken(first)
+	assert.NoError(t, err)
+	other, _ := svc.parseToken(second)
+	assert.Equal(t, "https://auth.example.com", claims["iss"])
+	assert.NotEmpty(t, claims["jti"])
+	assert.NotEqual(t, claims["jti"], other["jti"])
+}
+
+// gatewayClient finds the "gateway" client, its secret is "secret"
+func gatewayClient(t *testing.T) func(ctx context.Context, id string) (*oauth.Client, error) {
+	secretHash, err := testHasher.Hash("secret")
+	assert.NoError(t, err)
+
+	return func(ctx context.Context, id string) (*oauth.Client, error) {
+		if id != "gateway" {
+			return nil, key.ErrInvalidClient
+		}
+		return &oauth.Client{ID: id, SecretHash: secretHash}, nil
+	}
+}
+
+func introspectionRepo(t *testing.T, revokedAt time.Time) *mocks.MockRepo {
+	return &mocks.MockRepo{
+		FindClientFunc: gatewayClient(t),
+		AccessTokensRevokedAtFunc: func(ctx context.Context, userID string) (time.Time, error) {
+			return revokedAt, nil
+		},
+		FindRefreshTokenFunc: func(ctx context.Context, token string) (string, time.Duration, error) {
+			if token == "refresh:known" {
+				return "user-id", time.Hour, nil
+			}
+			return "", 0, key.ErrExpiredToken
+		},
+	}
+}
+
+func TestService_IntrospectToken_AccessToken(t *testing.T) {
+	svc := newTestService(t, introspectionRepo(t, time.Time{}))
+	token, _ := svc.SignToken(context.Background(), user.User{ID: "user-id", Email: "foo@bar.com"})
+
+	result, err := svc.IntrospectToken(context.Background(), "gateway", "secret", token, "")
+
+	assert.NoError(t, err)
+	assert.True(t, result.Active)
+	assert.Equal(t, "user-id", result.Sub)
+	assert.Equal(t, "foo@bar.com", result.Username)
+	assert.Equal(t, "Bearer", result.TokenType)
+	assert.NotZero(t, result.Exp)
+}
+
+func TestService_IntrospectToken_Revoked(t *testing.T) {
+	svc := newTestService(t, introspectionRepo(t, time.Now().Add(time.Minute)))
+	token, _ := svc.SignToken(context.Background(), user.User{ID: "user-id"})
+
+	result, err := svc.IntrospectToken(context.Background(), "gateway", "secret", token, oidc.HintAccessToken)
+
+	assert.NoError(t, err)
+	assert.Equal(t, oidc.Inactive, result)
+}
+
+func TestService_VerifyAccessToken_Revoked(t *testing.T) {
+	svc := newTestService(t, introspectionRepo(t, time.Now().Add(time.Minute)))
+	token, _ := svc.SignToken(context.Background(), user.User{ID: "user-id"})
+
+	_, err := svc.VerifyAccessToken(context.Background(), token)
+
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+func TestService_IntrospectToken_RefreshToken(t *testing.T) {
+	svc := newTestService(t, introspectionRepo(t, time.Time{}))
+
+	result, err := svc.IntrospectToken(context.Background(), "gateway", "secret", "known", oidc.HintRefreshToken)
+	assert.NoError(t, err)
+	assert.True(t, result.Active)
+	assert.Equal(t, oidc.HintRefreshToken, result.TokenType)
+	assert.Equal(t, "user-id", result.Sub)
+
+	result, err = svc.IntrospectToken(context.Background(), "gateway", "secret", "unknown", "")
+	assert.NoError(t, err)
+	assert.False(t, result.Active)
+}
+
+func TestService_IntrospectToken_InvalidClient(t *testing.T) {
+	svc := newTestService(t, introspectionRepo(t, time.Time{}))
+
+	_, err := svc.IntrospectToken(context.Background(), "gateway", "wrong", "known", "")
+	assert.ErrorIs(t, err, key.ErrInvalidClient)
+
+	_, err = svc.IntrospectToken(context.Background(), "unknown", "secret", "known", "")
+	assert.ErrorIs(t, err, key.ErrInvalidClient)
+}
+
+func TestService_UserInfo(t *testing.T) {
+	repo := introspectionRepo(t, time.Time{})
+	repo.FindUserFunc = func(ctx context.Context, id string) (*user.User, error) {
+		return &user.User{ID: id, Name: "foo", Email: "foo@bar.com", Role: user.RoleUser}, nil
+	}
+	svc := newTestService(t, repo)
+	token, _ := svc.SignToken(context.Background(), user.User{ID: "user-id"})
+
+	claims, err := svc.UserInfo(context.Background(), token)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "user-id", claims["sub"])
+	assert.Equal(t, "foo@bar.com", claims["email"])
+	assert.Equal(t, "foo", claims["preferred_username"])
+}
+
+func TestService_UserInfo_Revoked(t *testing.T) {
+	svc := newTestService(t, introspectionRepo(t, time.Now().Add(time.Minute)))
+	token, _ := svc.SignToken(context.Background(), user.User{ID: "user-id"})
+
+	_, err := svc.UserInfo(context.Background(), token)
+
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+func magicLinkRepo(attempt int64, saved map[string]magiclink.Link) *mocks.MockRepo {
+	return &mocks.MockRepo{
+		CountAttemptFunc: func(ctx context.Context, bucket string, window time.Duration) (int64, error) {
+			return attempt, nil
+		},
+		FindUserByCredentialsFunc: func(ctx context.Context, identifier string) (*user.User, error) {
+			return &user.User{ID: "user-id", Email: "foo@bar.com"}, nil
+		},
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return &user.User{ID: id, Email: "foo@bar.com"}, nil
+		},
+		SaveMagicLinkFunc: func(ctx context.Context, tokenHash string, link magiclink.Link, ttl time.Duration) error {
+			saved[tokenHash] = link
+			return nil
+		},
+		FindMagicLinkFunc: func(ctx context.Context, tokenHash string) (*magiclink.Link, error) {
+			link, ok := saved[tokenHash]
+			if !ok {
+				return nil, key.ErrInvalidToken
+			}
+			return &link, nil
+		},
+		ConsumeMagicLinkFunc: func(ctx context.Context, tokenHash string) (*magiclink.Link, error) {
+			link, ok := saved[tokenHash]
+			if !ok {
+				return nil, key.ErrInvalidToken
+			}
+			delete(saved, tokenHash)
+			return &link, nil
+		},
+		FindMFAFunc: func(ctx context.Context, userID string) (*user.MFA, error) {
+			return nil, key.ErrMFANotEnabled
+		},
+		SaveRefreshTokenFunc: func(ctx context.Context, token string, session user.Session, ttl time.Duration) error {
+			return nil
+		},
+	}
+}
+
+// sentMagicLink requests a link and returns the token from the mail
+func sentMagicLink(t *testing.T, svc *service, ctx context.Context, deviceID string) string {
+	t.Helper()
+	mail := svc.mailer.(*mocks.MockMailer)
+
+	assert.NoError(t, svc.RequestMagicLink(ctx, " Foo@Bar.com ", deviceID))
+	assert.Len(t, mail.Sent, 1)
+
+	link, err := url.Parse(strings.Fields(strings.TrimPrefix(mail.Sent[0].Body, "Use the following link to sign in: "))[0])
+	assert.NoError(t, err)
+
+	return link.Query().Get("token")
+}
+
+func TestService_MagicLink_SingleUse(t *testing.T) {
+	saved := map[string]magiclink.Link{}
+	svc := newTestService(t, magicLinkRepo(1, saved))
+	svc.magicLink = magiclink.NewOptions(config.MagicLinkConfig{URL: "https://app.example.com/login"})
+
+	token := sentMagicLink(t, svc, context.Background(), "")
+
+	// only the hash of the token is stored
+	assert.Contains(t, saved, hashToken(token))
+
+	tokens, err := svc.ConsumeMagicLink(context.Background(), token, "")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.Access)
+	assert.NotEmpty(t, tokens.Refresh)
+
+	_, err = svc.ConsumeMagicLink(context.Background(), token, "")
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+
+	// the link itself is never an access token
+	_, err = svc.VerifyAccessToken(context.Background(), token)
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+func TestService_MagicLink_BindDevice(t *testing.T) {
+	svc := newTestService(t, magicLinkRepo(1, map[string]magiclink.Link{}))
+	svc.magicLink = magiclink.NewOptions(config.MagicLinkConfig{URL: "https://app.example.com/login", BindDevice: true})
+
+	token := sentMagicLink(t, svc, context.Background(), "laptop")
+
+	_, err := svc.ConsumeMagicLink(context.Background(), token, "phone")
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+
+	// the wrong device doesn't use the link up
+	tokens, err := svc.ConsumeMagicLink(context.Background(), token, "laptop")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.Access)
+}
+
+func TestService_MagicLink_RateLimited(t *testing.T) {
+	svc := newTestService(t, magicLinkRepo(6, map[string]magiclink.Link{}))
+	svc.magicLink = magiclink.NewOptions(config.MagicLinkConfig{})
+
+	err := svc.RequestMagicLink(context.Background(), "foo@bar.com", "")
+
+	assert.ErrorIs(t, err, key.ErrTooManyRequests)
+	assert.Empty(t, svc.mailer.(*mocks.MockMailer).Sent)
+}
+
+func TestService_MagicLink_MFARequired(t *testing.T) {
+	repo := magicLinkRepo(1, map[string]magiclink.Link{})
+	repo.FindMFAFunc = func(ctx context.Context, userID string) (*user.MFA, error) {
+		return &user.MFA{UserID: userID, Enabled: true}, nil
+	}
+	svc := newTestService(t, repo)
+	svc.magicLink = magiclink.NewOptions(config.MagicLinkConfig{URL: "https://app.example.com/login"})
+
+	tokens, err := svc.ConsumeMagicLink(context.Background(), sentMagicLink(t, svc, context.Background(), ""), "")
+
+	assert.NoError(t, err)
+	assert.Empty(t, tokens.Access)
+	assert.NotEmpty(t, tokens.MFAPending)
+}
+
+// federationRepo keeps pending logins, users and identities in memory
+func federationRepo(users map[string]*user.User, identities map[string]string) *mocks.MockRepo {
+	states := map[string]federation.State{}
+
+	return &mocks.MockRepo{
+		SaveFederationStateFunc: func(ctx context.Context, state string, values federation.State, ttl time.Duration) error {
+			states[state] = values
+			return nil
+		},
+		ConsumeFederationStateFunc: func(ctx context.Context, state string) (*federation.State, error) {
+			values, ok := states[state]
+			if !ok {
+				return nil, key.ErrInvalidToken
+			}
+			delete(states, state)
+			return &values, nil
+		},
+		FindIdentityFunc: func(ctx context.Context, provider, subject string) (string, error) {
+			userID, ok := identities[provider+"/"+subject]
+			if !ok {
+				return "", key.ErrNotFound
+			}
+			return userID, nil
+		},
+		CreateIdentityFunc: func(ctx context.Context, userID string, identity federation.Identity) error {
+			identities[identity.Provider+"/"+identity.Subject] = userID
+			return nil
+		},
+		CreateUserWithIdentityFunc: func(ctx context.Context, u user.User, identity federation.Identity, events ...outbox.Event) error {
+			users[u.ID] = &u
+			identities[identity.Provider+"/"+identity.Subject] = u.ID
+			return nil
+		},
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return users[id], nil
+		},
+		FindUserByCredentialsFunc: func(ctx context.Context, identifier string) (*user.User, error) {
+			for _, u := range users {
+				if u.Email == identifier {
+					return u, nil
+				}
+			}
+			return nil, key.ErrUserNotFound
+		},
+		FindMFAFunc: func(ctx context.Context, userID string) (*user.MFA, error) {
+			return nil, key.ErrMFANotEnabled
+		},
+		SaveRefreshTokenFunc: func(ctx context.Context, token string, session user.Session, ttl time.Duration) error {
+			return nil
+		},
+	}
+}
+
+func newFederatedTestService(t *testing.T, repo *mocks.MockRepo) (*service, *oidctest.Server) {
+	t.Helper()
+	server, err := oidctest.NewServer("auctions", "secret")
+	assert.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	svc := newTestService(t, repo)
+	svc.providers = federation.NewProviders(config.OIDCConfig{Providers: map[string]config.OIDCProviderConfig{
+		"test": {Issuer: server.URL, ClientID: "auctions", ClientSecret: "secret", RedirectURL: "http://localhost:8099/auth/oidc/test/callback"},
+	}})
+
+	return svc, server
+}
+
+func federatedLogin(t *testing.T, svc *service, server *oidctest.Server) (*key.Token, error) {
+	t.Helper()
+	authURL, err := svc.StartFederatedLogin(context.Background(), "test")
+	assert.NoError(t, err)
+
+	callback, err := server.Authorize(authURL)
+	assert.NoError(t, err)
+
+	return svc.CompleteFederatedLogin(context.Background(), "test", callback.Query().Get("code"), callback.Query().Get("state"))
+}
+
+func TestService_FederatedLogin_CreatesUser(t *testing.T) {
+	users, identities := map[string]*user.User{}, map[string]string{}
+	svc, server := newFederatedTestService(t, federationRepo(users, identities))
+	server.SignIn(oidctest.User{Subject: "42", Email: "jane@example.com", EmailVerified: true})
+
+	token, err := federatedLogin(t, svc, server)
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.Access)
+	assert.Len(t, users, 1)
+	claims, _ := svc.parseToken(token.Access)
+	assert.Equal(t, identities["test/42"], claims["sub"])
+
+	// signing in again uses the linked user
+	_, err = federatedLogin(t, svc, server)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+}
+
+func TestService_FederatedLogin_LinksVerifiedEmail(t *testing.T) {
+	users := map[string]*user.User{"local-id": {ID: "local-id", Email: "jane@example.com", Role: user.RoleUser}}
+	identities := map[string]string{}
+	svc, server := newFederatedTestService(t, federationRepo(users, identities))
+	server.SignIn(oidctest.User{Subject: "42", Email: "jane@example.com", EmailVerified: true})
+
+	_, err := federatedLogin(t, svc, server)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "local-id", identities["test/42"])
+}
+
+func TestService_FederatedLogin_UnverifiedEmailTaken(t *testing.T) {
+	users := map[string]*user.User{"local-id": {ID: "local-id", Email: "jane@example.com"}}
+	identities := map[string]string{}
+	svc, server := newFederatedTestService(t, federationRepo(users, identities))
+	server.SignIn(oidctest.User{Subject: "42", Email: "jane@example.com", EmailVerified: false})
+
+	_, err := federatedLogin(t, svc, server)
+
+	assert.ErrorIs(t, err, key.ErrAlreadyExists)
+	assert.Empty(t, identities)
+}
+
+func TestService_FederatedLogin_StateIsSingleUse(t *testing.T) {
+	svc, server := newFederatedTestService(t, federationRepo(map[string]*user.User{}, map[string]string{}))
+
+	authURL, err := svc.StartFederatedLogin(context.Background(), "test")
+	assert.NoError(t, err)
+	callback, err := server.Authorize(authURL)
+	assert.NoError(t, err)
+	state := callback.Query().Get("state")
+
+	_, err = svc.CompleteFederatedLogin(context.Background(), "test", callback.Query().Get("code"), state)
+	assert.NoError(t, err)
+
+	_, err = svc.CompleteFederatedLogin(context.Background(), "test", callback.Query().Get("code"), state)
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+func TestService_FederatedLogin_UnknownProvider(t *testing.T) {
+	svc, _ := newFederatedTestService(t, federationRepo(map[string]*user.User{}, map[string]string{}))
+
+	_, err := svc.StartFederatedLogin(context.Background(), "github")
+
+	assert.ErrorIs(t, err, key.ErrNotFound)
+}
+
+func impersonationRepo() *mocks.MockRepo {
+	users := map[string]*user.User{
+		"admin-id":    {ID: "admin-id", Email: "admin@bar.com", Role: user.RoleAdmin},
+		"other-admin": {ID: "other-admin", Email: "other@bar.com", Role: user.RoleAdmin},
+		"user-id":     {ID: "user-id", Email: "foo@bar.com", Role: user.RoleUser},
+	}
+
+	return &mocks.MockRepo{
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			if u, ok := users[id]; ok {
+				return u, nil
+			}
+			return nil, key.ErrUserNotFound
+		},
+		AccessTokensRevokedAtFunc: func(ctx context.Context, userID string) (time.Time, error) {
+			return time.Time{}, nil
+		},
+	}
+}
+
+func TestService_Impersonate(t *testing.T) {
+	svc := newTestService(t, impersonationRepo())
+	svc.issuer = "https://auth.example.com"
+
+	token, err := svc.Impersonate(context.Background(), "admin-id", "user-id")
+	assert.NoError(t, err)
+
+	claims, err := svc.VerifyAccessToken(context.Background(), token)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-id", claims["sub"])
+	assert.Equal(t, user.RoleUser, claims["role"])
+	assert.Equal(t, "https://auth.example.com", claims["iss"])
+
+	actor, ok := sharedhttp.Actor(claims)
+	assert.True(t, ok)
+	assert.Equal(t, "admin-id", actor)
+
+	exp, _ := claims.GetExpirationTime()
+	assert.WithinDuration(t, time.Now().Add(impersonationTTL), exp.Time, 5*time.Second)
+}
+
+func TestService_Impersonate_Refused(t *testing.T) {
+	svc := newTestService(t, impersonationRepo())
+
+	_, err := svc.Impersonate(context.Background(), "admin-id", "admin-id")
+	assert.ErrorIs(t, err, key.ErrBadRequest)
+
+	_, err = svc.Impersonate(context.Background(), "admin-id", "other-admin")
+	assert.ErrorIs(t, err, key.ErrForbidden)
+
+	_, err = svc.Impersonate(context.Background(), "admin-id", "missing")
+	assert.ErrorIs(t, err, key.ErrNotFound)
+}
+
+func TestService_IntrospectToken_Impersonation(t *testing.T) {
+	repo := introspectionRepo(t, time.Time{})
+	repo.FindUserFunc = impersonationRepo().FindUserFunc
+	svc := newTestService(t, repo)
+	token, err := svc.Impersonate(context.Background(), "admin-id", "user-id")
+	assert.NoError(t, err)
+
+	result, err := svc.IntrospectToken(context.Background(), "gateway", "secret", token, "")
+
+	assert.NoError(t, err)
+	assert.True(t, result.Active)
+	assert.Equal(t, &oidc.Actor{Sub: "admin-id"}, result.Act)
+}
+
+// organizationRepo keeps members in memory, keyed by organization and user id
+func organizationRepo(members map[string]*organization.Member, invitations map[string]*organization.Invitation) *mocks.MockRepo {
+	return &mocks.MockRepo{
+		FindUserFunc: func(ctx context.Context, id string) (*user.User, error) {
+			return &user.User{ID: id, Email: id + "@bar.com", Role: user.RoleUser}, nil
+		},
+		FindMemberFunc: func(ctx context.Context, orgID, userID string) (*organization.Member, error) {
+			if member, ok := members[orgID+"/"+userID]; ok {
+				return member, nil
+			}
+			return nil, key.ErrNotFound
+		},
+		CountOwnersFunc: func(ctx context.Context, orgID string) (int, error) {
+			owners := 0
+			for _, member := range members {
+				if member.OrganizationID == orgID && member.Role == organization.RoleOwner {
+					owners++
+				}
+			}
+			return owners, nil
+		},
+		UpdateMemberRoleFunc: func(ctx context.Context, orgID, userID, role string) error {
+			members[orgID+"/"+userID].Role = role
+			return nil
+		},
+		DeleteMemberFunc: func(ctx context.Context, orgID, userID string) error {
+			delete(members, orgID+"/"+userID)
+			return nil
+		},
+		CreateInvitationFunc: func(ctx context.Context, invitation organization.Invitation) error {
+			invitations[invitation.TokenHash] = &invitation
+			return nil
+		},
+		FindInvitationFunc: func(ctx context.Context, tokenHash string) (*organization.Invitation, error) {
+			if invitation, ok := invitations[tokenHash]; ok {
+				return invitation, nil
+			}
+			return nil, key.ErrInvalidToken
+		},
+		AcceptInvitationFunc: func(ctx context.Context, invitationID string, member organization.Member) error {
+			members[member.OrganizationID+"/"+member.UserID] = &member
+			return nil
+		},
+	}
+}
+
+func orgMembers() map[string]*organization.Member {
+	return map[string]*organization.Member{
+		"org-id/owner":   {OrganizationID: "org-id", UserID: "owner", Role: organization.RoleOwner},
+		"org-id/manager": {OrganizationID: "org-id", UserID: "manager", Role: organization.RoleManager},
+	}
+}
+
+func TestService_CreateOrganization(t *testing.T) {
+	var owner organization.Member
+	repo := &mocks.MockRepo{
+		CreateOrganizationFunc: func(ctx context.Context, org organization.Organization, member organization.Member) error {
+			owner = member
+			return nil
+		},
+	}
+	svc := newTestService(t, repo)
+
+	created, err := svc.CreateOrganization(context.Background(), "user-id", " Acme Auctions ")
+	assert.NoError(t, err)
+	assert.Equal(t, "Acme Auctions", created.Name)
+	assert.Equal(t, organization.Member{OrganizationID: created.ID, UserID: "user-id", Role: organization.RoleOwner, CreatedAt: created.CreatedAt}, owner)
+
+	_, err = svc.CreateOrganization(context.Background(), "user-id", " ")
+	assert.ErrorIs(t, err, key.ErrBadRequest)
+}
+
+func TestService_InviteAndAccept(t *testing.T) {
+	members := orgMembers()
+	invitations := map[string]*organization.Invitation{}
+	svc := newTestService(t, organizationRepo(members, invitations))
+	mail := &mocks.MockMailer{}
+	svc.mailer = mail
+	svc.invitationURL = "https://example.com/join"
+
+	_, err := svc.InviteMember(context.Background(), "manager", "org-id", "new@bar.com", organization.RoleViewer)
+	assert.ErrorIs(t, err, key.ErrForbidden)
+
+	invitation, err := svc.InviteMember(context.Background(), "owner", "org-id", " New@Bar.com ", organization.RoleViewer)
+	assert.NoError(t, err)
+	assert.Equal(t, "new@bar.com", invitation.Email)
+	assert.Len(t, mail.Sent, 1)
+
+	link, err := url.Parse(strings.Fields(strings.SplitAfter(mail.Sent[0].Body, ": ")[1])[0])
+	assert.NoError(t, err)
+	token := link.Query().Get("token")
+
+	// only the invited address can accept
+	_, err = svc.AcceptInvitation(context.Background(), "someone", token)
+	assert.ErrorIs(t, err, key.ErrForbidden)
+
+	member, err := svc.AcceptInvitation(context.Background(), "new", token)
+	assert.NoError(t, err)
+	assert.Equal(t, organization.RoleViewer, member.Role)
+	assert.Contains(t, members, "org-id/new")
+
+	invitations[invitation.TokenHash].AcceptedAt = &member.CreatedAt
+	_, err = svc.AcceptInvitation(context.Background(), "new", token)
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+func TestService_AcceptInvitation_Expired(t *testing.T) {
+	invitations := map[string]*organization.Invitation{
+		hashToken("token"): {ID: "id", OrganizationID: "org-id", Email: "new@bar.com", ExpiresAt: time.Now().Add(-time.Minute)},
+	}
+	svc := newTestService(t, organizationRepo(orgMembers(), invitations))
+
+	_, err := svc.AcceptInvitation(context.Background(), "new", "token")
+
+	assert.ErrorIs(t, err, key.ErrExpiredToken)
+}
+
+func TestService_KeepsLastOwner(t *testing.T) {
+	members := orgMembers()
+	svc := newTestService(t, organizationRepo(members, nil))
+
+	err := svc.UpdateMemberRole(context.Background(), "owner", "org-id", "owner", organization.RoleManager)
+	assert.ErrorIs(t, err, key.ErrBadRequest)
+
+	err = svc.RemoveMember(context.Background(), "owner", "org-id", "owner")
+	assert.ErrorIs(t, err, key.ErrBadRequest)
+
+	assert.NoError(t, svc.UpdateMemberRole(context.Background(), "owner", "org-id", "manager", organization.RoleOwner))
+	assert.NoError(t, svc.RemoveMember(context.Background(), "owner", "org-id", "owner"))
+	assert.NotContains(t, members, "org-id/owner")
+}
+
+func TestService_UpdateMemberRole_Audited(t *testing.T) {
+	repo := organizationRepo(orgMembers(), nil)
+	events := auditRepo(repo)
+	svc := newTestService(t, repo)
+
+	assert.NoError(t, svc.UpdateMemberRole(context.Background(), "owner", "org-id", "manager", organization.RoleViewer))
+
+	assert.Len(t, *events, 1)
+	assert.Equal(t, audit.TypeRoleChange, (*events)[0].Type)
+	assert.Equal(t, "owner", (*events)[0].ActorID)
+	assert.Equal(t, "manager", (*events)[0].SubjectID)
+	assert.Equal(t, map[string]string{"organization": "org-id", "role": organization.RoleViewer, "previousRole": organization.RoleManager}, (*events)[0].Details)
+}
+
+func TestService_RemoveMember(t *testing.T) {
+	members := orgMembers()
+	members["org-id/viewer"] = &organization.Member{OrganizationID: "org-id", UserID: "viewer", Role: organization.RoleViewer}
+	svc := newTestService(t, organizationRepo(members, nil))
+
+	err := svc.RemoveMember(context.Background(), "manager", "org-id", "viewer")
+	assert.ErrorIs(t, err, key.ErrForbidden)
+
+	// members may always leave
+	assert.NoError(t, svc.RemoveMember(context.Background(), "viewer", "org-id", "viewer"))
+
+	err = svc.RemoveMember(context.Background(), "outsider", "org-id", "manager")
+	assert.ErrorIs(t, err, key.ErrNotFound)
+}
+
+func TestService_SwitchOrganization(t *testing.T) {
+	repo := organizationRepo(orgMembers(), nil)
+	repo.AccessTokensRevokedAtFunc = func(ctx context.Context, userID string) (time.Time, error) {
+		return time.Time{}, nil
+	}
+	svc := newTestService(t, repo)
+
+	token, err := svc.SwitchOrganization(context.Background(), "manager", "org-id")
+	assert.NoError(t, err)
+
+	claims, err := svc.VerifyAccessToken(context.Background(), token)
+	assert.NoError(t, err)
+	org, ok := sharedhttp.OrganizationOf(claims)
+	assert.True(t, ok)
+	assert.Equal(t, sharedhttp.Organization{ID: "org-id", Role: organization.RoleManager}, org)
+
+	_, err = svc.SwitchOrganization(context.Background(), "outsider", "org-id")
+	assert.ErrorIs(t, err, key.ErrNotFound)
+}
+
+func TestService_ResolveAPIKey_Organization(t *testing.T) {
+	members := orgMembers()
+	repo := organizationRepo(members, nil)
+	repo.FindAPIKeyByHashFunc = func(ctx context.Context, hash string) (*apikey.APIKey, error) {
+		return &apikey.APIKey{ID: "key-id", UserID: "manager", OrganizationID: "org-id", Scopes: []string{apikey.ScopeAuctionsWrite}}, nil
+	}
+	repo.TouchAPIKeyFunc = func(ctx context.Context, id string, usedAt time.Time) error {
+		return nil
+	}
+	repo.FindClientFunc = gatewayClient(t)
+	svc := newTestService(t, repo)
+	plain, _, err := apikey.Generate()
+	assert.NoError(t, err)
+
+	claims, err := svc.ResolveAPIKey(context.Background(), "gateway", "secret", plain)
+	assert.NoError(t, err)
+	assert.Equal(t, "org-id", claims[sharedhttp.OrgClaim])
+	assert.Equal(t, organization.RoleManager, claims[sharedhttp.OrgRoleClaim])
+
+	// the key stops working once its owner leaves the organization
+	delete(members, "org-id/manager")
+	_, err = svc.ResolveAPIKey(context.Background(), "gateway", "secret", plain)
+	assert.ErrorIs(t, err, key.ErrInvalidToken)
+}
+
+// auditRepo collects the audit events appended through the repo
+func auditRepo(repo *mocks.MockRepo) *[]audit.Event {
+	var events []audit.Event
+	repo.AppendAuditEventFunc = func(ctx context.Context, event audit.Event) error {
+		events = append(events, event)
+		return nil
+	}
+
+	return &events
+}
+
+func TestService_Login_AuditsFailureWithoutIdentifier(t *testing.T) {
+	hashed, _ := testHasher.Hash("pass")
+	repo := &mocks.MockRepo{
+		FindUserByCredentialsFunc: func(ctx context.Context, identifier string) (*user.User, error) {
+			if identifier == "foo@bar.com" {
+				return &user.User{ID: "user-id", Password: hashed}, nil
+			}
+
+			return nil, key.ErrUserNotFound
+		},
+	}
+	events := auditRepo(repo)
+	svc := newTestService(t, repo)
+	ctx := context.WithValue(context.Background(), clientIPContextKey, "10.0.0.1")
+
+	_, err := svc.Login(ctx, "Nobody@bar.com", "pass")
+	assert.ErrorIs(t, err, key.ErrInvalidCredentials)
+	_, err = svc.Login(ctx, "foo@bar.com", "wrong")
+	assert.ErrorIs(t, err, key.ErrInvalidCredentials)
+
+	assert.Len(t, *events, 2)
+	unknown, wrongPassword := (*events)[0], (*events)[1]
+	assert.Equal(t, audit.TypeLogin, unknown.Type)
+	assert.Equal(t, audit.OutcomeFailure, unknown.Outcome)
+	assert.Equal(t, audit.ReasonUnknownUser, unknown.Reason)
+	assert.Equal(t, audit.HashIdentifier("nobody@bar.com"), unknown.Identifier)
+	assert.Equal(t, "10.0.0.1", unknown.IP)
+	assert.Equal(t, audit.ReasonInvalidPassword, wrongPassword.Reason)
+	assert.Equal(t, "user-id", wrongPassword.SubjectID)
+	assert.NotContains(t, wrongPassword.Identifier, "foo@bar.com")
+}
+
+func TestService_Login_AuditsChallenge(t *testing.T) {
+	hashed, _ := testHasher.Hash("pass")
+	repo := &mocks.MockRepo{
+		FindUserByCredentialsFunc: func(ctx context.Context, identifier string) (*user.User, error) {
+			return &user.User{ID: "user-id", Password: hashed}, nil
+		},
+	}
+	events := auditRepo(repo)
+	svc := newTestService(t, repo)
+	secret, _ := totp.GenerateSecret()
+	repo.FindMFAFunc = func(ctx context.Context, userID string) (*user.MFA, error) {
+		return enabledMFA(t, svc, secret), nil
+	}
+
+	_, err := svc.Login(context.Background(), "foo", "pass")
+	assert.NoError(t, err)
+
+	assert.Len(t, *events, 1)
+	assert.Equal(t, audit.OutcomeChallenged, (*events)[0].Outcome)
+	assert.Equal(t, "password", (*events)[0].Details["method"])
+}
+
+func TestService_Logout(t *testing.T) {
+	repo := &mocks.MockRepo{
+		DeleteRefreshTokenFunc: func(ctx context.Context, token string) (string, error) {
+			if token == "refresh:live" {
+				return "user-id", nil
+			}
+
+			return "", key.ErrExpiredToken
+		},
+	}
+	events := auditRepo(repo)
+	svc := newTestService(t, repo)
+
+	assert.NoError(t, svc.Logout(context.Background(), "live"))
+	// logging out twice is not an error
+	assert.NoError(t, svc.Logout(context.Background(), "gone"))
+	assert.ErrorIs(t, svc.Logout(context.Background(), ""), key.ErrBadRequest)
+
+	assert.Len(t, *events, 2)
+	assert.Equal(t, audit.OutcomeSuccess, (*events)[0].Outcome)
+	assert.Equal(t, "user-id", (*events)[0].SubjectID)
+	assert.Equal(t, audit.OutcomeFailure, (*events)[1].Outcome)
+}
+
+func TestService_UpdateUser_AuditsRoleChange(t *testing.T) {
+	repo := &mocks.MockRepo{
+		UpdateUserFunc: func(ctx context.Context, u user.User) error { return nil },
+	}
+	events := auditRepo(repo)
+	svc := newTestService(t, repo)
+	ctx := context.WithValue(context.Background(), claimsContextKey, jwt.MapClaims{"sub": "admin-id", "role": user.RoleAdmin})
+
+	assert.NoError(t, svc.UpdateUser(ctx, user.User{ID: "user-id", Name: "name"}))
+	assert.Empty(t, *events)
+
+	assert.NoError(t, svc.UpdateUser(ctx, user.User{ID: "user-id", Role: user.RoleAdmin}))
+	assert.Len(t, *events, 1)
+	assert.Equal(t, audit.TypeRoleChange, (*events)[0].Type)
+	assert.Equal(t, "admin-id", (*events)[0].ActorID)
+	assert.Equal(t, "user-id", (*events)[0].SubjectID)
+	assert.Equal(t, user.RoleAdmin, (*events)[0].Details["role"])
+}
+
+func TestService_AuditSigningKey(t *testing.T) {
+	repo := &mocks.MockRepo{}
+	events := auditRepo(repo)
+	repo.ListAuditEventsFunc = func(ctx context.Context, filter audit.Filter) ([]audit.Event, error) {
+		assert.Equal(t, audit.TypeKeyRotation, filter.Type)
+		if len(*events) == 0 {
+			return nil, nil
+		}
+		return (*events)[len(*events)-1:], nil
+	}
+	svc := newTestService(t, repo)
+
+	svc.auditSigningKey(context.Background(), &svc.privateKey.PublicKey)
+	// restarting with the same key is not a rotation
+	svc.auditSigningKey(context.Background(), &svc.privateKey.PublicKey)
+	assert.Len(t, *events, 1)
+	assert.Equal(t, audit.ActorSystem, (*events)[0].ActorID)
+	assert.Len(t, (*events)[0].Details["fingerprint"], 64)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	svc.auditSigningKey(context.Background(), &other.PublicKey)
+	assert.Len(t, *events, 2)
+}
+
+func TestService_RecordImpersonation(t *testing.T) {
+	repo := &mocks.MockRepo{}
+	events := auditRepo(repo)
+	svc := newTestService(t, repo)
+
+	svc.RecordImpersonation(context.Background(), sharedhttp.ImpersonationEntry{Actor: "admin-id", Subject: "user-id", Method: http.MethodGet, Path: "/auth/me", Error: "boom"})
+
+	assert.Len(t, *events, 1)
+	assert.Equal(t, audit.TypeImpersonation, (*events)[0].Type)
+	assert.Equal(t, audit.OutcomeFailure, (*events)[0].Outcome)
+	assert.Equal(t, "admin-id", (*events)[0].ActorID)
+	assert.Equal(t, "/auth/me", (*events)[0].Details["path"])
+	assert.Equal(t, "boom", (*events)[0].Details["error"])
+}
+
+func TestService_RecordImpersonation_OtherService(t *testing.T) {
+	repo := &mocks.MockRepo{}
+	events := auditRepo(repo)
+	svc := newTestService(t, repo)
+
+	svc.RecordImpersonation(context.Background(), sharedhttp.ImpersonationEntry{Service: "auction-service", Actor: "admin-id", Subject: "user-id",
+		Method: http.MethodPost, Path: "/auctions", Status: http.StatusForbidden, At: time.Now()})
+
+	assert.Len(t, *events, 1)
+	assert.Equal(t, audit.OutcomeFailure, (*events)[0].Outcome)
+	assert.Equal(t, "auction-service", (*events)[0].Details["service"])
+	assert.Equal(t, "403", (*events)[0].Details["status"])
+	assert.NotEmpty(t, (*events)[0].Details["at"])
+}
+
+func TestService_Record_StoreFailureIsIgnored(t *testing.T) {
+	repo := &mocks.MockRepo{
+		DeleteRefreshTokenFunc: func(ctx context.Context, token string) (string, error) { return "user-id", nil },
+		AppendAuditEventFunc: func(ctx context.Context, event audit.Event) error {
+			return errors.New("db down")
+		},
+	}
+	svc := newTestService(t, repo)
+
+	assert.NoError(t, svc.Logout(context.Background(), "live"))
+}
+
+func TestService_Register_UserRegisteredEvent(t *testing.T) {
+	var created []outbox.Event
+	repo := &mocks.MockRepo{
+		CreateUserFunc: func(ctx context.Context, u user.User, events ...outbox.Event) error {
+			created = events
+			return nil
+		},
+		SaveRefreshTokenFunc: func(ctx context.Context, token string, session user.Session, ttl time.Duration) error {
+			return nil
+		},
+	}
+	svc := newTestService(t, repo)
+
+	_, _, err := svc.Register(context.Background(), user.User{Name: "foo", Email: "foo@bar.com", Password: "P@ssw0rd1234"})
+	assert.NoError(t, err)
+
+	assert.Len(t, created, 1)
+	assert.Equal(t, events.TopicUserRegistered, created[0].Topic)
+	assert.Equal(t, events.TypeUserRegistered, created[0].Envelope.Type)
+
+	registered, err := messaging.Payload[events.UserRegistered](created[0].Envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, created[0].AggregateID, registered.UserID)
+	assert.NotContains(t, string(created[0].Envelope.Payload), "foo")
+	assert.Equal(t, events.RegistrationPassword, registered.Method)
+}
+
+func TestService_DeleteUser_UserDeletedEvent(t *testing.T) {
+	var deleted []outbox.Event
+	repo := &mocks.MockRepo{
+		RevokeRefreshTokensFunc: func(ctx context.Context, userID string) error { return nil },
+		RevokeAccessTokensFunc: func(ctx context.Context, userID string, at time.Time, ttl time.Duration) error {
+			return nil
+		},
+		DeleteUserFunc: func(ctx context.Context, id string, events ...outbox.Event) error {
+			deleted = events
+			return nil
+		},
+	}
+	svc := newTestService(t, repo)
+
+	assert.NoError(t, svc.DeleteUser(context.Background(), "user-id"))
+
+	assert.Len(t, deleted, 1)
+	payload, err := messaging.Payload[events.UserDeleted](deleted[0].Envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, "user-id", payload.UserID)
+	assert.Equal(t, events.TopicUserDeleted, deleted[0].Topic)
+}
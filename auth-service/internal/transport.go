@@ -6,9 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ireuven89/auctions/auth-service/audit"
 	"github.com/ireuven89/auctions/auth-service/key"
+	"github.com/ireuven89/auctions/auth-service/oauth"
 
 	"github.com/ireuven89/auctions/auth-service/user"
 
@@ -30,20 +37,145 @@ type Transport struct {
 	s      Service
 }
 
-func (t *Transport) ListenAndServe(port string) {
+// ListenAndServe serves the routes, the client address of requests relayed by the trusted proxies is the
+// one they forwarded
+func (t *Transport) ListenAndServe(port string, trustedProxies []*net.IPNet) {
 	log.Printf("Starting auth server on port %s...", port)
-	err := http.ListenAndServe(":"+port, t.router)
+	err := http.ListenAndServe(":"+port, forwardedClient(trustedProxies)(t.router))
 	if err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
 }
 
+const bearerTokenContextKey contextKey = "bearer_token"
+
+// the request line is kept for the impersonation audit
+const requestMethodContextKey contextKey = "request_method"
+const requestPathContextKey contextKey = "request_path"
+
+// bearerToContext moves the bearer token from the Authorization header to the context for MakeAuthenticationMiddleware,
+// the client is kept as well for the audit of authenticated requests
+func bearerToContext(ctx context.Context, r *http.Request) context.Context {
+	ctx = clientToContext(ctx, r)
+	authHeader := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return ctx
+	}
+
+	ctx = context.WithValue(ctx, requestMethodContextKey, r.Method)
+	ctx = context.WithValue(ctx, requestPathContextKey, r.URL.Path)
+
+	return context.WithValue(ctx, bearerTokenContextKey, strings.TrimPrefix(authHeader, "Bearer "))
+}
+
+const userAgentContextKey contextKey = "user_agent"
+const clientIPContextKey contextKey = "client_ip"
+
+// ParseTrustedProxies reads the proxies of the server config, single addresses or CIDR ranges
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var trusted []*net.IPNet
+
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+
+			if ip == nil {
+				return nil, fmt.Errorf("ParseTrustedProxies invalid address %q", proxy)
+			}
+
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+
+		if err != nil {
+			return nil, fmt.Errorf("ParseTrustedProxies %w", err)
+		}
+
+		trusted = append(trusted, network)
+	}
+
+	return trusted, nil
+}
+
+// forwardedClient replaces the address of requests coming from a trusted proxy with the client it forwarded.
+// X-Forwarded-For is read from the right, every proxy appends the address it got the request from, and the
+// first address not trusted is the client. Anyone else could send any X-Forwarded-For, theirs is ignored
+func forwardedClient(trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	trusted := func(address string) bool {
+		ip := net.ParseIP(address)
+
+		for _, proxy := range trustedProxies {
+			if ip != nil && proxy.Contains(ip) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			peer, _, err := net.SplitHostPort(r.RemoteAddr)
+
+			if err != nil || !trusted(peer) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+			for i := len(forwarded) - 1; i >= 0; i-- {
+				client := strings.TrimSpace(forwarded[i])
+
+				if client == "" || trusted(client) {
+					continue
+				}
+
+				if net.ParseIP(client) != nil {
+					r = r.Clone(r.Context())
+					r.RemoteAddr = net.JoinHostPort(client, "0")
+				}
+				break
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientToContext keeps the caller's user agent and address so new sessions and audit events can be labeled,
+// see forwardedClient for the requests relayed by a proxy
+func clientToContext(ctx context.Context, r *http.Request) context.Context {
+	ip := r.RemoteAddr
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+
+	ctx = context.WithValue(ctx, userAgentContextKey, r.UserAgent())
+
+	return context.WithValue(ctx, clientIPContextKey, ip)
+}
+
+func clientFromContext(ctx context.Context) (string, string) {
+	userAgent, _ := ctx.Value(userAgentContextKey).(string)
+	ip, _ := ctx.Value(clientIPContextKey).(string)
+
+	return userAgent, ip
+}
+
 func RegisterRoutes(router *httprouter.Router, s Service) {
+	authenticated := MakeAuthenticationMiddleware(s)
+	admin := MakeAdminMiddleware()
+	notImpersonating := MakeDenyImpersonationMiddleware()
 
 	registerUserHandler := kithttp.NewServer(
 		MakeEndpointRegisterUser(s),
 		decodeRegisterUserRequest,
 		encodeRegisterUserResponse,
+		kithttp.ServerBefore(clientToContext),
 		kithttp.ServerErrorEncoder(errorEncoder),
 	)
 
@@ -51,6 +183,7 @@ func RegisterRoutes(router *httprouter.Router, s Service) {
 		MakeEndpointLogin(s),
 		decodeLoginRequest,
 		encodeLoginUserResponse,
+		kithttp.ServerBefore(clientToContext),
 		kithttp.ServerErrorEncoder(errorEncoder),
 	)
 
@@ -58,13 +191,15 @@ func RegisterRoutes(router *httprouter.Router, s Service) {
 		MakeEndpointRefreshToken(s),
 		decodeRefreshRequest,
 		encodeRefreshResponse,
+		kithttp.ServerBefore(clientToContext),
 		kithttp.ServerErrorEncoder(errorEncoder),
 	)
 
 	logoutHandler := kithttp.NewServer(
 		MakeEndpointLogout(s),
 		decodeLogoutRequest,
-		encodeLogoutUserResponse,
+		encodeNoContentResponse,
+		kithttp.ServerBefore(clientToContext),
 		kithttp.ServerErrorEncoder(errorEncoder),
 	)
 
@@ -75,12 +210,393 @@ func RegisterRoutes(router *httprouter.Router, s Service) {
 		kithttp.ServerErrorEncoder(errorEncoder),
 	)
 
+	forgotPasswordHandler := kithttp.NewServer(
+		MakeEndpointForgotPassword(s),
+		decodeForgotPasswordRequest,
+		encodeForgotPasswordResponse,
+		kithttp.ServerBefore(clientToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	resetPasswordHandler := kithttp.NewServer(
+		MakeEndpointResetPassword(s),
+		decodeResetPasswordRequest,
+		encodeNoContentResponse,
+		kithttp.ServerBefore(clientToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	enrollMFAHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointEnrollMFA(s))),
+		decodeEmptyRequest,
+		encodeEnrollMFAResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	confirmMFAHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointConfirmMFA(s))),
+		decodeMFACodeRequest,
+		encodeConfirmMFAResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	disableMFAHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointDisableMFA(s))),
+		decodeMFACodeRequest,
+		encodeNoContentResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	verifyMFAHandler := kithttp.NewServer(
+		MakeEndpointVerifyMFA(s),
+		decodeVerifyMFARequest,
+		encodeLoginUserResponse,
+		kithttp.ServerBefore(clientToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	getMeHandler := kithttp.NewServer(
+		authenticated(MakeEndpointGetMe(s)),
+		decodeEmptyRequest,
+		encodeUserResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	updateMeHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointUpdateMe(s))),
+		decodeUpdateProfileRequest,
+		encodeUserResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	deleteMeHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointDeleteMe(s))),
+		decodeDeleteMeRequest,
+		encodeNoContentResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	changePasswordHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointChangePassword(s))),
+		decodeChangePasswordRequest,
+		encodeNoContentResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	changeEmailHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointChangeEmail(s))),
+		decodeChangeEmailRequest,
+		encodeForgotPasswordResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	verifyEmailHandler := kithttp.NewServer(
+		MakeEndpointVerifyEmail(s),
+		decodeVerifyEmailRequest,
+		encodeNoContentResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	getUserHandler := kithttp.NewServer(
+		authenticated(admin(MakeEndpointGetUser(s))),
+		decodeUserIDRequest,
+		encodeUserResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	updateUserHandler := kithttp.NewServer(
+		authenticated(admin(MakeEndpointUpdateUser(s))),
+		decodeUpdateUserRequest,
+		encodeUserResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	deleteUserHandler := kithttp.NewServer(
+		authenticated(admin(MakeEndpointDeleteUser(s))),
+		decodeUserIDRequest,
+		encodeNoContentResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	listSessionsHandler := kithttp.NewServer(
+		authenticated(MakeEndpointListSessions(s)),
+		decodeEmptyRequest,
+		encodeListSessionsResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	revokeSessionHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointRevokeSession(s))),
+		decodeSessionIDRequest,
+		encodeNoContentResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	clientTokenHandler := kithttp.NewServer(
+		MakeEndpointClientToken(s),
+		decodeClientTokenRequest,
+		encodeClientTokenResponse,
+		kithttp.ServerErrorEncoder(tokenErrorEncoder),
+	)
+
+	createClientHandler := kithttp.NewServer(
+		authenticated(admin(MakeEndpointCreateClient(s))),
+		decodeCreateClientRequest,
+		encodeCreateClientResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	deleteClientHandler := kithttp.NewServer(
+		authenticated(admin(MakeEndpointDeleteClient(s))),
+		decodeClientIDRequest,
+		encodeNoContentResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	createAPIKeyHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointCreateAPIKey(s))),
+		decodeCreateAPIKeyRequest,
+		encodeCreateAPIKeyResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	listAPIKeysHandler := kithttp.NewServer(
+		authenticated(MakeEndpointListAPIKeys(s)),
+		decodeEmptyRequest,
+		encodeListAPIKeysResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	revokeAPIKeyHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointRevokeAPIKey(s))),
+		decodeAPIKeyIDRequest,
+		encodeNoContentResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	resolveAPIKeyHandler := kithttp.NewServer(
+		MakeEndpointResolveAPIKey(s),
+		decodeResolveAPIKeyRequest,
+		encodeResolveAPIKeyResponse,
+		kithttp.ServerErrorEncoder(resolveAPIKeyErrorEncoder),
+	)
+
+	discoveryHandler := kithttp.NewServer(
+		MakeEndpointDiscovery(s),
+		decodeEmptyRequest,
+		encodeDiscoveryResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	wellKnownJWKSHandler := kithttp.NewServer(
+		MakeEndpointGetPublicKey(s),
+		decodeGetPublicRequest,
+		encodeJWKSResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	userInfoHandler := kithttp.NewServer(
+		MakeEndpointUserInfo(s),
+		decodeEmptyRequest,
+		encodeUserInfoResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(bearerErrorEncoder),
+	)
+
+	introspectHandler := kithttp.NewServer(
+		MakeEndpointIntrospect(s),
+		decodeIntrospectRequest,
+		encodeIntrospectResponse,
+		kithttp.ServerErrorEncoder(tokenErrorEncoder),
+	)
+
+	requestMagicLinkHandler := kithttp.NewServer(
+		MakeEndpointRequestMagicLink(s),
+		decodeMagicLinkRequest,
+		encodeForgotPasswordResponse,
+		kithttp.ServerBefore(clientToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	consumeMagicLinkHandler := kithttp.NewServer(
+		MakeEndpointConsumeMagicLink(s),
+		decodeConsumeMagicLinkRequest,
+		encodeLoginUserResponse,
+		kithttp.ServerBefore(clientToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	startFederatedLoginHandler := kithttp.NewServer(
+		MakeEndpointStartFederatedLogin(s),
+		decodeFederatedLoginRequest,
+		encodeFederatedLoginResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	federatedCallbackHandler := kithttp.NewServer(
+		MakeEndpointFederatedCallback(s),
+		decodeFederatedCallbackRequest,
+		encodeLoginUserResponse,
+		kithttp.ServerBefore(clientToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	impersonateHandler := kithttp.NewServer(
+		authenticated(notImpersonating(admin(MakeEndpointImpersonate(s)))),
+		decodeImpersonateRequest,
+		encodeImpersonateResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	createOrganizationHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointCreateOrganization(s))),
+		decodeCreateOrganizationRequest,
+		encodeCreateOrganizationResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	listOrganizationsHandler := kithttp.NewServer(
+		authenticated(MakeEndpointListOrganizations(s)),
+		decodeEmptyRequest,
+		encodeListOrganizationsResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	listMembersHandler := kithttp.NewServer(
+		authenticated(MakeEndpointListMembers(s)),
+		decodeOrganizationIDRequest,
+		encodeListMembersResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	inviteMemberHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointInviteMember(s))),
+		decodeInviteMemberRequest,
+		encodeInviteMemberResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	acceptInvitationHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointAcceptInvitation(s))),
+		decodeAcceptInvitationRequest,
+		encodeAcceptInvitationResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	updateMemberRoleHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointUpdateMemberRole(s))),
+		decodeMemberRequest,
+		encodeNoContentResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	removeMemberHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointRemoveMember(s))),
+		decodeMemberRequest,
+		encodeNoContentResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	// the organization token would not carry the act claim, so impersonating admins can't switch
+	switchOrganizationHandler := kithttp.NewServer(
+		authenticated(notImpersonating(MakeEndpointSwitchOrganization(s))),
+		decodeOrganizationIDRequest,
+		encodeSwitchOrganizationResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	listAuditEventsHandler := kithttp.NewServer(
+		authenticated(admin(MakeEndpointListAuditEvents(s))),
+		decodeAuditEventsRequest,
+		encodeListAuditEventsResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	exportAuditEventsHandler := kithttp.NewServer(
+		authenticated(admin(MakeEndpointExportAuditEvents(s))),
+		decodeAuditEventsRequest,
+		encodeExportAuditEventsResponse,
+		kithttp.ServerBefore(bearerToContext),
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
 	router.Handler(http.MethodPost, "/auth/register", registerUserHandler)
 	router.Handler(http.MethodPost, "/auth/login", loginHandler)
 	router.Handler(http.MethodPost, "/auth/refresh", refreshHandler)
 	router.Handler(http.MethodPost, "/auth/logout", logoutHandler)
 	router.Handler(http.MethodGet, "/auth/jwks", publicKeyHandler)
-	router.Handler(http.MethodDelete, "/auth/user/:id", publicKeyHandler)
+	router.Handler(http.MethodPost, "/auth/password/forgot", forgotPasswordHandler)
+	router.Handler(http.MethodPost, "/auth/password/reset", resetPasswordHandler)
+	router.Handler(http.MethodPost, "/auth/2fa/enroll", enrollMFAHandler)
+	router.Handler(http.MethodPost, "/auth/2fa/confirm", confirmMFAHandler)
+	router.Handler(http.MethodPost, "/auth/2fa/disable", disableMFAHandler)
+	router.Handler(http.MethodPost, "/auth/2fa/verify", verifyMFAHandler)
+	router.Handler(http.MethodGet, "/auth/me", getMeHandler)
+	router.Handler(http.MethodPatch, "/auth/me", updateMeHandler)
+	router.Handler(http.MethodDelete, "/auth/me", deleteMeHandler)
+	router.Handler(http.MethodPost, "/auth/me/password", changePasswordHandler)
+	router.Handler(http.MethodPost, "/auth/me/email", changeEmailHandler)
+	router.Handler(http.MethodPost, "/auth/email/verify", verifyEmailHandler)
+	router.Handler(http.MethodGet, "/auth/users/:id", getUserHandler)
+	router.Handler(http.MethodPatch, "/auth/users/:id", updateUserHandler)
+	router.Handler(http.MethodDelete, "/auth/users/:id", deleteUserHandler)
+	router.Handler(http.MethodGet, "/auth/sessions", listSessionsHandler)
+	router.Handler(http.MethodDelete, "/auth/sessions/:id", revokeSessionHandler)
+	router.Handler(http.MethodPost, "/auth/token", clientTokenHandler)
+	router.Handler(http.MethodPost, "/auth/clients", createClientHandler)
+	router.Handler(http.MethodDelete, "/auth/clients/:id", deleteClientHandler)
+	router.Handler(http.MethodPost, "/auth/api-keys", createAPIKeyHandler)
+	router.Handler(http.MethodGet, "/auth/api-keys", listAPIKeysHandler)
+	router.Handler(http.MethodDelete, "/auth/api-keys/:id", revokeAPIKeyHandler)
+	router.Handler(http.MethodPost, "/auth/api-keys/resolve", resolveAPIKeyHandler)
+	router.Handler(http.MethodGet, "/.well-known/openid-configuration", discoveryHandler)
+	router.Handler(http.MethodGet, "/.well-known/jwks.json", wellKnownJWKSHandler)
+	router.Handler(http.MethodGet, "/auth/userinfo", userInfoHandler)
+	router.Handler(http.MethodPost, "/auth/userinfo", userInfoHandler)
+	router.Handler(http.MethodPost, "/auth/introspect", introspectHandler)
+	router.Handler(http.MethodPost, "/auth/magic-link", requestMagicLinkHandler)
+	router.Handler(http.MethodPost, "/auth/magic-link/consume", consumeMagicLinkHandler)
+	router.Handler(http.MethodGet, "/auth/oidc/:provider/login", startFederatedLoginHandler)
+	router.Handler(http.MethodGet, "/auth/oidc/:provider/callback", federatedCallbackHandler)
+	router.Handler(http.MethodPost, "/auth/impersonate/:userId", impersonateHandler)
+	router.Handler(http.MethodPost, "/auth/organizations", createOrganizationHandler)
+	router.Handler(http.MethodGet, "/auth/organizations", listOrganizationsHandler)
+	router.Handler(http.MethodGet, "/auth/organizations/:orgId/members", listMembersHandler)
+	router.Handler(http.MethodPatch, "/auth/organizations/:orgId/members/:userId", updateMemberRoleHandler)
+	router.Handler(http.MethodDelete, "/auth/organizations/:orgId/members/:userId", removeMemberHandler)
+	router.Handler(http.MethodPost, "/auth/organizations/:orgId/invitations", inviteMemberHandler)
+	router.Handler(http.MethodPost, "/auth/organizations/:orgId/token", switchOrganizationHandler)
+	router.Handler(http.MethodPost, "/auth/invitations/accept", acceptInvitationHandler)
+	router.Handler(http.MethodGet, "/auth/audit/events", listAuditEventsHandler)
+	router.Handler(http.MethodGet, "/auth/audit/events/export", exportAuditEventsHandler)
 }
 
 func decodeRegisterUserRequest(ctx context.Context, r *http.Request) (interface{}, error) {
@@ -134,38 +650,28 @@ func encodeLoginUserResponse(ctx context.Context, w http.ResponseWriter, respons
 		"refreshToken": res.RefreshToken,
 	}
 
+	if res.MFAToken != "" {
+		formatted = map[string]interface{}{
+			"mfaRequired": true,
+			"mfaToken":    res.MFAToken,
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 
 	return json.NewEncoder(w).Encode(&formatted)
 }
 
 func decodeLogoutRequest(ctx context.Context, r *http.Request) (interface{}, error) {
-	var req LoginRequestModel
+	var req LogoutRequestModel
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return nil, fmt.Errorf("decodeLoginRequest failed parsing request %w", err)
+		return nil, fmt.Errorf("decodeLogoutRequest failed parsing request %w", key.ErrBadRequest)
 	}
 
 	return req, nil
 }
 
-func encodeLogoutUserResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
-	res, ok := response.(LoginResponseModel)
-
-	if !ok {
-		return fmt.Errorf("encodeLoginUserResponse.failed casting response")
-	}
-
-	formatted := map[string]interface{}{
-		"token":        res.AccessToken,
-		"refreshToken": res.RefreshToken,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-
-	return json.NewEncoder(w).Encode(&formatted)
-}
-
 func decodeGetPublicRequest(ctx context.Context, r *http.Request) (interface{}, error) {
 
 	return nil, nil
@@ -213,20 +719,848 @@ func encodeRefreshResponse(ctx context.Context, w http.ResponseWriter, response
 	return json.NewEncoder(w).Encode(formatted)
 }
 
-func errorEncoder(ctx context.Context, err error, w http.ResponseWriter) {
-	switch {
-	case errors.Is(err, key.ErrUserNotFound),
-		errors.Is(err, key.ErrInvalidCredentials):
-		w.WriteHeader(http.StatusUnauthorized) // 401
+func decodeForgotPasswordRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req ForgotPasswordRequestModel
 
-	case errors.Is(err, key.ErrInvalidToken),
-		errors.Is(err, key.ErrExpiredToken):
-		w.WriteHeader(http.StatusUnauthorized) // 401
-	case errors.Is(err, key.ErrTooManyRequests):
-		w.WriteHeader(http.StatusTooManyRequests) //429
-	default:
-		w.WriteHeader(http.StatusInternalServerError) //500
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeForgotPasswordRequest failed parsing request %w", err)
 	}
-	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 
+	return req, nil
+}
+
+// encodeForgotPasswordResponse always answers 202 so the response doesn't reveal whether the user exists
+func encodeForgotPasswordResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	w.WriteHeader(http.StatusAccepted)
+
+	return nil
+}
+
+func decodeResetPasswordRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req ResetPasswordRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeResetPasswordRequest failed parsing request %w", err)
+	}
+
+	return req, nil
+}
+
+func encodeNoContentResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+func decodeEmptyRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	return nil, nil
+}
+
+func encodeEnrollMFAResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(EnrollMFAResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeEnrollMFAResponse failed casting response")
+	}
+
+	formatted := map[string]interface{}{
+		"secret": res.Secret,
+		"uri":    res.URI,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(formatted)
+}
+
+func decodeMFACodeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req MFACodeRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeMFACodeRequest failed parsing request %w", err)
+	}
+
+	return req, nil
+}
+
+func encodeConfirmMFAResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(ConfirmMFAResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeConfirmMFAResponse failed casting response")
+	}
+
+	formatted := map[string]interface{}{
+		"recoveryCodes": res.RecoveryCodes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(formatted)
 }
+
+func decodeVerifyMFARequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req VerifyMFARequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeVerifyMFARequest failed parsing request %w", err)
+	}
+
+	return req, nil
+}
+
+func encodeUserResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(UserResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeUserResponse failed casting response")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(res.User)
+}
+
+func decodeUpdateProfileRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req UpdateProfileRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeUpdateProfileRequest failed parsing request %w", err)
+	}
+
+	return req, nil
+}
+
+func decodeDeleteMeRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req DeleteMeRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeDeleteMeRequest failed parsing request %w", err)
+	}
+
+	return req, nil
+}
+
+func decodeChangePasswordRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req ChangePasswordRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeChangePasswordRequest failed parsing request %w", err)
+	}
+
+	return req, nil
+}
+
+func decodeChangeEmailRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req ChangeEmailRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeChangeEmailRequest failed parsing request %w", err)
+	}
+
+	return req, nil
+}
+
+func decodeVerifyEmailRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req VerifyEmailRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeVerifyEmailRequest failed parsing request %w", err)
+	}
+
+	return req, nil
+}
+
+func decodeUserIDRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	return UserIDRequestModel{
+		id: httprouter.ParamsFromContext(ctx).ByName("id"),
+	}, nil
+}
+
+func decodeUpdateUserRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req UpdateUserRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeUpdateUserRequest failed parsing request %w", err)
+	}
+
+	req.id = httprouter.ParamsFromContext(ctx).ByName("id")
+
+	return req, nil
+}
+
+func errorEncoder(ctx context.Context, err error, w http.ResponseWriter) {
+	switch {
+	case errors.Is(err, key.ErrUserNotFound),
+		errors.Is(err, key.ErrInvalidCredentials):
+		w.WriteHeader(http.StatusUnauthorized) // 401
+
+	case errors.Is(err, key.ErrInvalidToken),
+		errors.Is(err, key.ErrExpiredToken):
+		w.WriteHeader(http.StatusUnauthorized) // 401
+	case errors.Is(err, key.ErrTooManyRequests):
+		w.WriteHeader(http.StatusTooManyRequests) //429
+	case errors.Is(err, key.ErrInvalidMFACode):
+		w.WriteHeader(http.StatusUnauthorized) // 401
+	case errors.Is(err, key.ErrInvalidPassword),
+		errors.Is(err, key.ErrInvalidEmail),
+		errors.Is(err, key.ErrInvalidRole),
+		errors.Is(err, key.ErrBadRequest),
+		errors.Is(err, key.ErrMFANotEnabled):
+		w.WriteHeader(http.StatusBadRequest) //400
+	case errors.Is(err, key.ErrForbidden):
+		w.WriteHeader(http.StatusForbidden) //403
+	case errors.Is(err, key.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound) //404
+	case errors.Is(err, key.ErrMFAAlreadyEnabled),
+		errors.Is(err, key.ErrAlreadyExists):
+		w.WriteHeader(http.StatusConflict) //409
+	default:
+		w.WriteHeader(http.StatusInternalServerError) //500
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+
+}
+
+func encodeListSessionsResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(ListSessionsResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeListSessionsResponse failed casting response")
+	}
+
+	formatted := map[string]interface{}{
+		"sessions": res.Sessions,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+func decodeSessionIDRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	return SessionIDRequestModel{
+		id: httprouter.ParamsFromContext(ctx).ByName("id"),
+	}, nil
+}
+
+// decodeClientTokenRequest reads the form encoded token request, the client may authenticate with basic auth instead
+// of the client_id and client_secret parameters (RFC 6749 section 2.3.1)
+func decodeClientTokenRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("decodeClientTokenRequest failed parsing request %w", key.ErrBadRequest)
+	}
+
+	req := ClientTokenRequestModel{
+		GrantType:    r.PostForm.Get("grant_type"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+		Scopes:       oauth.ParseScopes(r.PostForm.Get("scope")),
+	}
+
+	if id, secret, ok := r.BasicAuth(); ok {
+		// basic auth credentials are form encoded before they are base64 encoded
+		var err error
+
+		if req.ClientID, err = url.QueryUnescape(id); err != nil {
+			return nil, key.ErrInvalidClient
+		}
+
+		if req.ClientSecret, err = url.QueryUnescape(secret); err != nil {
+			return nil, key.ErrInvalidClient
+		}
+	}
+
+	return req, nil
+}
+
+func encodeClientTokenResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(ClientTokenResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeClientTokenResponse failed casting response")
+	}
+
+	formatted := map[string]interface{}{
+		"access_token": res.AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(serviceTokenTTL.Seconds()),
+		"scope":        oauth.FormatScopes(res.Scopes),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+// tokenErrorEncoder answers with the error response of RFC 6749 section 5.2
+func tokenErrorEncoder(ctx context.Context, err error, w http.ResponseWriter) {
+	code := "server_error"
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, key.ErrInvalidClient):
+		code, status = "invalid_client", http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+	case errors.Is(err, key.ErrInvalidScope):
+		code, status = "invalid_scope", http.StatusBadRequest
+	case errors.Is(err, key.ErrUnsupportedGrantType):
+		code, status = "unsupported_grant_type", http.StatusBadRequest
+	case errors.Is(err, key.ErrBadRequest):
+		code, status = "invalid_request", http.StatusBadRequest
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func decodeCreateClientRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req CreateClientRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeCreateClientRequest failed parsing request %w", key.ErrBadRequest)
+	}
+
+	return req, nil
+}
+
+func encodeCreateClientResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(CreateClientResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeCreateClientResponse failed casting response")
+	}
+
+	formatted := map[string]interface{}{
+		"clientId":     res.ClientID,
+		"clientSecret": res.ClientSecret,
+		"scopes":       res.Scopes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+func decodeClientIDRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	return ClientIDRequestModel{
+		id: httprouter.ParamsFromContext(ctx).ByName("id"),
+	}, nil
+}
+
+func decodeCreateAPIKeyRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req CreateAPIKeyRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeCreateAPIKeyRequest failed parsing request %w", key.ErrBadRequest)
+	}
+
+	return req, nil
+}
+
+func encodeCreateAPIKeyResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(CreateAPIKeyResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeCreateAPIKeyResponse failed casting response")
+	}
+
+	formatted := map[string]interface{}{
+		"key":       res.Key,
+		"id":        res.APIKey.ID,
+		"name":      res.APIKey.Name,
+		"prefix":    res.APIKey.Prefix,
+		"scopes":    res.APIKey.Scopes,
+		"expiresAt": res.APIKey.ExpiresAt,
+		"createdAt": res.APIKey.CreatedAt,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+func encodeListAPIKeysResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(ListAPIKeysResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeListAPIKeysResponse failed casting response")
+	}
+
+	formatted := map[string]interface{}{
+		"apiKeys": res.APIKeys,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+func decodeAPIKeyIDRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	return APIKeyIDRequestModel{
+		id: httprouter.ParamsFromContext(ctx).ByName("id"),
+	}, nil
+}
+
+// decodeResolveAPIKeyRequest reads the key to resolve, the calling service authenticates as a registered client
+// with basic auth the same way it does on the token endpoint
+func decodeResolveAPIKeyRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req ResolveAPIKeyRequestModel
+
+	id, secret, ok := r.BasicAuth()
+
+	if !ok {
+		return nil, key.ErrInvalidClient
+	}
+
+	var err error
+
+	if req.ClientID, err = url.QueryUnescape(id); err != nil {
+		return nil, key.ErrInvalidClient
+	}
+
+	if req.ClientSecret, err = url.QueryUnescape(secret); err != nil {
+		return nil, key.ErrInvalidClient
+	}
+
+	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeResolveAPIKeyRequest failed parsing request %w", key.ErrBadRequest)
+	}
+
+	return req, nil
+}
+
+// resolveAPIKeyErrorEncoder answers a rejected client the way the token endpoint does, so the caller can tell
+// it apart from a rejected key
+func resolveAPIKeyErrorEncoder(ctx context.Context, err error, w http.ResponseWriter) {
+	if errors.Is(err, key.ErrInvalidClient) {
+		tokenErrorEncoder(ctx, err, w)
+		return
+	}
+
+	errorEncoder(ctx, err, w)
+}
+
+func encodeResolveAPIKeyResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(ResolveAPIKeyResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeResolveAPIKeyResponse failed casting response")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	return json.NewEncoder(w).Encode(res.Claims)
+}
+
+func encodeDiscoveryResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(DiscoveryResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeDiscoveryResponse failed casting response")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(res.Configuration)
+}
+
+// encodeJWKSResponse writes the key set in the RFC 7517 shape that third party libraries expect
+func encodeJWKSResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(GetPublicKeyResponse)
+
+	if !ok {
+		return fmt.Errorf("encodeJWKSResponse failed casting response")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(res.PublicKey)
+}
+
+func encodeUserInfoResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(UserInfoResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeUserInfoResponse failed casting response")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	return json.NewEncoder(w).Encode(res.Claims)
+}
+
+// bearerErrorEncoder adds the challenge RFC 6750 asks for when a bearer token is rejected
+func bearerErrorEncoder(ctx context.Context, err error, w http.ResponseWriter) {
+	if errors.Is(err, key.ErrInvalidToken) || errors.Is(err, key.ErrExpiredToken) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+
+	errorEncoder(ctx, err, w)
+}
+
+// decodeIntrospectRequest reads the form encoded introspection request, the caller authenticates as a
+// registered client the same way it does on the token endpoint
+func decodeIntrospectRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	tokenReq, err := decodeClientTokenRequest(ctx, r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	client := tokenReq.(ClientTokenRequestModel)
+
+	return IntrospectRequestModel{
+		Token:         r.PostForm.Get("token"),
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+		ClientID:      client.ClientID,
+		ClientSecret:  client.ClientSecret,
+	}, nil
+}
+
+func encodeIntrospectResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(IntrospectResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeIntrospectResponse failed casting response")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	return json.NewEncoder(w).Encode(res.Introspection)
+}
+
+func decodeMagicLinkRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req MagicLinkRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeMagicLinkRequest failed parsing request %w", key.ErrBadRequest)
+	}
+
+	return req, nil
+}
+
+func decodeConsumeMagicLinkRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req ConsumeMagicLinkRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeConsumeMagicLinkRequest failed parsing request %w", key.ErrBadRequest)
+	}
+
+	return req, nil
+}
+
+func decodeFederatedLoginRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	return FederatedLoginRequestModel{
+		provider: httprouter.ParamsFromContext(ctx).ByName("provider"),
+	}, nil
+}
+
+// encodeFederatedLoginResponse sends the browser on to the identity provider
+func encodeFederatedLoginResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(FederatedLoginResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeFederatedLoginResponse failed casting response")
+	}
+
+	w.Header().Set("Location", res.URL)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusFound)
+
+	return nil
+}
+
+func decodeFederatedCallbackRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+
+	return FederatedCallbackRequestModel{
+		provider: httprouter.ParamsFromContext(ctx).ByName("provider"),
+		Code:     query.Get("code"),
+		State:    query.Get("state"),
+		Error:    query.Get("error"),
+	}, nil
+}
+
+func decodeImpersonateRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	return ImpersonateRequestModel{
+		userID: httprouter.ParamsFromContext(ctx).ByName("userId"),
+	}, nil
+}
+
+func encodeImpersonateResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(ImpersonateResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeImpersonateResponse failed casting response")
+	}
+
+	formatted := map[string]interface{}{
+		"token":     res.AccessToken,
+		"expiresIn": res.ExpiresIn,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+func decodeCreateOrganizationRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req CreateOrganizationRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeCreateOrganizationRequest failed parsing request %w", key.ErrBadRequest)
+	}
+
+	return req, nil
+}
+
+func encodeCreateOrganizationResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(OrganizationResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeCreateOrganizationResponse failed casting response")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	return json.NewEncoder(w).Encode(&res.Organization)
+}
+
+func encodeListOrganizationsResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(ListOrganizationsResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeListOrganizationsResponse failed casting response")
+	}
+
+	formatted := map[string]interface{}{
+		"organizations": res.Organizations,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+func decodeOrganizationIDRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	return OrganizationIDRequestModel{
+		orgID: httprouter.ParamsFromContext(ctx).ByName("orgId"),
+	}, nil
+}
+
+func encodeListMembersResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(ListMembersResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeListMembersResponse failed casting response")
+	}
+
+	formatted := map[string]interface{}{
+		"members": res.Members,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+func decodeInviteMemberRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req InviteMemberRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeInviteMemberRequest failed parsing request %w", key.ErrBadRequest)
+	}
+
+	req.orgID = httprouter.ParamsFromContext(ctx).ByName("orgId")
+
+	return req, nil
+}
+
+func encodeInviteMemberResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(InvitationResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeInviteMemberResponse failed casting response")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	return json.NewEncoder(w).Encode(&res.Invitation)
+}
+
+func decodeAcceptInvitationRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req AcceptInvitationRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		return nil, fmt.Errorf("decodeAcceptInvitationRequest failed parsing request %w", key.ErrBadRequest)
+	}
+
+	return req, nil
+}
+
+func encodeAcceptInvitationResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(MemberResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeAcceptInvitationResponse failed casting response")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(&res.Member)
+}
+
+// decodeMemberRequest - the body is only read when there is one, removing a member has none
+func decodeMemberRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req MemberRequestModel
+
+	if r.Method != http.MethodDelete {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, fmt.Errorf("decodeMemberRequest failed parsing request %w", key.ErrBadRequest)
+		}
+	}
+
+	params := httprouter.ParamsFromContext(ctx)
+	req.orgID = params.ByName("orgId")
+	req.userID = params.ByName("userId")
+
+	return req, nil
+}
+
+func encodeSwitchOrganizationResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(SwitchOrganizationResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeSwitchOrganizationResponse failed casting response")
+	}
+
+	formatted := map[string]interface{}{
+		"token":     res.AccessToken,
+		"expiresIn": res.ExpiresIn,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+// decodeAuditEventsRequest reads the filter from the query, the identifier is searched by its hash like it is stored
+func decodeAuditEventsRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Type:       query.Get("type"),
+		Outcome:    query.Get("outcome"),
+		ActorID:    query.Get("actorId"),
+		SubjectID:  query.Get("subjectId"),
+		Identifier: audit.HashIdentifier(query.Get("identifier")),
+		IP:         query.Get("ip"),
+	}
+
+	for name, field := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			at, err := time.Parse(time.RFC3339, value)
+
+			if err != nil {
+				return nil, fmt.Errorf("decodeAuditEventsRequest invalid %s %w", name, key.ErrBadRequest)
+			}
+
+			*field = &at
+		}
+	}
+
+	if value := query.Get("before"); value != "" {
+		before, err := strconv.ParseInt(value, 10, 64)
+
+		if err != nil || before <= 0 {
+			return nil, fmt.Errorf("decodeAuditEventsRequest invalid before %w", key.ErrBadRequest)
+		}
+
+		filter.Before = before
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("decodeAuditEventsRequest invalid limit %w", key.ErrBadRequest)
+		}
+
+		filter.Limit = limit
+	}
+
+	return AuditEventsRequestModel{filter: filter}, nil
+}
+
+func encodeListAuditEventsResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(ListAuditEventsResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeListAuditEventsResponse failed casting response")
+	}
+
+	formatted := map[string]interface{}{
+		"events": res.Events,
+	}
+
+	if res.Next > 0 {
+		formatted["next"] = res.Next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+// encodeExportAuditEventsResponse writes one JSON event per line, once streaming started errors can only cut the export short
+func encodeExportAuditEventsResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(ExportAuditEventsResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeExportAuditEventsResponse failed casting response")
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-events.ndjson"`)
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	written := 0
+
+	return res.Stream(func(event audit.Event) error {
+		if err := encoder.Encode(&event); err != nil {
+			return err
+		}
+
+		// flush every now and then so large exports reach the client while they are read
+		if written++; flusher != nil && written%exportFlushEvery == 0 {
+			flusher.Flush()
+		}
+
+		return nil
+	})
+}
+
+const exportFlushEvery = 100
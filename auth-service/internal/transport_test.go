@@ -4,10 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ireuven89/auctions/auth-service/audit"
+	"github.com/ireuven89/auctions/auth-service/key"
+	"github.com/ireuven89/auctions/auth-service/oidc"
 	user2 "github.com/ireuven89/auctions/auth-service/user"
 	"github.com/ireuven89/auctions/shared/jwksprovider"
+	"github.com/julienschmidt/httprouter"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -199,3 +208,329 @@ func TestEncodeRefreshResponse_Success(t *testing.T) {
 		t.Errorf("unexpected output: %+v", m)
 	}
 }
+
+func TestEncodeForgotPasswordResponse_Accepted(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := encodeForgotPasswordResponse(context.Background(), w, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.Code != http.StatusAccepted {
+		t.Errorf("expected status 202, got %d", w.Code)
+	}
+}
+
+func TestDecodeResetPasswordRequest_Success(t *testing.T) {
+	body, _ := json.Marshal(map[string]string{"token": "tok", "password": "pass"})
+	r := httptest.NewRequest(http.MethodPost, "/auth/password/reset", bytes.NewReader(body))
+	req, err := decodeResetPasswordRequest(context.Background(), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := req.(ResetPasswordRequestModel)
+	if got.Token != "tok" || got.Password != "pass" {
+		t.Errorf("unexpected request: %+v", got)
+	}
+}
+
+func TestEncodeLoginUserResponse_MFARequired(t *testing.T) {
+	w := httptest.NewRecorder()
+	if err := encodeLoginUserResponse(context.Background(), w, LoginResponseModel{MFAToken: "pending"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if m["mfaRequired"] != true || m["mfaToken"] != "pending" {
+		t.Errorf("unexpected output: %+v", m)
+	}
+	if _, ok := m["token"]; ok {
+		t.Errorf("access token must not be returned before the two-factor challenge: %+v", m)
+	}
+}
+
+func TestBearerToContext(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/auth/2fa/enroll", nil)
+	r.Header.Set("Authorization", "Bearer tok")
+
+	ctx := bearerToContext(context.Background(), r)
+	if got := ctx.Value(bearerTokenContextKey); got != "tok" {
+		t.Errorf("expected bearer token in context, got %v", got)
+	}
+}
+
+func TestEncodeUserResponse_NoPassword(t *testing.T) {
+	w := httptest.NewRecorder()
+	resp := UserResponseModel{User: user2.ToResponse(user2.User{ID: "id", Name: "foo", Password: "hash"})}
+	if err := encodeUserResponse(context.Background(), w, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bytes.Contains(w.Body.Bytes(), []byte("hash")) {
+		t.Errorf("password must not be encoded: %s", w.Body.String())
+	}
+}
+
+func TestClientToContext(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+	req.RemoteAddr = "192.168.1.5:51234"
+	req.Header.Set("User-Agent", "curl/8.4.0")
+
+	userAgent, ip := clientFromContext(clientToContext(context.Background(), req))
+	if userAgent != "curl/8.4.0" || ip != "192.168.1.5" {
+		t.Errorf("unexpected client %q %q", userAgent, ip)
+	}
+
+	// only a trusted proxy says who the client is
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	if _, ip = clientFromContext(clientToContext(context.Background(), req)); ip != "192.168.1.5" {
+		t.Errorf("expected the remote address, got %q", ip)
+	}
+}
+
+func TestForwardedClient(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.5"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var ip string
+	handler := forwardedClient(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ip = clientFromContext(clientToContext(r.Context(), r))
+	}))
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		expected  string
+	}{
+		{name: "direct", remote: "198.51.100.1:51234", expected: "198.51.100.1"},
+		{name: "spoofed by the client", remote: "198.51.100.1:51234", forwarded: "203.0.113.7", expected: "198.51.100.1"},
+		{name: "trusted proxy", remote: "192.168.1.5:51234", forwarded: "203.0.113.7", expected: "203.0.113.7"},
+		{name: "spoofed through the proxies", remote: "10.0.0.2:51234", forwarded: "1.2.3.4, 203.0.113.7, 10.0.0.3", expected: "203.0.113.7"},
+		{name: "trusted proxy without header", remote: "10.0.0.2:51234", expected: "10.0.0.2"},
+		{name: "malformed header", remote: "10.0.0.2:51234", forwarded: "unknown", expected: "10.0.0.2"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+			req.RemoteAddr = tc.remote
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if ip != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, ip)
+			}
+		})
+	}
+
+	if _, err = ParseTrustedProxies([]string{"proxy.local"}); err == nil {
+		t.Error("expected an invalid proxy to fail")
+	}
+}
+
+func TestDecodeClientTokenRequest_BasicAuth(t *testing.T) {
+	form := url.Values{"grant_type": {"client_credentials"}, "scope": {"bidders:read users:read"}}
+	req := httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("auction-service", url.QueryEscape("s3cr3t+/="))
+
+	decoded, err := decodeClientTokenRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := ClientTokenRequestModel{
+		GrantType:    "client_credentials",
+		ClientID:     "auction-service",
+		ClientSecret: "s3cr3t+/=",
+		Scopes:       []string{"bidders:read", "users:read"},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("got %+v, want %+v", decoded, expected)
+	}
+}
+
+func TestTokenErrorEncoder(t *testing.T) {
+	w := httptest.NewRecorder()
+	tokenErrorEncoder(context.Background(), fmt.Errorf("MakeEndpointClientToken %w", key.ErrInvalidClient), w)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+
+	var body map[string]string
+	_ = json.NewDecoder(w.Body).Decode(&body)
+	if body["error"] != "invalid_client" {
+		t.Errorf("expected invalid_client, got %v", body)
+	}
+}
+
+func TestDecodeIntrospectRequest(t *testing.T) {
+	form := url.Values{"token": {"abc"}, "token_type_hint": {"refresh_token"}}
+	req := httptest.NewRequest(http.MethodPost, "/auth/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("gateway", "secret")
+
+	decoded, err := decodeIntrospectRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := IntrospectRequestModel{Token: "abc", TokenTypeHint: "refresh_token", ClientID: "gateway", ClientSecret: "secret"}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("got %+v, want %+v", decoded, expected)
+	}
+}
+
+func TestDecodeResolveAPIKeyRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/auth/api-keys/resolve", strings.NewReader(`{"key":"ak_plain"}`))
+	req.SetBasicAuth("auctions", "secret")
+
+	decoded, err := decodeResolveAPIKeyRequest(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := ResolveAPIKeyRequestModel{Key: "ak_plain", ClientID: "auctions", ClientSecret: "secret"}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("got %+v, want %+v", decoded, expected)
+	}
+
+	// callers that don't authenticate are refused before the key is looked at
+	req = httptest.NewRequest(http.MethodPost, "/auth/api-keys/resolve", strings.NewReader(`{"key":"ak_plain"}`))
+	if _, err = decodeResolveAPIKeyRequest(context.Background(), req); !errors.Is(err, key.ErrInvalidClient) {
+		t.Errorf("expected invalid client, got %v", err)
+	}
+}
+
+func TestEncodeIntrospectResponse_Inactive(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := encodeIntrospectResponse(context.Background(), w, IntrospectResponseModel{Introspection: oidc.Inactive})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.TrimSpace(w.Body.String()) != `{"active":false}` {
+		t.Errorf("inactive tokens must not carry any claims, got %s", w.Body.String())
+	}
+	if w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("expected no-store")
+	}
+}
+
+func TestBearerErrorEncoder(t *testing.T) {
+	w := httptest.NewRecorder()
+	bearerErrorEncoder(context.Background(), fmt.Errorf("MakeEndpointUserInfo %w", key.ErrExpiredToken), w)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+	if w.Header().Get("WWW-Authenticate") != `Bearer error="invalid_token"` {
+		t.Errorf("missing challenge, got %q", w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestEncodeDiscoveryResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := encodeDiscoveryResponse(context.Background(), w, DiscoveryResponseModel{Configuration: oidc.NewConfiguration("https://auth.example.com/")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var body map[string]interface{}
+	_ = json.NewDecoder(w.Body).Decode(&body)
+	if body["issuer"] != "https://auth.example.com" || body["jwks_uri"] != "https://auth.example.com/.well-known/jwks.json" {
+		t.Errorf("unexpected discovery document %v", body)
+	}
+}
+
+func TestEncodeFederatedLoginResponse_Redirects(t *testing.T) {
+	w := httptest.NewRecorder()
+	err := encodeFederatedLoginResponse(context.Background(), w, FederatedLoginResponseModel{URL: "https://idp.example.com/authorize?state=abc"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://idp.example.com/authorize?state=abc" {
+		t.Errorf("expected a redirect to the provider, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestDecodeFederatedCallbackRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/google/callback?code=c&state=s", nil)
+	ctx := context.WithValue(context.Background(), httprouter.ParamsKey, httprouter.Params{{Key: "provider", Value: "google"}})
+
+	decoded, err := decodeFederatedCallbackRequest(ctx, req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := FederatedCallbackRequestModel{provider: "google", Code: "c", State: "s"}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("got %+v, want %+v", decoded, expected)
+	}
+}
+
+func TestDecodeAuditEventsRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/auth/audit/events?type=login&outcome=failure&identifier=Foo@Bar.com&from=2024-01-01T00:00:00Z&before=42&limit=10", nil)
+
+	req, err := decodeAuditEventsRequest(context.Background(), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	filter := req.(AuditEventsRequestModel).filter
+	if filter.Type != audit.TypeLogin || filter.Outcome != audit.OutcomeFailure || filter.Before != 42 || filter.Limit != 10 {
+		t.Errorf("unexpected filter %+v", filter)
+	}
+	if filter.Identifier != audit.HashIdentifier("foo@bar.com") {
+		t.Errorf("expected the identifier to be hashed, got %s", filter.Identifier)
+	}
+	if filter.From == nil || filter.From.Year() != 2024 || filter.To != nil {
+		t.Errorf("unexpected range %v %v", filter.From, filter.To)
+	}
+
+	for _, query := range []string{"from=yesterday", "limit=-1", "before=abc"} {
+		r = httptest.NewRequest(http.MethodGet, "/auth/audit/events?"+query, nil)
+		if _, err = decodeAuditEventsRequest(context.Background(), r); !errors.Is(err, key.ErrBadRequest) {
+			t.Errorf("%s: expected bad request, got %v", query, err)
+		}
+	}
+}
+
+func TestEncodeExportAuditEventsResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	res := ExportAuditEventsResponseModel{
+		Stream: func(write func(audit.Event) error) error {
+			for _, id := range []int64{3, 2, 1} {
+				if err := write(audit.Event{ID: id, Type: audit.TypeLogout}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	}
+
+	if err := encodeExportAuditEventsResponse(context.Background(), w, res); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("unexpected content type %s", ct)
+	}
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(lines))
+	}
+
+	var event audit.Event
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil || event.ID != 3 {
+		t.Errorf("unexpected first line %s", lines[0])
+	}
+}
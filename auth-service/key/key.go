@@ -16,12 +16,28 @@ type JWK struct {
 type Token struct {
 	Access  string
 	Refresh string
+	// MFAPending is set instead of Access and Refresh when the user has to complete a two-factor challenge
+	MFAPending string
 }
 
 var (
 	ErrUserNotFound       = errors.New("user not found or credentials missing")
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrTooManyRequests    = errors.New("too many requests")
+	ErrInvalidPassword    = errors.New("invalid password")
+	ErrInvalidEmail       = errors.New("invalid email pattern")
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrAlreadyExists      = errors.New("user already exists")
+	ErrForbidden          = errors.New("forbidden")
+	ErrBadRequest         = errors.New("bad request")
+	ErrNotFound           = errors.New("resource not found")
+)
+
+// Two-factor errors
+var (
+	ErrInvalidMFACode    = errors.New("unauthorized: invalid two-factor code")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
 )
 
 // Authorization errors (token required)
@@ -29,3 +45,10 @@ var (
 	ErrInvalidToken = errors.New("unauthorized: invalid token")
 	ErrExpiredToken = errors.New("unauthorized: expired token")
 )
+
+// OAuth2 errors, the token endpoint reports them with the RFC 6749 error codes
+var (
+	ErrInvalidClient        = errors.New("invalid_client")
+	ErrInvalidScope         = errors.New("invalid_scope")
+	ErrUnsupportedGrantType = errors.New("unsupported_grant_type")
+)
@@ -0,0 +1,89 @@
+package magiclink
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ireuven89/auctions/shared/config"
+)
+
+const defaultTTL = 15 * time.Minute
+const defaultMaxRequests = 5
+const defaultWindow = time.Hour
+
+// Link is what the random token inside a link stands for. Only the hash of the token is stored, and the token
+// can only be exchanged for a login, it is never an access token
+type Link struct {
+	UserID string
+	// Fingerprint of the device the link was requested from
+	Fingerprint string
+}
+
+type Options struct {
+	URL         string
+	TTL         time.Duration
+	MaxRequests int
+	Window      time.Duration
+	BindDevice  bool
+}
+
+// NewOptions uses the defaults for whatever is not configured
+func NewOptions(cfg config.MagicLinkConfig) Options {
+	options := Options{
+		URL:         cfg.URL,
+		TTL:         cfg.TTL,
+		MaxRequests: cfg.MaxRequests,
+		Window:      cfg.Window,
+		BindDevice:  cfg.BindDevice,
+	}
+
+	if options.TTL == 0 {
+		options.TTL = defaultTTL
+	}
+
+	if options.MaxRequests == 0 {
+		options.MaxRequests = defaultMaxRequests
+	}
+
+	if options.Window == 0 {
+		options.Window = defaultWindow
+	}
+
+	return options
+}
+
+// Link builds the URL sent to the user, without a configured page the bare token is sent instead
+func (o Options) Link(token string) string {
+	if o.URL == "" {
+		return token
+	}
+
+	separator := "?"
+	if strings.Contains(o.URL, "?") {
+		separator = "&"
+	}
+
+	return o.URL + separator + "token=" + url.QueryEscape(token)
+}
+
+// Fingerprint identifies the device that asked for a link. The client may send its own device id,
+// otherwise the user agent is used. Only the hash is stored with the link
+func Fingerprint(deviceID, userAgent string) string {
+	source := "ua:" + userAgent
+	if deviceID != "" {
+		source = "id:" + deviceID
+	}
+
+	sum := sha256.Sum256([]byte(source))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Identifier normalizes the name or email a link is requested for, so rate limits can't be dodged by changing case
+func Identifier(identifier string) string {
+
+	return strings.ToLower(strings.TrimSpace(identifier))
+}
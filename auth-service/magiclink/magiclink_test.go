@@ -0,0 +1,29 @@
+package magiclink
+
+import (
+	"testing"
+
+	"github.com/ireuven89/auctions/shared/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOptions_Defaults(t *testing.T) {
+	options := NewOptions(config.MagicLinkConfig{})
+
+	assert.Equal(t, defaultTTL, options.TTL)
+	assert.Equal(t, defaultMaxRequests, options.MaxRequests)
+	assert.Equal(t, defaultWindow, options.Window)
+}
+
+func TestLink(t *testing.T) {
+	assert.Equal(t, "https://app.example.com/login?token=a%2Bb", Options{URL: "https://app.example.com/login"}.Link("a+b"))
+	assert.Equal(t, "https://app.example.com/login?src=mail&token=abc", Options{URL: "https://app.example.com/login?src=mail"}.Link("abc"))
+	assert.Equal(t, "abc", Options{}.Link("abc"))
+}
+
+func TestFingerprint(t *testing.T) {
+	assert.Equal(t, Fingerprint("", "Mozilla/5.0"), Fingerprint("", "Mozilla/5.0"))
+	assert.NotEqual(t, Fingerprint("", "Mozilla/5.0"), Fingerprint("", "curl/8.0"))
+	// a device id wins over the user agent
+	assert.Equal(t, Fingerprint("device", "Mozilla/5.0"), Fingerprint("device", "curl/8.0"))
+}
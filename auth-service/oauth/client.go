@@ -0,0 +1,57 @@
+package oauth
+
+import (
+	"strings"
+)
+
+const GrantClientCredentials = "client_credentials"
+
+// Client is a registered service allowed to use the client_credentials grant, the secret is only kept hashed
+type Client struct {
+	ID         string
+	Name       string
+	SecretHash string
+	Scopes     []string
+}
+
+// ParseScopes splits an OAuth2 scope parameter, scopes are separated by spaces
+func ParseScopes(scope string) []string {
+
+	return strings.Fields(scope)
+}
+
+func FormatScopes(scopes []string) string {
+
+	return strings.Join(scopes, " ")
+}
+
+// Allows reports whether every requested scope was granted to the client
+func (c Client) Allows(scopes []string) bool {
+	granted := make(map[string]struct{}, len(c.Scopes))
+	for _, scope := range c.Scopes {
+		granted[scope] = struct{}{}
+	}
+
+	for _, scope := range scopes {
+		if _, ok := granted[scope]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ValidScope accepts scopes made of printable ascii without spaces, quotes or backslashes, as RFC 6749 does
+func ValidScope(scope string) bool {
+	if scope == "" {
+		return false
+	}
+
+	for _, r := range scope {
+		if r < 0x21 || r > 0x7e || r == '"' || r == '\\' {
+			return false
+		}
+	}
+
+	return true
+}
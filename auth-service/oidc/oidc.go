@@ -0,0 +1,71 @@
+package oidc
+
+import (
+	"strings"
+)
+
+// Configuration is the OpenID Connect discovery document served at /.well-known/openid-configuration
+type Configuration struct {
+	Issuer                                    string   `json:"issuer"`
+	JWKSURI                                   string   `json:"jwks_uri"`
+	TokenEndpoint                             string   `json:"token_endpoint"`
+	UserinfoEndpoint                          string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint                     string   `json:"introspection_endpoint"`
+	ResponseTypesSupported                    []string `json:"response_types_supported"`
+	SubjectTypesSupported                     []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported          []string `json:"id_token_signing_alg_values_supported"`
+	GrantTypesSupported                       []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported         []string `json:"token_endpoint_auth_methods_supported"`
+	IntrospectionEndpointAuthMethodsSupported []string `json:"introspection_endpoint_auth_methods_supported"`
+	ClaimsSupported                           []string `json:"claims_supported"`
+}
+
+// NewConfiguration describes the endpoints of the auth-service reachable at issuer
+func NewConfiguration(issuer string) Configuration {
+	issuer = strings.TrimSuffix(issuer, "/")
+	clientAuth := []string{"client_secret_basic", "client_secret_post"}
+
+	return Configuration{
+		Issuer:                            issuer,
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		TokenEndpoint:                     issuer + "/auth/token",
+		UserinfoEndpoint:                  issuer + "/auth/userinfo",
+		IntrospectionEndpoint:             issuer + "/auth/introspect",
+		ResponseTypesSupported:            []string{"token"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		GrantTypesSupported:               []string{"client_credentials"},
+		TokenEndpointAuthMethodsSupported: clientAuth,
+		IntrospectionEndpointAuthMethodsSupported: clientAuth,
+		ClaimsSupported: []string{"iss", "sub", "exp", "iat", "jti", "email", "name", "preferred_username", "role", "org", "org_role", "act"},
+	}
+}
+
+// token_type_hint values of RFC 7662 section 2.1
+const (
+	HintAccessToken  = "access_token"
+	HintRefreshToken = "refresh_token"
+)
+
+// Introspection is the RFC 7662 response, an inactive token carries nothing but Active
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+	// Act names the admin behind an impersonation token (RFC 8693 section 4.1)
+	Act *Actor `json:"act,omitempty"`
+}
+
+type Actor struct {
+	Sub string `json:"sub"`
+}
+
+// Inactive is the answer for unknown, expired and revoked tokens alike, callers must not learn which one it was
+var Inactive = Introspection{Active: false}
@@ -0,0 +1,141 @@
+package organization
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	sharedhttp "github.com/ireuven89/auctions/shared/http"
+)
+
+// roles are shared with the services that read the org_role claim
+const (
+	RoleOwner   = sharedhttp.OrgRoleOwner
+	RoleManager = sharedhttp.OrgRoleManager
+	RoleViewer  = sharedhttp.OrgRoleViewer
+)
+
+// InvitationTTL is how long an invitation link can be accepted
+const InvitationTTL = 7 * 24 * time.Hour
+
+func ValidRole(role string) bool {
+
+	return role == RoleOwner || role == RoleManager || role == RoleViewer
+}
+
+// Organization is a seller account shared by a team, its ID is the seller_id of its auctions
+type Organization struct {
+	ID        string
+	Name      string
+	CreatedAt time.Time
+}
+
+type Member struct {
+	OrganizationID string
+	UserID         string
+	Email          string
+	Role           string
+	CreatedAt      time.Time
+}
+
+// CanManageMembers tells if the member may invite, change and remove members
+func (m Member) CanManageMembers() bool {
+
+	return m.Role == RoleOwner
+}
+
+// Membership is an organization as seen by one of its members
+type Membership struct {
+	Organization
+	Role string
+}
+
+// Invitation is sent by email, only the hash of the token in the link is stored
+type Invitation struct {
+	ID             string
+	OrganizationID string
+	Email          string
+	Role           string
+	TokenHash      string
+	InvitedBy      string
+	ExpiresAt      time.Time
+	AcceptedAt     *time.Time
+	CreatedAt      time.Time
+}
+
+func (i Invitation) Expired(now time.Time) bool {
+
+	return !now.Before(i.ExpiresAt)
+}
+
+// Email normalizes an invited address, so the invitation matches the account whatever the case
+func Email(email string) string {
+
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// InvitationLink builds the URL sent to the invited address, without a configured page the bare token is sent instead
+func InvitationLink(base, token string) string {
+	if base == "" {
+		return token
+	}
+
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+
+	return base + separator + "token=" + url.QueryEscape(token)
+}
+
+type OrganizationResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func ToResponse(membership Membership) OrganizationResponse {
+
+	return OrganizationResponse{
+		ID:        membership.ID,
+		Name:      membership.Name,
+		Role:      membership.Role,
+		CreatedAt: membership.CreatedAt,
+	}
+}
+
+type MemberResponse struct {
+	UserID    string    `json:"userId"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func ToMemberResponse(member Member) MemberResponse {
+
+	return MemberResponse{
+		UserID:    member.UserID,
+		Email:     member.Email,
+		Role:      member.Role,
+		CreatedAt: member.CreatedAt,
+	}
+}
+
+// InvitationResponse - Do not add the token hash to the response
+type InvitationResponse struct {
+	ID        string    `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func ToInvitationResponse(invitation Invitation) InvitationResponse {
+
+	return InvitationResponse{
+		ID:        invitation.ID,
+		Email:     invitation.Email,
+		Role:      invitation.Role,
+		ExpiresAt: invitation.ExpiresAt,
+	}
+}
@@ -0,0 +1,38 @@
+package organization
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidRole(t *testing.T) {
+	assert.True(t, ValidRole(RoleOwner))
+	assert.True(t, ValidRole(RoleManager))
+	assert.True(t, ValidRole(RoleViewer))
+	assert.False(t, ValidRole("admin"))
+	assert.False(t, ValidRole(""))
+}
+
+func TestInvitationLink(t *testing.T) {
+	assert.Equal(t, "tok", InvitationLink("", "tok"))
+	assert.Equal(t, "https://example.com/join?token=a%2Bb", InvitationLink("https://example.com/join", "a+b"))
+	assert.Equal(t, "https://example.com/join?team=1&token=tok", InvitationLink("https://example.com/join?team=1", "tok"))
+}
+
+func TestInvitation_Expired(t *testing.T) {
+	now := time.Now()
+	invitation := Invitation{ExpiresAt: now.Add(time.Minute)}
+
+	assert.False(t, invitation.Expired(now))
+	assert.True(t, invitation.Expired(now.Add(time.Minute)))
+}
+
+func TestToInvitationResponse_NoTokenHash(t *testing.T) {
+	b, err := json.Marshal(ToInvitationResponse(Invitation{ID: "id", Email: "foo@bar.com", Role: RoleViewer, TokenHash: "secret-hash"}))
+
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "secret-hash")
+}
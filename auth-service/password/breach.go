@@ -0,0 +1,329 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BreachChecker reports whether a password is part of a known breach corpus
+type BreachChecker interface {
+	IsBreached(ctx context.Context, password string) (bool, error)
+}
+
+// BreachedViolation is the PolicyError violation for passwords found by a BreachChecker
+const BreachedViolation = "has appeared in a data breach, choose a different password"
+
+var ErrInvalidIndex = errors.New("invalid breach index")
+var ErrUnsortedCorpus = errors.New("breach corpus must be sorted by hash")
+
+// the index keeps the first 8 bytes of every SHA-1, the chance of a false positive stays negligible
+// while the full HIBP corpus fits in a few GB. Layout:
+//
+//	magic | count uint64 | count sorted uint64 entries | bucket table
+//
+// the bucket table holds the first entry of every 5 hex digit prefix, like the HIBP range API
+var indexMagic = [8]byte{'P', 'W', 'I', 'D', 'X', 0, 0, 1}
+
+const prefixBits = 20
+const bucketCount = 1 << prefixBits
+const headerSize = 16
+const entrySize = 8
+
+// IndexChecker looks passwords up in an index built by BuildBreachIndex, entries are read from disk on demand
+type IndexChecker struct {
+	file    *os.File
+	buckets []uint64
+}
+
+func OpenBreachIndex(path string) (*IndexChecker, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("OpenBreachIndex %w", err)
+	}
+
+	checker, err := readIndex(file)
+
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("OpenBreachIndex %s %w", path, err)
+	}
+
+	return checker, nil
+}
+
+func readIndex(file *os.File) (*IndexChecker, error) {
+	header := make([]byte, headerSize)
+
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, ErrInvalidIndex
+	}
+
+	if [8]byte(header[:8]) != indexMagic {
+		return nil, ErrInvalidIndex
+	}
+
+	count := binary.BigEndian.Uint64(header[8:])
+	table := make([]byte, (bucketCount+1)*entrySize)
+
+	if _, err := file.ReadAt(table, int64(headerSize+count*entrySize)); err != nil {
+		return nil, ErrInvalidIndex
+	}
+
+	buckets := make([]uint64, bucketCount+1)
+	for i := range buckets {
+		buckets[i] = binary.BigEndian.Uint64(table[i*entrySize:])
+	}
+
+	if buckets[bucketCount] != count {
+		return nil, ErrInvalidIndex
+	}
+
+	return &IndexChecker{file: file, buckets: buckets}, nil
+}
+
+func (c *IndexChecker) IsBreached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	target := binary.BigEndian.Uint64(sum[:entrySize])
+	bucket := target >> (64 - prefixBits)
+
+	start, end := c.buckets[bucket], c.buckets[bucket+1]
+	entry := make([]byte, entrySize)
+	var readErr error
+
+	// binary search inside the bucket, a bucket of the full corpus holds about a thousand entries
+	i := sort.Search(int(end-start), func(i int) bool {
+		if readErr != nil {
+			return true
+		}
+
+		if _, err := c.file.ReadAt(entry, int64(headerSize+(start+uint64(i))*entrySize)); err != nil {
+			readErr = err
+			return true
+		}
+
+		return binary.BigEndian.Uint64(entry) >= target
+	})
+
+	if readErr != nil {
+		return false, fmt.Errorf("IndexChecker.IsBreached %w", readErr)
+	}
+
+	if uint64(i) == end-start {
+		return false, nil
+	}
+
+	if _, err := c.file.ReadAt(entry, int64(headerSize+(start+uint64(i))*entrySize)); err != nil {
+		return false, fmt.Errorf("IndexChecker.IsBreached %w", err)
+	}
+
+	return binary.BigEndian.Uint64(entry) == target, nil
+}
+
+func (c *IndexChecker) Close() error {
+
+	return c.file.Close()
+}
+
+// BuildBreachIndex converts a HIBP corpus into an index for OpenBreachIndex. The source is either a single file of
+// HASH:COUNT lines, as written by the PwnedPasswordsDownloader, or a directory of range files named by their
+// 5 hex digit prefix holding SUFFIX:COUNT lines. Hashes must be sorted, which both formats are
+func BuildBreachIndex(source, dst string) (uint64, error) {
+	info, err := os.Stat(source)
+
+	if err != nil {
+		return 0, fmt.Errorf("BuildBreachIndex %w", err)
+	}
+
+	out, err := os.Create(dst)
+
+	if err != nil {
+		return 0, fmt.Errorf("BuildBreachIndex %w", err)
+	}
+	defer out.Close()
+
+	writer := newIndexWriter(out)
+
+	if err = writer.writeHeader(); err != nil {
+		return 0, fmt.Errorf("BuildBreachIndex %w", err)
+	}
+
+	if info.IsDir() {
+		err = addRangeDir(writer, source)
+	} else {
+		err = addFile(writer, source, "")
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("BuildBreachIndex %w", err)
+	}
+
+	if err = writer.finish(); err != nil {
+		return 0, fmt.Errorf("BuildBreachIndex %w", err)
+	}
+
+	return writer.count, nil
+}
+
+func addRangeDir(writer *indexWriter, dir string) error {
+	entries, err := os.ReadDir(dir)
+
+	if err != nil {
+		return err
+	}
+
+	var prefixes []string
+	for _, entry := range entries {
+		prefix := strings.ToUpper(strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())))
+
+		if entry.IsDir() || len(prefix) != 5 {
+			continue
+		}
+
+		if _, err = hex.DecodeString(prefix + "0"); err != nil {
+			continue
+		}
+
+		prefixes = append(prefixes, entry.Name())
+	}
+
+	sort.Slice(prefixes, func(i, j int) bool {
+		return strings.ToUpper(prefixes[i]) < strings.ToUpper(prefixes[j])
+	})
+
+	for _, name := range prefixes {
+		prefix := strings.ToUpper(strings.TrimSuffix(name, filepath.Ext(name)))
+
+		if err = addFile(writer, filepath.Join(dir, name), prefix); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addFile(writer *indexWriter, path, prefix string) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(text, ":")
+		raw, err := hex.DecodeString(prefix + hash)
+
+		if err != nil || len(raw) != sha1.Size {
+			return fmt.Errorf("%s:%d invalid hash %q", path, line, hash)
+		}
+
+		if err = writer.add(binary.BigEndian.Uint64(raw[:entrySize])); err != nil {
+			return fmt.Errorf("%s:%d %w", path, line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+type indexWriter struct {
+	file    *os.File
+	out     *bufio.Writer
+	buckets []uint64
+	// filled is the first bucket whose start is not known yet
+	filled uint64
+	count  uint64
+	last   uint64
+}
+
+func newIndexWriter(file *os.File) *indexWriter {
+
+	return &indexWriter{
+		file:    file,
+		out:     bufio.NewWriterSize(file, 1<<20),
+		buckets: make([]uint64, bucketCount+1),
+	}
+}
+
+// writeHeader reserves the header, it is filled in by finish once the count is known
+func (w *indexWriter) writeHeader() error {
+	_, err := w.out.Write(make([]byte, headerSize))
+
+	return err
+}
+
+func (w *indexWriter) add(entry uint64) error {
+	if w.count > 0 {
+		if entry < w.last {
+			return ErrUnsortedCorpus
+		}
+
+		// different hashes sharing the first 8 bytes only need one entry
+		if entry == w.last {
+			return nil
+		}
+	}
+
+	// this entry starts its bucket and every empty bucket before it
+	for bucket := entry >> (64 - prefixBits); w.filled <= bucket; w.filled++ {
+		w.buckets[w.filled] = w.count
+	}
+
+	var buf [entrySize]byte
+	binary.BigEndian.PutUint64(buf[:], entry)
+
+	if _, err := w.out.Write(buf[:]); err != nil {
+		return err
+	}
+
+	w.count++
+	w.last = entry
+
+	return nil
+}
+
+func (w *indexWriter) finish() error {
+	for ; w.filled <= bucketCount; w.filled++ {
+		w.buckets[w.filled] = w.count
+	}
+
+	var buf [entrySize]byte
+	for _, start := range w.buckets {
+		binary.BigEndian.PutUint64(buf[:], start)
+
+		if _, err := w.out.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+
+	if err := w.out.Flush(); err != nil {
+		return err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, indexMagic[:])
+	binary.BigEndian.PutUint64(header[8:], w.count)
+
+	_, err := w.file.WriteAt(header, 0)
+
+	return err
+}
@@ -0,0 +1,106 @@
+package password
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var breached = []string{"password", "123456", "qwerty", "letmein", "P@ssw0rd"}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func sortedHashes() []string {
+	hashes := make([]string, 0, len(breached))
+	for _, password := range breached {
+		hashes = append(hashes, sha1Hex(password))
+	}
+	sort.Strings(hashes)
+
+	return hashes
+}
+
+func assertIndex(t *testing.T, path string) {
+	t.Helper()
+	checker, err := OpenBreachIndex(path)
+	assert.NoError(t, err)
+	defer checker.Close()
+
+	for _, password := range breached {
+		ok, err := checker.IsBreached(context.Background(), password)
+		assert.NoError(t, err)
+		assert.True(t, ok, password)
+	}
+
+	for _, password := range []string{"Sunny-Day-42", "correct horse battery staple", ""} {
+		ok, err := checker.IsBreached(context.Background(), password)
+		assert.NoError(t, err)
+		assert.False(t, ok, password)
+	}
+}
+
+func TestBuildBreachIndex_File(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "pwned.txt")
+
+	var lines []string
+	for i, hash := range sortedHashes() {
+		lines = append(lines, fmt.Sprintf("%s:%d", hash, i+1))
+	}
+	assert.NoError(t, os.WriteFile(source, []byte(strings.Join(lines, "\r\n")), 0600))
+
+	count, err := BuildBreachIndex(source, filepath.Join(dir, "pwned.idx"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(len(breached)), count)
+	assertIndex(t, filepath.Join(dir, "pwned.idx"))
+}
+
+func TestBuildBreachIndex_RangeDir(t *testing.T) {
+	dir := t.TempDir()
+	ranges := filepath.Join(dir, "ranges")
+	assert.NoError(t, os.Mkdir(ranges, 0700))
+
+	buckets := map[string][]string{}
+	for _, hash := range sortedHashes() {
+		buckets[hash[:5]] = append(buckets[hash[:5]], hash[5:]+":1")
+	}
+	for prefix, suffixes := range buckets {
+		assert.NoError(t, os.WriteFile(filepath.Join(ranges, prefix+".txt"), []byte(strings.Join(suffixes, "\n")), 0600))
+	}
+
+	count, err := BuildBreachIndex(ranges, filepath.Join(dir, "pwned.idx"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(len(breached)), count)
+	assertIndex(t, filepath.Join(dir, "pwned.idx"))
+}
+
+func TestBuildBreachIndex_Unsorted(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "pwned.txt")
+	hashes := sortedHashes()
+	hashes[0], hashes[1] = hashes[1], hashes[0]
+	assert.NoError(t, os.WriteFile(source, []byte(strings.Join(hashes, ":1\n")), 0600))
+
+	_, err := BuildBreachIndex(source, filepath.Join(dir, "pwned.idx"))
+	assert.ErrorIs(t, err, ErrUnsortedCorpus)
+}
+
+func TestOpenBreachIndex_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.idx")
+	assert.NoError(t, os.WriteFile(path, []byte("not an index"), 0600))
+
+	_, err := OpenBreachIndex(path)
+	assert.ErrorIs(t, err, ErrInvalidIndex)
+}
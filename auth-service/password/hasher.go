@@ -0,0 +1,263 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ireuven89/auctions/shared/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Algorithm hashes passwords into PHC formatted strings, e.g. $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type Algorithm interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) (bool, error)
+	// Supports reports whether the encoded hash was produced by this algorithm
+	Supports(encoded string) bool
+	// NeedsRehash reports whether the encoded hash was produced with other parameters than the current ones
+	NeedsRehash(encoded string) bool
+}
+
+// Hasher hashes new passwords with the current algorithm and still verifies hashes of the older ones
+type Hasher struct {
+	current    Algorithm
+	algorithms []Algorithm
+}
+
+func NewHasher(current Algorithm, legacy ...Algorithm) *Hasher {
+
+	return &Hasher{
+		current:    current,
+		algorithms: append([]Algorithm{current}, legacy...),
+	}
+}
+
+// NewHasherFromConfig uses argon2id unless bcrypt is configured, the other algorithm is kept for verifying
+func NewHasherFromConfig(cfg config.PasswordHashConfig) (*Hasher, error) {
+	argon := NewArgon2id(Argon2Params{
+		Memory:      cfg.Memory,
+		Iterations:  cfg.Iterations,
+		Parallelism: cfg.Parallelism,
+	})
+	bc := NewBcrypt(cfg.BcryptCost)
+
+	switch cfg.Algorithm {
+	case "", AlgorithmArgon2id:
+		return NewHasher(argon, bc), nil
+	case AlgorithmBcrypt:
+		return NewHasher(bc, argon), nil
+	}
+
+	return nil, fmt.Errorf("NewHasherFromConfig %s %w", cfg.Algorithm, ErrUnknownAlgorithm)
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+
+	return h.current.Hash(password)
+}
+
+// Verify checks the password, rehash is true when the hash should be replaced with one of the current algorithm
+func (h *Hasher) Verify(encoded, password string) (ok bool, rehash bool, err error) {
+	for _, algorithm := range h.algorithms {
+		if !algorithm.Supports(encoded) {
+			continue
+		}
+
+		ok, err = algorithm.Verify(encoded, password)
+
+		if err != nil || !ok {
+			return false, false, err
+		}
+
+		return true, algorithm != h.current || h.current.NeedsRehash(encoded), nil
+	}
+
+	return false, false, ErrUnknownAlgorithm
+}
+
+const AlgorithmArgon2id = "argon2id"
+const AlgorithmBcrypt = "bcrypt"
+
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation with some headroom
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+type Argon2id struct {
+	params Argon2Params
+}
+
+// NewArgon2id fills the zero params with DefaultArgon2Params
+func NewArgon2id(params Argon2Params) *Argon2id {
+	if params.Memory == 0 {
+		params.Memory = DefaultArgon2Params.Memory
+	}
+	if params.Iterations == 0 {
+		params.Iterations = DefaultArgon2Params.Iterations
+	}
+	if params.Parallelism == 0 {
+		params.Parallelism = DefaultArgon2Params.Parallelism
+	}
+	if params.SaltLength == 0 {
+		params.SaltLength = DefaultArgon2Params.SaltLength
+	}
+	if params.KeyLength == 0 {
+		params.KeyLength = DefaultArgon2Params.KeyLength
+	}
+
+	return &Argon2id{params: params}
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.params.SaltLength)
+
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("Argon2id.Hash failed generating salt %w", err)
+	}
+
+	hash := argon2.IDKey([]byte(password), salt, a.params.Iterations, a.params.Memory, a.params.Parallelism, a.params.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		AlgorithmArgon2id,
+		argon2.Version,
+		a.params.Memory,
+		a.params.Iterations,
+		a.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+func (a *Argon2id) Verify(encoded, password string) (bool, error) {
+	params, salt, hash, err := decodeArgon2id(encoded)
+
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(hash)))
+
+	return subtle.ConstantTimeCompare(hash, other) == 1, nil
+}
+
+func (a *Argon2id) Supports(encoded string) bool {
+
+	return strings.HasPrefix(encoded, "$"+AlgorithmArgon2id+"$")
+}
+
+func (a *Argon2id) NeedsRehash(encoded string) bool {
+	params, salt, hash, err := decodeArgon2id(encoded)
+
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != a.params.Memory ||
+		params.Iterations != a.params.Iterations ||
+		params.Parallelism != a.params.Parallelism ||
+		uint32(len(salt)) != a.params.SaltLength ||
+		uint32(len(hash)) != a.params.KeyLength
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+	var version int
+
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, hash
+	parts := strings.Split(encoded, "$")
+
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+
+	if err != nil || len(hash) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	return params, salt, hash, nil
+}
+
+// Bcrypt is kept for the hashes stored before argon2id, note bcrypt ignores everything after 72 bytes
+type Bcrypt struct {
+	cost int
+}
+
+// NewBcrypt uses bcrypt.DefaultCost when cost is 0
+func NewBcrypt(cost int) *Bcrypt {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+
+	return &Bcrypt{cost: cost}
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost)
+
+	if err != nil {
+		return "", fmt.Errorf("Bcrypt.Hash %w", err)
+	}
+
+	return string(hash), nil
+}
+
+func (b *Bcrypt) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("Bcrypt.Verify %w", ErrInvalidHash)
+	}
+
+	return true, nil
+}
+
+func (b *Bcrypt) Supports(encoded string) bool {
+
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+
+	return err != nil || cost != b.cost
+}
@@ -0,0 +1,72 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var fastArgon2Params = Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}
+
+func TestArgon2id_HashAndVerify(t *testing.T) {
+	argon := NewArgon2id(fastArgon2Params)
+
+	encoded, err := argon.Hash("correct horse")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	ok, err := argon.Verify(encoded, "correct horse")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = argon.Verify(encoded, "wrong horse")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	other, _ := argon.Hash("correct horse")
+	assert.NotEqual(t, encoded, other, "salt must be random")
+}
+
+func TestArgon2id_InvalidHash(t *testing.T) {
+	argon := NewArgon2id(fastArgon2Params)
+
+	for _, encoded := range []string{"", "$argon2id$", "$argon2id$v=19$m=a,t=1,p=1$c2FsdA$aGFzaA", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$aGFzaA"} {
+		_, err := argon.Verify(encoded, "password")
+		assert.ErrorIs(t, err, ErrInvalidHash, encoded)
+	}
+}
+
+func TestArgon2id_NeedsRehash(t *testing.T) {
+	encoded, _ := NewArgon2id(fastArgon2Params).Hash("password")
+
+	assert.False(t, NewArgon2id(fastArgon2Params).NeedsRehash(encoded))
+	assert.True(t, NewArgon2id(Argon2Params{Memory: 2048, Iterations: 1, Parallelism: 1}).NeedsRehash(encoded))
+}
+
+func TestHasher_Verify(t *testing.T) {
+	argon := NewArgon2id(fastArgon2Params)
+	bc := NewBcrypt(bcrypt.MinCost)
+	hasher := NewHasher(argon, bc)
+
+	legacy, _ := bc.Hash("password")
+	ok, rehash, err := hasher.Verify(legacy, "password")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, rehash, "bcrypt hashes should move to argon2id")
+
+	current, _ := hasher.Hash("password")
+	ok, rehash, err = hasher.Verify(current, "password")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, rehash, err = hasher.Verify(legacy, "wrong")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, rehash)
+
+	_, _, err = hasher.Verify("$md5$abc", "password")
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
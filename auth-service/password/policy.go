@@ -0,0 +1,148 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/ireuven89/auctions/auth-service/key"
+	"github.com/ireuven89/auctions/shared/config"
+)
+
+const defaultMinLength = 10
+const defaultMaxLength = 128
+
+// minPersonalInfoLength keeps short names from rejecting most passwords
+const minPersonalInfoLength = 3
+
+type Policy struct {
+	MinLength          int
+	MaxLength          int
+	RequireUpper       bool
+	RequireLower       bool
+	RequireDigit       bool
+	RequireSymbol      bool
+	RejectPersonalInfo bool
+}
+
+// NewPolicy uses the default lengths when they are not configured
+func NewPolicy(cfg config.PasswordPolicyConfig) Policy {
+	policy := Policy{
+		MinLength:          cfg.MinLength,
+		MaxLength:          cfg.MaxLength,
+		RequireUpper:       cfg.RequireUpper,
+		RequireLower:       cfg.RequireLower,
+		RequireDigit:       cfg.RequireDigit,
+		RequireSymbol:      cfg.RequireSymbol,
+		RejectPersonalInfo: cfg.RejectPersonalInfo,
+	}
+
+	if policy.MinLength == 0 {
+		policy.MinLength = defaultMinLength
+	}
+
+	if policy.MaxLength == 0 {
+		policy.MaxLength = defaultMaxLength
+	}
+
+	return policy
+}
+
+// PolicyError lists every rule the password broke, it matches key.ErrInvalidPassword
+type PolicyError struct {
+	Violations []string
+}
+
+func (e *PolicyError) Error() string {
+
+	return fmt.Sprintf("%s: %s", key.ErrInvalidPassword, strings.Join(e.Violations, "; "))
+}
+
+func (e *PolicyError) Unwrap() error {
+
+	return key.ErrInvalidPassword
+}
+
+// Validate checks the password against the policy, personalInfo holds the user's email and name
+func (p Policy) Validate(password string, personalInfo ...string) error {
+	var violations []string
+	length := utf8.RuneCountInString(password)
+
+	if length == 0 {
+		return &PolicyError{Violations: []string{"password is required"}}
+	}
+
+	if length < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters", p.MinLength))
+	}
+
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d characters", p.MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+
+	if p.RequireLower && !lower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+
+	if p.RequireDigit && !digit {
+		violations = append(violations, "must contain a digit")
+	}
+
+	if p.RequireSymbol && !symbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if p.RejectPersonalInfo && containsPersonalInfo(password, personalInfo) {
+		violations = append(violations, "must not contain your email or name")
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+func containsPersonalInfo(password string, personalInfo []string) bool {
+	lowered := strings.ToLower(password)
+
+	for _, info := range personalInfo {
+		info = strings.ToLower(strings.TrimSpace(info))
+		candidates := []string{info}
+
+		// the local part of an email is what people tend to reuse
+		if at := strings.Index(info, "@"); at > 0 {
+			candidates = append(candidates, info[:at])
+		}
+
+		// and so are the separate parts of a full name
+		candidates = append(candidates, strings.Fields(info)...)
+
+		for _, candidate := range candidates {
+			if utf8.RuneCountInString(candidate) >= minPersonalInfoLength && strings.Contains(lowered, candidate) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,60 @@
+package password
+
+import (
+	"testing"
+
+	"github.com/ireuven89/auctions/auth-service/key"
+	"github.com/ireuven89/auctions/shared/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Validate(t *testing.T) {
+	policy := Policy{
+		MinLength:          10,
+		MaxLength:          20,
+		RequireUpper:       true,
+		RequireLower:       true,
+		RequireDigit:       true,
+		RequireSymbol:      true,
+		RejectPersonalInfo: true,
+	}
+
+	tests := []struct {
+		name       string
+		password   string
+		violations []string
+	}{
+		{name: "valid", password: "Sunny-Day-42"},
+		{name: "empty", password: "", violations: []string{"password is required"}},
+		{name: "too short", password: "Ab1!", violations: []string{"must be at least 10 characters"}},
+		{name: "too long", password: "Abcdefghijklmnopqrs1!", violations: []string{"must be at most 20 characters"}},
+		{name: "classes", password: "abcdefghijkl", violations: []string{
+			"must contain an uppercase letter",
+			"must contain a digit",
+			"must contain a symbol",
+		}},
+		{name: "email local part", password: "Jdoe-Rocks-42", violations: []string{"must not contain your email or name"}},
+		{name: "name part", password: "Smith-2024-Pw", violations: []string{"must not contain your email or name"}},
+	}
+
+	for _, test := range tests {
+		err := policy.Validate(test.password, "jdoe@example.com", "John Smith")
+
+		if test.violations == nil {
+			assert.NoError(t, err, test.name)
+			continue
+		}
+
+		var policyErr *PolicyError
+		assert.ErrorIs(t, err, key.ErrInvalidPassword, test.name)
+		assert.ErrorAs(t, err, &policyErr, test.name)
+		assert.Equal(t, test.violations, policyErr.Violations, test.name)
+	}
+}
+
+func TestNewPolicy_Defaults(t *testing.T) {
+	policy := NewPolicy(config.PasswordPolicyConfig{})
+
+	assert.Error(t, policy.Validate("short"))
+	assert.NoError(t, policy.Validate("long enough password"))
+}
@@ -0,0 +1,79 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6238 appendix B test vectors for SHA1, truncated to 6 digits
+func TestGenerateCode_RFCVectors(t *testing.T) {
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+	}
+
+	for _, test := range tests {
+		code, err := GenerateCode(secret, time.Unix(test.unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, test.code, code, test.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+
+	now := time.Now()
+	code, err := GenerateCode(secret, now)
+	assert.NoError(t, err)
+
+	assert.True(t, Validate(secret, code, now))
+	assert.True(t, Validate(secret, code, now.Add(Period)), "previous period is accepted")
+	assert.False(t, Validate(secret, code, now.Add(3*Period)))
+	assert.False(t, Validate(secret, "12345", now))
+	assert.False(t, Validate("not base32!", code, now))
+}
+
+func TestStep(t *testing.T) {
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+
+	// 287082 is the code of the period [30, 60)
+	step, ok := Step(secret, "287082", time.Unix(59, 0))
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), step)
+
+	step, ok = Step(secret, "287082", time.Unix(89, 0))
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), step, "the step of the code, not of the time it is checked at")
+
+	_, ok = Step(secret, "287082", time.Unix(150, 0))
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Auctions", "foo@bar.com", "SECRET")
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Auctions:foo@bar.com?"))
+	assert.Contains(t, uri, "secret=SECRET")
+	assert.Contains(t, uri, "issuer=Auctions")
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	assert.NoError(t, err)
+	assert.Len(t, key, secretSize)
+}
@@ -0,0 +1,87 @@
+package user
+
+import (
+	"strings"
+	"time"
+)
+
+// Session is the metadata kept with every refresh token, ID is not the token itself and is safe to expose
+type Session struct {
+	ID         string
+	UserID     string
+	UserAgent  string
+	IP         string
+	Device     string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+}
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	Device     string    `json:"device"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+}
+
+func ToSessionResponse(session Session) SessionResponse {
+
+	return SessionResponse{
+		ID:         session.ID,
+		UserAgent:  session.UserAgent,
+		IP:         session.IP,
+		Device:     session.Device,
+		CreatedAt:  session.CreatedAt,
+		LastUsedAt: session.LastUsedAt,
+	}
+}
+
+var browsers = []struct{ token, name string }{
+	// order matters, Edge and Opera user agents also contain Chrome and Chrome's contains Safari
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"Firefox/", "Firefox"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+	{"curl/", "curl"},
+}
+
+var platforms = []struct{ token, name string }{
+	{"iPhone", "iOS"},
+	{"iPad", "iOS"},
+	{"Android", "Android"},
+	{"Windows", "Windows"},
+	{"Mac OS X", "macOS"},
+	{"Linux", "Linux"},
+}
+
+// DeviceLabel gives a short human readable name for a user agent, e.g. "Chrome on macOS"
+func DeviceLabel(userAgent string) string {
+	var browser, platform string
+
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, p := range platforms {
+		if strings.Contains(userAgent, p.token) {
+			platform = p.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+
+	return "Unknown device"
+}
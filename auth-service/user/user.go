@@ -4,27 +4,45 @@ import (
 	"encoding/json"
 )
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type User struct {
 	ID    string
 	Name  string
 	Email string
+	Role  string
 	// <-- do NOT include in public struct or JSON output
 	Password string
 	// <-- do NOT include in public struct or JSON output
 }
 
+func ValidRole(role string) bool {
+
+	return role == RoleUser || role == RoleAdmin
+}
+
 func (user *User) toString() string {
 	//Do not add the password to the ToString method
 	return "id:" + user.ID + "name:" + user.Name + "email:" + user.Email
 }
 
-// ToJson - Do not add the password to the ToJson method
-func ToJson(user User) string {
-	safeUser := UserResponse{
+// ToResponse - Do not add the password to the response
+func ToResponse(user User) UserResponse {
+
+	return UserResponse{
 		ID:       user.ID,
 		Username: user.Name,
 		Email:    user.Email,
+		Role:     user.Role,
 	}
+}
+
+// ToJson - Do not add the password to the ToJson method
+func ToJson(user User) string {
+	safeUser := ToResponse(user)
 	data, err := json.Marshal(safeUser)
 	if err != nil {
 		return ""
@@ -45,4 +63,12 @@ type UserResponse struct {
 	ID       string `json:"id"`
 	Username string `json:"username"`
 	Email    string `json:"email"`
+	Role     string `json:"role"`
+}
+
+// MFA holds the user's TOTP enrollment, Secret is encrypted
+type MFA struct {
+	UserID  string
+	Secret  string
+	Enabled bool
 }
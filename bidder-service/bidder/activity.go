@@ -0,0 +1,32 @@
+package bidder
+
+import "time"
+
+// the standing of a bid on an open auction
+const (
+	BidLeading = "leading"
+	BidOutbid  = "outbid"
+)
+
+// Activity is the bidding of a bidder as bidder-service learned it from the auction events
+type Activity struct {
+	BidderID   string
+	ActiveBids []ActiveBid
+	Won        []WonAuction
+	TotalSpend float64
+}
+
+// ActiveBid is the highest bid of the bidder on an auction still open
+type ActiveBid struct {
+	AuctionID     string
+	Amount        float64
+	PlacedAt      time.Time
+	Status        string
+	LeadingAmount float64
+}
+
+type WonAuction struct {
+	AuctionID string
+	FinalBid  float64
+	ClosedAt  time.Time
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/ireuven89/auctions/bidder-service/db"
 	"github.com/ireuven89/auctions/bidder-service/internal"
 	"github.com/ireuven89/auctions/shared/config"
+	"github.com/ireuven89/auctions/shared/events"
+	"github.com/ireuven89/auctions/shared/messaging"
 	"github.com/julienschmidt/httprouter"
 	"go.uber.org/zap"
 )
@@ -16,6 +19,12 @@ func main() {
 	if err != nil {
 		panic(fmt.Errorf("failed loading config %v", err))
 	}
+	publicKey, err := config.LoadRSAPublicKeyFromEnv()
+
+	if err != nil {
+		panic(fmt.Errorf("failed loading publicKey %v", err))
+	}
+
 	dbConn, err := db.MustNewDB(cfg.Sql.Host, cfg.Sql.User, cfg.Sql.Password, cfg.Sql.Port)
 
 	if err != nil {
@@ -26,9 +35,28 @@ func main() {
 	repo := db.NewRepository(dbConn, logger)
 	router := httprouter.New()
 
-	service := internal.NewService(repo, logger)
-	transport := internal.NewTransport(router, service)
+	service := internal.NewService(repo, db.NewActivityRepository(dbConn, logger), logger)
+	transport := internal.NewTransport(router, service, publicKey)
 
-	transport.ListenAndServe(cfg.Server.Port)
+	if cfg.Rabbit.URL != "" {
+		broker, err := messaging.DialAMQP(cfg.Rabbit)
 
+		if err != nil {
+			panic(fmt.Errorf("failed connecting broker %v", err))
+		}
+		defer broker.Close()
+
+		consumer := messaging.NewConsumer(broker, messaging.NewSQLProcessedStore(dbConn))
+		consumer.Handle(internal.UserEventsSubscription, messaging.DefaultRetryPolicy, events.Validating(internal.NewUserEventsHandler(service, logger)))
+		consumer.Handle(internal.BiddingEventsSubscription, messaging.DefaultRetryPolicy, events.Validating(internal.NewBiddingEventsHandler(service)))
+
+		go func() {
+			err := consumer.Run(context.Background())
+			logger.Error("consumer stopped", zap.Error(err))
+		}()
+	} else {
+		logger.Warn("rabbit.url is not set, bidders are not provisioned for new users and their activity is not projected")
+	}
+
+	transport.ListenAndServe(cfg.Server.Port)
 }
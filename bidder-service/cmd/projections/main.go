@@ -0,0 +1,54 @@
+// projections maintains the read models bidder-service projects from the auction events.
+//
+//	projections rebuild   empty the bidding activity and project the logged events again from the first
+//
+// Run it after changing how the events are projected. It reads the service config like the service
+// does, from APP_ENV and CONFIG_DIR
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ireuven89/auctions/bidder-service/db"
+	"github.com/ireuven89/auctions/shared/config"
+	"go.uber.org/zap"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "rebuild" {
+		fmt.Fprintln(os.Stderr, "usage: projections rebuild")
+		os.Exit(2)
+	}
+
+	if err := rebuild(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func rebuild() error {
+	cfg, err := config.LoadConfig()
+
+	if err != nil {
+		return err
+	}
+
+	dbConn, err := db.MustNewDB(cfg.Sql.Host, cfg.Sql.User, cfg.Sql.Password, cfg.Sql.Port)
+
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	count, err := db.NewActivityRepository(dbConn, zap.NewNop()).Rebuild(context.Background())
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("rebuilt the bidding activity from %d events\n", count)
+
+	return nil
+}
@@ -0,0 +1,303 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ireuven89/auctions/bidder-service/bidder"
+	"github.com/ireuven89/auctions/shared/events"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"go.uber.org/zap"
+)
+
+// rebuildBatchSize is how many logged events a rebuild reads at a time
+const rebuildBatchSize = 500
+
+// the status of an auction in auction_standings
+const (
+	standingOpen      = "open"
+	standingClosed    = "closed"
+	standingCancelled = "cancelled"
+)
+
+type ActivityRepository interface {
+	// Apply logs the event and projects it in one transaction, an event already logged is skipped. It tells
+	// whether the event was applied
+	Apply(ctx context.Context, envelope messaging.Envelope) (bool, error)
+	Activity(ctx context.Context, bidderID string) (bidder.Activity, error)
+	// Rebuild empties the projections and projects the logged events again from the first, it returns how many
+	Rebuild(ctx context.Context) (int, error)
+}
+
+// BiddingActivityRepository projects the auction events into the bidding activity of the bidders. The
+// projections converge whatever order the events arrive in: a bid only leads while it is the highest, and
+// an auction stays ended once it did
+type BiddingActivityRepository struct {
+	logger *zap.Logger
+	db     *sql.DB
+}
+
+func NewActivityRepository(db *sql.DB, logger *zap.Logger) ActivityRepository {
+
+	return &BiddingActivityRepository{
+		logger: logger,
+		db:     db,
+	}
+}
+
+func (r *BiddingActivityRepository) Apply(ctx context.Context, envelope messaging.Envelope) (bool, error) {
+	body, err := json.Marshal(envelope)
+
+	if err != nil {
+		return false, fmt.Errorf("BiddingActivityRepository.Apply %w", err)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return false, fmt.Errorf("BiddingActivityRepository.Apply %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "insert ignore into bidding_events (id, type, envelope) values (?, ?, ?)", envelope.ID, envelope.Type, body)
+
+	if err != nil {
+		return false, fmt.Errorf("BiddingActivityRepository.Apply failed logging %s %w", envelope.ID, err)
+	}
+
+	logged, err := res.RowsAffected()
+
+	if err != nil {
+		return false, fmt.Errorf("BiddingActivityRepository.Apply %w", err)
+	}
+
+	if logged == 0 {
+		r.logger.Debug("BiddingActivityRepository.Apply event already applied", zap.String("id", envelope.ID))
+		return false, nil
+	}
+
+	if err = project(ctx, tx, envelope); err != nil {
+		return false, fmt.Errorf("BiddingActivityRepository.Apply %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("BiddingActivityRepository.Apply %w", err)
+	}
+
+	return true, nil
+}
+
+// project applies the event to the projections, events of other types are only logged
+func project(ctx context.Context, tx *sql.Tx, envelope messaging.Envelope) error {
+	switch envelope.Type {
+	case events.TypeBidPlaced:
+		placed, err := messaging.Payload[events.BidPlaced](envelope)
+
+		if err != nil {
+			return err
+		}
+
+		// the leader is compared with the leading amount before it is raised
+		_, err = tx.ExecContext(ctx, `insert into auction_standings (auction_id, leader_id, leading_amount) values (?, ?, ?)
+			on duplicate key update leader_id = if(values(leading_amount) > leading_amount, values(leader_id), leader_id),
+			leading_amount = greatest(leading_amount, values(leading_amount))`,
+			placed.AuctionID, placed.BidderID, placed.Amount)
+
+		if err != nil {
+			return fmt.Errorf("failed projecting standing of %s %w", placed.AuctionID, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `insert into bidder_bids (auction_id, bidder_id, amount, placed_at) values (?, ?, ?, ?)
+			on duplicate key update placed_at = if(values(amount) > amount, values(placed_at), placed_at),
+			amount = greatest(amount, values(amount))`,
+			placed.AuctionID, placed.BidderID, placed.Amount, placed.PlacedAt)
+
+		if err != nil {
+			return fmt.Errorf("failed projecting bid of %s %w", placed.BidderID, err)
+		}
+	case events.TypeAuctionClosed:
+		closed, err := messaging.Payload[events.AuctionClosed](envelope)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `insert into auction_standings (auction_id, status, winner_id, final_bid, ended_at) values (?, ?, ?, ?, ?)
+			on duplicate key update status = values(status), winner_id = values(winner_id), final_bid = values(final_bid), ended_at = values(ended_at)`,
+			closed.AuctionID, standingClosed, nullable(closed.WinnerID), closed.FinalBid, closed.ClosedAt)
+
+		if err != nil {
+			return fmt.Errorf("failed projecting close of %s %w", closed.AuctionID, err)
+		}
+	case events.TypeAuctionCancelled:
+		cancelled, err := messaging.Payload[events.AuctionCancelled](envelope)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `insert into auction_standings (auction_id, status, ended_at) values (?, ?, ?)
+			on duplicate key update status = values(status), ended_at = values(ended_at)`,
+			cancelled.AuctionID, standingCancelled, cancelled.CancelledAt)
+
+		if err != nil {
+			return fmt.Errorf("failed projecting cancel of %s %w", cancelled.AuctionID, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *BiddingActivityRepository) Activity(ctx context.Context, bidderID string) (bidder.Activity, error) {
+	activity := bidder.Activity{BidderID: bidderID}
+
+	rows, err := r.db.QueryContext(ctx, `select b.auction_id, b.amount, b.placed_at, coalesce(s.leader_id, ''), s.leading_amount
+		from bidder_bids b join auction_standings s on s.auction_id = b.auction_id
+		where b.bidder_id = ? and s.status = ? order by b.placed_at desc`, bidderID, standingOpen)
+
+	if err != nil {
+		return bidder.Activity{}, fmt.Errorf("BiddingActivityRepository.Activity %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bid bidder.ActiveBid
+		var leaderID string
+
+		if err = rows.Scan(&bid.AuctionID, &bid.Amount, &bid.PlacedAt, &leaderID, &bid.LeadingAmount); err != nil {
+			return bidder.Activity{}, fmt.Errorf("BiddingActivityRepository.Activity %w", err)
+		}
+
+		bid.Status = bidder.BidOutbid
+		if leaderID == bidderID {
+			bid.Status = bidder.BidLeading
+		}
+
+		activity.ActiveBids = append(activity.ActiveBids, bid)
+	}
+
+	if err = rows.Err(); err != nil {
+		return bidder.Activity{}, fmt.Errorf("BiddingActivityRepository.Activity %w", err)
+	}
+
+	won, err := r.db.QueryContext(ctx, "select auction_id, final_bid, ended_at from auction_standings where winner_id = ? and status = ? order by ended_at desc",
+		bidderID, standingClosed)
+
+	if err != nil {
+		return bidder.Activity{}, fmt.Errorf("BiddingActivityRepository.Activity %w", err)
+	}
+	defer won.Close()
+
+	for won.Next() {
+		var auction bidder.WonAuction
+
+		if err = won.Scan(&auction.AuctionID, &auction.FinalBid, &auction.ClosedAt); err != nil {
+			return bidder.Activity{}, fmt.Errorf("BiddingActivityRepository.Activity %w", err)
+		}
+
+		activity.Won = append(activity.Won, auction)
+		activity.TotalSpend += auction.FinalBid
+	}
+
+	if err = won.Err(); err != nil {
+		return bidder.Activity{}, fmt.Errorf("BiddingActivityRepository.Activity %w", err)
+	}
+
+	return activity, nil
+}
+
+// Rebuild runs in one transaction, the projections read as before until it commits
+func (r *BiddingActivityRepository) Rebuild(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return 0, fmt.Errorf("BiddingActivityRepository.Rebuild %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, q := range []string{"delete from bidder_bids", "delete from auction_standings"} {
+		if _, err = tx.ExecContext(ctx, q); err != nil {
+			return 0, fmt.Errorf("BiddingActivityRepository.Rebuild %w", err)
+		}
+	}
+
+	var after int64
+	replayed := 0
+
+	for {
+		batch, last, err := loggedEvents(ctx, tx, after)
+
+		if err != nil {
+			return 0, fmt.Errorf("BiddingActivityRepository.Rebuild %w", err)
+		}
+
+		for _, envelope := range batch {
+			if err = project(ctx, tx, envelope); err != nil {
+				return 0, fmt.Errorf("BiddingActivityRepository.Rebuild %s %w", envelope.ID, err)
+			}
+		}
+
+		replayed += len(batch)
+		after = last
+
+		if len(batch) < rebuildBatchSize {
+			break
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("BiddingActivityRepository.Rebuild %w", err)
+	}
+
+	r.logger.Info("BiddingActivityRepository.Rebuild replayed events", zap.Int("count", replayed))
+
+	return replayed, nil
+}
+
+// loggedEvents reads a batch of the event log after the sequence number upcast to the latest versions,
+// and the last sequence number it read
+func loggedEvents(ctx context.Context, tx *sql.Tx, after int64) ([]messaging.Envelope, int64, error) {
+	rows, err := tx.QueryContext(ctx, "select seq, envelope from bidding_events where seq > ? order by seq limit ?", after, rebuildBatchSize)
+
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var batch []messaging.Envelope
+	last := after
+
+	for rows.Next() {
+		var envelope messaging.Envelope
+		var body []byte
+
+		if err = rows.Scan(&last, &body); err != nil {
+			return nil, 0, err
+		}
+
+		if err = json.Unmarshal(body, &envelope); err != nil {
+			return nil, 0, fmt.Errorf("malformed event %d %w", last, err)
+		}
+
+		// the log keeps the version consumed at the time, project sees the latest one like the consumer does
+		if events.DefaultRegistry.Latest(envelope.Type) != 0 {
+			if envelope, err = events.DefaultRegistry.Upcast(envelope); err != nil {
+				return nil, 0, fmt.Errorf("malformed event %d %w", last, err)
+			}
+		}
+
+		batch = append(batch, envelope)
+	}
+
+	return batch, last, rows.Err()
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+
+	return s
+}
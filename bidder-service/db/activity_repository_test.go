@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ireuven89/auctions/bidder-service/bidder"
+	"github.com/ireuven89/auctions/shared/events"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func bidPlaced(t *testing.T, placedAt time.Time) messaging.Envelope {
+	envelope, err := events.NewEnvelope(context.Background(), events.TypeBidPlaced, events.BidPlaced{
+		BidID: "bid", AuctionID: "auction", BidderID: "bidder", Amount: 15, PlacedAt: placedAt,
+	})
+	assert.NoError(t, err)
+
+	return envelope
+}
+
+func TestActivityRepository_Apply(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	repo := NewActivityRepository(db, zap.NewNop())
+	ctx := context.Background()
+	placedAt := time.Now().UTC()
+	envelope := bidPlaced(t, placedAt)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("insert ignore into bidding_events").WithArgs(envelope.ID, events.TypeBidPlaced, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("insert into auction_standings").WithArgs("auction", "bidder", 15.0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("insert into bidder_bids").WithArgs("auction", "bidder", 15.0, placedAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	applied, err := repo.Apply(ctx, envelope)
+	assert.NoError(t, err)
+	assert.True(t, applied)
+
+	// delivered again
+	mock.ExpectBegin()
+	mock.ExpectExec("insert ignore into bidding_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	applied, err = repo.Apply(ctx, envelope)
+	assert.NoError(t, err)
+	assert.False(t, applied)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestActivityRepository_Activity(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	repo := NewActivityRepository(db, zap.NewNop())
+	now := time.Now()
+
+	mock.ExpectQuery("select b.auction_id, b.amount, b.placed_at").WithArgs("bidder", standingOpen).
+		WillReturnRows(sqlmock.NewRows([]string{"auction_id", "amount", "placed_at", "leader_id", "leading_amount"}).
+			AddRow("leading", 20.0, now, "bidder", 20.0).
+			AddRow("outbid", 10.0, now, "other", 30.0))
+	mock.ExpectQuery("select auction_id, final_bid, ended_at from auction_standings").WithArgs("bidder", standingClosed).
+		WillReturnRows(sqlmock.NewRows([]string{"auction_id", "final_bid", "ended_at"}).
+			AddRow("won", 50.0, now).
+			AddRow("also-won", 12.5, now))
+
+	activity, err := repo.Activity(context.Background(), "bidder")
+	assert.NoError(t, err)
+	assert.Equal(t, []bidder.ActiveBid{
+		{AuctionID: "leading", Amount: 20, PlacedAt: now, Status: bidder.BidLeading, LeadingAmount: 20},
+		{AuctionID: "outbid", Amount: 10, PlacedAt: now, Status: bidder.BidOutbid, LeadingAmount: 30},
+	}, activity.ActiveBids)
+	assert.Len(t, activity.Won, 2)
+	assert.Equal(t, 62.5, activity.TotalSpend)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestActivityRepository_Rebuild(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	repo := NewActivityRepository(db, zap.NewNop())
+	placed := bidPlaced(t, time.Now().UTC())
+	closed, _ := events.NewEnvelope(context.Background(), events.TypeAuctionClosed, events.AuctionClosed{AuctionID: "auction", WinnerID: "bidder", FinalBid: 15})
+	placedBody, _ := json.Marshal(placed)
+	closedBody, _ := json.Marshal(closed)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("delete from bidder_bids").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("delete from auction_standings").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("select seq, envelope from bidding_events").WithArgs(0, rebuildBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"seq", "envelope"}).AddRow(1, placedBody).AddRow(2, closedBody))
+	mock.ExpectExec("insert into auction_standings").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("insert into bidder_bids").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("insert into auction_standings").WithArgs("auction", standingClosed, "bidder", 15.0, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	replayed, err := repo.Rebuild(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, replayed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestActivityRepository_Rebuild_InvalidLoggedEvent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	repo := NewActivityRepository(db, zap.NewNop())
+	placed := bidPlaced(t, time.Now().UTC())
+	placed.Payload = json.RawMessage(`{"bid_id": "bid"}`)
+	placedBody, _ := json.Marshal(placed)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("delete from bidder_bids").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("delete from auction_standings").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("select seq, envelope from bidding_events").WithArgs(0, rebuildBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"seq", "envelope"}).AddRow(1, placedBody))
+	mock.ExpectRollback()
+
+	_, err = repo.Rebuild(context.Background())
+	assert.ErrorIs(t, err, events.ErrInvalidPayload)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -46,7 +46,8 @@ func MustNewDB(host, user, password string, port int) (*sql.DB, error) {
 				return nil, err
 			}
 			//this connects to after db creation
-			db, err = sql.Open("mysql", dsn+databaseName)
+			// timestamps are scanned into time.Time
+			db, err = sql.Open("mysql", dsn+databaseName+"?parseTime=true")
 
 			if err != nil {
 				return nil, retry.RetryableError(err)
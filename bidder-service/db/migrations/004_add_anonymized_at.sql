@@ -0,0 +1,4 @@
+-- +goose Up
+
+-- bidders provisioned from auth-service share the id of their user, deleting the user anonymizes them
+alter table bidders add column anonymized_at timestamp null;
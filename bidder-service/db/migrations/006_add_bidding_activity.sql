@@ -0,0 +1,33 @@
+-- +goose Up
+
+-- the auction events the bidding activity is projected from, in the order they were received. The
+-- projections below can always be rebuilt from it, see cmd/projections
+create table if not exists bidding_events (
+    seq bigint unsigned auto_increment primary key,
+    id varchar(64) not null unique,
+    type varchar(255) not null,
+    envelope json not null,
+    received_at timestamp(6) not null default current_timestamp(6)
+);
+
+-- the highest bid of every bidder on every auction they bid on
+create table if not exists bidder_bids (
+    auction_id varchar(36) not null,
+    bidder_id varchar(36) not null,
+    amount double not null,
+    placed_at timestamp(6) not null,
+    primary key (auction_id, bidder_id),
+    index idx_bidder_bids_bidder (bidder_id)
+);
+
+-- who leads every auction and how it ended
+create table if not exists auction_standings (
+    auction_id varchar(36) primary key,
+    leader_id varchar(36) null,
+    leading_amount double not null default 0,
+    status varchar(16) not null default 'open',
+    winner_id varchar(36) null,
+    final_bid double not null default 0,
+    ended_at timestamp(6) null,
+    index idx_auction_standings_winner (winner_id)
+);
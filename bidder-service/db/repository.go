@@ -17,6 +17,10 @@ type Repository interface {
 	Update(ctx context.Context, bidder bidder.Bidder) error
 	Delete(ctx context.Context, id string) error
 	DeleteMany(ctx context.Context, ids []interface{}) error
+	// CreateIfAbsent creates the bidder unless its id or name is taken, and tells if it did
+	CreateIfAbsent(ctx context.Context, bidder bidder.Bidder) (bool, error)
+	// Anonymize replaces the personal data of the bidder, a bidder that doesn't exist yet is created anonymized
+	Anonymize(ctx context.Context, id string) error
 }
 
 type BidderRepository struct {
@@ -59,6 +63,43 @@ func (r *BidderRepository) Create(ctx context.Context, bidder bidder.Bidder) err
 	return nil
 }
 
+func (r *BidderRepository) CreateIfAbsent(ctx context.Context, bidder bidder.Bidder) (bool, error) {
+	q := "insert into bidders (id, name, item, download_link) values(?, ?, ?, '') on duplicate key update id = id"
+
+	res, err := r.db.ExecContext(ctx, q, bidder.ID, bidder.Name, bidder.Item)
+
+	if err != nil {
+		r.logger.Error("CreateIfAbsent failed inserting", zap.Error(err), zap.String("id", bidder.ID))
+		return false, fmt.Errorf("BidderRepository.CreateIfAbsent %w", err)
+	}
+
+	affected, err := res.RowsAffected()
+
+	if err != nil {
+		return false, fmt.Errorf("BidderRepository.CreateIfAbsent %w", err)
+	}
+
+	return affected == 1, nil
+}
+
+func (r *BidderRepository) Anonymize(ctx context.Context, id string) error {
+	q := `insert into bidders (id, name, item, download_link, anonymized_at) values(?, ?, '', '', current_timestamp)
+		  on duplicate key update name = values(name), item = '', download_link = '', anonymized_at = coalesce(anonymized_at, current_timestamp)`
+
+	if _, err := r.db.ExecContext(ctx, q, id, AnonymousName(id)); err != nil {
+		r.logger.Error("Anonymize failed updating", zap.Error(err), zap.String("id", id))
+		return fmt.Errorf("BidderRepository.Anonymize %w", err)
+	}
+
+	return nil
+}
+
+// AnonymousName is the name of an anonymized bidder, names are unique
+func AnonymousName(id string) string {
+
+	return "deleted-" + id
+}
+
 func (r *BidderRepository) Update(ctx context.Context, bidder bidder.Bidder) error {
 	q, args, err := prepareUpdateQuery(bidder)
 
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ireuven89/auctions/shared/events"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"go.uber.org/zap"
+)
+
+// UserEventsSubscription is the queue the user events auth-service publishes reach bidder-service on
+var UserEventsSubscription = messaging.Subscription{
+	Queue:  "bidder-service.users",
+	Topics: []string{events.TopicUserRegistered, events.TopicUserDeleted},
+}
+
+// NewUserEventsHandler keeps a bidder for every user, events may arrive more than once and the service
+// methods are idempotent
+func NewUserEventsHandler(s Service, logger *zap.Logger) messaging.Handler {
+	return func(ctx context.Context, msg messaging.Message) error {
+		switch msg.Type {
+		case events.TypeUserRegistered:
+			registered, err := messaging.Payload[events.UserRegistered](msg.Envelope)
+
+			if err != nil || registered.UserID == "" {
+				return messaging.Permanent(fmt.Errorf("malformed %s %s %v", msg.Type, msg.ID, err))
+			}
+
+			return s.ProvisionBidder(ctx, registered.UserID)
+		case events.TypeUserDeleted:
+			deleted, err := messaging.Payload[events.UserDeleted](msg.Envelope)
+
+			if err != nil || deleted.UserID == "" {
+				return messaging.Permanent(fmt.Errorf("malformed %s %s %v", msg.Type, msg.ID, err))
+			}
+
+			return s.AnonymizeBidder(ctx, deleted.UserID)
+		default:
+			logger.Debug("NewUserEventsHandler ignoring event", zap.String("type", msg.Type), zap.String("id", msg.ID))
+			return nil
+		}
+	}
+}
+
+// BiddingEventsSubscription is the queue the auction events the bidding activity is projected from reach bidder-service on
+var BiddingEventsSubscription = messaging.Subscription{
+	Queue:  "bidder-service.bidding",
+	Topics: []string{events.TopicBidPlaced, events.TopicAuctionClosed, events.TopicAuctionCancelled},
+}
+
+// NewBiddingEventsHandler projects the auction events into the bidding activity of the bidders
+func NewBiddingEventsHandler(s Service) messaging.Handler {
+	return func(ctx context.Context, msg messaging.Message) error {
+
+		return s.ApplyBiddingEvent(ctx, msg.Envelope)
+	}
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ireuven89/auctions/shared/events"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func userEvent(t *testing.T, eventType string, payload interface{}) messaging.Message {
+	envelope, err := messaging.NewEnvelope(context.Background(), eventType, 1, payload)
+	assert.NoError(t, err)
+
+	return messaging.Message{Envelope: envelope}
+}
+
+func TestUserEventsHandler(t *testing.T) {
+	svc := new(mockService)
+	handler := NewUserEventsHandler(svc, zap.NewNop())
+	ctx := context.Background()
+
+	svc.On("ProvisionBidder", mock.Anything, "user-id").Return(nil)
+	svc.On("AnonymizeBidder", mock.Anything, "user-id").Return(errors.New("db down"))
+
+	assert.NoError(t, handler(ctx, userEvent(t, events.TypeUserRegistered, events.UserRegistered{UserID: "user-id"})))
+
+	// failures are retried
+	err := handler(ctx, userEvent(t, events.TypeUserDeleted, events.UserDeleted{UserID: "user-id"}))
+	assert.Error(t, err)
+	assert.False(t, messaging.IsPermanent(err))
+
+	// malformed events are not
+	err = handler(ctx, userEvent(t, events.TypeUserRegistered, events.UserRegistered{}))
+	assert.True(t, messaging.IsPermanent(err))
+
+	assert.NoError(t, handler(ctx, userEvent(t, "UserLoggedIn", nil)))
+	svc.AssertExpectations(t)
+}
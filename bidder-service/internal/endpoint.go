@@ -141,3 +141,29 @@ func MakeEndpointDeleteBidders(s Service) endpoint.Endpoint {
 		return nil, nil
 	}
 }
+
+type GetBidderActivityRequestModel struct {
+	id string
+}
+
+type GetBidderActivityResponseModel struct {
+	activity bidder.Activity
+}
+
+func MakeEndpointGetBidderActivity(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(GetBidderActivityRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointGetBidderActivity failed parsing request")
+		}
+
+		activity, err := s.BidderActivity(ctx, req.id)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointGetBidderActivity %w", err)
+		}
+
+		return GetBidderActivityResponseModel{activity: activity}, nil
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/ireuven89/auctions/bidder-service/bidder"
+	"github.com/ireuven89/auctions/shared/messaging"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -37,6 +38,24 @@ func (m *mockService) SearchBidders(ctx context.Context, request bidder.BiddersR
 	return args.Get(0).([]bidder.Bidder), args.Error(1)
 }
 
+func (m *mockService) ProvisionBidder(ctx context.Context, userID string) error {
+	return m.Called(ctx, userID).Error(0)
+}
+
+func (m *mockService) AnonymizeBidder(ctx context.Context, userID string) error {
+	return m.Called(ctx, userID).Error(0)
+}
+
+func (m *mockService) BidderActivity(ctx context.Context, id string) (bidder.Activity, error) {
+	args := m.Called(ctx, id)
+
+	return args.Get(0).(bidder.Activity), args.Error(1)
+}
+
+func (m *mockService) ApplyBiddingEvent(ctx context.Context, envelope messaging.Envelope) error {
+	return m.Called(ctx, envelope).Error(0)
+}
+
 func (m *mockService) DeleteBidders(ctx context.Context, request []string) error {
 
 	return m.Called(ctx, request).Error(0)
@@ -128,3 +147,27 @@ func TestMakeEndpointGetBidders(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, response)
 }
+
+func TestMakeEndpointGetBidderActivity(t *testing.T) {
+	mockSvc := new(mockService)
+	ctx := context.Background()
+
+	activity := bidder.Activity{
+		BidderID:   "1",
+		ActiveBids: []bidder.ActiveBid{{AuctionID: "auction", Amount: 10, Status: bidder.BidLeading, LeadingAmount: 10}},
+		Won:        []bidder.WonAuction{{AuctionID: "won", FinalBid: 25}},
+		TotalSpend: 25,
+	}
+	mockSvc.On("BidderActivity", ctx, "1").Return(activity, nil)
+
+	endpoint := MakeEndpointGetBidderActivity(mockSvc)
+
+	resp, err := endpoint(ctx, GetBidderActivityRequestModel{id: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, GetBidderActivityResponseModel{activity: activity}, resp)
+
+	formatted := formatActivity(activity)
+	assert.Equal(t, 25.0, formatted["totalSpend"])
+	assert.Len(t, formatted["activeBids"], 1)
+	assert.Len(t, formatted["won"], 1)
+}
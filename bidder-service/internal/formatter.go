@@ -10,3 +10,32 @@ func formatBidder(bidder bidder.Bidder) map[string]interface{} {
 		"item": bidder.Item,
 	}
 }
+
+func formatActivity(activity bidder.Activity) map[string]interface{} {
+	activeBids := make([]map[string]interface{}, 0, len(activity.ActiveBids))
+	for _, bid := range activity.ActiveBids {
+		activeBids = append(activeBids, map[string]interface{}{
+			"auctionId":     bid.AuctionID,
+			"amount":        bid.Amount,
+			"placedAt":      bid.PlacedAt,
+			"status":        bid.Status,
+			"leadingAmount": bid.LeadingAmount,
+		})
+	}
+
+	won := make([]map[string]interface{}, 0, len(activity.Won))
+	for _, auction := range activity.Won {
+		won = append(won, map[string]interface{}{
+			"auctionId": auction.AuctionID,
+			"finalBid":  auction.FinalBid,
+			"closedAt":  auction.ClosedAt,
+		})
+	}
+
+	return map[string]interface{}{
+		"bidderId":   activity.BidderID,
+		"activeBids": activeBids,
+		"won":        won,
+		"totalSpend": activity.TotalSpend,
+	}
+}
@@ -2,11 +2,14 @@ package internal
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/google/uuid"
 	"github.com/ireuven89/auctions/bidder-service/bidder"
 	"github.com/ireuven89/auctions/bidder-service/db"
+	"github.com/ireuven89/auctions/shared/messaging"
 	"go.uber.org/zap"
 )
 
@@ -17,18 +20,24 @@ type Service interface {
 	DeleteBidders(ctx context.Context, ids []string) error
 	CreateBidder(ctx context.Context, bidder bidder.Bidder) (string, error)
 	UpdateBidder(ctx context.Context, bidder bidder.Bidder) error
+	ProvisionBidder(ctx context.Context, userID string) error
+	AnonymizeBidder(ctx context.Context, userID string) error
+	BidderActivity(ctx context.Context, id string) (bidder.Activity, error)
+	ApplyBiddingEvent(ctx context.Context, envelope messaging.Envelope) error
 }
 
 type BidderService struct {
-	repo   db.Repository
-	logger *zap.Logger
+	repo     db.Repository
+	activity db.ActivityRepository
+	logger   *zap.Logger
 }
 
-func NewService(repo db.Repository, logger *zap.Logger) Service {
+func NewService(repo db.Repository, activity db.ActivityRepository, logger *zap.Logger) Service {
 
 	return &BidderService{
-		repo:   repo,
-		logger: logger,
+		repo:     repo,
+		activity: activity,
+		logger:   logger,
 	}
 }
 
@@ -53,6 +62,60 @@ func (s *BidderService) CreateBidder(ctx context.Context, bidder bidder.Bidder)
 	return bidder.ID, nil
 }
 
+// ProvisionBidder creates the bidder of a newly registered user, keyed and named by the user ID until they
+// pick a name, so provisioning the same user again does nothing
+func (s *BidderService) ProvisionBidder(ctx context.Context, userID string) error {
+	created, err := s.repo.CreateIfAbsent(ctx, bidder.Bidder{ID: userID, Name: userID})
+
+	if err != nil {
+		return fmt.Errorf("BidderService.ProvisionBidder %w", err)
+	}
+
+	if created {
+		s.logger.Info("BidderService.ProvisionBidder created bidder", zap.String("id", userID))
+		return nil
+	}
+
+	if _, err = s.repo.Find(ctx, userID); errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("BidderService.ProvisionBidder name %s is taken", userID)
+	} else if err != nil {
+		return fmt.Errorf("BidderService.ProvisionBidder %w", err)
+	}
+
+	return nil
+}
+
+// AnonymizeBidder forgets the personal data of the bidder of a deleted user, the bids stay
+func (s *BidderService) AnonymizeBidder(ctx context.Context, userID string) error {
+	if err := s.repo.Anonymize(ctx, userID); err != nil {
+		s.logger.Error("BidderService.AnonymizeBidder failed", zap.Error(err), zap.String("id", userID))
+		return fmt.Errorf("BidderService.AnonymizeBidder %w", err)
+	}
+
+	return nil
+}
+
+// BidderActivity is the bidding of the bidder, as up to date as the auction events consumed so far
+func (s *BidderService) BidderActivity(ctx context.Context, id string) (bidder.Activity, error) {
+	activity, err := s.activity.Activity(ctx, id)
+
+	if err != nil {
+		s.logger.Error("BidderService.BidderActivity failed", zap.Error(err), zap.String("id", id))
+		return bidder.Activity{}, fmt.Errorf("BidderService.BidderActivity %w", err)
+	}
+
+	return activity, nil
+}
+
+// ApplyBiddingEvent projects an auction event into the bidding activity, an event applied before is skipped
+func (s *BidderService) ApplyBiddingEvent(ctx context.Context, envelope messaging.Envelope) error {
+	if _, err := s.activity.Apply(ctx, envelope); err != nil {
+		return fmt.Errorf("BidderService.ApplyBiddingEvent %w", err)
+	}
+
+	return nil
+}
+
 func generateID() string {
 
 	return uuid.New().String()
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"crypto/rsa"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -9,6 +10,7 @@ import (
 
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/ireuven89/auctions/bidder-service/bidder"
+	http2 "github.com/ireuven89/auctions/shared/http"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -21,13 +23,13 @@ type Router interface {
 	Handle(method, path string, handler http.Handler)
 }
 
-func NewTransport(router *httprouter.Router, s Service) Transport {
+func NewTransport(router *httprouter.Router, s Service, publicKey *rsa.PublicKey) Transport {
 	transport := Transport{
 		router: router,
 		s:      s,
 	}
 
-	RegisterRoutes(router, s)
+	RegisterRoutes(router, s, publicKey)
 
 	return transport
 }
@@ -40,7 +42,9 @@ func (t *Transport) ListenAndServe(port string) {
 	}
 }
 
-func RegisterRoutes(router *httprouter.Router, s Service) {
+// RegisterRoutes registers the bidder routes, the bidding activity is personal and needs the JWT of the bidder
+// or of a service
+func RegisterRoutes(router *httprouter.Router, s Service, publicKey *rsa.PublicKey) {
 
 	getBidderHandler := kithttp.NewServer(
 		MakeEndpointGetBidder(s),
@@ -78,7 +82,14 @@ func RegisterRoutes(router *httprouter.Router, s Service) {
 		kithttp.EncodeJSONResponse,
 	)
 
+	getBidderActivityHandler := kithttp.NewServer(
+		MakeEndpointGetBidderActivity(s),
+		decodeGetBidderActivityRequest,
+		encodeGetBidderActivityResponse,
+	)
+
 	router.Handler(http.MethodGet, "/bidders/:id", getBidderHandler)
+	router.Handler(http.MethodGet, "/bidders/:id/activity", http2.JWTMiddleware(publicKey, nil)(bidderOrService(getBidderActivityHandler)))
 	router.Handler(http.MethodGet, "/bidders", getBiddersHandler)
 	router.Handler(http.MethodPost, "/bidders", createBidderHandler)
 	router.Handler(http.MethodPut, "/bidders/:id", updateBidderHandler)
@@ -87,6 +98,22 @@ func RegisterRoutes(router *httprouter.Router, s Service) {
 
 }
 
+// bidderOrService lets through the bidder of the path, bidders are keyed by the ID of their user, and services
+func bidderOrService(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := http2.ClaimsFromContext(r.Context())
+		sub, _ := claims["sub"].(string)
+		id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+		if !http2.IsServiceToken(claims) && (sub == "" || sub != id) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func decodeGetBidderRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
 	id := httprouter.ParamsFromContext(ctx).ByName("id")
 
@@ -191,3 +218,21 @@ func decodeDeleteBiddersRequest(ctx context.Context, r *http.Request) (request i
 		ids: ids,
 	}, nil
 }
+
+func decodeGetBidderActivityRequest(ctx context.Context, r *http.Request) (request interface{}, err error) {
+	id := httprouter.ParamsFromContext(ctx).ByName("id")
+
+	return GetBidderActivityRequestModel{
+		id: id,
+	}, nil
+}
+
+func encodeGetBidderActivityResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(GetBidderActivityResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeGetBidderActivityResponse failed parsing response")
+	}
+
+	return json.NewEncoder(w).Encode(formatActivity(res.activity))
+}
@@ -1 +1,60 @@
 package internal
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ireuven89/auctions/bidder-service/bidder"
+	http2 "github.com/ireuven89/auctions/shared/http"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransport_BidderActivity(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	s := new(mockService)
+	s.On("BidderActivity", mock.Anything, "bidder-id").Return(bidder.Activity{BidderID: "bidder-id"}, nil)
+
+	router := httprouter.New()
+	RegisterRoutes(router, s, &key.PublicKey)
+
+	sign := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+		require.NoError(t, err)
+
+		return token
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{name: "no token", status: http.StatusUnauthorized},
+		{name: "the bidder", token: sign(jwt.MapClaims{"sub": "bidder-id"}), status: http.StatusOK},
+		{name: "another user", token: sign(jwt.MapClaims{"sub": "other-id"}), status: http.StatusForbidden},
+		{name: "a service", token: sign(jwt.MapClaims{"sub": "projections", "typ": http2.TokenTypeService}), status: http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/bidders/bidder-id/activity", nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.status, resp.Code)
+		})
+	}
+}
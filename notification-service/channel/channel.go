@@ -0,0 +1,25 @@
+// Package channel sends notifications out of notification-service. The inbox is not a channel here, the
+// notifications of the inbox are stored as they are raised
+package channel
+
+import (
+	"context"
+
+	"github.com/ireuven89/auctions/notification-service/notification"
+)
+
+// Message is what is sent to a user, one notification or a digest of several
+type Message struct {
+	UserID string
+	// To is the email address or webhook URL of the user
+	To      string
+	Subject string
+	Body    string
+	// Notifications are what the message tells, more than one when it is a digest
+	Notifications []notification.Notification
+}
+
+// Channel sends messages one way, an error fails the attempt and the message is tried again later
+type Channel interface {
+	Send(ctx context.Context, msg Message) error
+}
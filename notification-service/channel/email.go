@@ -0,0 +1,96 @@
+package channel
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ireuven89/auctions/shared/config"
+)
+
+type SMTPChannel struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPChannel(cfg config.MailConfig) Channel {
+	var auth smtp.Auth
+
+	if cfg.User != "" {
+		auth = smtp.PlainAuth("", cfg.User, cfg.Password, cfg.Host)
+	}
+
+	return &SMTPChannel{
+		addr: cfg.Host + ":" + strconv.Itoa(cfg.Port),
+		from: cfg.From,
+		auth: auth,
+	}
+}
+
+func (c *SMTPChannel) Send(ctx context.Context, msg Message) error {
+	if err := smtp.SendMail(c.addr, c.auth, c.from, []string{msg.To}, email(c.from, msg)); err != nil {
+		return fmt.Errorf("SMTPChannel.Send %w", err)
+	}
+
+	return nil
+}
+
+// FileOutbox writes every email to a file of its directory instead of sending it, used locally where there
+// is no SMTP server to read what users would be sent
+type FileOutbox struct {
+	dir  string
+	from string
+}
+
+func NewFileOutbox(dir, from string) (Channel, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("NewFileOutbox %w", err)
+	}
+
+	return &FileOutbox{dir: dir, from: from}, nil
+}
+
+func (o *FileOutbox) Send(ctx context.Context, msg Message) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000"), uuid.NewString())
+
+	if err := os.WriteFile(filepath.Join(o.dir, name), email(o.from, msg), 0o644); err != nil {
+		return fmt.Errorf("FileOutbox.Send %w", err)
+	}
+
+	return nil
+}
+
+// NewEmailChannel returns an SMTP channel when a mail host is configured and a file outbox otherwise
+func NewEmailChannel(cfg config.MailConfig) (Channel, error) {
+	if cfg.Host != "" {
+		return NewSMTPChannel(cfg), nil
+	}
+
+	dir := cfg.OutboxDir
+
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "notifications-outbox")
+	}
+
+	return NewFileOutbox(dir, cfg.From)
+}
+
+func email(from string, msg Message) []byte {
+	var body strings.Builder
+
+	body.WriteString("From: " + from + "\r\n")
+	body.WriteString("To: " + msg.To + "\r\n")
+	body.WriteString("Subject: " + msg.Subject + "\r\n")
+	body.WriteString("Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	body.WriteString(msg.Body)
+
+	return []byte(body.String())
+}
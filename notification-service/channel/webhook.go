@@ -0,0 +1,87 @@
+package channel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// WebhookChannel posts the messages as JSON to the URL the user registered in their preferences
+type WebhookChannel struct {
+	client *http.Client
+}
+
+func NewWebhookChannel() Channel {
+
+	return &WebhookChannel{client: &http.Client{Timeout: webhookTimeout}}
+}
+
+type webhookNotification struct {
+	ID        string      `json:"id"`
+	Kind      string      `json:"kind"`
+	AuctionID string      `json:"auctionId"`
+	Subject   string      `json:"subject"`
+	Data      interface{} `json:"data"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+type webhookBody struct {
+	UserID        string                `json:"userId"`
+	Subject       string                `json:"subject"`
+	Body          string                `json:"body"`
+	Notifications []webhookNotification `json:"notifications"`
+}
+
+// Send fails on any response but a 2xx
+func (c *WebhookChannel) Send(ctx context.Context, msg Message) error {
+	body := webhookBody{
+		UserID:        msg.UserID,
+		Subject:       msg.Subject,
+		Body:          msg.Body,
+		Notifications: make([]webhookNotification, 0, len(msg.Notifications)),
+	}
+
+	for _, n := range msg.Notifications {
+		body.Notifications = append(body.Notifications, webhookNotification{
+			ID:        n.ID,
+			Kind:      string(n.Kind),
+			AuctionID: n.AuctionID,
+			Subject:   n.Subject,
+			Data:      n.Data,
+			CreatedAt: n.CreatedAt,
+		})
+	}
+
+	payload, err := json.Marshal(body)
+
+	if err != nil {
+		return fmt.Errorf("WebhookChannel.Send %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.To, bytes.NewReader(payload))
+
+	if err != nil {
+		return fmt.Errorf("WebhookChannel.Send %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.client.Do(req)
+
+	if err != nil {
+		return fmt.Errorf("WebhookChannel.Send %w", err)
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("WebhookChannel.Send endpoint responded %s", res.Status)
+	}
+
+	return nil
+}
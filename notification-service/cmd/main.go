@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ireuven89/auctions/notification-service/channel"
+	"github.com/ireuven89/auctions/notification-service/db"
+	"github.com/ireuven89/auctions/notification-service/internal"
+	"github.com/ireuven89/auctions/notification-service/notification"
+	"github.com/ireuven89/auctions/shared/config"
+	"github.com/ireuven89/auctions/shared/encryption"
+	"github.com/ireuven89/auctions/shared/events"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/ireuven89/auctions/shared/messaging/outbox"
+	"github.com/ireuven89/auctions/shared/sqltx"
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+)
+
+func main() {
+	cfg, err := config.LoadConfig()
+
+	if err != nil {
+		panic(fmt.Errorf("failed loading config %v", err))
+	}
+
+	publicKey, err := config.LoadRSAPublicKeyFromEnv()
+
+	if err != nil {
+		panic(fmt.Errorf("failed loading publicKey %v", err))
+	}
+
+	logger, _ := zap.NewDevelopment()
+	dbConn, err := db.MustNewDB(cfg.Sql.Host, cfg.Sql.User, cfg.Sql.Password, cfg.Sql.Port, logger)
+
+	if err != nil {
+		panic(err)
+	}
+
+	templates, err := notification.NewTemplates()
+
+	if err != nil {
+		panic(err)
+	}
+
+	email, err := channel.NewEmailChannel(cfg.Mail)
+
+	if err != nil {
+		panic(err)
+	}
+
+	keyProvider, err := encryption.NewKeyProviderFromConfig(context.Background(), cfg.AWS, cfg.Encryption)
+
+	if err != nil {
+		panic(err)
+	}
+
+	fields, err := encryption.LoadFieldCipher(context.Background(), keyProvider, encryption.NewSQLKeyStore(dbConn))
+
+	if err != nil {
+		panic(fmt.Errorf("failed loading the personal data keys %v", err))
+	}
+
+	repo := db.NewRepository(dbConn, fields, logger)
+	router := httprouter.New()
+
+	service := internal.NewService(repo, sqltx.NewManager(dbConn), templates, internal.NewUnreadHub(), email, logger)
+	transport := internal.NewTransport(router, service)
+
+	channels := map[notification.Channel]channel.Channel{
+		notification.ChannelEmail:   email,
+		notification.ChannelWebhook: channel.NewWebhookChannel(),
+	}
+
+	go func() {
+		err := internal.NewDispatcher(repo, channels, templates, logger).Run(context.Background())
+		logger.Error("dispatcher stopped", zap.Error(err))
+	}()
+
+	if cfg.Rabbit.URL != "" {
+		broker, err := messaging.DialAMQP(cfg.Rabbit)
+
+		if err != nil {
+			panic(fmt.Errorf("failed connecting broker %v", err))
+		}
+		defer broker.Close()
+
+		go outbox.NewRelay(outbox.NewSQLStore(dbConn), broker).Run(context.Background())
+
+		consumer := messaging.NewConsumer(broker, messaging.NewSQLProcessedStore(dbConn))
+		consumer.Handle(internal.AuctionEventsSubscription, messaging.DefaultRetryPolicy, events.Validating(internal.NewAuctionEventsHandler(service)))
+		consumer.Handle(internal.UserEventsSubscription, messaging.DefaultRetryPolicy, events.Validating(internal.NewUserEventsHandler(service, logger)))
+
+		go func() {
+			err := consumer.Run(context.Background())
+			logger.Error("consumer stopped", zap.Error(err))
+		}()
+	} else {
+		logger.Warn("rabbit.url is not set, no notifications are raised and the impersonated requests are kept in the outbox")
+	}
+
+	// impersonated requests reach the audit log of auth-service through the outbox
+	transport.ListenAndServe(cfg.Server.Port, publicKey, events.NewOutboxImpersonationRecorder(dbConn, "notification-service"))
+}
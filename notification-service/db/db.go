@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/pressly/goose/v3"
+	"github.com/sethvargo/go-retry"
+	"go.uber.org/zap"
+)
+
+var migrationFolder = "/migrations"
+var databaseName = "notifications"
+
+func MustNewDB(host, user, password string, port int, logger *zap.Logger) (*sql.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/", user, password, host, port)
+
+	backoff := retry.WithMaxRetries(5, retry.NewExponential(3*time.Second))
+	var attempt int
+
+	db, err := retry.DoValue[*sql.DB](
+		context.Background(),
+		backoff,
+		func(ctx context.Context) (*sql.DB, error) {
+			attempt++
+
+			db, err := sql.Open("mysql", dsn)
+			if err != nil {
+				logger.Warn("MustNewDB failed opening the connection", zap.Int("attempt", attempt), zap.Error(err))
+				return nil, retry.RetryableError(fmt.Errorf("failed opening the connection %w", err))
+			}
+
+			if err := db.PingContext(ctx); err != nil {
+				logger.Warn("MustNewDB failed pinging the database", zap.Int("attempt", attempt), zap.Error(err))
+				return nil, retry.RetryableError(fmt.Errorf("failed pinging the database %w", err))
+			}
+
+			_, err = db.Exec(fmt.Sprintf("create database if not exists %s", databaseName))
+
+			if err != nil {
+				return nil, fmt.Errorf("failed creating the database %w", err)
+			}
+			//this connects to after db creation
+			// timestamps are scanned into time.Time
+			db, err = sql.Open("mysql", dsn+databaseName+"?parseTime=true")
+
+			if err != nil {
+				return nil, retry.RetryableError(fmt.Errorf("failed opening the database %w", err))
+			}
+
+			return db, nil
+		},
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("MustNewDB %w", err)
+	}
+
+	if err = migrate(db, logger); err != nil {
+		return nil, fmt.Errorf("MustNewDB failed migrating %w", err)
+	}
+
+	return db, nil
+}
+
+func migrate(db *sql.DB, logger *zap.Logger) error {
+
+	if err := goose.SetDialect("mysql"); err != nil {
+		return err
+	}
+	goose.SetLogger(log.New(os.Stdout, "goose: ", log.LstdFlags))
+
+	ctx := context.Background()
+
+	backoff := retry.WithMaxRetries(3, retry.NewConstant(1*time.Second))
+	var attempt int
+
+	migrationPath := os.Getenv("MIGRATIONS_DIR")
+
+	if migrationPath == "" {
+		migrationPath = migrationFolder
+	}
+
+	err := retry.Do(ctx, backoff, func(ctx context.Context) error {
+
+		err := goose.Up(db, migrationPath)
+
+		if err != nil {
+			attempt++
+			logger.Warn("migrate failed running the migrations", zap.Int("attempt", attempt), zap.Error(err))
+			return retry.RetryableError(err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
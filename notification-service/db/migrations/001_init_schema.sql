@@ -0,0 +1,68 @@
+-- +goose Up
+
+-- what notification-service needs to know of the auctions to tell who to notify, projected from the auction events
+create table if not exists auctions (
+    auction_id varchar(64) primary key,
+    seller_id varchar(64) not null default '',
+    description varchar(255) not null default '',
+    leader_id varchar(64) not null default '',
+    leading_amount decimal(15, 2) not null default 0
+);
+
+create table if not exists auction_bidders (
+    auction_id varchar(64) not null,
+    bidder_id varchar(64) not null,
+    primary key (auction_id, bidder_id),
+    index idx_auction_bidders_bidder (bidder_id)
+);
+
+-- the preferences of the users who changed the defaults, see notification.DefaultPreferences
+create table if not exists notification_preferences (
+    user_id varchar(64) primary key,
+    email varchar(255) not null default '',
+    webhook_url varchar(2048) not null default '',
+    preferences json not null,
+    updated_at timestamp(6) not null default current_timestamp(6) on update current_timestamp(6)
+);
+
+-- a user is notified once of a kind per event however many times the event is delivered
+create table if not exists notifications (
+    id varchar(64) primary key,
+    user_id varchar(64) not null,
+    kind varchar(32) not null,
+    event_id varchar(64) not null,
+    auction_id varchar(64) not null,
+    subject varchar(255) not null,
+    body text not null,
+    data json not null,
+    in_app boolean not null default false,
+    created_at timestamp(6) not null default current_timestamp(6),
+    unique key uq_notifications_event (user_id, kind, event_id),
+    index idx_notifications_user (user_id, created_at)
+);
+
+-- the notifications to send on the channels other than the in-app inbox, a digest delivery waits for
+-- deliver_after and goes out with the other digest deliveries of the user due on the channel
+create table if not exists notification_deliveries (
+    id varchar(64) primary key,
+    notification_id varchar(64) not null,
+    user_id varchar(64) not null,
+    channel varchar(16) not null,
+    digest boolean not null default false,
+    status varchar(16) not null default 'pending',
+    deliver_after timestamp(6) not null,
+    attempts int not null default 0,
+    last_error varchar(1024),
+    sent_at timestamp(6) null,
+    unique key uq_notification_deliveries (notification_id, channel),
+    index idx_notification_deliveries_due (status, deliver_after),
+    constraint fk_notification_deliveries_notification foreign key (notification_id) references notifications (id) on delete cascade
+);
+
+-- messages the consumers handled, a message delivered again is skipped, see messaging.SQLProcessedStore
+create table if not exists processed_messages (
+    queue varchar(255) not null,
+    message_id varchar(64) not null,
+    processed_at timestamp(6) not null default current_timestamp(6),
+    primary key (queue, message_id)
+);
@@ -0,0 +1,7 @@
+-- +goose Up
+
+-- seq orders the inbox and pages through it, read_at is when the user read the notification in the app
+alter table notifications
+    add column seq bigint not null auto_increment unique key,
+    add column read_at timestamp(6) null,
+    add index idx_notifications_inbox (user_id, in_app, read_at, seq);
@@ -0,0 +1,27 @@
+-- +goose Up
+
+-- data keys of the personal data encryption, wrapped by the master key, see encryption.SQLKeyStore
+create table if not exists encryption_keys (
+    id varchar(64) primary key,
+    purpose varchar(16) not null,
+    wrapped_key varbinary(1024) not null,
+    master_key_id varchar(255) not null,
+    created_at timestamp(6) not null default current_timestamp(6)
+);
+
+-- an address the user asked to be notified at, it becomes their email once they send back the token mailed
+-- to it. Only the sha256 of the token is kept and the address is encrypted
+create table if not exists email_verifications (
+    token_hash char(64) primary key,
+    user_id varchar(64) not null,
+    email varchar(1024) not null,
+    expires_at timestamp(6) not null,
+    created_at timestamp(6) not null default current_timestamp(6),
+    index idx_email_verifications_user (user_id, expires_at)
+);
+
+-- the email is encrypted from now on and kept out of the preferences document. The addresses set before
+-- were never verified, their users set them again through the verification
+alter table notification_preferences modify email varchar(1024) not null default '';
+
+update notification_preferences set email = '', preferences = json_remove(preferences, '$.email');
@@ -0,0 +1,556 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ireuven89/auctions/notification-service/notification"
+	"github.com/ireuven89/auctions/shared/encryption"
+	"github.com/ireuven89/auctions/shared/sqltx"
+	"go.uber.org/zap"
+)
+
+type Repository interface {
+	// LockAuction returns what is known of the auction and locks it until the transaction of the context
+	// ends, an auction not known yet is returned with only its id
+	LockAuction(ctx context.Context, id string) (notification.Auction, error)
+	SaveAuction(ctx context.Context, auction notification.Auction) error
+	AddBidder(ctx context.Context, auctionID, bidderID string) error
+	Bidders(ctx context.Context, auctionID string) ([]string, error)
+	// Preferences returns the preferences of the user, the defaults when they never changed them
+	Preferences(ctx context.Context, userID string) (notification.Preferences, error)
+	// SavePreferences keeps the email of the user, it changes through SaveEmail only
+	SavePreferences(ctx context.Context, preferences notification.Preferences) error
+	SaveEmail(ctx context.Context, userID, email string) error
+	// SaveEmailVerification keeps the address the user asked to be notified at until expiresAt, under the
+	// hash of the token mailed to it
+	SaveEmailVerification(ctx context.Context, tokenHash, userID, email string, expiresAt time.Time) error
+	// PendingEmailVerifications counts the verifications of the user not expired at now
+	PendingEmailVerifications(ctx context.Context, userID string, now time.Time) (int, error)
+	// ConsumeEmailVerification returns the address of the verification of the user unless it expired, and
+	// deletes every verification of the user. It returns ErrNotFound when there is no such verification
+	ConsumeEmailVerification(ctx context.Context, tokenHash, userID string, now time.Time) (string, error)
+	// Store stores the notification and its deliveries unless the user was notified of the kind for the
+	// event already. It tells whether it stored them
+	Store(ctx context.Context, n notification.Notification, deliveries []notification.Delivery) (bool, error)
+	// ForgetUser deletes the notifications, preferences, email verifications and bids of the user
+	ForgetUser(ctx context.Context, userID string) error
+	// Inbox returns a page of the in-app notifications of the user, newest first
+	Inbox(ctx context.Context, filter notification.InboxFilter) ([]notification.Notification, error)
+	// MarkRead marks the in-app notification of the user read, a notification read before keeps when it
+	// was. It returns ErrNotFound when the user has no such notification
+	MarkRead(ctx context.Context, userID, id string) error
+	// MarkAllRead marks every in-app notification of the user read and returns how many were unread
+	MarkAllRead(ctx context.Context, userID string) (int64, error)
+	UnreadCount(ctx context.Context, userID string) (int, error)
+}
+
+// NotificationRepository encrypts the email addresses with fields
+type NotificationRepository struct {
+	logger *zap.Logger
+	db     *sql.DB
+	fields *encryption.FieldCipher
+}
+
+func NewRepository(db *sql.DB, fields *encryption.FieldCipher, logger *zap.Logger) *NotificationRepository {
+
+	return &NotificationRepository{
+		logger: logger,
+		db:     db,
+		fields: fields,
+	}
+}
+
+func (r *NotificationRepository) LockAuction(ctx context.Context, id string) (notification.Auction, error) {
+	c := sqltx.Conn(ctx, r.db)
+
+	// the auction exists before it is locked so two first events of an auction wait for each other
+	if _, err := c.ExecContext(ctx, "insert ignore into auctions (auction_id) values (?)", id); err != nil {
+		return notification.Auction{}, fmt.Errorf("NotificationRepository.LockAuction %w", err)
+	}
+
+	auction := notification.Auction{ID: id}
+	err := c.QueryRowContext(ctx, "select seller_id, description, leader_id, leading_amount from auctions where auction_id = ? for update", id).
+		Scan(&auction.SellerID, &auction.Description, &auction.LeaderID, &auction.LeadingAmount)
+
+	if err != nil {
+		return notification.Auction{}, fmt.Errorf("NotificationRepository.LockAuction %w", err)
+	}
+
+	return auction, nil
+}
+
+func (r *NotificationRepository) SaveAuction(ctx context.Context, auction notification.Auction) error {
+	_, err := sqltx.Conn(ctx, r.db).ExecContext(ctx, `insert into auctions (auction_id, seller_id, description, leader_id, leading_amount) values (?, ?, ?, ?, ?)
+		on duplicate key update seller_id = values(seller_id), description = values(description), leader_id = values(leader_id), leading_amount = values(leading_amount)`,
+		auction.ID, auction.SellerID, auction.Description, auction.LeaderID, auction.LeadingAmount)
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.SaveAuction %w", err)
+	}
+
+	return nil
+}
+
+func (r *NotificationRepository) AddBidder(ctx context.Context, auctionID, bidderID string) error {
+	if _, err := sqltx.Conn(ctx, r.db).ExecContext(ctx, "insert ignore into auction_bidders (auction_id, bidder_id) values (?, ?)", auctionID, bidderID); err != nil {
+		return fmt.Errorf("NotificationRepository.AddBidder %w", err)
+	}
+
+	return nil
+}
+
+func (r *NotificationRepository) Bidders(ctx context.Context, auctionID string) ([]string, error) {
+	rows, err := sqltx.Conn(ctx, r.db).QueryContext(ctx, "select bidder_id from auction_bidders where auction_id = ? order by bidder_id", auctionID)
+
+	if err != nil {
+		return nil, fmt.Errorf("NotificationRepository.Bidders %w", err)
+	}
+	defer rows.Close()
+
+	var bidders []string
+
+	for rows.Next() {
+		var bidderID string
+
+		if err = rows.Scan(&bidderID); err != nil {
+			return nil, fmt.Errorf("NotificationRepository.Bidders %w", err)
+		}
+
+		bidders = append(bidders, bidderID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("NotificationRepository.Bidders %w", err)
+	}
+
+	return bidders, nil
+}
+
+func (r *NotificationRepository) Preferences(ctx context.Context, userID string) (notification.Preferences, error) {
+	var email string
+	var raw []byte
+	err := sqltx.Conn(ctx, r.db).QueryRowContext(ctx, "select email, preferences from notification_preferences where user_id = ?", userID).Scan(&email, &raw)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return notification.DefaultPreferences(userID), nil
+	}
+
+	if err != nil {
+		return notification.Preferences{}, fmt.Errorf("NotificationRepository.Preferences %w", err)
+	}
+
+	var preferences notification.Preferences
+
+	if err = json.Unmarshal(raw, &preferences); err != nil {
+		return notification.Preferences{}, fmt.Errorf("NotificationRepository.Preferences %w", err)
+	}
+	preferences.UserID = userID
+
+	if preferences.Email, err = r.fields.Decrypt(ctx, email); err != nil {
+		return notification.Preferences{}, fmt.Errorf("NotificationRepository.Preferences %w", err)
+	}
+
+	return preferences, nil
+}
+
+// SavePreferences keeps the webhook URL in a column of its own as well, the dispatcher reads it when it sends
+func (r *NotificationRepository) SavePreferences(ctx context.Context, preferences notification.Preferences) error {
+	raw, err := json.Marshal(preferences)
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.SavePreferences %w", err)
+	}
+
+	_, err = sqltx.Conn(ctx, r.db).ExecContext(ctx, `insert into notification_preferences (user_id, webhook_url, preferences) values (?, ?, ?)
+		on duplicate key update webhook_url = values(webhook_url), preferences = values(preferences)`,
+		preferences.UserID, preferences.WebhookURL, raw)
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.SavePreferences %w", err)
+	}
+
+	return nil
+}
+
+// SaveEmail gives a user who never changed their preferences the defaults along with the email
+func (r *NotificationRepository) SaveEmail(ctx context.Context, userID, email string) error {
+	raw, err := json.Marshal(notification.DefaultPreferences(userID))
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.SaveEmail %w", err)
+	}
+
+	sealed, err := r.fields.Encrypt(email)
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.SaveEmail %w", err)
+	}
+
+	_, err = sqltx.Conn(ctx, r.db).ExecContext(ctx, `insert into notification_preferences (user_id, email, preferences) values (?, ?, ?)
+		on duplicate key update email = values(email)`, userID, sealed, raw)
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.SaveEmail %w", err)
+	}
+
+	return nil
+}
+
+// SaveEmailVerification drops the expired verifications on the way
+func (r *NotificationRepository) SaveEmailVerification(ctx context.Context, tokenHash, userID, email string, expiresAt time.Time) error {
+	sealed, err := r.fields.Encrypt(email)
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.SaveEmailVerification %w", err)
+	}
+
+	c := sqltx.Conn(ctx, r.db)
+
+	if _, err = c.ExecContext(ctx, "delete from email_verifications where expires_at <= ?", time.Now().UTC()); err != nil {
+		return fmt.Errorf("NotificationRepository.SaveEmailVerification %w", err)
+	}
+
+	_, err = c.ExecContext(ctx, "insert into email_verifications (token_hash, user_id, email, expires_at) values (?, ?, ?, ?)",
+		tokenHash, userID, sealed, expiresAt.UTC())
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.SaveEmailVerification %w", err)
+	}
+
+	return nil
+}
+
+func (r *NotificationRepository) PendingEmailVerifications(ctx context.Context, userID string, now time.Time) (int, error) {
+	var count int
+	err := sqltx.Conn(ctx, r.db).QueryRowContext(ctx, "select count(*) from email_verifications where user_id = ? and expires_at > ?", userID, now.UTC()).Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("NotificationRepository.PendingEmailVerifications %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *NotificationRepository) ConsumeEmailVerification(ctx context.Context, tokenHash, userID string, now time.Time) (string, error) {
+	c := sqltx.Conn(ctx, r.db)
+
+	var email string
+	err := c.QueryRowContext(ctx, "select email from email_verifications where token_hash = ? and user_id = ? and expires_at > ? for update",
+		tokenHash, userID, now.UTC()).Scan(&email)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("NotificationRepository.ConsumeEmailVerification %w", notification.ErrNotFound)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("NotificationRepository.ConsumeEmailVerification %w", err)
+	}
+
+	if _, err = c.ExecContext(ctx, "delete from email_verifications where user_id = ?", userID); err != nil {
+		return "", fmt.Errorf("NotificationRepository.ConsumeEmailVerification %w", err)
+	}
+
+	if email, err = r.fields.Decrypt(ctx, email); err != nil {
+		return "", fmt.Errorf("NotificationRepository.ConsumeEmailVerification %w", err)
+	}
+
+	return email, nil
+}
+
+func (r *NotificationRepository) Store(ctx context.Context, n notification.Notification, deliveries []notification.Delivery) (bool, error) {
+	data, err := json.Marshal(n.Data)
+
+	if err != nil {
+		return false, fmt.Errorf("NotificationRepository.Store %w", err)
+	}
+
+	c := sqltx.Conn(ctx, r.db)
+	res, err := c.ExecContext(ctx, `insert ignore into notifications (id, user_id, kind, event_id, auction_id, subject, body, data, in_app, created_at)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		n.ID, n.UserID, n.Kind, n.EventID, n.AuctionID, n.Subject, n.Body, data, n.InApp, n.CreatedAt.UTC())
+
+	if err != nil {
+		return false, fmt.Errorf("NotificationRepository.Store %w", err)
+	}
+
+	stored, err := res.RowsAffected()
+
+	if err != nil {
+		return false, fmt.Errorf("NotificationRepository.Store %w", err)
+	}
+
+	if stored == 0 {
+		return false, nil
+	}
+
+	for _, d := range deliveries {
+		_, err = c.ExecContext(ctx, `insert into notification_deliveries (id, notification_id, user_id, channel, digest, status, deliver_after)
+			values (?, ?, ?, ?, ?, ?, ?)`,
+			d.ID, n.ID, n.UserID, d.Channel, d.Digest, notification.DeliveryPending, d.DeliverAfter.UTC())
+
+		if err != nil {
+			return false, fmt.Errorf("NotificationRepository.Store failed storing %s delivery %w", d.Channel, err)
+		}
+	}
+
+	return true, nil
+}
+
+func (r *NotificationRepository) ForgetUser(ctx context.Context, userID string) error {
+	c := sqltx.Conn(ctx, r.db)
+
+	// the deliveries go with their notifications
+	for _, query := range []string{
+		"delete from notifications where user_id = ?",
+		"delete from notification_preferences where user_id = ?",
+		"delete from email_verifications where user_id = ?",
+		"delete from auction_bidders where bidder_id = ?",
+	} {
+		if _, err := c.ExecContext(ctx, query, userID); err != nil {
+			return fmt.Errorf("NotificationRepository.ForgetUser %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *NotificationRepository) Inbox(ctx context.Context, filter notification.InboxFilter) ([]notification.Notification, error) {
+	query := `select id, kind, event_id, auction_id, subject, body, data, seq, read_at, created_at
+		from notifications where user_id = ? and in_app`
+	args := []interface{}{filter.UserID}
+
+	if filter.Unread {
+		query += " and read_at is null"
+	}
+
+	if filter.Before > 0 {
+		query += " and seq < ?"
+		args = append(args, filter.Before)
+	}
+
+	query += " order by seq desc limit ?"
+	args = append(args, filter.Limit)
+
+	rows, err := sqltx.Conn(ctx, r.db).QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, fmt.Errorf("NotificationRepository.Inbox %w", err)
+	}
+	defer rows.Close()
+
+	var inbox []notification.Notification
+
+	for rows.Next() {
+		n := notification.Notification{UserID: filter.UserID, InApp: true}
+		var data []byte
+		var readAt sql.NullTime
+
+		if err = rows.Scan(&n.ID, &n.Kind, &n.EventID, &n.AuctionID, &n.Subject, &n.Body, &data, &n.Seq, &readAt, &n.CreatedAt); err != nil {
+			return nil, fmt.Errorf("NotificationRepository.Inbox %w", err)
+		}
+
+		if err = json.Unmarshal(data, &n.Data); err != nil {
+			return nil, fmt.Errorf("NotificationRepository.Inbox %w", err)
+		}
+
+		if readAt.Valid {
+			n.ReadAt = &readAt.Time
+		}
+
+		inbox = append(inbox, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("NotificationRepository.Inbox %w", err)
+	}
+
+	return inbox, nil
+}
+
+func (r *NotificationRepository) MarkRead(ctx context.Context, userID, id string) error {
+	var found int
+	err := sqltx.Conn(ctx, r.db).QueryRowContext(ctx, "select count(*) from notifications where id = ? and user_id = ? and in_app", id, userID).Scan(&found)
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.MarkRead %w", err)
+	}
+
+	if found == 0 {
+		return fmt.Errorf("NotificationRepository.MarkRead %s %w", id, notification.ErrNotFound)
+	}
+
+	_, err = sqltx.Conn(ctx, r.db).ExecContext(ctx, "update notifications set read_at = ? where id = ? and user_id = ? and read_at is null",
+		time.Now().UTC(), id, userID)
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.MarkRead %w", err)
+	}
+
+	return nil
+}
+
+func (r *NotificationRepository) MarkAllRead(ctx context.Context, userID string) (int64, error) {
+	res, err := sqltx.Conn(ctx, r.db).ExecContext(ctx, "update notifications set read_at = ? where user_id = ? and in_app and read_at is null",
+		time.Now().UTC(), userID)
+
+	if err != nil {
+		return 0, fmt.Errorf("NotificationRepository.MarkAllRead %w", err)
+	}
+
+	marked, err := res.RowsAffected()
+
+	if err != nil {
+		return 0, fmt.Errorf("NotificationRepository.MarkAllRead %w", err)
+	}
+
+	return marked, nil
+}
+
+func (r *NotificationRepository) UnreadCount(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := sqltx.Conn(ctx, r.db).QueryRowContext(ctx, "select count(*) from notifications where user_id = ? and in_app and read_at is null", userID).Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("NotificationRepository.UnreadCount %w", err)
+	}
+
+	return count, nil
+}
+
+// Claim pushes the deliveries it returns past the lease, a dispatcher that dies with them leaves them to
+// the others once it expires
+func (r *NotificationRepository) Claim(ctx context.Context, limit int, lease time.Duration) ([]notification.Pending, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("NotificationRepository.Claim %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	rows, err := tx.QueryContext(ctx, `select d.id, d.notification_id, d.user_id, d.channel, d.digest, d.deliver_after, d.attempts,
+		n.kind, n.event_id, n.auction_id, n.subject, n.body, n.data, n.in_app, n.created_at, coalesce(p.email, ''), coalesce(p.webhook_url, '')
+		from notification_deliveries d join notifications n on n.id = d.notification_id
+		left join notification_preferences p on p.user_id = d.user_id
+		where d.status = ? and d.deliver_after <= ? order by d.deliver_after limit ? for update of d skip locked`,
+		notification.DeliveryPending, now, limit)
+
+	if err != nil {
+		return nil, fmt.Errorf("NotificationRepository.Claim %w", err)
+	}
+
+	var claimed []notification.Pending
+	var ids []interface{}
+
+	for rows.Next() {
+		var pending notification.Pending
+		var data []byte
+		var email, webhookURL string
+		n := &pending.Notification
+
+		err = rows.Scan(&pending.ID, &pending.NotificationID, &pending.UserID, &pending.Channel, &pending.Digest, &pending.DeliverAfter, &pending.Attempts,
+			&n.Kind, &n.EventID, &n.AuctionID, &n.Subject, &n.Body, &data, &n.InApp, &n.CreatedAt, &email, &webhookURL)
+
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("NotificationRepository.Claim %w", err)
+		}
+
+		if err = json.Unmarshal(data, &n.Data); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("NotificationRepository.Claim %w", err)
+		}
+
+		if email, err = r.fields.Decrypt(ctx, email); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("NotificationRepository.Claim %w", err)
+		}
+
+		n.ID, n.UserID = pending.NotificationID, pending.UserID
+		pending.Status = notification.DeliveryPending
+		pending.Address = notification.Preferences{Email: email, WebhookURL: webhookURL}.Address(pending.Channel)
+		claimed = append(claimed, pending)
+		ids = append(ids, pending.ID)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("NotificationRepository.Claim %w", err)
+	}
+
+	if len(claimed) == 0 {
+		return nil, nil
+	}
+
+	args := append([]interface{}{now.Add(lease)}, ids...)
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("update notification_deliveries set deliver_after = ? where id in (%s)", placeholders(len(ids))), args...); err != nil {
+		return nil, fmt.Errorf("NotificationRepository.Claim %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("NotificationRepository.Claim %w", err)
+	}
+
+	return claimed, nil
+}
+
+func (r *NotificationRepository) Sent(ctx context.Context, ids []string) error {
+	args := append([]interface{}{notification.DeliverySent, time.Now().UTC()}, toArgs(ids)...)
+
+	_, err := r.db.ExecContext(ctx, fmt.Sprintf("update notification_deliveries set status = ?, attempts = attempts + 1, last_error = null, sent_at = ? where id in (%s)",
+		placeholders(len(ids))), args...)
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.Sent %w", err)
+	}
+
+	return nil
+}
+
+func (r *NotificationRepository) Retry(ctx context.Context, ids []string, reason string, at time.Time) error {
+	args := append([]interface{}{reason, at.UTC()}, toArgs(ids)...)
+
+	_, err := r.db.ExecContext(ctx, fmt.Sprintf("update notification_deliveries set attempts = attempts + 1, last_error = ?, deliver_after = ? where id in (%s)",
+		placeholders(len(ids))), args...)
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.Retry %w", err)
+	}
+
+	return nil
+}
+
+func (r *NotificationRepository) Fail(ctx context.Context, ids []string, reason string) error {
+	args := append([]interface{}{notification.DeliveryFailed, reason}, toArgs(ids)...)
+
+	_, err := r.db.ExecContext(ctx, fmt.Sprintf("update notification_deliveries set status = ?, attempts = attempts + 1, last_error = ? where id in (%s)",
+		placeholders(len(ids))), args...)
+
+	if err != nil {
+		return fmt.Errorf("NotificationRepository.Fail %w", err)
+	}
+
+	return nil
+}
+
+func placeholders(n int) string {
+
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func toArgs(values []string) []interface{} {
+	args := make([]interface{}, 0, len(values))
+
+	for _, v := range values {
+		args = append(args, v)
+	}
+
+	return args
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ireuven89/auctions/notification-service/notification"
+	"github.com/ireuven89/auctions/shared/encryption"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func testFields(t *testing.T) *encryption.FieldCipher {
+	t.Helper()
+	line, err := encryption.NewKeyringLine(time.Now())
+	require.NoError(t, err)
+	provider, err := encryption.ParseKeyring(strings.NewReader(line))
+	require.NoError(t, err)
+	fields, err := encryption.LoadFieldCipher(context.Background(), provider, encryption.NewMemoryKeyStore())
+	require.NoError(t, err)
+
+	return fields
+}
+
+func TestNotificationRepository_Store(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	repo := NewRepository(db, testFields(t), zap.NewNop())
+	ctx := context.Background()
+	now := time.Now().UTC()
+	n := notification.Notification{
+		ID: "n", UserID: "alice", Kind: notification.KindOutbid, EventID: "event", AuctionID: "auction",
+		Subject: "subject", Body: "body", InApp: true, CreatedAt: now,
+	}
+	deliveries := []notification.Delivery{{ID: "d", Channel: notification.ChannelEmail, Digest: true, DeliverAfter: now}}
+
+	mock.ExpectExec("insert ignore into notifications").
+		WithArgs("n", "alice", notification.KindOutbid, "event", "auction", "subject", "body", sqlmock.AnyArg(), true, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("insert into notification_deliveries").
+		WithArgs("d", "n", "alice", notification.ChannelEmail, true, notification.DeliveryPending, now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	stored, err := repo.Store(ctx, n, deliveries)
+	assert.NoError(t, err)
+	assert.True(t, stored)
+
+	// the user was notified of the event already
+	mock.ExpectExec("insert ignore into notifications").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	stored, err = repo.Store(ctx, n, deliveries)
+	assert.NoError(t, err)
+	assert.False(t, stored)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNotificationRepository_Preferences(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	fields := testFields(t)
+	repo := NewRepository(db, fields, zap.NewNop())
+	query := regexp.QuoteMeta("select email, preferences from notification_preferences where user_id = ?")
+
+	mock.ExpectQuery(query).WithArgs("alice").WillReturnRows(sqlmock.NewRows([]string{"email", "preferences"}))
+
+	preferences, err := repo.Preferences(context.Background(), "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, notification.DefaultPreferences("alice"), preferences)
+
+	sealed, err := fields.Encrypt("bob@example.com")
+	require.NoError(t, err)
+
+	mock.ExpectQuery(query).WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"email", "preferences"}).AddRow(sealed, `{"channels":{"won":["email"]},"digestMinutes":30}`))
+
+	preferences, err = repo.Preferences(context.Background(), "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", preferences.UserID)
+	assert.Equal(t, "bob@example.com", preferences.Email)
+	assert.Equal(t, []notification.Channel{notification.ChannelEmail}, preferences.ChannelsFor(notification.KindWon))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// encryptedArg matches a value sealed by a FieldCipher
+type encryptedArg struct{}
+
+func (encryptedArg) Match(v driver.Value) bool {
+	value, ok := v.(string)
+
+	return ok && encryption.Encrypted(value)
+}
+
+func TestNotificationRepository_EmailVerification(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	fields := testFields(t)
+	repo := NewRepository(db, fields, zap.NewNop())
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	mock.ExpectExec("delete from email_verifications where expires_at").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("insert into email_verifications").
+		WithArgs("hash", "alice", encryptedArg{}, now.Add(time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, repo.SaveEmailVerification(ctx, "hash", "alice", "alice@example.com", now.Add(time.Hour)))
+
+	sealed, err := fields.Encrypt("alice@example.com")
+	require.NoError(t, err)
+
+	query := regexp.QuoteMeta("select email from email_verifications where token_hash = ? and user_id = ? and expires_at > ? for update")
+	mock.ExpectQuery(query).WithArgs("hash", "alice", now).WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow(sealed))
+	mock.ExpectExec("delete from email_verifications where user_id").WithArgs("alice").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	email, err := repo.ConsumeEmailVerification(ctx, "hash", "alice", now)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", email)
+
+	// a token of another user, or consumed already
+	mock.ExpectQuery(query).WithArgs("hash", "mallory", now).WillReturnRows(sqlmock.NewRows([]string{"email"}))
+
+	_, err = repo.ConsumeEmailVerification(ctx, "hash", "mallory", now)
+	assert.ErrorIs(t, err, notification.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNotificationRepository_Inbox(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	repo := NewRepository(db, testFields(t), zap.NewNop())
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	mock.ExpectQuery(regexp.QuoteMeta("from notifications where user_id = ? and in_app and read_at is null and seq < ? order by seq desc limit ?")).
+		WithArgs("alice", int64(40), 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "event_id", "auction_id", "subject", "body", "data", "seq", "read_at", "created_at"}).
+			AddRow("n", "won", "event", "auction", "subject", "body", `{"auctionId":"auction","amount":120}`, 39, nil, now))
+
+	inbox, err := repo.Inbox(ctx, notification.InboxFilter{UserID: "alice", Unread: true, Before: 40, Limit: 20})
+	assert.NoError(t, err)
+	assert.Equal(t, []notification.Notification{{
+		ID: "n", UserID: "alice", Kind: notification.KindWon, EventID: "event", AuctionID: "auction", Subject: "subject", Body: "body",
+		Data: notification.Data{AuctionID: "auction", Amount: 120}, InApp: true, Seq: 39, CreatedAt: now,
+	}}, inbox)
+
+	// the notifications of other users are not found
+	mock.ExpectQuery(regexp.QuoteMeta("select count(*) from notifications where id = ? and user_id = ? and in_app")).
+		WithArgs("n", "bob").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	assert.ErrorIs(t, repo.MarkRead(ctx, "bob", "n"), notification.ErrNotFound)
+
+	mock.ExpectQuery(regexp.QuoteMeta("select count(*) from notifications where id = ? and user_id = ? and in_app")).
+		WithArgs("n", "alice").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta("update notifications set read_at = ? where id = ? and user_id = ? and read_at is null")).
+		WithArgs(sqlmock.AnyArg(), "n", "alice").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, repo.MarkRead(ctx, "alice", "n"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ireuven89/auctions/shared/events"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"go.uber.org/zap"
+)
+
+// AuctionEventsSubscription is the queue the auction events users are notified of reach notification-service on
+var AuctionEventsSubscription = messaging.Subscription{
+	Queue: "notification-service.auctions",
+	Topics: []string{events.TopicAuctionCreated, events.TopicAuctionUpdated, events.TopicBidPlaced,
+		events.TopicAuctionClosed, events.TopicAuctionCancelled},
+}
+
+// NewAuctionEventsHandler notifies the users of what happens to their auctions and bids
+func NewAuctionEventsHandler(s Service) messaging.Handler {
+	return func(ctx context.Context, msg messaging.Message) error {
+
+		return s.ApplyAuctionEvent(ctx, msg.Envelope)
+	}
+}
+
+// UserEventsSubscription is the queue the deleted users reach notification-service on
+var UserEventsSubscription = messaging.Subscription{
+	Queue:  "notification-service.users",
+	Topics: []string{events.TopicUserDeleted},
+}
+
+// NewUserEventsHandler forgets the notifications and preferences of deleted users
+func NewUserEventsHandler(s Service, logger *zap.Logger) messaging.Handler {
+	return func(ctx context.Context, msg messaging.Message) error {
+		if msg.Type != events.TypeUserDeleted {
+			logger.Debug("NewUserEventsHandler ignoring event", zap.String("type", msg.Type), zap.String("id", msg.ID))
+			return nil
+		}
+
+		deleted, err := messaging.Payload[events.UserDeleted](msg.Envelope)
+
+		if err != nil || deleted.UserID == "" {
+			return messaging.Permanent(fmt.Errorf("malformed %s %s %v", msg.Type, msg.ID, err))
+		}
+
+		return s.ForgetUser(ctx, deleted.UserID)
+	}
+}
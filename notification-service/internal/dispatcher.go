@@ -0,0 +1,212 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ireuven89/auctions/notification-service/channel"
+	"github.com/ireuven89/auctions/notification-service/notification"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultBatchSize    = 100
+	DefaultPollInterval = 5 * time.Second
+	// claimLease is how long claimed deliveries are left to their dispatcher before another may claim them
+	claimLease = 2 * time.Minute
+	// maxErrorLength keeps the last delivery error readable
+	maxErrorLength = 1024
+)
+
+// DefaultDeliveryPolicy retries a delivery for a few hours before giving up on it
+var DefaultDeliveryPolicy = messaging.RetryPolicy{
+	MaxAttempts:  6,
+	InitialDelay: time.Minute,
+	MaxDelay:     2 * time.Hour,
+	Multiplier:   4,
+}
+
+// DeliveryStore keeps the deliveries for the dispatcher
+type DeliveryStore interface {
+	// Claim returns up to limit deliveries due and leaves them to the caller until the lease expires
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]notification.Pending, error)
+	Sent(ctx context.Context, ids []string) error
+	// Retry records the failed attempt and when to try again
+	Retry(ctx context.Context, ids []string, reason string, at time.Time) error
+	// Fail records the last attempt failed and gives up on the deliveries
+	Fail(ctx context.Context, ids []string, reason string) error
+}
+
+// Dispatcher sends the deliveries due on their channels. The digest deliveries of a user due on a channel
+// are sent as one message
+type Dispatcher struct {
+	store        DeliveryStore
+	channels     map[notification.Channel]channel.Channel
+	templates    *notification.Templates
+	policy       messaging.RetryPolicy
+	batchSize    int
+	pollInterval time.Duration
+	logger       *zap.Logger
+}
+
+func NewDispatcher(store DeliveryStore, channels map[notification.Channel]channel.Channel, templates *notification.Templates, logger *zap.Logger) *Dispatcher {
+
+	return &Dispatcher{
+		store:        store,
+		channels:     channels,
+		templates:    templates,
+		policy:       DefaultDeliveryPolicy,
+		batchSize:    DefaultBatchSize,
+		pollInterval: DefaultPollInterval,
+		logger:       logger,
+	}
+}
+
+// Run dispatches until the context is done, polling when nothing is due
+func (d *Dispatcher) Run(ctx context.Context) error {
+	for {
+		dispatched, err := d.DispatchOnce(ctx)
+		wait := d.pollInterval
+
+		if err != nil {
+			d.logger.Error("Dispatcher.Run failed dispatching", zap.Error(err))
+		} else if dispatched == d.batchSize {
+			// there is probably more due
+			wait = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// DispatchOnce sends a batch of the deliveries due and returns how many were attempted
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	due, err := d.store.Claim(ctx, d.batchSize, claimLease)
+
+	if err != nil {
+		return 0, fmt.Errorf("Dispatcher.DispatchOnce %w", err)
+	}
+
+	for _, batch := range batches(due) {
+		if err = d.dispatch(ctx, batch); err != nil {
+			return 0, fmt.Errorf("Dispatcher.DispatchOnce %w", err)
+		}
+	}
+
+	return len(due), nil
+}
+
+// batches keeps the deliveries that are not digested on their own and puts the digested ones of a user on
+// a channel together, in the order they were claimed
+func batches(due []notification.Pending) [][]notification.Pending {
+	type digestKey struct {
+		userID  string
+		channel notification.Channel
+	}
+
+	var result [][]notification.Pending
+	digests := make(map[digestKey]int)
+
+	for _, pending := range due {
+		if !pending.Digest {
+			result = append(result, []notification.Pending{pending})
+			continue
+		}
+
+		key := digestKey{userID: pending.UserID, channel: pending.Channel}
+
+		if i, ok := digests[key]; ok {
+			result[i] = append(result[i], pending)
+			continue
+		}
+
+		digests[key] = len(result)
+		result = append(result, []notification.Pending{pending})
+	}
+
+	return result
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, batch []notification.Pending) error {
+	first := batch[0]
+	ids := make([]string, 0, len(batch))
+	attempts := 0
+
+	for _, pending := range batch {
+		ids = append(ids, pending.ID)
+		attempts = max(attempts, pending.Attempts)
+	}
+
+	send, ok := d.channels[first.Channel]
+
+	if !ok {
+		return d.store.Fail(ctx, ids, fmt.Sprintf("no %s channel", first.Channel))
+	}
+
+	// the user removed their address after the notification was raised
+	if first.Address == "" {
+		return d.store.Fail(ctx, ids, fmt.Sprintf("no %s address", first.Channel))
+	}
+
+	msg, err := d.message(batch)
+
+	if err != nil {
+		return d.store.Fail(ctx, ids, err.Error())
+	}
+
+	err = send.Send(ctx, msg)
+
+	if err == nil {
+		return d.store.Sent(ctx, ids)
+	}
+
+	reason := err.Error()
+	if len(reason) > maxErrorLength {
+		reason = reason[:maxErrorLength]
+	}
+
+	attempt := attempts + 1
+
+	if attempt < d.policy.MaxAttempts {
+		return d.store.Retry(ctx, ids, reason, time.Now().Add(d.policy.Delay(attempt)))
+	}
+
+	d.logger.Warn("Dispatcher gave up on deliveries", zap.Strings("ids", ids), zap.String("userId", first.UserID),
+		zap.String("channel", string(first.Channel)), zap.Int("attempts", attempt), zap.String("reason", reason))
+
+	return d.store.Fail(ctx, ids, reason)
+}
+
+// message is the notification itself, or a digest of the notifications when there are several
+func (d *Dispatcher) message(batch []notification.Pending) (channel.Message, error) {
+	msg := channel.Message{
+		UserID:        batch[0].UserID,
+		To:            batch[0].Address,
+		Notifications: make([]notification.Notification, 0, len(batch)),
+	}
+
+	for _, pending := range batch {
+		msg.Notifications = append(msg.Notifications, pending.Notification)
+	}
+
+	if len(batch) == 1 {
+		msg.Subject, msg.Body = batch[0].Notification.Subject, batch[0].Notification.Body
+		return msg, nil
+	}
+
+	subject, body, err := d.templates.RenderDigest(msg.Notifications)
+
+	if err != nil {
+		return channel.Message{}, err
+	}
+
+	msg.Subject, msg.Body = subject, body
+
+	return msg, nil
+}
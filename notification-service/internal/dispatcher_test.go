@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ireuven89/auctions/notification-service/channel"
+	"github.com/ireuven89/auctions/notification-service/notification"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeStore struct {
+	due     []notification.Pending
+	sent    []string
+	retried []string
+	failed  map[string]string
+}
+
+func (s *fakeStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]notification.Pending, error) {
+	due := s.due
+	s.due = nil
+
+	return due, nil
+}
+
+func (s *fakeStore) Sent(ctx context.Context, ids []string) error {
+	s.sent = append(s.sent, ids...)
+
+	return nil
+}
+
+func (s *fakeStore) Retry(ctx context.Context, ids []string, reason string, at time.Time) error {
+	s.retried = append(s.retried, ids...)
+
+	return nil
+}
+
+func (s *fakeStore) Fail(ctx context.Context, ids []string, reason string) error {
+	for _, id := range ids {
+		s.failed[id] = reason
+	}
+
+	return nil
+}
+
+type fakeChannel struct {
+	messages []channel.Message
+	err      error
+}
+
+func (c *fakeChannel) Send(ctx context.Context, msg channel.Message) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.messages = append(c.messages, msg)
+
+	return nil
+}
+
+func pending(id, userID string, ch notification.Channel, digest bool, subject string) notification.Pending {
+
+	return notification.Pending{
+		Delivery:     notification.Delivery{ID: id, UserID: userID, Channel: ch, Digest: digest},
+		Notification: notification.Notification{ID: "n-" + id, UserID: userID, Subject: subject, Body: subject + " body\n"},
+		Address:      userID + "@example.com",
+	}
+}
+
+func TestDispatchOnce(t *testing.T) {
+	templates, err := notification.NewTemplates()
+	require.NoError(t, err)
+
+	store := &fakeStore{failed: map[string]string{}}
+	email := &fakeChannel{}
+	dispatcher := NewDispatcher(store, map[notification.Channel]channel.Channel{notification.ChannelEmail: email}, templates, zap.NewNop())
+
+	noAddress := pending("5", "bob", notification.ChannelEmail, false, "You won a lamp")
+	noAddress.Address = ""
+
+	store.due = []notification.Pending{
+		pending("1", "alice", notification.ChannelEmail, true, "You have been outbid on a bike"),
+		pending("2", "alice", notification.ChannelEmail, false, "You won a bike"),
+		pending("3", "alice", notification.ChannelEmail, true, "You have been outbid on a lamp"),
+		pending("4", "alice", notification.ChannelWebhook, false, "You won a bike"),
+		noAddress,
+	}
+
+	dispatched, err := dispatcher.DispatchOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 5, dispatched)
+
+	// the digested deliveries of alice went out as one message
+	require.Len(t, email.messages, 2)
+	assert.Equal(t, "2 updates on your auctions", email.messages[0].Subject)
+	assert.Equal(t, "alice@example.com", email.messages[0].To)
+	assert.Len(t, email.messages[0].Notifications, 2)
+	assert.Equal(t, "You won a bike", email.messages[1].Subject)
+	assert.ElementsMatch(t, []string{"1", "2", "3"}, store.sent)
+
+	assert.Equal(t, "no webhook channel", store.failed["4"])
+	assert.Equal(t, "no email address", store.failed["5"])
+
+	// failed sends are retried until the attempts run out
+	email.err = errors.New("connection refused")
+	exhausted := pending("7", "alice", notification.ChannelEmail, false, "You won a bike")
+	exhausted.Attempts = DefaultDeliveryPolicy.MaxAttempts - 1
+	store.due = []notification.Pending{pending("6", "alice", notification.ChannelEmail, false, "You won a bike"), exhausted}
+
+	_, err = dispatcher.DispatchOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"6"}, store.retried)
+	assert.Equal(t, "connection refused", store.failed["7"])
+}
@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/ireuven89/auctions/notification-service/notification"
+)
+
+type GetPreferencesRequestModel struct{}
+
+type PreferencesResponseModel struct {
+	preferences notification.Preferences
+}
+
+func MakeEndpointGetPreferences(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		res, err := s.Preferences(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointGetPreferences %w", err)
+		}
+
+		return PreferencesResponseModel{preferences: res}, nil
+	}
+}
+
+type UpdatePreferencesRequestModel struct {
+	notification.Preferences
+}
+
+func MakeEndpointUpdatePreferences(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(UpdatePreferencesRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointUpdatePreferences failed casting request")
+		}
+
+		res, err := s.UpdatePreferences(ctx, req.Preferences)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointUpdatePreferences %w", err)
+		}
+
+		return PreferencesResponseModel{preferences: res}, nil
+	}
+}
+
+type RequestEmailRequestModel struct {
+	Email string `json:"email"`
+}
+
+func MakeEndpointRequestEmail(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(RequestEmailRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointRequestEmail failed casting request")
+		}
+
+		if err = s.RequestEmail(ctx, req.Email); err != nil {
+			return nil, fmt.Errorf("MakeEndpointRequestEmail %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type VerifyEmailRequestModel struct {
+	Token string `json:"token"`
+}
+
+func MakeEndpointVerifyEmail(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(VerifyEmailRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointVerifyEmail failed casting request")
+		}
+
+		res, err := s.VerifyEmail(ctx, req.Token)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointVerifyEmail %w", err)
+		}
+
+		return PreferencesResponseModel{preferences: res}, nil
+	}
+}
+
+type GetInboxRequestModel struct {
+	filter notification.InboxFilter
+}
+
+// GetInboxResponseModel - Next is the cursor of the following page, zero on the last one
+type GetInboxResponseModel struct {
+	notifications []notification.Notification
+	Next          int64
+}
+
+func MakeEndpointGetInbox(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(GetInboxRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointGetInbox failed casting request")
+		}
+
+		filter := req.filter.WithLimit()
+		inbox, err := s.Inbox(ctx, filter)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointGetInbox %w", err)
+		}
+
+		res := GetInboxResponseModel{notifications: inbox}
+		if len(inbox) == filter.Limit {
+			res.Next = inbox[len(inbox)-1].Seq
+		}
+
+		return res, nil
+	}
+}
+
+type MarkReadRequestModel struct {
+	id string
+}
+
+func MakeEndpointMarkRead(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req, ok := request.(MarkReadRequestModel)
+
+		if !ok {
+			return nil, fmt.Errorf("MakeEndpointMarkRead failed casting request")
+		}
+
+		if err = s.MarkRead(ctx, req.id); err != nil {
+			return nil, fmt.Errorf("MakeEndpointMarkRead %w", err)
+		}
+
+		return nil, nil
+	}
+}
+
+type MarkAllReadResponseModel struct {
+	Marked int64 `json:"marked"`
+}
+
+func MakeEndpointMarkAllRead(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		marked, err := s.MarkAllRead(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointMarkAllRead %w", err)
+		}
+
+		return MarkAllReadResponseModel{Marked: marked}, nil
+	}
+}
+
+type UnreadCountResponseModel struct {
+	Unread int `json:"unread"`
+}
+
+func MakeEndpointUnreadCount(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		count, err := s.UnreadCount(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("MakeEndpointUnreadCount %w", err)
+		}
+
+		return UnreadCountResponseModel{Unread: count}, nil
+	}
+}
+
+// StreamUnreadCountResponseModel - the counts are sent while the stream is open, Stream hands each of them to send
+type StreamUnreadCountResponseModel struct {
+	Stream func(ctx context.Context, send func(count int) error) error
+}
+
+func MakeEndpointStreamUnreadCount(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+
+		return StreamUnreadCountResponseModel{Stream: s.UnreadCounts}, nil
+	}
+}
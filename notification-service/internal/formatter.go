@@ -0,0 +1,50 @@
+package internal
+
+import "github.com/ireuven89/auctions/notification-service/notification"
+
+func formatPreferences(preferences notification.Preferences) map[string]interface{} {
+	channels := make(map[string][]notification.Channel, len(preferences.Channels))
+	for kind, kindChannels := range preferences.Channels {
+		channels[string(kind)] = kindChannels
+	}
+
+	digest := preferences.Digest
+	if digest == nil {
+		digest = []notification.Kind{}
+	}
+
+	formatted := map[string]interface{}{
+		"userId":        preferences.UserID,
+		"email":         preferences.Email,
+		"webhookUrl":    preferences.WebhookURL,
+		"channels":      channels,
+		"digest":        digest,
+		"digestMinutes": preferences.DigestMinutes,
+		"quietHours":    nil,
+	}
+
+	if q := preferences.QuietHours; q != nil {
+		formatted["quietHours"] = map[string]interface{}{
+			"start":    q.Start,
+			"end":      q.End,
+			"timeZone": q.TimeZone,
+		}
+	}
+
+	return formatted
+}
+
+func formatNotification(n notification.Notification) map[string]interface{} {
+
+	return map[string]interface{}{
+		"id":        n.ID,
+		"kind":      n.Kind,
+		"auctionId": n.AuctionID,
+		"subject":   n.Subject,
+		"body":      n.Body,
+		"data":      n.Data,
+		"read":      n.ReadAt != nil,
+		"readAt":    n.ReadAt,
+		"createdAt": n.CreatedAt,
+	}
+}
@@ -0,0 +1,682 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ireuven89/auctions/notification-service/channel"
+	"github.com/ireuven89/auctions/notification-service/db"
+	"github.com/ireuven89/auctions/notification-service/notification"
+	"github.com/ireuven89/auctions/shared/events"
+	http2 "github.com/ireuven89/auctions/shared/http"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"go.uber.org/zap"
+)
+
+type Service interface {
+	// ApplyAuctionEvent notifies the users the auction event is about, an event applied before notifies no one again
+	ApplyAuctionEvent(ctx context.Context, envelope messaging.Envelope) error
+	ForgetUser(ctx context.Context, userID string) error
+	// Preferences and UpdatePreferences are those of the user of the request
+	Preferences(ctx context.Context) (notification.Preferences, error)
+	UpdatePreferences(ctx context.Context, preferences notification.Preferences) (notification.Preferences, error)
+	// RequestEmail mails a token to the address, the address becomes the email of the user of the request once
+	// VerifyEmail is given the token
+	RequestEmail(ctx context.Context, email string) error
+	VerifyEmail(ctx context.Context, token string) (notification.Preferences, error)
+	// Inbox, MarkRead, MarkAllRead and UnreadCount are the in-app notifications of the user of the request
+	Inbox(ctx context.Context, filter notification.InboxFilter) ([]notification.Notification, error)
+	MarkRead(ctx context.Context, id string) error
+	MarkAllRead(ctx context.Context) (int64, error)
+	UnreadCount(ctx context.Context) (int, error)
+	// UnreadCounts sends the unread count of the user of the request, then again whenever it changes, until
+	// ctx is done or send fails
+	UnreadCounts(ctx context.Context, send func(count int) error) error
+}
+
+// Transactor runs fn in a transaction, the repositories called with the context it gets join the transaction
+type Transactor interface {
+	WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// unreadPollInterval is how often a stream counts the unread notifications again, it catches the changes
+// made on other instances
+const unreadPollInterval = 15 * time.Second
+
+const (
+	// emailVerificationTTL is how long the token mailed to verify an address is valid
+	emailVerificationTTL = 24 * time.Hour
+	// maxPendingEmailVerifications keeps a user from mailing tokens to any number of addresses
+	maxPendingEmailVerifications = 3
+)
+
+type NotificationService struct {
+	repo         db.Repository
+	tx           Transactor
+	templates    *notification.Templates
+	unread       *UnreadHub
+	email        channel.Channel
+	logger       *zap.Logger
+	now          func() time.Time
+	pollInterval time.Duration
+}
+
+// NewService sends the tokens verifying the email addresses of the users by email
+func NewService(repo db.Repository, tx Transactor, templates *notification.Templates, unread *UnreadHub, email channel.Channel, logger *zap.Logger) Service {
+
+	return &NotificationService{
+		repo:         repo,
+		tx:           tx,
+		templates:    templates,
+		unread:       unread,
+		email:        email,
+		logger:       logger,
+		now:          time.Now,
+		pollInterval: unreadPollInterval,
+	}
+}
+
+// ApplyAuctionEvent updates what is known of the auction and stores the notifications the event raises in
+// one transaction, so a failed event is applied again as a whole. The streams of the users whose inbox
+// changed are woken up once it committed
+func (s *NotificationService) ApplyAuctionEvent(ctx context.Context, envelope messaging.Envelope) error {
+	var inbox []string
+
+	err := s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		inbox = nil
+
+		switch envelope.Type {
+		case events.TypeAuctionCreated:
+			return s.auctionCreated(ctx, envelope)
+		case events.TypeAuctionUpdated:
+			return s.auctionUpdated(ctx, envelope)
+		case events.TypeBidPlaced:
+			return s.bidPlaced(ctx, envelope, &inbox)
+		case events.TypeAuctionClosed:
+			return s.auctionClosed(ctx, envelope, &inbox)
+		case events.TypeAuctionCancelled:
+			return s.auctionCancelled(ctx, envelope, &inbox)
+		default:
+			return nil
+		}
+	})
+
+	if err != nil {
+		s.logger.Error("NotificationService.ApplyAuctionEvent failed", zap.Error(err), zap.String("id", envelope.ID), zap.String("type", envelope.Type))
+		return fmt.Errorf("NotificationService.ApplyAuctionEvent %w", err)
+	}
+
+	s.unread.Changed(inbox...)
+
+	return nil
+}
+
+func (s *NotificationService) auctionCreated(ctx context.Context, envelope messaging.Envelope) error {
+	created, err := messaging.Payload[events.AuctionCreated](envelope)
+
+	if err != nil {
+		return messaging.Permanent(err)
+	}
+
+	auction, err := s.repo.LockAuction(ctx, created.AuctionID)
+
+	if err != nil {
+		return err
+	}
+
+	auction.SellerID, auction.Description = created.SellerID, created.Description
+
+	return s.repo.SaveAuction(ctx, auction)
+}
+
+func (s *NotificationService) auctionUpdated(ctx context.Context, envelope messaging.Envelope) error {
+	updated, err := messaging.Payload[events.AuctionUpdated](envelope)
+
+	if err != nil {
+		return messaging.Permanent(err)
+	}
+
+	if updated.Description == "" {
+		return nil
+	}
+
+	auction, err := s.repo.LockAuction(ctx, updated.AuctionID)
+
+	if err != nil {
+		return err
+	}
+
+	auction.Description = updated.Description
+
+	return s.repo.SaveAuction(ctx, auction)
+}
+
+// bidPlaced tells the leader they were outbid and the seller of the new leading bid. A bid arriving after a
+// higher one tells its own bidder, who was outbid as soon as the higher bid was placed
+func (s *NotificationService) bidPlaced(ctx context.Context, envelope messaging.Envelope, inbox *[]string) error {
+	placed, err := messaging.Payload[events.BidPlaced](envelope)
+
+	if err != nil {
+		return messaging.Permanent(err)
+	}
+
+	auction, err := s.repo.LockAuction(ctx, placed.AuctionID)
+
+	if err != nil {
+		return err
+	}
+
+	if err = s.repo.AddBidder(ctx, placed.AuctionID, placed.BidderID); err != nil {
+		return err
+	}
+
+	if placed.Amount <= auction.LeadingAmount {
+		if placed.BidderID == auction.LeaderID {
+			return nil
+		}
+
+		return s.notify(ctx, inbox, placed.BidderID, notification.KindOutbid, envelope.ID, notification.Data{
+			AuctionID:   auction.ID,
+			Description: auction.Description,
+			Amount:      auction.LeadingAmount,
+			YourBid:     placed.Amount,
+		})
+	}
+
+	outbid, outbidAmount := auction.LeaderID, auction.LeadingAmount
+	auction.LeaderID, auction.LeadingAmount = placed.BidderID, placed.Amount
+
+	if err = s.repo.SaveAuction(ctx, auction); err != nil {
+		return err
+	}
+
+	if auction.SellerID != "" && auction.SellerID != placed.BidderID {
+		err = s.notify(ctx, inbox, auction.SellerID, notification.KindBidReceived, envelope.ID, notification.Data{
+			AuctionID:   auction.ID,
+			Description: auction.Description,
+			BidderID:    placed.BidderID,
+			Amount:      placed.Amount,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if outbid == "" || outbid == placed.BidderID {
+		return nil
+	}
+
+	return s.notify(ctx, inbox, outbid, notification.KindOutbid, envelope.ID, notification.Data{
+		AuctionID:   auction.ID,
+		Description: auction.Description,
+		Amount:      placed.Amount,
+		YourBid:     outbidAmount,
+	})
+}
+
+// auctionClosed tells the seller how their auction ended, and the winner they won and what they owe
+func (s *NotificationService) auctionClosed(ctx context.Context, envelope messaging.Envelope, inbox *[]string) error {
+	closed, err := messaging.Payload[events.AuctionClosed](envelope)
+
+	if err != nil {
+		return messaging.Permanent(err)
+	}
+
+	auction, err := s.repo.LockAuction(ctx, closed.AuctionID)
+
+	if err != nil {
+		return err
+	}
+
+	data := notification.Data{
+		AuctionID:   auction.ID,
+		Description: auction.Description,
+		BidderID:    closed.WinnerID,
+		Amount:      closed.FinalBid,
+	}
+
+	if auction.SellerID != "" {
+		if err = s.notify(ctx, inbox, auction.SellerID, notification.KindAuctionClosed, envelope.ID, data); err != nil {
+			return err
+		}
+	}
+
+	if closed.WinnerID == "" {
+		return nil
+	}
+
+	if err = s.notify(ctx, inbox, closed.WinnerID, notification.KindWon, envelope.ID, data); err != nil {
+		return err
+	}
+
+	closedAt := closed.ClosedAt
+
+	if closedAt.IsZero() {
+		closedAt = envelope.OccurredAt
+	}
+	data.DueAt = closedAt.Add(notification.PaymentDueAfter)
+
+	return s.notify(ctx, inbox, closed.WinnerID, notification.KindPaymentDue, envelope.ID, data)
+}
+
+// auctionCancelled tells everyone who bid on the auction
+func (s *NotificationService) auctionCancelled(ctx context.Context, envelope messaging.Envelope, inbox *[]string) error {
+	cancelled, err := messaging.Payload[events.AuctionCancelled](envelope)
+
+	if err != nil {
+		return messaging.Permanent(err)
+	}
+
+	auction, err := s.repo.LockAuction(ctx, cancelled.AuctionID)
+
+	if err != nil {
+		return err
+	}
+
+	bidders, err := s.repo.Bidders(ctx, cancelled.AuctionID)
+
+	if err != nil {
+		return err
+	}
+
+	for _, bidderID := range bidders {
+		err = s.notify(ctx, inbox, bidderID, notification.KindCancelled, envelope.ID, notification.Data{
+			AuctionID:   auction.ID,
+			Description: auction.Description,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// notify renders the notification and stores it for the channels the user wants the kind on. The email
+// and webhook deliveries wait for the digest or the end of the quiet hours when the preferences say so, the
+// users whose inbox got the notification are added to inbox
+func (s *NotificationService) notify(ctx context.Context, inbox *[]string, userID string, kind notification.Kind, eventID string, data notification.Data) error {
+	preferences, err := s.repo.Preferences(ctx, userID)
+
+	if err != nil {
+		return err
+	}
+
+	channels := preferences.ChannelsFor(kind)
+
+	if len(channels) == 0 {
+		return nil
+	}
+
+	if data.Description == "" {
+		data.Description = "auction " + data.AuctionID
+	}
+
+	subject, body, err := s.templates.Render(kind, data)
+
+	if err != nil {
+		return messaging.Permanent(err)
+	}
+
+	now := s.now().UTC()
+	n := notification.Notification{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Kind:      kind,
+		EventID:   eventID,
+		AuctionID: data.AuctionID,
+		Subject:   subject,
+		Body:      body,
+		Data:      data,
+		CreatedAt: now,
+	}
+
+	var deliveries []notification.Delivery
+	deliverAfter, digest := preferences.DeliverAfter(kind, now)
+
+	for _, channel := range channels {
+		if channel == notification.ChannelInApp {
+			n.InApp = true
+			continue
+		}
+
+		// there is nowhere to send it until the user tells
+		if preferences.Address(channel) == "" {
+			continue
+		}
+
+		deliveries = append(deliveries, notification.Delivery{
+			ID:           uuid.NewString(),
+			Channel:      channel,
+			Digest:       digest,
+			DeliverAfter: deliverAfter,
+		})
+	}
+
+	if !n.InApp && len(deliveries) == 0 {
+		return nil
+	}
+
+	stored, err := s.repo.Store(ctx, n, deliveries)
+
+	if err != nil {
+		return err
+	}
+
+	if !stored {
+		return nil
+	}
+
+	s.logger.Debug("NotificationService notified", zap.String("userId", userID), zap.String("kind", string(kind)), zap.String("eventId", eventID))
+
+	if n.InApp {
+		*inbox = append(*inbox, userID)
+	}
+
+	return nil
+}
+
+// ForgetUser deletes what notification-service keeps of a deleted user
+func (s *NotificationService) ForgetUser(ctx context.Context, userID string) error {
+	if err := s.repo.ForgetUser(ctx, userID); err != nil {
+		s.logger.Error("NotificationService.ForgetUser failed", zap.Error(err), zap.String("id", userID))
+		return fmt.Errorf("NotificationService.ForgetUser %w", err)
+	}
+
+	s.unread.Changed(userID)
+
+	return nil
+}
+
+func (s *NotificationService) Inbox(ctx context.Context, filter notification.InboxFilter) ([]notification.Notification, error) {
+	userID, err := requestUser(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("NotificationService.Inbox %w", err)
+	}
+
+	filter.UserID = userID
+	inbox, err := s.repo.Inbox(ctx, filter.WithLimit())
+
+	if err != nil {
+		s.logger.Error("NotificationService.Inbox failed", zap.Error(err), zap.String("userId", userID))
+		return nil, fmt.Errorf("NotificationService.Inbox %w", err)
+	}
+
+	return inbox, nil
+}
+
+func (s *NotificationService) MarkRead(ctx context.Context, id string) error {
+	userID, err := requestUser(ctx)
+
+	if err != nil {
+		return fmt.Errorf("NotificationService.MarkRead %w", err)
+	}
+
+	if err = s.repo.MarkRead(ctx, userID, id); err != nil {
+		return fmt.Errorf("NotificationService.MarkRead %w", err)
+	}
+
+	s.unread.Changed(userID)
+
+	return nil
+}
+
+func (s *NotificationService) MarkAllRead(ctx context.Context) (int64, error) {
+	userID, err := requestUser(ctx)
+
+	if err != nil {
+		return 0, fmt.Errorf("NotificationService.MarkAllRead %w", err)
+	}
+
+	marked, err := s.repo.MarkAllRead(ctx, userID)
+
+	if err != nil {
+		s.logger.Error("NotificationService.MarkAllRead failed", zap.Error(err), zap.String("userId", userID))
+		return 0, fmt.Errorf("NotificationService.MarkAllRead %w", err)
+	}
+
+	if marked > 0 {
+		s.unread.Changed(userID)
+	}
+
+	return marked, nil
+}
+
+func (s *NotificationService) UnreadCount(ctx context.Context) (int, error) {
+	userID, err := requestUser(ctx)
+
+	if err != nil {
+		return 0, fmt.Errorf("NotificationService.UnreadCount %w", err)
+	}
+
+	count, err := s.repo.UnreadCount(ctx, userID)
+
+	if err != nil {
+		return 0, fmt.Errorf("NotificationService.UnreadCount %w", err)
+	}
+
+	return count, nil
+}
+
+// UnreadCounts counts again when the inbox changes on this instance and every poll interval, a count is
+// only sent when it differs from the last one
+func (s *NotificationService) UnreadCounts(ctx context.Context, send func(count int) error) error {
+	userID, err := requestUser(ctx)
+
+	if err != nil {
+		return fmt.Errorf("NotificationService.UnreadCounts %w", err)
+	}
+
+	changed, unsubscribe := s.unread.Subscribe(userID)
+	defer unsubscribe()
+
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	last := -1
+
+	for {
+		count, err := s.repo.UnreadCount(ctx, userID)
+
+		if err != nil {
+			return fmt.Errorf("NotificationService.UnreadCounts %w", err)
+		}
+
+		if count != last {
+			if err = send(count); err != nil {
+				return fmt.Errorf("NotificationService.UnreadCounts %w", err)
+			}
+			last = count
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *NotificationService) Preferences(ctx context.Context) (notification.Preferences, error) {
+	userID, err := requestUser(ctx)
+
+	if err != nil {
+		return notification.Preferences{}, fmt.Errorf("NotificationService.Preferences %w", err)
+	}
+
+	preferences, err := s.repo.Preferences(ctx, userID)
+
+	if err != nil {
+		s.logger.Error("NotificationService.Preferences failed", zap.Error(err), zap.String("userId", userID))
+		return notification.Preferences{}, fmt.Errorf("NotificationService.Preferences %w", err)
+	}
+
+	return preferences, nil
+}
+
+// UpdatePreferences replaces the preferences of the user, the notifications already raised keep the
+// channels and times they were given
+func (s *NotificationService) UpdatePreferences(ctx context.Context, preferences notification.Preferences) (notification.Preferences, error) {
+	userID, err := requestUser(ctx)
+
+	if err != nil {
+		return notification.Preferences{}, fmt.Errorf("NotificationService.UpdatePreferences %w", err)
+	}
+
+	preferences.UserID = userID
+
+	if preferences.Channels == nil {
+		preferences.Channels = map[notification.Kind][]notification.Channel{}
+	}
+
+	if preferences.DigestMinutes == 0 {
+		preferences.DigestMinutes = notification.DefaultDigestMinutes
+	}
+
+	if err = preferences.Validate(); err != nil {
+		return notification.Preferences{}, fmt.Errorf("NotificationService.UpdatePreferences %w", err)
+	}
+
+	if err = s.repo.SavePreferences(ctx, preferences); err != nil {
+		s.logger.Error("NotificationService.UpdatePreferences failed", zap.Error(err), zap.String("userId", userID))
+		return notification.Preferences{}, fmt.Errorf("NotificationService.UpdatePreferences %w", err)
+	}
+
+	// the email is not part of the update, it is the verified one
+	saved, err := s.repo.Preferences(ctx, userID)
+
+	if err != nil {
+		s.logger.Error("NotificationService.UpdatePreferences failed", zap.Error(err), zap.String("userId", userID))
+		return notification.Preferences{}, fmt.Errorf("NotificationService.UpdatePreferences %w", err)
+	}
+
+	return saved, nil
+}
+
+// RequestEmail sends a verification token to the address, the email of the user changes only once it is
+// verified. A user has at most maxPendingEmailVerifications tokens out at a time
+func (s *NotificationService) RequestEmail(ctx context.Context, email string) error {
+	userID, err := requestUser(ctx)
+
+	if err != nil {
+		return fmt.Errorf("NotificationService.RequestEmail %w", err)
+	}
+
+	address, err := mail.ParseAddress(email)
+
+	if err != nil {
+		return fmt.Errorf("NotificationService.RequestEmail invalid email %w", notification.ErrBadRequest)
+	}
+
+	now := s.now().UTC()
+	pending, err := s.repo.PendingEmailVerifications(ctx, userID, now)
+
+	if err != nil {
+		s.logger.Error("NotificationService.RequestEmail failed", zap.Error(err), zap.String("userId", userID))
+		return fmt.Errorf("NotificationService.RequestEmail %w", err)
+	}
+
+	if pending >= maxPendingEmailVerifications {
+		return fmt.Errorf("NotificationService.RequestEmail %d verifications pending %w", pending, notification.ErrTooManyRequests)
+	}
+
+	token, err := generateToken()
+
+	if err != nil {
+		return fmt.Errorf("NotificationService.RequestEmail %w", err)
+	}
+
+	if err = s.repo.SaveEmailVerification(ctx, hashToken(token), userID, address.Address, now.Add(emailVerificationTTL)); err != nil {
+		s.logger.Error("NotificationService.RequestEmail failed", zap.Error(err), zap.String("userId", userID))
+		return fmt.Errorf("NotificationService.RequestEmail %w", err)
+	}
+
+	msg := channel.Message{
+		UserID:  userID,
+		To:      address.Address,
+		Subject: "Verify your notifications email",
+		Body:    fmt.Sprintf("Use the following token to receive your auction notifications at this email: %s\nThe token expires in %d hours.", token, int(emailVerificationTTL.Hours())),
+	}
+
+	if err = s.email.Send(ctx, msg); err != nil {
+		s.logger.Error("NotificationService.RequestEmail failed sending verification email", zap.Error(err), zap.String("userId", userID))
+		return fmt.Errorf("NotificationService.RequestEmail failed sending verification email %w", err)
+	}
+
+	return nil
+}
+
+// VerifyEmail makes the address the token was mailed to the email of the user, the token must have been
+// requested by the same user
+func (s *NotificationService) VerifyEmail(ctx context.Context, token string) (notification.Preferences, error) {
+	userID, err := requestUser(ctx)
+
+	if err != nil {
+		return notification.Preferences{}, fmt.Errorf("NotificationService.VerifyEmail %w", err)
+	}
+
+	if token == "" {
+		return notification.Preferences{}, fmt.Errorf("NotificationService.VerifyEmail missing token %w", notification.ErrBadRequest)
+	}
+
+	err = s.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		email, err := s.repo.ConsumeEmailVerification(ctx, hashToken(token), userID, s.now().UTC())
+
+		if err != nil {
+			return err
+		}
+
+		return s.repo.SaveEmail(ctx, userID, email)
+	})
+
+	if errors.Is(err, notification.ErrNotFound) {
+		return notification.Preferences{}, fmt.Errorf("NotificationService.VerifyEmail invalid or expired token %w", notification.ErrBadRequest)
+	}
+
+	if err != nil {
+		s.logger.Error("NotificationService.VerifyEmail failed", zap.Error(err), zap.String("userId", userID))
+		return notification.Preferences{}, fmt.Errorf("NotificationService.VerifyEmail %w", err)
+	}
+
+	return s.Preferences(ctx)
+}
+
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken - the verification tokens are stored hashed so a leaked table can't be used to verify addresses
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// requestUser is the user the request acts as, an admin impersonating a user manages their notifications
+func requestUser(ctx context.Context) (string, error) {
+	identities, ok := http2.IdentitiesFromContext(ctx)
+
+	if !ok || identities.Subject == "" {
+		return "", notification.ErrUnAuthorized
+	}
+
+	return identities.Subject, nil
+}
@@ -0,0 +1,499 @@
+package internal
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ireuven89/auctions/notification-service/notification"
+	"github.com/ireuven89/auctions/shared/events"
+	http2 "github.com/ireuven89/auctions/shared/http"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeRepo keeps everything in memory, notifications are keyed like the unique key of the table
+type fakeRepo struct {
+	auctions      map[string]notification.Auction
+	bidders       map[string][]string
+	preferences   map[string]notification.Preferences
+	notifications map[string]notification.Notification
+	deliveries    map[string][]notification.Delivery
+	verifications map[string]fakeVerification
+}
+
+type fakeVerification struct {
+	userID    string
+	email     string
+	expiresAt time.Time
+}
+
+func newFakeRepo() *fakeRepo {
+
+	return &fakeRepo{
+		auctions:      map[string]notification.Auction{},
+		bidders:       map[string][]string{},
+		preferences:   map[string]notification.Preferences{},
+		notifications: map[string]notification.Notification{},
+		deliveries:    map[string][]notification.Delivery{},
+		verifications: map[string]fakeVerification{},
+	}
+}
+
+func notificationKey(userID string, kind notification.Kind, eventID string) string {
+
+	return userID + "/" + string(kind) + "/" + eventID
+}
+
+func (r *fakeRepo) LockAuction(ctx context.Context, id string) (notification.Auction, error) {
+	if auction, ok := r.auctions[id]; ok {
+		return auction, nil
+	}
+
+	return notification.Auction{ID: id}, nil
+}
+
+func (r *fakeRepo) SaveAuction(ctx context.Context, auction notification.Auction) error {
+	r.auctions[auction.ID] = auction
+
+	return nil
+}
+
+func (r *fakeRepo) AddBidder(ctx context.Context, auctionID, bidderID string) error {
+	for _, id := range r.bidders[auctionID] {
+		if id == bidderID {
+			return nil
+		}
+	}
+	r.bidders[auctionID] = append(r.bidders[auctionID], bidderID)
+
+	return nil
+}
+
+func (r *fakeRepo) Bidders(ctx context.Context, auctionID string) ([]string, error) {
+
+	return r.bidders[auctionID], nil
+}
+
+func (r *fakeRepo) Preferences(ctx context.Context, userID string) (notification.Preferences, error) {
+	if preferences, ok := r.preferences[userID]; ok {
+		return preferences, nil
+	}
+
+	return notification.DefaultPreferences(userID), nil
+}
+
+func (r *fakeRepo) SavePreferences(ctx context.Context, preferences notification.Preferences) error {
+	preferences.Email = r.preferences[preferences.UserID].Email
+	r.preferences[preferences.UserID] = preferences
+
+	return nil
+}
+
+func (r *fakeRepo) SaveEmail(ctx context.Context, userID, email string) error {
+	preferences, _ := r.Preferences(ctx, userID)
+	preferences.Email = email
+	r.preferences[userID] = preferences
+
+	return nil
+}
+
+func (r *fakeRepo) SaveEmailVerification(ctx context.Context, tokenHash, userID, email string, expiresAt time.Time) error {
+	r.verifications[tokenHash] = fakeVerification{userID: userID, email: email, expiresAt: expiresAt}
+
+	return nil
+}
+
+func (r *fakeRepo) PendingEmailVerifications(ctx context.Context, userID string, now time.Time) (int, error) {
+	count := 0
+
+	for _, v := range r.verifications {
+		if v.userID == userID && v.expiresAt.After(now) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (r *fakeRepo) ConsumeEmailVerification(ctx context.Context, tokenHash, userID string, now time.Time) (string, error) {
+	v, ok := r.verifications[tokenHash]
+
+	if !ok || v.userID != userID || !v.expiresAt.After(now) {
+		return "", notification.ErrNotFound
+	}
+
+	for hash, other := range r.verifications {
+		if other.userID == userID {
+			delete(r.verifications, hash)
+		}
+	}
+
+	return v.email, nil
+}
+
+func (r *fakeRepo) Store(ctx context.Context, n notification.Notification, deliveries []notification.Delivery) (bool, error) {
+	key := notificationKey(n.UserID, n.Kind, n.EventID)
+
+	if _, ok := r.notifications[key]; ok {
+		return false, nil
+	}
+
+	n.Seq = int64(len(r.notifications) + 1)
+	r.notifications[key] = n
+	r.deliveries[key] = deliveries
+
+	return true, nil
+}
+
+func (r *fakeRepo) ForgetUser(ctx context.Context, userID string) error {
+	delete(r.preferences, userID)
+
+	return nil
+}
+
+// inbox is the in-app notifications of the user, newest first
+func (r *fakeRepo) inbox(userID string) []*notification.Notification {
+	var inbox []*notification.Notification
+
+	for key := range r.notifications {
+		if n := r.notifications[key]; n.UserID == userID && n.InApp {
+			inbox = append(inbox, &n)
+		}
+	}
+
+	sort.Slice(inbox, func(i, j int) bool { return inbox[i].Seq > inbox[j].Seq })
+
+	return inbox
+}
+
+func (r *fakeRepo) Inbox(ctx context.Context, filter notification.InboxFilter) ([]notification.Notification, error) {
+	var page []notification.Notification
+
+	for _, n := range r.inbox(filter.UserID) {
+		if (filter.Unread && n.ReadAt != nil) || (filter.Before > 0 && n.Seq >= filter.Before) || len(page) == filter.Limit {
+			continue
+		}
+		page = append(page, *n)
+	}
+
+	return page, nil
+}
+
+func (r *fakeRepo) MarkRead(ctx context.Context, userID, id string) error {
+	for key, n := range r.notifications {
+		if n.ID == id && n.UserID == userID && n.InApp {
+			if n.ReadAt == nil {
+				now := time.Now()
+				n.ReadAt = &now
+				r.notifications[key] = n
+			}
+			return nil
+		}
+	}
+
+	return notification.ErrNotFound
+}
+
+func (r *fakeRepo) MarkAllRead(ctx context.Context, userID string) (int64, error) {
+	var marked int64
+
+	for _, n := range r.inbox(userID) {
+		if n.ReadAt == nil {
+			marked++
+			_ = r.MarkRead(ctx, userID, n.ID)
+		}
+	}
+
+	return marked, nil
+}
+
+func (r *fakeRepo) UnreadCount(ctx context.Context, userID string) (int, error) {
+	unread := 0
+
+	for _, n := range r.inbox(userID) {
+		if n.ReadAt == nil {
+			unread++
+		}
+	}
+
+	return unread, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) WithinTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+
+	return fn(ctx)
+}
+
+func newTestService(t *testing.T, repo *fakeRepo, now time.Time) *NotificationService {
+	templates, err := notification.NewTemplates()
+	require.NoError(t, err)
+
+	s := NewService(repo, fakeTx{}, templates, NewUnreadHub(), &fakeChannel{}, zap.NewNop()).(*NotificationService)
+	s.now = func() time.Time { return now }
+
+	return s
+}
+
+func auctionEvent(t *testing.T, eventType string, payload interface{}) messaging.Envelope {
+	envelope, err := events.NewEnvelope(context.Background(), eventType, payload)
+	require.NoError(t, err)
+
+	return envelope
+}
+
+func TestApplyAuctionEvent(t *testing.T) {
+	repo := newFakeRepo()
+	now := time.Date(2026, 3, 2, 10, 20, 0, 0, time.UTC)
+	s := newTestService(t, repo, now)
+	ctx := context.Background()
+
+	repo.preferences["alice"] = notification.Preferences{
+		UserID:   "alice",
+		Email:    "alice@example.com",
+		Channels: notification.DefaultPreferences("alice").Channels,
+	}
+
+	require.NoError(t, s.ApplyAuctionEvent(ctx, auctionEvent(t, events.TypeAuctionCreated, events.AuctionCreated{
+		AuctionID: "auction", SellerID: "seller", Description: "a bike", Status: "active", CreatedAt: now,
+	})))
+
+	first := auctionEvent(t, events.TypeBidPlaced, events.BidPlaced{BidID: "1", AuctionID: "auction", BidderID: "alice", Amount: 100, PlacedAt: now})
+	require.NoError(t, s.ApplyAuctionEvent(ctx, first))
+	assert.Len(t, repo.notifications, 1)
+	received := repo.notifications[notificationKey("seller", notification.KindBidReceived, first.ID)]
+	assert.Equal(t, "New bid of 100.00 on a bike", received.Subject)
+	assert.Equal(t, "alice", received.Data.BidderID)
+
+	outbid := auctionEvent(t, events.TypeBidPlaced, events.BidPlaced{BidID: "2", AuctionID: "auction", BidderID: "bob", Amount: 120, PlacedAt: now})
+	require.NoError(t, s.ApplyAuctionEvent(ctx, outbid))
+	// delivered again
+	require.NoError(t, s.ApplyAuctionEvent(ctx, outbid))
+
+	require.Len(t, repo.notifications, 3)
+	n := repo.notifications[notificationKey("alice", notification.KindOutbid, outbid.ID)]
+	assert.Equal(t, "You have been outbid on a bike", n.Subject)
+	assert.Equal(t, 100.0, n.Data.YourBid)
+	assert.Equal(t, 120.0, n.Data.Amount)
+	assert.True(t, n.InApp)
+
+	// alice does not digest outbids, the email goes right away
+	deliveries := repo.deliveries[notificationKey("alice", notification.KindOutbid, outbid.ID)]
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, notification.ChannelEmail, deliveries[0].Channel)
+	assert.Equal(t, now, deliveries[0].DeliverAfter)
+	assert.False(t, deliveries[0].Digest)
+
+	// a lower bid arriving late tells its own bidder, carol has no email so only her inbox is written
+	late := auctionEvent(t, events.TypeBidPlaced, events.BidPlaced{BidID: "3", AuctionID: "auction", BidderID: "carol", Amount: 110, PlacedAt: now})
+	require.NoError(t, s.ApplyAuctionEvent(ctx, late))
+	assert.Contains(t, repo.notifications, notificationKey("carol", notification.KindOutbid, late.ID))
+	assert.Empty(t, repo.deliveries[notificationKey("carol", notification.KindOutbid, late.ID)])
+	assert.Equal(t, "bob", repo.auctions["auction"].LeaderID)
+
+	closed := auctionEvent(t, events.TypeAuctionClosed, events.AuctionClosed{AuctionID: "auction", WinnerID: "bob", FinalBid: 120, ClosedAt: now})
+	require.NoError(t, s.ApplyAuctionEvent(ctx, closed))
+	assert.Contains(t, repo.notifications, notificationKey("bob", notification.KindWon, closed.ID))
+	assert.Equal(t, "a bike sold for 120.00", repo.notifications[notificationKey("seller", notification.KindAuctionClosed, closed.ID)].Subject)
+	due := repo.notifications[notificationKey("bob", notification.KindPaymentDue, closed.ID)]
+	assert.Equal(t, now.Add(notification.PaymentDueAfter), due.Data.DueAt)
+
+	cancelled := auctionEvent(t, events.TypeAuctionCancelled, events.AuctionCancelled{AuctionID: "auction", CancelledAt: now})
+	require.NoError(t, s.ApplyAuctionEvent(ctx, cancelled))
+
+	for _, bidder := range []string{"alice", "bob", "carol"} {
+		assert.Contains(t, repo.notifications, notificationKey(bidder, notification.KindCancelled, cancelled.ID), bidder)
+	}
+}
+
+func TestApplyAuctionEventPreferences(t *testing.T) {
+	repo := newFakeRepo()
+	now := time.Date(2026, 3, 2, 23, 20, 0, 0, time.UTC)
+	s := newTestService(t, repo, now)
+	ctx := context.Background()
+
+	// dave only wants a webhook when they win, and not at night
+	repo.preferences["dave"] = notification.Preferences{
+		UserID:     "dave",
+		WebhookURL: "https://example.com/hook",
+		Channels:   map[notification.Kind][]notification.Channel{notification.KindWon: {notification.ChannelWebhook}},
+		QuietHours: &notification.QuietHours{Start: "22:00", End: "07:00", TimeZone: "UTC"},
+	}
+
+	repo.auctions["auction"] = notification.Auction{ID: "auction", LeaderID: "dave", LeadingAmount: 50}
+	outbid := auctionEvent(t, events.TypeBidPlaced, events.BidPlaced{BidID: "1", AuctionID: "auction", BidderID: "erin", Amount: 60, PlacedAt: now})
+	require.NoError(t, s.ApplyAuctionEvent(ctx, outbid))
+	assert.Empty(t, repo.notifications)
+
+	closed := auctionEvent(t, events.TypeAuctionClosed, events.AuctionClosed{AuctionID: "auction", WinnerID: "dave", FinalBid: 50, ClosedAt: now})
+	require.NoError(t, s.ApplyAuctionEvent(ctx, closed))
+
+	key := notificationKey("dave", notification.KindWon, closed.ID)
+	assert.False(t, repo.notifications[key].InApp)
+	assert.Equal(t, "auction auction", repo.notifications[key].Data.Description)
+	require.Len(t, repo.deliveries[key], 1)
+	assert.Equal(t, notification.ChannelWebhook, repo.deliveries[key][0].Channel)
+	assert.Equal(t, time.Date(2026, 3, 3, 7, 0, 0, 0, time.UTC), repo.deliveries[key][0].DeliverAfter)
+	assert.True(t, repo.deliveries[key][0].Digest)
+	assert.NotContains(t, repo.notifications, notificationKey("dave", notification.KindPaymentDue, closed.ID))
+}
+
+func TestUpdatePreferences(t *testing.T) {
+	repo := newFakeRepo()
+	s := newTestService(t, repo, time.Now())
+
+	_, err := s.UpdatePreferences(context.Background(), notification.Preferences{})
+	assert.ErrorIs(t, err, notification.ErrUnAuthorized)
+
+	ctx := http2.ContextWithClaims(context.Background(), jwt.MapClaims{"sub": "alice"})
+
+	_, err = s.UpdatePreferences(ctx, notification.Preferences{WebhookURL: "http://example.com"})
+	assert.ErrorIs(t, err, notification.ErrBadRequest)
+
+	require.NoError(t, repo.SaveEmail(ctx, "alice", "alice@example.com"))
+
+	// the email is the verified one whatever the update says
+	updated, err := s.UpdatePreferences(ctx, notification.Preferences{
+		Email:    "mallory@example.com",
+		Channels: map[notification.Kind][]notification.Channel{notification.KindWon: {notification.ChannelEmail}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", updated.UserID)
+	assert.Equal(t, "alice@example.com", updated.Email)
+	assert.Equal(t, notification.DefaultDigestMinutes, updated.DigestMinutes)
+
+	preferences, err := s.Preferences(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, updated, preferences)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	repo := newFakeRepo()
+	now := time.Date(2026, 3, 2, 10, 20, 0, 0, time.UTC)
+	s := newTestService(t, repo, now)
+	mailed := s.email.(*fakeChannel)
+	alice := http2.ContextWithClaims(context.Background(), jwt.MapClaims{"sub": "alice"})
+	mallory := http2.ContextWithClaims(context.Background(), jwt.MapClaims{"sub": "mallory"})
+
+	assert.ErrorIs(t, s.RequestEmail(alice, "not an email"), notification.ErrBadRequest)
+
+	require.NoError(t, s.RequestEmail(alice, "Alice <alice@example.com>"))
+	require.Len(t, mailed.messages, 1)
+	assert.Equal(t, "alice@example.com", mailed.messages[0].To)
+
+	token := strings.TrimPrefix(strings.Split(mailed.messages[0].Body, "\n")[0], "Use the following token to receive your auction notifications at this email: ")
+
+	// the token is stored hashed and the email is unchanged until it is verified
+	assert.Contains(t, repo.verifications, hashToken(token))
+	preferences, err := s.Preferences(alice)
+	require.NoError(t, err)
+	assert.Empty(t, preferences.Email)
+
+	// only the user who asked for it can use the token
+	_, err = s.VerifyEmail(mallory, token)
+	assert.ErrorIs(t, err, notification.ErrBadRequest)
+
+	preferences, err = s.VerifyEmail(alice, token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", preferences.Email)
+
+	_, err = s.VerifyEmail(alice, token)
+	assert.ErrorIs(t, err, notification.ErrBadRequest)
+
+	// a user can't mail tokens to any number of addresses
+	for i := 0; i < maxPendingEmailVerifications; i++ {
+		require.NoError(t, s.RequestEmail(alice, "alice@example.com"))
+	}
+	assert.ErrorIs(t, s.RequestEmail(alice, "alice@example.com"), notification.ErrTooManyRequests)
+}
+
+func TestInbox(t *testing.T) {
+	repo := newFakeRepo()
+	now := time.Date(2026, 3, 2, 10, 20, 0, 0, time.UTC)
+	s := newTestService(t, repo, now)
+	ctx := http2.ContextWithClaims(context.Background(), jwt.MapClaims{"sub": "alice"})
+
+	repo.auctions["auction"] = notification.Auction{ID: "auction", Description: "a bike"}
+	repo.bidders["auction"] = []string{"alice"}
+
+	for _, amount := range []float64{100, 110, 120} {
+		repo.auctions["auction"] = notification.Auction{ID: "auction", Description: "a bike", LeaderID: "alice", LeadingAmount: amount - 5}
+		require.NoError(t, s.ApplyAuctionEvent(context.Background(), auctionEvent(t, events.TypeBidPlaced, events.BidPlaced{
+			BidID: "bid", AuctionID: "auction", BidderID: "bob", Amount: amount, PlacedAt: now,
+		})))
+	}
+
+	count, err := s.UnreadCount(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	page, err := s.Inbox(ctx, notification.InboxFilter{Limit: 2})
+	assert.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, 120.0, page[0].Data.Amount)
+
+	next, err := s.Inbox(ctx, notification.InboxFilter{Before: page[1].Seq})
+	assert.NoError(t, err)
+	require.Len(t, next, 1)
+	assert.Equal(t, 100.0, next[0].Data.Amount)
+
+	assert.NoError(t, s.MarkRead(ctx, next[0].ID))
+	assert.ErrorIs(t, s.MarkRead(ctx, "unknown"), notification.ErrNotFound)
+
+	unread, err := s.Inbox(ctx, notification.InboxFilter{Unread: true})
+	assert.NoError(t, err)
+	assert.Len(t, unread, 2)
+
+	// bob cannot read the inbox of alice
+	bob := http2.ContextWithClaims(context.Background(), jwt.MapClaims{"sub": "bob"})
+	assert.ErrorIs(t, s.MarkRead(bob, page[0].ID), notification.ErrNotFound)
+
+	marked, err := s.MarkAllRead(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), marked)
+
+	count, err = s.UnreadCount(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestUnreadCounts(t *testing.T) {
+	repo := newFakeRepo()
+	now := time.Date(2026, 3, 2, 10, 20, 0, 0, time.UTC)
+	s := newTestService(t, repo, now)
+	s.pollInterval = time.Hour
+	ctx, cancel := context.WithCancel(http2.ContextWithClaims(context.Background(), jwt.MapClaims{"sub": "alice"}))
+	defer cancel()
+
+	counts := make(chan int)
+	done := make(chan error)
+
+	go func() {
+		done <- s.UnreadCounts(ctx, func(count int) error {
+			counts <- count
+			return nil
+		})
+	}()
+
+	assert.Equal(t, 0, <-counts)
+
+	repo.auctions["auction"] = notification.Auction{ID: "auction", LeaderID: "alice", LeadingAmount: 100}
+	require.NoError(t, s.ApplyAuctionEvent(context.Background(), auctionEvent(t, events.TypeBidPlaced, events.BidPlaced{
+		BidID: "bid", AuctionID: "auction", BidderID: "bob", Amount: 110, PlacedAt: now,
+	})))
+	assert.Equal(t, 1, <-counts)
+
+	_, err := s.MarkAllRead(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, <-counts)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
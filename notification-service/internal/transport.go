@@ -0,0 +1,309 @@
+package internal
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/ireuven89/auctions/notification-service/notification"
+	http2 "github.com/ireuven89/auctions/shared/http"
+	"github.com/julienschmidt/httprouter"
+)
+
+type Transport struct {
+	router *httprouter.Router
+	s      Service
+}
+
+func NewTransport(router *httprouter.Router, s Service) Transport {
+	transport := Transport{
+		router: router,
+		s:      s,
+	}
+
+	RegisterRoutes(router, s)
+
+	return transport
+}
+
+// ListenAndServe serves the users with a JWT, the notifications are those of the subject of the token.
+// Requests made while impersonating the subject are handed to impersonations
+func (t *Transport) ListenAndServe(port string, publicKey *rsa.PublicKey, impersonations http2.ImpersonationRecorder) {
+	log.Printf("Starting notification server on port %s...", port)
+	authMw := http2.AuthMiddleware(publicKey, nil, []string{"/health"})
+	audit := http2.AuditImpersonation(impersonations)
+	err := http.ListenAndServe(":"+port, authMw(audit(t.router)))
+	if err != nil {
+		log.Fatalf("Server failed to start: %v", err)
+	}
+}
+
+func RegisterRoutes(router *httprouter.Router, s Service) {
+
+	getPreferencesHandler := kithttp.NewServer(
+		MakeEndpointGetPreferences(s),
+		decodeGetPreferencesRequest,
+		encodePreferencesResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	updatePreferencesHandler := kithttp.NewServer(
+		MakeEndpointUpdatePreferences(s),
+		decodeUpdatePreferencesRequest,
+		encodePreferencesResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	requestEmailHandler := kithttp.NewServer(
+		MakeEndpointRequestEmail(s),
+		decodeRequestEmailRequest,
+		kithttp.EncodeJSONResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	verifyEmailHandler := kithttp.NewServer(
+		MakeEndpointVerifyEmail(s),
+		decodeVerifyEmailRequest,
+		encodePreferencesResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	getInboxHandler := kithttp.NewServer(
+		MakeEndpointGetInbox(s),
+		decodeGetInboxRequest,
+		encodeGetInboxResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	markReadHandler := kithttp.NewServer(
+		MakeEndpointMarkRead(s),
+		decodeMarkReadRequest,
+		kithttp.EncodeJSONResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	markAllReadHandler := kithttp.NewServer(
+		MakeEndpointMarkAllRead(s),
+		kithttp.NopRequestDecoder,
+		kithttp.EncodeJSONResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	unreadCountHandler := kithttp.NewServer(
+		MakeEndpointUnreadCount(s),
+		kithttp.NopRequestDecoder,
+		kithttp.EncodeJSONResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	streamUnreadCountHandler := kithttp.NewServer(
+		MakeEndpointStreamUnreadCount(s),
+		kithttp.NopRequestDecoder,
+		encodeStreamUnreadCountResponse,
+		kithttp.ServerErrorEncoder(errorEncoder),
+	)
+
+	// support staff impersonating a user may look around but not redirect where the user's notifications go
+	notImpersonating := http2.DenyImpersonation()
+
+	router.Handler(http.MethodGet, "/notifications", getInboxHandler)
+	router.Handler(http.MethodGet, "/notifications/preferences", getPreferencesHandler)
+	router.Handler(http.MethodPut, "/notifications/preferences", notImpersonating(updatePreferencesHandler))
+	// PUT for the same reason as /notifications/read, the email is set once the token mailed to it comes back
+	router.Handler(http.MethodPut, "/notifications/preferences/email", notImpersonating(requestEmailHandler))
+	router.Handler(http.MethodPut, "/notifications/preferences/email/verification", notImpersonating(verifyEmailHandler))
+	router.Handler(http.MethodGet, "/notifications/unread-count", unreadCountHandler)
+	router.Handler(http.MethodGet, "/notifications/stream", streamUnreadCountHandler)
+	router.Handler(http.MethodPost, "/notifications/:id/read", markReadHandler)
+	// PUT as httprouter does not let a static segment sit next to :id, marking everything read is idempotent anyway
+	router.Handler(http.MethodPut, "/notifications/read", markAllReadHandler)
+}
+
+func decodeGetPreferencesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	return GetPreferencesRequestModel{}, nil
+}
+
+func decodeUpdatePreferencesRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req UpdatePreferencesRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req.Preferences); err != nil {
+		return nil, fmt.Errorf("decodeUpdatePreferencesRequest %v %w", err, notification.ErrBadRequest)
+	}
+
+	return req, nil
+}
+
+func decodeRequestEmailRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req RequestEmailRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeRequestEmailRequest %v %w", err, notification.ErrBadRequest)
+	}
+
+	return req, nil
+}
+
+func decodeVerifyEmailRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var req VerifyEmailRequestModel
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("decodeVerifyEmailRequest %v %w", err, notification.ErrBadRequest)
+	}
+
+	return req, nil
+}
+
+func encodePreferencesResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(PreferencesResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodePreferencesResponse failed parsing reponse")
+	}
+
+	formatted := formatPreferences(res.preferences)
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+// decodeGetInboxRequest reads unread=true to list the unread notifications only, before, the next cursor
+// of the previous page, and the page size
+func decodeGetInboxRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	var filter notification.InboxFilter
+
+	if value := query.Get("unread"); value != "" {
+		unread, err := strconv.ParseBool(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("decodeGetInboxRequest invalid unread %w", notification.ErrBadRequest)
+		}
+
+		filter.Unread = unread
+	}
+
+	if value := query.Get("before"); value != "" {
+		before, err := strconv.ParseInt(value, 10, 64)
+
+		if err != nil || before <= 0 {
+			return nil, fmt.Errorf("decodeGetInboxRequest invalid before %w", notification.ErrBadRequest)
+		}
+
+		filter.Before = before
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("decodeGetInboxRequest invalid limit %w", notification.ErrBadRequest)
+		}
+
+		filter.Limit = limit
+	}
+
+	return GetInboxRequestModel{filter: filter}, nil
+}
+
+func encodeGetInboxResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(GetInboxResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeGetInboxResponse failed casting response")
+	}
+
+	notifications := make([]map[string]interface{}, 0, len(res.notifications))
+	for _, n := range res.notifications {
+		notifications = append(notifications, formatNotification(n))
+	}
+
+	formatted := map[string]interface{}{
+		"notifications": notifications,
+	}
+
+	if res.Next > 0 {
+		formatted["next"] = res.Next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	return json.NewEncoder(w).Encode(&formatted)
+}
+
+func decodeMarkReadRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+
+	return MarkReadRequestModel{
+		id: httprouter.ParamsFromContext(ctx).ByName("id"),
+	}, nil
+}
+
+// encodeStreamUnreadCountResponse sends the unread counts as Server-Sent Events. The headers are written with
+// the first count, an error before it is still answered with its status
+func encodeStreamUnreadCountResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	res, ok := response.(StreamUnreadCountResponseModel)
+
+	if !ok {
+		return fmt.Errorf("encodeStreamUnreadCountResponse failed casting response")
+	}
+
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		return fmt.Errorf("encodeStreamUnreadCountResponse streaming unsupported")
+	}
+
+	started := false
+
+	err := res.Stream(ctx, func(count int) error {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("Connection", "keep-alive")
+			// proxies would hold the events back otherwise
+			w.Header().Set("X-Accel-Buffering", "no")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+
+		if _, err := fmt.Fprintf(w, "event: unread\ndata: {\"unread\":%d}\n\n", count); err != nil {
+			return err
+		}
+		flusher.Flush()
+
+		return nil
+	})
+
+	if err != nil && started {
+		// the status is sent already, the client sees the stream end and reconnects
+		return nil
+	}
+
+	return err
+}
+
+func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case errors.Is(err, notification.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, notification.ErrUnAuthorized):
+		w.WriteHeader(http.StatusUnauthorized)
+	case errors.Is(err, notification.ErrBadRequest):
+		w.WriteHeader(http.StatusBadRequest)
+	case errors.Is(err, notification.ErrTooManyRequests):
+		w.WriteHeader(http.StatusTooManyRequests)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": err.Error(),
+	})
+}
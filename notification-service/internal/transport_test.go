@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/ireuven89/auctions/notification-service/notification"
+	http2 "github.com/ireuven89/auctions/shared/http"
+	"github.com/julienschmidt/httprouter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func asUser(req *http.Request, userID string) *http.Request {
+
+	return req.WithContext(http2.ContextWithClaims(req.Context(), jwt.MapClaims{"sub": userID}))
+}
+
+func TestInboxTransport(t *testing.T) {
+	repo := newFakeRepo()
+	now := time.Date(2026, 3, 2, 10, 20, 0, 0, time.UTC)
+	s := newTestService(t, repo, now)
+	r := httprouter.New()
+	NewTransport(r, s)
+
+	for i, subject := range []string{"first", "second"} {
+		_, err := repo.Store(context.Background(), notification.Notification{
+			ID: subject, UserID: "alice", Kind: notification.KindWon, EventID: subject, Subject: subject, InApp: true, CreatedAt: now.Add(time.Duration(i) * time.Minute),
+		}, nil)
+		require.NoError(t, err)
+	}
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, asUser(httptest.NewRequest(http.MethodGet, "/notifications?unread=true&limit=1", nil), "alice"))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	var page map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	notifications := page["notifications"].([]interface{})
+	require.Len(t, notifications, 1)
+	assert.Equal(t, "second", notifications[0].(map[string]interface{})["subject"])
+	assert.Equal(t, false, notifications[0].(map[string]interface{})["read"])
+	assert.Equal(t, 2.0, page["next"])
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, asUser(httptest.NewRequest(http.MethodGet, "/notifications?limit=many", nil), "alice"))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, asUser(httptest.NewRequest(http.MethodPost, "/notifications/first/read", nil), "alice"))
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, asUser(httptest.NewRequest(http.MethodPost, "/notifications/first/read", nil), "bob"))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, asUser(httptest.NewRequest(http.MethodGet, "/notifications/unread-count", nil), "alice"))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"unread":1}`, resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, asUser(httptest.NewRequest(http.MethodPut, "/notifications/read", nil), "alice"))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.JSONEq(t, `{"marked":1}`, resp.Body.String())
+}
+
+func TestStreamUnreadCountTransport(t *testing.T) {
+	repo := newFakeRepo()
+	s := newTestService(t, repo, time.Now())
+	r := httprouter.New()
+	NewTransport(r, s)
+
+	// the stream of a client gone after the first count
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, asUser(httptest.NewRequest(http.MethodGet, "/notifications/stream", nil).WithContext(ctx), "alice"))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "text/event-stream", resp.Header().Get("Content-Type"))
+	assert.Equal(t, "event: unread\ndata: {\"unread\":0}\n\n", resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/notifications/stream", nil))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+}
+
+func TestUpdatePreferencesTransport_Impersonating(t *testing.T) {
+	s := newTestService(t, newFakeRepo(), time.Now())
+	r := httprouter.New()
+	NewTransport(r, s)
+	body := `{"email":"mallory@example.com","webhookUrl":"https://example.com/hook"}`
+
+	req := httptest.NewRequest(http.MethodPut, "/notifications/preferences", strings.NewReader(body))
+	req = req.WithContext(http2.ContextWithClaims(req.Context(), jwt.MapClaims{"sub": "alice", http2.ActorClaim: map[string]interface{}{"sub": "admin"}}))
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+
+	// reading them is fine
+	req = httptest.NewRequest(http.MethodGet, "/notifications/preferences", nil)
+	req = req.WithContext(http2.ContextWithClaims(req.Context(), jwt.MapClaims{"sub": "alice", http2.ActorClaim: map[string]interface{}{"sub": "admin"}}))
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
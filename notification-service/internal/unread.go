@@ -0,0 +1,53 @@
+package internal
+
+import "sync"
+
+// UnreadHub wakes up the unread count streams open on this instance when the inbox of their user changes.
+// Changes made on other instances are only seen when the streams poll
+type UnreadHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan struct{}]struct{}
+}
+
+func NewUnreadHub() *UnreadHub {
+
+	return &UnreadHub{subscribers: make(map[string]map[chan struct{}]struct{})}
+}
+
+// Subscribe returns a channel signalled when the inbox of the user changes and the func to unsubscribe with.
+// Changes arriving while the subscriber is busy are coalesced into one signal
+func (h *UnreadHub) Subscribe(userID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	h.mu.Lock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan struct{}]struct{})
+	}
+	h.subscribers[userID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		delete(h.subscribers[userID], ch)
+		if len(h.subscribers[userID]) == 0 {
+			delete(h.subscribers, userID)
+		}
+	}
+}
+
+// Changed signals the subscribers of every user, it never blocks
+func (h *UnreadHub) Changed(userIDs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, userID := range userIDs {
+		for ch := range h.subscribers[userID] {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package notification
+
+import (
+	"errors"
+	"time"
+)
+
+// Kind is what a notification tells the user
+type Kind string
+
+const (
+	KindOutbid     Kind = "outbid"
+	KindWon        Kind = "won"
+	KindEndingSoon Kind = "ending_soon"
+	KindCancelled  Kind = "cancelled"
+	KindPaymentDue Kind = "payment_due"
+	// KindBidReceived and KindAuctionClosed tell sellers about their own auctions
+	KindBidReceived   Kind = "bid_received"
+	KindAuctionClosed Kind = "auction_closed"
+	// kindDigest is the template several held notifications are sent together with
+	kindDigest Kind = "digest"
+)
+
+// PaymentDueAfter is how long the winner of an auction has to pay once it closed
+const PaymentDueAfter = 72 * time.Hour
+
+// Kinds are the kinds of notification users are sent
+var Kinds = []Kind{KindOutbid, KindWon, KindEndingSoon, KindCancelled, KindPaymentDue, KindBidReceived, KindAuctionClosed}
+
+// Channel is a way a notification reaches the user
+type Channel string
+
+const (
+	// ChannelInApp is the inbox of the user, it is written as the notification is raised
+	ChannelInApp   Channel = "in_app"
+	ChannelEmail   Channel = "email"
+	ChannelWebhook Channel = "webhook"
+)
+
+var Channels = []Channel{ChannelInApp, ChannelEmail, ChannelWebhook}
+
+// the status of a delivery
+const (
+	DeliveryPending = "pending"
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed"
+)
+
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrUnAuthorized    = errors.New("unauthorized")
+	ErrBadRequest      = errors.New("bad request")
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+// Data is what the templates of the notifications are rendered with, the fields a kind does not use are empty
+type Data struct {
+	AuctionID   string `json:"auctionId"`
+	Description string `json:"description,omitempty"`
+	// BidderID is who placed the bid a seller is told of, or who won their auction
+	BidderID string    `json:"bidderId,omitempty"`
+	Amount   float64   `json:"amount,omitempty"`
+	YourBid  float64   `json:"yourBid,omitempty"`
+	DueAt    time.Time `json:"dueAt,omitempty"`
+	EndsAt   time.Time `json:"endsAt,omitempty"`
+}
+
+// Notification is something that happened to the auctions or bids of a user, raised once per user, kind
+// and event
+type Notification struct {
+	ID        string
+	UserID    string
+	Kind      Kind
+	EventID   string
+	AuctionID string
+	Subject   string
+	Body      string
+	Data      Data
+	// InApp tells whether the notification is shown in the inbox of the user
+	InApp bool
+	// Seq orders the inbox, it is set once the notification is stored
+	Seq int64
+	// ReadAt is when the user read the notification in the inbox, nil while it is unread
+	ReadAt    *time.Time
+	CreatedAt time.Time
+}
+
+const (
+	DefaultInboxLimit = 20
+	MaxInboxLimit     = 100
+)
+
+// InboxFilter pages through the inbox of a user, newest first
+type InboxFilter struct {
+	UserID string
+	Unread bool
+	// Before is the seq the page starts after, zero for the first page
+	Before int64
+	Limit  int
+}
+
+// WithLimit applies the default and the maximum page size
+func (f InboxFilter) WithLimit() InboxFilter {
+	if f.Limit <= 0 {
+		f.Limit = DefaultInboxLimit
+	}
+
+	if f.Limit > MaxInboxLimit {
+		f.Limit = MaxInboxLimit
+	}
+
+	return f
+}
+
+// Delivery is a notification to send on a channel other than the inbox
+type Delivery struct {
+	ID             string
+	NotificationID string
+	UserID         string
+	Channel        Channel
+	// Digest deliveries are held back, by the digest interval or quiet hours, and sent together
+	Digest       bool
+	DeliverAfter time.Time
+	Status       string
+	Attempts     int
+}
+
+// Pending is a delivery due, the notification it sends and where to
+type Pending struct {
+	Delivery
+	Notification Notification
+	// Address is the email address or webhook URL of the user for the channel, empty when there is none
+	Address string
+}
+
+// Auction is what notification-service knows of an auction from its events
+type Auction struct {
+	ID            string
+	SellerID      string
+	Description   string
+	LeaderID      string
+	LeadingAmount float64
+}
@@ -0,0 +1,205 @@
+package notification
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"time"
+)
+
+// DefaultDigestMinutes is how often digested notifications are sent unless the user chose otherwise
+const DefaultDigestMinutes = 60
+
+// Preferences are how a user wants to be notified
+type Preferences struct {
+	UserID string `json:"-"`
+	// Email and WebhookURL are where the email and webhook notifications go, a channel without one is skipped.
+	// Email is only set once the user verified it, it is stored encrypted and not in the preferences document
+	Email      string `json:"-"`
+	WebhookURL string `json:"webhookUrl"`
+	// Channels are the channels each kind is sent on, a kind missing is not sent at all
+	Channels map[Kind][]Channel `json:"channels"`
+	// Digest are the noisy kinds held and sent together every DigestMinutes rather than one by one
+	Digest        []Kind      `json:"digest"`
+	DigestMinutes int         `json:"digestMinutes"`
+	QuietHours    *QuietHours `json:"quietHours,omitempty"`
+}
+
+// QuietHours hold back the email and webhook notifications from Start to End, "15:04" in TimeZone, they
+// are sent as a digest once the quiet hours are over. The inbox is not held back
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	TimeZone string `json:"timeZone"`
+}
+
+// DefaultPreferences notify users of every kind in the inbox and by email, outbids and bids received are digested
+func DefaultPreferences(userID string) Preferences {
+	channels := make(map[Kind][]Channel, len(Kinds))
+
+	for _, kind := range Kinds {
+		channels[kind] = []Channel{ChannelInApp, ChannelEmail}
+	}
+
+	return Preferences{
+		UserID:        userID,
+		Channels:      channels,
+		Digest:        []Kind{KindOutbid, KindBidReceived},
+		DigestMinutes: DefaultDigestMinutes,
+	}
+}
+
+// ChannelsFor returns the channels the kind is sent on
+func (p Preferences) ChannelsFor(kind Kind) []Channel {
+
+	return p.Channels[kind]
+}
+
+// Address returns where notifications are sent on the channel, empty when the user has not told
+func (p Preferences) Address(channel Channel) string {
+	switch channel {
+	case ChannelEmail:
+		return p.Email
+	case ChannelWebhook:
+		return p.WebhookURL
+	default:
+		return ""
+	}
+}
+
+func (p Preferences) digested(kind Kind) bool {
+	for _, k := range p.Digest {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DeliverAfter returns when a notification of the kind raised at now is sent by email or webhook, and
+// whether it is held back to be sent as part of a digest
+func (p Preferences) DeliverAfter(kind Kind, now time.Time) (time.Time, bool) {
+	at, digest := now, false
+
+	if p.digested(kind) {
+		interval := time.Duration(p.DigestMinutes) * time.Minute
+
+		if interval <= 0 {
+			interval = DefaultDigestMinutes * time.Minute
+		}
+
+		at, digest = now.Truncate(interval).Add(interval), true
+	}
+
+	if p.QuietHours != nil {
+		if end, quiet := p.QuietHours.endOf(at); quiet {
+			at, digest = end, true
+		}
+	}
+
+	return at, digest
+}
+
+// endOf tells whether t is within the quiet hours and when they end
+func (q QuietHours) endOf(t time.Time) (time.Time, bool) {
+	loc, start, end, err := q.parse()
+
+	if err != nil || start == end {
+		return time.Time{}, false
+	}
+
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	endToday := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, loc)
+
+	switch {
+	case start < end && minute >= start && minute < end:
+		return endToday.In(t.Location()), true
+	case start > end && minute >= start:
+		// the quiet hours go past midnight
+		return endToday.AddDate(0, 0, 1).In(t.Location()), true
+	case start > end && minute < end:
+		return endToday.In(t.Location()), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// parse returns the location and the minutes of the day the quiet hours start and end at
+func (q QuietHours) parse() (*time.Location, int, int, error) {
+	loc, err := time.LoadLocation(q.TimeZone)
+
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("unknown time zone %s", q.TimeZone)
+	}
+
+	start, err := time.Parse("15:04", q.Start)
+
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("start must be HH:MM")
+	}
+
+	end, err := time.Parse("15:04", q.End)
+
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("end must be HH:MM")
+	}
+
+	return loc, start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+// Validate checks the preferences only name known kinds and channels and the addresses are usable
+func (p Preferences) Validate() error {
+	for kind, channels := range p.Channels {
+		if !known(Kinds, kind) {
+			return fmt.Errorf("unknown kind %s %w", kind, ErrBadRequest)
+		}
+
+		for _, channel := range channels {
+			if !known(Channels, channel) {
+				return fmt.Errorf("unknown channel %s %w", channel, ErrBadRequest)
+			}
+		}
+	}
+
+	for _, kind := range p.Digest {
+		if !known(Kinds, kind) {
+			return fmt.Errorf("unknown digest kind %s %w", kind, ErrBadRequest)
+		}
+	}
+
+	if p.DigestMinutes < 0 || p.DigestMinutes > 24*60 {
+		return fmt.Errorf("digestMinutes must be between 0 and a day %w", ErrBadRequest)
+	}
+
+	if p.Email != "" {
+		if _, err := mail.ParseAddress(p.Email); err != nil {
+			return fmt.Errorf("invalid email %w", ErrBadRequest)
+		}
+	}
+
+	if p.WebhookURL != "" {
+		if u, err := url.Parse(p.WebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("webhookUrl must be an https URL %w", ErrBadRequest)
+		}
+	}
+
+	if p.QuietHours != nil {
+		if _, _, _, err := p.QuietHours.parse(); err != nil {
+			return fmt.Errorf("quietHours %v %w", err, ErrBadRequest)
+		}
+	}
+
+	return nil
+}
+
+func known[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
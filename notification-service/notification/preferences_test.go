@@ -0,0 +1,70 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliverAfter(t *testing.T) {
+	preferences := DefaultPreferences("user")
+	now := time.Date(2026, 3, 2, 10, 20, 0, 0, time.UTC)
+
+	at, digest := preferences.DeliverAfter(KindWon, now)
+	assert.Equal(t, now, at)
+	assert.False(t, digest)
+
+	// outbids wait for the next digest
+	at, digest = preferences.DeliverAfter(KindOutbid, now)
+	assert.Equal(t, time.Date(2026, 3, 2, 11, 0, 0, 0, time.UTC), at)
+	assert.True(t, digest)
+
+	// quiet hours past midnight hold back whatever is raised in them until they end
+	preferences.QuietHours = &QuietHours{Start: "22:00", End: "07:30", TimeZone: "Europe/Paris"}
+	late := time.Date(2026, 3, 2, 22, 15, 0, 0, time.UTC)
+
+	at, digest = preferences.DeliverAfter(KindWon, late)
+	assert.Equal(t, time.Date(2026, 3, 3, 6, 30, 0, 0, time.UTC), at)
+	assert.True(t, digest)
+
+	early := time.Date(2026, 3, 3, 5, 0, 0, 0, time.UTC)
+	at, _ = preferences.DeliverAfter(KindWon, early)
+	assert.Equal(t, time.Date(2026, 3, 3, 6, 30, 0, 0, time.UTC), at)
+
+	// a digest due in the quiet hours waits for them to end too
+	at, _ = preferences.DeliverAfter(KindOutbid, time.Date(2026, 3, 2, 20, 40, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2026, 3, 3, 6, 30, 0, 0, time.UTC), at)
+
+	at, digest = preferences.DeliverAfter(KindWon, now)
+	assert.Equal(t, now, at)
+	assert.False(t, digest)
+}
+
+func TestPreferencesValidate(t *testing.T) {
+	assert.NoError(t, DefaultPreferences("user").Validate())
+
+	tests := []struct {
+		name   string
+		modify func(p *Preferences)
+	}{
+		{"unknown kind", func(p *Preferences) { p.Channels["listing"] = []Channel{ChannelEmail} }},
+		{"unknown channel", func(p *Preferences) { p.Channels[KindWon] = []Channel{"sms"} }},
+		{"unknown digest kind", func(p *Preferences) { p.Digest = []Kind{"listing"} }},
+		{"invalid email", func(p *Preferences) { p.Email = "not an email" }},
+		{"plain http webhook", func(p *Preferences) { p.WebhookURL = "http://example.com/hook" }},
+		{"invalid quiet hours", func(p *Preferences) { p.QuietHours = &QuietHours{Start: "25:00", End: "07:00"} }},
+		{"unknown time zone", func(p *Preferences) {
+			p.QuietHours = &QuietHours{Start: "22:00", End: "07:00", TimeZone: "Mars/Olympus"}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preferences := DefaultPreferences("user")
+			tt.modify(&preferences)
+
+			assert.ErrorIs(t, preferences.Validate(), ErrBadRequest)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package notification
+
+import (
+	"embed"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+var templateFuncs = template.FuncMap{
+	"money": func(amount float64) string {
+		return fmt.Sprintf("%.2f", amount)
+	},
+	"date": func(t time.Time) string {
+		return t.UTC().Format("Mon, 02 Jan 2006 15:04 MST")
+	},
+}
+
+// Templates render the subject and body of the notifications, templates/<kind>.tmpl defines both
+type Templates struct {
+	byKind map[Kind]*template.Template
+}
+
+// NewTemplates parses the template of every kind and of the digest, it fails when one is missing
+func NewTemplates() (*Templates, error) {
+	templates := &Templates{byKind: make(map[Kind]*template.Template)}
+
+	for _, kind := range append([]Kind{kindDigest}, Kinds...) {
+		t, err := template.New(string(kind)).Funcs(templateFuncs).ParseFS(templateFiles, "templates/"+string(kind)+".tmpl")
+
+		if err != nil {
+			return nil, fmt.Errorf("NewTemplates failed parsing %s %w", kind, err)
+		}
+
+		templates.byKind[kind] = t
+	}
+
+	return templates, nil
+}
+
+// Render returns the subject and body of a notification of the kind
+func (t *Templates) Render(kind Kind, data Data) (string, string, error) {
+
+	return t.render(kind, data)
+}
+
+// RenderDigest returns the subject and body of one message telling the notifications
+func (t *Templates) RenderDigest(notifications []Notification) (string, string, error) {
+
+	return t.render(kindDigest, notifications)
+}
+
+func (t *Templates) render(kind Kind, data interface{}) (string, string, error) {
+	tmpl, ok := t.byKind[kind]
+
+	if !ok {
+		return "", "", fmt.Errorf("Templates.Render no template for %s", kind)
+	}
+
+	var subject, body strings.Builder
+
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", fmt.Errorf("Templates.Render %s subject %w", kind, err)
+	}
+
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return "", "", fmt.Errorf("Templates.Render %s body %w", kind, err)
+	}
+
+	return strings.TrimSpace(subject.String()), strings.TrimSpace(body.String()) + "\n", nil
+}
@@ -0,0 +1,46 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplatesRender(t *testing.T) {
+	templates, err := NewTemplates()
+	require.NoError(t, err)
+
+	data := Data{
+		AuctionID:   "auction",
+		Description: "a bike",
+		Amount:      120,
+		YourBid:     100,
+		DueAt:       time.Date(2026, 3, 5, 10, 0, 0, 0, time.UTC),
+		EndsAt:      time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC),
+	}
+
+	for _, kind := range Kinds {
+		subject, body, err := templates.Render(kind, data)
+
+		assert.NoError(t, err, kind)
+		assert.Contains(t, subject, "a bike", kind)
+		assert.Contains(t, body, "auction", kind)
+	}
+
+	subject, body, err := templates.Render(KindOutbid, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "You have been outbid on a bike", subject)
+	assert.Contains(t, body, "Your bid of 100.00")
+	assert.Contains(t, body, "now 120.00")
+
+	_, body, err = templates.Render(KindPaymentDue, data)
+	assert.NoError(t, err)
+	assert.Contains(t, body, "Thu, 05 Mar 2026 10:00 UTC")
+
+	subject, body, err = templates.RenderDigest([]Notification{{Subject: "You have been outbid on a bike"}, {Subject: "You have been outbid on a lamp"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "2 updates on your auctions", subject)
+	assert.Contains(t, body, "- You have been outbid on a bike\n- You have been outbid on a lamp")
+}
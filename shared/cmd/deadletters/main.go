@@ -0,0 +1,156 @@
+// deadletters manages the dead letter queues of the consumers.
+//
+//	deadletters inspect -queue bidder-service.users [-limit 20]   print the dead letters as JSON lines
+//	deadletters replay -queue bidder-service.users [-id <id>,...]  send dead letters back to the queue
+//	deadletters purge -queue bidder-service.users                  drop the dead letters
+//
+// The broker is the one of the service config, read from APP_ENV and CONFIG_DIR, unless -url is given
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ireuven89/auctions/shared/config"
+	"github.com/ireuven89/auctions/shared/messaging"
+)
+
+type deadLetter struct {
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	Version  int             `json:"version"`
+	Topic    string          `json:"topic"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failedAt"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "inspect", "replay", "purge":
+	default:
+		usage()
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	queue := flags.String("queue", "", "the queue the messages failed on")
+	url := flags.String("url", "", "broker URL, defaults to the one of the service config")
+	limit := flags.Int("limit", 20, "inspect: how many dead letters to print, 0 for all")
+	ids := flags.String("id", "", "replay: comma separated message IDs, all dead letters when empty")
+	flags.Parse(os.Args[2:])
+
+	if *queue == "" {
+		usage()
+	}
+
+	broker, err := dial(*url)
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer broker.Close()
+
+	ctx := context.Background()
+
+	switch os.Args[1] {
+	case "inspect":
+		err = inspect(ctx, broker, *queue, *limit)
+	case "replay":
+		err = replay(ctx, broker, *queue, *ids)
+	case "purge":
+		err = purge(ctx, broker, *queue)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: deadletters inspect|replay|purge -queue <queue> [-url <url>] [-limit <n>] [-id <id>,...]")
+	os.Exit(2)
+}
+
+func dial(url string) (*messaging.AMQPBroker, error) {
+	if url != "" {
+		return messaging.DialAMQP(config.RabbitConfig{URL: url})
+	}
+
+	cfg, err := config.LoadConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return messaging.DialAMQP(cfg.Rabbit)
+}
+
+func inspect(ctx context.Context, letters messaging.DeadLetters, queue string, limit int) error {
+	found, err := letters.Inspect(ctx, queue, limit)
+
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+
+	for _, letter := range found {
+		err = encoder.Encode(deadLetter{
+			ID:       letter.ID,
+			Type:     letter.Type,
+			Version:  letter.Version,
+			Topic:    letter.Topic,
+			Attempts: letter.Attempts,
+			Error:    letter.Error,
+			FailedAt: letter.FailedAt,
+			Payload:  letter.Payload,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func replay(ctx context.Context, letters messaging.DeadLetters, queue, ids string) error {
+	var selected []string
+	if ids != "" {
+		selected = strings.Split(ids, ",")
+	}
+
+	count, err := letters.Replay(ctx, queue, selected)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("replayed %d dead letters to %s\n", count, queue)
+
+	return nil
+}
+
+func purge(ctx context.Context, letters messaging.DeadLetters, queue string) error {
+	count, err := letters.Purge(ctx, queue)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("purged %d dead letters of %s\n", count, queue)
+
+	return nil
+}
@@ -6,19 +6,44 @@ import (
 	"encoding/pem"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Sql    DBConfig     `mapstructure:"database"`
-	Redis  DBConfig     `mapstructure:"redis"`
-	Server ServerConfig `mapstructure:"server"`
-	AWS    AWSConfig    `mapstructure:"aws"`
+	Sql        DBConfig         `mapstructure:"database"`
+	Redis      DBConfig         `mapstructure:"redis"`
+	Server     ServerConfig     `mapstructure:"server"`
+	AWS        AWSConfig        `mapstructure:"aws"`
+	Mail       MailConfig       `mapstructure:"mail"`
+	Password   PasswordConfig   `mapstructure:"password"`
+	Auth       AuthConfig       `mapstructure:"auth"`
+	MagicLink  MagicLinkConfig  `mapstructure:"magic_link"`
+	OIDC       OIDCConfig       `mapstructure:"oidc"`
+	Encryption EncryptionConfig `mapstructure:"encryption"`
+	Rabbit     RabbitConfig     `mapstructure:"rabbit"`
+}
+
+// AuthConfig points the other services at auth-service
+type AuthConfig struct {
+	URL string `mapstructure:"url"`
+	// APIKeyCacheTTL is how long a resolved API key is trusted, and so how long a revoked key may still work
+	APIKeyCacheTTL time.Duration `mapstructure:"api_key_cache_ttl"`
+	// ClientID and ClientSecret are the credentials of the registered client the service resolves API keys as
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	// Issuer is the URL auth-service signs its tokens and publishes its discovery document with
+	Issuer string `mapstructure:"issuer"`
+	// InvitationURL is the page organization invitations link to, the token is added as a query parameter
+	InvitationURL string `mapstructure:"invitation_url"`
 }
 
 type ServerConfig struct {
 	Port string `mapstructure:"port"`
+	// TrustedProxies are the addresses or CIDR ranges of the proxies in front of the service, X-Forwarded-For
+	// is only read from them
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
 }
 
 type DBConfig struct {
@@ -33,9 +58,89 @@ type AWSConfig struct {
 		Primary string `mapstructure:"primary"`
 	} `mapstructure:"s3_buckets"`
 	S3Region string
+	Region   string `mapstructure:"region"`
+	// KMSKeyID is the KMS key the personal data keys are wrapped with, it takes precedence over a master key file
 	KMSKeyID string `mapstructure:"kms_key_id"`
 }
 
+// EncryptionConfig configures the encryption of personal data at rest
+type EncryptionConfig struct {
+	// MasterKeyFile is a local keyring of master keys for development, see auth-service/cmd/piikeys
+	MasterKeyFile string `mapstructure:"master_key_file"`
+}
+
+// RabbitConfig configures the broker the services exchange events through, an empty URL keeps them in memory
+type RabbitConfig struct {
+	URL string `mapstructure:"url"`
+	// Exchange is the durable topic exchange every event is published to
+	Exchange string `mapstructure:"exchange"`
+	// Prefetch caps the unacknowledged messages a consumer holds
+	Prefetch int `mapstructure:"prefetch"`
+	// ConfirmTimeout bounds the wait for the broker to confirm a publish
+	ConfirmTimeout time.Duration `mapstructure:"confirm_timeout"`
+}
+
+type MailConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+	From     string `mapstructure:"from"`
+	// OutboxDir is the directory emails are written to as files when no Host is configured
+	OutboxDir string `mapstructure:"outbox_dir"`
+}
+
+type PasswordConfig struct {
+	Hash   PasswordHashConfig   `mapstructure:"hash"`
+	Policy PasswordPolicyConfig `mapstructure:"policy"`
+	// BreachIndex is the path of an index built by auth-service/cmd/breachindex, empty disables the check
+	BreachIndex string `mapstructure:"breach_index"`
+}
+
+type PasswordHashConfig struct {
+	Algorithm   string `mapstructure:"algorithm"`
+	Memory      uint32 `mapstructure:"memory"`
+	Iterations  uint32 `mapstructure:"iterations"`
+	Parallelism uint8  `mapstructure:"parallelism"`
+	BcryptCost  int    `mapstructure:"bcrypt_cost"`
+}
+
+type PasswordPolicyConfig struct {
+	MinLength          int  `mapstructure:"min_length"`
+	MaxLength          int  `mapstructure:"max_length"`
+	RequireUpper       bool `mapstructure:"require_upper"`
+	RequireLower       bool `mapstructure:"require_lower"`
+	RequireDigit       bool `mapstructure:"require_digit"`
+	RequireSymbol      bool `mapstructure:"require_symbol"`
+	RejectPersonalInfo bool `mapstructure:"reject_personal_info"`
+}
+
+// MagicLinkConfig configures passwordless login links
+type MagicLinkConfig struct {
+	// URL is the page the emailed link opens, the token is passed in its token query parameter
+	URL string        `mapstructure:"url"`
+	TTL time.Duration `mapstructure:"ttl"`
+	// MaxRequests is how many links may be requested for one identifier within Window
+	MaxRequests int           `mapstructure:"max_requests"`
+	Window      time.Duration `mapstructure:"window"`
+	// BindDevice rejects links opened on another device than the one that requested them
+	BindDevice bool `mapstructure:"bind_device"`
+}
+
+// OIDCConfig lists the external identity providers users may sign in with, by name
+type OIDCConfig struct {
+	Providers map[string]OIDCProviderConfig `mapstructure:"providers"`
+}
+
+type OIDCProviderConfig struct {
+	Issuer       string `mapstructure:"issuer"`
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	// RedirectURL is the callback registered with the provider, /auth/oidc/<name>/callback on this service
+	RedirectURL string   `mapstructure:"redirect_url"`
+	Scopes      []string `mapstructure:"scopes"`
+}
+
 const defaultConfigDir = "/config"
 const defaultPublicKeyPath = "/config/public.key"
 
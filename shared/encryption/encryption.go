@@ -0,0 +1,83 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
+type Encrypter interface {
+	Encrypt(plaintext []byte) (string, error)
+	Decrypt(ciphertext string) ([]byte, error)
+}
+
+// AESEncrypter seals values with AES-256-GCM, the nonce is prepended to the ciphertext
+type AESEncrypter struct {
+	aead cipher.AEAD
+}
+
+func NewAESEncrypter(key []byte) (Encrypter, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("NewAESEncrypter key must be 32 bytes, got %d", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("NewAESEncrypter %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, fmt.Errorf("NewAESEncrypter %w", err)
+	}
+
+	return &AESEncrypter{aead: aead}, nil
+}
+
+// NewAESEncrypterFromBase64 - the key is usually passed through the environment as base64
+func NewAESEncrypterFromBase64(encodedKey string) (Encrypter, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("NewAESEncrypterFromBase64 %w", err)
+	}
+
+	return NewAESEncrypter(key)
+}
+
+func (e *AESEncrypter) Encrypt(plaintext []byte) (string, error) {
+	nonce := make([]byte, e.aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("AESEncrypter.Encrypt %w", err)
+	}
+
+	sealed := e.aead.Seal(nonce, nonce, plaintext, nil)
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (e *AESEncrypter) Decrypt(ciphertext string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+
+	if err != nil || len(sealed) < e.aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	nonce, sealed := sealed[:e.aead.NonceSize()], sealed[e.aead.NonceSize():]
+
+	plaintext, err := e.aead.Open(nil, nonce, sealed, nil)
+
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,37 @@
+package encryption
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAESEncrypter_RoundTrip(t *testing.T) {
+	enc, err := NewAESEncrypter(bytes.Repeat([]byte{1}, 32))
+	assert.NoError(t, err)
+
+	ciphertext, err := enc.Encrypt([]byte("secret"))
+	assert.NoError(t, err)
+	assert.NotContains(t, ciphertext, "secret")
+
+	plaintext, err := enc.Decrypt(ciphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(plaintext))
+}
+
+func TestAESEncrypter_WrongKey(t *testing.T) {
+	enc, _ := NewAESEncrypter(bytes.Repeat([]byte{1}, 32))
+	other, _ := NewAESEncrypter(bytes.Repeat([]byte{2}, 32))
+
+	ciphertext, err := enc.Encrypt([]byte("secret"))
+	assert.NoError(t, err)
+
+	_, err = other.Decrypt(ciphertext)
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+}
+
+func TestNewAESEncrypter_InvalidKey(t *testing.T) {
+	_, err := NewAESEncrypter([]byte("short"))
+	assert.Error(t, err)
+}
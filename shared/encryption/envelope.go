@@ -0,0 +1,315 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ireuven89/auctions/shared/config"
+)
+
+var (
+	ErrKeyExists        = errors.New("data key already exists")
+	ErrUnknownDataKey   = errors.New("unknown data key")
+	ErrUnknownMasterKey = errors.New("unknown master key")
+)
+
+// KeyProvider holds the master key and only wraps and unwraps data keys with it, the master key itself never leaves it
+type KeyProvider interface {
+	// KeyID is the master key new data keys are wrapped with
+	KeyID() string
+	// WrapKey encrypts a data key with the current master key and returns the id of the master key used
+	WrapKey(ctx context.Context, dataKey []byte) ([]byte, string, error)
+	UnwrapKey(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error)
+}
+
+// purposes of the data keys
+const (
+	PurposeData  = "data"
+	PurposeIndex = "index"
+)
+
+// the keys created on first start, instances starting together agree on them because the ids are fixed
+const (
+	initialDataKeyID = "data-1"
+	indexKeyID       = "index"
+)
+
+// DataKey encrypts the fields, it is only stored wrapped by the master key
+type DataKey struct {
+	ID          string
+	Purpose     string
+	Wrapped     []byte
+	MasterKeyID string
+	CreatedAt   time.Time
+}
+
+// KeyStore persists the wrapped data keys, CreateDataKey returns ErrKeyExists for a taken id
+type KeyStore interface {
+	ListDataKeys(ctx context.Context) ([]DataKey, error)
+	CreateDataKey(ctx context.Context, key DataKey) error
+	UpdateDataKey(ctx context.Context, id string, wrapped []byte, masterKeyID string) error
+}
+
+// fieldPrefix marks encrypted values, values without it were written before encryption was turned on
+const fieldPrefix = "enc:"
+
+// FieldCipher encrypts single column values with the data keys, and computes the blind indexes
+// equality lookups use instead of the encrypted columns
+type FieldCipher struct {
+	provider KeyProvider
+	store    KeyStore
+
+	mu       sync.RWMutex
+	keys     map[string]cipher.AEAD
+	current  string
+	indexKey []byte
+}
+
+// LoadFieldCipher unwraps the stored data keys, creating them on the first start
+func LoadFieldCipher(ctx context.Context, provider KeyProvider, store KeyStore) (*FieldCipher, error) {
+	f := &FieldCipher{provider: provider, store: store}
+
+	for _, initial := range []DataKey{{ID: initialDataKeyID, Purpose: PurposeData}, {ID: indexKeyID, Purpose: PurposeIndex}} {
+		if err := f.createKey(ctx, initial); err != nil && !errors.Is(err, ErrKeyExists) {
+			return nil, fmt.Errorf("LoadFieldCipher %w", err)
+		}
+	}
+
+	if err := f.load(ctx); err != nil {
+		return nil, fmt.Errorf("LoadFieldCipher %w", err)
+	}
+
+	return f, nil
+}
+
+func (f *FieldCipher) createKey(ctx context.Context, key DataKey) error {
+	plain := make([]byte, 32)
+
+	if _, err := rand.Read(plain); err != nil {
+		return err
+	}
+
+	wrapped, masterKeyID, err := f.provider.WrapKey(ctx, plain)
+
+	if err != nil {
+		return fmt.Errorf("failed wrapping data key %w", err)
+	}
+
+	key.Wrapped, key.MasterKeyID, key.CreatedAt = wrapped, masterKeyID, time.Now().UTC()
+
+	return f.store.CreateDataKey(ctx, key)
+}
+
+// load unwraps every stored key, the newest data key encrypts from now on
+func (f *FieldCipher) load(ctx context.Context) error {
+	stored, err := f.store.ListDataKeys(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]cipher.AEAD, len(stored))
+	var current DataKey
+	var indexKey []byte
+
+	for _, key := range stored {
+		plain, err := f.provider.UnwrapKey(ctx, key.MasterKeyID, key.Wrapped)
+
+		if err != nil {
+			return fmt.Errorf("failed unwrapping data key %s %w", key.ID, err)
+		}
+
+		if key.Purpose == PurposeIndex {
+			indexKey = plain
+			continue
+		}
+
+		if keys[key.ID], err = newAEAD(plain); err != nil {
+			return err
+		}
+
+		if current.ID == "" || key.CreatedAt.After(current.CreatedAt) {
+			current = key
+		}
+	}
+
+	if current.ID == "" || indexKey == nil {
+		return fmt.Errorf("missing data keys %w", ErrUnknownDataKey)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys, f.current, f.indexKey = keys, current.ID, indexKey
+
+	return nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Encrypt seals the value with the current data key, empty values stay empty
+func (f *FieldCipher) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	f.mu.RLock()
+	id, aead := f.current, f.keys[f.current]
+	f.mu.RUnlock()
+
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("FieldCipher.Encrypt %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(id))
+
+	return fieldPrefix + id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value sealed by Encrypt, values written before encryption are returned as they are.
+// A key created by another instance after this one started is loaded on the way
+func (f *FieldCipher) Decrypt(ctx context.Context, value string) (string, error) {
+	if !Encrypted(value) {
+		return value, nil
+	}
+
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, fieldPrefix), ":")
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+
+	if !ok || err != nil {
+		return "", ErrInvalidCiphertext
+	}
+
+	aead, err := f.key(ctx, id)
+
+	if err != nil {
+		return "", fmt.Errorf("FieldCipher.Decrypt %w", err)
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrInvalidCiphertext
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+
+	return string(plaintext), nil
+}
+
+func (f *FieldCipher) key(ctx context.Context, id string) (cipher.AEAD, error) {
+	f.mu.RLock()
+	aead, ok := f.keys[id]
+	f.mu.RUnlock()
+
+	if ok {
+		return aead, nil
+	}
+
+	if err := f.load(ctx); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if aead, ok = f.keys[id]; !ok {
+		return nil, ErrUnknownDataKey
+	}
+
+	return aead, nil
+}
+
+// BlindIndex is the keyed hash equality lookups search for, case and surrounding spaces don't matter
+// like they didn't when the plaintext columns were compared by MySQL
+func (f *FieldCipher) BlindIndex(value string) string {
+	if value == "" {
+		return ""
+	}
+
+	f.mu.RLock()
+	mac := hmac.New(sha256.New, f.indexKey)
+	f.mu.RUnlock()
+
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(value))))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Encrypted tells if the value was sealed by a FieldCipher
+func Encrypted(value string) bool {
+
+	return strings.HasPrefix(value, fieldPrefix)
+}
+
+// Rewrap wraps the data keys with the current master key, the data they encrypt is left untouched.
+// Keys already wrapped with it are skipped unless all is set
+func Rewrap(ctx context.Context, provider KeyProvider, store KeyStore, all bool) (int, error) {
+	keys, err := store.ListDataKeys(ctx)
+
+	if err != nil {
+		return 0, fmt.Errorf("Rewrap %w", err)
+	}
+
+	rewrapped := 0
+	for _, key := range keys {
+		if key.MasterKeyID == provider.KeyID() && !all {
+			continue
+		}
+
+		plain, err := provider.UnwrapKey(ctx, key.MasterKeyID, key.Wrapped)
+
+		if err != nil {
+			return rewrapped, fmt.Errorf("Rewrap failed unwrapping %s %w", key.ID, err)
+		}
+
+		wrapped, masterKeyID, err := provider.WrapKey(ctx, plain)
+
+		if err != nil {
+			return rewrapped, fmt.Errorf("Rewrap failed wrapping %s %w", key.ID, err)
+		}
+
+		if err = store.UpdateDataKey(ctx, key.ID, wrapped, masterKeyID); err != nil {
+			return rewrapped, fmt.Errorf("Rewrap failed saving %s %w", key.ID, err)
+		}
+
+		rewrapped++
+	}
+
+	return rewrapped, nil
+}
+
+// NewKeyProviderFromConfig uses KMS when a key is configured, the local keyring otherwise
+func NewKeyProviderFromConfig(ctx context.Context, aws config.AWSConfig, cfg config.EncryptionConfig) (KeyProvider, error) {
+	if aws.KMSKeyID != "" {
+		return NewKMSKeyProvider(ctx, aws.KMSKeyID, aws.Region)
+	}
+
+	if cfg.MasterKeyFile != "" {
+		return NewLocalKeyProvider(cfg.MasterKeyFile)
+	}
+
+	return nil, fmt.Errorf("NewKeyProviderFromConfig neither aws.kms_key_id nor encryption.master_key_file is set %w", ErrUnknownMasterKey)
+}
@@ -0,0 +1,134 @@
+package encryption
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testKeyring(t *testing.T, lines ...string) *LocalKeyProvider {
+	t.Helper()
+	provider, err := ParseKeyring(strings.NewReader(strings.Join(lines, "\n")))
+	assert.NoError(t, err)
+
+	return provider
+}
+
+func keyringLine(t *testing.T, at time.Time) string {
+	t.Helper()
+	line, err := NewKeyringLine(at)
+	assert.NoError(t, err)
+
+	return line
+}
+
+func TestFieldCipher_RoundTrip(t *testing.T) {
+	store := NewMemoryKeyStore()
+	fields, err := LoadFieldCipher(context.Background(), testKeyring(t, keyringLine(t, time.Now())), store)
+	assert.NoError(t, err)
+	assert.Len(t, store.keys, 2)
+
+	sealed, err := fields.Encrypt("foo@bar.com")
+	assert.NoError(t, err)
+	assert.True(t, Encrypted(sealed))
+	assert.NotContains(t, sealed, "foo@bar.com")
+
+	other, _ := fields.Encrypt("foo@bar.com")
+	assert.NotEqual(t, sealed, other, "the encryption must be randomized")
+
+	plain, err := fields.Decrypt(context.Background(), sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo@bar.com", plain)
+
+	// values written before the encryption pass through
+	plain, err = fields.Decrypt(context.Background(), "legacy@bar.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy@bar.com", plain)
+
+	_, err = fields.Decrypt(context.Background(), sealed[:len(sealed)-4])
+	assert.ErrorIs(t, err, ErrInvalidCiphertext)
+}
+
+func TestFieldCipher_BlindIndex(t *testing.T) {
+	provider := testKeyring(t, keyringLine(t, time.Now()))
+	store := NewMemoryKeyStore()
+	fields, _ := LoadFieldCipher(context.Background(), provider, store)
+
+	assert.Equal(t, fields.BlindIndex("foo@bar.com"), fields.BlindIndex(" Foo@Bar.com"))
+	assert.NotEqual(t, fields.BlindIndex("foo@bar.com"), fields.BlindIndex("bar@bar.com"))
+	assert.Empty(t, fields.BlindIndex(""))
+
+	// another instance on the same store indexes the same way
+	again, err := LoadFieldCipher(context.Background(), provider, store)
+	assert.NoError(t, err)
+	assert.Len(t, store.keys, 2)
+	assert.Equal(t, fields.BlindIndex("foo@bar.com"), again.BlindIndex("foo@bar.com"))
+}
+
+func TestFieldCipher_LoadsNewKeys(t *testing.T) {
+	provider := testKeyring(t, keyringLine(t, time.Now()))
+	store := NewMemoryKeyStore()
+	fields, _ := LoadFieldCipher(context.Background(), provider, store)
+	writer, _ := LoadFieldCipher(context.Background(), provider, store)
+
+	// a data key added after fields started
+	assert.NoError(t, writer.createKey(context.Background(), DataKey{ID: "data-2", Purpose: PurposeData}))
+	assert.NoError(t, writer.load(context.Background()))
+	sealed, err := writer.Encrypt("name")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, fieldPrefix+"data-2:"))
+
+	plain, err := fields.Decrypt(context.Background(), sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "name", plain)
+}
+
+func TestRewrap(t *testing.T) {
+	oldKey := keyringLine(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := NewMemoryKeyStore()
+	fields, err := LoadFieldCipher(context.Background(), testKeyring(t, oldKey), store)
+	assert.NoError(t, err)
+	sealed, _ := fields.Encrypt("foo@bar.com")
+
+	newKey := keyringLine(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	rotated := testKeyring(t, oldKey, newKey)
+	count, err := Rewrap(context.Background(), rotated, store, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	count, err = Rewrap(context.Background(), rotated, store, false)
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+
+	// the old master key is no longer needed, the data is still readable
+	reloaded, err := LoadFieldCipher(context.Background(), testKeyring(t, newKey), store)
+	assert.NoError(t, err)
+	plain, err := reloaded.Decrypt(context.Background(), sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo@bar.com", plain)
+	for _, key := range store.keys {
+		assert.Equal(t, rotated.KeyID(), key.MasterKeyID)
+	}
+}
+
+func TestParseKeyring(t *testing.T) {
+	first, second := keyringLine(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)), keyringLine(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	provider := testKeyring(t, "# dev keys", first, "", second)
+	assert.Equal(t, "local-20250101T000000", provider.KeyID())
+
+	wrapped, id, err := provider.WrapKey(context.Background(), []byte("data key"))
+	assert.NoError(t, err)
+	assert.Equal(t, provider.KeyID(), id)
+
+	_, err = provider.UnwrapKey(context.Background(), "local-unknown", wrapped)
+	assert.ErrorIs(t, err, ErrUnknownMasterKey)
+
+	_, err = ParseKeyring(strings.NewReader("id:short"))
+	assert.Error(t, err)
+
+	_, err = ParseKeyring(strings.NewReader("# nothing"))
+	assert.ErrorIs(t, err, ErrUnknownMasterKey)
+}
@@ -0,0 +1,134 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// KMSKeyProvider wraps the data keys with an AWS KMS key, it speaks the KMS JSON API so only Encrypt
+// and Decrypt have to be allowed for the service. Configure the key by ARN, aliases resolve to the ARN
+// the data keys are recorded with and would always look due for a rewrap
+type KMSKeyProvider struct {
+	keyID       string
+	region      string
+	endpoint    string
+	credentials aws.CredentialsProvider
+	client      *http.Client
+	signer      *v4.Signer
+}
+
+func NewKMSKeyProvider(ctx context.Context, keyID, region string) (*KMSKeyProvider, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+
+	if err != nil {
+		return nil, fmt.Errorf("NewKMSKeyProvider %w", err)
+	}
+
+	return &KMSKeyProvider{
+		keyID:       keyID,
+		region:      cfg.Region,
+		endpoint:    fmt.Sprintf("https://kms.%s.amazonaws.com/", cfg.Region),
+		credentials: cfg.Credentials,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		signer:      v4.NewSigner(),
+	}, nil
+}
+
+func (p *KMSKeyProvider) KeyID() string {
+
+	return p.keyID
+}
+
+// the JSON API encodes blobs as base64, which encoding/json does for byte slices
+type kmsEncryptRequest struct {
+	KeyId     string
+	Plaintext []byte
+}
+
+type kmsEncryptResponse struct {
+	CiphertextBlob []byte
+	KeyId          string
+}
+
+type kmsDecryptRequest struct {
+	KeyId          string
+	CiphertextBlob []byte
+}
+
+type kmsDecryptResponse struct {
+	Plaintext []byte
+}
+
+func (p *KMSKeyProvider) WrapKey(ctx context.Context, dataKey []byte) ([]byte, string, error) {
+	var res kmsEncryptResponse
+
+	if err := p.call(ctx, "Encrypt", kmsEncryptRequest{KeyId: p.keyID, Plaintext: dataKey}, &res); err != nil {
+		return nil, "", fmt.Errorf("KMSKeyProvider.WrapKey %w", err)
+	}
+
+	return res.CiphertextBlob, res.KeyId, nil
+}
+
+func (p *KMSKeyProvider) UnwrapKey(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error) {
+	var res kmsDecryptResponse
+
+	if err := p.call(ctx, "Decrypt", kmsDecryptRequest{KeyId: masterKeyID, CiphertextBlob: wrapped}, &res); err != nil {
+		return nil, fmt.Errorf("KMSKeyProvider.UnwrapKey %w", err)
+	}
+
+	return res.Plaintext, nil
+}
+
+func (p *KMSKeyProvider) call(ctx context.Context, action string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+
+	credentials, err := p.credentials.Retrieve(ctx)
+
+	if err != nil {
+		return fmt.Errorf("failed retrieving credentials %w", err)
+	}
+
+	payloadHash := sha256.Sum256(body)
+
+	if err = p.signer.SignHTTP(ctx, credentials, req, hex.EncodeToString(payloadHash[:]), "kms", p.region, time.Now()); err != nil {
+		return fmt.Errorf("failed signing request %w", err)
+	}
+
+	res, err := p.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("kms %s returned %d: %s", action, res.StatusCode, message)
+	}
+
+	return json.NewDecoder(res.Body).Decode(response)
+}
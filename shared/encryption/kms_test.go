@@ -0,0 +1,60 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeKMS "encrypts" by prefixing the key id, enough to check the requests the provider sends
+func fakeKMS(t *testing.T) *httptest.Server {
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256"))
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.Encrypt":
+			var req kmsEncryptRequest
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			_ = json.NewEncoder(w).Encode(kmsEncryptResponse{CiphertextBlob: append([]byte(req.KeyId+"|"), req.Plaintext...), KeyId: "arn:" + req.KeyId})
+		case "TrentService.Decrypt":
+			var req kmsDecryptRequest
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			_, plain, _ := strings.Cut(string(req.CiphertextBlob), "|")
+			_ = json.NewEncoder(w).Encode(kmsDecryptResponse{Plaintext: []byte(plain)})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	server := fakeKMS(t)
+	defer server.Close()
+
+	provider := &KMSKeyProvider{
+		keyID:    "pii",
+		region:   "eu-west-1",
+		endpoint: server.URL,
+		credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "id", SecretAccessKey: "secret"}, nil
+		}),
+		client: server.Client(),
+		signer: v4.NewSigner(),
+	}
+
+	wrapped, masterKeyID, err := provider.WrapKey(context.Background(), []byte("data key"))
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:pii", masterKeyID)
+
+	plain, err := provider.UnwrapKey(context.Background(), masterKeyID, wrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, "data key", string(plain))
+}
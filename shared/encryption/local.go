@@ -0,0 +1,116 @@
+package encryption
+
+import (
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// LocalKeyProvider keeps the master keys in a keyring file, it is meant for development.
+// Each line of the file is <id>:<base64 32 byte key>, the last key is the current one and the
+// older ones are kept so the data keys they wrapped can still be unwrapped until they are rewrapped
+type LocalKeyProvider struct {
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+func NewLocalKeyProvider(path string) (*LocalKeyProvider, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("NewLocalKeyProvider %w", err)
+	}
+	defer file.Close()
+
+	return ParseKeyring(file)
+}
+
+// ParseKeyring reads a keyring, empty lines and lines starting with # are skipped
+func ParseKeyring(r io.Reader) (*LocalKeyProvider, error) {
+	p := &LocalKeyProvider{keys: map[string]cipher.AEAD{}}
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(line, ":")
+		key, err := base64.StdEncoding.DecodeString(encoded)
+
+		if !ok || id == "" || err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("ParseKeyring invalid key %q, expected <id>:<base64 32 byte key>", id)
+		}
+
+		if p.keys[id], err = newAEAD(key); err != nil {
+			return nil, fmt.Errorf("ParseKeyring %w", err)
+		}
+		p.current = id
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ParseKeyring %w", err)
+	}
+
+	if p.current == "" {
+		return nil, fmt.Errorf("ParseKeyring no master key %w", ErrUnknownMasterKey)
+	}
+
+	return p, nil
+}
+
+// NewKeyringLine generates a master key in the keyring format, appending it to the file makes it the current key
+func NewKeyringLine(now time.Time) (string, error) {
+	key := make([]byte, 32)
+
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("NewKeyringLine %w", err)
+	}
+
+	return fmt.Sprintf("local-%s:%s", now.UTC().Format("20060102T150405"), base64.StdEncoding.EncodeToString(key)), nil
+}
+
+func (p *LocalKeyProvider) KeyID() string {
+
+	return p.current
+}
+
+func (p *LocalKeyProvider) WrapKey(ctx context.Context, dataKey []byte) ([]byte, string, error) {
+	aead := p.keys[p.current]
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", fmt.Errorf("LocalKeyProvider.WrapKey %w", err)
+	}
+
+	return aead.Seal(nonce, nonce, dataKey, []byte(p.current)), p.current, nil
+}
+
+func (p *LocalKeyProvider) UnwrapKey(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[masterKeyID]
+
+	if !ok {
+		return nil, fmt.Errorf("LocalKeyProvider.UnwrapKey %s %w", masterKeyID, ErrUnknownMasterKey)
+	}
+
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+
+	plain, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(masterKeyID))
+
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	return plain, nil
+}
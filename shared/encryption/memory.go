@@ -0,0 +1,52 @@
+package encryption
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryKeyStore keeps the data keys in memory, for tests
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys []DataKey
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+
+	return &MemoryKeyStore{}
+}
+
+func (s *MemoryKeyStore) ListDataKeys(ctx context.Context) ([]DataKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]DataKey(nil), s.keys...), nil
+}
+
+func (s *MemoryKeyStore) CreateDataKey(ctx context.Context, key DataKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.keys {
+		if existing.ID == key.ID {
+			return ErrKeyExists
+		}
+	}
+
+	s.keys = append(s.keys, key)
+
+	return nil
+}
+
+func (s *MemoryKeyStore) UpdateDataKey(ctx context.Context, id string, wrapped []byte, masterKeyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.keys {
+		if s.keys[i].ID == id {
+			s.keys[i].Wrapped, s.keys[i].MasterKeyID = wrapped, masterKeyID
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package encryption
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SQLKeyStore keeps the wrapped data keys in a table of this shape in the database of the service:
+//
+//	id varchar(64) primary key, purpose varchar(16), wrapped_key varbinary(1024), master_key_id varchar(255),
+//	created_at timestamp(6) default current_timestamp(6)
+type SQLKeyStore struct {
+	db *sql.DB
+}
+
+func NewSQLKeyStore(db *sql.DB) *SQLKeyStore {
+
+	return &SQLKeyStore{db: db}
+}
+
+func (s *SQLKeyStore) ListDataKeys(ctx context.Context) ([]DataKey, error) {
+	rows, err := s.db.QueryContext(ctx, "select id, purpose, wrapped_key, master_key_id, created_at from encryption_keys order by created_at")
+
+	if err != nil {
+		return nil, fmt.Errorf("SQLKeyStore.ListDataKeys %w", err)
+	}
+	defer rows.Close()
+
+	var keys []DataKey
+	for rows.Next() {
+		var dataKey DataKey
+
+		if err = rows.Scan(&dataKey.ID, &dataKey.Purpose, &dataKey.Wrapped, &dataKey.MasterKeyID, &dataKey.CreatedAt); err != nil {
+			return nil, fmt.Errorf("SQLKeyStore.ListDataKeys failed scanning key %w", err)
+		}
+
+		keys = append(keys, dataKey)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("SQLKeyStore.ListDataKeys %w", err)
+	}
+
+	return keys, nil
+}
+
+func (s *SQLKeyStore) CreateDataKey(ctx context.Context, dataKey DataKey) error {
+	res, err := s.db.ExecContext(ctx, "insert ignore into encryption_keys (id, purpose, wrapped_key, master_key_id, created_at) values (?, ?, ?, ?, ?)",
+		dataKey.ID, dataKey.Purpose, dataKey.Wrapped, dataKey.MasterKeyID, dataKey.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("SQLKeyStore.CreateDataKey %w", err)
+	}
+
+	inserted, err := res.RowsAffected()
+
+	if err != nil {
+		return fmt.Errorf("SQLKeyStore.CreateDataKey %w", err)
+	}
+
+	if inserted == 0 {
+		return ErrKeyExists
+	}
+
+	return nil
+}
+
+func (s *SQLKeyStore) UpdateDataKey(ctx context.Context, id string, wrapped []byte, masterKeyID string) error {
+	_, err := s.db.ExecContext(ctx, "update encryption_keys set wrapped_key = ?, master_key_id = ? where id = ?", wrapped, masterKeyID, id)
+
+	if err != nil {
+		return fmt.Errorf("SQLKeyStore.UpdateDataKey %w", err)
+	}
+
+	return nil
+}
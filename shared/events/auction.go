@@ -0,0 +1,65 @@
+package events
+
+import "time"
+
+// published by auction-service through its outbox
+const (
+	TypeAuctionCreated   = "AuctionCreated"
+	TypeAuctionUpdated   = "AuctionUpdated"
+	TypeBidPlaced        = "BidPlaced"
+	TypeAuctionClosed    = "AuctionClosed"
+	TypeAuctionCancelled = "AuctionCancelled"
+	TypeAuctionDeleted   = "AuctionDeleted"
+
+	TopicAuctionCreated   = "auction.created"
+	TopicAuctionUpdated   = "auction.updated"
+	TopicBidPlaced        = "auction.bid.placed"
+	TopicAuctionClosed    = "auction.closed"
+	TopicAuctionCancelled = "auction.cancelled"
+	TopicAuctionDeleted   = "auction.deleted"
+)
+
+type AuctionCreated struct {
+	AuctionID    string    `json:"auctionId"`
+	SellerID     string    `json:"sellerId"`
+	Description  string    `json:"description"`
+	InitialOffer int64     `json:"initialOffer"`
+	MinIncrement int64     `json:"minIncrement"`
+	Status       string    `json:"status"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// AuctionUpdated carries the fields that changed, the others are empty
+type AuctionUpdated struct {
+	AuctionID   string    `json:"auctionId"`
+	Description string    `json:"description,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+type BidPlaced struct {
+	BidID     string    `json:"bidId"`
+	AuctionID string    `json:"auctionId"`
+	BidderID  string    `json:"bidderId"`
+	Amount    float64   `json:"amount"`
+	PlacedAt  time.Time `json:"placedAt"`
+}
+
+type AuctionClosed struct {
+	AuctionID string    `json:"auctionId"`
+	WinnerID  string    `json:"winnerId,omitempty"`
+	FinalBid  float64   `json:"finalBid"`
+	ClosedAt  time.Time `json:"closedAt"`
+}
+
+type AuctionCancelled struct {
+	AuctionID   string    `json:"auctionId"`
+	CancelledAt time.Time `json:"cancelledAt"`
+}
+
+// AuctionDeleted carries the seller, the auction can't be looked up anymore
+type AuctionDeleted struct {
+	AuctionID string    `json:"auctionId"`
+	SellerID  string    `json:"sellerId"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
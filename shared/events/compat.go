@@ -0,0 +1,130 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Compatible lists the changes from the old schema to the current one that break consumers or payloads of
+// the old one: a property removed or its type, format or items changed, a property becoming required,
+// enum values removed and additional properties forbidden. Adding optional properties is compatible
+func Compatible(old, current []byte) ([]string, error) {
+	var o, n map[string]interface{}
+
+	if err := json.Unmarshal(old, &o); err != nil {
+		return nil, fmt.Errorf("Compatible old schema %w", err)
+	}
+
+	if err := json.Unmarshal(current, &n); err != nil {
+		return nil, fmt.Errorf("Compatible new schema %w", err)
+	}
+
+	var breaking []string
+	compare("$", o, n, &breaking)
+
+	return breaking, nil
+}
+
+func compare(path string, old, current map[string]interface{}, breaking *[]string) {
+	report := func(format string, args ...interface{}) {
+		*breaking = append(*breaking, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if oldTypes, newTypes := set(old["type"]), set(current["type"]); len(newTypes) > 0 {
+		for _, t := range keys(oldTypes) {
+			if !newTypes[t] && !(t == "integer" && newTypes["number"]) {
+				report("type %v no longer allowed", t)
+			}
+		}
+
+		if len(oldTypes) == 0 {
+			report("type restricted to %v", keys(newTypes))
+		}
+	}
+
+	if old["format"] != current["format"] && current["format"] != nil {
+		report("format changed from %v to %v", old["format"], current["format"])
+	}
+
+	if newEnum := set(current["enum"]); len(newEnum) > 0 {
+		oldEnum := set(old["enum"])
+
+		if len(oldEnum) == 0 {
+			report("restricted to %v", keys(newEnum))
+		}
+
+		for _, value := range keys(oldEnum) {
+			if !newEnum[value] {
+				report("enum value %v removed", value)
+			}
+		}
+	}
+
+	oldRequired := set(old["required"])
+	for _, field := range keys(set(current["required"])) {
+		if !oldRequired[field] {
+			report("%s became required", field)
+		}
+	}
+
+	if additional, ok := current["additionalProperties"].(bool); ok && !additional {
+		if oldAdditional, ok := old["additionalProperties"].(bool); !ok || oldAdditional {
+			report("additional properties no longer allowed")
+		}
+	}
+
+	oldProperties, _ := old["properties"].(map[string]interface{})
+	newProperties, _ := current["properties"].(map[string]interface{})
+
+	names := make([]string, 0, len(oldProperties))
+	for name := range oldProperties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		newProperty, ok := newProperties[name].(map[string]interface{})
+
+		if !ok {
+			report("%s removed", name)
+			continue
+		}
+
+		oldProperty, _ := oldProperties[name].(map[string]interface{})
+		compare(path+"."+name, oldProperty, newProperty, breaking)
+	}
+
+	oldItems, _ := old["items"].(map[string]interface{})
+	newItems, _ := current["items"].(map[string]interface{})
+	if newItems != nil {
+		compare(path+"[]", oldItems, newItems, breaking)
+	}
+}
+
+// set reads a schema keyword holding a value or a list of values, numbers and strings alike
+func set(v interface{}) map[string]bool {
+	values := map[string]bool{}
+
+	switch v := v.(type) {
+	case nil:
+	case []interface{}:
+		for _, value := range v {
+			values[fmt.Sprint(value)] = true
+		}
+	default:
+		values[fmt.Sprint(v)] = true
+	}
+
+	return values
+}
+
+func keys(values map[string]bool) []string {
+	sorted := make([]string, 0, len(values))
+	for value := range values {
+		sorted = append(sorted, value)
+	}
+	sort.Strings(sorted)
+
+	return sorted
+}
@@ -0,0 +1,58 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompatible(t *testing.T) {
+	old := `{"type": "object", "properties": {
+		"id": {"type": "string"},
+		"amount": {"type": "integer"},
+		"method": {"type": "string", "enum": ["password", "federated"]},
+		"at": {"type": "string", "format": "date-time"}
+	}, "required": ["id"]}`
+
+	tests := []struct {
+		name     string
+		current  string
+		expected []string
+	}{
+		{
+			name: "optional property added",
+			current: `{"type": "object", "properties": {
+				"id": {"type": "string"}, "amount": {"type": "number"}, "method": {"type": "string", "enum": ["password", "federated", "magic"]},
+				"at": {"type": "string", "format": "date-time"}, "note": {"type": "string"}
+			}, "required": ["id"]}`,
+		},
+		{
+			name: "breaking changes",
+			current: `{"type": "object", "additionalProperties": false, "properties": {
+				"id": {"type": "integer"}, "amount": {"type": "integer"}, "method": {"type": "string", "enum": ["password"]},
+				"at": {"type": "string", "format": "date"}, "note": {"type": "string"}
+			}, "required": ["id", "note"]}`,
+			expected: []string{
+				"$: note became required",
+				"$: additional properties no longer allowed",
+				"$.at: format changed from date-time to date",
+				"$.id: type string no longer allowed",
+				"$.method: enum value federated removed",
+			},
+		},
+		{
+			name:     "property removed",
+			current:  `{"type": "object", "properties": {"id": {"type": "string"}, "amount": {"type": "integer"}, "method": {"type": "string"}}, "required": ["id"]}`,
+			expected: []string{"$: at removed"},
+		},
+	}
+
+	for _, test := range tests {
+		breaking, err := Compatible([]byte(old), []byte(test.current))
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, breaking, test.name)
+	}
+
+	_, err := Compatible([]byte(old), []byte("{"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,43 @@
+package events_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ireuven89/auctions/shared/events"
+	"github.com/ireuven89/auctions/shared/events/eventstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemasBackwardCompatible(t *testing.T) {
+	eventstest.AssertBackwardCompatible(t, events.DefaultRegistry, "testdata/snapshots")
+}
+
+// the payloads the services publish match their schemas
+func TestPayloadsMatchSchemas(t *testing.T) {
+	now := time.Now().UTC()
+	payloads := map[string]interface{}{
+		events.TypeUserRegistered:   events.UserRegistered{UserID: "user-id", Method: events.RegistrationFederated, RegisteredAt: now},
+		events.TypeUserDeleted:      events.UserDeleted{UserID: "user-id", DeletedAt: now},
+		events.TypeAuctionCreated:   events.AuctionCreated{AuctionID: "auction-id", SellerID: "seller-id", InitialOffer: 10, Status: "pending", CreatedAt: now},
+		events.TypeAuctionUpdated:   events.AuctionUpdated{AuctionID: "auction-id", UpdatedAt: now},
+		events.TypeBidPlaced:        events.BidPlaced{BidID: "bid-id", AuctionID: "auction-id", BidderID: "bidder-id", Amount: 12.5, PlacedAt: now},
+		events.TypeAuctionClosed:    events.AuctionClosed{AuctionID: "auction-id", ClosedAt: now},
+		events.TypeAuctionCancelled: events.AuctionCancelled{AuctionID: "auction-id", CancelledAt: now},
+		events.TypeAuctionDeleted:   events.AuctionDeleted{AuctionID: "auction-id", SellerID: "seller-id", DeletedAt: now},
+		events.TypeImpersonatedRequest: events.ImpersonatedRequest{Service: "auction-service", ActorID: "admin-id", SubjectID: "user-id",
+			Method: "POST", Path: "/auctions", Status: 201, At: now},
+	}
+
+	assert.Len(t, payloads, len(events.DefaultRegistry.Types()))
+
+	for eventType, payload := range payloads {
+		envelope, err := events.NewEnvelope(context.Background(), eventType, payload)
+		assert.NoError(t, err, eventType)
+		assert.Equal(t, 1, envelope.Version, eventType)
+	}
+
+	_, err := events.NewEnvelope(context.Background(), events.TypeUserRegistered, events.UserRegistered{Method: "sms"})
+	assert.ErrorIs(t, err, events.ErrInvalidPayload)
+}
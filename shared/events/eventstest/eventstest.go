@@ -0,0 +1,91 @@
+// Package eventstest holds the schemas of the events to their contract in tests
+package eventstest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ireuven89/auctions/shared/events"
+)
+
+// UpdateEnv set to 1 writes the missing snapshots, they are committed with the schemas
+const UpdateEnv = "UPDATE_SCHEMA_SNAPSHOTS"
+
+// AssertBackwardCompatible fails the test when a schema of the registry breaks its snapshot in dir, a
+// copy of the schema as it was first published. Every version has to stay compatible with its snapshot,
+// see events.Compatible, and a version other than the first needs an upcaster from the previous one
+// unless it is compatible with it. Schemas of published versions are never removed
+func AssertBackwardCompatible(t testing.TB, registry *events.Registry, dir string) {
+	t.Helper()
+
+	published := map[string]bool{}
+	snapshots, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, snapshot := range snapshots {
+		published[filepath.Base(snapshot)] = true
+	}
+
+	for _, eventType := range registry.Types() {
+		for _, version := range registry.Versions(eventType) {
+			name := fmt.Sprintf("%s.v%d.json", eventType, version)
+			delete(published, name)
+
+			current, _ := registry.Schema(eventType, version)
+			assertSnapshot(t, filepath.Join(dir, name), current)
+
+			if version == 1 {
+				continue
+			}
+
+			previous, ok := registry.Schema(eventType, version-1)
+
+			if !ok {
+				t.Errorf("%s v%d has no previous version", eventType, version)
+				continue
+			}
+
+			if breaking, _ := events.Compatible(previous, current); len(breaking) > 0 && !registry.HasUpcaster(eventType, version-1) {
+				t.Errorf("%s v%d breaks v%d and has no upcaster from it %v", eventType, version, version-1, breaking)
+			}
+		}
+	}
+
+	for name := range published {
+		t.Errorf("the schema of %s was removed, consumers may still receive it", name)
+	}
+}
+
+func assertSnapshot(t testing.TB, path string, current []byte) {
+	t.Helper()
+
+	snapshot, err := os.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		if os.Getenv(UpdateEnv) != "1" {
+			t.Errorf("%s has no snapshot, run the test with %s=1 and commit it", filepath.Base(path), UpdateEnv)
+			return
+		}
+
+		if err = os.WriteFile(path, current, 0o644); err != nil {
+			t.Errorf("failed writing snapshot %v", err)
+		}
+		return
+	}
+
+	if err != nil {
+		t.Errorf("failed reading snapshot %v", err)
+		return
+	}
+
+	breaking, err := events.Compatible(snapshot, current)
+
+	if err != nil {
+		t.Errorf("%s %v", filepath.Base(path), err)
+		return
+	}
+
+	for _, change := range breaking {
+		t.Errorf("%s is not backward compatible with its snapshot, %s", filepath.Base(path), change)
+	}
+}
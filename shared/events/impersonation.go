@@ -0,0 +1,63 @@
+package events
+
+import (
+	"context"
+	"log"
+	"time"
+
+	sharedhttp "github.com/ireuven89/auctions/shared/http"
+	"github.com/ireuven89/auctions/shared/messaging/outbox"
+)
+
+// published by the services other than auth-service through their outbox, auth-service keeps them in its audit log
+const (
+	TypeImpersonatedRequest  = "ImpersonatedRequest"
+	TopicImpersonatedRequest = "impersonation.request"
+)
+
+type ImpersonatedRequest struct {
+	Service   string    `json:"service"`
+	ActorID   string    `json:"actorId"`
+	SubjectID string    `json:"subjectId"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// OutboxImpersonationRecorder writes the requests made while impersonating to the outbox of the service, so they
+// reach the audit log of auth-service even when the broker or auth-service are down
+type OutboxImpersonationRecorder struct {
+	exec    outbox.Execer
+	service string
+	// fallback keeps the entries the outbox refused in the logs rather than losing them
+	fallback sharedhttp.ImpersonationRecorder
+}
+
+func NewOutboxImpersonationRecorder(exec outbox.Execer, service string) *OutboxImpersonationRecorder {
+
+	return &OutboxImpersonationRecorder{exec: exec, service: service, fallback: sharedhttp.NewLogImpersonationRecorder(nil)}
+}
+
+func (r *OutboxImpersonationRecorder) RecordImpersonation(ctx context.Context, entry sharedhttp.ImpersonationEntry) {
+	entry.Service = r.service
+
+	envelope, err := NewEnvelope(ctx, TypeImpersonatedRequest, ImpersonatedRequest{
+		Service:   entry.Service,
+		ActorID:   entry.Actor,
+		SubjectID: entry.Subject,
+		Method:    entry.Method,
+		Path:      entry.Path,
+		Status:    entry.Status,
+		At:        entry.At,
+	})
+
+	if err == nil {
+		err = outbox.Insert(ctx, r.exec, outbox.Event{AggregateID: entry.Subject, Topic: TopicImpersonatedRequest, Envelope: envelope})
+	}
+
+	if err != nil {
+		log.Printf("OutboxImpersonationRecorder.RecordImpersonation failed writing to the outbox %v", err)
+		r.fallback.RecordImpersonation(ctx, entry)
+	}
+}
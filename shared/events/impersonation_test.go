@@ -0,0 +1,31 @@
+package events
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	sharedhttp "github.com/ireuven89/auctions/shared/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutboxImpersonationRecorder(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("insert into outbox (id, aggregate_id, topic, type, envelope) values (?, ?, ?, ?, ?)")).
+		WithArgs(sqlmock.AnyArg(), "user-id", TopicImpersonatedRequest, TypeImpersonatedRequest, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	recorder := NewOutboxImpersonationRecorder(db, "auction-service")
+	recorder.RecordImpersonation(context.Background(), sharedhttp.ImpersonationEntry{
+		Actor: "admin-id", Subject: "user-id", Method: http.MethodPost, Path: "/auctions", Status: http.StatusCreated, At: time.Now().UTC(),
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
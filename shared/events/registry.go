@@ -0,0 +1,264 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var (
+	ErrUnknownEvent   = errors.New("events: no schema for the event")
+	ErrInvalidPayload = errors.New("events: payload does not match its schema")
+	ErrNoUpcaster     = errors.New("events: no upcaster to the next version")
+)
+
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// DefaultRegistry holds the schemas of this package, the package functions use it
+var DefaultRegistry = mustLoadDefault()
+
+var schemaFileName = regexp.MustCompile(`^([A-Za-z]+)\.v([0-9]+)\.json$`)
+
+// Upcaster turns the payload of a version into the payload of the next one
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+type schema struct {
+	source   []byte
+	compiled *jsonschema.Schema
+}
+
+// Registry holds the schemas of the events by type and version, and the upcasters between the versions
+type Registry struct {
+	schemas   map[string]map[int]schema
+	upcasters map[string]map[int]Upcaster
+}
+
+// LoadRegistry compiles the schemas in the root of fsys, files named <Type>.v<version>.json
+func LoadRegistry(fsys fs.FS) (*Registry, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+
+	if err != nil {
+		return nil, fmt.Errorf("LoadRegistry %w", err)
+	}
+
+	r := &Registry{schemas: map[string]map[int]schema{}, upcasters: map[string]map[int]Upcaster{}}
+
+	for _, entry := range entries {
+		match := schemaFileName.FindStringSubmatch(entry.Name())
+
+		if match == nil {
+			continue
+		}
+
+		version, _ := strconv.Atoi(match[2])
+		source, err := fs.ReadFile(fsys, entry.Name())
+
+		if err != nil {
+			return nil, fmt.Errorf("LoadRegistry %w", err)
+		}
+
+		compiled, err := compile(entry.Name(), source)
+
+		if err != nil {
+			return nil, fmt.Errorf("LoadRegistry %s %w", entry.Name(), err)
+		}
+
+		if r.schemas[match[1]] == nil {
+			r.schemas[match[1]] = map[int]schema{}
+		}
+		r.schemas[match[1]][version] = schema{source: source, compiled: compiled}
+	}
+
+	return r, nil
+}
+
+func mustLoadDefault() *Registry {
+	schemas, err := fs.Sub(schemaFiles, "schemas")
+
+	if err != nil {
+		panic(err)
+	}
+
+	r, err := LoadRegistry(schemas)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return r
+}
+
+func compile(name string, source []byte) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft2020
+	compiler.AssertFormat = true
+
+	if err := compiler.AddResource(name, bytes.NewReader(source)); err != nil {
+		return nil, err
+	}
+
+	return compiler.Compile(name)
+}
+
+// RegisterUpcaster adds the upcaster from a version of the event to the next, register them before consuming
+func (r *Registry) RegisterUpcaster(eventType string, from int, upcaster Upcaster) {
+	if r.upcasters[eventType] == nil {
+		r.upcasters[eventType] = map[int]Upcaster{}
+	}
+
+	r.upcasters[eventType][from] = upcaster
+}
+
+// HasUpcaster tells whether the version of the event can be upcast to the next
+func (r *Registry) HasUpcaster(eventType string, from int) bool {
+	_, ok := r.upcasters[eventType][from]
+
+	return ok
+}
+
+// Types returns the event types with a schema, sorted
+func (r *Registry) Types() []string {
+	types := make([]string, 0, len(r.schemas))
+	for eventType := range r.schemas {
+		types = append(types, eventType)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// Versions returns the versions of the event with a schema, sorted
+func (r *Registry) Versions(eventType string) []int {
+	versions := make([]int, 0, len(r.schemas[eventType]))
+	for version := range r.schemas[eventType] {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+
+	return versions
+}
+
+// Latest is the latest version of the event, 0 when it has no schema
+func (r *Registry) Latest(eventType string) int {
+	latest := 0
+	for version := range r.schemas[eventType] {
+		latest = max(latest, version)
+	}
+
+	return latest
+}
+
+// Schema returns the source of the schema of the version of the event
+func (r *Registry) Schema(eventType string, version int) ([]byte, bool) {
+	s, ok := r.schemas[eventType][version]
+
+	return s.source, ok
+}
+
+// Validate checks the payload against the schema of the version of the event
+func (r *Registry) Validate(eventType string, version int, payload json.RawMessage) error {
+	s, ok := r.schemas[eventType][version]
+
+	if !ok {
+		return fmt.Errorf("%w %s v%d", ErrUnknownEvent, eventType, version)
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return fmt.Errorf("%w %s v%d %v", ErrInvalidPayload, eventType, version, err)
+	}
+
+	if err := s.compiled.Validate(v); err != nil {
+		return fmt.Errorf("%w %s v%d %v", ErrInvalidPayload, eventType, version, err)
+	}
+
+	return nil
+}
+
+// NewEnvelope wraps the payload in an envelope of the latest version of the event, once it matches its schema
+func (r *Registry) NewEnvelope(ctx context.Context, eventType string, payload interface{}) (messaging.Envelope, error) {
+	envelope, err := messaging.NewEnvelope(ctx, eventType, r.Latest(eventType), payload)
+
+	if err != nil {
+		return messaging.Envelope{}, err
+	}
+
+	if err = r.Validate(eventType, envelope.Version, envelope.Payload); err != nil {
+		return messaging.Envelope{}, fmt.Errorf("NewEnvelope %w", err)
+	}
+
+	return envelope, nil
+}
+
+// Upcast validates the envelope against the schema of its version and brings it to the latest version,
+// validating every step. Versions newer than the latest are unknown, the consumer has to be updated first
+func (r *Registry) Upcast(envelope messaging.Envelope) (messaging.Envelope, error) {
+	if err := r.Validate(envelope.Type, envelope.Version, envelope.Payload); err != nil {
+		return envelope, fmt.Errorf("Upcast %s %w", envelope.ID, err)
+	}
+
+	for latest := r.Latest(envelope.Type); envelope.Version < latest; {
+		upcaster, ok := r.upcasters[envelope.Type][envelope.Version]
+
+		if !ok {
+			return envelope, fmt.Errorf("Upcast %s %w %s v%d", envelope.ID, ErrNoUpcaster, envelope.Type, envelope.Version)
+		}
+
+		payload, err := upcaster(envelope.Payload)
+
+		if err != nil {
+			return envelope, fmt.Errorf("Upcast %s %s v%d %w", envelope.ID, envelope.Type, envelope.Version, err)
+		}
+
+		envelope.Payload, envelope.Version = payload, envelope.Version+1
+
+		if err = r.Validate(envelope.Type, envelope.Version, envelope.Payload); err != nil {
+			return envelope, fmt.Errorf("Upcast %s %w", envelope.ID, err)
+		}
+	}
+
+	return envelope, nil
+}
+
+// Validating hands the handler the messages upcast to the latest version of their event. Messages not
+// matching their schema are failed permanently, events without a schema are passed as they are
+func (r *Registry) Validating(handler messaging.Handler) messaging.Handler {
+	return func(ctx context.Context, msg messaging.Message) error {
+		if r.Latest(msg.Type) == 0 {
+			return handler(ctx, msg)
+		}
+
+		envelope, err := r.Upcast(msg.Envelope)
+
+		if err != nil {
+			return messaging.Permanent(err)
+		}
+
+		msg.Envelope = envelope
+
+		return handler(ctx, msg)
+	}
+}
+
+// NewEnvelope wraps the payload in an envelope of the latest version of the event, see Registry.NewEnvelope
+func NewEnvelope(ctx context.Context, eventType string, payload interface{}) (messaging.Envelope, error) {
+
+	return DefaultRegistry.NewEnvelope(ctx, eventType, payload)
+}
+
+// Validating upcasts and validates the messages before the handler sees them, see Registry.Validating
+func Validating(handler messaging.Handler) messaging.Handler {
+
+	return DefaultRegistry.Validating(handler)
+}
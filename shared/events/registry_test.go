@@ -0,0 +1,112 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/stretchr/testify/assert"
+)
+
+// PriceSet v1 had a price in cents, v2 splits it in amount and currency
+var testSchemas = fstest.MapFS{
+	"PriceSet.v1.json": {Data: []byte(`{"type": "object", "properties": {"cents": {"type": "integer"}}, "required": ["cents"]}`)},
+	"PriceSet.v2.json": {Data: []byte(`{"type": "object", "properties": {"amount": {"type": "number"}, "currency": {"type": "string", "enum": ["USD", "EUR"]}}, "required": ["amount", "currency"]}`)},
+	"README.md":        {Data: []byte("not a schema")},
+}
+
+func testRegistry(t *testing.T) *Registry {
+	r, err := LoadRegistry(testSchemas)
+	assert.NoError(t, err)
+
+	r.RegisterUpcaster("PriceSet", 1, func(payload json.RawMessage) (json.RawMessage, error) {
+		var v1 struct {
+			Cents int `json:"cents"`
+		}
+		if err := json.Unmarshal(payload, &v1); err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(map[string]interface{}{"amount": float64(v1.Cents) / 100, "currency": "USD"})
+	})
+
+	return r
+}
+
+func TestRegistry(t *testing.T) {
+	r := testRegistry(t)
+
+	assert.Equal(t, []string{"PriceSet"}, r.Types())
+	assert.Equal(t, []int{1, 2}, r.Versions("PriceSet"))
+	assert.Equal(t, 2, r.Latest("PriceSet"))
+	assert.Equal(t, 0, r.Latest("Unknown"))
+
+	assert.NoError(t, r.Validate("PriceSet", 1, []byte(`{"cents": 150}`)))
+	assert.ErrorIs(t, r.Validate("PriceSet", 1, []byte(`{"cents": "150"}`)), ErrInvalidPayload)
+	assert.ErrorIs(t, r.Validate("PriceSet", 2, []byte(`{"amount": 1.5, "currency": "GBP"}`)), ErrInvalidPayload)
+	assert.ErrorIs(t, r.Validate("PriceSet", 3, []byte(`{}`)), ErrUnknownEvent)
+
+	_, err := LoadRegistry(fstest.MapFS{"Broken.v1.json": {Data: []byte(`{"type": 5}`)}})
+	assert.Error(t, err)
+}
+
+func TestRegistry_NewEnvelope(t *testing.T) {
+	r := testRegistry(t)
+	ctx := context.Background()
+
+	envelope, err := r.NewEnvelope(ctx, "PriceSet", map[string]interface{}{"amount": 1.5, "currency": "EUR"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, envelope.Version)
+
+	_, err = r.NewEnvelope(ctx, "PriceSet", map[string]interface{}{"cents": 150})
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+}
+
+func TestRegistry_Upcast(t *testing.T) {
+	r := testRegistry(t)
+	v1, _ := messaging.NewEnvelope(context.Background(), "PriceSet", 1, map[string]int{"cents": 150})
+
+	upcast, err := r.Upcast(v1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, upcast.Version)
+	assert.JSONEq(t, `{"amount": 1.5, "currency": "USD"}`, string(upcast.Payload))
+
+	// consumers older than the publisher can't read its events
+	v3 := v1
+	v3.Version = 3
+	_, err = r.Upcast(v3)
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+
+	withoutUpcaster, _ := LoadRegistry(testSchemas)
+	_, err = withoutUpcaster.Upcast(v1)
+	assert.ErrorIs(t, err, ErrNoUpcaster)
+}
+
+func TestRegistry_Validating(t *testing.T) {
+	r := testRegistry(t)
+	ctx := context.Background()
+
+	var received []messaging.Message
+	handler := r.Validating(func(ctx context.Context, msg messaging.Message) error {
+		received = append(received, msg)
+		return nil
+	})
+
+	v1, _ := messaging.NewEnvelope(ctx, "PriceSet", 1, map[string]int{"cents": 150})
+	invalid, _ := messaging.NewEnvelope(ctx, "PriceSet", 1, map[string]string{"cents": "a lot"})
+	unknown, _ := messaging.NewEnvelope(ctx, "Unknown", 1, nil)
+
+	assert.NoError(t, handler(ctx, messaging.Message{Envelope: v1}))
+	assert.NoError(t, handler(ctx, messaging.Message{Envelope: unknown}))
+
+	err := handler(ctx, messaging.Message{Envelope: invalid})
+	assert.True(t, messaging.IsPermanent(err))
+	assert.True(t, errors.Is(err, ErrInvalidPayload))
+
+	assert.Len(t, received, 2)
+	assert.Equal(t, 2, received[0].Version)
+	assert.Equal(t, "Unknown", received[1].Type)
+}
@@ -0,0 +1,39 @@
+// Package events holds the events the services exchange, the contract between the publisher of an
+// event and its consumers. Every payload has a JSON Schema per version in schemas, named
+// <Type>.v<version>.json: publishers create envelopes of the latest version with NewEnvelope, consumers
+// have the messages validated and upcast to the latest version with Validating.
+//
+// A published version of a schema only changes in backward compatible ways, adding optional fields. Anything
+// else is a new version with an upcaster from the previous one, eventstest.AssertBackwardCompatible holds
+// the schemas to that
+package events
+
+import "time"
+
+// published by auth-service
+const (
+	TypeUserRegistered = "UserRegistered"
+	TypeUserDeleted    = "UserDeleted"
+
+	TopicUserRegistered = "user.registered"
+	TopicUserDeleted    = "user.deleted"
+)
+
+// how the user registered
+const (
+	RegistrationPassword  = "password"
+	RegistrationFederated = "federated"
+)
+
+// UserRegistered carries no personal data, consumers needing it ask auth-service
+type UserRegistered struct {
+	UserID       string    `json:"userId"`
+	Method       string    `json:"method"`
+	RegisteredAt time.Time `json:"registeredAt"`
+}
+
+// UserDeleted asks the consumers to forget the personal data they keep about the user
+type UserDeleted struct {
+	UserID    string    `json:"userId"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
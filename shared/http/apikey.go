@@ -0,0 +1,163 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKeyResolver turns an API key into the principal it stands for, in the same shape as JWT claims
+type APIKeyResolver interface {
+	ResolveAPIKey(ctx context.Context, key string) (jwt.MapClaims, error)
+}
+
+// RemoteAPIKeyResolver asks auth-service who an API key belongs to, authenticating as a registered client
+type RemoteAPIKeyResolver struct {
+	url          string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+}
+
+func NewRemoteAPIKeyResolver(authURL, clientID, clientSecret string) *RemoteAPIKeyResolver {
+
+	return &RemoteAPIKeyResolver{
+		url:          strings.TrimSuffix(authURL, "/") + "/auth/api-keys/resolve",
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (r *RemoteAPIKeyResolver) ResolveAPIKey(ctx context.Context, key string) (jwt.MapClaims, error) {
+	body, err := json.Marshal(map[string]string{"key": key})
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+
+	if err != nil {
+		return nil, fmt.Errorf("RemoteAPIKeyResolver.ResolveAPIKey %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// basic auth credentials are form encoded before they are base64 encoded (RFC 6749 section 2.3.1)
+	req.SetBasicAuth(url.QueryEscape(r.clientID), url.QueryEscape(r.clientSecret))
+
+	resp, err := r.client.Do(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("RemoteAPIKeyResolver.ResolveAPIKey %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	// a rejected client is a misconfiguration, it must not be cached as a rejected key
+	case resp.StatusCode == http.StatusUnauthorized && strings.HasPrefix(resp.Header.Get("WWW-Authenticate"), "Basic"):
+		return nil, fmt.Errorf("RemoteAPIKeyResolver.ResolveAPIKey client %s was rejected", r.clientID)
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, ErrInvalidAPIKey
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("RemoteAPIKeyResolver.ResolveAPIKey unexpected status %d", resp.StatusCode)
+	}
+
+	var claims jwt.MapClaims
+	if err = json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("RemoteAPIKeyResolver.ResolveAPIKey %w", err)
+	}
+
+	return claims, nil
+}
+
+type cachedPrincipal struct {
+	claims  jwt.MapClaims
+	expires time.Time
+}
+
+// maxCachedKeys bounds the cache, expired entries are dropped when it is full
+const maxCachedKeys = 10000
+
+// CachedAPIKeyResolver remembers resolved keys for a short time, so a revoked key keeps working for up to ttl.
+// Unknown keys are cached as well, so guessing keys doesn't turn into a request to auth-service per attempt
+type CachedAPIKeyResolver struct {
+	next    APIKeyResolver
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cachedPrincipal
+	now     func() time.Time
+}
+
+func NewCachedAPIKeyResolver(next APIKeyResolver, ttl time.Duration) *CachedAPIKeyResolver {
+
+	return &CachedAPIKeyResolver{
+		next:    next,
+		ttl:     ttl,
+		entries: make(map[string]cachedPrincipal),
+		now:     time.Now,
+	}
+}
+
+func (c *CachedAPIKeyResolver) ResolveAPIKey(ctx context.Context, key string) (jwt.MapClaims, error) {
+	// keys are kept hashed so a memory dump doesn't leak them
+	sum := sha256.Sum256([]byte(key))
+	id := hex.EncodeToString(sum[:])
+	now := c.now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[id]
+	c.mu.Unlock()
+
+	if ok && now.Before(entry.expires) {
+		if entry.claims == nil {
+			return nil, ErrInvalidAPIKey
+		}
+
+		return entry.claims, nil
+	}
+
+	claims, err := c.next.ResolveAPIKey(ctx, key)
+
+	if err != nil && !errors.Is(err, ErrInvalidAPIKey) {
+		return nil, err
+	}
+
+	expires := now.Add(c.ttl)
+
+	// never keep a key past its own expiry
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil && exp.Before(expires) {
+		expires = exp.Time
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= maxCachedKeys {
+		for k, v := range c.entries {
+			if !now.Before(v.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) < maxCachedKeys {
+		c.entries[id] = cachedPrincipal{claims: claims, expires: expires}
+	}
+	c.mu.Unlock()
+
+	if claims == nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,103 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubResolver struct {
+	calls  int
+	claims map[string]jwt.MapClaims
+}
+
+func (s *stubResolver) ResolveAPIKey(ctx context.Context, key string) (jwt.MapClaims, error) {
+	s.calls++
+	if claims, ok := s.claims[key]; ok {
+		return claims, nil
+	}
+
+	return nil, ErrInvalidAPIKey
+}
+
+func TestCachedAPIKeyResolver(t *testing.T) {
+	stub := &stubResolver{claims: map[string]jwt.MapClaims{"ak_good": {"sub": "user-id", "typ": TokenTypeAPIKey}}}
+	cache := NewCachedAPIKeyResolver(stub, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		claims, err := cache.ResolveAPIKey(context.Background(), "ak_good")
+		assert.NoError(t, err)
+		assert.Equal(t, "user-id", claims["sub"])
+	}
+	assert.Equal(t, 1, stub.calls)
+
+	for i := 0; i < 3; i++ {
+		_, err := cache.ResolveAPIKey(context.Background(), "ak_bad")
+		assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	}
+	assert.Equal(t, 2, stub.calls)
+
+	now = now.Add(2 * time.Minute)
+	_, _ = cache.ResolveAPIKey(context.Background(), "ak_good")
+	assert.Equal(t, 3, stub.calls)
+}
+
+func TestRemoteAPIKeyResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+
+		switch {
+		case id != "auctions" || secret != "secret":
+			w.Header().Set("WWW-Authenticate", `Basic realm="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path != "/auth/api-keys/resolve":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	_, err := NewRemoteAPIKeyResolver(server.URL, "auctions", "secret").ResolveAPIKey(context.Background(), "ak_bad")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	// a rejected client must not look like a rejected key, or the cache would remember every key as invalid
+	_, err = NewRemoteAPIKeyResolver(server.URL, "auctions", "wrong").ResolveAPIKey(context.Background(), "ak_bad")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidAPIKey)
+}
+
+func TestAuthMiddleware_APIKey(t *testing.T) {
+	stub := &stubResolver{claims: map[string]jwt.MapClaims{"ak_good": {"sub": "user-id", "typ": TokenTypeAPIKey, "scope": "auctions:read"}}}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		header   string
+		handler  http.Handler
+		expected int
+	}{
+		{"valid key", "ApiKey ak_good", AuthMiddleware(nil, stub, nil)(ok), http.StatusOK},
+		{"unknown key", "ApiKey ak_bad", AuthMiddleware(nil, stub, nil)(ok), http.StatusUnauthorized},
+		{"keys disabled", "ApiKey ak_good", AuthMiddleware(nil, nil, nil)(ok), http.StatusUnauthorized},
+		{"missing scope", "ApiKey ak_good", AuthMiddleware(nil, stub, nil)(RequireScopes("auctions:write")(ok)), http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/auctions", nil)
+		req.Header.Set("Authorization", test.header)
+		w := httptest.NewRecorder()
+		test.handler.ServeHTTP(w, req)
+
+		assert.Equal(t, test.expected, w.Code, test.name)
+	}
+}
@@ -1,8 +1,10 @@
 package http
 
 import (
+	"context"
 	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
@@ -78,8 +80,26 @@ func (p *JWKSProvider) GetPublicKey() (interface{}, bool) {
 	return key, ok
 }
 
+// TokenTypeService is the "typ" claim of tokens issued to services with the client_credentials grant
+const TokenTypeService = "service"
+
+// TokenTypeAPIKey is the "typ" claim of the principal an API key resolves to
+const TokenTypeAPIKey = "api_key"
+
+type contextKey string
+
+const claimsContextKey contextKey = "jwt_claims"
+
 // JWTMiddleware applies JWT validation to all routes except those in publicPaths.
+// The claims of a valid token are available to the handlers through ClaimsFromContext
 func JWTMiddleware(publicKey *rsa.PublicKey, publicPaths []string) func(http.Handler) http.Handler {
+
+	return AuthMiddleware(publicKey, nil, publicPaths)
+}
+
+// AuthMiddleware is JWTMiddleware that also accepts "Authorization: ApiKey <key>" when apiKeys is set.
+// Both resolve to the same claims, API keys carry the "typ" TokenTypeAPIKey and their scopes
+func AuthMiddleware(publicKey *rsa.PublicKey, apiKeys APIKeyResolver, publicPaths []string) func(http.Handler) http.Handler {
 	// Build a set for O(1) path lookups
 	public := make(map[string]struct{}, len(publicPaths))
 	for _, p := range publicPaths {
@@ -93,19 +113,125 @@ func JWTMiddleware(publicKey *rsa.PublicKey, publicPaths []string) func(http.Han
 				return
 			}
 			authHeader := r.Header.Get("Authorization")
+
+			if apiKeys != nil && strings.HasPrefix(authHeader, "ApiKey ") {
+				claims, err := apiKeys.ResolveAPIKey(r.Context(), strings.TrimPrefix(authHeader, "ApiKey "))
+				if errors.Is(err, ErrInvalidAPIKey) {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				if err != nil {
+					http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
+				return
+			}
+
 			if !strings.HasPrefix(authHeader, "Bearer ") {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
 			tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-			token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+			claims := jwt.MapClaims{}
+			token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
 				return publicKey, nil
-			})
-			if err != nil || !token.Valid {
+			}, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+			if err != nil || !token.Valid || !bearerTokenType(claims) {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 				return
 			}
-			// Optionally: put claims in context here
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsContextKey, claims)))
+		})
+	}
+}
+
+// bearerTokenType reports whether the token may be used as a bearer token, only user access tokens are untyped.
+// Half logged in users, magic links and the like are signed with the same key but never act as a principal,
+// API key principals only come from the APIKeyResolver
+func bearerTokenType(claims jwt.MapClaims) bool {
+	typ, ok := claims["typ"]
+	if !ok {
+		return true
+	}
+
+	return typ == "" || typ == TokenTypeService
+}
+
+func ClaimsFromContext(ctx context.Context) (jwt.MapClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(jwt.MapClaims)
+
+	return claims, ok
+}
+
+// ContextWithClaims is what the middlewares do with verified claims, for calls that don't go through them
+func ContextWithClaims(ctx context.Context, claims jwt.MapClaims) context.Context {
+
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// IsServiceToken reports whether the claims belong to a service rather than to a user
+func IsServiceToken(claims jwt.MapClaims) bool {
+
+	return claims["typ"] == TokenTypeService
+}
+
+// RoleAdmin is the "role" claim auth-service gives its admins, support staff included
+const RoleAdmin = "admin"
+
+// IsAdmin reports whether the claims are those of an admin's own token, API keys never act as admins
+func IsAdmin(claims jwt.MapClaims) bool {
+	typ, _ := claims["typ"].(string)
+
+	return typ == "" && claims["role"] == RoleAdmin
+}
+
+// HasScopes reports whether the token was granted every one of the scopes
+func HasScopes(claims jwt.MapClaims, scopes ...string) bool {
+	scope, _ := claims["scope"].(string)
+	granted := make(map[string]struct{})
+	for _, s := range strings.Fields(scope) {
+		granted[s] = struct{}{}
+	}
+
+	for _, s := range scopes {
+		if _, ok := granted[s]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RequireScopes limits scoped principals, services and API keys, to the routes their scopes cover.
+// User tokens are not scoped and pass. It doesn't authenticate, that is left to JWTMiddleware or AuthMiddleware
+func RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ := ClaimsFromContext(r.Context())
+			scoped := IsServiceToken(claims) || claims["typ"] == TokenTypeAPIKey
+			if scoped && !HasScopes(claims, scopes...) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireServiceScopes only lets service tokens with all the scopes through, it must run after JWTMiddleware
+func RequireServiceScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !IsServiceToken(claims) || !HasScopes(claims, scopes...) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -0,0 +1,148 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func signedToken(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(key)
+	assert.NoError(t, err)
+
+	return token
+}
+
+func serve(handler http.Handler, token string) int {
+	req := httptest.NewRequest(http.MethodGet, "/internal/bidders", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	return w.Code
+}
+
+func TestRequireServiceScopes(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := JWTMiddleware(&key.PublicKey, nil)(RequireServiceScopes("bidders:read")(ok))
+
+	tests := []struct {
+		name     string
+		token    string
+		expected int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"service token with scope", signedToken(t, key, jwt.MapClaims{"sub": "auction-service", "azp": "auction-service", "typ": TokenTypeService, "scope": "auctions:read bidders:read"}), http.StatusOK},
+		{"service token without scope", signedToken(t, key, jwt.MapClaims{"sub": "auction-service", "azp": "auction-service", "typ": TokenTypeService, "scope": "auctions:read"}), http.StatusForbidden},
+		{"user token", signedToken(t, key, jwt.MapClaims{"sub": "user-id", "scope": "bidders:read"}), http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, serve(handler, test.token), test.name)
+	}
+}
+
+func TestJWTMiddleware_RejectsOtherAlgorithms(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	handler := JWTMiddleware(&key.PublicKey, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user-id"}).SignedString([]byte("secret"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, token))
+}
+
+func TestJWTMiddleware_RejectsOtherTokenTypes(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	handler := JWTMiddleware(&key.PublicKey, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		expected int
+	}{
+		{"user token", jwt.MapClaims{"sub": "user-id"}, http.StatusOK},
+		{"service token", jwt.MapClaims{"sub": "auction-service", "typ": TokenTypeService}, http.StatusOK},
+		{"mfa pending token", jwt.MapClaims{"sub": "user-id", "typ": "mfa_pending"}, http.StatusUnauthorized},
+		{"magic link token", jwt.MapClaims{"sub": "user-id", "typ": "magic_link"}, http.StatusUnauthorized},
+		{"api key principal", jwt.MapClaims{"sub": "user-id", "typ": TokenTypeAPIKey, "scope": "auctions:write"}, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, serve(handler, signedToken(t, key, test.claims)), test.name)
+	}
+}
+
+type recordedImpersonations struct {
+	entries []ImpersonationEntry
+}
+
+func (r *recordedImpersonations) RecordImpersonation(ctx context.Context, entry ImpersonationEntry) {
+	r.entries = append(r.entries, entry)
+}
+
+func TestImpersonation(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	recorder := &recordedImpersonations{}
+	var identities Identities
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identities, _ = IdentitiesFromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	})
+	audited := func(h http.Handler) http.Handler {
+		return JWTMiddleware(&key.PublicKey, nil)(AuditImpersonation(recorder)(h))
+	}
+
+	impersonating := signedToken(t, key, jwt.MapClaims{"sub": "user-id", ActorClaim: map[string]interface{}{"sub": "admin-id"}})
+	user := signedToken(t, key, jwt.MapClaims{"sub": "user-id"})
+
+	assert.Equal(t, http.StatusNoContent, serve(audited(ok), impersonating))
+	assert.Equal(t, Identities{Subject: "user-id", Actor: "admin-id"}, identities)
+	assert.Equal(t, http.StatusForbidden, serve(audited(DenyImpersonation()(ok)), impersonating))
+	assert.Equal(t, http.StatusNoContent, serve(audited(DenyImpersonation()(ok)), user))
+	assert.Equal(t, Identities{Subject: "user-id"}, identities)
+
+	// the user's own request isn't audited, the refused one is
+	assert.Len(t, recorder.entries, 2)
+	assert.Equal(t, "admin-id", recorder.entries[1].Actor)
+	assert.Equal(t, http.StatusForbidden, recorder.entries[1].Status)
+	assert.Equal(t, "/internal/bidders", recorder.entries[1].Path)
+}
+
+func TestOrganizationOf(t *testing.T) {
+	org, ok := OrganizationOf(jwt.MapClaims{"sub": "user-id", OrgClaim: "org-id", OrgRoleClaim: OrgRoleManager})
+	assert.True(t, ok)
+	assert.Equal(t, Organization{ID: "org-id", Role: OrgRoleManager}, org)
+	assert.True(t, org.CanManageListings())
+
+	assert.False(t, Organization{ID: "org-id", Role: OrgRoleViewer}.CanManageListings())
+
+	_, ok = OrganizationOf(jwt.MapClaims{"sub": "user-id"})
+	assert.False(t, ok)
+}
@@ -0,0 +1,152 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ActorClaim names who is really behind a token when an admin impersonates a user (RFC 8693 section 4.1)
+const ActorClaim = "act"
+
+// Identities are both sides of a request, Actor is empty unless someone is impersonating Subject
+type Identities struct {
+	Subject string
+	Actor   string
+}
+
+func (i Identities) Impersonated() bool {
+
+	return i.Actor != ""
+}
+
+// Actor returns the id of the admin acting as the subject of the claims
+func Actor(claims jwt.MapClaims) (string, bool) {
+	act, ok := claims[ActorClaim].(map[string]interface{})
+
+	if !ok {
+		return "", false
+	}
+
+	actor, _ := act["sub"].(string)
+
+	return actor, actor != ""
+}
+
+// IdentitiesFromContext returns who the request acts as and, when impersonating, who is acting
+func IdentitiesFromContext(ctx context.Context) (Identities, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+
+	if !ok {
+		return Identities{}, false
+	}
+
+	identities := Identities{}
+	identities.Subject, _ = claims["sub"].(string)
+	identities.Actor, _ = Actor(claims)
+
+	return identities, true
+}
+
+// DenyImpersonation keeps impersonating admins away from destructive routes, it must run after AuthMiddleware
+func DenyImpersonation() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if identities, _ := IdentitiesFromContext(r.Context()); identities.Impersonated() {
+				http.Error(w, "Forbidden while impersonating", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ImpersonationEntry is one request made while impersonating
+type ImpersonationEntry struct {
+	// Service is the service the request was made to, empty for auth-service itself
+	Service string    `json:"service,omitempty"`
+	Actor   string    `json:"actor"`
+	Subject string    `json:"subject"`
+	Method  string    `json:"method"`
+	Path    string    `json:"path"`
+	Status  int       `json:"status,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+type ImpersonationRecorder interface {
+	RecordImpersonation(ctx context.Context, entry ImpersonationEntry)
+}
+
+// LogImpersonationRecorder writes every entry as a JSON line to the logger
+type LogImpersonationRecorder struct {
+	logger *log.Logger
+}
+
+// NewLogImpersonationRecorder uses the standard logger when logger is nil
+func NewLogImpersonationRecorder(logger *log.Logger) *LogImpersonationRecorder {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return &LogImpersonationRecorder{logger: logger}
+}
+
+func (l *LogImpersonationRecorder) RecordImpersonation(ctx context.Context, entry ImpersonationEntry) {
+	line, err := json.Marshal(entry)
+
+	if err != nil {
+		l.logger.Printf("impersonation audit failed encoding entry: %v", err)
+		return
+	}
+
+	l.logger.Printf("impersonation %s", line)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streamed responses, like server-sent events, through
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// AuditImpersonation records every request made with an impersonation token, including the refused ones.
+// It must run after AuthMiddleware
+func AuditImpersonation(recorder ImpersonationRecorder) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identities, _ := IdentitiesFromContext(r.Context())
+
+			if !identities.Impersonated() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			recorded := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(recorded, r)
+
+			recorder.RecordImpersonation(r.Context(), ImpersonationEntry{
+				Actor:   identities.Actor,
+				Subject: identities.Subject,
+				Method:  r.Method,
+				Path:    r.URL.Path,
+				Status:  recorded.status,
+				At:      time.Now().UTC(),
+			})
+		})
+	}
+}
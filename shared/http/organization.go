@@ -0,0 +1,56 @@
+package http
+
+import (
+	"context"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OrgClaim is the organization a token acts for, OrgRoleClaim is the member's role in it
+const (
+	OrgClaim     = "org"
+	OrgRoleClaim = "org_role"
+)
+
+// roles of an organization member, owners also manage the members
+const (
+	OrgRoleOwner   = "owner"
+	OrgRoleManager = "manager"
+	OrgRoleViewer  = "viewer"
+)
+
+// Organization is the active organization of a token, sellers are organizations
+type Organization struct {
+	ID   string
+	Role string
+}
+
+// CanManageListings tells if the member may create and change the organization's auctions
+func (o Organization) CanManageListings() bool {
+
+	return o.Role == OrgRoleOwner || o.Role == OrgRoleManager
+}
+
+// OrganizationOf returns the active organization of the claims
+func OrganizationOf(claims jwt.MapClaims) (Organization, bool) {
+	id, _ := claims[OrgClaim].(string)
+
+	if id == "" {
+		return Organization{}, false
+	}
+
+	role, _ := claims[OrgRoleClaim].(string)
+
+	return Organization{ID: id, Role: role}, true
+}
+
+// OrganizationFromContext returns the organization the request acts for, it must run after AuthMiddleware
+func OrganizationFromContext(ctx context.Context) (Organization, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+
+	if !ok {
+		return Organization{}, false
+	}
+
+	return OrganizationOf(claims)
+}
@@ -0,0 +1,586 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ireuven89/auctions/shared/config"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	DefaultExchange       = "auctions.events"
+	DefaultPrefetch       = 10
+	DefaultConfirmTimeout = 5 * time.Second
+
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+
+	// versionHeader mirrors the envelope version so bindings and tooling can see it without the body
+	versionHeader = "version"
+)
+
+// AMQPBroker publishes to and consumes from a RabbitMQ topic exchange. A lost connection is dialed again
+// in the background, publishes fail until it is back and subscriptions pick up where they left off
+type AMQPBroker struct {
+	cfg config.RabbitConfig
+
+	mu     sync.Mutex
+	conn   *amqp.Connection
+	ready  chan struct{}
+	closed bool
+	done   chan struct{}
+
+	// publishes share one channel in confirm mode, one at a time so every confirm matches its publish
+	publishMu sync.Mutex
+	publishCh *amqp.Channel
+}
+
+// DialAMQP connects to the broker and declares the exchange, the first connection has to succeed
+func DialAMQP(cfg config.RabbitConfig) (*AMQPBroker, error) {
+	if cfg.Exchange == "" {
+		cfg.Exchange = DefaultExchange
+	}
+
+	if cfg.Prefetch <= 0 {
+		cfg.Prefetch = DefaultPrefetch
+	}
+
+	if cfg.ConfirmTimeout <= 0 {
+		cfg.ConfirmTimeout = DefaultConfirmTimeout
+	}
+
+	b := &AMQPBroker{cfg: cfg, ready: make(chan struct{}), done: make(chan struct{})}
+	conn, err := b.dial()
+
+	if err != nil {
+		return nil, fmt.Errorf("DialAMQP %w", err)
+	}
+
+	b.connected(conn)
+
+	return b, nil
+}
+
+func (b *AMQPBroker) dial() (*amqp.Connection, error) {
+	conn, err := amqp.Dial(b.cfg.URL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer ch.Close()
+
+	if err = ch.ExchangeDeclare(b.cfg.Exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed declaring exchange %s %w", b.cfg.Exchange, err)
+	}
+
+	return conn, nil
+}
+
+func (b *AMQPBroker) connected(conn *amqp.Connection) {
+	b.mu.Lock()
+	b.conn = conn
+	close(b.ready)
+	b.mu.Unlock()
+
+	go b.watch(conn.NotifyClose(make(chan *amqp.Error, 1)))
+}
+
+// watch dials again with a growing delay when the connection is lost
+func (b *AMQPBroker) watch(closed chan *amqp.Error) {
+	reason := <-closed
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.ready = make(chan struct{})
+	b.mu.Unlock()
+
+	log.Printf("messaging: connection lost %v, reconnecting", reason)
+
+	delay := minReconnectDelay
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-time.After(delay):
+		}
+
+		conn, err := b.dial()
+
+		if err == nil {
+			log.Printf("messaging: reconnected")
+			b.connected(conn)
+			return
+		}
+
+		log.Printf("messaging: reconnect failed %v", err)
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// connection waits for the broker to be connected
+func (b *AMQPBroker) connection(ctx context.Context) (*amqp.Connection, error) {
+	for {
+		b.mu.Lock()
+		conn, ready, closed := b.conn, b.ready, b.closed
+		b.mu.Unlock()
+
+		if closed {
+			return nil, ErrClosed
+		}
+
+		select {
+		case <-ready:
+			if !conn.IsClosed() {
+				return conn, nil
+			}
+			// the close notification is on its way, wait for the new connection
+			select {
+			case <-time.After(minReconnectDelay / 10):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-b.done:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// Publish sends the envelope persistent and waits for the broker to confirm it
+func (b *AMQPBroker) Publish(ctx context.Context, topic string, envelope Envelope) error {
+	publishing, err := newPublishing(envelope, amqp.Table{})
+
+	if err != nil {
+		return fmt.Errorf("AMQPBroker.Publish %w", err)
+	}
+
+	if err = b.publish(ctx, b.cfg.Exchange, topic, publishing, nil); err != nil {
+		return fmt.Errorf("AMQPBroker.Publish %s %w", envelope.Type, err)
+	}
+
+	return nil
+}
+
+// Retry publishes the message to a delay queue of the queue, a queue per delay whose messages expire
+// back into the queue
+func (b *AMQPBroker) Retry(ctx context.Context, queue string, msg Message, attempt int, delay time.Duration) error {
+	retryQueue := fmt.Sprintf("%s.retry.%d", queue, delay.Milliseconds())
+	publishing, err := newPublishing(msg.Envelope, amqp.Table{attemptHeader: int32(attempt), topicHeader: msg.Topic})
+
+	if err != nil {
+		return fmt.Errorf("AMQPBroker.Retry %w", err)
+	}
+
+	err = b.publish(ctx, "", retryQueue, publishing, func(ch *amqp.Channel) error {
+		_, err := ch.QueueDeclare(retryQueue, true, false, false, false, amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		})
+
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("AMQPBroker.Retry %s to %s %w", msg.ID, retryQueue, err)
+	}
+
+	return nil
+}
+
+// DeadLetter publishes the message to the dead letter queue of its queue, the failure goes in the headers
+func (b *AMQPBroker) DeadLetter(ctx context.Context, letter DeadLetter) error {
+	dlq := DeadLetterQueue(letter.Queue)
+	publishing, err := newPublishing(letter.Envelope, amqp.Table{
+		attemptHeader:  int32(letter.Attempts),
+		topicHeader:    letter.Topic,
+		queueHeader:    letter.Queue,
+		errorHeader:    letter.Error,
+		failedAtHeader: letter.FailedAt,
+	})
+
+	if err != nil {
+		return fmt.Errorf("AMQPBroker.DeadLetter %w", err)
+	}
+
+	err = b.publish(ctx, "", dlq, publishing, func(ch *amqp.Channel) error {
+		_, err := ch.QueueDeclare(dlq, true, false, false, false, nil)
+
+		return err
+	})
+
+	if err != nil {
+		return fmt.Errorf("AMQPBroker.DeadLetter %s to %s %w", letter.ID, dlq, err)
+	}
+
+	return nil
+}
+
+// publish sends on the confirm channel and waits for the confirm, declare runs on the channel before
+func (b *AMQPBroker) publish(ctx context.Context, exchange, key string, publishing amqp.Publishing, declare func(ch *amqp.Channel) error) error {
+	b.publishMu.Lock()
+	defer b.publishMu.Unlock()
+
+	ch, err := b.publishChannel(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if declare != nil {
+		if err = declare(ch); err != nil {
+			// a failed declare closes the channel
+			b.dropPublishChannel()
+			return err
+		}
+	}
+
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, false, false, publishing)
+
+	if err != nil {
+		b.dropPublishChannel()
+		return err
+	}
+
+	confirmCtx, cancel := context.WithTimeout(ctx, b.cfg.ConfirmTimeout)
+	defer cancel()
+
+	acked, err := confirmation.WaitContext(confirmCtx)
+
+	if err != nil {
+		// the confirm may still come on this channel and would be matched with the next publish
+		b.dropPublishChannel()
+		return err
+	}
+
+	if !acked {
+		return ErrNotConfirmed
+	}
+
+	return nil
+}
+
+func newPublishing(envelope Envelope, headers amqp.Table) (amqp.Publishing, error) {
+	body, err := json.Marshal(envelope)
+
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+
+	headers[versionHeader] = int32(envelope.Version)
+
+	return amqp.Publishing{
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     envelope.ID,
+		Type:          envelope.Type,
+		Timestamp:     envelope.OccurredAt,
+		CorrelationId: envelope.CorrelationID,
+		Headers:       headers,
+		Body:          body,
+	}, nil
+}
+
+func (b *AMQPBroker) publishChannel(ctx context.Context) (*amqp.Channel, error) {
+	if b.publishCh != nil && !b.publishCh.IsClosed() {
+		return b.publishCh, nil
+	}
+
+	conn, err := b.connection(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed enabling confirms %w", err)
+	}
+
+	b.publishCh = ch
+
+	return ch, nil
+}
+
+func (b *AMQPBroker) dropPublishChannel() {
+	if b.publishCh != nil {
+		b.publishCh.Close()
+		b.publishCh = nil
+	}
+}
+
+// Subscribe declares the durable queue, binds it to the topics and hands the messages to the handler
+// one at a time until the context is done. Messages are acknowledged once the handler returns
+func (b *AMQPBroker) Subscribe(ctx context.Context, subscription Subscription, handler Handler) error {
+	for {
+		err := b.consume(ctx, subscription, handler)
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if errors.Is(err, ErrClosed) {
+			return err
+		}
+
+		log.Printf("messaging: subscription %s interrupted %v", subscription.Queue, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(minReconnectDelay):
+		}
+	}
+}
+
+func (b *AMQPBroker) consume(ctx context.Context, subscription Subscription, handler Handler) error {
+	conn, err := b.connection(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	prefetch := subscription.Prefetch
+	if prefetch <= 0 {
+		prefetch = b.cfg.Prefetch
+	}
+
+	if err = ch.Qos(prefetch, 0, false); err != nil {
+		return fmt.Errorf("failed setting prefetch %w", err)
+	}
+
+	if _, err = ch.QueueDeclare(subscription.Queue, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("failed declaring queue %s %w", subscription.Queue, err)
+	}
+
+	for _, topic := range subscription.Topics {
+		if err = ch.QueueBind(subscription.Queue, topic, b.cfg.Exchange, false, nil); err != nil {
+			return fmt.Errorf("failed binding %s to %s %w", subscription.Queue, topic, err)
+		}
+	}
+
+	deliveries, err := ch.ConsumeWithContext(ctx, subscription.Queue, "", false, false, false, false, nil)
+
+	if err != nil {
+		return fmt.Errorf("failed consuming %s %w", subscription.Queue, err)
+	}
+
+	for delivery := range deliveries {
+		if err = b.handle(ctx, delivery, handler); err != nil {
+			return err
+		}
+	}
+
+	return errors.New("deliveries closed")
+}
+
+// handle acknowledges the delivery by the outcome of the handler, bodies that are not envelopes are rejected
+func (b *AMQPBroker) handle(ctx context.Context, delivery amqp.Delivery, handler Handler) error {
+	msg := Message{Topic: delivery.RoutingKey, Redelivered: delivery.Redelivered, Headers: delivery.Headers}
+
+	// retried and replayed messages are routed by the queue name
+	if topic, ok := delivery.Headers[topicHeader].(string); ok {
+		msg.Topic = topic
+	}
+
+	if err := json.Unmarshal(delivery.Body, &msg.Envelope); err != nil {
+		log.Printf("messaging: rejecting malformed message %s on %s %v", delivery.MessageId, delivery.RoutingKey, err)
+		return delivery.Reject(false)
+	}
+
+	if err := handler(ctx, msg); err != nil {
+		permanent := IsPermanent(err)
+		log.Printf("messaging: handling %s %s failed (requeue %t) %v", msg.Type, msg.ID, !permanent, err)
+
+		return delivery.Nack(false, !permanent)
+	}
+
+	return delivery.Ack(false)
+}
+
+// Inspect fetches up to limit dead letters of the queue, they go back to the dead letter queue when the channel closes
+func (b *AMQPBroker) Inspect(ctx context.Context, queue string, limit int) ([]DeadLetter, error) {
+	ch, err := b.channel(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("AMQPBroker.Inspect %w", err)
+	}
+	defer ch.Close()
+
+	var letters []DeadLetter
+
+	for limit <= 0 || len(letters) < limit {
+		delivery, ok, err := ch.Get(DeadLetterQueue(queue), false)
+
+		if err != nil {
+			return nil, fmt.Errorf("AMQPBroker.Inspect %w", err)
+		}
+
+		if !ok {
+			break
+		}
+
+		letters = append(letters, deadLetter(queue, delivery))
+	}
+
+	return letters, nil
+}
+
+// Replay moves the selected dead letters back to the queue as a first attempt. Every dead letter is
+// fetched once, those not replayed are held until the channel closes and go back to the dead letter queue
+func (b *AMQPBroker) Replay(ctx context.Context, queue string, ids []string) (int, error) {
+	ch, err := b.channel(ctx)
+
+	if err != nil {
+		return 0, fmt.Errorf("AMQPBroker.Replay %w", err)
+	}
+	defer ch.Close()
+
+	if err = ch.Confirm(false); err != nil {
+		return 0, fmt.Errorf("AMQPBroker.Replay %w", err)
+	}
+
+	dlq, err := ch.QueueDeclarePassive(DeadLetterQueue(queue), true, false, false, false, nil)
+
+	if err != nil {
+		return 0, fmt.Errorf("AMQPBroker.Replay %w", err)
+	}
+
+	replayed := 0
+
+	for i := 0; i < dlq.Messages; i++ {
+		delivery, ok, err := ch.Get(dlq.Name, false)
+
+		if err != nil {
+			return replayed, fmt.Errorf("AMQPBroker.Replay %w", err)
+		}
+
+		if !ok {
+			break
+		}
+
+		if !selected(delivery.MessageId, ids) {
+			continue
+		}
+
+		// the body is sent as it is, whatever the handler could not make of it
+		confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false, amqp.Publishing{
+			ContentType:   delivery.ContentType,
+			DeliveryMode:  amqp.Persistent,
+			MessageId:     delivery.MessageId,
+			Type:          delivery.Type,
+			Timestamp:     delivery.Timestamp,
+			CorrelationId: delivery.CorrelationId,
+			Headers:       amqp.Table{versionHeader: delivery.Headers[versionHeader], topicHeader: delivery.Headers[topicHeader]},
+			Body:          delivery.Body,
+		})
+
+		if err != nil {
+			return replayed, fmt.Errorf("AMQPBroker.Replay %s %w", delivery.MessageId, err)
+		}
+
+		if acked, err := confirmation.WaitContext(ctx); err != nil || !acked {
+			return replayed, fmt.Errorf("AMQPBroker.Replay %s %w", delivery.MessageId, errors.Join(ErrNotConfirmed, err))
+		}
+
+		if err = delivery.Ack(false); err != nil {
+			return replayed, fmt.Errorf("AMQPBroker.Replay %s %w", delivery.MessageId, err)
+		}
+
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+func (b *AMQPBroker) Purge(ctx context.Context, queue string) (int, error) {
+	ch, err := b.channel(ctx)
+
+	if err != nil {
+		return 0, fmt.Errorf("AMQPBroker.Purge %w", err)
+	}
+	defer ch.Close()
+
+	purged, err := ch.QueuePurge(DeadLetterQueue(queue), false)
+
+	if err != nil {
+		return 0, fmt.Errorf("AMQPBroker.Purge %w", err)
+	}
+
+	return purged, nil
+}
+
+func (b *AMQPBroker) channel(ctx context.Context) (*amqp.Channel, error) {
+	conn, err := b.connection(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return conn.Channel()
+}
+
+// deadLetter reads the failure from the headers DeadLetter wrote
+func deadLetter(queue string, delivery amqp.Delivery) DeadLetter {
+	letter := DeadLetter{Message: Message{Headers: delivery.Headers}, Queue: queue}
+
+	if err := json.Unmarshal(delivery.Body, &letter.Envelope); err != nil {
+		letter.ID, letter.Type = delivery.MessageId, delivery.Type
+	}
+
+	letter.Topic, _ = delivery.Headers[topicHeader].(string)
+	letter.Error, _ = delivery.Headers[errorHeader].(string)
+	letter.FailedAt, _ = delivery.Headers[failedAtHeader].(time.Time)
+	letter.Attempts = Attempt(letter.Message)
+
+	return letter
+}
+
+func (b *AMQPBroker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	conn := b.conn
+	b.mu.Unlock()
+
+	b.publishMu.Lock()
+	b.dropPublishChannel()
+	b.publishMu.Unlock()
+
+	return conn.Close()
+}
@@ -0,0 +1,204 @@
+package messaging
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// attemptHeader counts the deliveries of a message to its queue, the first is attempt 1
+	attemptHeader = "attempt"
+	// topicHeader keeps the topic of a message sent back to its queue directly, the routing key is the queue then
+	topicHeader = "topic"
+	// the failure of a dead letter
+	errorHeader    = "error"
+	failedAtHeader = "failed-at"
+	queueHeader    = "queue"
+)
+
+// RetryPolicy decides how often a failing message is tried and how long apart, the delay grows
+// exponentially from InitialDelay up to MaxDelay
+type RetryPolicy struct {
+	// MaxAttempts includes the first one, a message still failing after it is dead-lettered
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+}
+
+// DefaultRetryPolicy tries a message five times over about a minute and a half
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, InitialDelay: time.Second, MaxDelay: 5 * time.Minute, Multiplier: 4}
+
+// Delay is the wait before the attempt after the given one
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	delay := p.InitialDelay
+
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay = time.Duration(float64(delay) * p.Multiplier)
+	}
+
+	return min(delay, p.MaxDelay)
+}
+
+// DeadLetter is a message its handler gave up on and why
+type DeadLetter struct {
+	Message
+	// Queue is the queue the message failed on, it is replayed to it
+	Queue    string
+	Error    string
+	Attempts int
+	FailedAt time.Time
+}
+
+// Retrier is what the consumer needs from a broker beyond subscribing
+type Retrier interface {
+	// Retry sends the message back to the queue after the delay, to be delivered as the given attempt
+	Retry(ctx context.Context, queue string, msg Message, attempt int, delay time.Duration) error
+	// DeadLetter sets the message aside in the dead letter queue of its queue
+	DeadLetter(ctx context.Context, letter DeadLetter) error
+}
+
+// DeadLetters manages the dead letter queues, see cmd/deadletters
+type DeadLetters interface {
+	// Inspect returns up to limit dead letters of the queue, they stay in the dead letter queue
+	Inspect(ctx context.Context, queue string, limit int) ([]DeadLetter, error)
+	// Replay sends the dead letters with the given message IDs back to the queue, all of them when ids is empty
+	Replay(ctx context.Context, queue string, ids []string) (int, error)
+	// Purge drops the dead letters of the queue
+	Purge(ctx context.Context, queue string) (int, error)
+}
+
+// DeadLetterQueue is the name of the dead letter queue of a queue
+func DeadLetterQueue(queue string) string {
+
+	return queue + ".dlq"
+}
+
+// Attempt is the delivery attempt of the message to its queue, starting at 1
+func Attempt(msg Message) int {
+	switch attempt := msg.Headers[attemptHeader].(type) {
+	case int:
+		return attempt
+	case int32:
+		return int(attempt)
+	case int64:
+		return int(attempt)
+	default:
+		return 1
+	}
+}
+
+// ConsumerBroker is a broker the consumer can run on
+type ConsumerBroker interface {
+	Subscriber
+	Retrier
+}
+
+type consumerHandler struct {
+	subscription Subscription
+	policy       RetryPolicy
+	handler      Handler
+}
+
+// Consumer runs handlers on their subscriptions. A failed message is not requeued right away:
+// it is tried again after the delay of the retry policy of the handler, and dead-lettered when it fails
+// permanently or runs out of attempts. With a processed store every message is handled once per queue
+type Consumer struct {
+	broker    ConsumerBroker
+	processed ProcessedStore
+	handlers  []consumerHandler
+}
+
+// NewConsumer returns a consumer on the broker, processed may be nil when the handlers are idempotent themselves
+func NewConsumer(broker ConsumerBroker, processed ProcessedStore) *Consumer {
+
+	return &Consumer{broker: broker, processed: processed}
+}
+
+// Handle registers the handler, call it before Run
+func (c *Consumer) Handle(subscription Subscription, policy RetryPolicy, handler Handler) {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+
+	c.handlers = append(c.handlers, consumerHandler{subscription: subscription, policy: policy, handler: handler})
+}
+
+// Run consumes every subscription until the context is done or one of them stops, and returns why
+func (c *Consumer) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var result error
+
+	for _, h := range c.handlers {
+		wg.Add(1)
+
+		go func(h consumerHandler) {
+			defer wg.Done()
+
+			err := c.broker.Subscribe(ctx, h.subscription, c.wrap(h))
+			once.Do(func() {
+				result = err
+				cancel()
+			})
+		}(h)
+	}
+
+	wg.Wait()
+
+	return result
+}
+
+// wrap acknowledges the message once it was handled, retried or dead-lettered, only when the broker
+// could not take it back is the error returned and the message requeued
+func (c *Consumer) wrap(h consumerHandler) Handler {
+	queue := h.subscription.Queue
+
+	return func(ctx context.Context, msg Message) error {
+		err := c.handle(ctx, queue, h.handler, msg)
+
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		attempt := Attempt(msg)
+
+		if !IsPermanent(err) && attempt < h.policy.MaxAttempts {
+			delay := h.policy.Delay(attempt)
+			log.Printf("messaging: %s %s failed on %s attempt %d, retrying in %s %v", msg.Type, msg.ID, queue, attempt, delay, err)
+
+			return c.broker.Retry(ctx, queue, msg, attempt+1, delay)
+		}
+
+		log.Printf("messaging: %s %s failed on %s attempt %d, dead-lettering %v", msg.Type, msg.ID, queue, attempt, err)
+
+		return c.broker.DeadLetter(ctx, DeadLetter{
+			Message:  msg,
+			Queue:    queue,
+			Error:    err.Error(),
+			Attempts: attempt,
+			FailedAt: time.Now().UTC(),
+		})
+	}
+}
+
+func (c *Consumer) handle(ctx context.Context, queue string, handler Handler, msg Message) error {
+	if c.processed == nil {
+		return handler(ctx, msg)
+	}
+
+	handled, err := c.processed.Once(ctx, queue, msg.ID, func(ctx context.Context) error {
+		return handler(ctx, msg)
+	})
+
+	if err == nil && !handled {
+		log.Printf("messaging: %s %s already processed on %s", msg.Type, msg.ID, queue)
+	}
+
+	return err
+}
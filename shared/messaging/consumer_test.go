@@ -0,0 +1,138 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, InitialDelay: time.Second, MaxDelay: 30 * time.Second, Multiplier: 4}
+
+	assert.Equal(t, time.Second, policy.Delay(1))
+	assert.Equal(t, 4*time.Second, policy.Delay(2))
+	assert.Equal(t, 16*time.Second, policy.Delay(3))
+	assert.Equal(t, 30*time.Second, policy.Delay(4))
+	assert.Equal(t, 30*time.Second, policy.Delay(10))
+}
+
+func TestConsumer(t *testing.T) {
+	broker := NewMemoryBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 2}
+
+	var mu sync.Mutex
+	attempts := map[string][]int{}
+	failing := map[string]error{"flaky": errors.New("temporary"), "broken": errors.New("db down"), "malformed": Permanent(errors.New("malformed"))}
+
+	consumer := NewConsumer(broker, nil)
+	consumer.Handle(Subscription{Queue: "bidders", Topics: []string{"user.*"}}, policy, func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		attempts[msg.Type] = append(attempts[msg.Type], Attempt(msg))
+		if msg.Type == "flaky" && len(attempts[msg.Type]) > 1 {
+			return nil
+		}
+
+		return failing[msg.Type]
+	})
+
+	done := make(chan error)
+	go func() { done <- consumer.Run(ctx) }()
+	waitForQueue(t, broker, "bidders")
+
+	for _, eventType := range []string{"flaky", "broken", "malformed"} {
+		envelope, _ := NewEnvelope(ctx, eventType, 1, nil)
+		assert.NoError(t, broker.Publish(ctx, "user.registered", envelope))
+	}
+
+	assert.Eventually(t, func() bool {
+		letters, _ := broker.Inspect(ctx, "bidders", 0)
+		return len(letters) == 2
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []int{1, 2}, attempts["flaky"])
+	assert.Equal(t, []int{1, 2, 3}, attempts["broken"])
+	assert.Equal(t, []int{1}, attempts["malformed"])
+	mu.Unlock()
+
+	letters, err := broker.Inspect(ctx, "bidders", 0)
+	assert.NoError(t, err)
+	byType := map[string]DeadLetter{}
+	for _, letter := range letters {
+		byType[letter.Type] = letter
+	}
+	assert.Equal(t, 3, byType["broken"].Attempts)
+	assert.Equal(t, "db down", byType["broken"].Error)
+	assert.Equal(t, "user.registered", byType["broken"].Topic)
+	assert.Equal(t, "bidders", byType["broken"].Queue)
+	assert.False(t, byType["broken"].FailedAt.IsZero())
+	assert.Equal(t, 1, byType["malformed"].Attempts)
+
+	// a replayed dead letter starts over
+	replayed, err := broker.Replay(ctx, "bidders", []string{byType["broken"].ID})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+	assert.Eventually(t, func() bool {
+		letters, _ := broker.Inspect(ctx, "bidders", 0)
+		return len(letters) == 2
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, []int{1, 2, 3, 1, 2, 3}, attempts["broken"])
+	mu.Unlock()
+
+	purged, err := broker.Purge(ctx, "bidders")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestConsumer_Processed(t *testing.T) {
+	broker := NewMemoryBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handled := make(chan Message, 10)
+	failed := false
+
+	consumer := NewConsumer(broker, NewMemoryProcessedStore())
+	consumer.Handle(Subscription{Queue: "bidders", Topics: []string{"user.*"}}, RetryPolicy{MaxAttempts: 2}, func(ctx context.Context, msg Message) error {
+		if !failed {
+			failed = true
+			return errors.New("temporary")
+		}
+
+		handled <- msg
+		return nil
+	})
+	go consumer.Run(ctx)
+	waitForQueue(t, broker, "bidders")
+
+	envelope, _ := NewEnvelope(ctx, "UserRegistered", 1, nil)
+	assert.NoError(t, broker.Publish(ctx, "user.registered", envelope))
+	assert.NoError(t, broker.Publish(ctx, "user.registered", envelope))
+
+	select {
+	case msg := <-handled:
+		assert.Equal(t, envelope.ID, msg.ID)
+	case <-time.After(time.Second):
+		t.Fatal("message was not handled")
+	}
+
+	select {
+	case <-handled:
+		t.Fatal("message was handled twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
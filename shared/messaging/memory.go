@@ -0,0 +1,298 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryBroker is an in-process broker with the AMQP topic semantics: messages go to every queue bound
+// to a matching topic, and are kept in the queue until a subscriber acknowledges them
+type MemoryBroker struct {
+	mu        sync.Mutex
+	queues    map[string]*memoryQueue
+	published []Message
+	rejected  []Message
+	// dead letters by the queue they failed on
+	deadLetters map[string][]DeadLetter
+	closed      bool
+}
+
+type memoryQueue struct {
+	topics   []string
+	messages []Message
+	ready    chan struct{}
+}
+
+func NewMemoryBroker() *MemoryBroker {
+
+	return &MemoryBroker{queues: map[string]*memoryQueue{}, deadLetters: map[string][]DeadLetter{}}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, topic string, envelope Envelope) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	msg := Message{Envelope: envelope, Topic: topic}
+	b.published = append(b.published, msg)
+
+	for _, queue := range b.queues {
+		if queue.bound(topic) {
+			queue.push(msg)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe declares the queue like AMQP does, messages published before are only there if the queue already was
+func (b *MemoryBroker) Subscribe(ctx context.Context, subscription Subscription, handler Handler) error {
+	b.mu.Lock()
+
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+
+	queue, ok := b.queues[subscription.Queue]
+	if !ok {
+		queue = &memoryQueue{ready: make(chan struct{}, 1)}
+		b.queues[subscription.Queue] = queue
+	}
+	queue.topics = append(queue.topics, subscription.Topics...)
+	b.mu.Unlock()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-queue.ready:
+		}
+
+		for {
+			msg, ok := b.pop(queue)
+
+			if !ok {
+				break
+			}
+
+			if err := handler(ctx, msg); err != nil {
+				b.nack(queue, msg, err)
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// Retry pushes the message back to the queue once the delay passed
+func (b *MemoryBroker) Retry(ctx context.Context, queue string, msg Message, attempt int, delay time.Duration) error {
+	msg = resent(msg, attempt)
+
+	time.AfterFunc(delay, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if q, ok := b.queues[queue]; ok && !b.closed {
+			q.push(msg)
+		}
+	})
+
+	return nil
+}
+
+func (b *MemoryBroker) DeadLetter(ctx context.Context, letter DeadLetter) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	b.deadLetters[letter.Queue] = append(b.deadLetters[letter.Queue], letter)
+
+	return nil
+}
+
+func (b *MemoryBroker) Inspect(ctx context.Context, queue string, limit int) ([]DeadLetter, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	letters := b.deadLetters[queue]
+	if limit > 0 && len(letters) > limit {
+		letters = letters[:limit]
+	}
+
+	return append([]DeadLetter(nil), letters...), nil
+}
+
+func (b *MemoryBroker) Replay(ctx context.Context, queue string, ids []string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[queue]
+	if !ok {
+		return 0, fmt.Errorf("MemoryBroker.Replay unknown queue %s", queue)
+	}
+
+	var kept []DeadLetter
+	replayed := 0
+
+	for _, letter := range b.deadLetters[queue] {
+		if !selected(letter.ID, ids) {
+			kept = append(kept, letter)
+			continue
+		}
+
+		q.push(resent(letter.Message, 1))
+		replayed++
+	}
+
+	b.deadLetters[queue] = kept
+
+	return replayed, nil
+}
+
+func (b *MemoryBroker) Purge(ctx context.Context, queue string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	purged := len(b.deadLetters[queue])
+	delete(b.deadLetters, queue)
+
+	return purged, nil
+}
+
+func (b *MemoryBroker) pop(queue *memoryQueue) (Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(queue.messages) == 0 || b.closed {
+		return Message{}, false
+	}
+
+	msg := queue.messages[0]
+	queue.messages = queue.messages[1:]
+
+	return msg, true
+}
+
+func (b *MemoryBroker) nack(queue *memoryQueue, msg Message, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if IsPermanent(err) {
+		b.rejected = append(b.rejected, msg)
+		return
+	}
+
+	msg.Redelivered = true
+	queue.push(msg)
+}
+
+// Published returns the messages published so far, for tests
+func (b *MemoryBroker) Published() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]Message(nil), b.published...)
+}
+
+// Rejected returns the messages handlers failed permanently, for tests
+func (b *MemoryBroker) Rejected() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]Message(nil), b.rejected...)
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+
+	return nil
+}
+
+// resent is the message as delivered again to its queue for the attempt
+func resent(msg Message, attempt int) Message {
+	headers := make(map[string]interface{}, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[attemptHeader] = attempt
+
+	msg.Headers = headers
+	msg.Redelivered = false
+
+	return msg
+}
+
+// selected tells whether the ID is one of ids, every ID is when there are none
+func selected(id string, ids []string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (q *memoryQueue) bound(topic string) bool {
+	for _, pattern := range q.topics {
+		if TopicMatches(pattern, topic) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (q *memoryQueue) push(msg Message) {
+	q.messages = append(q.messages, msg)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// TopicMatches matches a topic against a binding pattern, * matches one dot separated word and # any number of words
+func TopicMatches(pattern, topic string) bool {
+
+	return matchWords(strings.Split(pattern, "."), strings.Split(topic, "."))
+}
+
+func matchWords(pattern, topic []string) bool {
+	if len(pattern) == 0 {
+		return len(topic) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(topic); i++ {
+			if matchWords(pattern[1:], topic[i:]) {
+				return true
+			}
+		}
+
+		return false
+	case "*":
+		return len(topic) > 0 && matchWords(pattern[1:], topic[1:])
+	default:
+		return len(topic) > 0 && pattern[0] == topic[0] && matchWords(pattern[1:], topic[1:])
+	}
+}
@@ -0,0 +1,85 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		pattern, topic string
+		expected       bool
+	}{
+		{"auction.created", "auction.created", true},
+		{"auction.created", "auction.updated", false},
+		{"auction.*", "auction.created", true},
+		{"auction.*", "auction.bid.placed", false},
+		{"auction.#", "auction.bid.placed", true},
+		{"auction.#", "auction", true},
+		{"#", "user.registered", true},
+		{"*.registered", "user.registered", true},
+		{"#.placed", "auction.bid.placed", true},
+		{"#.placed", "auction.bid.retracted", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, TopicMatches(test.pattern, test.topic), test.pattern+" "+test.topic)
+	}
+}
+
+func TestMemoryBroker(t *testing.T) {
+	broker := NewMemoryBroker()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan Message, 10)
+	attempts := 0
+	go broker.Subscribe(ctx, Subscription{Queue: "bidders", Topics: []string{"user.*"}}, func(ctx context.Context, msg Message) error {
+		attempts++
+		switch {
+		case msg.Type == "UserDeleted":
+			return Permanent(errors.New("not supported"))
+		case attempts == 1:
+			return errors.New("temporary")
+		}
+		received <- msg
+		return nil
+	})
+	waitForQueue(t, broker, "bidders")
+
+	registered, _ := NewEnvelope(ctx, "UserRegistered", 1, map[string]string{"id": "user"})
+	deleted, _ := NewEnvelope(ctx, "UserDeleted", 1, map[string]string{"id": "user"})
+	bid, _ := NewEnvelope(ctx, "BidPlaced", 1, nil)
+	assert.NoError(t, broker.Publish(ctx, "user.registered", registered))
+	assert.NoError(t, broker.Publish(ctx, "auction.bid", bid))
+	assert.NoError(t, broker.Publish(ctx, "user.deleted", deleted))
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, registered.ID, msg.ID)
+		assert.Equal(t, "user.registered", msg.Topic)
+		assert.True(t, msg.Redelivered)
+	case <-time.After(time.Second):
+		t.Fatal("message was not redelivered")
+	}
+
+	assert.Eventually(t, func() bool { return len(broker.Rejected()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, deleted.ID, broker.Rejected()[0].ID)
+	assert.Len(t, broker.Published(), 3)
+
+	assert.NoError(t, broker.Close())
+	assert.ErrorIs(t, broker.Publish(ctx, "user.registered", registered), ErrClosed)
+}
+
+func waitForQueue(t *testing.T, broker *MemoryBroker, queue string) {
+	assert.Eventually(t, func() bool {
+		broker.mu.Lock()
+		defer broker.mu.Unlock()
+		_, ok := broker.queues[queue]
+		return ok
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,150 @@
+// Package messaging carries the events the services publish to each other. Events travel in envelopes
+// on topics, subscribers consume them from named queues bound to the topics they are interested in.
+// The AMQP implementation is used against RabbitMQ, the in-memory one in tests and broker-less runs
+package messaging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrClosed       = errors.New("messaging: broker closed")
+	ErrNotConfirmed = errors.New("messaging: publish not confirmed by the broker")
+)
+
+// Envelope is what travels on the wire, the payload is the event itself
+type Envelope struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Version int    `json:"version"`
+	// OccurredAt is when the event happened, not when it was published
+	OccurredAt time.Time `json:"occurredAt"`
+	// CorrelationID ties together the events caused by the same request
+	CorrelationID string          `json:"correlationId,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// NewEnvelope wraps the payload, the correlation ID is taken from the context
+func NewEnvelope(ctx context.Context, eventType string, version int, payload interface{}) (Envelope, error) {
+	raw, err := json.Marshal(payload)
+
+	if err != nil {
+		return Envelope{}, fmt.Errorf("NewEnvelope failed encoding %s %w", eventType, err)
+	}
+
+	return Envelope{
+		ID:            NewID(),
+		Type:          eventType,
+		Version:       version,
+		OccurredAt:    time.Now().UTC(),
+		CorrelationID: CorrelationID(ctx),
+		Payload:       raw,
+	}, nil
+}
+
+// Decode unmarshals the payload into v
+func (e Envelope) Decode(v interface{}) error {
+	if err := json.Unmarshal(e.Payload, v); err != nil {
+		return fmt.Errorf("Envelope.Decode %s %w", e.Type, err)
+	}
+
+	return nil
+}
+
+// Payload decodes the payload of the envelope as a T
+func Payload[T any](e Envelope) (T, error) {
+	var payload T
+	err := e.Decode(&payload)
+
+	return payload, err
+}
+
+// NewID returns a random identifier for envelopes
+func NewID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Message is a delivered envelope
+type Message struct {
+	Envelope
+	Topic string
+	// Redelivered is set when the message was delivered before and not acknowledged
+	Redelivered bool
+	Headers     map[string]interface{}
+}
+
+// Handler processes a message, the message is acknowledged when it returns nil and requeued when it
+// returns an error, unless the error is Permanent
+type Handler func(ctx context.Context, msg Message) error
+
+// Subscription binds a queue to topics, topics may use the AMQP wildcards, * for one word and # for any number of words
+type Subscription struct {
+	Queue  string
+	Topics []string
+	// Prefetch caps the messages handed to the subscriber before it acknowledges them, zero uses the broker default
+	Prefetch int
+}
+
+type Publisher interface {
+	// Publish returns once the broker took responsibility for the message
+	Publish(ctx context.Context, topic string, envelope Envelope) error
+	Close() error
+}
+
+type Subscriber interface {
+	// Subscribe consumes the subscription until the context is done
+	Subscribe(ctx context.Context, subscription Subscription, handler Handler) error
+	Close() error
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+
+	return e.err
+}
+
+// Permanent marks a handler error retrying won't fix, the message is rejected instead of requeued
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var permanent permanentError
+
+	return errors.As(err, &permanent)
+}
+
+type correlationKey struct{}
+
+// WithCorrelationID sets the correlation ID the envelopes created with the context carry
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+
+	return id
+}
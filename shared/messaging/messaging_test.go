@@ -0,0 +1,46 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type bidPlaced struct {
+	AuctionID string  `json:"auctionId"`
+	Amount    float64 `json:"amount"`
+}
+
+func TestNewEnvelope(t *testing.T) {
+	ctx := WithCorrelationID(context.Background(), "request-1")
+
+	envelope, err := NewEnvelope(ctx, "BidPlaced", 2, bidPlaced{AuctionID: "auction", Amount: 10})
+	assert.NoError(t, err)
+	assert.Len(t, envelope.ID, 32)
+	assert.Equal(t, "BidPlaced", envelope.Type)
+	assert.Equal(t, 2, envelope.Version)
+	assert.Equal(t, "request-1", envelope.CorrelationID)
+	assert.False(t, envelope.OccurredAt.IsZero())
+	assert.JSONEq(t, `{"auctionId":"auction","amount":10}`, string(envelope.Payload))
+
+	payload, err := Payload[bidPlaced](envelope)
+	assert.NoError(t, err)
+	assert.Equal(t, bidPlaced{AuctionID: "auction", Amount: 10}, payload)
+
+	other, _ := NewEnvelope(context.Background(), "BidPlaced", 1, nil)
+	assert.NotEqual(t, envelope.ID, other.ID)
+	assert.Empty(t, other.CorrelationID)
+}
+
+func TestPermanent(t *testing.T) {
+	cause := errors.New("unknown auction")
+	err := Permanent(cause)
+
+	assert.True(t, IsPermanent(err))
+	assert.True(t, IsPermanent(errors.Join(errors.New("handler"), err)))
+	assert.ErrorIs(t, err, cause)
+	assert.False(t, IsPermanent(cause))
+	assert.Nil(t, Permanent(nil))
+}
@@ -0,0 +1,177 @@
+// Package outbox implements the transactional outbox: a service writes its events to an outbox table in
+// the transaction of the change they describe, and a relay publishes them afterwards in the order they
+// were written. An event is only lost if its transaction was, and may be published more than once.
+//
+// Every service using it has an outbox table of this shape in its own database:
+//
+//	seq bigint unsigned auto_increment primary key, id varchar(64), aggregate_id varchar(36),
+//	topic varchar(255), type varchar(255), envelope json, attempts integer unsigned default 0,
+//	last_error text, created_at timestamp(6), sent_at timestamp(6) null
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ireuven89/auctions/shared/messaging"
+)
+
+// maxErrorLength keeps the last publish error readable in the table
+const maxErrorLength = 1024
+
+// Event is an envelope on its way to a topic, AggregateID is the entity it is about. Envelopes of the
+// events the services exchange come from events.NewEnvelope
+type Event struct {
+	AggregateID string
+	Topic       string
+	Envelope    messaging.Envelope
+}
+
+// Execer is a *sql.DB or, so the events are written with the change, the *sql.Tx of the change
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Insert writes the events to the outbox
+func Insert(ctx context.Context, exec Execer, events ...Event) error {
+	for _, event := range events {
+		body, err := json.Marshal(event.Envelope)
+
+		if err != nil {
+			return fmt.Errorf("outbox.Insert %w", err)
+		}
+
+		_, err = exec.ExecContext(ctx, "insert into outbox (id, aggregate_id, topic, type, envelope) values (?, ?, ?, ?, ?)",
+			event.Envelope.ID, event.AggregateID, event.Topic, event.Envelope.Type, body)
+
+		if err != nil {
+			return fmt.Errorf("outbox.Insert failed inserting %s %w", event.Envelope.Type, err)
+		}
+	}
+
+	return nil
+}
+
+// Record is an outbox row waiting to be published
+type Record struct {
+	Seq      int64
+	Topic    string
+	Envelope messaging.Envelope
+	Attempts int
+}
+
+// SQLStore reads the outbox table for the relay
+type SQLStore struct {
+	db *sql.DB
+}
+
+func NewSQLStore(db *sql.DB) *SQLStore {
+
+	return &SQLStore{db: db}
+}
+
+// Process hands up to limit pending records to publish in order and stops at the first that fails, so
+// none is published ahead of an earlier one. The records stay locked until it returns, relays running on
+// several instances take turns. It returns how many were published
+func (s *SQLStore) Process(ctx context.Context, limit int, publish func(ctx context.Context, record Record) error) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return 0, fmt.Errorf("SQLStore.Process %w", err)
+	}
+	defer tx.Rollback()
+
+	records, err := pending(ctx, tx, limit)
+
+	if err != nil {
+		return 0, fmt.Errorf("SQLStore.Process %w", err)
+	}
+
+	var sent []interface{}
+	var publishErr error
+
+	for _, record := range records {
+		if err = publish(ctx, record); err != nil {
+			publishErr = fmt.Errorf("failed publishing %s %s attempt %d %w", record.Envelope.Type, record.Envelope.ID, record.Attempts+1, err)
+
+			reason := err.Error()
+			if len(reason) > maxErrorLength {
+				reason = reason[:maxErrorLength]
+			}
+
+			if _, err = tx.ExecContext(ctx, "update outbox set attempts = attempts + 1, last_error = ? where seq = ?", reason, record.Seq); err != nil {
+				return 0, fmt.Errorf("SQLStore.Process failed recording the failure %w", err)
+			}
+			break
+		}
+
+		sent = append(sent, record.Seq)
+	}
+
+	if len(sent) > 0 {
+		q := fmt.Sprintf("update outbox set sent_at = current_timestamp(6), attempts = attempts + 1 where seq in (%s)", placeholders(len(sent)))
+
+		if _, err = tx.ExecContext(ctx, q, sent...); err != nil {
+			return 0, fmt.Errorf("SQLStore.Process failed marking sent %w", err)
+		}
+	}
+
+	// what was published is marked sent even when a later record failed
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("SQLStore.Process %w", err)
+	}
+
+	if publishErr != nil {
+		return len(sent), fmt.Errorf("SQLStore.Process %w", publishErr)
+	}
+
+	return len(sent), nil
+}
+
+// Purge deletes the events sent before the time, the outbox is not an archive of what the service published
+func (s *SQLStore) Purge(ctx context.Context, sentBefore time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "delete from outbox where sent_at < ?", sentBefore.UTC())
+
+	if err != nil {
+		return 0, fmt.Errorf("SQLStore.Purge %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+func pending(ctx context.Context, tx *sql.Tx, limit int) ([]Record, error) {
+	rows, err := tx.QueryContext(ctx, "select seq, topic, envelope, attempts from outbox where sent_at is null order by seq limit ? for update", limit)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+
+	for rows.Next() {
+		var record Record
+		var body []byte
+
+		if err = rows.Scan(&record.Seq, &record.Topic, &body, &record.Attempts); err != nil {
+			return nil, err
+		}
+
+		if err = json.Unmarshal(body, &record.Envelope); err != nil {
+			return nil, fmt.Errorf("malformed envelope %d %w", record.Seq, err)
+		}
+
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func placeholders(n int) string {
+
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
@@ -0,0 +1,130 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/ireuven89/auctions/shared/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsert(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	envelope, err := messaging.NewEnvelope(context.Background(), "UserRegistered", 1, map[string]string{"userId": "user-id"})
+	require.NoError(t, err)
+	event := Event{AggregateID: "user-id", Topic: "user.registered", Envelope: envelope}
+	body, _ := json.Marshal(event.Envelope)
+
+	mock.ExpectExec(regexp.QuoteMeta("insert into outbox (id, aggregate_id, topic, type, envelope) values (?, ?, ?, ?, ?)")).
+		WithArgs(event.Envelope.ID, "user-id", "user.registered", "UserRegistered", body).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	assert.NoError(t, Insert(context.Background(), db, event))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLStore_Process(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	envelope := func(id string) []byte {
+		body, _ := json.Marshal(messaging.Envelope{ID: id, Type: "BidPlaced"})
+		return body
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("select seq, topic, envelope, attempts from outbox where sent_at is null order by seq limit ? for update")).
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"seq", "topic", "envelope", "attempts"}).
+			AddRow(1, "auction.bid.placed", envelope("first"), 0).
+			AddRow(2, "auction.bid.placed", envelope("second"), 2).
+			AddRow(3, "auction.bid.placed", envelope("third"), 0))
+	mock.ExpectExec(regexp.QuoteMeta("update outbox set attempts = attempts + 1, last_error = ? where seq = ?")).
+		WithArgs("broker unavailable", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("update outbox set sent_at = current_timestamp(6), attempts = attempts + 1 where seq in (?)")).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// the third record waits for the second
+	var published []string
+	sent, err := NewSQLStore(db).Process(context.Background(), 10, func(ctx context.Context, record Record) error {
+		if record.Envelope.ID == "second" {
+			assert.Equal(t, 2, record.Attempts)
+			return errors.New("broker unavailable")
+		}
+		published = append(published, record.Envelope.ID)
+		return nil
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, []string{"first"}, published)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLStore_Purge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sentBefore := time.Now().Add(-DefaultRetention)
+
+	mock.ExpectExec(regexp.QuoteMeta("delete from outbox where sent_at < ?")).
+		WithArgs(sentBefore.UTC()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	purged, err := NewSQLStore(db).Purge(context.Background(), sentBefore)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+type memoryStore struct {
+	records []Record
+}
+
+func (s *memoryStore) Process(ctx context.Context, limit int, publish func(ctx context.Context, record Record) error) (int, error) {
+	sent := 0
+	for len(s.records) > 0 && sent < limit {
+		if err := publish(ctx, s.records[0]); err != nil {
+			return sent, err
+		}
+		s.records = s.records[1:]
+		sent++
+	}
+	return sent, nil
+}
+
+func (s *memoryStore) Purge(ctx context.Context, sentBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+func TestRelayOnce(t *testing.T) {
+	store := &memoryStore{records: []Record{
+		{Seq: 1, Topic: "auction.created", Envelope: messaging.Envelope{ID: "first"}},
+		{Seq: 2, Topic: "auction.closed", Envelope: messaging.Envelope{ID: "second"}},
+	}}
+	broker := messaging.NewMemoryBroker()
+	relay := NewRelay(store, broker)
+
+	sent, err := relay.RelayOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+
+	published := broker.Published()
+	assert.Len(t, published, 2)
+	assert.Equal(t, "auction.created", published[0].Topic)
+	assert.Equal(t, "second", published[1].ID)
+}
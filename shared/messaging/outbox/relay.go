@@ -0,0 +1,94 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ireuven89/auctions/shared/messaging"
+)
+
+const (
+	DefaultBatchSize    = 100
+	DefaultPollInterval = time.Second
+	// DefaultRetention is how long sent events stay in the outbox, for looking into what was published
+	DefaultRetention = 24 * time.Hour
+	maxBackoff       = time.Minute
+	purgeInterval    = time.Hour
+)
+
+// Store hands the pending records to the relay and forgets the sent ones, see SQLStore
+type Store interface {
+	Process(ctx context.Context, limit int, publish func(ctx context.Context, record Record) error) (int, error)
+	Purge(ctx context.Context, sentBefore time.Time) (int64, error)
+}
+
+// Relay publishes what the service wrote to its outbox
+type Relay struct {
+	store        Store
+	publisher    messaging.Publisher
+	batchSize    int
+	pollInterval time.Duration
+	retention    time.Duration
+}
+
+func NewRelay(store Store, publisher messaging.Publisher) *Relay {
+
+	return &Relay{
+		store:        store,
+		publisher:    publisher,
+		batchSize:    DefaultBatchSize,
+		pollInterval: DefaultPollInterval,
+		retention:    DefaultRetention,
+	}
+}
+
+// Run relays until the context is done, polling when the outbox is drained and backing off while publishing fails.
+// Every hour it purges the events sent longer than the retention ago
+func (r *Relay) Run(ctx context.Context) error {
+	backoff := r.pollInterval
+	var purgedAt time.Time
+
+	for {
+		if time.Since(purgedAt) >= purgeInterval {
+			if _, err := r.PurgeOnce(ctx); err != nil {
+				log.Printf("outbox: purge failed %v", err)
+			}
+			purgedAt = time.Now()
+		}
+
+		sent, err := r.RelayOnce(ctx)
+		wait := r.pollInterval
+
+		switch {
+		case err != nil:
+			log.Printf("outbox: relay failed, retrying in %s %v", backoff, err)
+			wait, backoff = backoff, min(backoff*2, maxBackoff)
+		case sent == r.batchSize:
+			// there is probably more waiting
+			wait, backoff = 0, r.pollInterval
+		default:
+			backoff = r.pollInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RelayOnce publishes a batch of pending events and returns how many were sent
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+
+	return r.store.Process(ctx, r.batchSize, func(ctx context.Context, record Record) error {
+		return r.publisher.Publish(ctx, record.Topic, record.Envelope)
+	})
+}
+
+// PurgeOnce deletes the events sent longer than the retention ago and returns how many
+func (r *Relay) PurgeOnce(ctx context.Context) (int64, error) {
+
+	return r.store.Purge(ctx, time.Now().Add(-r.retention))
+}
@@ -0,0 +1,97 @@
+package messaging
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// ProcessedStore remembers the messages handled on each queue, so a message delivered again is skipped
+type ProcessedStore interface {
+	// Once runs fn unless the message was processed on the queue before, and records it when fn succeeds.
+	// It reports whether fn ran
+	Once(ctx context.Context, queue, messageID string, fn func(ctx context.Context) error) (bool, error)
+}
+
+// SQLProcessedStore keeps the processed messages in a table of this shape in the database of the service:
+//
+//	queue varchar(255), message_id varchar(64), processed_at timestamp(6) default current_timestamp(6),
+//	primary key (queue, message_id)
+//
+// The row is inserted before the handler runs and committed after it succeeded, a concurrent delivery of
+// the same message waits on it and is skipped. The changes of the handler are committed on their own, a
+// crash between the two commits has the message handled again
+type SQLProcessedStore struct {
+	db *sql.DB
+}
+
+func NewSQLProcessedStore(db *sql.DB) *SQLProcessedStore {
+
+	return &SQLProcessedStore{db: db}
+}
+
+func (s *SQLProcessedStore) Once(ctx context.Context, queue, messageID string, fn func(ctx context.Context) error) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return false, fmt.Errorf("SQLProcessedStore.Once %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "insert ignore into processed_messages (queue, message_id) values (?, ?)", queue, messageID)
+
+	if err != nil {
+		return false, fmt.Errorf("SQLProcessedStore.Once %w", err)
+	}
+
+	inserted, err := res.RowsAffected()
+
+	if err != nil {
+		return false, fmt.Errorf("SQLProcessedStore.Once %w", err)
+	}
+
+	if inserted == 0 {
+		return false, nil
+	}
+
+	if err = fn(ctx); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return true, fmt.Errorf("SQLProcessedStore.Once %w", err)
+	}
+
+	return true, nil
+}
+
+// MemoryProcessedStore is a ProcessedStore for tests
+type MemoryProcessedStore struct {
+	mu        sync.Mutex
+	processed map[string]bool
+}
+
+func NewMemoryProcessedStore() *MemoryProcessedStore {
+
+	return &MemoryProcessedStore{processed: map[string]bool{}}
+}
+
+func (s *MemoryProcessedStore) Once(ctx context.Context, queue, messageID string, fn func(ctx context.Context) error) (bool, error) {
+	key := queue + "/" + messageID
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.processed[key] {
+		return false, nil
+	}
+
+	if err := fn(ctx); err != nil {
+		return false, err
+	}
+
+	s.processed[key] = true
+
+	return true, nil
+}
@@ -0,0 +1,52 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLProcessedStore_Once(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	store := NewSQLProcessedStore(db)
+	ctx := context.Background()
+	insert := regexp.QuoteMeta("insert ignore into processed_messages (queue, message_id) values (?, ?)")
+
+	// first delivery
+	mock.ExpectBegin()
+	mock.ExpectExec(insert).WithArgs("bidders", "id").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	ran := 0
+	handled, err := store.Once(ctx, "bidders", "id", func(ctx context.Context) error { ran++; return nil })
+	assert.NoError(t, err)
+	assert.True(t, handled)
+
+	// delivered again
+	mock.ExpectBegin()
+	mock.ExpectExec(insert).WithArgs("bidders", "id").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	handled, err = store.Once(ctx, "bidders", "id", func(ctx context.Context) error { ran++; return nil })
+	assert.NoError(t, err)
+	assert.False(t, handled)
+	assert.Equal(t, 1, ran)
+
+	// a failure is not recorded
+	mock.ExpectBegin()
+	mock.ExpectExec(insert).WithArgs("bidders", "other").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	handled, err = store.Once(ctx, "bidders", "other", func(ctx context.Context) error { return errors.New("temporary") })
+	assert.Error(t, err)
+	assert.False(t, handled)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}